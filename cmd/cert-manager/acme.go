@@ -0,0 +1,753 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+const (
+	// DefaultACMEAddr is where the ACME server listens, Pebble's port
+	DefaultACMEAddr = ":14000"
+
+	// DefaultACMEHostnames are the names the ACME server certificate covers
+	DefaultACMEHostnames = "localhost,127.0.0.1,cert_manager,host.docker.internal"
+
+	// acmeDirectoryPath is the directory URL path ACME clients start from
+	acmeDirectoryPath = "/dir"
+
+	// acmeLifetime is how long orders and authorizations stay usable
+	acmeLifetime = 7 * 24 * time.Hour
+
+	// maxACMERequestSize bounds request bodies; CSRs are the largest payloads
+	maxACMERequestSize = 64 << 10
+
+	// maxACMENonces bounds the outstanding nonces; when reached they are
+	// dropped and clients retry the badNonce errors with a fresh one
+	maxACMENonces = 10000
+
+	// acmeErrorPrefix prefixes the ACME problem types (RFC 8555 section 6.7)
+	acmeErrorPrefix = "urn:ietf:params:acme:error:"
+)
+
+// acmeChallengeTypes are offered for every authorization; any of them is
+// accepted without checking, as nothing is publicly reachable locally
+var acmeChallengeTypes = []string{"http-01", "dns-01", "tls-alpn-01"}
+
+// acmeIdentifier is an order identifier; only "dns" ones are supported.
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeAccount struct {
+	id         string
+	key        crypto.PublicKey
+	thumbprint string
+	contact    []string
+	orders     []string
+}
+
+type acmeOrder struct {
+	id          string
+	accountID   string
+	identifiers []acmeIdentifier
+	authzIDs    []string
+	expires     time.Time
+	certID      string
+}
+
+type acmeAuthz struct {
+	id           string
+	accountID    string
+	identifier   acmeIdentifier
+	wildcard     bool
+	expires      time.Time
+	challengeIDs []string
+}
+
+type acmeChallenge struct {
+	id        string
+	authzID   string
+	kind      string
+	token     string
+	validated time.Time
+}
+
+// acmeProblem is an ACME error response.
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func (p *acmeProblem) Error() string { return p.Detail }
+
+func problem(status int, kind, format string, args ...any) *acmeProblem {
+	return &acmeProblem{Type: acmeErrorPrefix + kind, Detail: fmt.Sprintf(format, args...), Status: status}
+}
+
+// acmeServer is a minimal RFC 8555 server in the spirit of Pebble: accounts,
+// orders and certificates live in memory, authorizations for names under the
+// configured domains are granted as soon as any challenge is answered, and
+// certificates are signed by the local CA. It lets projects run their real
+// ACME code paths against local hostnames.
+type acmeServer struct {
+	manager   *CertManager
+	hostnames []string
+
+	mu         sync.Mutex
+	nonces     map[string]bool
+	accounts   map[string]*acmeAccount
+	thumbprint map[string]string // account key thumbprint -> account ID
+	orders     map[string]*acmeOrder
+	authzs     map[string]*acmeAuthz
+	challenges map[string]*acmeChallenge
+	certs      map[string][]byte
+	serverCert *tls.Certificate
+}
+
+func newACMEServer(manager *CertManager, hostnames []string) *acmeServer {
+	return &acmeServer{
+		manager:    manager,
+		hostnames:  hostnames,
+		nonces:     make(map[string]bool),
+		accounts:   make(map[string]*acmeAccount),
+		thumbprint: make(map[string]string),
+		orders:     make(map[string]*acmeOrder),
+		authzs:     make(map[string]*acmeAuthz),
+		challenges: make(map[string]*acmeChallenge),
+		certs:      make(map[string][]byte),
+	}
+}
+
+// handler returns the HTTP handler of the ACME API.
+func (s *acmeServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+acmeDirectoryPath, s.handleDirectory)
+	mux.HandleFunc("HEAD /nonce-plz", s.handleNonce)
+	mux.HandleFunc("GET /nonce-plz", s.handleNonce)
+	mux.HandleFunc("POST /new-acct", s.post(s.handleNewAccount))
+	mux.HandleFunc("POST /acct/{id}", s.post(s.handleAccount))
+	mux.HandleFunc("POST /acct/{id}/orders", s.post(s.handleAccountOrders))
+	mux.HandleFunc("POST /new-order", s.post(s.handleNewOrder))
+	mux.HandleFunc("POST /order/{id}", s.post(s.handleOrder))
+	mux.HandleFunc("POST /authz/{id}", s.post(s.handleAuthz))
+	mux.HandleFunc("POST /chall/{id}", s.post(s.handleChallenge))
+	mux.HandleFunc("POST /finalize/{id}", s.post(s.handleFinalize))
+	mux.HandleFunc("POST /cert/{id}", s.post(s.handleCertificate))
+	mux.HandleFunc("POST /revoke-cert", s.post(s.handleRevoke))
+	mux.HandleFunc("POST /key-change", s.post(s.handleKeyChange))
+	return mux
+}
+
+// run serves the ACME API over TLS on addr until ctx is cancelled.
+func (s *acmeServer) run(ctx context.Context, addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+		TLSConfig:         &tls.Config{GetCertificate: s.certificate, MinVersion: tls.VersionTLS12},
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	s.manager.logger.Info("ACME server listening", "addr", addr, "directory", acmeDirectoryPath, "hostnames", strings.Join(s.hostnames, ","))
+	if err := server.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// certificate returns the certificate of the ACME server, issued by the local
+// CA on first use.
+func (s *acmeServer) certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	ca := s.manager.authority()
+	if ca == nil {
+		return nil, errors.New("local CA not loaded yet")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.serverCert != nil {
+		return s.serverCert, nil
+	}
+	certPEM, keyPEM, err := ca.issue(s.hostnames, s.manager.now())
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(append(certPEM, ca.certPEM...), keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	s.serverCert = &cert
+	return s.serverCert, nil
+}
+
+// baseURL returns the scheme and host the client used, which the URLs in
+// responses and the JWS "url" headers are built from.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func (s *acmeServer) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"newNonce":   base + "/nonce-plz",
+		"newAccount": base + "/new-acct",
+		"newOrder":   base + "/new-order",
+		"revokeCert": base + "/revoke-cert",
+		"keyChange":  base + "/key-change",
+		"meta": map[string]any{
+			"externalAccountRequired": false,
+		},
+	})
+}
+
+func (s *acmeServer) handleNonce(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodGet {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// newNonce returns a fresh anti-replay nonce.
+func (s *acmeServer) newNonce() string {
+	nonce := randomID()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.nonces) >= maxACMENonces {
+		s.nonces = make(map[string]bool)
+	}
+	s.nonces[nonce] = true
+	return nonce
+}
+
+// useNonce consumes a nonce, reporting whether it was outstanding.
+func (s *acmeServer) useNonce(nonce string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.nonces[nonce] {
+		return false
+	}
+	delete(s.nonces, nonce)
+	return true
+}
+
+// acmeRequest is an authenticated POST: the account is nil only for new
+// account requests, which are signed with the key in the header.
+type acmeRequest struct {
+	base       string
+	payload    []byte
+	key        crypto.PublicKey
+	thumbprint string
+	account    *acmeAccount
+}
+
+// postAsGet reports whether the request only fetches a resource.
+func (req *acmeRequest) postAsGet() bool {
+	return len(req.payload) == 0
+}
+
+// acmeHandler handles an authenticated request, returning the status, body
+// and Location of the response, or an error.
+type acmeHandler func(r *http.Request, req *acmeRequest, w http.ResponseWriter) (int, any, error)
+
+// post authenticates a JWS-signed request before handing it to h, and
+// renders its result; every response carries a fresh nonce.
+func (s *acmeServer) post(h acmeHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", s.newNonce())
+		w.Header().Set("Link", fmt.Sprintf("<%s%s>;rel=\"index\"", baseURL(r), acmeDirectoryPath))
+
+		req, err := s.authenticate(r)
+		if err == nil {
+			var status int
+			var body any
+			status, body, err = h(r, req, w)
+			if err == nil {
+				if data, ok := body.([]byte); ok {
+					w.Header().Set("Content-Type", "application/pem-certificate-chain")
+					w.WriteHeader(status)
+					w.Write(data)
+					return
+				}
+				writeJSON(w, status, body)
+				return
+			}
+		}
+
+		var p *acmeProblem
+		if !errors.As(err, &p) {
+			s.manager.logger.Error("ACME request failed", "path", r.URL.Path, "error", err)
+			p = problem(http.StatusInternalServerError, "serverInternal", "%v", err)
+		}
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(p.Status)
+		json.NewEncoder(w).Encode(p)
+	}
+}
+
+// authenticate verifies the JWS of a request: its nonce, URL and signature,
+// by the key in the header for new accounts and the account key otherwise.
+func (s *acmeServer) authenticate(r *http.Request) (*acmeRequest, error) {
+	if mediaType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";"); strings.TrimSpace(mediaType) != "application/jose+json" {
+		return nil, problem(http.StatusUnsupportedMediaType, "malformed", "content type must be application/jose+json")
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxACMERequestSize+1))
+	if err != nil || len(body) > maxACMERequestSize {
+		return nil, problem(http.StatusBadRequest, "malformed", "request body unreadable or too large")
+	}
+	msg, header, payload, err := parseJWS(body)
+	if err != nil {
+		return nil, problem(http.StatusBadRequest, "malformed", "%v", err)
+	}
+	if !s.useNonce(header.Nonce) {
+		return nil, problem(http.StatusBadRequest, "badNonce", "nonce is invalid or was already used")
+	}
+	req := &acmeRequest{base: baseURL(r), payload: payload}
+	if header.URL != req.base+r.URL.Path {
+		return nil, problem(http.StatusUnauthorized, "unauthorized", "JWS url %q does not match the request URL", header.URL)
+	}
+
+	newAccount := r.URL.Path == "/new-acct"
+	switch {
+	case newAccount && len(header.JWK) == 0:
+		return nil, problem(http.StatusBadRequest, "malformed", "new account requests must be signed with a jwk")
+	case !newAccount && header.KID == "":
+		return nil, problem(http.StatusBadRequest, "malformed", "requests must be signed with the account kid")
+	}
+
+	if newAccount {
+		req.key, req.thumbprint, err = parseJWK(header.JWK)
+		if err != nil {
+			return nil, problem(http.StatusBadRequest, "badPublicKey", "%v", err)
+		}
+	} else {
+		id, ok := strings.CutPrefix(header.KID, req.base+"/acct/")
+		s.mu.Lock()
+		req.account = s.accounts[id]
+		s.mu.Unlock()
+		if !ok || req.account == nil {
+			return nil, problem(http.StatusBadRequest, "accountDoesNotExist", "unknown account %q", header.KID)
+		}
+		req.key = req.account.key
+	}
+
+	if err := verifyJWS(msg, header.Alg, req.key); err != nil {
+		return nil, problem(http.StatusBadRequest, "malformed", "%v", err)
+	}
+	return req, nil
+}
+
+func (s *acmeServer) handleNewAccount(r *http.Request, req *acmeRequest, w http.ResponseWriter) (int, any, error) {
+	var payload struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		return 0, nil, problem(http.StatusBadRequest, "malformed", "invalid account request: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.thumbprint[req.thumbprint]; ok {
+		account := s.accounts[id]
+		w.Header().Set("Location", req.base+"/acct/"+id)
+		return http.StatusOK, s.renderAccount(req.base, account), nil
+	}
+	if payload.OnlyReturnExisting {
+		return 0, nil, problem(http.StatusBadRequest, "accountDoesNotExist", "no account exists for this key")
+	}
+
+	account := &acmeAccount{id: randomID(), key: req.key, thumbprint: req.thumbprint, contact: payload.Contact}
+	s.accounts[account.id] = account
+	s.thumbprint[account.thumbprint] = account.id
+	s.manager.logger.Info("ACME account created", "account", account.id, "contact", strings.Join(account.contact, ","))
+	w.Header().Set("Location", req.base+"/acct/"+account.id)
+	return http.StatusCreated, s.renderAccount(req.base, account), nil
+}
+
+func (s *acmeServer) handleAccount(r *http.Request, req *acmeRequest, w http.ResponseWriter) (int, any, error) {
+	if r.PathValue("id") != req.account.id {
+		return 0, nil, problem(http.StatusUnauthorized, "unauthorized", "account does not belong to the request key")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !req.postAsGet() {
+		var payload struct {
+			Contact []string `json:"contact"`
+			Status  string   `json:"status"`
+		}
+		if err := json.Unmarshal(req.payload, &payload); err != nil {
+			return 0, nil, problem(http.StatusBadRequest, "malformed", "invalid account update: %v", err)
+		}
+		if payload.Status == "deactivated" {
+			delete(s.accounts, req.account.id)
+			delete(s.thumbprint, req.account.thumbprint)
+			return http.StatusOK, map[string]any{"status": "deactivated"}, nil
+		}
+		if payload.Contact != nil {
+			req.account.contact = payload.Contact
+		}
+	}
+	return http.StatusOK, s.renderAccount(req.base, req.account), nil
+}
+
+func (s *acmeServer) handleAccountOrders(r *http.Request, req *acmeRequest, w http.ResponseWriter) (int, any, error) {
+	if r.PathValue("id") != req.account.id {
+		return 0, nil, problem(http.StatusUnauthorized, "unauthorized", "account does not belong to the request key")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	orders := []string{}
+	for _, id := range req.account.orders {
+		orders = append(orders, req.base+"/order/"+id)
+	}
+	return http.StatusOK, map[string]any{"orders": orders}, nil
+}
+
+func (s *acmeServer) handleNewOrder(r *http.Request, req *acmeRequest, w http.ResponseWriter) (int, any, error) {
+	var payload struct {
+		Identifiers []acmeIdentifier `json:"identifiers"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil || len(payload.Identifiers) == 0 {
+		return 0, nil, problem(http.StatusBadRequest, "malformed", "an order needs identifiers")
+	}
+
+	var identifiers []acmeIdentifier
+	for _, identifier := range payload.Identifiers {
+		name := utils.NormalizeHostname(identifier.Value)
+		if identifier.Type != "dns" {
+			return 0, nil, problem(http.StatusBadRequest, "unsupportedIdentifier", "identifier type %q is not supported", identifier.Type)
+		}
+		if !utils.ValidHostname(name) || !s.manager.managedName(name) {
+			return 0, nil, problem(http.StatusBadRequest, "rejectedIdentifier",
+				"%q is not under the configured domains %v", identifier.Value, s.manager.config.Domains)
+		}
+		if !slices.Contains(identifiers, acmeIdentifier{Type: "dns", Value: name}) {
+			identifiers = append(identifiers, acmeIdentifier{Type: "dns", Value: name})
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeExpiredOrders(s.manager.now())
+	expires := s.manager.now().Add(acmeLifetime)
+	order := &acmeOrder{id: randomID(), accountID: req.account.id, identifiers: identifiers, expires: expires}
+	for _, identifier := range identifiers {
+		authz := &acmeAuthz{id: randomID(), accountID: req.account.id, identifier: identifier, expires: expires}
+		if parent := wildcardParent(identifier.Value); parent != "" {
+			// Wildcard authorizations are for the parent name (RFC 8555
+			// section 7.1.3) and only take dns-01 challenges
+			authz.identifier.Value = parent
+			authz.wildcard = true
+		}
+		for _, kind := range acmeChallengeTypes {
+			if authz.wildcard && kind != "dns-01" {
+				continue
+			}
+			challenge := &acmeChallenge{id: randomID(), authzID: authz.id, kind: kind, token: randomID()}
+			s.challenges[challenge.id] = challenge
+			authz.challengeIDs = append(authz.challengeIDs, challenge.id)
+		}
+		s.authzs[authz.id] = authz
+		order.authzIDs = append(order.authzIDs, authz.id)
+	}
+	s.orders[order.id] = order
+	req.account.orders = append(req.account.orders, order.id)
+
+	w.Header().Set("Location", req.base+"/order/"+order.id)
+	return http.StatusCreated, s.renderOrder(req.base, order), nil
+}
+
+func (s *acmeServer) handleOrder(r *http.Request, req *acmeRequest, w http.ResponseWriter) (int, any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, err := s.ownedOrder(r.PathValue("id"), req.account)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, s.renderOrder(req.base, order), nil
+}
+
+func (s *acmeServer) handleAuthz(r *http.Request, req *acmeRequest, w http.ResponseWriter) (int, any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	authz := s.authzs[r.PathValue("id")]
+	if authz == nil || authz.accountID != req.account.id {
+		return 0, nil, problem(http.StatusNotFound, "malformed", "unknown authorization")
+	}
+	return http.StatusOK, s.renderAuthz(req.base, authz), nil
+}
+
+// handleChallenge accepts a challenge response without checking it: the
+// client's servers are not reachable from here, and the point is to run the
+// client's ACME flow, not to prove control of a local name.
+func (s *acmeServer) handleChallenge(r *http.Request, req *acmeRequest, w http.ResponseWriter) (int, any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	challenge := s.challenges[r.PathValue("id")]
+	if challenge == nil || s.authzs[challenge.authzID].accountID != req.account.id {
+		return 0, nil, problem(http.StatusNotFound, "malformed", "unknown challenge")
+	}
+	authz := s.authzs[challenge.authzID]
+	if !req.postAsGet() && s.authzStatus(authz) == "pending" {
+		challenge.validated = s.manager.now()
+		s.manager.logger.Info("ACME authorization granted", "identifier", authz.identifier.Value, "challenge", challenge.kind)
+	}
+	w.Header().Set("Link", fmt.Sprintf("<%s/authz/%s>;rel=\"up\"", req.base, authz.id))
+	return http.StatusOK, s.renderChallenge(req.base, challenge), nil
+}
+
+func (s *acmeServer) handleFinalize(r *http.Request, req *acmeRequest, w http.ResponseWriter) (int, any, error) {
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		return 0, nil, problem(http.StatusBadRequest, "malformed", "invalid finalize request: %v", err)
+	}
+	ca := s.manager.authority()
+	if ca == nil {
+		return 0, nil, errors.New("local CA not loaded yet")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, err := s.ownedOrder(r.PathValue("id"), req.account)
+	if err != nil {
+		return 0, nil, err
+	}
+	if status := s.orderStatus(order); status != "ready" {
+		return 0, nil, problem(http.StatusForbidden, "orderNotReady", "order is %s", status)
+	}
+
+	csr, err := parseCSR(payload.CSR)
+	if err != nil {
+		return 0, nil, problem(http.StatusBadRequest, "badCSR", "%v", err)
+	}
+	names := csrNames(csr)
+	var want []string
+	for _, identifier := range order.identifiers {
+		want = append(want, identifier.Value)
+	}
+	slices.Sort(want)
+	if !slices.Equal(names, want) {
+		return 0, nil, problem(http.StatusBadRequest, "badCSR", "CSR names %v do not match the order identifiers %v", names, want)
+	}
+
+	certPEM, err := ca.sign(csr.PublicKey, want, s.manager.now())
+	if err != nil {
+		return 0, nil, err
+	}
+	order.certID = randomID()
+	s.certs[order.certID] = append(certPEM, ca.certPEM...)
+	s.manager.logger.Info("ACME certificate issued", "names", strings.Join(want, ","), "account", req.account.id)
+
+	w.Header().Set("Location", req.base+"/order/"+order.id)
+	return http.StatusOK, s.renderOrder(req.base, order), nil
+}
+
+func (s *acmeServer) handleCertificate(r *http.Request, req *acmeRequest, w http.ResponseWriter) (int, any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, orderID := range req.account.orders {
+		if order := s.orders[orderID]; order != nil && order.certID == r.PathValue("id") {
+			return http.StatusOK, s.certs[order.certID], nil
+		}
+	}
+	return 0, nil, problem(http.StatusNotFound, "malformed", "unknown certificate")
+}
+
+// handleRevoke accepts revocations without recording them: nothing checks
+// the revocation status of local certificates.
+func (s *acmeServer) handleRevoke(r *http.Request, req *acmeRequest, w http.ResponseWriter) (int, any, error) {
+	return http.StatusOK, struct{}{}, nil
+}
+
+func (s *acmeServer) handleKeyChange(r *http.Request, req *acmeRequest, w http.ResponseWriter) (int, any, error) {
+	return 0, nil, problem(http.StatusNotImplemented, "malformed", "account key rollover is not supported")
+}
+
+// purgeExpiredOrders drops the expired orders with their authorizations,
+// challenges and certificates, so a long-running server does not keep every
+// order it ever created; s.mu must be held.
+func (s *acmeServer) purgeExpiredOrders(now time.Time) {
+	for id, order := range s.orders {
+		if !now.After(order.expires) {
+			continue
+		}
+		for _, authzID := range order.authzIDs {
+			if authz := s.authzs[authzID]; authz != nil {
+				for _, challengeID := range authz.challengeIDs {
+					delete(s.challenges, challengeID)
+				}
+			}
+			delete(s.authzs, authzID)
+		}
+		delete(s.certs, order.certID)
+		delete(s.orders, id)
+		if account := s.accounts[order.accountID]; account != nil {
+			account.orders = slices.DeleteFunc(account.orders, func(orderID string) bool { return orderID == id })
+		}
+	}
+}
+
+// ownedOrder returns an order of the account; s.mu must be held.
+func (s *acmeServer) ownedOrder(id string, account *acmeAccount) (*acmeOrder, error) {
+	order := s.orders[id]
+	if order == nil || order.accountID != account.id {
+		return nil, problem(http.StatusNotFound, "malformed", "unknown order")
+	}
+	return order, nil
+}
+
+// authzStatus returns the status of an authorization; s.mu must be held.
+func (s *acmeServer) authzStatus(authz *acmeAuthz) string {
+	for _, id := range authz.challengeIDs {
+		if !s.challenges[id].validated.IsZero() {
+			return "valid"
+		}
+	}
+	if s.manager.now().After(authz.expires) {
+		return "expired"
+	}
+	return "pending"
+}
+
+// orderStatus returns the status of an order; s.mu must be held.
+func (s *acmeServer) orderStatus(order *acmeOrder) string {
+	if order.certID != "" {
+		return "valid"
+	}
+	if s.manager.now().After(order.expires) {
+		return "invalid"
+	}
+	for _, id := range order.authzIDs {
+		if s.authzStatus(s.authzs[id]) != "valid" {
+			return "pending"
+		}
+	}
+	return "ready"
+}
+
+func (s *acmeServer) renderAccount(base string, account *acmeAccount) map[string]any {
+	return map[string]any{
+		"status":  "valid",
+		"contact": account.contact,
+		"orders":  base + "/acct/" + account.id + "/orders",
+	}
+}
+
+func (s *acmeServer) renderOrder(base string, order *acmeOrder) map[string]any {
+	var authorizations []string
+	for _, id := range order.authzIDs {
+		authorizations = append(authorizations, base+"/authz/"+id)
+	}
+	rendered := map[string]any{
+		"status":         s.orderStatus(order),
+		"expires":        order.expires.UTC().Format(time.RFC3339),
+		"identifiers":    order.identifiers,
+		"authorizations": authorizations,
+		"finalize":       base + "/finalize/" + order.id,
+	}
+	if order.certID != "" {
+		rendered["certificate"] = base + "/cert/" + order.certID
+	}
+	return rendered
+}
+
+func (s *acmeServer) renderAuthz(base string, authz *acmeAuthz) map[string]any {
+	var challenges []map[string]any
+	for _, id := range authz.challengeIDs {
+		challenges = append(challenges, s.renderChallenge(base, s.challenges[id]))
+	}
+	rendered := map[string]any{
+		"status":     s.authzStatus(authz),
+		"expires":    authz.expires.UTC().Format(time.RFC3339),
+		"identifier": authz.identifier,
+		"challenges": challenges,
+	}
+	if authz.wildcard {
+		rendered["wildcard"] = true
+	}
+	return rendered
+}
+
+func (s *acmeServer) renderChallenge(base string, challenge *acmeChallenge) map[string]any {
+	rendered := map[string]any{
+		"type":   challenge.kind,
+		"url":    base + "/chall/" + challenge.id,
+		"token":  challenge.token,
+		"status": "pending",
+	}
+	if !challenge.validated.IsZero() {
+		rendered["status"] = "valid"
+		rendered["validated"] = challenge.validated.UTC().Format(time.RFC3339)
+	}
+	return rendered
+}
+
+// parseCSR decodes and checks the signature of a base64url DER CSR.
+func parseCSR(encoded string) (*x509.CertificateRequest, error) {
+	der, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("invalid CSR encoding")
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSR: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid CSR signature: %w", err)
+	}
+	return csr, nil
+}
+
+// csrNames returns the sorted, lowercased names a CSR asks for: its DNS
+// names and its common name, which clients often repeat there.
+func csrNames(csr *x509.CertificateRequest) []string {
+	names := make([]string, 0, len(csr.DNSNames)+1)
+	for _, name := range append(csr.DNSNames, csr.Subject.CommonName) {
+		if name = utils.NormalizeHostname(name); name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// randomID returns a random URL-safe identifier.
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// writeJSON writes v as a JSON response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// acmeTestClient signs ACME requests with an ES256 account key.
+type acmeTestClient struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *ecdsa.PrivateKey
+	kid    string
+	nonce  string
+}
+
+func newACMETestClient(t *testing.T, server *httptest.Server) *acmeTestClient {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &acmeTestClient{t: t, server: server, key: key}
+}
+
+func b64(data []byte) string { return base64.RawURLEncoding.EncodeToString(data) }
+
+// post sends a signed request; payload nil means POST-as-GET.
+func (c *acmeTestClient) post(url string, payload any) (*http.Response, []byte) {
+	c.t.Helper()
+	if c.nonce == "" {
+		resp, err := http.Head(c.server.URL + "/nonce-plz")
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		c.nonce = resp.Header.Get("Replay-Nonce")
+	}
+
+	header := map[string]any{"alg": "ES256", "nonce": c.nonce, "url": url}
+	if c.kid != "" {
+		header["kid"] = c.kid
+	} else {
+		header["jwk"] = map[string]string{
+			"kty": "EC", "crv": "P-256",
+			"x": b64(c.key.X.FillBytes(make([]byte, 32))),
+			"y": b64(c.key.Y.FillBytes(make([]byte, 32))),
+		}
+	}
+	protected, _ := json.Marshal(header)
+	var body []byte
+	if payload != nil {
+		body, _ = json.Marshal(payload)
+	}
+	signed := b64(protected) + "." + b64(body)
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest("ES256", []byte(signed)))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	msg, _ := json.Marshal(jwsMessage{Protected: b64(protected), Payload: b64(body), Signature: b64(signature)})
+
+	resp, err := http.Post(url, "application/jose+json", bytes.NewReader(msg))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	c.nonce = resp.Header.Get("Replay-Nonce")
+	data, _ := io.ReadAll(resp.Body)
+	return resp, data
+}
+
+// decode posts and decodes a JSON response, failing on unexpected statuses.
+func (c *acmeTestClient) decode(url string, payload any, want int) (*http.Response, map[string]any) {
+	c.t.Helper()
+	resp, data := c.post(url, payload)
+	if resp.StatusCode != want {
+		c.t.Fatalf("POST %s = %d %s, want %d", url, resp.StatusCode, data, want)
+	}
+	var v map[string]any
+	json.Unmarshal(data, &v)
+	return resp, v
+}
+
+func TestACMEServerIssuesCertificate(t *testing.T) {
+	m := testManager(t, "loc")
+	server := httptest.NewServer(newACMEServer(m, []string{"localhost"}).handler())
+	defer server.Close()
+	c := newACMETestClient(t, server)
+
+	resp, _ := c.decode(server.URL+"/new-acct", map[string]any{"termsOfServiceAgreed": true}, http.StatusCreated)
+	c.kid = resp.Header.Get("Location")
+
+	// The same key finds the existing account
+	c.kid = ""
+	resp, _ = c.decode(server.URL+"/new-acct", map[string]any{"onlyReturnExisting": true}, http.StatusOK)
+	c.kid = resp.Header.Get("Location")
+
+	resp, order := c.decode(server.URL+"/new-order", map[string]any{
+		"identifiers": []map[string]string{{"type": "dns", "value": "shop.loc"}, {"type": "dns", "value": "*.shop.loc"}},
+	}, http.StatusCreated)
+	orderURL := resp.Header.Get("Location")
+	if order["status"] != "pending" {
+		t.Fatalf("order status = %v, want pending", order["status"])
+	}
+
+	// Finalizing before the authorizations are valid is refused
+	if resp, data := c.post(order["finalize"].(string), map[string]string{"csr": testCSR(t, "shop.loc", "*.shop.loc")}); !strings.Contains(string(data), "orderNotReady") {
+		t.Errorf("early finalize = %d %s, want orderNotReady", resp.StatusCode, data)
+	}
+
+	for _, url := range order["authorizations"].([]any) {
+		_, authz := c.decode(url.(string), nil, http.StatusOK)
+		challenge := authz["challenges"].([]any)[0].(map[string]any)
+		if authz["wildcard"] == true && challenge["type"] != "dns-01" {
+			t.Errorf("wildcard authorization offers %v", challenge["type"])
+		}
+		c.decode(challenge["url"].(string), map[string]any{}, http.StatusOK)
+		if _, authz := c.decode(url.(string), nil, http.StatusOK); authz["status"] != "valid" {
+			t.Errorf("authorization status = %v, want valid", authz["status"])
+		}
+	}
+	if _, order := c.decode(orderURL, nil, http.StatusOK); order["status"] != "ready" {
+		t.Fatalf("order status = %v, want ready", order["status"])
+	}
+
+	// A CSR for other names is rejected
+	if resp, data := c.post(order["finalize"].(string), map[string]string{"csr": testCSR(t, "shop.loc")}); resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(data), "badCSR") {
+		t.Errorf("mismatched CSR = %d %s, want badCSR", resp.StatusCode, data)
+	}
+
+	_, order = c.decode(order["finalize"].(string), map[string]string{"csr": testCSR(t, "shop.loc", "*.shop.loc")}, http.StatusOK)
+	if order["status"] != "valid" {
+		t.Fatalf("order status = %v, want valid", order["status"])
+	}
+	resp, chain := c.post(order["certificate"].(string), nil)
+	if resp.Header.Get("Content-Type") != "application/pem-certificate-chain" {
+		t.Errorf("certificate content type = %q", resp.Header.Get("Content-Type"))
+	}
+	if !m.ca.verify(chain, []string{"shop.loc", "api.shop.loc"}, m.now()) {
+		t.Error("issued certificate does not verify for its names")
+	}
+}
+
+func TestACMEServerRejects(t *testing.T) {
+	m := testManager(t, "loc")
+	server := httptest.NewServer(newACMEServer(m, []string{"localhost"}).handler())
+	defer server.Close()
+	c := newACMETestClient(t, server)
+
+	// Requests for an unknown account
+	c.kid = server.URL + "/acct/unknown"
+	if resp, data := c.post(server.URL+"/new-order", map[string]any{}); !strings.Contains(string(data), "accountDoesNotExist") {
+		t.Errorf("unknown account = %d %s", resp.StatusCode, data)
+	}
+
+	c.kid = ""
+	resp, _ := c.decode(server.URL+"/new-acct", map[string]any{"termsOfServiceAgreed": true}, http.StatusCreated)
+	c.kid = resp.Header.Get("Location")
+
+	// Names outside the configured domains
+	resp, data := c.post(server.URL+"/new-order", map[string]any{
+		"identifiers": []map[string]string{{"type": "dns", "value": "example.com"}},
+	})
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(data), "rejectedIdentifier") {
+		t.Errorf("foreign name = %d %s, want rejectedIdentifier", resp.StatusCode, data)
+	}
+
+	// Replayed nonces
+	nonce := c.nonce
+	c.post(server.URL+"/acct/x", nil)
+	c.nonce = nonce
+	if resp, data := c.post(server.URL+"/new-order", nil); !strings.Contains(string(data), "badNonce") {
+		t.Errorf("replayed nonce = %d %s, want badNonce", resp.StatusCode, data)
+	}
+
+	// Signed URLs that differ from the request URL
+	if resp, data := c.post(server.URL+"/new-order?x", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("mismatched url = %d %s, want 401", resp.StatusCode, data)
+	}
+
+	// Another account's key cannot read the order
+	_, order := c.decode(server.URL+"/new-order", map[string]any{
+		"identifiers": []map[string]string{{"type": "dns", "value": "web.loc"}},
+	}, http.StatusCreated)
+	other := newACMETestClient(t, server)
+	resp, _ = other.decode(server.URL+"/new-acct", map[string]any{"termsOfServiceAgreed": true}, http.StatusCreated)
+	other.kid = resp.Header.Get("Location")
+	if resp, _ := other.post(order["authorizations"].([]any)[0].(string), nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("foreign authorization = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestACMEServerPurgesExpiredOrders(t *testing.T) {
+	m := testManager(t, "loc")
+	acme := newACMEServer(m, []string{"localhost"})
+	server := httptest.NewServer(acme.handler())
+	defer server.Close()
+	c := newACMETestClient(t, server)
+
+	resp, _ := c.decode(server.URL+"/new-acct", map[string]any{"termsOfServiceAgreed": true}, http.StatusCreated)
+	c.kid = resp.Header.Get("Location")
+	newOrder := map[string]any{"identifiers": []map[string]string{{"type": "dns", "value": "shop.loc"}}}
+	resp, _ = c.decode(server.URL+"/new-order", newOrder, http.StatusCreated)
+	expiredURL := resp.Header.Get("Location")
+
+	// The next order after the first expired drops it and what it created
+	now := m.now().Add(acmeLifetime + time.Minute)
+	m.now = func() time.Time { return now }
+	c.decode(server.URL+"/new-order", newOrder, http.StatusCreated)
+
+	acme.mu.Lock()
+	orders, authzs, challenges := len(acme.orders), len(acme.authzs), len(acme.challenges)
+	acme.mu.Unlock()
+	if orders != 1 || authzs != 1 || challenges != len(acmeChallengeTypes) {
+		t.Errorf("kept %d orders, %d authorizations, %d challenges; want 1, 1, %d", orders, authzs, challenges, len(acmeChallengeTypes))
+	}
+	if resp, _ := c.post(expiredURL, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expired order = %d, want 404", resp.StatusCode)
+	}
+	if _, list := c.decode(strings.TrimSuffix(c.kid, "/")+"/orders", nil, http.StatusOK); len(list["orders"].([]any)) != 1 {
+		t.Errorf("account orders = %v, want only the new one", list["orders"])
+	}
+}
+
+func testCSR(t *testing.T, names ...string) string {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: names[0]},
+		DNSNames: names,
+	}, key)
+	if err != nil {
+		t.Fatal(fmt.Errorf("failed to create CSR: %w", err))
+	}
+	return b64(der)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// caCertFile and caKeyFile are named like mkcert's, so CAROOT=<ca dir>
+	// mkcert -install trusts the CA and an existing mkcert CA can be reused
+	caCertFile = "rootCA.pem"
+	caKeyFile  = "rootCA-key.pem"
+
+	// caValidity is how long a created CA is valid
+	caValidity = 10 * 365 * 24 * time.Hour
+
+	// leafValidity is how long issued certificates are valid, within the
+	// 825 days Apple platforms accept for TLS server certificates
+	leafValidity = 825 * 24 * time.Hour
+
+	// caOrganization names the CA and issued certificates in trust stores
+	caOrganization = "Spark HTTP Proxy development CA"
+)
+
+// authority is the local CA issuing the certificates.
+type authority struct {
+	cert    *x509.Certificate
+	key     crypto.Signer
+	certPEM []byte
+}
+
+// loadOrCreateCA loads the CA from dir, creating it when dir holds none.
+func loadOrCreateCA(dir string, now time.Time) (*authority, bool, error) {
+	certPEM, certErr := os.ReadFile(filepath.Join(dir, caCertFile))
+	keyPEM, keyErr := os.ReadFile(filepath.Join(dir, caKeyFile))
+	if errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist) {
+		ca, err := createCA(dir, now)
+		return ca, true, err
+	}
+	if certErr != nil {
+		return nil, false, fmt.Errorf("failed to read CA certificate: %w", certErr)
+	}
+	if keyErr != nil {
+		return nil, false, fmt.Errorf("failed to read CA key: %w", keyErr)
+	}
+
+	cert, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid CA certificate: %w", err)
+	}
+	key, err := parseKeyPEM(keyPEM)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid CA key: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, false, fmt.Errorf("%s is not a CA certificate", caCertFile)
+	}
+	return &authority{cert: cert, key: key, certPEM: certPEM}, false, nil
+}
+
+// createCA generates a CA and writes it to dir, the key readable by the
+// owner only.
+func createCA(dir string, now time.Time) (*authority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{caOrganization}, CommonName: caOrganization},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := encodeKeyPEM(key)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create CA directory: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, caKeyFile), keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, caCertFile), certPEM, 0644); err != nil {
+		return nil, err
+	}
+	return &authority{cert: cert, key: key, certPEM: certPEM}, nil
+}
+
+// issue creates a server certificate for names, which may include
+// wildcards and IP addresses, returning the certificate and key as PEM.
+func (ca *authority) issue(names []string, now time.Time) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	certPEM, err = ca.sign(key.Public(), names, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err = encodeKeyPEM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certPEM, keyPEM, nil
+}
+
+// sign creates a server certificate for names and the given public key,
+// returning it as PEM.
+func (ca *authority) sign(pub crypto.PublicKey, names []string, now time.Time) ([]byte, error) {
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{caOrganization}, CommonName: names[0]},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if _, ok := pub.(*rsa.PublicKey); ok {
+		// RSA key exchange in TLS 1.2 encrypts with the certificate key
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, pub, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate for %v: %w", names, err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// verify reports whether certPEM was issued by the CA, is valid at now and
+// covers every name.
+func (ca *authority) verify(certPEM []byte, names []string, now time.Time) bool {
+	cert, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		return false
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	for _, name := range names {
+		_, err := cert.Verify(x509.VerifyOptions{
+			DNSName:     name,
+			Roots:       roots,
+			CurrentTime: now,
+			KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// randomSerial returns a random 128-bit certificate serial number.
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serial, nil
+}
+
+// parseCertificatePEM parses the first certificate of a PEM file.
+func parseCertificatePEM(data []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// parseKeyPEM parses a PKCS#8, PKCS#1 or EC private key, as written by
+// mkcert and openssl.
+func parseKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no key found")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, errors.New("unsupported key type")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported key format")
+}
+
+// encodeKeyPEM encodes a private key as PKCS#8 PEM.
+func encodeKeyPEM(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// writeFileAtomic writes data to a temporary file renamed over path, so
+// readers never see a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tempFile := path + ".tmp"
+	if err := os.WriteFile(tempFile, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	if err := os.Rename(tempFile, path); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to rename %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
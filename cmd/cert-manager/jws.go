@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// jwsMessage is an ACME request body: a JWS in flattened JSON serialization
+// (RFC 8555 section 6.2).
+type jwsMessage struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// jwsHeader is the protected header of an ACME request. Exactly one of JWK
+// (new accounts) and KID (the account URL) is set.
+type jwsHeader struct {
+	Alg   string          `json:"alg"`
+	Nonce string          `json:"nonce"`
+	URL   string          `json:"url"`
+	JWK   json.RawMessage `json:"jwk,omitempty"`
+	KID   string          `json:"kid,omitempty"`
+}
+
+// jsonWebKey is the subset of RFC 7517 ACME clients use for account keys.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// parseJWS decodes a request body into its protected header and payload,
+// leaving the signature to verifyJWS once the key is known.
+func parseJWS(body []byte) (*jwsMessage, *jwsHeader, []byte, error) {
+	var msg jwsMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, nil, nil, fmt.Errorf("request is not a flattened JWS: %w", err)
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(msg.Protected)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid protected header encoding: %w", err)
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid protected header: %w", err)
+	}
+	if (len(header.JWK) == 0) == (header.KID == "") {
+		return nil, nil, nil, errors.New("protected header must have exactly one of jwk and kid")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(msg.Payload)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid payload encoding: %w", err)
+	}
+	return &msg, &header, payload, nil
+}
+
+// verifyJWS checks the signature of msg with key under the header algorithm.
+func verifyJWS(msg *jwsMessage, alg string, key crypto.PublicKey) error {
+	signature, err := base64.RawURLEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	signed := []byte(msg.Protected + "." + msg.Payload)
+
+	switch alg {
+	case "ES256", "ES384":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s does not match the account key", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if (alg == "ES256") != (pub.Curve == elliptic.P256()) || len(signature) != 2*size {
+			return fmt.Errorf("invalid %s signature", alg)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest(alg, signed), r, s) {
+			return errors.New("signature verification failed")
+		}
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s does not match the account key", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest(alg, signed), signature); err != nil {
+			return errors.New("signature verification failed")
+		}
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	return nil
+}
+
+// digest hashes data for a JWS algorithm.
+func digest(alg string, data []byte) []byte {
+	if alg == "ES384" {
+		sum := sha512.Sum384(data)
+		return sum[:]
+	}
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// parseJWK decodes an account key and returns it with its RFC 7638
+// thumbprint, which identifies the account.
+func parseJWK(data []byte) (crypto.PublicKey, string, error) {
+	var jwk jsonWebKey
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, "", fmt.Errorf("invalid jwk: %w", err)
+	}
+
+	var key crypto.PublicKey
+	var canonical string
+	switch jwk.Kty {
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, "", fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+		y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if errX != nil || errY != nil {
+			return nil, "", errors.New("invalid EC key coordinates")
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, "", errors.New("EC key is not on its curve")
+		}
+		key = pub
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, jwk.Crv, jwk.X, jwk.Y)
+	case "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil, "", errors.New("invalid RSA key")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < 2048 {
+			return nil, "", errors.New("RSA keys must have at least 2048 bits")
+		}
+		key = pub
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	default:
+		return nil, "", fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+	return key, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
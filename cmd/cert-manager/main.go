@@ -0,0 +1,445 @@
+// Package main implements the cert-manager service: a local CA that issues
+// certificates for the configured domains and the hostnames of running
+// containers, and hands them to Traefik through the dynamic directory, so
+// HTTPS works without generating certificates by hand.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+	"github.com/sparkfabrik/http-proxy/pkg/service"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultDataDir holds the issued certificates
+	DefaultDataDir = "/var/lib/cert-manager"
+
+	// DefaultTraefikDynamicDir is where the TLS configuration is written
+	DefaultTraefikDynamicDir = "/traefik/dynamic"
+
+	// DefaultCertsDir holds the user certificates Traefik already serves
+	DefaultCertsDir = "/traefik/certs"
+
+	// tlsConfigFile is the dynamic config file listing the issued certificates
+	tlsConfigFile = "cert-manager.yaml"
+
+	// tlsConfigHeader heads the generated dynamic config file
+	tlsConfigHeader = "# Generated by cert-manager from the running containers; do not edit.\n"
+
+	// DefaultRenewDays is how many days before expiry certificates are renewed
+	DefaultRenewDays = 30
+
+	// DefaultCheckInterval is how often certificate expiry is checked
+	DefaultCheckInterval = time.Hour
+
+	// caMetricLabel labels the CA in the expiry gauge
+	caMetricLabel = "rootCA"
+)
+
+// CertManagerConfig holds the cert-manager settings. Certificates are kept
+// in DataDir/certs and the CA in CADir. Domains get wildcard certificates
+// when they have two labels or more; hostnames already covered by the
+// certificates in CertsDir are skipped. Every CheckInterval the issued
+// certificates expiring within RenewBefore are renewed.
+type CertManagerConfig struct {
+	LogLevel          string
+	DataDir           string
+	CADir             string
+	TraefikDynamicDir string
+	CertsDir          string
+	Domains           []string
+	RenewBefore       time.Duration
+	CheckInterval     time.Duration
+	MetricsAddr       string
+	ACMEEnabled       bool
+	ACMEAddr          string
+	ACMEHostnames     []string
+}
+
+// Validate checks the configuration.
+func (c *CertManagerConfig) Validate() error {
+	if err := utils.ValidateLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if c.DataDir == "" || c.CADir == "" {
+		return errors.New("data and CA directories must be set")
+	}
+	if c.TraefikDynamicDir == "" {
+		return errors.New("traefik dynamic directory must be set")
+	}
+	if c.RenewBefore < 0 || c.RenewBefore >= leafValidity {
+		return fmt.Errorf("renewal window must be between 0 and %d days", int(leafValidity.Hours()/24))
+	}
+	if c.CheckInterval < 0 {
+		return errors.New("check interval cannot be negative")
+	}
+	if c.ACMEEnabled {
+		if c.ACMEAddr == "" {
+			return errors.New("ACME server address must be set")
+		}
+		if len(c.ACMEHostnames) == 0 {
+			return errors.New("ACME server hostnames must be set")
+		}
+	}
+	return nil
+}
+
+// CertManager implements service.EventHandler: it tracks the hostnames of
+// running containers and keeps a certificate issued for each of them.
+type CertManager struct {
+	dockerClient  *client.Client
+	dockerTimeout time.Duration
+	logger        *logger.Logger
+	config        *CertManagerConfig
+	now           func() time.Time
+	metrics       *metrics.Registry
+	expiry        *metrics.Vec
+
+	// mu serializes the event loop and the expiry checks
+	mu         sync.Mutex
+	ca         *authority
+	containers map[string][]string // container ID -> hostnames
+	issued     map[string]bool     // certificate file names in the expiry gauge
+}
+
+// NewCertManager creates a cert manager; the CA is loaded or created by the
+// initial scan.
+func NewCertManager(cfg *CertManagerConfig) *CertManager {
+	registry := metrics.NewRegistry()
+	return &CertManager{
+		config:     cfg,
+		now:        time.Now,
+		metrics:    registry,
+		expiry:     registry.Gauge("http_proxy_cert_expiry_timestamp_seconds", "Expiry time of the issued certificates and of the local CA, as a Unix timestamp.", "certificate"),
+		containers: make(map[string][]string),
+		issued:     make(map[string]bool),
+	}
+}
+
+// GetName returns the service name
+func (m *CertManager) GetName() string {
+	return "cert-manager"
+}
+
+// SetDockerTimeout sets the timeout of each Docker API call from the service
+// framework
+func (m *CertManager) SetDockerTimeout(timeout time.Duration) {
+	m.dockerTimeout = timeout
+}
+
+// SetDependencies sets the Docker client and logger from the service framework
+func (m *CertManager) SetDependencies(dockerClient *client.Client, logger *logger.Logger) {
+	m.dockerClient = dockerClient
+	m.logger = logger
+}
+
+// HandleInitialScan loads the CA and issues the certificates of the running
+// containers.
+func (m *CertManager) HandleInitialScan(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ca == nil {
+		ca, created, err := loadOrCreateCA(m.config.CADir, m.now())
+		if err != nil {
+			return err
+		}
+		m.ca = ca
+		if created {
+			m.logger.Info("Created local CA; trust it to avoid browser warnings",
+				"ca_cert", filepath.Join(m.config.CADir, caCertFile))
+		} else {
+			m.logger.Info("Loaded local CA", "ca_cert", filepath.Join(m.config.CADir, caCertFile))
+		}
+		m.expiry.Set(float64(ca.cert.NotAfter.Unix()), caMetricLabel)
+	}
+
+	containers, err := utils.RetryContainerList(ctx, m.dockerClient, m.dockerTimeout, container.ListOptions{})
+	if err != nil {
+		return err
+	}
+	m.containers = make(map[string][]string)
+	for _, ctr := range containers {
+		if err := m.register(ctx, ctr.ID); err != nil {
+			m.logger.Warn("Failed to read container hostnames", "container_id", utils.FormatDockerID(ctr.ID), "error", err)
+		}
+	}
+	return m.sync()
+}
+
+// HandleEvent issues certificates for started containers and drops the
+// hostnames of stopped ones from the TLS configuration.
+func (m *CertManager) HandleEvent(ctx context.Context, event events.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ev := service.ParseContainerEvent(event)
+	switch ev.Action {
+	case "start":
+		if err := m.register(ctx, ev.ContainerID); err != nil {
+			return err
+		}
+	case "die":
+		if _, ok := m.containers[ev.ContainerID]; !ok {
+			return nil
+		}
+		delete(m.containers, ev.ContainerID)
+	default:
+		m.logger.Debug("Unhandled container action", ev.LogArgs()...)
+		return nil
+	}
+	return m.sync()
+}
+
+// RunBackground runs the ACME server when enabled and checks the
+// certificates every CheckInterval, renewing the ones about to expire.
+func (m *CertManager) RunBackground(ctx context.Context) {
+	if m.config.ACMEEnabled {
+		go func() {
+			if err := newACMEServer(m, m.config.ACMEHostnames).run(ctx, m.config.ACMEAddr); err != nil {
+				m.logger.Error("ACME server stopped", "error", err)
+			}
+		}()
+	}
+
+	if m.config.CheckInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.config.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.checkExpiry(); err != nil {
+				m.logger.Error("Failed to check certificate expiry", "error", err)
+			}
+		}
+	}
+}
+
+// checkExpiry warns about an expiring CA and syncs the certificates, which
+// renews the ones within the renewal window.
+func (m *CertManager) checkExpiry() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ca == nil {
+		return nil // not loaded until the initial scan
+	}
+	if remaining := m.ca.cert.NotAfter.Sub(m.now()); remaining < m.config.RenewBefore {
+		m.logger.Warn("Local CA expires soon; remove it to create a new one and trust that instead",
+			"ca_cert", filepath.Join(m.config.CADir, caCertFile), "expires", m.ca.cert.NotAfter)
+	}
+	return m.sync()
+}
+
+// authority returns the local CA, or nil before the initial scan loads it.
+func (m *CertManager) authority() *authority {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ca
+}
+
+// managedName reports whether a name, possibly a wildcard, is one of the
+// configured domains or below one.
+func (m *CertManager) managedName(name string) bool {
+	name = strings.TrimPrefix(name, "*.")
+	for _, domain := range m.config.Domains {
+		domain = utils.NormalizeHostname(domain)
+		if domain != "" && (name == domain || strings.HasSuffix(name, "."+domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// register inspects a container and records its hostnames.
+func (m *CertManager) register(ctx context.Context, containerID string) error {
+	inspect, err := utils.RetryContainerInspect(ctx, m.dockerClient, m.dockerTimeout, containerID)
+	if err != nil {
+		return err
+	}
+	if inspect.State == nil || !inspect.State.Running || inspect.Config == nil {
+		return nil
+	}
+	hostnames := utils.ContainerHostnames(utils.GetDockerEnvVar(inspect.Config.Env, "VIRTUAL_HOST"), inspect.Config.Labels)
+	if len(hostnames) > 0 {
+		m.containers[containerID] = hostnames
+	}
+	return nil
+}
+
+// hostnames returns the sorted hostnames of the running containers.
+func (m *CertManager) hostnames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, hostnames := range m.containers {
+		for _, hostname := range hostnames {
+			if !seen[hostname] {
+				seen[hostname] = true
+				names = append(names, hostname)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sync issues the missing certificates and rewrites the TLS configuration
+// when the set of certificates changed.
+func (m *CertManager) sync() error {
+	plan := certificatePlan(m.config.Domains, m.hostnames(), userCertificateNames(m.config.CertsDir))
+
+	tlsConfig := &config.TLSConfig{Certificates: []config.TLSCertificate{}}
+	issued := make(map[string]bool)
+	for _, names := range plan {
+		certPEM, keyPEM, notAfter, err := m.certificate(names)
+		if err != nil {
+			m.logger.Error("Failed to issue certificate", "names", names, "error", err)
+			continue
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, config.TLSCertificate{
+			CertFile: string(certPEM),
+			KeyFile:  string(keyPEM),
+		})
+		name := certificateFileName(names)
+		issued[name] = true
+		m.expiry.Set(float64(notAfter.Unix()), name)
+	}
+	for name := range m.issued {
+		if !issued[name] {
+			m.expiry.Delete(name)
+		}
+	}
+	m.issued = issued
+	return m.writeTLSConfig(&config.TraefikConfig{TLS: tlsConfig})
+}
+
+// certificate returns the certificate, key and expiry for names, reusing
+// the stored ones while they are valid past the renewal window and issuing
+// new ones otherwise.
+func (m *CertManager) certificate(names []string) (certPEM, keyPEM []byte, notAfter time.Time, err error) {
+	dir := filepath.Join(m.config.DataDir, "certs")
+	base := filepath.Join(dir, certificateFileName(names))
+	now := m.now()
+
+	certPEM, certErr := os.ReadFile(base + ".pem")
+	keyPEM, keyErr := os.ReadFile(base + "-key.pem")
+	if certErr == nil && keyErr == nil && m.ca.verify(certPEM, names, now) {
+		cert, _ := parseCertificatePEM(certPEM) // parsed by verify
+		if cert.NotAfter.Sub(now) >= m.config.RenewBefore {
+			m.logger.Debug("Certificate valid", "names", strings.Join(names, ","), "expires", cert.NotAfter)
+			return certPEM, keyPEM, cert.NotAfter, nil
+		}
+		m.logger.Info("Renewing certificate", "names", strings.Join(names, ","), "expires", cert.NotAfter)
+	}
+
+	certPEM, keyPEM, err = m.ca.issue(names, now)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, time.Time{}, fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+	if err := writeFileAtomic(base+"-key.pem", keyPEM, 0600); err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	if err := writeFileAtomic(base+".pem", certPEM, 0644); err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	notAfter = now.Add(leafValidity)
+	m.logger.Info("Issued certificate", "names", strings.Join(names, ","), "expires", notAfter)
+	return certPEM, keyPEM, notAfter, nil
+}
+
+// writeTLSConfig writes the TLS configuration to the dynamic directory
+// unless it is unchanged, embedding the certificates so Traefik needs no
+// access to the data directory.
+func (m *CertManager) writeTLSConfig(cfg *config.TraefikConfig) error {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal TLS config: %w", err)
+	}
+	data = append([]byte(tlsConfigHeader), data...)
+
+	path := filepath.Join(m.config.TraefikDynamicDir, tlsConfigFile)
+	if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+	if err := os.MkdirAll(m.config.TraefikDynamicDir, 0755); err != nil {
+		return fmt.Errorf("failed to create Traefik dynamic directory: %w", err)
+	}
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return err
+	}
+	m.logger.Info("Updated TLS configuration", "config_file", path, "certificates", len(cfg.TLS.Certificates))
+	return nil
+}
+
+func main() {
+	ctx := context.Background()
+
+	dataDir := config.GetEnvOrDefault("HTTP_PROXY_CERT_MANAGER_DIR", DefaultDataDir)
+	renewDays, err := strconv.Atoi(config.GetEnvOrDefault("HTTP_PROXY_CERT_RENEW_DAYS", strconv.Itoa(DefaultRenewDays)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: invalid HTTP_PROXY_CERT_RENEW_DAYS: %v\n", err)
+		os.Exit(1)
+	}
+	checkInterval, err := time.ParseDuration(config.GetEnvOrDefault("HTTP_PROXY_CERT_CHECK_INTERVAL", DefaultCheckInterval.String()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: invalid HTTP_PROXY_CERT_CHECK_INTERVAL: %v\n", err)
+		os.Exit(1)
+	}
+	cfg := &CertManagerConfig{
+		LogLevel:          config.GetEnvOrDefault("LOG_LEVEL", "info"),
+		DataDir:           dataDir,
+		CADir:             config.GetEnvOrDefault("HTTP_PROXY_CERT_MANAGER_CA_DIR", filepath.Join(dataDir, "ca")),
+		TraefikDynamicDir: config.GetEnvOrDefault("TRAEFIK_DYNAMIC_DIR", DefaultTraefikDynamicDir),
+		CertsDir:          config.GetEnvOrDefault("HTTP_PROXY_CERTS_DIR", DefaultCertsDir),
+		Domains:           config.Load().Domains,
+		RenewBefore:       time.Duration(renewDays) * 24 * time.Hour,
+		CheckInterval:     checkInterval,
+		MetricsAddr:       config.GetEnvOrDefault("HTTP_PROXY_CERT_METRICS_ADDR", ":9155"),
+		ACMEEnabled:       config.GetEnvOrDefault("HTTP_PROXY_ACME_ENABLED", "false") == "true",
+		ACMEAddr:          config.GetEnvOrDefault("HTTP_PROXY_ACME_ADDR", DefaultACMEAddr),
+		ACMEHostnames:     config.GetEnvOrDefaultStringSlice("HTTP_PROXY_ACME_HOSTNAMES", strings.Split(DefaultACMEHostnames, ",")),
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	handler := NewCertManager(cfg)
+	if cfg.MetricsAddr != "" {
+		go func() {
+			if err := metrics.Serve(ctx, cfg.MetricsAddr, handler.metrics.ServeMux()); err != nil {
+				fmt.Fprintf(os.Stderr, "Metrics endpoint stopped: %v\n", err)
+			}
+		}()
+	}
+	if err := service.RunWithSignalHandling(ctx, handler.GetName(), cfg.LogLevel, handler); err != nil {
+		fmt.Fprintf(os.Stderr, "Service failed: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+// testManager returns a cert manager with its CA loaded, writing to
+// temporary directories.
+func testManager(t *testing.T, domains ...string) *CertManager {
+	t.Helper()
+	dir := t.TempDir()
+	m := NewCertManager(&CertManagerConfig{
+		LogLevel:          "info",
+		DataDir:           filepath.Join(dir, "data"),
+		CADir:             filepath.Join(dir, "ca"),
+		TraefikDynamicDir: filepath.Join(dir, "dynamic"),
+		CertsDir:          filepath.Join(dir, "certs"),
+		Domains:           domains,
+	})
+	m.logger = logger.New("test")
+	m.now = func() time.Time { return time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	ca, created, err := loadOrCreateCA(m.config.CADir, m.now())
+	if err != nil || !created {
+		t.Fatalf("loadOrCreateCA() = %v, %v", created, err)
+	}
+	m.ca = ca
+	return m
+}
+
+func TestLoadOrCreateCA(t *testing.T) {
+	m := testManager(t)
+
+	info, err := os.Stat(filepath.Join(m.config.CADir, caKeyFile))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("CA key mode = %v, %v, want 0600", info, err)
+	}
+
+	loaded, created, err := loadOrCreateCA(m.config.CADir, m.now())
+	if err != nil || created {
+		t.Fatalf("second loadOrCreateCA() = %v, %v, want the existing CA", created, err)
+	}
+	if !loaded.cert.Equal(m.ca.cert) {
+		t.Error("loaded CA differs from the created one")
+	}
+
+	os.Remove(filepath.Join(m.config.CADir, caKeyFile))
+	if _, _, err := loadOrCreateCA(m.config.CADir, m.now()); err == nil {
+		t.Error("loadOrCreateCA() accepted a CA without its key")
+	}
+}
+
+func TestIssueAndVerify(t *testing.T) {
+	m := testManager(t)
+	names := []string{"*.spark.dev", "spark.dev"}
+
+	certPEM, _, err := m.ca.issue(names, m.now())
+	if err != nil {
+		t.Fatalf("issue() error = %v", err)
+	}
+	if !m.ca.verify(certPEM, names, m.now()) {
+		t.Error("verify() rejected an issued certificate")
+	}
+	if !m.ca.verify(certPEM, []string{"api.spark.dev"}, m.now()) {
+		t.Error("wildcard certificate does not cover a host below it")
+	}
+	if m.ca.verify(certPEM, []string{"other.loc"}, m.now()) {
+		t.Error("verify() accepted a name the certificate does not cover")
+	}
+	if m.ca.verify(certPEM, names, m.now().Add(leafValidity+time.Hour)) {
+		t.Error("verify() accepted an expired certificate")
+	}
+
+	other := testManager(t)
+	if other.ca.verify(certPEM, names, m.now()) {
+		t.Error("verify() accepted a certificate of another CA")
+	}
+}
+
+func TestSyncWritesTLSConfig(t *testing.T) {
+	m := testManager(t, "loc", "spark.dev")
+	m.containers["abc"] = []string{"web.loc", "api.spark.dev"}
+
+	if err := m.sync(); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	path := filepath.Join(m.config.TraefikDynamicDir, tlsConfigFile)
+	cfg, err := config.LoadTraefikConfigFile(path)
+	if err != nil {
+		t.Fatalf("failed to load TLS config: %v", err)
+	}
+	if cfg.TLS == nil || len(cfg.TLS.Certificates) != 2 {
+		t.Fatalf("TLS config = %+v, want 2 certificates", cfg.TLS)
+	}
+	if !m.ca.verify([]byte(cfg.TLS.Certificates[1].CertFile), []string{"web.loc"}, m.now()) {
+		t.Error("second certificate does not cover web.loc")
+	}
+	if _, err := parseKeyPEM([]byte(cfg.TLS.Certificates[1].KeyFile)); err != nil {
+		t.Errorf("embedded key is invalid: %v", err)
+	}
+
+	// Unchanged certificates are reused and the file is not rewritten
+	before, _ := os.Stat(path)
+	if err := m.sync(); err != nil {
+		t.Fatalf("second sync() error = %v", err)
+	}
+	after, _ := os.Stat(path)
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Error("TLS config rewritten without changes")
+	}
+
+	// Stopped containers drop out of the configuration
+	delete(m.containers, "abc")
+	if err := m.sync(); err != nil {
+		t.Fatalf("third sync() error = %v", err)
+	}
+	cfg, _ = config.LoadTraefikConfigFile(path)
+	if cfg.TLS == nil || len(cfg.TLS.Certificates) != 1 {
+		t.Errorf("TLS config = %+v, want only the domain wildcard", cfg.TLS)
+	}
+}
+
+func TestSyncSkipsUserCertificates(t *testing.T) {
+	m := testManager(t, "loc")
+	m.containers["abc"] = []string{"web.loc", "api.loc"}
+
+	// A user certificate (e.g. from mkcert) for web.loc is already served
+	userCert, userKey, err := m.ca.issue([]string{"web.loc"}, m.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(m.config.CertsDir, 0755)
+	os.WriteFile(filepath.Join(m.config.CertsDir, "web.loc.pem"), userCert, 0644)
+	os.WriteFile(filepath.Join(m.config.CertsDir, "web.loc-key.pem"), userKey, 0600)
+
+	if err := m.sync(); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	cfg, err := config.LoadTraefikConfigFile(filepath.Join(m.config.TraefikDynamicDir, tlsConfigFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.TLS.Certificates) != 1 || !m.ca.verify([]byte(cfg.TLS.Certificates[0].CertFile), []string{"api.loc"}, m.now()) {
+		t.Errorf("TLS config = %+v, want only api.loc", cfg.TLS)
+	}
+}
+
+func TestCheckExpiryRenewsCertificates(t *testing.T) {
+	m := testManager(t, "spark.dev")
+	m.config.RenewBefore = 30 * 24 * time.Hour
+	if err := m.checkExpiry(); err != nil {
+		t.Fatalf("checkExpiry() error = %v", err)
+	}
+	certPath := filepath.Join(m.config.DataDir, "certs", "_wildcard.spark.dev.pem")
+	first, _ := os.ReadFile(certPath)
+	issuedAt := m.now()
+	if got := m.expiry.Value("_wildcard.spark.dev"); got != float64(issuedAt.Add(leafValidity).Unix()) {
+		t.Errorf("expiry gauge = %v, want the certificate expiry", got)
+	}
+
+	// Outside the renewal window the certificate is kept
+	m.now = func() time.Time { return issuedAt.Add(leafValidity - 31*24*time.Hour) }
+	m.checkExpiry()
+	if current, _ := os.ReadFile(certPath); string(current) != string(first) {
+		t.Error("certificate renewed outside the renewal window")
+	}
+
+	// Within it the certificate and the TLS config are renewed
+	m.now = func() time.Time { return issuedAt.Add(leafValidity - 29*24*time.Hour) }
+	m.checkExpiry()
+	renewed, _ := os.ReadFile(certPath)
+	if string(renewed) == string(first) {
+		t.Fatal("certificate not renewed within the renewal window")
+	}
+	cfg, err := config.LoadTraefikConfigFile(filepath.Join(m.config.TraefikDynamicDir, tlsConfigFile))
+	if err != nil || cfg.TLS.Certificates[0].CertFile != string(renewed) {
+		t.Errorf("TLS config not rewritten with the renewed certificate: %v", err)
+	}
+	if got := m.expiry.Value("_wildcard.spark.dev"); got != float64(m.now().Add(leafValidity).Unix()) {
+		t.Errorf("expiry gauge = %v, want the renewed expiry", got)
+	}
+
+	// Certificates no longer issued leave the gauge
+	m.config.Domains = nil
+	m.checkExpiry()
+	if got := m.expiry.Value("_wildcard.spark.dev"); got != 0 {
+		t.Errorf("expiry gauge = %v for a dropped certificate, want no series", got)
+	}
+}
+
+func TestCertManagerConfigValidate(t *testing.T) {
+	valid := CertManagerConfig{LogLevel: "info", DataDir: "/data", CADir: "/ca", TraefikDynamicDir: "/dynamic", RenewBefore: 30 * 24 * time.Hour}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	for name, mutate := range map[string]func(*CertManagerConfig){
+		"negative window":   func(c *CertManagerConfig) { c.RenewBefore = -time.Hour },
+		"window too long":   func(c *CertManagerConfig) { c.RenewBefore = leafValidity },
+		"negative interval": func(c *CertManagerConfig) { c.CheckInterval = -time.Minute },
+		"no dynamic dir":    func(c *CertManagerConfig) { c.TraefikDynamicDir = "" },
+	} {
+		cfg := valid
+		mutate(&cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate() accepted a config with %s", name)
+		}
+	}
+}
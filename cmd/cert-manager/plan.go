@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// wildcardParent returns the domain a "*.<domain>" name covers, or "".
+func wildcardParent(name string) string {
+	parent, ok := strings.CutPrefix(name, "*.")
+	if !ok {
+		return ""
+	}
+	return parent
+}
+
+// covers reports whether a certificate for pattern is valid for name: the
+// same name, or a name one label below a wildcard's domain.
+func covers(pattern, name string) bool {
+	if pattern == name {
+		return true
+	}
+	parent := wildcardParent(pattern)
+	if parent == "" || strings.HasPrefix(name, "*.") {
+		return false
+	}
+	_, rest, ok := strings.Cut(name, ".")
+	return ok && rest == parent
+}
+
+// certificatePlan returns the names of each certificate to issue for the
+// configured domains and the container hostnames, sorted by first name.
+// Domains of two labels or more get a wildcard certificate; a wildcard for a
+// single-label TLD (*.loc) is rejected by browsers, so hostnames under it get
+// their own certificates. Hostnames covered by a planned wildcard or by one
+// of the existing certificates in skip are left out.
+func certificatePlan(domains, hostnames, skip []string) [][]string {
+	var plan [][]string
+	var patterns []string
+	covered := func(name string) bool {
+		for _, pattern := range patterns {
+			if covers(pattern, name) {
+				return true
+			}
+		}
+		for _, pattern := range skip {
+			if covers(pattern, name) {
+				return true
+			}
+		}
+		return false
+	}
+	add := func(names ...string) {
+		patterns = append(patterns, names...)
+		plan = append(plan, names)
+	}
+
+	for _, domain := range domains {
+		domain = utils.NormalizeHostname(domain)
+		if !strings.Contains(domain, ".") || !utils.ValidHostname(domain) || covered("*."+domain) {
+			continue
+		}
+		add("*."+domain, domain)
+	}
+
+	// Wildcards first, so exact hostnames below them are not issued twice
+	sorted := append([]string(nil), hostnames...)
+	sort.Slice(sorted, func(i, j int) bool {
+		wi, wj := wildcardParent(sorted[i]) != "", wildcardParent(sorted[j]) != ""
+		if wi != wj {
+			return wi
+		}
+		return sorted[i] < sorted[j]
+	})
+	for _, hostname := range sorted {
+		if covered(hostname) {
+			continue
+		}
+		if parent := wildcardParent(hostname); parent != "" {
+			add(hostname, parent)
+			continue
+		}
+		add(hostname)
+	}
+
+	sort.Slice(plan, func(i, j int) bool { return plan[i][0] < plan[j][0] })
+	return plan
+}
+
+// certificateFileName returns the base file name of a certificate, after
+// its first name as mkcert does ("_wildcard.spark.dev" for "*.spark.dev").
+func certificateFileName(names []string) string {
+	return strings.ReplaceAll(names[0], "*", "_wildcard")
+}
+
+// userCertificateNames returns the names covered by the certificates in dir
+// that have a key, which Traefik already serves, so they are not shadowed
+// by issued ones.
+func userCertificateNames(dir string) []string {
+	var names []string
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		if entry.IsDir() || (ext != ".pem" && ext != ".crt") || strings.Contains(name, "-key") {
+			continue
+		}
+		if !hasKeyFile(dir, strings.TrimSuffix(name, ext)) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		cert, err := parseCertificatePEM(data)
+		if err != nil || cert.IsCA {
+			continue
+		}
+		for _, dnsName := range cert.DNSNames {
+			names = append(names, utils.NormalizeHostname(dnsName))
+		}
+	}
+	return names
+}
+
+// hasKeyFile reports whether dir holds the key of the certificate named
+// base, under the names the Traefik entrypoint looks for.
+func hasKeyFile(dir, base string) bool {
+	for _, name := range []string{base + "-key.pem", base + "-key.crt", base + "-key.key", base + ".key"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
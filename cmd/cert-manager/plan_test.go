@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCovers(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"app.loc", "app.loc", true},
+		{"*.spark.dev", "api.spark.dev", true},
+		{"*.spark.dev", "spark.dev", false},
+		{"*.spark.dev", "a.b.spark.dev", false},
+		{"*.spark.dev", "*.x.spark.dev", false},
+		{"app.loc", "api.app.loc", false},
+	}
+	for _, tt := range tests {
+		if got := covers(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("covers(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCertificatePlan(t *testing.T) {
+	tests := []struct {
+		name      string
+		domains   []string
+		hostnames []string
+		skip      []string
+		want      [][]string
+	}{
+		{
+			name:      "single-label TLD gets per-host certificates",
+			domains:   []string{"loc"},
+			hostnames: []string{"web.loc", "api.loc"},
+			want:      [][]string{{"api.loc"}, {"web.loc"}},
+		},
+		{
+			name:      "multi-label domain gets a wildcard covering its hosts",
+			domains:   []string{"loc", "spark.dev"},
+			hostnames: []string{"web.spark.dev", "a.b.spark.dev", "web.loc"},
+			want:      [][]string{{"*.spark.dev", "spark.dev"}, {"a.b.spark.dev"}, {"web.loc"}},
+		},
+		{
+			name:      "wildcard hosts cover the hosts below them",
+			domains:   []string{"loc"},
+			hostnames: []string{"web.app.loc", "*.app.loc"},
+			want:      [][]string{{"*.app.loc", "app.loc"}},
+		},
+		{
+			name:      "hosts of user certificates are skipped",
+			domains:   []string{"loc", "spark.dev"},
+			hostnames: []string{"web.loc", "api.loc"},
+			skip:      []string{"*.spark.dev", "web.loc"},
+			want:      [][]string{{"api.loc"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := certificatePlan(tt.domains, tt.hostnames, tt.skip)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("certificatePlan() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertificateFileName(t *testing.T) {
+	if got := certificateFileName([]string{"*.spark.dev", "spark.dev"}); got != "_wildcard.spark.dev" {
+		t.Errorf("certificateFileName() = %q", got)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Resolver backends configure-dns can write
+const (
+	backendResolver        = "resolver"
+	backendSystemdResolved = "systemd-resolved"
+	backendNetworkManager  = "networkmanager"
+)
+
+// managedHeader starts every file configure-dns writes, so it only ever
+// replaces or removes its own files.
+const managedHeader = "# Managed by spark-http-proxy configure-dns\n"
+
+// settings describe how the host should reach the proxy's DNS server. Root
+// prefixes every path; it is "/" outside tests.
+type settings struct {
+	TLDs []string
+	IP   string
+	Port string
+	Root string
+}
+
+// managedFile is a file configure-dns writes, with its path below Root.
+type managedFile struct {
+	Path    string
+	Content string
+}
+
+// plan returns the files a backend needs and the command that makes the
+// resolver pick them up (nil when none is needed).
+func plan(backend string, s settings) ([]managedFile, []string, error) {
+	switch backend {
+	case backendResolver:
+		// One file per domain; mDNSResponder rereads /etc/resolver on its own,
+		// the HUP only drops answers cached before the change
+		var files []managedFile
+		for _, tld := range s.TLDs {
+			files = append(files, managedFile{
+				Path:    filepath.Join("/etc/resolver", tld),
+				Content: fmt.Sprintf("%snameserver %s\nport %s\n", managedHeader, s.IP, s.Port),
+			})
+		}
+		return files, []string{"killall", "-HUP", "mDNSResponder"}, nil
+
+	case backendSystemdResolved:
+		domains := make([]string, len(s.TLDs))
+		for i, tld := range s.TLDs {
+			domains[i] = "~" + tld
+		}
+		return []managedFile{{
+			Path:    "/etc/systemd/resolved.conf.d/http-proxy.conf",
+			Content: fmt.Sprintf("%s[Resolve]\nDNS=%s:%s\nDomains=%s\n", managedHeader, s.IP, s.Port, strings.Join(domains, " ")),
+		}}, []string{"systemctl", "restart", "systemd-resolved"}, nil
+
+	case backendNetworkManager:
+		var b strings.Builder
+		b.WriteString(managedHeader)
+		for _, tld := range s.TLDs {
+			fmt.Fprintf(&b, "server=/%s/%s#%s\n", tld, s.IP, s.Port)
+		}
+		return []managedFile{{
+			Path:    "/etc/NetworkManager/dnsmasq.d/http-proxy.conf",
+			Content: b.String(),
+		}}, []string{"systemctl", "restart", "NetworkManager"}, nil
+
+	default:
+		return nil, nil, fmt.Errorf("unknown backend %q, must be %s, %s or %s", backend, backendResolver, backendSystemdResolved, backendNetworkManager)
+	}
+}
+
+// detectBackend picks the backend for the host: /etc/resolver on macOS; on
+// Linux the dnsmasq instance of NetworkManager when it runs one, else
+// systemd-resolved.
+func detectBackend(goos, root string) (string, error) {
+	switch goos {
+	case "darwin":
+		return backendResolver, nil
+	case "linux":
+		if networkManagerUsesDnsmasq(root) {
+			return backendNetworkManager, nil
+		}
+		if _, err := os.Stat(filepath.Join(root, "/run/systemd/resolve")); err == nil {
+			return backendSystemdResolved, nil
+		}
+		return "", errors.New("no supported resolver found: expected systemd-resolved or NetworkManager with dns=dnsmasq")
+	default:
+		return "", fmt.Errorf("unsupported operating system %q", goos)
+	}
+}
+
+// networkManagerUsesDnsmasq reports whether a NetworkManager config file sets
+// dns=dnsmasq.
+func networkManagerUsesDnsmasq(root string) bool {
+	paths := []string{filepath.Join(root, "/etc/NetworkManager/NetworkManager.conf")}
+	dropIns, _ := filepath.Glob(filepath.Join(root, "/etc/NetworkManager/conf.d/*.conf"))
+	paths = append(paths, dropIns...)
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			key, value, ok := strings.Cut(scanner.Text(), "=")
+			if ok && strings.TrimSpace(key) == "dns" && strings.TrimSpace(value) == "dnsmasq" {
+				f.Close()
+				return true
+			}
+		}
+		f.Close()
+	}
+	return false
+}
+
+// isManaged reports whether existing content was written by configure-dns:
+// it carries the header, or it is exactly what the shell implementation
+// wrote before it (the wanted content without the header).
+func isManaged(existing, want string) bool {
+	return strings.HasPrefix(existing, managedHeader) || existing == strings.TrimPrefix(want, managedHeader)
+}
+
+// apply writes the files that are missing or out of date and reports whether
+// any changed. Files configure-dns did not write are only replaced with force.
+func apply(files []managedFile, root string, force bool, out io.Writer) (bool, error) {
+	changed := false
+	for _, f := range files {
+		path := filepath.Join(root, f.Path)
+		existing, err := os.ReadFile(path)
+		switch {
+		case err == nil && string(existing) == f.Content:
+			fmt.Fprintf(out, "%s is up to date\n", f.Path)
+			continue
+		case err == nil && !force && !isManaged(string(existing), f.Content):
+			return changed, fmt.Errorf("%s was not written by configure-dns; remove it or pass -force", f.Path)
+		case err != nil && !errors.Is(err, os.ErrNotExist):
+			return changed, fmt.Errorf("failed to read %s: %w", f.Path, err)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return changed, fmt.Errorf("failed to create %s: %w", filepath.Dir(f.Path), err)
+		}
+		if err := os.WriteFile(path, []byte(f.Content), 0o644); err != nil {
+			return changed, fmt.Errorf("failed to write %s: %w", f.Path, err)
+		}
+		fmt.Fprintf(out, "Wrote %s\n", f.Path)
+		changed = true
+	}
+	return changed, nil
+}
+
+// revert removes the files written by configure-dns and reports whether any
+// was removed. Files it did not write are left in place.
+func revert(files []managedFile, root string, out io.Writer) (bool, error) {
+	changed := false
+	for _, f := range files {
+		path := filepath.Join(root, f.Path)
+		existing, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return changed, fmt.Errorf("failed to read %s: %w", f.Path, err)
+		}
+		if !isManaged(string(existing), f.Content) {
+			fmt.Fprintf(out, "Leaving %s in place: not written by configure-dns\n", f.Path)
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			return changed, fmt.Errorf("failed to remove %s: %w", f.Path, err)
+		}
+		fmt.Fprintf(out, "Removed %s\n", f.Path)
+		changed = true
+	}
+	return changed, nil
+}
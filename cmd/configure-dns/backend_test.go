@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	s := settings{TLDs: []string{"loc", "test"}, IP: "127.0.0.1", Port: "19322"}
+	tests := []struct {
+		backend string
+		want    []managedFile
+	}{
+		{backendResolver, []managedFile{
+			{"/etc/resolver/loc", managedHeader + "nameserver 127.0.0.1\nport 19322\n"},
+			{"/etc/resolver/test", managedHeader + "nameserver 127.0.0.1\nport 19322\n"},
+		}},
+		{backendSystemdResolved, []managedFile{
+			{"/etc/systemd/resolved.conf.d/http-proxy.conf", managedHeader + "[Resolve]\nDNS=127.0.0.1:19322\nDomains=~loc ~test\n"},
+		}},
+		{backendNetworkManager, []managedFile{
+			{"/etc/NetworkManager/dnsmasq.d/http-proxy.conf", managedHeader + "server=/loc/127.0.0.1#19322\nserver=/test/127.0.0.1#19322\n"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.backend, func(t *testing.T) {
+			files, reload, err := plan(tt.backend, s)
+			if err != nil {
+				t.Fatalf("plan() error = %v", err)
+			}
+			if len(files) != len(tt.want) {
+				t.Fatalf("plan() = %d files, want %d", len(files), len(tt.want))
+			}
+			for i := range files {
+				if files[i] != tt.want[i] {
+					t.Errorf("file %d = %+v, want %+v", i, files[i], tt.want[i])
+				}
+			}
+			if len(reload) == 0 {
+				t.Error("plan() returned no reload command")
+			}
+		})
+	}
+
+	if _, _, err := plan("hosts", s); err == nil {
+		t.Error("plan() accepted an unknown backend")
+	}
+}
+
+func writeTestFile(t *testing.T, root, path, content string) {
+	t.Helper()
+	full := filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDetectBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		goos    string
+		files   map[string]string
+		want    string
+		wantErr bool
+	}{
+		{name: "macos", goos: "darwin", want: backendResolver},
+		{name: "systemd-resolved", goos: "linux", files: map[string]string{"/run/systemd/resolve/stub-resolv.conf": ""}, want: backendSystemdResolved},
+		{
+			name: "networkmanager dnsmasq",
+			goos: "linux",
+			files: map[string]string{
+				"/run/systemd/resolve/stub-resolv.conf":      "",
+				"/etc/NetworkManager/conf.d/00-dnsmasq.conf": "[main]\ndns = dnsmasq\n",
+			},
+			want: backendNetworkManager,
+		},
+		{name: "no resolver", goos: "linux", files: map[string]string{"/etc/NetworkManager/NetworkManager.conf": "[main]\ndns=default\n"}, wantErr: true},
+		{name: "windows", goos: "windows", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for path, content := range tt.files {
+				writeTestFile(t, root, path, content)
+			}
+			got, err := detectBackend(tt.goos, root)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("detectBackend() = %q, %v, want %q (error %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyAndRevert(t *testing.T) {
+	root := t.TempDir()
+	files, _, _ := plan(backendResolver, settings{TLDs: []string{"loc", "test"}, IP: "127.0.0.1", Port: "19322"})
+
+	changed, err := apply(files, root, false, io.Discard)
+	if err != nil || !changed {
+		t.Fatalf("apply() = %v, %v, want a change", changed, err)
+	}
+	if changed, err := apply(files, root, false, io.Discard); err != nil || changed {
+		t.Errorf("second apply() = %v, %v, want no change", changed, err)
+	}
+
+	// A file written by someone else survives a revert
+	writeTestFile(t, root, "/etc/resolver/test", "nameserver 10.0.0.1\n")
+	changed, err = revert(files, root, io.Discard)
+	if err != nil || !changed {
+		t.Fatalf("revert() = %v, %v, want a change", changed, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "/etc/resolver/loc")); !os.IsNotExist(err) {
+		t.Error("managed file kept by revert()")
+	}
+	if _, err := os.Stat(filepath.Join(root, "/etc/resolver/test")); err != nil {
+		t.Error("unmanaged file removed by revert()")
+	}
+}
+
+func TestApplyRefusesUnmanagedFiles(t *testing.T) {
+	root := t.TempDir()
+	files, _, _ := plan(backendResolver, settings{TLDs: []string{"loc"}, IP: "127.0.0.1", Port: "19322"})
+	writeTestFile(t, root, "/etc/resolver/loc", "nameserver 10.0.0.1\n")
+
+	if _, err := apply(files, root, false, io.Discard); err == nil || !strings.Contains(err.Error(), "-force") {
+		t.Errorf("apply() error = %v, want a refusal suggesting -force", err)
+	}
+	if _, err := apply(files, root, true, io.Discard); err != nil {
+		t.Errorf("apply() with force error = %v", err)
+	}
+}
+
+func TestApplyAdoptsShellConfiguration(t *testing.T) {
+	root := t.TempDir()
+	files, _, _ := plan(backendResolver, settings{TLDs: []string{"loc"}, IP: "127.0.0.1", Port: "19322"})
+	writeTestFile(t, root, "/etc/resolver/loc", "nameserver 127.0.0.1\nport 19322\n")
+
+	if changed, err := apply(files, root, false, io.Discard); err != nil || !changed {
+		t.Errorf("apply() = %v, %v, want the shell-written file replaced", changed, err)
+	}
+}
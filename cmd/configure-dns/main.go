@@ -0,0 +1,133 @@
+// Package main implements configure-dns, which points the host resolver at
+// the proxy's DNS server for the proxy domains: /etc/resolver files on macOS,
+// a systemd-resolved drop-in or a NetworkManager dnsmasq snippet on Linux.
+// It verifies resolution end to end and can revert its changes.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+)
+
+// tldPattern matches the domains accepted in file names and resolver config
+var tldPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+func main() {
+	tlds := flag.String("tlds", config.GetEnvOrDefault("HTTP_PROXY_DNS_TLDS", "loc"), "comma-separated domains resolved by the proxy")
+	ip := flag.String("ip", "127.0.0.1", "address the proxy DNS server is reachable at from the host")
+	port := flag.String("port", config.GetEnvOrDefault("HTTP_PROXY_DNS_PORT", "19322"), "port the proxy DNS server is reachable at from the host")
+	backend := flag.String("backend", "auto", "resolver to configure (auto, resolver, systemd-resolved, networkmanager)")
+	revertFlag := flag.Bool("revert", false, "remove the configuration written by configure-dns")
+	dryRun := flag.Bool("dry-run", false, "print the files that would be written without touching anything")
+	force := flag.Bool("force", false, "replace files configure-dns did not write")
+	verifyFlag := flag.Bool("verify", true, "check that the host resolves the domains through the proxy")
+	flag.Parse()
+
+	s := settings{IP: *ip, Port: *port, Root: "/"}
+	var err error
+	if s.TLDs, err = parseTLDs(*tlds); err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration error: %v\n", err)
+		os.Exit(2)
+	}
+	if net.ParseIP(s.IP) == nil {
+		fmt.Fprintf(os.Stderr, "Configuration error: invalid IP address %q\n", s.IP)
+		os.Exit(2)
+	}
+
+	if *backend == "auto" {
+		if *backend, err = detectBackend(runtime.GOOS, s.Root); err != nil {
+			fmt.Fprintf(os.Stderr, "Detection failed: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	files, reload, err := plan(*backend, s)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration error: %v\n", err)
+		os.Exit(2)
+	}
+
+	if *dryRun {
+		writePlan(os.Stdout, *backend, files, reload, *revertFlag)
+		return
+	}
+	if os.Geteuid() != 0 {
+		fmt.Fprintln(os.Stderr, "configure-dns writes system files and must run as root (use sudo)")
+		os.Exit(1)
+	}
+
+	changed := false
+	if *revertFlag {
+		changed, err = revert(files, s.Root, os.Stdout)
+	} else {
+		changed, err = apply(files, s.Root, *force, os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	if changed && reload != nil {
+		fmt.Printf("Running %s\n", strings.Join(reload, " "))
+		if output, err := exec.Command(reload[0], reload[1:]...).CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to reload the resolver: %v: %s\n", err, strings.TrimSpace(string(output)))
+			os.Exit(1)
+		}
+	}
+
+	if *revertFlag || !*verifyFlag {
+		return
+	}
+	server := serverLookup(net.JoinHostPort(s.IP, s.Port))
+	if err := verify(context.Background(), s.TLDs, server, hostLookup, verifyTimeout); err != nil {
+		fmt.Fprintf(os.Stderr, "Verification failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Verified: .%s resolve through the proxy (%s)\n", strings.Join(s.TLDs, ", ."), *backend)
+}
+
+// parseTLDs splits a comma-separated domain list, dropping leading dots and
+// duplicates. Domains end up in file names, so only DNS characters pass.
+func parseTLDs(value string) ([]string, error) {
+	seen := make(map[string]bool)
+	var tlds []string
+	for _, item := range strings.Split(value, ",") {
+		tld := strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(item)), "."), ".")
+		if tld == "" || seen[tld] {
+			continue
+		}
+		if !tldPattern.MatchString(tld) {
+			return nil, fmt.Errorf("invalid domain %q", item)
+		}
+		seen[tld] = true
+		tlds = append(tlds, tld)
+	}
+	if len(tlds) == 0 {
+		return nil, fmt.Errorf("no domains configured")
+	}
+	return tlds, nil
+}
+
+// writePlan prints what a run would write (or remove) and reload.
+func writePlan(out io.Writer, backend string, files []managedFile, reload []string, reverting bool) {
+	fmt.Fprintf(out, "Backend: %s\n", backend)
+	for _, f := range files {
+		if reverting {
+			fmt.Fprintf(out, "\nWould remove %s\n", f.Path)
+			continue
+		}
+		fmt.Fprintf(out, "\n%s:\n%s", f.Path, f.Content)
+	}
+	if reload != nil {
+		fmt.Fprintf(out, "\nThen, if anything changed: %s\n", strings.Join(reload, " "))
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// verifyTimeout caps how long the host resolver may take to pick up the
+	// new configuration
+	verifyTimeout = 10 * time.Second
+
+	// verifyInterval is the delay between host lookups
+	verifyInterval = 500 * time.Millisecond
+
+	// checkLabel is the label resolved under each domain to verify it; the
+	// proxy DNS server answers every name of its domains
+	checkLabel = "configure-dns-check"
+)
+
+// lookupFunc resolves a name to its addresses.
+type lookupFunc func(ctx context.Context, name string) ([]string, error)
+
+// verify checks, for every domain, that the proxy DNS server answers a name
+// under it and that the host resolver returns the same answer, retrying the
+// host lookup while the resolver picks up its new configuration.
+func verify(ctx context.Context, tlds []string, server, host lookupFunc, timeout time.Duration) error {
+	for _, tld := range tlds {
+		name := checkLabel + "." + tld
+
+		want, err := server(ctx, name)
+		if err != nil {
+			return fmt.Errorf("proxy DNS server did not answer %s (is the proxy running?): %w", name, err)
+		}
+
+		deadline := time.Now().Add(timeout)
+		for {
+			got, err := host(ctx, name)
+			if err == nil && sameAddresses(got, want) {
+				break
+			}
+			if time.Now().After(deadline) {
+				if err != nil {
+					return fmt.Errorf("host resolver does not resolve %s through the proxy: %w", name, err)
+				}
+				return fmt.Errorf("host resolver does not resolve %s through the proxy: got %v, want %v", name, got, want)
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(verifyInterval):
+			}
+		}
+	}
+	return nil
+}
+
+// sameAddresses reports whether got holds every address of want.
+func sameAddresses(got, want []string) bool {
+	for _, addr := range want {
+		if !slices.Contains(got, addr) {
+			return false
+		}
+	}
+	return len(want) > 0
+}
+
+// serverLookup asks the proxy DNS server at addr for the A records of a name.
+func serverLookup(addr string) lookupFunc {
+	return func(ctx context.Context, name string) ([]string, error) {
+		msg := new(dns.Msg)
+		msg.SetQuestion(dns.Fqdn(name), dns.TypeA)
+
+		client := dns.Client{Timeout: 2 * time.Second}
+		resp, _, err := client.ExchangeContext(ctx, msg, addr)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Rcode != dns.RcodeSuccess {
+			return nil, fmt.Errorf("answered %s", dns.RcodeToString[resp.Rcode])
+		}
+
+		var addrs []string
+		for _, rr := range resp.Answer {
+			if a, ok := rr.(*dns.A); ok {
+				addrs = append(addrs, a.A.String())
+			}
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("no A record")
+		}
+		return addrs, nil
+	}
+}
+
+// hostLookup resolves a name like the other programs of the host do.
+func hostLookup(ctx context.Context, name string) ([]string, error) {
+	return net.DefaultResolver.LookupHost(ctx, name)
+}
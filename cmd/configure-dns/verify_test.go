@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func staticLookup(addrs []string, err error) lookupFunc {
+	return func(context.Context, string) ([]string, error) { return addrs, err }
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  lookupFunc
+		host    lookupFunc
+		wantErr bool
+	}{
+		{"resolved", staticLookup([]string{"127.0.0.1"}, nil), staticLookup([]string{"127.0.0.1"}, nil), false},
+		{"server down", staticLookup(nil, errors.New("connection refused")), staticLookup([]string{"127.0.0.1"}, nil), true},
+		{"host not routed", staticLookup([]string{"127.0.0.1"}, nil), staticLookup(nil, errors.New("no such host")), true},
+		{"other answer", staticLookup([]string{"127.0.0.1"}, nil), staticLookup([]string{"10.0.0.1"}, nil), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verify(context.Background(), []string{"loc"}, tt.server, tt.host, 0)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyWaitsForHostResolver(t *testing.T) {
+	calls := 0
+	host := func(context.Context, string) ([]string, error) {
+		calls++
+		if calls < 2 {
+			return nil, errors.New("no such host")
+		}
+		return []string{"127.0.0.1"}, nil
+	}
+
+	if err := verify(context.Background(), []string{"loc"}, staticLookup([]string{"127.0.0.1"}, nil), host, 5*time.Second); err != nil {
+		t.Errorf("verify() error = %v", err)
+	}
+}
+
+func TestParseTLDs(t *testing.T) {
+	got, err := parseTLDs(" loc, .Test ,loc,,docs.local. ")
+	want := []string{"loc", "test", "docs.local"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTLDs() = %v, %v, want %v", got, err, want)
+	}
+
+	for _, bad := range []string{"../etc", "a/b", ",", "-loc"} {
+		if _, err := parseTLDs(bad); err == nil {
+			t.Errorf("parseTLDs(%q) accepted an invalid list", bad)
+		}
+	}
+}
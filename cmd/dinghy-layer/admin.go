@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+const (
+	// adminReadHeaderTimeout bounds how long the admin server waits for request headers
+	adminReadHeaderTimeout = 5 * time.Second
+
+	// adminShutdownTimeout bounds graceful shutdown of the admin server
+	adminShutdownTimeout = 5 * time.Second
+)
+
+// regenerateResponse is the body returned by POST /containers/{id}/regenerate.
+// Status is "regenerated" when a config was written, or "removed" when the
+// container is no longer managed and any stale config was deleted.
+type regenerateResponse struct {
+	ContainerID   string   `json:"container_id"`
+	ContainerName string   `json:"container_name"`
+	Status        string   `json:"status"`
+	Hostnames     []string `json:"hostnames,omitempty"`
+}
+
+// routeStatus is one entry of GET /routes. Status is "healthy" or "degraded"
+// from the last probe of the container's hostnames, or "unknown" when routes
+// are not probed (yet). Metadata holds the selected container labels and
+// Certificates the last certificate probe of each hostname. With ?all=true,
+// the removed routes of stopped containers are listed too, with status
+// "parked" (stop requested) or "crashed". Source tells generated routes
+// ("virtual_host") from those imported from Traefik labels ("traefik_labels")
+// and static routes ("static", with container_id "static:<name>"). Path is
+// the VIRTUAL_PATH prefix the routes are restricted to.
+type routeStatus struct {
+	ContainerID   string            `json:"container_id"`
+	ContainerName string            `json:"container_name"`
+	Hostnames     []string          `json:"hostnames"`
+	Path          string            `json:"path,omitempty"`
+	BackendURL    string            `json:"backend_url"`
+	Source        string            `json:"source,omitempty"`
+	Project       string            `json:"project,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	Status        string            `json:"status"`
+	Probes        []ProbeResult     `json:"probes,omitempty"`
+	Certificates  []CertProbeResult `json:"certificates,omitempty"`
+	StoppedAt     *time.Time        `json:"stopped_at,omitempty"`
+	ExitCode      *int              `json:"exit_code,omitempty"`
+}
+
+// errorResponse is the body returned for failed admin requests.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// adminHandler returns the HTTP handler serving the admin API.
+func (cl *CompatibilityLayer) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /containers/{id}/regenerate", cl.handleRegenerate)
+	mux.HandleFunc("GET /routes", cl.handleRoutes)
+	mux.HandleFunc("GET /projects", cl.handleProjects)
+	mux.HandleFunc("GET /collisions", cl.handleCollisions)
+	mux.HandleFunc("GET /redirects", cl.handleRedirects)
+	mux.HandleFunc("GET /routes/{host}/websocket", cl.handleWebSocketProbe)
+	mux.HandleFunc("POST /reconcile", cl.handleReconcile)
+	mux.HandleFunc("GET /plan", cl.handlePlan)
+	mux.HandleFunc("POST /shutdown", cl.handleShutdown)
+	mux.HandleFunc("POST /batch", cl.handleBatch)
+	mux.HandleFunc("PUT /static-routes/{name}", cl.handleSetStaticRoute)
+	mux.HandleFunc("DELETE /static-routes/{name}", cl.handleDeleteStaticRoute)
+	mux.HandleFunc("GET /containers", cl.handleContainers)
+	mux.HandleFunc("GET /networks", cl.handleNetworks)
+	mux.HandleFunc("GET /dns/domains", cl.handleDNSDomains)
+	mux.HandleFunc("GET /events", cl.handleEvents)
+	mux.HandleFunc("GET /faults", cl.handleFault)
+	mux.HandleFunc("GET /health", cl.handleStackHealth)
+	mux.HandleFunc("GET /healthz", cl.handleHealth)
+	mux.Handle("GET /metrics", cl.metrics.Handler())
+	return mux
+}
+
+// runAdminServer serves the admin API on the configured address until ctx is done.
+func (cl *CompatibilityLayer) runAdminServer(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              cl.config.AdminAddr,
+		Handler:           cl.adminHandler(),
+		ReadHeaderTimeout: adminReadHeaderTimeout,
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.ListenAndServe()
+	}()
+
+	cl.logger.Info("Admin API listening", "addr", cl.config.AdminAddr)
+
+	select {
+	case err := <-errChan:
+		return fmt.Errorf("admin API failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), adminShutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+// handleRegenerate re-inspects a container and rewrites its config, so drift
+// from manual edits can be repaired without restarting the container or the
+// layer. The path value may be a container ID, short ID, or name.
+func (cl *CompatibilityLayer) handleRegenerate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	inspect, err := utils.RetryContainerInspect(ctx, cl.dockerClient, cl.dockerTimeout, r.PathValue("id"))
+	if err != nil {
+		if cerrdefs.IsNotFound(err) {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: "container not found"})
+			return
+		}
+		writeJSON(w, http.StatusBadGateway, errorResponse{Error: err.Error()})
+		return
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	// Forget the previous routes so the inventory reflects only what is regenerated
+	cl.routes.remove(inspect.ID)
+
+	if err := cl.processContainer(ctx, inspect.ID); err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+
+	resp := regenerateResponse{
+		ContainerID:   inspect.ID,
+		ContainerName: cl.extractContainerInfo(inspect).Name,
+	}
+
+	if routes, ok := cl.routes.get(inspect.ID); ok {
+		resp.Status = "regenerated"
+		resp.Hostnames = routes.Hostnames
+	} else {
+		// No longer managed (stopped, VIRTUAL_HOST removed, ...): drop stale config
+		if err := cl.removeTraefikConfig(inspect.ID); err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+			return
+		}
+		cl.routesChanged()
+		resp.Status = "removed"
+	}
+
+	cl.logger.Info("Regenerated container config via admin API",
+		"container_id", utils.FormatDockerID(inspect.ID),
+		"status", resp.Status)
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleRoutes lists the managed routes with their probe status and, with
+// ?all=true, the routes of stopped containers.
+func (cl *CompatibilityLayer) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	result := []routeStatus{}
+	for _, routes := range cl.routes.list() {
+		status := routeStatus{
+			ContainerID:   routes.ContainerID,
+			ContainerName: routes.ContainerName,
+			Hostnames:     routes.Hostnames,
+			Path:          routes.Path,
+			BackendURL:    routes.BackendURL,
+			Source:        routes.Source,
+			Project:       routes.Project,
+			Metadata:      routes.Metadata,
+			Status:        "unknown",
+		}
+
+		if cl.prober != nil {
+			for _, hostname := range routes.Hostnames {
+				probe, ok := cl.prober.result(hostname)
+				if !ok {
+					continue
+				}
+				status.Probes = append(status.Probes, probe)
+				if !probe.Healthy {
+					status.Status = "degraded"
+				} else if status.Status == "unknown" {
+					status.Status = "healthy"
+				}
+			}
+		}
+
+		if cl.certProber != nil {
+			for _, hostname := range routes.Hostnames {
+				if cert, ok := cl.certProber.result(hostname); ok {
+					status.Certificates = append(status.Certificates, cert)
+				}
+			}
+		}
+
+		result = append(result, status)
+	}
+
+	if r.URL.Query().Get("all") == "true" {
+		for _, stopped := range cl.stops.list() {
+			result = append(result, routeStatus{
+				ContainerID:   stopped.ContainerID,
+				ContainerName: stopped.ContainerName,
+				Hostnames:     stopped.Hostnames,
+				Path:          stopped.Path,
+				BackendURL:    stopped.BackendURL,
+				Source:        stopped.Source,
+				Project:       stopped.Project,
+				Metadata:      stopped.Metadata,
+				Status:        stopped.Reason,
+				StoppedAt:     &stopped.StoppedAt,
+				ExitCode:      stopped.ExitCode,
+			})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleReconcile regenerates the configs of all running containers and
+// repairs drift in the dynamic directory; with ?check=true it only reports.
+func (cl *CompatibilityLayer) handleReconcile(w http.ResponseWriter, r *http.Request) {
+	report, err := cl.reconcile(r.Context(), r.URL.Query().Get("check") != "true")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+
+	cl.logger.Info("Reconciled configs via admin API",
+		"checked", report.Checked,
+		"drift", len(report.Drift),
+		"repaired", report.Repaired)
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// shutdownResponse is the body returned by POST /shutdown.
+type shutdownResponse struct {
+	Removed int `json:"removed"`
+}
+
+// handleShutdown prepares the layer for a stack shutdown: it removes the
+// configs generated for containers, so Traefik stops accepting requests for
+// them while it drains the ones in flight, and stops generating configs until
+// the layer restarts. Static routes keep their files, which they are restored
+// from.
+func (cl *CompatibilityLayer) handleShutdown(w http.ResponseWriter, r *http.Request) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.shuttingDown = true
+	// Debounced writes would otherwise land after the removal
+	cl.flushWrites()
+	removed, err := cl.removeOrphanedConfigs(nil)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+
+	cl.logger.Info("Removed generated configs for stack shutdown", "count", removed)
+	writeJSON(w, http.StatusOK, shutdownResponse{Removed: removed})
+}
+
+// handleSetStaticRoute creates or replaces the static route named in the
+// path from a StaticRoute body (its name field is ignored).
+func (cl *CompatibilityLayer) handleSetStaticRoute(w http.ResponseWriter, r *http.Request) {
+	var route StaticRoute
+	if err := json.NewDecoder(r.Body).Decode(&route); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid JSON body: " + err.Error()})
+		return
+	}
+	route.Name = r.PathValue("name")
+	if err := route.validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if err := cl.setStaticRoute(route); err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, route)
+}
+
+// handleDeleteStaticRoute removes the static route named in the path.
+func (cl *CompatibilityLayer) handleDeleteStaticRoute(w http.ResponseWriter, r *http.Request) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	removed, err := cl.removeStaticRoute(r.PathValue("name"))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	if !removed {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "static route not found"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleHealth answers while the layer is running, for liveness checks.
+func (cl *CompatibilityLayer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// writeJSON writes v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	// The status line is already sent, so an encoding error cannot be reported
+	// to the client; it only happens when the connection is gone.
+	_ = json.NewEncoder(w).Encode(v)
+}
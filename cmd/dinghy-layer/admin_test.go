@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
+
+// newFakeDocker serves the subset of the Docker API used by the layer from an
+// in-memory container set and returns a client talking to it.
+func newFakeDocker(t *testing.T, containers ...types.ContainerJSON) *client.Client {
+	t.Helper()
+
+	find := func(ref string) (types.ContainerJSON, bool) {
+		for _, c := range containers {
+			if c.ID == ref || strings.HasPrefix(c.ID, ref) || strings.TrimPrefix(c.Name, "/") == ref {
+				return c, true
+			}
+		}
+		return types.ContainerJSON{}, false
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1.47/containers/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+		c, ok := find(r.PathValue("id"))
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "No such container"})
+			return
+		}
+		json.NewEncoder(w).Encode(c)
+	})
+	mux.HandleFunc("GET /v1.47/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var list []types.Container
+		for _, c := range containers {
+			if c.State != nil && c.State.Running && matchesLabelFilters(args, c.Config.Labels) {
+				list = append(list, types.Container{ID: c.ID, Names: []string{c.Name}, Labels: c.Config.Labels})
+			}
+		}
+		json.NewEncoder(w).Encode(list)
+	})
+
+	mux.HandleFunc("/_ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", "1.47")
+		w.Write([]byte("OK"))
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	cli, err := client.NewClientWithOpts(
+		client.WithHost("tcp://"+strings.TrimPrefix(srv.URL, "http://")),
+		client.WithVersion("1.47"),
+	)
+	if err != nil {
+		t.Fatalf("failed to create Docker client: %v", err)
+	}
+	t.Cleanup(func() { cli.Close() })
+	return cli
+}
+
+// matchesLabelFilters reports whether labels match every "label" filter of a
+// container list request.
+func matchesLabelFilters(args filters.Args, labels map[string]string) bool {
+	for _, filter := range args.Get("label") {
+		key, value, hasValue := strings.Cut(filter, "=")
+		got, ok := labels[key]
+		if !ok || (hasValue && got != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// managedContainer returns a running container inspect with a VIRTUAL_HOST.
+func managedContainer(id, name, virtualHost, ip string) types.ContainerJSON {
+	inspect := inspectWithIP("/"+name, ip)
+	inspect.ID = id
+	inspect.State = &types.ContainerState{Running: true}
+	inspect.Config = &container.Config{Env: []string{"VIRTUAL_HOST=" + virtualHost}}
+	return inspect
+}
+
+func testLayerWithDocker(t *testing.T, containers ...types.ContainerJSON) *CompatibilityLayer {
+	t.Helper()
+	cl := &CompatibilityLayer{
+		config:  &CompatibilityConfig{TraefikDynamicDir: t.TempDir()},
+		routes:  newRouteInventory(),
+		stops:   newStopTracker(),
+		metrics: metrics.NewRegistry(),
+	}
+	cl.SetDependencies(newFakeDocker(t, containers...), logger.New("test"))
+	return cl
+}
+
+func TestHandleRegenerate(t *testing.T) {
+	const id = "0123456789abcdef0123"
+	cl := testLayerWithDocker(t, managedContainer(id, "web", "web.loc", "172.0.0.5"))
+
+	req := httptest.NewRequest(http.MethodPost, "/containers/web/regenerate", nil)
+	rec := httptest.NewRecorder()
+	cl.adminHandler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200; body %s", rec.Code, rec.Body)
+	}
+
+	var resp regenerateResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.ContainerID != id || resp.Status != "regenerated" || len(resp.Hostnames) != 1 || resp.Hostnames[0] != "web.loc" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	// The config is written under the full container ID, not the name used in the URL
+	configFile := filepath.Join(cl.config.TraefikDynamicDir, "0123456789ab.yaml")
+	if _, err := os.Stat(configFile); err != nil {
+		t.Errorf("expected config file %s: %v", configFile, err)
+	}
+}
+
+func TestHandleRegenerateRemovesUnmanaged(t *testing.T) {
+	const id = "fedcba9876543210fedc"
+	stopped := managedContainer(id, "web", "web.loc", "172.0.0.5")
+	stopped.State.Running = false
+	cl := testLayerWithDocker(t, stopped)
+
+	// Simulate a stale config left behind for the container
+	stale := filepath.Join(cl.config.TraefikDynamicDir, "fedcba987654.yaml")
+	if err := os.WriteFile(stale, []byte("http: {}\n"), ConfigFilePermissions); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/containers/"+id+"/regenerate", nil))
+
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"removed"`) {
+		t.Fatalf("unexpected response %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale config still present: %v", err)
+	}
+}
+
+func TestHandleRegenerateNotFound(t *testing.T) {
+	cl := testLayerWithDocker(t)
+
+	rec := httptest.NewRecorder()
+	cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/containers/missing/regenerate", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", rec.Code)
+	}
+}
+
+func TestHandleRegenerateRequiresPost(t *testing.T) {
+	cl := testLayerWithDocker(t)
+
+	rec := httptest.NewRecorder()
+	cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/containers/web/regenerate", nil))
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want 405", rec.Code)
+	}
+}
+
+func TestHandleShutdown(t *testing.T) {
+	const id = "0123456789abcdef0123"
+	cl := testLayerWithDocker(t, managedContainer(id, "web", "web.loc", "172.0.0.5"))
+	dir := cl.config.TraefikDynamicDir
+	ctx := context.Background()
+
+	if err := cl.processContainer(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"static-docs.yaml", "middlewares.yaml"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("http: {}\n"), ConfigFilePermissions); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/shutdown", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"removed":1`) {
+		t.Fatalf("unexpected response %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "0123456789ab.yaml")); !os.IsNotExist(err) {
+		t.Errorf("generated config still present: %v", err)
+	}
+	for _, name := range []string{"static-docs.yaml", "middlewares.yaml"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was removed: %v", name, err)
+		}
+	}
+
+	// Events arriving while the stack stops do not bring configs back
+	if err := cl.processContainer(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "0123456789ab.yaml")); !os.IsNotExist(err) {
+		t.Errorf("config regenerated after shutdown: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+const (
+	// authMiddlewareSuffix marks the generated middlewares that authenticate
+	// requests; routers for auth bypass paths are generated without them.
+	authMiddlewareSuffix = "-auth"
+
+	// basicAuthLabel sets the users of HTTP_PROXY_BASIC_AUTH on containers
+	// that keep their environment free of credentials
+	basicAuthLabel = "http-proxy.basic-auth"
+)
+
+// htpasswdHashPrefixes are the password hash formats Traefik's basicAuth
+// middleware accepts: bcrypt, Apache MD5 and SHA-1.
+var htpasswdHashPrefixes = []string{"$2y$", "$2a$", "$2b$", "$apr1$", "{SHA}"}
+
+// isAuthMiddleware reports whether a router middleware is a generated auth one.
+func isAuthMiddleware(name string) bool {
+	return strings.HasSuffix(name, authMiddlewareSuffix)
+}
+
+// parseBasicAuthUsers parses HTTP_PROXY_BASIC_AUTH, htpasswd "user:hash"
+// entries separated by commas or newlines. Entries without a user or with a
+// plain-text password are returned separately, so a typo never stores a
+// password in the dynamic configuration.
+func parseBasicAuthUsers(spec string) (users []string, invalid []string) {
+	entries := strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' })
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(entry, ":")
+		if !ok || strings.TrimSpace(user) == "" || !isPasswordHash(hash) {
+			invalid = append(invalid, entry)
+			continue
+		}
+		users = append(users, entry)
+	}
+	return users, invalid
+}
+
+// basicAuthSetting returns a container's basic auth users: the
+// HTTP_PROXY_BASIC_AUTH variable, else the http-proxy.basic-auth label.
+func basicAuthSetting(env []string, labels map[string]string) string {
+	if users := utils.GetDockerEnvVar(env, "HTTP_PROXY_BASIC_AUTH"); users != "" {
+		return users
+	}
+	return labels[basicAuthLabel]
+}
+
+// isPasswordHash reports whether hash is in a format Traefik accepts.
+func isPasswordHash(hash string) bool {
+	for _, prefix := range htpasswdHashPrefixes {
+		if strings.HasPrefix(hash, prefix) && len(hash) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// basicAuthMiddlewareName returns the name of the middleware asking for a
+// service's credentials.
+func basicAuthMiddlewareName(serviceName string) string {
+	return serviceName + authMiddlewareSuffix
+}
+
+// addBasicAuthMiddleware defines a basicAuth middleware for the users and
+// attaches it to all of the service's routers.
+func addBasicAuthMiddleware(traefikConfig *config.TraefikConfig, serviceName string, users []string) {
+	if len(users) == 0 {
+		return
+	}
+
+	name := basicAuthMiddlewareName(serviceName)
+	traefikConfig.HTTP.Middlewares[name] = &config.Middleware{
+		BasicAuth: &config.BasicAuthMiddleware{Users: users, Realm: serviceName},
+	}
+	for _, router := range traefikConfig.HTTP.Routers {
+		if isContainerService(router.Service, serviceName) {
+			router.Middlewares = append(router.Middlewares, name)
+		}
+	}
+}
+
+// parseAuthBypassPaths parses HTTP_PROXY_AUTH_BYPASS_PATHS, a comma-separated
+// list of paths served without authentication. A path matches exactly unless
+// it ends with "*", which makes it a prefix ("/status/*"). Entries that are
+// not absolute paths or could break out of a rule are returned separately.
+func parseAuthBypassPaths(spec string) (matchers []string, invalid []string) {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.HasPrefix(entry, "/") || strings.ContainsAny(entry, "`\\ ") {
+			invalid = append(invalid, entry)
+			continue
+		}
+
+		if prefix, ok := strings.CutSuffix(entry, "*"); ok {
+			if strings.Contains(prefix, "*") {
+				invalid = append(invalid, entry)
+				continue
+			}
+			matchers = append(matchers, fmt.Sprintf("PathPrefix(`%s`)", prefix))
+		} else if strings.Contains(entry, "*") {
+			invalid = append(invalid, entry)
+		} else {
+			matchers = append(matchers, fmt.Sprintf("Path(`%s`)", entry))
+		}
+	}
+	return matchers, invalid
+}
+
+// addAuthBypassRouters adds, for every router of the service carrying an auth
+// middleware, a router matching only the bypass paths with the same
+// middlewares minus the auth ones. Its priority is above the original
+// router's, so Traefik picks it for those paths. Routers without a generated
+// auth middleware get none. It must run after all middlewares are attached.
+func addAuthBypassRouters(traefikConfig *config.TraefikConfig, serviceName string, matchers []string) {
+	if len(matchers) == 0 {
+		return
+	}
+	pathRule := strings.Join(matchers, " || ")
+
+	bypass := make(map[string]*config.Router)
+	for name, router := range traefikConfig.HTTP.Routers {
+		if !isContainerService(router.Service, serviceName) {
+			continue
+		}
+
+		var middlewares []string
+		protected := false
+		for _, middleware := range router.Middlewares {
+			if isAuthMiddleware(middleware) {
+				protected = true
+				continue
+			}
+			middlewares = append(middlewares, middleware)
+		}
+		if !protected {
+			continue
+		}
+
+		rule := fmt.Sprintf("(%s) && (%s)", router.Rule, pathRule)
+		bypass[name+"-noauth"] = &config.Router{
+			Rule:        rule,
+			Service:     router.Service,
+			EntryPoints: router.EntryPoints,
+			Middlewares: middlewares,
+			Priority:    max(len(rule), routerPriority(router)+1),
+			TLS:         router.TLS,
+		}
+	}
+
+	for name, router := range bypass {
+		traefikConfig.HTTP.Routers[name] = router
+	}
+}
+
+// routerPriority returns a router's effective priority: the explicit one, or
+// the rule priority setRulePriorities gives it.
+func routerPriority(router *config.Router) int {
+	if router.Priority != 0 {
+		return router.Priority
+	}
+	return rulePriority(router.Rule)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+)
+
+func TestParseAuthBypassPaths(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        string
+		want        []string
+		wantInvalid []string
+	}{
+		{name: "empty"},
+		{
+			name: "exact and prefix",
+			spec: "/healthz, /status/*",
+			want: []string{"Path(`/healthz`)", "PathPrefix(`/status/`)"},
+		},
+		{
+			name:        "malformed entries",
+			spec:        "healthz,/a`b,/x/*/y,/metrics",
+			want:        []string{"Path(`/metrics`)"},
+			wantInvalid: []string{"healthz", "/a`b", "/x/*/y"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, invalid := parseAuthBypassPaths(tt.spec)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchers = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(invalid, tt.wantInvalid) {
+				t.Errorf("invalid = %v, want %v", invalid, tt.wantInvalid)
+			}
+		})
+	}
+}
+
+func TestAddAuthBypassRouters(t *testing.T) {
+	cfg := config.NewTraefikConfig()
+	cfg.HTTP.Routers["shop-0"] = &config.Router{
+		Rule:        "Host(`shop.loc`)",
+		Service:     "shop",
+		EntryPoints: []string{"http"},
+		Middlewares: []string{"shop-auth", "shop-headers"},
+	}
+	cfg.HTTP.Routers["shop-tls-0"] = &config.Router{
+		Rule:        "Host(`shop.loc`)",
+		Service:     "shop",
+		EntryPoints: []string{"https"},
+		Middlewares: []string{"shop-headers", "shop-auth", "shop-security-headers"},
+		Priority:    500,
+		TLS:         &config.RouterTLSConfig{},
+	}
+	cfg.HTTP.Routers["other-0"] = &config.Router{
+		Rule:        "Host(`other.loc`)",
+		Service:     "other",
+		Middlewares: []string{"other-auth"},
+	}
+
+	addAuthBypassRouters(cfg, "shop", []string{"Path(`/healthz`)", "PathPrefix(`/metrics/`)"})
+
+	if len(cfg.HTTP.Routers) != 5 {
+		t.Fatalf("got %d routers, want 5: %v", len(cfg.HTTP.Routers), cfg.HTTP.Routers)
+	}
+
+	plain := cfg.HTTP.Routers["shop-0-noauth"]
+	wantRule := "(Host(`shop.loc`)) && (Path(`/healthz`) || PathPrefix(`/metrics/`))"
+	if plain == nil || plain.Rule != wantRule || plain.Priority != len(wantRule) {
+		t.Fatalf("unexpected HTTP bypass router: %+v", plain)
+	}
+	if !reflect.DeepEqual(plain.Middlewares, []string{"shop-headers"}) {
+		t.Errorf("HTTP bypass middlewares = %v", plain.Middlewares)
+	}
+
+	secure := cfg.HTTP.Routers["shop-tls-0-noauth"]
+	if secure == nil || secure.TLS == nil || secure.Priority != 501 {
+		t.Fatalf("unexpected HTTPS bypass router: %+v", secure)
+	}
+	if !reflect.DeepEqual(secure.Middlewares, []string{"shop-headers", "shop-security-headers"}) {
+		t.Errorf("HTTPS bypass middlewares = %v", secure.Middlewares)
+	}
+}
+
+func TestAddAuthBypassRoutersWithoutAuth(t *testing.T) {
+	cl := testLayer()
+	info := ContainerInfo{Name: "shop", VirtualHost: "shop.loc", AuthBypassPaths: "/healthz"}
+
+	cfg := cl.generateTraefikConfig(inspectWithIP("/shop", "172.0.0.8"), info)
+
+	for name := range cfg.HTTP.Routers {
+		if name != "shop-0" && name != "shop-tls-0" {
+			t.Errorf("unexpected router %q for a route without auth", name)
+		}
+	}
+}
+
+func TestParseBasicAuthUsers(t *testing.T) {
+	const bcrypt = "alice:$2y$05$Ui4Cx0tZo.Xh6pDBhbYz5OQdbL2ZH5Qq7fJmkXH8tBvjYfPbJ9dXW"
+	tests := []struct {
+		name        string
+		spec        string
+		want        []string
+		wantInvalid []string
+	}{
+		{name: "empty"},
+		{
+			name: "hashes",
+			spec: bcrypt + ",\nbob:$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/\ncarol:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=",
+			want: []string{bcrypt, "bob:$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/", "carol:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g="},
+		},
+		{
+			name:        "plain text and missing user",
+			spec:        "dave:secret, :$2y$05$abc, erin",
+			wantInvalid: []string{"dave:secret", ":$2y$05$abc", "erin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, invalid := parseBasicAuthUsers(tt.spec)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("users = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(invalid, tt.wantInvalid) {
+				t.Errorf("invalid = %v, want %v", invalid, tt.wantInvalid)
+			}
+		})
+	}
+}
+
+func TestBasicAuthSetting(t *testing.T) {
+	labels := map[string]string{basicAuthLabel: "label:$2y$05$x"}
+	if got := basicAuthSetting([]string{"HTTP_PROXY_BASIC_AUTH=env:$2y$05$x"}, labels); got != "env:$2y$05$x" {
+		t.Errorf("basicAuthSetting() = %q, want the environment variable", got)
+	}
+	if got := basicAuthSetting(nil, labels); got != "label:$2y$05$x" {
+		t.Errorf("basicAuthSetting() = %q, want the label", got)
+	}
+}
+
+func TestGenerateTraefikConfigBasicAuth(t *testing.T) {
+	cl := testLayer()
+	info := ContainerInfo{
+		Name:            "staging",
+		VirtualHost:     "staging.loc",
+		BasicAuth:       "alice:$2y$05$Ui4Cx0tZo.Xh6pDBhbYz5OQdbL2ZH5Qq7fJmkXH8tBvjYfPbJ9dXW,bob:plain",
+		AuthBypassPaths: "/healthz",
+	}
+
+	cfg := cl.generateTraefikConfig(inspectWithIP("/staging", "172.0.0.30"), info)
+
+	auth := cfg.HTTP.Middlewares["staging-auth"]
+	if auth == nil || auth.BasicAuth == nil || len(auth.BasicAuth.Users) != 1 {
+		t.Fatalf("staging-auth = %+v, want a basicAuth middleware with one user", auth)
+	}
+	for _, name := range []string{"staging-0", "staging-tls-0"} {
+		if router := cfg.HTTP.Routers[name]; router.Middlewares[0] != "staging-auth" {
+			t.Errorf("%s middlewares = %v, want staging-auth first", name, router.Middlewares)
+		}
+		if _, ok := cfg.HTTP.Routers[name+"-noauth"]; !ok {
+			t.Errorf("missing bypass router %s-noauth", name)
+		}
+	}
+
+	info.BasicAuth = "bob:plain"
+	if cfg := cl.generateTraefikConfig(inspectWithIP("/staging", "172.0.0.30"), info); len(cfg.HTTP.Routers) != 0 {
+		t.Errorf("invalid users generated %d routers, want none", len(cfg.HTTP.Routers))
+	}
+}
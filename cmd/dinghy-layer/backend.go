@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+)
+
+// Backend schemes selected by VIRTUAL_PROTO
+const (
+	backendProtoHTTP  = "http"
+	backendProtoHTTPS = "https"
+	backendProtoH2C   = "h2c" // HTTP/2 without TLS, as gRPC services speak it
+)
+
+// backendProto is how Traefik reaches a container: the URL scheme and, for
+// HTTPS backends, whether their certificate is left unverified.
+type backendProto struct {
+	scheme     string
+	skipVerify bool
+}
+
+// parseBackendProto parses VIRTUAL_PROTO and HTTP_PROXY_BACKEND_SKIP_VERIFY.
+// Containers terminating TLS themselves mostly use self-signed certificates,
+// so verification is skipped unless skipVerify is "false". nginx-proxy's
+// grpc and grpcs map to h2c and https, where Traefik negotiates HTTP/2; its
+// other protocols (uwsgi, fastcgi) are rejected.
+func parseBackendProto(proto, skipVerify string) (backendProto, error) {
+	switch strings.ToLower(strings.TrimSpace(proto)) {
+	case "", backendProtoHTTP:
+		return backendProto{scheme: backendProtoHTTP}, nil
+	case backendProtoHTTPS, "grpcs":
+		return backendProto{scheme: backendProtoHTTPS, skipVerify: strings.TrimSpace(skipVerify) != "false"}, nil
+	case backendProtoH2C, "grpc":
+		return backendProto{scheme: backendProtoH2C}, nil
+	default:
+		return backendProto{}, fmt.Errorf("unsupported VIRTUAL_PROTO %q, expected %s, %s or %s", proto, backendProtoHTTP, backendProtoHTTPS, backendProtoH2C)
+	}
+}
+
+// serverURL returns the URL Traefik forwards requests to.
+func (p backendProto) serverURL(ip, port string) string {
+	return fmt.Sprintf("%s://%s:%s", p.scheme, ip, port)
+}
+
+// transport returns the servers transport the backend needs, or nil for
+// Traefik's default. Verified HTTPS backends are checked against the first
+// plain VIRTUAL_HOST, since their certificates are not issued for the
+// container IP Traefik connects to.
+func (p backendProto) transport(hosts []virtualHost) *config.ServersTransport {
+	if p.scheme != backendProtoHTTPS {
+		return nil
+	}
+	if p.skipVerify {
+		return &config.ServersTransport{InsecureSkipVerify: true}
+	}
+	for _, host := range hosts {
+		if !isWildcardHost(host.hostname) {
+			return &config.ServersTransport{ServerName: host.hostname}
+		}
+	}
+	return nil
+}
+
+// serversTransportName returns the name of the servers transport of a
+// service.
+func serversTransportName(serviceName string) string {
+	return serviceName + "-transport"
+}
+
+// addServersTransport defines the servers transport of a container, if any,
+// and points the load balancers of its services at it.
+func addServersTransport(traefikConfig *config.TraefikConfig, serviceName string, transport *config.ServersTransport) {
+	if transport == nil {
+		return
+	}
+
+	name := serversTransportName(serviceName)
+	for svcName, svc := range traefikConfig.HTTP.Services {
+		if !isContainerService(svcName, serviceName) || svc.LoadBalancer == nil {
+			continue
+		}
+		if traefikConfig.HTTP.ServersTransports == nil {
+			traefikConfig.HTTP.ServersTransports = make(map[string]*config.ServersTransport)
+		}
+		traefikConfig.HTTP.ServersTransports[name] = transport
+		svc.LoadBalancer.ServersTransport = name
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+)
+
+func TestParseBackendProto(t *testing.T) {
+	tests := []struct {
+		name       string
+		proto      string
+		skipVerify string
+		want       backendProto
+		wantErr    bool
+	}{
+		{name: "default", want: backendProto{scheme: "http"}},
+		{name: "http", proto: "http", skipVerify: "false", want: backendProto{scheme: "http"}},
+		{name: "https skips verification", proto: " HTTPS ", want: backendProto{scheme: "https", skipVerify: true}},
+		{name: "https verified", proto: "https", skipVerify: "false", want: backendProto{scheme: "https"}},
+		{name: "h2c", proto: "h2c", want: backendProto{scheme: "h2c"}},
+		{name: "grpc", proto: "grpc", want: backendProto{scheme: "h2c"}},
+		{name: "grpcs", proto: "grpcs", want: backendProto{scheme: "https", skipVerify: true}},
+		{name: "uwsgi", proto: "uwsgi", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBackendProto(tt.proto, tt.skipVerify)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBackendProto(%q, %q) error = %v, wantErr %v", tt.proto, tt.skipVerify, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseBackendProto(%q, %q) = %+v, want %+v", tt.proto, tt.skipVerify, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateTraefikConfigHTTPSBackend(t *testing.T) {
+	tests := []struct {
+		name       string
+		skipVerify string
+		want       *config.ServersTransport
+	}{
+		{name: "self-signed", want: &config.ServersTransport{InsecureSkipVerify: true}},
+		{name: "verified", skipVerify: "false", want: &config.ServersTransport{ServerName: "auth.loc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := testLayer()
+			info := ContainerInfo{Name: "keycloak", VirtualHost: "*.auth.loc,auth.loc", VirtualPort: "8443", VirtualProto: "https", BackendSkipVerify: tt.skipVerify}
+
+			cfg := cl.generateTraefikConfig(inspectWithIP("/keycloak", "172.0.0.11"), info)
+
+			lb := cfg.HTTP.Services["keycloak"].LoadBalancer
+			if got := lb.Servers[0].URL; got != "https://172.0.0.11:8443" {
+				t.Errorf("server URL = %q, want https://172.0.0.11:8443", got)
+			}
+			if lb.ServersTransport != "keycloak-transport" {
+				t.Errorf("serversTransport = %q, want keycloak-transport", lb.ServersTransport)
+			}
+			if got := cfg.HTTP.ServersTransports["keycloak-transport"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("transport = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	cl := testLayer()
+	cfg := cl.generateTraefikConfig(inspectWithIP("/api", "172.0.0.13"), ContainerInfo{Name: "api", VirtualHost: "api.loc", VirtualPort: "50051", VirtualProto: "grpc"})
+	if lb := cfg.HTTP.Services["api"].LoadBalancer; lb.Servers[0].URL != "h2c://172.0.0.13:50051" || lb.ServersTransport != "" {
+		t.Errorf("h2c backend = %+v, want h2c://172.0.0.13:50051 without a servers transport", lb)
+	}
+
+	cfg = cl.generateTraefikConfig(inspectWithIP("/web", "172.0.0.12"), ContainerInfo{Name: "web", VirtualHost: "web.loc"})
+	if cfg.HTTP.ServersTransports != nil || cfg.HTTP.Services["web"].LoadBalancer.ServersTransport != "" {
+		t.Errorf("HTTP backend got a servers transport: %+v", cfg.HTTP.ServersTransports)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// Batch operations of POST /batch
+const (
+	batchPause         = "pause"          // remove the routes of a project's containers until resumed
+	batchResume        = "resume"         // route a paused project's containers again
+	batchRegenerate    = "regenerate"     // rewrite the configs of all running containers, or a project's
+	batchDeleteOrphans = "delete-orphans" // remove config files of no running managed container
+)
+
+// pausedStateName is the state snapshot keeping the paused projects across
+// restarts
+const pausedStateName = "paused-projects"
+
+// pausedState is the snapshot of the paused projects.
+type pausedState struct {
+	Projects []string `json:"projects"`
+}
+
+// Changes a batch makes to a config file
+const (
+	batchWrite  = "write"
+	batchRemove = "remove"
+)
+
+// batchOperation is one operation of a batch request. Project selects a
+// compose project; it is required by pause and resume.
+type batchOperation struct {
+	Op      string `json:"op"`
+	Project string `json:"project,omitempty"`
+}
+
+// batchRequest is the body of POST /batch. With DryRun, the changes are
+// only reported.
+type batchRequest struct {
+	Operations []batchOperation `json:"operations"`
+	DryRun     bool             `json:"dry_run,omitempty"`
+}
+
+// batchChange is a config file written or removed by a batch.
+type batchChange struct {
+	ContainerID   string `json:"container_id"`
+	ContainerName string `json:"container_name,omitempty"`
+	Project       string `json:"project,omitempty"`
+	ConfigFile    string `json:"config_file"`
+	Action        string `json:"action"`
+}
+
+// batchResponse is the body returned by POST /batch. Applied is false for
+// dry runs and failed batches; Paused lists the paused projects after the
+// batch.
+type batchResponse struct {
+	DryRun  bool          `json:"dry_run"`
+	Applied bool          `json:"applied"`
+	Changes []batchChange `json:"changes"`
+	Paused  []string      `json:"paused"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// validate checks the operations of a batch.
+func (req batchRequest) validate() error {
+	if len(req.Operations) == 0 {
+		return errors.New("no operations")
+	}
+	for i, op := range req.Operations {
+		switch op.Op {
+		case batchPause, batchResume:
+			if op.Project == "" {
+				return fmt.Errorf("operation %d: %s requires a project", i, op.Op)
+			}
+		case batchRegenerate:
+		case batchDeleteOrphans:
+			if op.Project != "" {
+				return fmt.Errorf("operation %d: %s applies to all projects", i, op.Op)
+			}
+		default:
+			return fmt.Errorf("operation %d: unknown operation %q, expected pause, resume, regenerate or delete-orphans", i, op.Op)
+		}
+	}
+	return nil
+}
+
+// handleBatch runs several route operations as one transaction: every
+// change is planned before any file is touched, and files already changed
+// are restored when a later change fails. ?dry_run=true or "dry_run" in the
+// body only reports the changes.
+func (cl *CompatibilityLayer) handleBatch(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid JSON body: " + err.Error()})
+		return
+	}
+	// Projects are matched against their labels, which compose normalizes
+	for i := range req.Operations {
+		req.Operations[i].Project = utils.NormalizeComposeProject(req.Operations[i].Project)
+	}
+	if err := req.validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	req.DryRun = req.DryRun || r.URL.Query().Get("dry_run") == "true" || cl.config.DryRun
+
+	resp, err := cl.runBatch(r.Context(), req)
+	if err != nil {
+		resp.Error = err.Error()
+		writeJSON(w, http.StatusInternalServerError, resp)
+		return
+	}
+
+	cl.logger.Info("Ran batch operations via admin API",
+		"operations", len(req.Operations),
+		"changes", len(resp.Changes),
+		"dry_run", resp.DryRun)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// runBatch plans the changes of a batch against the configs the layer would
+// generate with the batch's paused projects, then applies them unless the
+// batch is a dry run.
+func (cl *CompatibilityLayer) runBatch(ctx context.Context, req batchRequest) (batchResponse, error) {
+	resp := batchResponse{DryRun: req.DryRun, Changes: []batchChange{}}
+
+	containers, err := utils.RetryContainerList(ctx, cl.dockerClient, cl.dockerTimeout, container.ListOptions{})
+	if err != nil {
+		return resp, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	// Changes are planned against the files with debounced writes on disk
+	cl.flushWrites()
+
+	previous := cl.paused
+	paused := make(map[string]bool, len(previous))
+	for project := range previous {
+		paused[project] = true
+	}
+	for _, op := range req.Operations {
+		switch op.Op {
+		case batchPause:
+			paused[op.Project] = true
+		case batchResume:
+			delete(paused, op.Project)
+		}
+	}
+	resp.Paused = sortedProjects(previous)
+
+	// Generate every running container's config with the new paused set,
+	// collecting it instead of writing it
+	cl.paused = paused
+	expected := make(map[string][]byte)
+	routes := make(map[string]ContainerRoutes)
+	running := make(map[string]batchChange)
+	var failed []string
+	for _, cont := range containers {
+		id := utils.FormatDockerID(cont.ID)
+		name := ""
+		if len(cont.Names) > 0 {
+			name = strings.TrimPrefix(cont.Names[0], "/")
+		}
+		running[id] = batchChange{ContainerID: cont.ID, ContainerName: name, Project: utils.ComposeProject(cont.Labels)}
+		render, err := cl.planContainer(ctx, cont.ID)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		if render != nil {
+			expected[id] = render.config
+			if render.routes.ServiceName != "" {
+				routes[id] = render.routes
+			}
+		}
+	}
+	cl.paused = previous
+
+	// A transaction does not go ahead with configs it could not generate
+	if len(failed) > 0 {
+		return resp, fmt.Errorf("failed to generate configs: %s", strings.Join(failed, "; "))
+	}
+
+	changes, err := cl.planBatch(req.Operations, running, expected)
+	if err != nil {
+		return resp, err
+	}
+	resp.Changes = changes
+	if req.DryRun {
+		resp.Paused = sortedProjects(paused)
+		return resp, nil
+	}
+
+	if err := cl.applyBatch(changes, expected, routes); err != nil {
+		return resp, err
+	}
+	cl.paused = paused
+	cl.savePaused()
+	resp.Applied = true
+	resp.Paused = sortedProjects(paused)
+	return resp, nil
+}
+
+// planBatch returns the file changes the operations select, in config file
+// order: running containers are in scope of pause and resume of their
+// project and of regenerate, files of no running container of delete-orphans.
+func (cl *CompatibilityLayer) planBatch(ops []batchOperation, running map[string]batchChange, expected map[string][]byte) ([]batchChange, error) {
+	inScope := func(id string) bool {
+		cont, isRunning := running[id]
+		for _, op := range ops {
+			switch op.Op {
+			case batchPause, batchResume:
+				if isRunning && cont.Project == op.Project {
+					return true
+				}
+			case batchRegenerate:
+				if isRunning && (op.Project == "" || cont.Project == op.Project) {
+					return true
+				}
+			case batchDeleteOrphans:
+				if _, ok := expected[id]; !ok && !isRunning {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	onDisk, err := cl.configFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool)
+	for id := range onDisk {
+		ids[id] = true
+	}
+	for id := range expected {
+		ids[id] = true
+	}
+
+	var changes []batchChange
+	for id := range ids {
+		if !inScope(id) {
+			continue
+		}
+		change := running[id]
+		if change.ContainerID == "" {
+			change.ContainerID = id
+		}
+		change.ConfigFile = cl.configFileName(id)
+
+		want, generated := expected[id]
+		got, exists := onDisk[id]
+		switch {
+		case generated && (!exists || !bytes.Equal(got, want)):
+			change.Action = batchWrite
+		case !generated && exists:
+			change.Action = batchRemove
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].ConfigFile < changes[j].ConfigFile })
+	if changes == nil {
+		changes = []batchChange{}
+	}
+	return changes, nil
+}
+
+// configFiles reads the config files the layer wrote, by short container ID.
+func (cl *CompatibilityLayer) configFiles() (map[string][]byte, error) {
+	entries, err := os.ReadDir(cl.config.TraefikDynamicDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read Traefik dynamic directory: %w", err)
+	}
+	files := make(map[string][]byte)
+	for _, entry := range entries {
+		if entry.IsDir() || !configFilePattern.MatchString(entry.Name()) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(cl.config.TraefikDynamicDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file %s: %w", entry.Name(), err)
+		}
+		files[strings.TrimSuffix(entry.Name(), ".yaml")] = data
+	}
+	return files, nil
+}
+
+// applyBatch makes the planned changes and updates the route inventory.
+// When one fails, the files changed before it are restored and the error is
+// returned.
+func (cl *CompatibilityLayer) applyBatch(changes []batchChange, expected map[string][]byte, routes map[string]ContainerRoutes) error {
+	type backup struct {
+		name string
+		data []byte // nil when the file did not exist
+	}
+	var done []backup
+
+	for _, change := range changes {
+		path := filepath.Join(cl.config.TraefikDynamicDir, change.ConfigFile)
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			err = fmt.Errorf("failed to back up %s: %w", change.ConfigFile, err)
+		} else if change.Action == batchWrite {
+			_, err = cl.writeDynamicFile(change.ConfigFile, expected[utils.FormatDockerID(change.ContainerID)])
+		} else if err = os.Remove(path); err != nil {
+			err = fmt.Errorf("failed to remove %s: %w", change.ConfigFile, err)
+		}
+		if err != nil {
+			for i := len(done) - 1; i >= 0; i-- {
+				cl.restoreConfigFile(done[i].name, done[i].data)
+			}
+			return err
+		}
+		done = append(done, backup{name: change.ConfigFile, data: data})
+	}
+
+	for _, change := range changes {
+		if change.Action == batchRemove {
+			cl.routes.remove(change.ContainerID)
+		} else if r, ok := routes[utils.FormatDockerID(change.ContainerID)]; ok {
+			cl.routes.set(r)
+		}
+		cl.logger.Info("Applied batch change",
+			"container_id", utils.FormatDockerID(change.ContainerID),
+			"config_file", change.ConfigFile,
+			"action", change.Action)
+	}
+	if len(changes) > 0 {
+		cl.routesChanged()
+	}
+	return nil
+}
+
+// restoreConfigFile puts back a config file changed by a failed batch.
+func (cl *CompatibilityLayer) restoreConfigFile(name string, data []byte) {
+	var err error
+	if data == nil {
+		err = os.Remove(filepath.Join(cl.config.TraefikDynamicDir, name))
+	} else {
+		_, err = cl.writeDynamicFile(name, data)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		cl.logger.Error("Failed to restore config file after a failed batch",
+			"config_file", name,
+			"error", err)
+	}
+}
+
+// isPaused reports whether a container belongs to a project paused with
+// POST /batch.
+func (cl *CompatibilityLayer) isPaused(labels map[string]string) bool {
+	project := utils.ComposeProject(labels)
+	return project != "" && cl.paused[project]
+}
+
+// savePaused writes the paused projects to the state directory, so a restart
+// does not resume them.
+func (cl *CompatibilityLayer) savePaused() {
+	if cl.state == nil {
+		return
+	}
+	if err := cl.state.Write(pausedStateName, pausedState{Projects: sortedProjects(cl.paused)}); err != nil {
+		cl.logger.Error("Failed to save paused projects", "error", err)
+	}
+}
+
+// loadPaused restores the projects paused by a previous run.
+func (cl *CompatibilityLayer) loadPaused() {
+	if cl.state == nil {
+		return
+	}
+	var snapshot pausedState
+	if err := cl.state.Read(pausedStateName, &snapshot); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			cl.logger.Warn("Ignoring unreadable paused projects", "error", err)
+		}
+		return
+	}
+	cl.paused = make(map[string]bool, len(snapshot.Projects))
+	for _, project := range snapshot.Projects {
+		cl.paused[project] = true
+	}
+	if len(cl.paused) > 0 {
+		cl.logger.Info("Restored paused projects", "projects", snapshot.Projects)
+	}
+}
+
+// sortedProjects returns the names of a project set in order.
+func sortedProjects(projects map[string]bool) []string {
+	names := make([]string, 0, len(projects))
+	for name := range projects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sparkfabrik/http-proxy/pkg/service"
+	"github.com/sparkfabrik/http-proxy/pkg/state"
+)
+
+// projectContainer returns a running managed container of a compose project.
+func projectContainer(id, name, project string) types.ContainerJSON {
+	inspect := managedContainer(id, name, name+".loc", "172.0.0.60")
+	inspect.Config.Labels = map[string]string{service.ComposeProjectLabel: project}
+	return inspect
+}
+
+// runBatchRequest posts a batch to the admin API at target and decodes the
+// answer.
+func runBatchRequest(t *testing.T, cl *CompatibilityLayer, target, body string) (int, batchResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, bytes.NewBufferString(body)))
+
+	var resp batchResponse
+	if rec.Code != http.StatusBadRequest {
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+	return rec.Code, resp
+}
+
+// changeActions returns the config file and action of each change.
+func changeActions(changes []batchChange) []string {
+	var out []string
+	for _, change := range changes {
+		out = append(out, change.Action+" "+change.ConfigFile)
+	}
+	return out
+}
+
+func TestBatchRequestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		ops     []batchOperation
+		wantErr bool
+	}{
+		{name: "empty", wantErr: true},
+		{name: "pause", ops: []batchOperation{{Op: batchPause, Project: "shop"}}},
+		{name: "pause without project", ops: []batchOperation{{Op: batchPause}}, wantErr: true},
+		{name: "regenerate all", ops: []batchOperation{{Op: batchRegenerate}}},
+		{name: "orphans of a project", ops: []batchOperation{{Op: batchDeleteOrphans, Project: "shop"}}, wantErr: true},
+		{name: "unknown", ops: []batchOperation{{Op: "stop"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := (batchRequest{Operations: tt.ops}).validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandleBatch(t *testing.T) {
+	cl := testLayerWithDocker(t,
+		projectContainer("aaaaaaaaaaaa0000", "web", "shop"),
+		projectContainer("bbbbbbbbbbbb0000", "worker", "shop"),
+		projectContainer("cccccccccccc0000", "blog", "blog"))
+	dir := cl.config.TraefikDynamicDir
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+
+	code, resp := runBatchRequest(t, cl, "/batch", `{"operations":[{"op":"regenerate"}]}`)
+	want := []string{"write aaaaaaaaaaaa.yaml", "write bbbbbbbbbbbb.yaml", "write cccccccccccc.yaml"}
+	if code != http.StatusOK || !resp.Applied || !reflect.DeepEqual(changeActions(resp.Changes), want) {
+		t.Fatalf("regenerate: %d %+v", code, resp)
+	}
+	if len(cl.routes.list()) != 3 {
+		t.Errorf("inventory has %d containers, want 3", len(cl.routes.list()))
+	}
+
+	// A stray file of a container that is gone
+	if err := os.WriteFile(filepath.Join(dir, "dddddddddddd.yaml"), []byte("http: {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"operations":[{"op":"pause","project":"shop"},{"op":"delete-orphans"}]}`
+	want = []string{"remove aaaaaaaaaaaa.yaml", "remove bbbbbbbbbbbb.yaml", "remove dddddddddddd.yaml"}
+	_, resp = runBatchRequest(t, cl, "/batch?dry_run=true", body)
+	if !resp.DryRun || resp.Applied || !reflect.DeepEqual(changeActions(resp.Changes), want) || !reflect.DeepEqual(resp.Paused, []string{"shop"}) {
+		t.Errorf("dry run: %+v", resp)
+	}
+	if !exists("aaaaaaaaaaaa.yaml") || !exists("dddddddddddd.yaml") || len(cl.paused) != 0 || len(cl.routes.list()) != 3 {
+		t.Error("dry run changed the dynamic directory, the inventory or the paused projects")
+	}
+
+	code, resp = runBatchRequest(t, cl, "/batch", body)
+	if code != http.StatusOK || !resp.Applied || !reflect.DeepEqual(changeActions(resp.Changes), want) {
+		t.Fatalf("pause: %d %+v", code, resp)
+	}
+	if exists("aaaaaaaaaaaa.yaml") || exists("dddddddddddd.yaml") || !exists("cccccccccccc.yaml") || len(cl.routes.list()) != 1 {
+		t.Error("pause did not remove the project's routes and the orphan only")
+	}
+
+	// Paused containers stay unrouted when regenerated
+	if _, resp = runBatchRequest(t, cl, "/batch", `{"operations":[{"op":"regenerate"}]}`); len(resp.Changes) != 0 {
+		t.Errorf("regenerate of a paused project: %+v", resp.Changes)
+	}
+
+	_, resp = runBatchRequest(t, cl, "/batch", `{"operations":[{"op":"resume","project":"shop"}]}`)
+	want = []string{"write aaaaaaaaaaaa.yaml", "write bbbbbbbbbbbb.yaml"}
+	if !resp.Applied || !reflect.DeepEqual(changeActions(resp.Changes), want) || len(resp.Paused) != 0 || len(cl.routes.list()) != 3 {
+		t.Errorf("resume: %+v", resp)
+	}
+}
+
+func TestPausedProjectsSurviveRestart(t *testing.T) {
+	stateDir := t.TempDir()
+	cl := testLayerWithDocker(t, projectContainer("aaaaaaaaaaaa0000", "web", "shop"))
+	cl.state = state.NewStore(stateDir)
+
+	if _, resp := runBatchRequest(t, cl, "/batch", `{"operations":[{"op":"pause","project":"shop"}]}`); !resp.Applied {
+		t.Fatalf("pause: %+v", resp)
+	}
+
+	restarted := testLayerWithDocker(t, projectContainer("aaaaaaaaaaaa0000", "web", "shop"))
+	restarted.state = state.NewStore(stateDir)
+	restarted.loadPaused()
+	if !reflect.DeepEqual(restarted.paused, map[string]bool{"shop": true}) {
+		t.Fatalf("restored paused projects = %v, want shop", restarted.paused)
+	}
+
+	if _, resp := runBatchRequest(t, restarted, "/batch", `{"operations":[{"op":"resume","project":"shop"}]}`); !resp.Applied {
+		t.Fatalf("resume: %+v", resp)
+	}
+	var snapshot pausedState
+	if err := restarted.state.Read(pausedStateName, &snapshot); err != nil || len(snapshot.Projects) != 0 {
+		t.Errorf("paused projects after resume = %+v, %v", snapshot, err)
+	}
+}
+
+func TestHandleBatchRollback(t *testing.T) {
+	cl := testLayerWithDocker(t,
+		projectContainer("aaaaaaaaaaaa0000", "web", "shop"),
+		projectContainer("bbbbbbbbbbbb0000", "worker", "shop"))
+	dir := cl.config.TraefikDynamicDir
+
+	// The second config cannot replace a non-empty directory in its place
+	if err := os.MkdirAll(filepath.Join(dir, "bbbbbbbbbbbb.yaml", "keep"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	code, resp := runBatchRequest(t, cl, "/batch", `{"operations":[{"op":"regenerate","project":"shop"}]}`)
+	if code != http.StatusInternalServerError || resp.Applied || resp.Error == "" {
+		t.Fatalf("failed batch: %d %+v", code, resp)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "aaaaaaaaaaaa.yaml")); !os.IsNotExist(err) {
+		t.Errorf("config written before the failure was not rolled back: %v", err)
+	}
+	if len(cl.routes.list()) != 0 {
+		t.Errorf("failed batch recorded routes: %+v", cl.routes.list())
+	}
+}
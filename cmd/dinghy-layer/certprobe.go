@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
+
+const (
+	// DefaultCertProbeTarget is the HTTPS entrypoint certificates are read from
+	DefaultCertProbeTarget = "http-proxy:443"
+
+	// certProbeTimeout bounds a single TLS handshake
+	certProbeTimeout = 5 * time.Second
+
+	// traefikDefaultCertSubject is the common name of the self-signed
+	// certificate Traefik serves when no configured certificate matches
+	traefikDefaultCertSubject = "TRAEFIK DEFAULT CERT"
+)
+
+// Certificate probe outcomes
+const (
+	certStatusOK       = "ok"       // the intended certificate is served and valid
+	certStatusDefault  = "default"  // no certificate covers the host; Traefik's default is served as expected
+	certStatusMismatch = "mismatch" // a certificate covers the host but another one is served
+	certStatusExpired  = "expired"  // the intended certificate is served outside its validity period
+	certStatusError    = "error"    // the handshake failed
+)
+
+// CertProbeResult is the outcome of the last certificate probe of one
+// hostname. IntendedFile is the certificate in the certs directory covering
+// the hostname, empty when there is none.
+type CertProbeResult struct {
+	Hostname      string    `json:"hostname"`
+	ContainerName string    `json:"container_name"`
+	Status        string    `json:"status"`
+	IntendedFile  string    `json:"intended_file,omitempty"`
+	ServedSubject string    `json:"served_subject,omitempty"`
+	ServedSHA256  string    `json:"served_sha256,omitempty"`
+	NotAfter      time.Time `json:"not_after,omitzero"`
+	Error         string    `json:"error,omitempty"`
+	CheckedAt     time.Time `json:"checked_at"`
+}
+
+// intendedCert is a certificate from the certs directory that Traefik loads
+// at startup (one with a matching key file).
+type intendedCert struct {
+	file        string
+	fingerprint string
+	cert        *x509.Certificate
+}
+
+// certProber periodically reads the certificate the HTTPS entrypoint serves
+// for every managed hostname and compares it with the certificate meant to
+// cover it, catching fallbacks to Traefik's default certificate (e.g. a
+// certificate added after Traefik started, or a missing key file).
+type certProber struct {
+	target   string
+	certsDir string
+	interval time.Duration
+	routes   *routeInventory
+	logger   *logger.Logger
+	now      func() time.Time
+
+	mismatch *metrics.Vec
+	expiry   *metrics.Vec
+	total    *metrics.Vec
+
+	mu      sync.RWMutex
+	results map[string]CertProbeResult
+}
+
+// newCertProber creates a prober reading certificates from target (host:port)
+// for the routes in the inventory, registering its metrics in registry.
+func newCertProber(target, certsDir string, interval time.Duration, routes *routeInventory, registry *metrics.Registry, log *logger.Logger) (*certProber, error) {
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return nil, fmt.Errorf("invalid certificate probe target %q: %w", target, err)
+	}
+
+	return &certProber{
+		target:   target,
+		certsDir: certsDir,
+		interval: interval,
+		routes:   routes,
+		logger:   log,
+		now:      time.Now,
+		mismatch: registry.Gauge("http_proxy_cert_mismatch", "Whether the HTTPS entrypoint serves another certificate than the one covering the host.", "host", "container"),
+		expiry:   registry.Gauge("http_proxy_cert_expiry_timestamp_seconds", "Expiry of the certificate served for the host, as a Unix timestamp.", "host"),
+		total:    registry.Counter("http_proxy_cert_probes_total", "Certificate probes by status.", "host", "status"),
+		results:  make(map[string]CertProbeResult),
+	}, nil
+}
+
+// Run probes all hostnames every interval until ctx is done.
+func (p *certProber) Run(ctx context.Context) error {
+	p.logger.Info("Certificate probes enabled", "interval", p.interval, "target", p.target)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			p.probeAll(ctx)
+		}
+	}
+}
+
+// probeAll probes every probeable hostname against the current contents of
+// the certs directory and forgets hostnames that are no longer routed.
+func (p *certProber) probeAll(ctx context.Context) {
+	intended, err := loadIntendedCerts(p.certsDir)
+	if err != nil {
+		p.logger.Debug("Failed to read certificates directory", "dir", p.certsDir, "error", err)
+	}
+
+	current := make(map[string]bool)
+	for _, routes := range p.routes.list() {
+		for _, hostname := range routes.Hostnames {
+			if isWildcardHost(hostname) || current[hostname] {
+				continue
+			}
+			current[hostname] = true
+			p.record(p.probe(ctx, hostname, routes.ContainerName, intended))
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for hostname, result := range p.results {
+		if !current[hostname] {
+			delete(p.results, hostname)
+			p.mismatch.Delete(hostname, result.ContainerName)
+			p.expiry.Delete(hostname)
+			p.total.DeleteMatching(hostname)
+		}
+	}
+}
+
+// probe reads the certificate served for hostname and evaluates it.
+func (p *certProber) probe(ctx context.Context, hostname, containerName string, intended []intendedCert) CertProbeResult {
+	result := CertProbeResult{Hostname: hostname, ContainerName: containerName}
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: certProbeTimeout},
+		// The served certificate is inspected, not trusted
+		Config: &tls.Config{ServerName: hostname, InsecureSkipVerify: true},
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, certProbeTimeout)
+	defer cancel()
+
+	conn, err := dialer.DialContext(dialCtx, "tcp", p.target)
+	result.CheckedAt = p.now().UTC()
+	if err != nil {
+		result.Status = certStatusError
+		result.Error = err.Error()
+		return result
+	}
+	defer conn.Close()
+
+	peers := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(peers) == 0 {
+		result.Status = certStatusError
+		result.Error = "no certificate served"
+		return result
+	}
+
+	served := peers[0]
+	result.ServedSubject = served.Subject.CommonName
+	result.ServedSHA256 = certFingerprint(served)
+	result.NotAfter = served.NotAfter
+	result.Status, result.IntendedFile = evaluateServedCert(hostname, served, intended, p.now())
+	return result
+}
+
+// evaluateServedCert compares the certificate served for hostname with the
+// certificates meant to cover it.
+func evaluateServedCert(hostname string, served *x509.Certificate, intended []intendedCert, now time.Time) (status, intendedFile string) {
+	fingerprint := certFingerprint(served)
+
+	var covering []intendedCert
+	for _, c := range intended {
+		if c.cert.VerifyHostname(hostname) == nil {
+			covering = append(covering, c)
+		}
+	}
+	if len(covering) == 0 {
+		return certStatusDefault, ""
+	}
+
+	for _, c := range covering {
+		if c.fingerprint == fingerprint {
+			if now.Before(served.NotBefore) || now.After(served.NotAfter) {
+				return certStatusExpired, c.file
+			}
+			return certStatusOK, c.file
+		}
+	}
+	return certStatusMismatch, covering[0].file
+}
+
+// record stores a result, updates metrics and logs status transitions.
+func (p *certProber) record(result CertProbeResult) {
+	p.mu.Lock()
+	previous, seen := p.results[result.Hostname]
+	p.results[result.Hostname] = result
+	p.mu.Unlock()
+
+	if seen && previous.ContainerName != result.ContainerName {
+		p.mismatch.Delete(result.Hostname, previous.ContainerName)
+	}
+
+	mismatch := 0.0
+	if result.Status == certStatusMismatch || result.Status == certStatusExpired {
+		mismatch = 1
+	}
+	p.mismatch.Set(mismatch, result.Hostname, result.ContainerName)
+	if !result.NotAfter.IsZero() {
+		p.expiry.Set(float64(result.NotAfter.Unix()), result.Hostname)
+	}
+	p.total.Inc(result.Hostname, result.Status)
+
+	if seen && previous.Status == result.Status {
+		return
+	}
+	switch result.Status {
+	case certStatusMismatch:
+		fallback := ""
+		if result.ServedSubject == traefikDefaultCertSubject {
+			fallback = "Traefik default certificate"
+		}
+		p.logger.Warn("HTTPS entrypoint serves an unexpected certificate",
+			"host", result.Hostname, "intended_file", result.IntendedFile,
+			"served_subject", result.ServedSubject, "fallback", fallback)
+	case certStatusExpired:
+		p.logger.Warn("Served certificate is outside its validity period",
+			"host", result.Hostname, "intended_file", result.IntendedFile, "not_after", result.NotAfter)
+	case certStatusError:
+		p.logger.Debug("Certificate probe failed", "host", result.Hostname, "error", result.Error)
+	default:
+		if seen {
+			p.logger.Info("Served certificate as expected", "host", result.Hostname, "status", result.Status)
+		}
+	}
+}
+
+// result returns the last certificate probe result of a hostname.
+func (p *certProber) result(hostname string) (CertProbeResult, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	result, ok := p.results[hostname]
+	return result, ok
+}
+
+// loadIntendedCerts reads the certificates Traefik loads from dir: .pem and
+// .crt files other than keys that have a "<name>-key.<ext>" or "<name>.key"
+// key file next to them, mirroring the Traefik image entrypoint.
+func loadIntendedCerts(dir string) ([]intendedCert, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []intendedCert
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		if entry.IsDir() || (ext != ".pem" && ext != ".crt") || strings.Contains(name, "-key") {
+			continue
+		}
+		if !hasKeyFile(dir, strings.TrimSuffix(name, ext)) {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		certs = append(certs, intendedCert{file: name, fingerprint: certFingerprint(cert), cert: cert})
+	}
+	return certs, nil
+}
+
+// hasKeyFile reports whether the key file of certificate base exists in dir.
+func hasKeyFile(dir, base string) bool {
+	return keyFile(dir, base) != ""
+}
+
+// keyFile returns the path of the key file of certificate base in dir, or ""
+// when there is none.
+func keyFile(dir, base string) string {
+	for _, candidate := range []string{base + "-key.pem", base + "-key.crt", base + "-key.key", base + ".key"} {
+		path := filepath.Join(dir, candidate)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// certFingerprint returns the hex SHA-256 of a certificate.
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
+
+// testCert returns a self-signed certificate for names, valid from notBefore
+// to notAfter.
+func testCert(t *testing.T, commonName string, names []string, notBefore, notAfter time.Time) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     names,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writeTestCert writes cert to dir/name in PEM form.
+func writeTestCert(t *testing.T, dir, name string, cert tls.Certificate) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEvaluateServedCert(t *testing.T) {
+	now := time.Now()
+	valid := testCert(t, "app", []string{"app.loc", "*.app.loc"}, now.Add(-time.Hour), now.Add(time.Hour))
+	expired := testCert(t, "old", []string{"old.loc"}, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	fallback := testCert(t, traefikDefaultCertSubject, nil, now.Add(-time.Hour), now.Add(time.Hour))
+
+	intended := []intendedCert{
+		{file: "app.pem", fingerprint: certFingerprint(valid.Leaf), cert: valid.Leaf},
+		{file: "old.pem", fingerprint: certFingerprint(expired.Leaf), cert: expired.Leaf},
+	}
+
+	tests := []struct {
+		name         string
+		hostname     string
+		served       *x509.Certificate
+		wantStatus   string
+		wantIntended string
+	}{
+		{"intended served", "app.loc", valid.Leaf, certStatusOK, "app.pem"},
+		{"wildcard covers subdomain", "api.app.loc", valid.Leaf, certStatusOK, "app.pem"},
+		{"default fallback", "app.loc", fallback.Leaf, certStatusMismatch, "app.pem"},
+		{"expired", "old.loc", expired.Leaf, certStatusExpired, "old.pem"},
+		{"no covering certificate", "other.loc", fallback.Leaf, certStatusDefault, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, file := evaluateServedCert(tt.hostname, tt.served, intended, now)
+			if status != tt.wantStatus || file != tt.wantIntended {
+				t.Errorf("got (%q, %q), want (%q, %q)", status, file, tt.wantStatus, tt.wantIntended)
+			}
+		})
+	}
+}
+
+func TestLoadIntendedCerts(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	cert := testCert(t, "app", []string{"app.loc"}, now.Add(-time.Hour), now.Add(time.Hour))
+
+	writeTestCert(t, dir, "app.pem", cert)
+	writeTestCert(t, dir, "app-key.pem", cert)
+	writeTestCert(t, dir, "site.crt", cert)
+	writeTestCert(t, dir, "site.key", cert)
+	// Traefik does not load a certificate without its key
+	writeTestCert(t, dir, "orphan.pem", cert)
+
+	certs, err := loadIntendedCerts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, c := range certs {
+		files = append(files, c.file)
+	}
+	if len(files) != 2 || files[0] != "app.pem" || files[1] != "site.crt" {
+		t.Errorf("loaded %v, want [app.pem site.crt]", files)
+	}
+}
+
+func TestCertProberProbeAll(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	intended := testCert(t, "app", []string{"app.loc"}, now.Add(-time.Hour), now.Add(time.Hour))
+	fallback := testCert(t, traefikDefaultCertSubject, nil, now.Add(-time.Hour), now.Add(time.Hour))
+	writeTestCert(t, dir, "app.pem", intended)
+	writeTestCert(t, dir, "app-key.pem", intended)
+	writeTestCert(t, dir, "stale.pem", intended)
+
+	// The fake entrypoint serves the intended certificate for app.loc only,
+	// falling back to the default one like Traefik does
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = &tls.Config{GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if hello.ServerName == "app.loc" {
+			return &intended, nil
+		}
+		return &fallback, nil
+	}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	routes := newRouteInventory()
+	routes.set(ContainerRoutes{ContainerID: "a", ContainerName: "app", Hostnames: []string{"app.loc", "*.app.loc"}})
+	routes.set(ContainerRoutes{ContainerID: "b", ContainerName: "plain", Hostnames: []string{"plain.loc"}})
+
+	registry := metrics.NewRegistry()
+	p, err := newCertProber(server.Listener.Addr().String(), dir, time.Minute, routes, registry, logger.New("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.probeAll(context.Background())
+
+	for hostname, status := range map[string]string{"app.loc": certStatusOK, "plain.loc": certStatusDefault} {
+		result, ok := p.result(hostname)
+		if !ok {
+			t.Errorf("%s was not probed", hostname)
+			continue
+		}
+		if result.Status != status {
+			t.Errorf("%s status = %q, want %q (%+v)", hostname, result.Status, status, result)
+		}
+	}
+	if _, ok := p.result("*.app.loc"); ok {
+		t.Error("wildcard hosts must not be probed")
+	}
+
+	// A certificate covering plain.loc that Traefik has not loaded is a mismatch
+	plain := testCert(t, "plain", []string{"plain.loc"}, now.Add(-time.Hour), now.Add(time.Hour))
+	writeTestCert(t, dir, "plain.pem", plain)
+	writeTestCert(t, dir, "plain-key.pem", plain)
+	p.probeAll(context.Background())
+
+	result, _ := p.result("plain.loc")
+	if result.Status != certStatusMismatch || result.IntendedFile != "plain.pem" || result.ServedSubject != traefikDefaultCertSubject {
+		t.Errorf("plain.loc = %+v, want mismatch against plain.pem", result)
+	}
+	if got := p.mismatch.Value("plain.loc", "plain"); got != 1 {
+		t.Errorf("cert_mismatch{plain.loc} = %v, want 1", got)
+	}
+	if got := p.expiry.Value("app.loc"); got != float64(intended.Leaf.NotAfter.Unix()) {
+		t.Errorf("cert_expiry{app.loc} = %v, want %v", got, intended.Leaf.NotAfter.Unix())
+	}
+
+	// A removed container's results are dropped
+	routes.remove("b")
+	p.probeAll(context.Background())
+	if _, ok := p.result("plain.loc"); ok {
+		t.Error("result of a removed route was kept")
+	}
+}
+
+func TestCertProberUnreachableTarget(t *testing.T) {
+	routes := newRouteInventory()
+	routes.set(ContainerRoutes{ContainerID: "a", ContainerName: "app", Hostnames: []string{"app.loc"}})
+
+	p, err := newCertProber("127.0.0.1:1", t.TempDir(), time.Minute, routes, metrics.NewRegistry(), logger.New("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.probeAll(context.Background())
+
+	if result, _ := p.result("app.loc"); result.Status != certStatusError || result.Error == "" {
+		t.Errorf("app.loc = %+v, want an error result", result)
+	}
+	if _, err := newCertProber("http-proxy", "", time.Minute, routes, metrics.NewRegistry(), logger.New("test")); err == nil {
+		t.Error("expected an error for a target without port")
+	}
+}
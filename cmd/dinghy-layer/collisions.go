@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// HTTP_PROXY_HOST_COLLISIONS values: how containers serving the same
+// hostname are ordered
+const (
+	collisionsWarn   = "warn"
+	collisionsNewest = "newest"
+	collisionsOldest = "oldest"
+	collisionsWeight = "weight"
+)
+
+// parseCollisionPolicy parses HTTP_PROXY_HOST_COLLISIONS, "warn" when empty.
+func parseCollisionPolicy(value string) (string, error) {
+	switch policy := strings.ToLower(strings.TrimSpace(value)); policy {
+	case "":
+		return collisionsWarn, nil
+	case collisionsWarn, collisionsNewest, collisionsOldest, collisionsWeight:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unsupported HTTP_PROXY_HOST_COLLISIONS %q, expected %s, %s, %s or %s",
+			value, collisionsWarn, collisionsNewest, collisionsOldest, collisionsWeight)
+	}
+}
+
+// hostKey is what two routes collide on: the same hostname and path prefix.
+type hostKey struct {
+	hostname string
+	path     string
+}
+
+func routeKeys(routes ContainerRoutes) []hostKey {
+	keys := make([]hostKey, 0, len(routes.Hostnames))
+	for _, hostname := range routes.Hostnames {
+		keys = append(keys, hostKey{hostname: strings.ToLower(hostname), path: routes.Path})
+	}
+	return keys
+}
+
+// hostCollision is a hostname served by several containers. Containers are
+// ordered by the policy, the winner first; Winner is empty with the warn
+// policy, which leaves the choice to Traefik.
+type hostCollision struct {
+	Hostname   string   `json:"hostname"`
+	Path       string   `json:"path,omitempty"`
+	Containers []string `json:"containers"`
+	Winner     string   `json:"winner,omitempty"`
+	Policy     string   `json:"policy"`
+}
+
+// collisionGroups returns the routes claiming each hostname served by more
+// than one container.
+func collisionGroups(routes []ContainerRoutes) map[hostKey][]ContainerRoutes {
+	claims := make(map[hostKey][]ContainerRoutes)
+	for _, r := range routes {
+		for _, key := range routeKeys(r) {
+			claims[key] = append(claims[key], r)
+		}
+	}
+	for key, group := range claims {
+		if len(group) < 2 {
+			delete(claims, key)
+		}
+	}
+	return claims
+}
+
+// orderClaims sorts the claims of a hostname, the winner first: the newest
+// or oldest container, or the highest VIRTUAL_HOST_WEIGHT with the newest
+// breaking ties. Container names break the remaining ties, so the order is
+// the same whichever container was processed last.
+func orderClaims(group []ContainerRoutes, policy string) {
+	sort.SliceStable(group, func(i, j int) bool {
+		a, b := group[i], group[j]
+		if policy == collisionsWeight && a.Weight != b.Weight {
+			return a.Weight > b.Weight
+		}
+		if !a.Created.Equal(b.Created) {
+			if policy == collisionsOldest {
+				return a.Created.Before(b.Created)
+			}
+			return a.Created.After(b.Created)
+		}
+		return a.ContainerName < b.ContainerName
+	})
+}
+
+// resolvable reports whether the policy can order a route: only generated
+// routes get their priorities raised.
+func resolvable(routes ContainerRoutes) bool {
+	return routes.Source == routeSourceVirtualHost
+}
+
+// collisionWinner returns the container winning a hostname under the policy,
+// or "" when the policy only warns.
+func collisionWinner(group []ContainerRoutes, policy string) string {
+	if policy == collisionsWarn {
+		return ""
+	}
+	var candidates []ContainerRoutes
+	for _, r := range group {
+		if resolvable(r) {
+			candidates = append(candidates, r)
+		}
+	}
+	if len(candidates) < 2 {
+		return ""
+	}
+	orderClaims(candidates, policy)
+	return candidates[0].ContainerID
+}
+
+// listCollisions returns the hostname collisions of routes, sorted by
+// hostname and path.
+func listCollisions(routes []ContainerRoutes, policy string) []hostCollision {
+	result := []hostCollision{}
+	for key, group := range collisionGroups(routes) {
+		orderClaims(group, policy)
+		collision := hostCollision{Hostname: key.hostname, Path: key.path, Policy: policy}
+		winner := collisionWinner(group, policy)
+		for _, r := range group {
+			collision.Containers = append(collision.Containers, r.ContainerName)
+			if r.ContainerID == winner {
+				collision.Winner = r.ContainerName
+			}
+		}
+		result = append(result, collision)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Hostname != result[j].Hostname {
+			return result[i].Hostname < result[j].Hostname
+		}
+		return result[i].Path < result[j].Path
+	})
+	return result
+}
+
+// collisionPolicy returns the configured policy.
+func (cl *CompatibilityLayer) collisionPolicy() string {
+	if policy, err := parseCollisionPolicy(cl.config.HostCollisions); err == nil {
+		return policy
+	}
+	return collisionsWarn
+}
+
+// collisionRouters returns the routers of a container to raise above the
+// other claims of their hostname, and by how much: past the highest weight
+// of the group, so VIRTUAL_HOST_WEIGHT does not outrank the policy. The
+// claim being generated replaces the container's recorded one.
+func (cl *CompatibilityLayer) collisionRouters(claim ContainerRoutes) (map[hostKey]int, bool) {
+	policy := cl.collisionPolicy()
+	if policy == collisionsWarn {
+		return nil, false
+	}
+
+	routes := []ContainerRoutes{claim}
+	for _, r := range cl.routes.list() {
+		if r.ContainerID != claim.ContainerID {
+			routes = append(routes, r)
+		}
+	}
+
+	won := make(map[hostKey]int)
+	for key, group := range collisionGroups(routes) {
+		if collisionWinner(group, policy) != claim.ContainerID {
+			continue
+		}
+		maxWeight := 0
+		for _, r := range group {
+			maxWeight = max(maxWeight, r.Weight)
+		}
+		won[key] = maxWeight - claim.Weight + 1
+	}
+	return won, len(won) > 0
+}
+
+// raiseCollisionWinners raises the priority of the routers of the hostnames
+// a container wins; hostRouters maps each hostname to its router names.
+func raiseCollisionWinners(traefikConfig *config.TraefikConfig, won map[hostKey]int, path string, hostRouters map[string][]string) {
+	for hostname, names := range hostRouters {
+		bonus, ok := won[hostKey{hostname: strings.ToLower(hostname), path: path}]
+		if !ok {
+			continue
+		}
+		for _, name := range names {
+			if router, ok := traefikConfig.HTTP.Routers[name]; ok {
+				router.Priority = routerPriority(router) + bonus
+			}
+		}
+	}
+}
+
+// updateCollisions compares the collisions of the route inventory with the
+// last known ones: new collisions are logged, and the containers that won or
+// lost a hostname are regenerated so their priorities follow the policy. It
+// runs with cl.mu held, like every change of the inventory.
+func (cl *CompatibilityLayer) updateCollisions() {
+	policy := cl.collisionPolicy()
+	collisions := listCollisions(cl.routes.list(), policy)
+
+	known := make(map[hostKey]hostCollision, len(cl.collisions))
+	for _, c := range cl.collisions {
+		known[hostKey{hostname: c.Hostname, path: c.Path}] = c
+	}
+	current := make(map[hostKey]hostCollision, len(collisions))
+	for _, c := range collisions {
+		current[hostKey{hostname: c.Hostname, path: c.Path}] = c
+	}
+	cl.collisions = collisions
+
+	affected := make(map[string]bool)
+	for key, c := range current {
+		previous, ok := known[key]
+		if !ok || strings.Join(previous.Containers, ",") != strings.Join(c.Containers, ",") {
+			args := []any{
+				"hostname", c.Hostname,
+				"containers", strings.Join(c.Containers, ","),
+				"policy", c.Policy,
+			}
+			if c.Path != "" {
+				args = append(args, "path", c.Path)
+			}
+			if c.Winner != "" {
+				args = append(args, "winner", c.Winner)
+			}
+			cl.logger.Warn("HOSTNAME COLLISION: several containers serve the same hostname", args...)
+		}
+		if previous.Winner != c.Winner {
+			affected[previous.Winner] = true
+			affected[c.Winner] = true
+		}
+	}
+	for key, previous := range known {
+		if _, ok := current[key]; !ok {
+			cl.logger.Info("Hostname collision resolved", "hostname", previous.Hostname, "containers", strings.Join(previous.Containers, ","))
+			affected[previous.Winner] = true
+		}
+	}
+	delete(affected, "")
+	if policy == collisionsWarn || len(affected) == 0 {
+		return
+	}
+
+	for _, routes := range cl.routes.list() {
+		if !affected[routes.ContainerName] || !resolvable(routes) {
+			continue
+		}
+		if err := cl.processContainer(context.Background(), routes.ContainerID); err != nil {
+			cl.logger.Error("Failed to regenerate container after a hostname collision changed",
+				"container_id", utils.FormatDockerID(routes.ContainerID),
+				"error", err)
+		}
+	}
+}
+
+// handleCollisions lists the hostnames served by several containers.
+func (cl *CompatibilityLayer) handleCollisions(w http.ResponseWriter, r *http.Request) {
+	cl.mu.Lock()
+	collisions := append([]hostCollision{}, cl.collisions...)
+	cl.mu.Unlock()
+	writeJSON(w, http.StatusOK, collisions)
+}
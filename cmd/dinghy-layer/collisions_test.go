@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+)
+
+func TestParseCollisionPolicy(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"", collisionsWarn, false},
+		{"warn", collisionsWarn, false},
+		{"Newest", collisionsNewest, false},
+		{"oldest", collisionsOldest, false},
+		{"weight", collisionsWeight, false},
+		{"random", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseCollisionPolicy(tt.value)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseCollisionPolicy(%q) = %q, %v, want %q (error %v)", tt.value, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestListCollisions(t *testing.T) {
+	old := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	routes := []ContainerRoutes{
+		{ContainerID: "a", ContainerName: "shop-old", Hostnames: []string{"shop.loc"}, Source: routeSourceVirtualHost, Created: old, Weight: 5},
+		{ContainerID: "b", ContainerName: "shop-new", Hostnames: []string{"Shop.loc", "new.loc"}, Source: routeSourceVirtualHost, Created: old.Add(time.Hour)},
+		{ContainerID: "c", ContainerName: "api", Hostnames: []string{"shop.loc"}, Path: "/api/", Source: routeSourceVirtualHost},
+		{ContainerID: "d", ContainerName: "labels", Hostnames: []string{"new.loc"}, Source: routeSourceTraefikLabels},
+	}
+
+	tests := []struct {
+		policy string
+		want   []hostCollision
+	}{
+		{collisionsWarn, []hostCollision{
+			{Hostname: "new.loc", Containers: []string{"shop-new", "labels"}, Policy: collisionsWarn},
+			{Hostname: "shop.loc", Containers: []string{"shop-new", "shop-old"}, Policy: collisionsWarn},
+		}},
+		{collisionsNewest, []hostCollision{
+			{Hostname: "new.loc", Containers: []string{"shop-new", "labels"}, Policy: collisionsNewest},
+			{Hostname: "shop.loc", Containers: []string{"shop-new", "shop-old"}, Winner: "shop-new", Policy: collisionsNewest},
+		}},
+		{collisionsOldest, []hostCollision{
+			{Hostname: "new.loc", Containers: []string{"labels", "shop-new"}, Policy: collisionsOldest},
+			{Hostname: "shop.loc", Containers: []string{"shop-old", "shop-new"}, Winner: "shop-old", Policy: collisionsOldest},
+		}},
+		{collisionsWeight, []hostCollision{
+			{Hostname: "new.loc", Containers: []string{"shop-new", "labels"}, Policy: collisionsWeight},
+			{Hostname: "shop.loc", Containers: []string{"shop-old", "shop-new"}, Winner: "shop-old", Policy: collisionsWeight},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			if got := listCollisions(routes, tt.policy); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("listCollisions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// createdContainer is a managed container created at the given time.
+func createdContainer(id, name, virtualHost, ip string, created time.Time) types.ContainerJSON {
+	inspect := managedContainer(id, name, virtualHost, ip)
+	inspect.Created = created.Format(time.RFC3339Nano)
+	return inspect
+}
+
+// routerPriorities returns the priority of each router of a container's
+// written config.
+func routerPriorities(t *testing.T, cl *CompatibilityLayer, containerID string) map[string]int {
+	t.Helper()
+	cfg, err := config.LoadTraefikConfigFile(filepath.Join(cl.config.TraefikDynamicDir, cl.configFileName(containerID)))
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	priorities := make(map[string]int)
+	for name, router := range cfg.HTTP.Routers {
+		priorities[name] = router.Priority
+	}
+	return priorities
+}
+
+func TestCollisionPolicyRaisesWinner(t *testing.T) {
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	const oldID, newID = "aaaa56789abcdef00001", "bbbb56789abcdef00002"
+	old := createdContainer(oldID, "shop-old", "shop.loc", "172.0.0.70", created)
+	fresh := createdContainer(newID, "shop-new", "shop.loc", "172.0.0.71", created.Add(time.Hour))
+	rule := rulePriority(hostRule("shop.loc"))
+
+	tests := []struct {
+		policy           string
+		wantOld, wantNew int
+		wantWinner       string
+	}{
+		{collisionsWarn, rule, rule, ""},
+		{collisionsNewest, rule, rule + 1, "shop-new"},
+		{collisionsOldest, rule + 1, rule, "shop-old"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			cl := testLayerWithDocker(t, old, fresh)
+			cl.config.HostCollisions = tt.policy
+			if err := cl.HandleInitialScan(context.Background()); err != nil {
+				t.Fatalf("HandleInitialScan() error = %v", err)
+			}
+
+			for id, want := range map[string]int{oldID: tt.wantOld, newID: tt.wantNew} {
+				for name, priority := range routerPriorities(t, cl, id) {
+					if priority != want {
+						t.Errorf("router %s priority = %d, want %d", name, priority, want)
+					}
+				}
+			}
+
+			rec := httptest.NewRecorder()
+			cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/collisions", nil))
+			var collisions []hostCollision
+			if err := json.NewDecoder(rec.Body).Decode(&collisions); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(collisions) != 1 || collisions[0].Hostname != "shop.loc" || collisions[0].Winner != tt.wantWinner {
+				t.Errorf("collisions = %+v, want shop.loc won by %q", collisions, tt.wantWinner)
+			}
+		})
+	}
+}
+
+func TestCollisionWinnerRegeneratedWhenPeerStops(t *testing.T) {
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	const oldID, newID = "aaaa56789abcdef00001", "bbbb56789abcdef00002"
+	cl := testLayerWithDocker(t,
+		createdContainer(oldID, "shop-old", "shop.loc", "172.0.0.70", created),
+		createdContainer(newID, "shop-new", "shop.loc", "172.0.0.71", created.Add(time.Hour)))
+	cl.config.HostCollisions = collisionsOldest
+	if err := cl.HandleInitialScan(context.Background()); err != nil {
+		t.Fatalf("HandleInitialScan() error = %v", err)
+	}
+
+	cl.mu.Lock()
+	err := cl.removeTraefikConfig(newID)
+	cl.mu.Unlock()
+	if err != nil {
+		t.Fatalf("removeTraefikConfig() error = %v", err)
+	}
+
+	for name, priority := range routerPriorities(t, cl, oldID) {
+		if want := rulePriority(hostRule("shop.loc")); priority != want {
+			t.Errorf("router %s priority = %d after the collision ended, want %d", name, priority, want)
+		}
+	}
+	if len(cl.collisions) != 0 {
+		t.Errorf("collisions = %+v, want none", cl.collisions)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// DefaultProxyContainer is the name of the Traefik container
+const DefaultProxyContainer = "http-proxy"
+
+// Why a network was chosen for a container's backend IP
+const (
+	ipReasonOnly      = "only network"
+	ipReasonPreferred = "preferred network"
+	ipReasonJoined    = "joined by the proxy"
+	ipReasonPriority  = "highest gateway priority"
+	ipReasonName      = "first by name"
+)
+
+// ipSelection is the network a container is reached on and why it was
+// chosen.
+type ipSelection struct {
+	network string
+	ip      string
+	reason  string
+}
+
+// selectContainerIP picks the network Traefik reaches a container on, among
+// those where it has an IP: the first of preferred (network names, in order)
+// the container is attached to, else one the proxy has joined (joined holds
+// network IDs and names), else any. Ties go to the highest gateway priority
+// (compose's gw_priority), then to the lowest network name, so the choice
+// does not depend on map iteration order. The zero value means no network
+// has an IP.
+func selectContainerIP(inspect types.ContainerJSON, preferred []string, joined map[string]bool) ipSelection {
+	if inspect.NetworkSettings == nil {
+		return ipSelection{}
+	}
+
+	var names []string
+	for name, endpoint := range inspect.NetworkSettings.Networks {
+		if endpoint != nil && endpoint.IPAddress != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ipSelection{}
+	}
+	endpoints := inspect.NetworkSettings.Networks
+	sort.Slice(names, func(i, j int) bool {
+		if pi, pj := endpoints[names[i]].GwPriority, endpoints[names[j]].GwPriority; pi != pj {
+			return pi > pj
+		}
+		return names[i] < names[j]
+	})
+	selected := func(name, reason string) ipSelection {
+		return ipSelection{network: name, ip: endpoints[name].IPAddress, reason: reason}
+	}
+
+	if len(names) == 1 {
+		return selected(names[0], ipReasonOnly)
+	}
+	for _, name := range preferred {
+		if endpoint, ok := endpoints[name]; ok && endpoint != nil && endpoint.IPAddress != "" {
+			return selected(name, ipReasonPreferred)
+		}
+	}
+
+	candidates, reason := names, ""
+	var reachable []string
+	for _, name := range names {
+		if joined[name] || joined[endpoints[name].NetworkID] {
+			reachable = append(reachable, name)
+		}
+	}
+	if len(reachable) == 1 {
+		return selected(reachable[0], ipReasonJoined)
+	}
+	if len(reachable) > 1 {
+		candidates, reason = reachable, ipReasonJoined+", "
+	}
+
+	if endpoints[candidates[0]].GwPriority != endpoints[candidates[1]].GwPriority {
+		return selected(candidates[0], reason+ipReasonPriority)
+	}
+	return selected(candidates[0], reason+ipReasonName)
+}
+
+// parsePreferredNetworks parses HTTP_PROXY_PREFERRED_NETWORKS, a
+// comma-separated list of network names.
+func parsePreferredNetworks(spec string) []string {
+	var names []string
+	for _, name := range strings.Split(spec, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// proxyNetworks returns the IDs and names of the networks the proxy is
+// attached to: from inspecting the ProxyContainer, else from the
+// join-networks snapshot, which lags behind the network events. It is empty
+// when neither is available.
+func (cl *CompatibilityLayer) proxyNetworks() map[string]bool {
+	if cl.dockerClient != nil && cl.config.ProxyContainer != "" {
+		ctx, cancel := utils.WithDockerTimeout(context.Background(), cl.dockerTimeout)
+		defer cancel()
+		proxy, err := cl.dockerClient.ContainerInspect(ctx, cl.config.ProxyContainer)
+		if err == nil && proxy.NetworkSettings != nil {
+			joined := make(map[string]bool, 2*len(proxy.NetworkSettings.Networks))
+			for name, endpoint := range proxy.NetworkSettings.Networks {
+				joined[name] = true
+				if endpoint != nil && endpoint.NetworkID != "" {
+					joined[endpoint.NetworkID] = true
+				}
+			}
+			return joined
+		}
+		cl.logger.Debug("Could not inspect the proxy container for its networks",
+			"container", cl.config.ProxyContainer,
+			"error", err)
+	}
+
+	if cl.state != nil {
+		var status networksStatus
+		if err := cl.state.Read(networksStateName, &status); err == nil {
+			joined := make(map[string]bool, 2*len(status.Networks))
+			for _, n := range status.Networks {
+				joined[n.ID] = true
+				joined[n.Name] = true
+			}
+			return joined
+		}
+	}
+	return nil
+}
+
+// isProxyContainer reports whether inspect is the ProxyContainer.
+func (cl *CompatibilityLayer) isProxyContainer(inspect types.ContainerJSON) bool {
+	name := cl.config.ProxyContainer
+	return name != "" && (strings.TrimPrefix(inspect.Name, "/") == name || inspect.ID == name)
+}
+
+// sharesNetwork reports whether the network of a container named network is
+// one of the proxy's.
+func sharesNetwork(inspect types.ContainerJSON, network string, joined map[string]bool) bool {
+	if joined[network] {
+		return true
+	}
+	endpoint := inspect.NetworkSettings.Networks[network]
+	return endpoint != nil && endpoint.NetworkID != "" && joined[endpoint.NetworkID]
+}
+
+// containerIP returns the IP Traefik reaches a container on, logging the
+// network chosen when the container has several, and warning when the proxy
+// is known not to be on it. It returns "" when no network has an IP.
+func (cl *CompatibilityLayer) containerIP(inspect types.ContainerJSON) string {
+	joined := cl.proxyNetworks()
+	selection := selectContainerIP(inspect, cl.config.PreferredNetworks, joined)
+	if selection.ip != "" && selection.reason != ipReasonOnly {
+		cl.logger.Info("Selected container network",
+			"container_id", utils.FormatDockerID(inspect.ID),
+			"network", selection.network,
+			"ip", selection.ip,
+			"reason", selection.reason)
+	}
+	if selection.ip != "" && len(joined) > 0 && !sharesNetwork(inspect, selection.network, joined) {
+		cl.logger.Warn("Container shares no network with the proxy, its IP may be unreachable",
+			"container_id", utils.FormatDockerID(inspect.ID),
+			"network", selection.network,
+			"ip", selection.ip)
+	}
+	return selection.ip
+}
+
+// refreshContainerIP regenerates the config of a container whose backend IP
+// may have changed without it restarting: it was connected to or
+// disconnected from network (empty for other events), restarted or unpaused.
+// Containers whose selected IP still matches their recorded backend are left
+// alone, as are stopped and removed ones; containers not serving routes yet
+// are processed, they may have just become reachable. The proxy joining or
+// leaving a network changes which IP its containers are best reached on, so
+// they are all refreshed.
+func (cl *CompatibilityLayer) refreshContainerIP(ctx context.Context, containerID, network string) error {
+	inspect, err := utils.RetryContainerInspect(ctx, cl.dockerClient, cl.dockerTimeout, containerID)
+	if err != nil {
+		if cerrdefs.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to inspect container %s: %w", containerID, err)
+	}
+	if inspect.State == nil || !inspect.State.Running {
+		return nil
+	}
+	if cl.isProxyContainer(inspect) {
+		if network != "" {
+			return cl.refreshNetworkContainers(ctx, network)
+		}
+		return nil
+	}
+
+	ip := selectContainerIP(inspect, cl.config.PreferredNetworks, cl.proxyNetworks()).ip
+	if routes, ok := cl.routes.get(containerID); ok {
+		backend, err := url.Parse(routes.BackendURL)
+		if err == nil && backend.Hostname() == ip {
+			return nil
+		}
+		cl.logger.Info("Container IP changed, regenerating its config",
+			"container_id", utils.FormatDockerID(containerID),
+			"backend_url", routes.BackendURL,
+			"ip", ip)
+	}
+	return cl.processContainer(ctx, containerID)
+}
+
+// refreshNetworkContainers refreshes the IP of every running container on
+// network, after the proxy joined or left it. Failures are logged so one
+// container cannot hold back the others.
+func (cl *CompatibilityLayer) refreshNetworkContainers(ctx context.Context, network string) error {
+	containers, err := utils.RetryContainerList(ctx, cl.dockerClient, cl.dockerTimeout, container.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("network", network)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list containers on network %s: %w", network, err)
+	}
+
+	cl.logger.Debug("Proxy networks changed, refreshing container IPs",
+		"network", network, "containers", len(containers))
+	for _, c := range containers {
+		if err := cl.refreshContainerIP(ctx, c.ID, ""); err != nil {
+			cl.logger.Warn("Failed to refresh container IP",
+				"container_id", utils.FormatDockerID(c.ID),
+				"network", network,
+				"error", err)
+		}
+	}
+	return nil
+}
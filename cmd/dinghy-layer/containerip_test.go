@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestSelectContainerIP(t *testing.T) {
+	networks := map[string]*network.EndpointSettings{
+		"zeta":    {NetworkID: "z1", IPAddress: "172.0.0.9"},
+		"alpha":   {NetworkID: "a1", IPAddress: "172.0.0.1"},
+		"beta":    {NetworkID: "b1", IPAddress: "172.0.0.2"},
+		"pending": {NetworkID: "p1"},
+	}
+	tests := []struct {
+		name      string
+		networks  map[string]*network.EndpointSettings
+		preferred []string
+		joined    map[string]bool
+		want      ipSelection
+	}{
+		{name: "nil settings"},
+		{name: "no IP", networks: map[string]*network.EndpointSettings{"pending": {}}},
+		{
+			name:     "only network with an IP",
+			networks: map[string]*network.EndpointSettings{"alpha": {}, "beta": {IPAddress: "172.0.0.2"}},
+			want:     ipSelection{network: "beta", ip: "172.0.0.2", reason: ipReasonOnly},
+		},
+		{name: "first by name", networks: networks, want: ipSelection{network: "alpha", ip: "172.0.0.1", reason: ipReasonName}},
+		{
+			name:      "preferred",
+			networks:  networks,
+			preferred: []string{"pending", "missing", "zeta", "beta"},
+			joined:    map[string]bool{"b1": true},
+			want:      ipSelection{network: "zeta", ip: "172.0.0.9", reason: ipReasonPreferred},
+		},
+		{
+			name:     "joined by ID",
+			networks: networks,
+			joined:   map[string]bool{"z1": true, "p1": true},
+			want:     ipSelection{network: "zeta", ip: "172.0.0.9", reason: ipReasonJoined},
+		},
+		{
+			name:     "several joined",
+			networks: networks,
+			joined:   map[string]bool{"zeta": true, "beta": true},
+			want:     ipSelection{network: "beta", ip: "172.0.0.2", reason: ipReasonJoined + ", " + ipReasonName},
+		},
+		{
+			name: "gateway priority",
+			networks: map[string]*network.EndpointSettings{
+				"alpha": {IPAddress: "172.0.0.1"},
+				"beta":  {IPAddress: "172.0.0.2", GwPriority: 10},
+			},
+			want: ipSelection{network: "beta", ip: "172.0.0.2", reason: ipReasonPriority},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inspect := types.ContainerJSON{}
+			if tt.networks != nil {
+				inspect.NetworkSettings = &types.NetworkSettings{Networks: tt.networks}
+			}
+			// Map iteration order must not change the choice
+			for i := 0; i < 20; i++ {
+				if got := selectContainerIP(inspect, tt.preferred, tt.joined); got != tt.want {
+					t.Fatalf("selectContainerIP() = %+v, want %+v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParsePreferredNetworks(t *testing.T) {
+	got := parsePreferredNetworks(" shop_default ,, backend ")
+	if len(got) != 2 || got[0] != "shop_default" || got[1] != "backend" {
+		t.Errorf("parsePreferredNetworks() = %q", got)
+	}
+	if got := parsePreferredNetworks(""); got != nil {
+		t.Errorf("parsePreferredNetworks(\"\") = %q, want nil", got)
+	}
+}
+
+func TestHandleEventRefreshesChangedIP(t *testing.T) {
+	const id = "0123456789abcdef0123"
+	web := managedContainer(id, "web", "web.loc", "172.0.0.5")
+	cl := testLayerWithDocker(t, web)
+	ctx := context.Background()
+	configFile := filepath.Join(cl.config.TraefikDynamicDir, "0123456789ab.yaml")
+
+	networkEvent := func(action events.Action) events.Message {
+		return events.Message{
+			Type:   events.NetworkEventType,
+			Action: action,
+			Actor:  events.Actor{ID: "net1", Attributes: map[string]string{"container": id, "name": "shop_default"}},
+		}
+	}
+	backend := func() string {
+		routes, _ := cl.routes.get(id)
+		return routes.BackendURL
+	}
+
+	if err := cl.HandleEvent(ctx, events.Message{Action: events.ActionStart, Actor: events.Actor{ID: id}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := backend(); got != "http://172.0.0.5:80" {
+		t.Fatalf("backend = %q after start", got)
+	}
+
+	// Connected to a network without changing the selected IP: untouched
+	if err := os.Remove(configFile); err != nil {
+		t.Fatal(err)
+	}
+	web.NetworkSettings.Networks["zeta"] = &network.EndpointSettings{IPAddress: "172.0.1.5"}
+	if err := cl.HandleEvent(ctx, networkEvent(events.ActionConnect)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(configFile); !os.IsNotExist(err) {
+		t.Errorf("config rewritten although the IP did not change: %v", err)
+	}
+
+	// Disconnected from the network it was reached on: regenerated
+	delete(web.NetworkSettings.Networks, "default")
+	if err := cl.HandleEvent(ctx, networkEvent(events.ActionDisconnect)); err != nil {
+		t.Fatal(err)
+	}
+	if got := backend(); got != "http://172.0.1.5:80" {
+		t.Errorf("backend = %q after disconnect, want the remaining network", got)
+	}
+	if _, err := os.Stat(configFile); err != nil {
+		t.Errorf("config not regenerated: %v", err)
+	}
+
+	// Removed containers are ignored
+	missing := networkEvent(events.ActionDisconnect)
+	missing.Actor.Attributes["container"] = "fedcba9876543210fedc"
+	if err := cl.HandleEvent(ctx, missing); err != nil {
+		t.Errorf("HandleEvent() for a removed container error = %v", err)
+	}
+}
+
+func TestHandleEventRefreshesProxyNetwork(t *testing.T) {
+	proxy := inspectWithIP("/http-proxy", "172.0.1.2")
+	proxy.ID = "eeee56789abcdef00001"
+	proxy.State = &types.ContainerState{Running: true}
+	proxy.NetworkSettings.Networks = map[string]*network.EndpointSettings{
+		"alpha": {NetworkID: "a1", IPAddress: "172.0.1.2"},
+	}
+	const id = "aaaa56789abcdef00001"
+	web := managedContainer(id, "web", "web.loc", "172.0.0.5")
+	web.NetworkSettings.Networks = map[string]*network.EndpointSettings{
+		"alpha":   {NetworkID: "a1", IPAddress: "172.0.1.5"},
+		"private": {NetworkID: "p1", IPAddress: "172.0.2.5"},
+	}
+
+	cl := testLayerWithDocker(t, proxy, web)
+	cl.config.ProxyContainer = "http-proxy"
+	ctx := context.Background()
+	if err := cl.HandleEvent(ctx, events.Message{Action: events.ActionStart, Actor: events.Actor{ID: id}}); err != nil {
+		t.Fatal(err)
+	}
+	backend := func() string {
+		routes, _ := cl.routes.get(id)
+		return routes.BackendURL
+	}
+	if got := backend(); got != "http://172.0.1.5:80" {
+		t.Fatalf("backend = %q after start, want the network shared with the proxy", got)
+	}
+
+	// The proxy moves from alpha to private: the event is about the proxy,
+	// but the containers on the network are the ones to regenerate
+	proxy.NetworkSettings.Networks["private"] = &network.EndpointSettings{NetworkID: "p1", IPAddress: "172.0.2.2"}
+	delete(proxy.NetworkSettings.Networks, "alpha")
+	err := cl.HandleEvent(ctx, events.Message{
+		Type:   events.NetworkEventType,
+		Action: events.ActionDisconnect,
+		Actor:  events.Actor{ID: "a1", Attributes: map[string]string{"container": proxy.ID, "name": "alpha"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := backend(); got != "http://172.0.2.5:80" {
+		t.Errorf("backend = %q after the proxy left alpha, want the network it moved to", got)
+	}
+}
+
+func TestContainerIPInspectsProxyWithoutSnapshot(t *testing.T) {
+	proxy := inspectWithIP("/http-proxy", "172.0.2.2")
+	proxy.ID = "eeee56789abcdef00001"
+	proxy.NetworkSettings.Networks = map[string]*network.EndpointSettings{
+		"shop_default": {NetworkID: "s1", IPAddress: "172.0.2.2"},
+	}
+	web := managedContainer("aaaa56789abcdef00001", "web", "web.loc", "172.0.0.5")
+	web.NetworkSettings.Networks = map[string]*network.EndpointSettings{
+		"alpha":   {NetworkID: "a1", IPAddress: "172.0.1.5"},
+		"private": {NetworkID: "s1", IPAddress: "172.0.2.5"},
+	}
+
+	cl := testLayerWithDocker(t, proxy, web)
+	cl.config.ProxyContainer = "http-proxy"
+	if got := cl.containerIP(web); got != "172.0.2.5" {
+		t.Errorf("containerIP() = %q, want the IP on the network shared with the proxy", got)
+	}
+
+	// Without the proxy's networks the choice falls back to the network name
+	cl.config.ProxyContainer = "missing"
+	if got := cl.containerIP(web); got != "172.0.1.5" {
+		t.Errorf("containerIP() = %q without the proxy networks, want the first network by name", got)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// corsAnyOrigin is the CORS_ALLOW_ORIGINS value admitting every origin
+const corsAnyOrigin = "*"
+
+// Defaults for the CORS variables a container leaves unset: the methods and
+// request headers SPAs commonly send to their API
+var (
+	defaultCORSMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	defaultCORSHeaders = []string{"Accept", "Authorization", "Content-Type", "X-Requested-With"}
+)
+
+// parseCORS builds the headers middleware answering CORS requests, or nil
+// when CORS_ALLOW_ORIGINS is unset. Origins are "*" or scheme://host[:port]
+// entries; CORS_ALLOW_METHODS and CORS_ALLOW_HEADERS are comma-separated
+// names. Credentials cannot be allowed for any origin, which browsers reject.
+func parseCORS(origins, methods, headers, credentials, maxAge string) (*config.HeadersMiddleware, error) {
+	originList := splitList(origins)
+	if len(originList) == 0 {
+		return nil, nil
+	}
+	for _, origin := range originList {
+		if origin != corsAnyOrigin && !validOrigin(origin) {
+			return nil, fmt.Errorf("invalid CORS_ALLOW_ORIGINS entry %q, expected * or scheme://host[:port]", origin)
+		}
+	}
+
+	methodList := defaultCORSMethods
+	if list := splitList(methods); len(list) > 0 {
+		methodList = nil
+		for _, method := range list {
+			if !headerNamePattern.MatchString(method) {
+				return nil, fmt.Errorf("invalid CORS_ALLOW_METHODS entry %q", method)
+			}
+			methodList = append(methodList, strings.ToUpper(method))
+		}
+	}
+
+	headerList := defaultCORSHeaders
+	if list := splitList(headers); len(list) > 0 {
+		for _, header := range list {
+			if header != "*" && !headerNamePattern.MatchString(header) {
+				return nil, fmt.Errorf("invalid CORS_ALLOW_HEADERS entry %q", header)
+			}
+		}
+		headerList = list
+	}
+
+	middleware := &config.HeadersMiddleware{
+		AccessControlAllowOriginList: originList,
+		AccessControlAllowMethods:    methodList,
+		AccessControlAllowHeaders:    headerList,
+	}
+
+	anyOrigin := slices.Contains(originList, corsAnyOrigin)
+	switch strings.ToLower(strings.TrimSpace(credentials)) {
+	case "", "false":
+	case "true":
+		if anyOrigin {
+			return nil, fmt.Errorf("CORS_ALLOW_CREDENTIALS=true requires explicit CORS_ALLOW_ORIGINS, browsers reject credentials for *")
+		}
+		allow := true
+		middleware.AccessControlAllowCredentials = &allow
+	default:
+		return nil, fmt.Errorf("invalid CORS_ALLOW_CREDENTIALS %q, expected true or false", credentials)
+	}
+
+	if maxAge = strings.TrimSpace(maxAge); maxAge != "" {
+		seconds, err := strconv.ParseInt(maxAge, 10, 64)
+		if err != nil || seconds < 0 {
+			return nil, fmt.Errorf("invalid CORS_MAX_AGE %q, expected a number of seconds", maxAge)
+		}
+		middleware.AccessControlMaxAge = &seconds
+	}
+
+	// Answers name the origin asking, so caches must not share them
+	if !anyOrigin {
+		middleware.Extra = map[string]interface{}{"addVaryHeader": true}
+	}
+	return middleware, nil
+}
+
+// validOrigin reports whether origin is a bare scheme://host[:port].
+func validOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		u.Path == "" && u.RawQuery == "" && u.Fragment == "" && u.User == nil
+}
+
+// splitList splits a comma-separated variable, dropping empty entries.
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// corsMiddlewareName returns the name of the middleware answering a
+// service's CORS requests.
+func corsMiddlewareName(serviceName string) string {
+	return serviceName + "-cors"
+}
+
+// addCORSMiddleware defines the CORS middleware of a service and puts it
+// first on all of the service's routers, so preflight requests, which carry
+// no credentials, are answered before the auth middlewares.
+func addCORSMiddleware(traefikConfig *config.TraefikConfig, serviceName string, cors *config.HeadersMiddleware) {
+	if cors == nil {
+		return
+	}
+
+	name := corsMiddlewareName(serviceName)
+	traefikConfig.HTTP.Middlewares[name] = &config.Middleware{Headers: cors}
+	for _, router := range traefikConfig.HTTP.Routers {
+		if isContainerService(router.Service, serviceName) {
+			router.Middlewares = append([]string{name}, router.Middlewares...)
+		}
+	}
+}
+
+// cors parses a container's CORS settings, logging invalid ones, which are
+// ignored as a whole.
+func (cl *CompatibilityLayer) cors(containerInfo ContainerInfo) *config.HeadersMiddleware {
+	cors, err := parseCORS(containerInfo.CORSAllowOrigins, containerInfo.CORSAllowMethods,
+		containerInfo.CORSAllowHeaders, containerInfo.CORSAllowCredentials, containerInfo.CORSMaxAge)
+	if err != nil {
+		cl.logger.Warn("Ignoring CORS settings",
+			"container_id", utils.FormatDockerID(containerInfo.ID),
+			"error", err)
+		return nil
+	}
+	return cors
+}
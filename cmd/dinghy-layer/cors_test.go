@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+)
+
+func TestParseCORS(t *testing.T) {
+	allow := true
+	maxAge := int64(600)
+
+	tests := []struct {
+		name                                           string
+		origins, methods, headers, credentials, maxAge string
+		want                                           *config.HeadersMiddleware
+		wantErr                                        bool
+	}{
+		{name: "disabled", methods: "GET"},
+		{
+			name:    "any origin",
+			origins: "*",
+			want: &config.HeadersMiddleware{
+				AccessControlAllowOriginList: []string{"*"},
+				AccessControlAllowMethods:    defaultCORSMethods,
+				AccessControlAllowHeaders:    defaultCORSHeaders,
+			},
+		},
+		{
+			name:        "explicit",
+			origins:     "https://app.loc, http://localhost:3000",
+			methods:     "get,post",
+			headers:     "Content-Type, X-Api-Key",
+			credentials: "true",
+			maxAge:      "600",
+			want: &config.HeadersMiddleware{
+				AccessControlAllowOriginList:  []string{"https://app.loc", "http://localhost:3000"},
+				AccessControlAllowMethods:     []string{"GET", "POST"},
+				AccessControlAllowHeaders:     []string{"Content-Type", "X-Api-Key"},
+				AccessControlAllowCredentials: &allow,
+				AccessControlMaxAge:           &maxAge,
+				Extra:                         map[string]interface{}{"addVaryHeader": true},
+			},
+		},
+		{name: "origin with path", origins: "https://app.loc/", wantErr: true},
+		{name: "origin without scheme", origins: "app.loc", wantErr: true},
+		{name: "bad method", origins: "*", methods: "GET POST", wantErr: true},
+		{name: "bad header", origins: "*", headers: "X:Y", wantErr: true},
+		{name: "credentials for any origin", origins: "*", credentials: "true", wantErr: true},
+		{name: "bad credentials", origins: "https://app.loc", credentials: "yes", wantErr: true},
+		{name: "bad max age", origins: "*", maxAge: "-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCORS(tt.origins, tt.methods, tt.headers, tt.credentials, tt.maxAge)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCORS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCORS() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateTraefikConfigCORS(t *testing.T) {
+	cl := testLayer()
+	info := ContainerInfo{
+		Name:             "api",
+		VirtualHost:      "api.loc",
+		CORSAllowOrigins: "https://app.loc",
+		BasicAuth:        "dev:$2y$05$abcdefghijklmnopqrstuu5s8uGOWbm5RHXIYHQ5eOtvRCXyOCPz.",
+	}
+
+	cfg := cl.generateTraefikConfig(inspectWithIP("/api", "172.0.0.30"), info)
+
+	mw, ok := cfg.HTTP.Middlewares["api-cors"]
+	if !ok || mw.Headers == nil || !reflect.DeepEqual(mw.Headers.AccessControlAllowOriginList, []string{"https://app.loc"}) {
+		t.Fatalf("api-cors middleware = %+v", mw)
+	}
+	for name, router := range cfg.HTTP.Routers {
+		if len(router.Middlewares) < 2 || router.Middlewares[0] != "api-cors" || router.Middlewares[1] != "api-auth" {
+			t.Errorf("%s middlewares = %v, want CORS before auth", name, router.Middlewares)
+		}
+	}
+
+	info.CORSAllowOrigins = "app.loc"
+	cfg = cl.generateTraefikConfig(inspectWithIP("/api", "172.0.0.30"), info)
+	if _, ok := cfg.HTTP.Middlewares["api-cors"]; ok || len(cfg.HTTP.Routers) == 0 {
+		t.Errorf("invalid CORS settings: middlewares %v, %d routers; want routes without CORS", cfg.HTTP.Middlewares, len(cfg.HTTP.Routers))
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultWriteDebounce is how long event-driven config writes wait for
+	// more events before they are written
+	DefaultWriteDebounce = "200ms"
+
+	// writeDebounceMaxPeriods bounds the wait of a burst to this many debounce
+	// periods after its first event, so a steady stream of events cannot hold
+	// the writes back forever
+	writeDebounceMaxPeriods = 10
+)
+
+// writeBuffer collects the config writes and removals of Docker event
+// bursts, such as a compose up of many containers, so Traefik reloads once
+// per burst instead of once per container. A file buffered twice keeps only
+// its last content.
+type writeBuffer struct {
+	debounce time.Duration
+	files    map[string][]byte // by config file name, nil to remove
+	first    time.Time
+	timer    *time.Timer
+}
+
+// newWriteBuffer returns a buffer writing debounce after the last event, or
+// nil when debounce is not positive.
+func newWriteBuffer(debounce time.Duration) *writeBuffer {
+	if debounce <= 0 {
+		return nil
+	}
+	return &writeBuffer{debounce: debounce, files: make(map[string][]byte)}
+}
+
+// put buffers the content of a config file, or its removal when data is nil.
+func (b *writeBuffer) put(name string, data []byte) {
+	if len(b.files) == 0 {
+		b.first = time.Now()
+	}
+	b.files[name] = data
+}
+
+// forget drops the buffered change of a file written directly, so a flush
+// cannot overwrite it with older content. It is a no-op on a nil buffer.
+func (b *writeBuffer) forget(name string) {
+	if b != nil {
+		delete(b.files, name)
+	}
+}
+
+// delay returns how long to wait before flushing: the debounce period, cut
+// short when the burst has reached its maximum wait.
+func (b *writeBuffer) delay(now time.Time) time.Duration {
+	remaining := b.first.Add(writeDebounceMaxPeriods * b.debounce).Sub(now)
+	return max(min(b.debounce, remaining), 0)
+}
+
+// bufferingWrites buffers the config writes of fn when debouncing is enabled
+// and schedules their flush. Callers hold cl.mu.
+func (cl *CompatibilityLayer) bufferingWrites(fn func() error) error {
+	if cl.writes == nil || cl.config.DryRun {
+		return fn()
+	}
+
+	cl.buffering = true
+	err := fn()
+	cl.buffering = false
+
+	if len(cl.writes.files) == 0 {
+		return err
+	}
+	if cl.writes.timer != nil {
+		cl.writes.timer.Stop()
+	}
+	cl.writes.timer = time.AfterFunc(cl.writes.delay(time.Now()), func() {
+		cl.mu.Lock()
+		defer cl.mu.Unlock()
+		cl.flushWrites()
+	})
+	return err
+}
+
+// flushWrites writes and removes the buffered config files in one pass.
+// Failures are logged: the files are regenerated on the next event or
+// reconciliation. Callers hold cl.mu.
+func (cl *CompatibilityLayer) flushWrites() {
+	if cl.writes == nil || len(cl.writes.files) == 0 {
+		return
+	}
+	if cl.writes.timer != nil {
+		cl.writes.timer.Stop()
+		cl.writes.timer = nil
+	}
+
+	names := make([]string, 0, len(cl.writes.files))
+	for name := range cl.writes.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var written, removed int
+	for _, name := range names {
+		data := cl.writes.files[name]
+		delete(cl.writes.files, name)
+
+		if data == nil {
+			err := os.Remove(filepath.Join(cl.config.TraefikDynamicDir, name))
+			if err == nil {
+				removed++
+			} else if !os.IsNotExist(err) {
+				cl.logger.Error("Failed to remove Traefik config file", "config_file", name, "error", err)
+			}
+			continue
+		}
+
+		if _, err := cl.writeDynamicFile(name, data); err != nil {
+			cl.logger.Error("Failed to write Traefik config file", "config_file", name, "error", err)
+			continue
+		}
+		written++
+	}
+
+	cl.logger.Info("Flushed debounced Traefik configuration",
+		"written", written,
+		"removed", removed,
+		"waited", time.Since(cl.writes.first).Round(time.Millisecond))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+)
+
+func TestWriteBufferDelay(t *testing.T) {
+	if newWriteBuffer(0) != nil {
+		t.Error("newWriteBuffer(0) enabled debouncing")
+	}
+
+	b := newWriteBuffer(100 * time.Millisecond)
+	start := time.Now()
+	b.put("a.yaml", []byte("a"))
+	b.put("b.yaml", nil)
+
+	tests := []struct {
+		elapsed time.Duration
+		want    time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{950 * time.Millisecond, 50 * time.Millisecond},
+		{2 * time.Second, 0},
+	}
+	for _, tt := range tests {
+		if got := b.delay(start.Add(tt.elapsed)); got.Round(time.Millisecond) != tt.want {
+			t.Errorf("delay after %v = %v, want %v", tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func TestHandleEventDebouncesWrites(t *testing.T) {
+	cl := testLayerWithDocker(t,
+		managedContainer("aaaaaaaaaaaa0000", "web", "web.loc", "172.0.0.81"),
+		managedContainer("bbbbbbbbbbbb0000", "api", "api.loc", "172.0.0.82"))
+	cl.writes = newWriteBuffer(time.Hour)
+	dir := cl.config.TraefikDynamicDir
+	if err := os.WriteFile(dir+"/cccccccccccc.yaml", []byte("http: {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ev := range []events.Message{
+		{Action: events.ActionStart, Actor: events.Actor{ID: "aaaaaaaaaaaa0000"}},
+		{Action: events.ActionStart, Actor: events.Actor{ID: "bbbbbbbbbbbb0000"}},
+		{Action: events.ActionDie, Actor: events.Actor{ID: "cccccccccccc0000"}},
+	} {
+		if err := cl.HandleEvent(context.Background(), ev); err != nil {
+			t.Fatalf("HandleEvent(%s) error = %v", ev.Action, err)
+		}
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 || entries[0].Name() != "cccccccccccc.yaml" {
+		t.Fatalf("files before the flush = %v, want the untouched old config only", entries)
+	}
+	if len(cl.routes.list()) != 2 {
+		t.Errorf("inventory has %d routes, want them recorded before the flush", len(cl.routes.list()))
+	}
+
+	cl.flushWrites()
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 || entries[0].Name() != "aaaaaaaaaaaa.yaml" || entries[1].Name() != "bbbbbbbbbbbb.yaml" {
+		t.Errorf("files after the flush = %v, want the two written configs", entries)
+	}
+}
+
+func TestDirectWriteDropsBufferedWrite(t *testing.T) {
+	cl := testLayerWithDocker(t, managedContainer("aaaaaaaaaaaa0000", "web", "web.loc", "172.0.0.81"))
+	cl.writes = newWriteBuffer(time.Hour)
+
+	if err := cl.HandleEvent(context.Background(), events.Message{Action: events.ActionStart, Actor: events.Actor{ID: "aaaaaaaaaaaa0000"}}); err != nil {
+		t.Fatal(err)
+	}
+	// A regenerate through the admin API writes at once
+	if err := cl.processContainer(context.Background(), "aaaaaaaaaaaa0000"); err != nil {
+		t.Fatal(err)
+	}
+	if len(cl.writes.files) != 0 {
+		t.Errorf("buffered writes = %v, want the direct write to replace them", cl.writes.files)
+	}
+	cl.flushWrites()
+}
+
+func TestWriteDynamicFileLeavesNoTemporaryFiles(t *testing.T) {
+	cl := testLayerWithDocker(t)
+	for i := 0; i < 2; i++ {
+		if _, err := cl.writeDynamicFile("web.yaml", []byte("http: {}\n")); err != nil {
+			t.Fatalf("writeDynamicFile() error = %v", err)
+		}
+	}
+	entries, _ := os.ReadDir(cl.config.TraefikDynamicDir)
+	if len(entries) != 1 || entries[0].Name() != "web.yaml" {
+		t.Errorf("files = %v, want web.yaml only", entries)
+	}
+	if info, err := os.Stat(cl.config.TraefikDynamicDir + "/web.yaml"); err != nil || info.Mode().Perm() != ConfigFilePermissions {
+		t.Errorf("web.yaml mode = %v, %v, want %v", info.Mode().Perm(), err, ConfigFilePermissions)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultCertFileName is the dynamic config file setting the default
+	// certificate of Traefik's TLS store; the reconciler's container file
+	// pattern never matches it
+	defaultCertFileName = "default-cert.yaml"
+
+	// defaultCertAuto picks the wildcard certificate of the certificates
+	// directory as the default certificate
+	defaultCertAuto = "auto"
+
+	// defaultCertPollInterval is how often "auto" looks for a new wildcard
+	// certificate in the certificates directory
+	defaultCertPollInterval = 5 * time.Second
+)
+
+// defaultCertificate returns the certificate named by HTTP_PROXY_DEFAULT_CERT
+// in dir, looked up like CERT_NAME, or with "auto" the first valid wildcard
+// certificate by file name. It returns nil when "auto" finds none.
+func defaultCertificate(dir, name string, now time.Time) (*config.TLSCertificate, error) {
+	if name != defaultCertAuto {
+		return nginxProxySettings{certName: name}.certificate(dir)
+	}
+
+	certs, err := loadIntendedCerts(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificates directory: %w", err)
+	}
+	sort.Slice(certs, func(i, j int) bool { return certs[i].file < certs[j].file })
+	for _, c := range certs {
+		if now.Before(c.cert.NotBefore) || now.After(c.cert.NotAfter) {
+			continue
+		}
+		for _, san := range c.cert.DNSNames {
+			if strings.HasPrefix(san, "*.") {
+				base := strings.TrimSuffix(c.file, filepath.Ext(c.file))
+				return &config.TLSCertificate{CertFile: filepath.Join(dir, c.file), KeyFile: keyFile(dir, base)}, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// defaultCertConfig returns the dynamic config making cert the default
+// certificate of Traefik's default TLS store.
+func defaultCertConfig(cert *config.TLSCertificate) *config.TraefikConfig {
+	return &config.TraefikConfig{
+		TLS: &config.TLSConfig{
+			Stores: map[string]*config.TLSStore{
+				config.DefaultTLSStore: {DefaultCertificate: cert},
+			},
+		},
+	}
+}
+
+// applyDefaultCert writes the default certificate config when it changed, or
+// removes it when no certificate is found, leaving Traefik's self-signed
+// fallback. Callers hold cl.mu.
+func (cl *CompatibilityLayer) applyDefaultCert() error {
+	if cl.config.DefaultCert == "" {
+		return nil
+	}
+
+	cert, err := defaultCertificate(cl.config.CertsDir, cl.config.DefaultCert, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if cert != nil {
+		cfg := defaultCertConfig(cert)
+		if err := cfg.Validate(); err != nil {
+			return fmt.Errorf("invalid default certificate config: %w", err)
+		}
+		if data, err = yaml.Marshal(cfg); err != nil {
+			return fmt.Errorf("failed to marshal Traefik config: %w", err)
+		}
+	}
+
+	current, err := cl.readConfigFile(defaultCertFileName)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(current, data) {
+		return nil
+	}
+	if cl.config.DryRun {
+		if action := cl.printPlan(defaultCertFileName, data); action != "" {
+			cl.logger.Info("DRY RUN: Would update the default certificate",
+				"config_file", defaultCertFileName,
+				"action", action)
+		}
+		return nil
+	}
+
+	if cert == nil {
+		cl.logger.Warn("No wildcard certificate found, Traefik serves its self-signed default certificate", "dir", cl.config.CertsDir)
+		err := os.Remove(filepath.Join(cl.config.TraefikDynamicDir, defaultCertFileName))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove default certificate config: %w", err)
+		}
+		return nil
+	}
+	if _, err := cl.writeDynamicFile(defaultCertFileName, data); err != nil {
+		return err
+	}
+	cl.logger.Info("Set the default certificate", "cert_file", cert.CertFile)
+	return nil
+}
+
+// runDefaultCertWatcher re-picks the "auto" default certificate until ctx is
+// cancelled, so a wildcard certificate issued, renewed or removed is served
+// without waiting for a reconciliation.
+func (cl *CompatibilityLayer) runDefaultCertWatcher(ctx context.Context) error {
+	ticker := time.NewTicker(defaultCertPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			cl.mu.Lock()
+			err := cl.applyDefaultCert()
+			cl.mu.Unlock()
+			if err != nil {
+				cl.logger.Error("Failed to set the default certificate", "error", err)
+			}
+		}
+	}
+}
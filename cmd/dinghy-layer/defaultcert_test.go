@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+)
+
+func TestDefaultCertificate(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+	valid := testCert(t, "loc", []string{"*.loc", "loc"}, now.Add(-time.Hour), now.Add(time.Hour))
+	expired := testCert(t, "dev", []string{"*.dev"}, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	plain := testCert(t, "app", []string{"app.loc"}, now.Add(-time.Hour), now.Add(time.Hour))
+	writeTestCert(t, dir, "a-expired.pem", expired)
+	writeTestCert(t, dir, "a-expired-key.pem", expired)
+	writeTestCert(t, dir, "app.crt", plain)
+	writeTestCert(t, dir, "app.key", plain)
+	writeTestCert(t, dir, "wildcard.pem", valid)
+	writeTestCert(t, dir, "wildcard-key.pem", valid)
+
+	tests := []struct {
+		name    string
+		value   string
+		want    *config.TLSCertificate
+		wantErr bool
+	}{
+		{
+			name:  "auto picks the valid wildcard certificate",
+			value: defaultCertAuto,
+			want:  &config.TLSCertificate{CertFile: filepath.Join(dir, "wildcard.pem"), KeyFile: filepath.Join(dir, "wildcard-key.pem")},
+		},
+		{
+			name:  "named certificate",
+			value: "app",
+			want:  &config.TLSCertificate{CertFile: filepath.Join(dir, "app.crt"), KeyFile: filepath.Join(dir, "app.key")},
+		},
+		{name: "missing certificate", value: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := defaultCertificate(dir, tt.value, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("defaultCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want == nil {
+				return
+			}
+			if got == nil || got.CertFile != tt.want.CertFile || got.KeyFile != tt.want.KeyFile {
+				t.Errorf("defaultCertificate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if got, err := defaultCertificate(t.TempDir(), defaultCertAuto, now); got != nil || err != nil {
+		t.Errorf("defaultCertificate() without wildcard = %+v, %v, want nil", got, err)
+	}
+}
+
+func TestApplyDefaultCert(t *testing.T) {
+	now := time.Now()
+	cl := testLayer()
+	cl.config.TraefikDynamicDir = t.TempDir()
+	cl.config.CertsDir = t.TempDir()
+	cl.config.DefaultCert = defaultCertAuto
+	configFile := filepath.Join(cl.config.TraefikDynamicDir, defaultCertFileName)
+
+	if err := cl.applyDefaultCert(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(configFile); !os.IsNotExist(err) {
+		t.Fatalf("config written without a wildcard certificate: %v", err)
+	}
+
+	cert := testCert(t, "loc", []string{"*.loc"}, now.Add(-time.Hour), now.Add(time.Hour))
+	writeTestCert(t, cl.config.CertsDir, "wildcard.pem", cert)
+	writeTestCert(t, cl.config.CertsDir, "wildcard-key.pem", cert)
+	if err := cl.applyDefaultCert(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatalf("default certificate config not written: %v", err)
+	}
+	cfg, err := config.ParseTraefikConfig(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := cfg.TLS.Stores[config.DefaultTLSStore]
+	if store == nil || store.DefaultCertificate == nil || store.DefaultCertificate.CertFile != filepath.Join(cl.config.CertsDir, "wildcard.pem") {
+		t.Errorf("config = %s, want the wildcard certificate as default", data)
+	}
+
+	// The config goes away with the certificate
+	os.Remove(filepath.Join(cl.config.CertsDir, "wildcard.pem"))
+	if err := cl.applyDefaultCert(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(configFile); !os.IsNotExist(err) {
+		t.Errorf("config kept after the certificate was removed: %v", err)
+	}
+
+	cl.config.DefaultCert = "missing"
+	if err := cl.applyDefaultCert(); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("applyDefaultCert() error = %v, want the missing certificate", err)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Types of the events streamed by GET /events
+const (
+	eventContainerRouted = "container.routed"
+	eventRouteRemoved    = "route.removed"
+	eventNetworkJoined   = "network.joined"
+	eventNetworkLeft     = "network.left"
+	eventCertGenerated   = "cert.generated"
+)
+
+const (
+	// eventHistorySize is how many past events are kept for clients that
+	// reconnect with Last-Event-ID
+	eventHistorySize = 256
+
+	// eventSubscriberBuffer is how many events a slow client may lag behind
+	// before it is disconnected
+	eventSubscriberBuffer = 64
+
+	// eventPollInterval is how often the join-networks snapshot and the certs
+	// directory, written by other services, are checked for changes
+	eventPollInterval = 2 * time.Second
+
+	// eventKeepAlive is how often an idle stream gets a comment, so proxies
+	// and clients do not time it out
+	eventKeepAlive = 15 * time.Second
+)
+
+// proxyEvent is a change of the proxy state. Only the fields of its type are
+// set: the container fields for routes, Network for networks and
+// Certificate for certificates.
+type proxyEvent struct {
+	ID            uint64      `json:"id"`
+	Type          string      `json:"type"`
+	Timestamp     time.Time   `json:"timestamp"`
+	ContainerID   string      `json:"container_id,omitempty"`
+	ContainerName string      `json:"container_name,omitempty"`
+	Hostnames     []string    `json:"hostnames,omitempty"`
+	Path          string      `json:"path,omitempty"`
+	BackendURL    string      `json:"backend_url,omitempty"`
+	Source        string      `json:"source,omitempty"`
+	Network       *networkRef `json:"network,omitempty"`
+	Certificate   string      `json:"certificate,omitempty"`
+}
+
+// eventBroker fans proxy events out to the GET /events streams and keeps a
+// short history for reconnecting clients. It also holds the last state seen
+// of each source, so changes are published as differences. It is safe for
+// concurrent use.
+type eventBroker struct {
+	mu          sync.Mutex
+	nextID      uint64
+	history     []proxyEvent
+	subscribers map[chan proxyEvent]struct{}
+	closed      bool
+
+	routes   map[string]ContainerRoutes
+	networks map[string]networkRef
+	certs    map[string]string
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		nextID:      1,
+		subscribers: make(map[chan proxyEvent]struct{}),
+		routes:      make(map[string]ContainerRoutes),
+	}
+}
+
+// publish numbers an event and sends it to every subscriber. A subscriber
+// whose buffer is full is dropped; it reconnects and replays what it missed.
+func (b *eventBroker) publish(ev proxyEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+
+	ev.ID = b.nextID
+	b.nextID++
+	if ev.Timestamp.IsZero() {
+		ev.Timestamp = time.Now().UTC()
+	}
+	b.history = append(b.history, ev)
+	if len(b.history) > eventHistorySize {
+		b.history = b.history[len(b.history)-eventHistorySize:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns the events after lastID still in the history and a
+// channel receiving the next ones, closed when the broker closes or the
+// subscriber falls behind. cancel must be called when done.
+func (b *eventBroker) subscribe(lastID uint64) ([]proxyEvent, <-chan proxyEvent, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var replay []proxyEvent
+	for _, ev := range b.history {
+		if ev.ID > lastID {
+			replay = append(replay, ev)
+		}
+	}
+
+	ch := make(chan proxyEvent, eventSubscriberBuffer)
+	if b.closed {
+		close(ch)
+		return replay, ch, func() {}
+	}
+	b.subscribers[ch] = struct{}{}
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return replay, ch, cancel
+}
+
+// close ends every stream; later events are dropped.
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// updateRoutes publishes the differences between routes and the routes seen
+// last: container.routed for new or changed routes, route.removed for those
+// gone.
+func (b *eventBroker) updateRoutes(routes []ContainerRoutes) {
+	b.mu.Lock()
+	previous := b.routes
+	b.routes = make(map[string]ContainerRoutes, len(routes))
+	for _, r := range routes {
+		b.routes[r.ContainerID] = r
+	}
+	b.mu.Unlock()
+
+	for _, r := range routes {
+		old, ok := previous[r.ContainerID]
+		if ok && slices.Equal(old.Hostnames, r.Hostnames) && old.Path == r.Path && old.BackendURL == r.BackendURL {
+			continue
+		}
+		b.publish(routeEvent(eventContainerRouted, r))
+	}
+	for id, r := range previous {
+		if _, ok := b.routes[id]; !ok {
+			b.publish(routeEvent(eventRouteRemoved, r))
+		}
+	}
+}
+
+func routeEvent(eventType string, r ContainerRoutes) proxyEvent {
+	return proxyEvent{
+		Type:          eventType,
+		ContainerID:   r.ContainerID,
+		ContainerName: r.ContainerName,
+		Hostnames:     r.Hostnames,
+		Path:          r.Path,
+		BackendURL:    r.BackendURL,
+		Source:        r.Source,
+	}
+}
+
+// updateNetworks publishes the networks joined and left since the last
+// call. The first call only records the baseline.
+func (b *eventBroker) updateNetworks(networks []networkRef) {
+	current := make(map[string]networkRef, len(networks))
+	for _, n := range networks {
+		current[n.ID] = n
+	}
+
+	b.mu.Lock()
+	previous := b.networks
+	b.networks = current
+	b.mu.Unlock()
+	if previous == nil {
+		return
+	}
+
+	for _, n := range networks {
+		if _, ok := previous[n.ID]; !ok {
+			b.publish(proxyEvent{Type: eventNetworkJoined, Network: &n})
+		}
+	}
+	for id, n := range previous {
+		if _, ok := current[id]; !ok {
+			b.publish(proxyEvent{Type: eventNetworkLeft, Network: &n})
+		}
+	}
+}
+
+// updateCerts publishes the certificates added or replaced since the last
+// call, given as file name to fingerprint. The first call only records the
+// baseline.
+func (b *eventBroker) updateCerts(certs map[string]string) {
+	b.mu.Lock()
+	previous := b.certs
+	b.certs = certs
+	b.mu.Unlock()
+	if previous == nil {
+		return
+	}
+
+	files := make([]string, 0, len(certs))
+	for file := range certs {
+		files = append(files, file)
+	}
+	slices.Sort(files)
+	for _, file := range files {
+		if previous[file] != certs[file] {
+			b.publish(proxyEvent{Type: eventCertGenerated, Certificate: file})
+		}
+	}
+}
+
+// runEventPoller feeds the broker with the changes of the join-networks
+// snapshot and the certs directory until ctx is done, then closes the
+// streams.
+func (cl *CompatibilityLayer) runEventPoller(ctx context.Context) error {
+	defer cl.events.close()
+
+	ticker := time.NewTicker(eventPollInterval)
+	defer ticker.Stop()
+	for {
+		cl.pollEventSources()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollEventSources reads the sources the layer does not change itself. A
+// source that cannot be read is skipped until the next poll.
+func (cl *CompatibilityLayer) pollEventSources() {
+	if cl.state != nil {
+		var status networksStatus
+		if err := cl.state.Read(networksStateName, &status); err == nil {
+			cl.events.updateNetworks(status.Networks)
+		}
+	}
+
+	if certs, err := loadIntendedCerts(cl.config.CertsDir); err == nil {
+		fingerprints := make(map[string]string, len(certs))
+		for _, cert := range certs {
+			fingerprints[cert.file] = cert.fingerprint
+		}
+		cl.events.updateCerts(fingerprints)
+	}
+}
+
+// handleEvents streams proxy events as Server-Sent Events. A client
+// reconnecting with Last-Event-ID first gets the events it missed, as far
+// as the history goes.
+func (cl *CompatibilityLayer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "streaming not supported"})
+		return
+	}
+
+	lastID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	replay, events, cancel := cl.events.subscribe(lastID)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, ev := range replay {
+		writeEvent(w, ev)
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			writeEvent(w, ev)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes one event in the text/event-stream format.
+func writeEvent(w http.ResponseWriter, ev proxyEvent) {
+	data, _ := json.Marshal(ev)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+}
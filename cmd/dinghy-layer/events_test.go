@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// eventTypes returns the types of the events after lastID.
+func eventTypes(b *eventBroker, lastID uint64) []string {
+	replay, _, cancel := b.subscribe(lastID)
+	defer cancel()
+	var types []string
+	for _, ev := range replay {
+		types = append(types, ev.Type+" "+ev.ContainerName+ev.Certificate)
+		if ev.Network != nil {
+			types[len(types)-1] += ev.Network.Name
+		}
+	}
+	return types
+}
+
+func TestEventBrokerDiffs(t *testing.T) {
+	b := newEventBroker()
+	web := ContainerRoutes{ContainerID: "a", ContainerName: "web", Hostnames: []string{"web.loc"}}
+	api := ContainerRoutes{ContainerID: "b", ContainerName: "api", Hostnames: []string{"api.loc"}}
+
+	b.updateRoutes([]ContainerRoutes{web, api})
+	b.updateRoutes([]ContainerRoutes{web, api})
+	web.Hostnames = []string{"web.loc", "www.web.loc"}
+	b.updateRoutes([]ContainerRoutes{web})
+
+	// The first snapshots only record the baseline
+	b.updateNetworks([]networkRef{{ID: "1", Name: "default"}})
+	b.updateCerts(map[string]string{"web.loc.pem": "aa"})
+	b.updateNetworks([]networkRef{{ID: "2", Name: "shop"}})
+	b.updateCerts(map[string]string{"web.loc.pem": "bb", "api.loc.pem": "cc"})
+
+	want := []string{
+		"container.routed web", "container.routed api",
+		"container.routed web", "route.removed api",
+		"network.joined shop", "network.left default",
+		"cert.generated api.loc.pem", "cert.generated web.loc.pem",
+	}
+	if got := eventTypes(b, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+	if got := eventTypes(b, 6); !reflect.DeepEqual(got, want[6:]) {
+		t.Errorf("events after 6 = %q, want %q", got, want[6:])
+	}
+}
+
+func TestEventBrokerSubscribers(t *testing.T) {
+	b := newEventBroker()
+	_, slow, cancelSlow := b.subscribe(0)
+	defer cancelSlow()
+	_, fast, cancelFast := b.subscribe(0)
+
+	for i := 0; i < eventSubscriberBuffer; i++ {
+		b.publish(proxyEvent{Type: eventCertGenerated})
+		<-fast
+	}
+	b.publish(proxyEvent{Type: eventCertGenerated})
+
+	// The slow subscriber lagged a full buffer behind and was dropped
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != eventSubscriberBuffer {
+		t.Errorf("slow subscriber received %d events before being dropped, want %d", received, eventSubscriberBuffer)
+	}
+	if ev := <-fast; ev.ID != eventSubscriberBuffer+1 {
+		t.Errorf("fast subscriber got event %d, want %d", ev.ID, eventSubscriberBuffer+1)
+	}
+
+	cancelFast()
+	cancelFast()
+	b.close()
+	if _, ok := <-fast; ok {
+		t.Error("channel of a cancelled subscriber is still open")
+	}
+}
+
+func TestHandleEvents(t *testing.T) {
+	cl := testLayerWithDocker(t)
+	cl.events.publish(proxyEvent{Type: eventNetworkJoined, Network: &networkRef{ID: "1", Name: "shop"}})
+	cl.events.publish(proxyEvent{Type: eventCertGenerated, Certificate: "shop.loc.pem"})
+
+	admin := httptest.NewServer(cl.adminHandler())
+	defer admin.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, admin.URL+"/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := admin.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	cl.routes.set(ContainerRoutes{ContainerID: "a", ContainerName: "web", Hostnames: []string{"web.loc"}})
+	cl.routesChanged()
+	cl.events.close()
+
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "id:") || strings.HasPrefix(line, "event:") {
+			lines = append(lines, line)
+		}
+	}
+	want := []string{"id: 2", "event: cert.generated", "id: 3", "event: container.routed"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("stream = %q, want %q", lines, want)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+const (
+	// DefaultFaultEndpoint is where Traefik reaches the fault injection
+	// endpoint of the admin API, over the proxy's compose network
+	DefaultFaultEndpoint = "http://dinghy_layer:8081/faults"
+
+	// maxFaultLatency keeps injected delays below the timeout Traefik applies
+	// to forwardAuth requests
+	maxFaultLatency = 25 * time.Second
+
+	// defaultFaultStatus is the status of injected errors
+	defaultFaultStatus = http.StatusServiceUnavailable
+
+	// faultHeader marks responses whose error was injected
+	faultHeader = "X-Fault-Injected"
+)
+
+// faultSpec is the fault injection asked for by a container: a fixed latency
+// added to every request and the percentage of requests answered with status
+// instead of reaching the backend.
+type faultSpec struct {
+	latency   time.Duration
+	errorRate float64
+	status    int
+}
+
+// parseFaultSpec parses HTTP_PROXY_FAULT_LATENCY (a duration),
+// HTTP_PROXY_FAULT_ERROR_RATE (a percentage) and HTTP_PROXY_FAULT_STATUS (a
+// 4xx or 5xx status, 503 by default).
+func parseFaultSpec(latency, errorRate, status string) (faultSpec, error) {
+	spec := faultSpec{status: defaultFaultStatus}
+
+	if latency = strings.TrimSpace(latency); latency != "" {
+		d, err := time.ParseDuration(latency)
+		if err != nil || d < 0 || d > maxFaultLatency {
+			return faultSpec{}, fmt.Errorf("invalid fault latency %q, expected a duration up to %s", latency, maxFaultLatency)
+		}
+		spec.latency = d
+	}
+
+	if errorRate = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(errorRate), "%")); errorRate != "" {
+		rate, err := strconv.ParseFloat(errorRate, 64)
+		if err != nil || rate < 0 || rate > 100 {
+			return faultSpec{}, fmt.Errorf("invalid fault error rate %q, expected a percentage between 0 and 100", errorRate)
+		}
+		spec.errorRate = rate
+	}
+
+	if status = strings.TrimSpace(status); status != "" {
+		code, err := strconv.Atoi(status)
+		if err != nil || code < 400 || code > 599 {
+			return faultSpec{}, fmt.Errorf("invalid fault status %q, expected a 4xx or 5xx status", status)
+		}
+		spec.status = code
+	}
+	return spec, nil
+}
+
+// enabled reports whether the spec injects any fault.
+func (f faultSpec) enabled() bool {
+	return f.latency > 0 || f.errorRate > 0
+}
+
+// query encodes the spec for the fault endpoint.
+func (f faultSpec) query(serviceName string) url.Values {
+	return url.Values{
+		"service":    {serviceName},
+		"latency":    {f.latency.String()},
+		"error_rate": {strconv.FormatFloat(f.errorRate, 'f', -1, 64)},
+		"status":     {strconv.Itoa(f.status)},
+	}
+}
+
+// faultMiddlewareName returns the name of the middleware injecting a
+// service's faults.
+func faultMiddlewareName(serviceName string) string {
+	return serviceName + "-faults"
+}
+
+// addFaultMiddleware defines a forwardAuth middleware asking the fault
+// endpoint whether to let each request through, and attaches it to all of
+// the service's routers. The spec travels in the endpoint URL, so the
+// endpoint keeps no state.
+func addFaultMiddleware(traefikConfig *config.TraefikConfig, serviceName, endpoint string, spec faultSpec) {
+	if !spec.enabled() || endpoint == "" {
+		return
+	}
+
+	name := faultMiddlewareName(serviceName)
+	traefikConfig.HTTP.Middlewares[name] = &config.Middleware{
+		ForwardAuth: &config.ForwardAuthMiddleware{
+			Address: endpoint + "?" + spec.query(serviceName).Encode(),
+		},
+	}
+	for _, router := range traefikConfig.HTTP.Routers {
+		if isContainerService(router.Service, serviceName) {
+			router.Middlewares = append(router.Middlewares, name)
+		}
+	}
+}
+
+// faults parses a container's fault injection settings, logging invalid
+// ones. Faults are left out rather than guessed.
+func (cl *CompatibilityLayer) faults(containerInfo ContainerInfo) faultSpec {
+	spec, err := parseFaultSpec(containerInfo.FaultLatency, containerInfo.FaultErrorRate, containerInfo.FaultStatus)
+	if err != nil {
+		cl.logger.Warn("Ignoring fault injection settings",
+			"container_id", utils.FormatDockerID(containerInfo.ID),
+			"error", err)
+		return faultSpec{}
+	}
+	return spec
+}
+
+// handleFault answers the forwardAuth requests of the fault middlewares:
+// after the latency, either 200, letting Traefik forward the request, or the
+// injected error, which Traefik returns to the client. The service must be
+// one the layer routes, as it labels the faults metric.
+func (cl *CompatibilityLayer) handleFault(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	spec, err := parseFaultSpec(query.Get("latency"), query.Get("error_rate"), query.Get("status"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	service := query.Get("service")
+	if !cl.routes.hasService(service) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("unknown service %q", service)})
+		return
+	}
+
+	if spec.latency > 0 {
+		timer := time.NewTimer(spec.latency)
+		defer timer.Stop()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-timer.C:
+		}
+		cl.faultsInjected.Inc(service, "latency")
+	}
+
+	if spec.errorRate > 0 && rand.Float64()*100 < spec.errorRate {
+		cl.faultsInjected.Inc(service, "error")
+		w.Header().Set(faultHeader, "error")
+		http.Error(w, "fault injected by http-proxy", spec.status)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
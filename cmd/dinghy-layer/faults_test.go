@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFaultSpec(t *testing.T) {
+	tests := []struct {
+		name      string
+		latency   string
+		errorRate string
+		status    string
+		want      faultSpec
+		wantErr   bool
+	}{
+		{name: "none", want: faultSpec{status: 503}},
+		{name: "latency", latency: "300ms", want: faultSpec{latency: 300 * time.Millisecond, status: 503}},
+		{name: "error rate with percent sign", errorRate: " 12.5% ", status: "500", want: faultSpec{errorRate: 12.5, status: 500}},
+		{name: "latency too long", latency: "1m", wantErr: true},
+		{name: "negative latency", latency: "-1s", wantErr: true},
+		{name: "rate above 100", errorRate: "150", wantErr: true},
+		{name: "not an error status", errorRate: "10", status: "204", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFaultSpec(tt.latency, tt.errorRate, tt.status)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFaultSpec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseFaultSpec() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateTraefikConfigFaults(t *testing.T) {
+	cl := testLayer()
+	cl.config.FaultEndpoint = DefaultFaultEndpoint
+	info := ContainerInfo{Name: "api", VirtualHost: "api.loc", FaultLatency: "200ms", FaultErrorRate: "10"}
+
+	cfg := cl.generateTraefikConfig(inspectWithIP("/api", "172.0.0.13"), info)
+
+	middleware, ok := cfg.HTTP.Middlewares["api-faults"]
+	if !ok || middleware.ForwardAuth == nil {
+		t.Fatalf("missing forwardAuth middleware api-faults; got %v", cfg.HTTP.Middlewares)
+	}
+	want := DefaultFaultEndpoint + "?error_rate=10&latency=200ms&service=api&status=503"
+	if middleware.ForwardAuth.Address != want {
+		t.Errorf("address = %q, want %q", middleware.ForwardAuth.Address, want)
+	}
+	for name, router := range cfg.HTTP.Routers {
+		if !strings.Contains(strings.Join(router.Middlewares, ","), "api-faults") {
+			t.Errorf("router %s middlewares = %v, want api-faults", name, router.Middlewares)
+		}
+	}
+
+	info.FaultErrorRate = "often"
+	if cfg := cl.generateTraefikConfig(inspectWithIP("/api", "172.0.0.13"), info); cfg.HTTP.Middlewares["api-faults"] != nil {
+		t.Error("invalid fault settings generated a middleware")
+	}
+}
+
+func TestHandleFault(t *testing.T) {
+	cl := testLayerWithDocker(t)
+	handler := cl.adminHandler()
+	cl.routes.set(ContainerRoutes{ContainerID: "aaaaaaaaaaaa", ContainerName: "api", ServiceName: "api"})
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantFault  string
+	}{
+		{name: "always fails", query: "service=api&latency=10ms&error_rate=100&status=502", wantStatus: http.StatusBadGateway, wantFault: "error"},
+		{name: "never fails", query: "service=api&latency=0s&error_rate=0&status=503", wantStatus: http.StatusOK},
+		{name: "invalid", query: "service=api&error_rate=200", wantStatus: http.StatusBadRequest},
+		{name: "unknown service", query: "service=other&latency=10ms&error_rate=100", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/faults?"+tt.query, nil))
+			if rec.Code != tt.wantStatus || rec.Header().Get(faultHeader) != tt.wantFault {
+				t.Errorf("status %d, %s %q; want %d, %q", rec.Code, faultHeader, rec.Header().Get(faultHeader), tt.wantStatus, tt.wantFault)
+			}
+		})
+	}
+
+	if got := cl.faultsInjected.Value("api", "latency"); got != 1 {
+		t.Errorf("latency faults = %v, want 1", got)
+	}
+	if got := cl.faultsInjected.Value("api", "error"); got != 1 {
+		t.Errorf("error faults = %v, want 1", got)
+	}
+	if got := cl.faultsInjected.Value("other", "latency"); got != 0 {
+		t.Errorf("faults of an unknown service = %v, want 0", got)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+)
+
+// geoCountryHeaders are the country headers GeoIP modules and CDNs add in
+// production; HTTP_PROXY_GEO_COUNTRY fills all of them.
+var geoCountryHeaders = []string{"X-Geo-Country", "CF-IPCountry", "CloudFront-Viewer-Country"}
+
+// headerNamePattern matches an RFC 7230 header field name.
+var headerNamePattern = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+// parseRequestHeaders builds the synthetic request headers a container asks
+// for. geoCountry fills the GeoIP country headers; spec is a ";"-separated list
+// of "Name: value" entries (HTTP_PROXY_REQUEST_HEADERS) that overrides them.
+// Malformed entries are returned separately so the caller can report them.
+func parseRequestHeaders(geoCountry, spec string) (map[string]string, []string) {
+	headers := make(map[string]string)
+	var invalid []string
+
+	if country := strings.ToUpper(strings.TrimSpace(geoCountry)); country != "" {
+		for _, name := range geoCountryHeaders {
+			headers[name] = country
+		}
+	}
+
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(entry, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || !headerNamePattern.MatchString(name) || strings.ContainsAny(value, "\r\n") {
+			invalid = append(invalid, entry)
+			continue
+		}
+		headers[name] = value
+	}
+
+	if len(headers) == 0 {
+		return nil, invalid
+	}
+	return headers, invalid
+}
+
+// headersMiddlewareName returns the name of the middleware carrying a
+// service's synthetic request headers.
+func headersMiddlewareName(serviceName string) string {
+	return serviceName + "-headers"
+}
+
+// addRequestHeadersMiddleware defines the headers middleware for a service and
+// attaches it to all of the service's routers.
+func addRequestHeadersMiddleware(traefikConfig *config.TraefikConfig, serviceName string, headers map[string]string) {
+	name := headersMiddlewareName(serviceName)
+	traefikConfig.HTTP.Middlewares[name] = &config.Middleware{
+		Headers: &config.HeadersMiddleware{CustomRequestHeaders: headers},
+	}
+
+	for _, router := range traefikConfig.HTTP.Routers {
+		if isContainerService(router.Service, serviceName) {
+			router.Middlewares = append(router.Middlewares, name)
+		}
+	}
+}
+
+// securityHeadersStrict is the HTTP_PROXY_SECURITY_HEADERS value selecting
+// the strict preset
+const securityHeadersStrict = "strict"
+
+// securityHeadersPreset returns the headers middleware for a
+// HTTP_PROXY_SECURITY_HEADERS value. The strict preset mirrors common
+// production settings; HSTS preloading is left out since it cannot be undone
+// from a local setup. ok is false for unknown presets.
+func securityHeadersPreset(name string) (headers *config.HeadersMiddleware, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "off", "none":
+		return nil, true
+	case securityHeadersStrict:
+		return &config.HeadersMiddleware{
+			STSSeconds:           31536000,
+			STSIncludeSubdomains: true,
+			ContentTypeNosniff:   true,
+			ReferrerPolicy:       "strict-origin-when-cross-origin",
+		}, true
+	default:
+		return nil, false
+	}
+}
+
+// securityHeadersMiddlewareName returns the name of the middleware carrying a
+// service's security headers.
+func securityHeadersMiddlewareName(serviceName string) string {
+	return serviceName + "-security-headers"
+}
+
+// addSecurityHeadersMiddleware attaches response security headers to the
+// service's HTTPS routers; HTTP routers are left alone since browsers ignore
+// HSTS over plain HTTP. HSTS itself is still stripped by the disable-hsts@file
+// middleware of the HTTPS entrypoint, which wraps every router.
+func addSecurityHeadersMiddleware(traefikConfig *config.TraefikConfig, serviceName string, headers *config.HeadersMiddleware) {
+	if headers == nil {
+		return
+	}
+	name := securityHeadersMiddlewareName(serviceName)
+	traefikConfig.HTTP.Middlewares[name] = &config.Middleware{Headers: headers}
+
+	for _, router := range traefikConfig.HTTP.Routers {
+		if isContainerService(router.Service, serviceName) && router.TLS != nil {
+			router.Middlewares = append(router.Middlewares, name)
+		}
+	}
+}
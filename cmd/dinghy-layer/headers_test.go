@@ -0,0 +1,133 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRequestHeaders(t *testing.T) {
+	tests := []struct {
+		name        string
+		geoCountry  string
+		spec        string
+		want        map[string]string
+		wantInvalid []string
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:       "geo preset",
+			geoCountry: "it",
+			want:       map[string]string{"X-Geo-Country": "IT", "CF-IPCountry": "IT", "CloudFront-Viewer-Country": "IT"},
+		},
+		{
+			name: "custom headers",
+			spec: "X-Forwarded-Proto: https; X-CDN: fastly ;",
+			want: map[string]string{"X-Forwarded-Proto": "https", "X-CDN": "fastly"},
+		},
+		{
+			name:       "custom overrides preset",
+			geoCountry: "IT",
+			spec:       "CF-IPCountry: DE",
+			want:       map[string]string{"X-Geo-Country": "IT", "CF-IPCountry": "DE", "CloudFront-Viewer-Country": "IT"},
+		},
+		{
+			name:        "malformed entries",
+			spec:        "no-colon;Bad Name: x;X-Ok: 1, 2",
+			want:        map[string]string{"X-Ok": "1, 2"},
+			wantInvalid: []string{"no-colon", "Bad Name: x"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, invalid := parseRequestHeaders(tt.geoCountry, tt.spec)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("headers = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(invalid, tt.wantInvalid) {
+				t.Errorf("invalid = %v, want %v", invalid, tt.wantInvalid)
+			}
+		})
+	}
+}
+
+func TestGenerateTraefikConfigRequestHeaders(t *testing.T) {
+	cl := testLayer()
+	inspect := inspectWithIP("/shop", "172.0.0.8")
+	info := ContainerInfo{Name: "shop", VirtualHost: "shop.loc", VirtualPort: "80", GeoCountry: "FR", RequestHeaders: "X-Forwarded-Proto: https"}
+
+	cfg := cl.generateTraefikConfig(inspect, info)
+
+	middleware, ok := cfg.HTTP.Middlewares["shop-headers"]
+	if !ok || middleware.Headers == nil {
+		t.Fatalf("missing headers middleware; got %v", cfg.HTTP.Middlewares)
+	}
+	if got := middleware.Headers.CustomRequestHeaders["X-Geo-Country"]; got != "FR" {
+		t.Errorf("X-Geo-Country = %q, want FR", got)
+	}
+	if got := middleware.Headers.CustomRequestHeaders["X-Forwarded-Proto"]; got != "https" {
+		t.Errorf("X-Forwarded-Proto = %q, want https", got)
+	}
+
+	for name, router := range cfg.HTTP.Routers {
+		want := []string{"shop-headers"}
+		if !reflect.DeepEqual(router.Middlewares, want) {
+			t.Errorf("router %s middlewares = %v, want %v", name, router.Middlewares, want)
+		}
+	}
+}
+
+func TestGenerateTraefikConfigWithoutRequestHeaders(t *testing.T) {
+	cl := testLayer()
+	cfg := cl.generateTraefikConfig(inspectWithIP("/plain", "172.0.0.9"), ContainerInfo{Name: "plain", VirtualHost: "plain.loc"})
+
+	if len(cfg.HTTP.Middlewares) != 0 {
+		t.Errorf("unexpected middlewares: %v", cfg.HTTP.Middlewares)
+	}
+	for _, name := range []string{"plain-0", "plain-tls-0"} {
+		if router := cfg.HTTP.Routers[name]; router == nil || router.Middlewares != nil {
+			t.Errorf("unexpected router %s: %+v", name, router)
+		}
+	}
+}
+
+func TestSecurityHeadersPreset(t *testing.T) {
+	for _, name := range []string{"", "off", "none"} {
+		if headers, ok := securityHeadersPreset(name); headers != nil || !ok {
+			t.Errorf("preset %q = %+v, %v; want nil, true", name, headers, ok)
+		}
+	}
+
+	strict, ok := securityHeadersPreset(" Strict ")
+	if !ok || strict == nil {
+		t.Fatal("strict preset not recognized")
+	}
+	if strict.STSSeconds != 31536000 || !strict.STSIncludeSubdomains || strict.STSPreload {
+		t.Errorf("unexpected HSTS settings: %+v", strict)
+	}
+	if !strict.ContentTypeNosniff || strict.ReferrerPolicy != "strict-origin-when-cross-origin" {
+		t.Errorf("unexpected headers: %+v", strict)
+	}
+
+	if _, ok := securityHeadersPreset("paranoid"); ok {
+		t.Error("unknown presets must be rejected")
+	}
+}
+
+func TestGenerateTraefikConfigSecurityHeaders(t *testing.T) {
+	cl := testLayer()
+	info := ContainerInfo{Name: "shop", VirtualHost: "shop.loc", SecurityHeaders: "strict", GeoCountry: "IT"}
+
+	cfg := cl.generateTraefikConfig(inspectWithIP("/shop", "172.0.0.8"), info)
+
+	if middleware := cfg.HTTP.Middlewares["shop-security-headers"]; middleware == nil || middleware.Headers.STSSeconds == 0 {
+		t.Fatalf("missing security headers middleware; got %v", cfg.HTTP.Middlewares)
+	}
+	if got := cfg.HTTP.Routers["shop-tls-0"].Middlewares; !reflect.DeepEqual(got, []string{"shop-headers", "shop-security-headers"}) {
+		t.Errorf("HTTPS router middlewares = %v", got)
+	}
+	if got := cfg.HTTP.Routers["shop-0"].Middlewares; !reflect.DeepEqual(got, []string{"shop-headers"}) {
+		t.Errorf("HTTP router middlewares = %v", got)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Where a container's routes come from
+const (
+	routeSourceVirtualHost   = "virtual_host"
+	routeSourceTraefikLabels = "traefik_labels"
+	routeSourceStatic        = "static"
+)
+
+// ContainerRoutes describes the routes the layer serves for one container:
+// generated from VIRTUAL_HOST, imported from native Traefik labels, or a
+// static route added through the admin API (Source).
+// Project is the container's compose project and Metadata holds the
+// container labels selected by HTTP_PROXY_METADATA_LABELS.
+// Regex hosts are prefixed with "~". Path is the VIRTUAL_PATH prefix the
+// routes are restricted to, if any. Created and Weight (VIRTUAL_HOST_WEIGHT)
+// order the containers serving the same hostname.
+type ContainerRoutes struct {
+	ContainerID   string
+	ContainerName string
+	ServiceName   string
+	Hostnames     []string
+	Path          string
+	BackendURL    string
+	Source        string
+	Project       string
+	Metadata      map[string]string
+	Created       time.Time
+	Weight        int
+	TLS           string
+}
+
+// routeInventory tracks the routes currently generated for each managed
+// container, so subsystems other than the file writer (mDNS advertisement,
+// ...) can see what the layer is serving. It is safe for concurrent use.
+type routeInventory struct {
+	mu     sync.RWMutex
+	routes map[string]ContainerRoutes
+}
+
+func newRouteInventory() *routeInventory {
+	return &routeInventory{routes: make(map[string]ContainerRoutes)}
+}
+
+// set records the routes of a container, replacing any previous entry.
+func (ri *routeInventory) set(routes ContainerRoutes) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	ri.routes[routes.ContainerID] = routes
+}
+
+// get returns the routes recorded for a container.
+func (ri *routeInventory) get(containerID string) (ContainerRoutes, bool) {
+	ri.mu.RLock()
+	defer ri.mu.RUnlock()
+
+	routes, ok := ri.routes[containerID]
+	return routes, ok
+}
+
+// remove forgets a container and reports whether it was known.
+func (ri *routeInventory) remove(containerID string) bool {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	if _, ok := ri.routes[containerID]; !ok {
+		return false
+	}
+	delete(ri.routes, containerID)
+	return true
+}
+
+// list returns all container routes sorted by container name.
+func (ri *routeInventory) list() []ContainerRoutes {
+	ri.mu.RLock()
+	defer ri.mu.RUnlock()
+
+	result := make([]ContainerRoutes, 0, len(ri.routes))
+	for _, routes := range ri.routes {
+		result = append(result, routes)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ContainerName != result[j].ContainerName {
+			return result[i].ContainerName < result[j].ContainerName
+		}
+		return result[i].ContainerID < result[j].ContainerID
+	})
+	return result
+}
+
+// serviceNames returns the services of all containers but one.
+func (ri *routeInventory) serviceNames(excludeID string) map[string]bool {
+	ri.mu.RLock()
+	defer ri.mu.RUnlock()
+
+	names := make(map[string]bool, len(ri.routes))
+	for id, routes := range ri.routes {
+		if id != excludeID && routes.ServiceName != "" {
+			names[routes.ServiceName] = true
+		}
+	}
+	return names
+}
+
+// hasService reports whether a container is routed through the named service.
+func (ri *routeInventory) hasService(serviceName string) bool {
+	ri.mu.RLock()
+	defer ri.mu.RUnlock()
+
+	for _, routes := range ri.routes {
+		if routes.ServiceName == serviceName {
+			return true
+		}
+	}
+	return false
+}
+
+// hostnames returns the sorted, de-duplicated hostnames of all containers.
+func (ri *routeInventory) hostnames() []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, routes := range ri.list() {
+		for _, hostname := range routes.Hostnames {
+			if !seen[hostname] {
+				seen[hostname] = true
+				result = append(result, hostname)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRouteInventory(t *testing.T) {
+	ri := newRouteInventory()
+	ri.set(ContainerRoutes{ContainerID: "b", ContainerName: "web", Hostnames: []string{"web.loc", "shared.loc"}})
+	ri.set(ContainerRoutes{ContainerID: "a", ContainerName: "api", Hostnames: []string{"api.loc", "shared.loc"}})
+
+	list := ri.list()
+	if len(list) != 2 || list[0].ContainerName != "api" || list[1].ContainerName != "web" {
+		t.Errorf("list not sorted by name: %+v", list)
+	}
+
+	want := []string{"api.loc", "shared.loc", "web.loc"}
+	if got := ri.hostnames(); !reflect.DeepEqual(got, want) {
+		t.Errorf("hostnames = %v, want %v", got, want)
+	}
+
+	if !ri.remove("a") {
+		t.Error("remove of known container returned false")
+	}
+	if ri.remove("a") {
+		t.Error("second remove returned true")
+	}
+	if got := ri.hostnames(); !reflect.DeepEqual(got, []string{"shared.loc", "web.loc"}) {
+		t.Errorf("hostnames after remove = %v", got)
+	}
+}
+
+func TestRouteInventoryReplace(t *testing.T) {
+	ri := newRouteInventory()
+	ri.set(ContainerRoutes{ContainerID: "a", Hostnames: []string{"old.loc"}})
+	ri.set(ContainerRoutes{ContainerID: "a", Hostnames: []string{"new.loc"}})
+
+	if got := ri.hostnames(); !reflect.DeepEqual(got, []string{"new.loc"}) {
+		t.Errorf("hostnames = %v, want [new.loc]", got)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// Labels overriding parts of the config generated from VIRTUAL_HOST. Unlike
+// traefik.* labels, they do not hand the container over to Traefik's Docker
+// provider.
+const (
+	middlewaresLabel = "http-proxy.middlewares"
+	priorityLabel    = "http-proxy.priority"
+	entryPointsLabel = "http-proxy.entrypoints"
+)
+
+// labelOverride is what a container's http-proxy.* labels change in its
+// generated routers: middlewares appended to every chain, a priority
+// replacing the computed ones, and the entry points served on. Listing http
+// or https keeps only the routers of those entry points; other entry points
+// are added to the HTTPS routers.
+type labelOverride struct {
+	middlewares []string
+	priority    int
+	entryPoints []string
+}
+
+// parseLabelOverride parses the http-proxy.* override labels. A priority that
+// is not a positive integer is returned as invalid and ignored.
+func parseLabelOverride(middlewares, priority, entryPoints string) (labelOverride, []string) {
+	var o labelOverride
+	var invalid []string
+	o.middlewares = splitLabelList(middlewares)
+	o.entryPoints = splitLabelList(entryPoints)
+	if priority = strings.TrimSpace(priority); priority != "" {
+		if p, err := strconv.Atoi(priority); err == nil && p > 0 {
+			o.priority = p
+		} else {
+			invalid = append(invalid, priorityLabel+"="+priority)
+		}
+	}
+	return o, invalid
+}
+
+// splitLabelList splits a comma-separated label value, dropping empty items.
+func splitLabelList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// apply changes the HTTP routers of cfg.
+func (o labelOverride) apply(cfg *config.TraefikConfig) {
+	if cfg.HTTP == nil {
+		return
+	}
+
+	var filter, extra []string
+	for _, entryPoint := range o.entryPoints {
+		if entryPoint == "http" || entryPoint == "https" {
+			filter = append(filter, entryPoint)
+		} else {
+			extra = append(extra, entryPoint)
+		}
+	}
+
+	for name, router := range cfg.HTTP.Routers {
+		if len(filter) > 0 && !slices.ContainsFunc(router.EntryPoints, func(e string) bool { return slices.Contains(filter, e) }) {
+			delete(cfg.HTTP.Routers, name)
+			continue
+		}
+		if router.TLS != nil {
+			for _, entryPoint := range extra {
+				if !slices.Contains(router.EntryPoints, entryPoint) {
+					router.EntryPoints = append(router.EntryPoints, entryPoint)
+				}
+			}
+		}
+		if o.priority > 0 {
+			router.Priority = o.priority
+		}
+		router.Middlewares = append(router.Middlewares, o.middlewares...)
+	}
+}
+
+// labelOverride parses a container's override labels, logging invalid ones.
+func (cl *CompatibilityLayer) labelOverride(containerInfo ContainerInfo) labelOverride {
+	o, invalid := parseLabelOverride(containerInfo.Middlewares, containerInfo.Priority, containerInfo.EntryPoints)
+	for _, label := range invalid {
+		cl.logger.Warn("Ignoring invalid override label, expected a positive integer",
+			"container_id", utils.FormatDockerID(containerInfo.ID),
+			"label", label)
+	}
+	return o
+}
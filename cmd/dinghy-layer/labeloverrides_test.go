@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLabelOverride(t *testing.T) {
+	tests := []struct {
+		name                            string
+		middlewares, priority, entryPts string
+		want                            labelOverride
+		wantInvalid                     []string
+	}{
+		{name: "empty"},
+		{
+			name:        "all labels",
+			middlewares: "compress@file, ,ratelimit@docker",
+			priority:    " 200 ",
+			entryPts:    "https,admin",
+			want: labelOverride{
+				middlewares: []string{"compress@file", "ratelimit@docker"},
+				priority:    200,
+				entryPoints: []string{"https", "admin"},
+			},
+		},
+		{name: "invalid priority", priority: "high", wantInvalid: []string{"http-proxy.priority=high"}},
+		{name: "negative priority", priority: "-1", wantInvalid: []string{"http-proxy.priority=-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, invalid := parseLabelOverride(tt.middlewares, tt.priority, tt.entryPts)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("override = %+v, want %+v", got, tt.want)
+			}
+			if !reflect.DeepEqual(invalid, tt.wantInvalid) {
+				t.Errorf("invalid = %v, want %v", invalid, tt.wantInvalid)
+			}
+		})
+	}
+}
+
+func TestGenerateTraefikConfigAppliesLabelOverride(t *testing.T) {
+	cl := testLayer()
+	info := ContainerInfo{
+		Name:        "shop",
+		VirtualHost: "shop.loc",
+		HostWeight:  "10",
+		Middlewares: "compress@file",
+		Priority:    "300",
+		EntryPoints: "https,admin",
+	}
+
+	cfg := cl.generateTraefikConfig(inspectWithIP("/shop", "172.0.0.8"), info)
+
+	if _, ok := cfg.HTTP.Routers["shop-0"]; ok {
+		t.Error("HTTP router kept although only https was listed")
+	}
+	router := cfg.HTTP.Routers["shop-tls-0"]
+	if router == nil {
+		t.Fatal("HTTPS router missing")
+	}
+	if router.Priority != 300 {
+		t.Errorf("priority = %d, want the label's 300 over VIRTUAL_HOST_WEIGHT", router.Priority)
+	}
+	if !reflect.DeepEqual(router.EntryPoints, []string{"https", "admin"}) {
+		t.Errorf("entry points = %v", router.EntryPoints)
+	}
+	if n := len(router.Middlewares); n == 0 || router.Middlewares[n-1] != "compress@file" {
+		t.Errorf("middlewares = %v, want compress@file appended", router.Middlewares)
+	}
+}
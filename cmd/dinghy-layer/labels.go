@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// importLabelRoutes records the routes a container declares with native
+// traefik.http.routers.*.rule labels, so status, probing and mDNS treat them
+// like generated ones. Traefik's Docker provider serves those routes itself,
+// so no config file is written. Containers without traefik.enable=true are not
+// exposed (exposedByDefault is off) and have no routes.
+func (cl *CompatibilityLayer) importLabelRoutes(inspect types.ContainerJSON, containerInfo ContainerInfo) {
+	labels := inspect.Config.Labels
+
+	var hostnames []string
+	if enabled, _ := strconv.ParseBool(labels["traefik.enable"]); enabled {
+		hostnames = labelHostnames(labels)
+	}
+	if len(hostnames) == 0 {
+		cl.logger.Debug("Skipping container with Traefik labels but no Host rules",
+			"container_id", utils.FormatDockerID(containerInfo.ID),
+			"container_name", containerInfo.Name)
+		if cl.routes.remove(containerInfo.ID) {
+			cl.routesChanged()
+		}
+		return
+	}
+
+	serviceName, backendURL := labelBackend(labels, cl.containerIP(inspect), getDefaultPort(inspect))
+	cl.logger.Info("Imported routes from Traefik labels",
+		"container_id", utils.FormatDockerID(containerInfo.ID),
+		"container_name", containerInfo.Name,
+		"hostnames", strings.Join(hostnames, ","))
+
+	cl.routes.set(ContainerRoutes{
+		ContainerID:   containerInfo.ID,
+		ContainerName: containerInfo.Name,
+		ServiceName:   serviceName,
+		Hostnames:     hostnames,
+		BackendURL:    backendURL,
+		Source:        routeSourceTraefikLabels,
+		Project:       containerInfo.Project,
+		Metadata:      containerInfo.Metadata,
+	})
+	cl.routesChanged()
+}
+
+// labelHostnames returns the de-duplicated hosts of the router rules, regex
+// hosts prefixed with "~".
+func labelHostnames(labels map[string]string) []string {
+	hosts, regexps := utils.TraefikRouterHosts(labels)
+	for _, re := range regexps {
+		hosts = append(hosts, "~"+re)
+	}
+
+	seen := make(map[string]bool)
+	var result []string
+	for _, host := range hosts {
+		if host != "" && !seen[host] {
+			seen[host] = true
+			result = append(result, host)
+		}
+	}
+	return result
+}
+
+// labelBackend returns the first service the labels define and its URL, from
+// its loadbalancer.server port and scheme labels. Without service labels,
+// Traefik creates one named after the router, on the container's default port.
+func labelBackend(labels map[string]string, ip, defaultPort string) (serviceName, backendURL string) {
+	serviceName = firstLabelName(labels, "traefik.http.services.")
+	if serviceName == "" {
+		serviceName = firstLabelName(labels, "traefik.http.routers.")
+	}
+
+	prefix := "traefik.http.services." + serviceName + ".loadbalancer.server."
+	port := labels[prefix+"port"]
+	if port == "" {
+		port = defaultPort
+	}
+	scheme := labels[prefix+"scheme"]
+	if scheme == "" {
+		scheme = "http"
+	}
+	if ip == "" {
+		return serviceName, ""
+	}
+	return serviceName, scheme + "://" + ip + ":" + port
+}
+
+// firstLabelName returns the smallest <name> among the labels starting with
+// prefix followed by "<name>.".
+func firstLabelName(labels map[string]string, prefix string) string {
+	var names []string
+	for key := range labels {
+		if rest, ok := strings.CutPrefix(key, prefix); ok {
+			if name, _, found := strings.Cut(rest, "."); found && name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLabelHostnames(t *testing.T) {
+	labels := map[string]string{
+		"traefik.enable":                      "true",
+		"traefik.http.routers.web.rule":       "Host(`app.loc`) || HostRegexp(`^.+\\.app\\.loc$`)",
+		"traefik.http.routers.web-https.rule": "Host(`app.loc`, `www.app.loc`)",
+	}
+	want := []string{"app.loc", "www.app.loc", "~^.+\\.app\\.loc$"}
+	if got := labelHostnames(labels); !reflect.DeepEqual(got, want) {
+		t.Errorf("labelHostnames() = %v, want %v", got, want)
+	}
+}
+
+func TestLabelBackend(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		ip          string
+		wantService string
+		wantURL     string
+	}{
+		{
+			name:        "router only",
+			labels:      map[string]string{"traefik.http.routers.web.rule": "Host(`app.loc`)"},
+			ip:          "172.18.0.5",
+			wantService: "web",
+			wantURL:     "http://172.18.0.5:80",
+		},
+		{
+			name: "service port and scheme",
+			labels: map[string]string{
+				"traefik.http.routers.web.rule":                        "Host(`app.loc`)",
+				"traefik.http.services.app.loadbalancer.server.port":   "8443",
+				"traefik.http.services.app.loadbalancer.server.scheme": "https",
+			},
+			ip:          "172.18.0.5",
+			wantService: "app",
+			wantURL:     "https://172.18.0.5:8443",
+		},
+		{
+			name:        "no address",
+			labels:      map[string]string{"traefik.http.routers.web.rule": "Host(`app.loc`)"},
+			wantService: "web",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, url := labelBackend(tt.labels, tt.ip, "80")
+			if service != tt.wantService || url != tt.wantURL {
+				t.Errorf("labelBackend() = %q, %q, want %q, %q", service, url, tt.wantService, tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestImportLabelRoutes(t *testing.T) {
+	cl := testLayer()
+	inspect := inspectWithIP("/app", "172.18.0.5")
+	inspect.ID = "abc"
+	inspect.Config.Labels = map[string]string{
+		"traefik.enable":                "true",
+		"traefik.http.routers.web.rule": "Host(`app.loc`)",
+	}
+	containerInfo := ContainerInfo{ID: "abc", Name: "app"}
+
+	cl.importLabelRoutes(inspect, containerInfo)
+	routes, ok := cl.routes.get("abc")
+	if !ok {
+		t.Fatal("routes not imported")
+	}
+	want := ContainerRoutes{
+		ContainerID:   "abc",
+		ContainerName: "app",
+		ServiceName:   "web",
+		Hostnames:     []string{"app.loc"},
+		BackendURL:    "http://172.18.0.5:80",
+		Source:        routeSourceTraefikLabels,
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("imported routes = %+v, want %+v", routes, want)
+	}
+
+	// Traefik does not expose the container without traefik.enable=true
+	inspect.Config.Labels["traefik.enable"] = "false"
+	cl.importLabelRoutes(inspect, containerInfo)
+	if _, ok := cl.routes.get("abc"); ok {
+		t.Error("routes kept for a container Traefik does not expose")
+	}
+}
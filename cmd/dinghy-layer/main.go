@@ -7,12 +7,17 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
@@ -20,9 +25,11 @@ import (
 	"github.com/docker/docker/client"
 	"github.com/sparkfabrik/http-proxy/pkg/config"
 	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/mdns"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
 	"github.com/sparkfabrik/http-proxy/pkg/service"
+	"github.com/sparkfabrik/http-proxy/pkg/state"
 	"github.com/sparkfabrik/http-proxy/pkg/utils"
-	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -34,6 +41,9 @@ const (
 
 	// ConfigDirPermissions defines the permissions for config directories
 	ConfigDirPermissions = 0755
+
+	// DefaultAdminAddr is the default listen address of the admin API
+	DefaultAdminAddr = ":8081"
 )
 
 // CompatibilityLayer implements the service.EventHandler interface and provides
@@ -41,35 +51,266 @@ const (
 // Traefik dynamic configuration. It monitors Docker events and generates
 // appropriate Traefik routing rules for containers with VIRTUAL_HOST variables.
 type CompatibilityLayer struct {
-	dockerClient *client.Client
-	logger       *logger.Logger
-	config       *CompatibilityConfig
+	dockerClient   *client.Client
+	dockerTimeout  time.Duration
+	logger         *logger.Logger
+	config         *CompatibilityConfig
+	template       *template.Template
+	routes         *routeInventory
+	stops          *stopTracker
+	mdns           *mdns.Responder
+	metrics        *metrics.Registry
+	prober         *routeProber
+	certProber     *certProber
+	metadata       []metadataLabel
+	drift          *metrics.Vec
+	faultsInjected *metrics.Vec
+	state          *state.Store
+	events         *eventBroker
+
+	// probedPorts caches the ports probed on containers without port
+	// information, by container ID; portDial replaces the probe dial in tests
+	probedPorts sync.Map
+	portDial    portDialFunc
+
+	// paused holds the compose projects paused with POST /batch, kept in the
+	// state directory across restarts
+	paused map[string]bool
+
+	// replicaSets identifies the replicas each leader's config was last
+	// written with, by leader ID
+	replicaSets map[string]string
+
+	// lastReconcile is when the last reconciliation succeeded, or when the
+	// reconciler started before its first one
+	lastReconcile time.Time
+
+	// writes buffers the config files written while handling events when
+	// buffering is set, nil when writes are not debounced
+	writes    *writeBuffer
+	buffering bool
+
+	// collisions are the hostnames served by several containers, as of the
+	// last change of the route inventory
+	collisions []hostCollision
+
+	// redirects is the redirect catalog as last applied; redirectProblems
+	// are its invalid entries, logged when they change
+	redirects        []hostRedirect
+	redirectsLoaded  bool
+	redirectProblems []string
+
+	// routesFileLast is the content of the routes file as last written
+	routesFileMu   sync.Mutex
+	routesFileLast []byte
+
+	// planOut receives the diffs printed in dry-run mode, stdout when nil
+	planOut io.Writer
+
+	// shuttingDown is set by POST /shutdown: the generated configs are gone
+	// and no container gets new ones until the layer restarts
+	shuttingDown bool
+
+	// mu serializes container processing between the event loop and the admin API
+	mu sync.Mutex
 }
 
-// CompatibilityConfig holds the configuration options for the compatibility layer.
-// It controls the behavior of the dinghy compatibility service including dry-run
-// mode, logging level, and the directory where Traefik dynamic configuration
-// files should be written.
+// CompatibilityConfig holds the configuration options for the compatibility
+// layer. It controls the behavior of the dinghy compatibility service including
+// dry-run mode, logging level, and the directory where Traefik dynamic
+// configuration files should be written.
 type CompatibilityConfig struct {
 	DryRun            bool
 	LogLevel          string
 	TraefikDynamicDir string
+
+	// TemplateFile is an optional template overriding the generated YAML.
+	TemplateFile string
+
+	// MDNSEnabled advertises managed hostnames as .local aliases resolving to
+	// MDNSIP.
+	MDNSEnabled bool
+	MDNSIP      string
+
+	// AdminAddr is the listen address of the admin API; an empty value disables
+	// it.
+	AdminAddr string
+
+	// CertsDir is checked for files Traefik cannot read.
+	CertsDir string
+
+	// CertsHostDir is the host directory CertsDir is mounted from, used in
+	// reported fixes.
+	CertsHostDir string
+
+	// A positive ProbeInterval requests ProbePath of every route through the
+	// proxy at ProbeTarget on that interval.
+	ProbeInterval time.Duration
+	ProbeTarget   string
+	ProbePath     string
+
+	// A positive CertProbeInterval compares the certificate served at
+	// CertProbeTarget for every hostname with the one in CertsDir covering it.
+	CertProbeInterval time.Duration
+	CertProbeTarget   string
+
+	// MetadataLabels selects the container labels attached to routes as
+	// metadata.
+	MetadataLabels string
+
+	// A positive ReconcileInterval repairs config drift on that interval.
+	ReconcileInterval time.Duration
+
+	// OverridesDir holds the per-container override snippets merged into the
+	// generated configs.
+	OverridesDir string
+
+	// StateDir is the shared state volume the admin API reads the join-networks
+	// and DNS server snapshots from (empty disables them).
+	StateDir string
+
+	// PreferredNetworks names the networks a container attached to several is
+	// reached on, in order of preference.
+	PreferredNetworks []string
+
+	// FaultEndpoint is the URL of the admin API's fault endpoint as seen from
+	// Traefik.
+	FaultEndpoint string
+
+	// ForceHTTPS redirects the HTTP routes of every container to HTTPS.
+	ForceHTTPS bool
+
+	// PortProbe dials PortProbePorts from the PortProbeContainer to pick the
+	// port of containers without port information.
+	PortProbe          bool
+	PortProbePorts     []string
+	PortProbeContainer string
+
+	// MergeReplicas routes the replicas of a compose service through one
+	// service.
+	MergeReplicas bool
+
+	// A positive WriteDebounce collects the config writes of event bursts and
+	// writes them once events stop for that long.
+	WriteDebounce time.Duration
+
+	// HostCollisions orders the containers serving the same hostname: warn,
+	// newest, oldest or weight.
+	HostCollisions string
+
+	// RedirectsDir holds the catalog of retired hostnames redirected to their
+	// replacements (empty disables it).
+	RedirectsDir string
+
+	// ProxyContainer is the Traefik container, inspected for its networks when
+	// the join-networks snapshot is unavailable.
+	ProxyContainer string
+
+	// SelectionMode is all, routing containers unless they opt out, or
+	// explicit, routing only those opting in.
+	SelectionMode string
+
+	// DryRunColor colours the diffs printed in dry-run mode, on a terminal
+	// only.
+	DryRunColor bool
+
+	// RoutesFile is the routes snapshot kept for host tooling.
+	RoutesFile string
+
+	// DefaultCert names the certificate of CertsDir Traefik serves when none
+	// matches, "auto" for its wildcard certificate (empty keeps Traefik's own).
+	DefaultCert string
+
+	// StreamEntryPoints are the TCP and UDP entry points declared in Traefik's
+	// static configuration; stream routes to any other are rejected.
+	StreamEntryPoints []string
 }
 
-// Validate checks if the configuration is valid
+// Validate checks if the configuration is valid and normalizes the selection
+// mode
 func (c *CompatibilityConfig) Validate() error {
 	if c.TraefikDynamicDir == "" {
 		return fmt.Errorf("traefik dynamic directory cannot be empty")
 	}
 
+	if c.MDNSEnabled {
+		if ip := net.ParseIP(c.MDNSIP); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("mDNS IP %q must be a valid IPv4 address", c.MDNSIP)
+		}
+	}
+
+	if c.ProbeInterval < 0 {
+		return fmt.Errorf("probe interval cannot be negative")
+	}
+	if c.ProbeInterval > 0 {
+		if _, err := parseProbeTarget(c.ProbeTarget); err != nil {
+			return err
+		}
+	}
+
+	if c.CertProbeInterval < 0 {
+		return fmt.Errorf("certificate probe interval cannot be negative")
+	}
+	if c.CertProbeInterval > 0 {
+		if _, _, err := net.SplitHostPort(c.CertProbeTarget); err != nil {
+			return fmt.Errorf("invalid certificate probe target %q: %w", c.CertProbeTarget, err)
+		}
+	}
+
+	if c.ReconcileInterval < 0 {
+		return fmt.Errorf("reconcile interval cannot be negative")
+	}
+
+	if c.WriteDebounce < 0 {
+		return fmt.Errorf("write debounce cannot be negative")
+	}
+
+	if _, err := parseCollisionPolicy(c.HostCollisions); err != nil {
+		return err
+	}
+
+	mode, err := parseSelectionMode(c.SelectionMode)
+	if err != nil {
+		return err
+	}
+	c.SelectionMode = mode
+
+	if c.PortProbe && (len(c.PortProbePorts) == 0 || c.PortProbeContainer == "") {
+		return fmt.Errorf("port probing needs HTTP_PROXY_PORT_PROBE_PORTS and HTTP_PROXY_PORT_PROBE_CONTAINER")
+	}
+
 	return utils.ValidateLogLevel(c.LogLevel)
 }
 
 // NewCompatibilityLayer creates a new CompatibilityLayer instance
-func NewCompatibilityLayer(cfg *CompatibilityConfig) *CompatibilityLayer {
-	return &CompatibilityLayer{
-		config: cfg,
+func NewCompatibilityLayer(cfg *CompatibilityConfig) (*CompatibilityLayer, error) {
+	cl := &CompatibilityLayer{
+		config:  cfg,
+		routes:  newRouteInventory(),
+		stops:   newStopTracker(),
+		metrics: metrics.NewRegistry(),
+		writes:  newWriteBuffer(cfg.WriteDebounce),
+	}
+
+	metadata, err := parseMetadataLabels(cfg.MetadataLabels)
+	if err != nil {
+		return nil, err
+	}
+	cl.metadata = metadata
+
+	if cfg.StateDir != "" {
+		cl.state = state.NewStore(cfg.StateDir)
 	}
+
+	if cfg.TemplateFile != "" {
+		tmpl, err := loadConfigTemplate(cfg.TemplateFile)
+		if err != nil {
+			return nil, err
+		}
+		cl.template = tmpl
+	}
+
+	return cl, nil
 }
 
 // GetName returns the service name
@@ -77,37 +318,218 @@ func (cl *CompatibilityLayer) GetName() string {
 	return "dinghy-compatibility"
 }
 
+// SetDockerTimeout sets the timeout of each Docker API call from the service
+// framework
+func (cl *CompatibilityLayer) SetDockerTimeout(timeout time.Duration) {
+	cl.dockerTimeout = timeout
+}
+
 // SetDependencies sets the Docker client and logger from the service framework
 func (cl *CompatibilityLayer) SetDependencies(dockerClient *client.Client, logger *logger.Logger) {
 	cl.dockerClient = dockerClient
 	cl.logger = logger
+	cl.drift = cl.metrics.Gauge("http_proxy_config_drift", "Config files found drifted by the last reconciliation, by kind.", "kind")
+	cl.faultsInjected = cl.metrics.Counter("http_proxy_faults_injected_total", "Faults injected into routes by kind.", "service", "fault")
+	cl.events = newEventBroker()
+
+	if cl.config.MDNSEnabled {
+		cl.mdns = mdns.NewResponder(net.ParseIP(cl.config.MDNSIP), logger.With("subsystem", "mdns"))
+	}
+
+	if cl.config.ProbeInterval > 0 {
+		// The target was checked by Validate, so this cannot fail
+		cl.prober, _ = newRouteProber(cl.config.ProbeTarget, cl.config.ProbePath, cl.config.ProbeInterval,
+			cl.routes, cl.metrics, logger.With("subsystem", "probe"))
+	}
+
+	if cl.config.CertProbeInterval > 0 {
+		// The target was checked by Validate, so this cannot fail
+		cl.certProber, _ = newCertProber(cl.config.CertProbeTarget, cl.config.CertsDir, cl.config.CertProbeInterval,
+			cl.routes, cl.metrics, logger.With("subsystem", "cert-probe"))
+	}
+}
+
+// RunBackground runs the optional subsystems (admin API and its event stream,
+// mDNS responder, route and certificate probes, reconciler, redirect catalog
+// and default certificate watchers) for the lifetime of the service. A
+// failing subsystem is logged and does not stop the others or the event loop.
+func (cl *CompatibilityLayer) RunBackground(ctx context.Context) {
+	var wg sync.WaitGroup
+	run := func(name string, fn func(context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(ctx); err != nil {
+				cl.logger.Error("Background subsystem stopped", "subsystem", name, "error", err)
+			}
+		}()
+	}
+
+	if cl.config.AdminAddr != "" {
+		run("admin-api", cl.runAdminServer)
+		run("events", cl.runEventPoller)
+	}
+	if cl.mdns != nil {
+		run("mdns", cl.mdns.Run)
+	}
+	if cl.prober != nil {
+		run("probe", cl.prober.Run)
+	}
+	if cl.certProber != nil {
+		run("cert-probe", cl.certProber.Run)
+	}
+	if cl.config.ReconcileInterval > 0 {
+		run("reconcile", cl.runReconciler)
+	}
+	if cl.config.RedirectsDir != "" {
+		run("redirects", cl.runRedirectsWatcher)
+	}
+	if cl.config.DefaultCert == defaultCertAuto {
+		run("default-cert", cl.runDefaultCertWatcher)
+	}
+
+	<-ctx.Done()
+	wg.Wait()
+
+	// Debounced writes still waiting are not lost on shutdown
+	cl.mu.Lock()
+	cl.flushWrites()
+	cl.mu.Unlock()
 }
 
 // ContainerInfo holds essential container information extracted from Docker
 // container inspection. This struct contains the minimal set of data needed
 // to generate Traefik configuration from nginx-proxy environment variables.
+// GeoCountry and RequestHeaders ask for synthetic production-like request
+// headers on the container's routes; SecurityHeaders selects a response
+// security headers preset for its HTTPS routes. VirtualPath and VirtualDest
+// restrict the routes to a path prefix and rewrite it. VirtualProto and
+// BackendSkipVerify select how the backend is reached. The Fault fields ask for
+// latency and errors injected into the routes. HTTPSMethod, HostWeight,
+// CertName and NetworkAccess carry over the matching nginx-proxy variables;
+// HTTPSRedirect asks for, or opts out of, the redirect to HTTPS. The CORS
+// fields answer cross-origin requests from the CORS_* variables.
+// VirtualTCPPort and VirtualUDPPort route Traefik entry points to container
+// ports for non-HTTP services; VirtualTCPTLS matches TCP routes by TLS server
+// name. TLSPassthrough routes the HTTPS connections of the VIRTUAL_HOST names
+// to the container with TLS untouched. StickyCookie names the cookie pinning
+// clients to one replica.
+// Preset selects production-like body limits, timeouts and buffering.
+// Enable opts the container in or out, from HTTP_PROXY_ENABLE or the
+// http-proxy.enable label.
+// Project is the compose project the container belongs to, if any, and
+// Created when the container was created.
+// BasicAuth holds the htpasswd users protecting the routes, from
+// HTTP_PROXY_BASIC_AUTH or the http-proxy.basic-auth label. Middlewares,
+// Priority and EntryPoints come from the http-proxy.* labels overriding the
+// generated routers.
 type ContainerInfo struct {
-	ID          string
-	Name        string
-	VirtualHost string
-	VirtualPort string
-	IsRunning   bool
+	ID                   string
+	Name                 string
+	VirtualHost          string
+	VirtualPort          string
+	VirtualPath          string
+	VirtualDest          string
+	VirtualProto         string
+	BackendSkipVerify    string
+	FaultLatency         string
+	FaultErrorRate       string
+	FaultStatus          string
+	GeoCountry           string
+	RequestHeaders       string
+	SecurityHeaders      string
+	AuthBypassPaths      string
+	BasicAuth            string
+	HTTPSMethod          string
+	HostWeight           string
+	CertName             string
+	NetworkAccess        string
+	HTTPSRedirect        string
+	CORSAllowOrigins     string
+	CORSAllowMethods     string
+	CORSAllowHeaders     string
+	CORSAllowCredentials string
+	CORSMaxAge           string
+	VirtualTCPPort       string
+	VirtualUDPPort       string
+	VirtualTCPTLS        string
+	TLSPassthrough       string
+	StickyCookie         string
+	Preset               string
+	Enable               string
+	Middlewares          string
+	Priority             string
+	EntryPoints          string
+	Project              string
+	Created              time.Time
+	Metadata             map[string]string
+	IsRunning            bool
 }
 
 // extractContainerInfo extracts relevant information from a container inspection
 func (cl *CompatibilityLayer) extractContainerInfo(inspect types.ContainerJSON) ContainerInfo {
 	return ContainerInfo{
-		ID:          inspect.ID,
-		Name:        strings.TrimPrefix(inspect.Name, "/"),
-		VirtualHost: utils.GetDockerEnvVar(inspect.Config.Env, "VIRTUAL_HOST"),
-		VirtualPort: utils.GetDockerEnvVar(inspect.Config.Env, "VIRTUAL_PORT"),
-		IsRunning:   inspect.State.Running,
+		ID:                   inspect.ID,
+		Name:                 strings.TrimPrefix(inspect.Name, "/"),
+		VirtualHost:          utils.GetDockerEnvVar(inspect.Config.Env, "VIRTUAL_HOST"),
+		VirtualPort:          utils.GetDockerEnvVar(inspect.Config.Env, "VIRTUAL_PORT"),
+		VirtualPath:          utils.GetDockerEnvVar(inspect.Config.Env, "VIRTUAL_PATH"),
+		VirtualDest:          utils.GetDockerEnvVar(inspect.Config.Env, "VIRTUAL_DEST"),
+		VirtualProto:         utils.GetDockerEnvVar(inspect.Config.Env, "VIRTUAL_PROTO"),
+		BackendSkipVerify:    utils.GetDockerEnvVar(inspect.Config.Env, "HTTP_PROXY_BACKEND_SKIP_VERIFY"),
+		FaultLatency:         utils.GetDockerEnvVar(inspect.Config.Env, "HTTP_PROXY_FAULT_LATENCY"),
+		FaultErrorRate:       utils.GetDockerEnvVar(inspect.Config.Env, "HTTP_PROXY_FAULT_ERROR_RATE"),
+		FaultStatus:          utils.GetDockerEnvVar(inspect.Config.Env, "HTTP_PROXY_FAULT_STATUS"),
+		GeoCountry:           utils.GetDockerEnvVar(inspect.Config.Env, "HTTP_PROXY_GEO_COUNTRY"),
+		RequestHeaders:       utils.GetDockerEnvVar(inspect.Config.Env, "HTTP_PROXY_REQUEST_HEADERS"),
+		SecurityHeaders:      utils.GetDockerEnvVar(inspect.Config.Env, "HTTP_PROXY_SECURITY_HEADERS"),
+		AuthBypassPaths:      utils.GetDockerEnvVar(inspect.Config.Env, "HTTP_PROXY_AUTH_BYPASS_PATHS"),
+		BasicAuth:            basicAuthSetting(inspect.Config.Env, inspect.Config.Labels),
+		HTTPSMethod:          utils.GetDockerEnvVar(inspect.Config.Env, "HTTPS_METHOD"),
+		HostWeight:           utils.GetDockerEnvVar(inspect.Config.Env, "VIRTUAL_HOST_WEIGHT"),
+		CertName:             utils.GetDockerEnvVar(inspect.Config.Env, "CERT_NAME"),
+		NetworkAccess:        utils.GetDockerEnvVar(inspect.Config.Env, "NETWORK_ACCESS"),
+		HTTPSRedirect:        utils.GetDockerEnvVar(inspect.Config.Env, "HTTPS_REDIRECT"),
+		CORSAllowOrigins:     utils.GetDockerEnvVar(inspect.Config.Env, "CORS_ALLOW_ORIGINS"),
+		CORSAllowMethods:     utils.GetDockerEnvVar(inspect.Config.Env, "CORS_ALLOW_METHODS"),
+		CORSAllowHeaders:     utils.GetDockerEnvVar(inspect.Config.Env, "CORS_ALLOW_HEADERS"),
+		CORSAllowCredentials: utils.GetDockerEnvVar(inspect.Config.Env, "CORS_ALLOW_CREDENTIALS"),
+		CORSMaxAge:           utils.GetDockerEnvVar(inspect.Config.Env, "CORS_MAX_AGE"),
+		VirtualTCPPort:       utils.GetDockerEnvVar(inspect.Config.Env, "VIRTUAL_TCP_PORT"),
+		VirtualUDPPort:       utils.GetDockerEnvVar(inspect.Config.Env, "VIRTUAL_UDP_PORT"),
+		VirtualTCPTLS:        utils.GetDockerEnvVar(inspect.Config.Env, "VIRTUAL_TCP_TLS"),
+		TLSPassthrough:       utils.GetDockerEnvVar(inspect.Config.Env, "VIRTUAL_TLS_PASSTHROUGH"),
+		StickyCookie:         utils.GetDockerEnvVar(inspect.Config.Env, "HTTP_PROXY_STICKY_COOKIE"),
+		Preset:               utils.GetDockerEnvVar(inspect.Config.Env, "HTTP_PROXY_PRESET"),
+		Enable:               enableSetting(inspect.Config.Env, inspect.Config.Labels),
+		Middlewares:          inspect.Config.Labels[middlewaresLabel],
+		Priority:             inspect.Config.Labels[priorityLabel],
+		EntryPoints:          inspect.Config.Labels[entryPointsLabel],
+		Project:              containerProject(inspect),
+		Created:              containerCreated(inspect),
+		Metadata:             routeMetadata(cl.metadata, inspect.Config.Labels),
+		IsRunning:            inspect.State.Running,
 	}
 }
 
 // HandleInitialScan performs initial processing of existing containers
 func (cl *CompatibilityLayer) HandleInitialScan(ctx context.Context) error {
-	containers, err := utils.RetryContainerList(ctx, cl.dockerClient, container.ListOptions{})
+	if err := cl.checkPermissions(); err != nil {
+		return err
+	}
+
+	cl.mu.Lock()
+	cl.loadPaused()
+	cl.loadStaticRoutes()
+	if err := cl.applyRedirects(); err != nil {
+		cl.logger.Error("Failed to apply redirect catalog", "error", err)
+	}
+	if err := cl.applyDefaultCert(); err != nil {
+		cl.logger.Error("Failed to set the default certificate", "error", err)
+	}
+	cl.mu.Unlock()
+
+	containers, err := utils.RetryContainerList(ctx, cl.dockerClient, cl.dockerTimeout, container.ListOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to list containers: %w", err)
 	}
@@ -119,7 +541,10 @@ func (cl *CompatibilityLayer) HandleInitialScan(ctx context.Context) error {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
-			if err := cl.processContainer(ctx, cont.ID); err != nil {
+			cl.mu.Lock()
+			err := cl.processContainer(ctx, cont.ID)
+			cl.mu.Unlock()
+			if err != nil {
 				cl.logger.Error("Failed to process container",
 					"error", err,
 					"container_id", utils.FormatDockerID(cont.ID),
@@ -129,31 +554,157 @@ func (cl *CompatibilityLayer) HandleInitialScan(ctx context.Context) error {
 		}
 	}
 
-	return nil
+	// Containers that stopped while the layer was down sent no event
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	_, err = cl.removeOrphanedConfigs(containers)
+	return err
 }
 
 // HandleEvent processes a Docker event
 func (cl *CompatibilityLayer) HandleEvent(ctx context.Context, event events.Message) error {
-	switch event.Action {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	ev := service.ParseContainerEvent(event)
+	switch ev.Action {
 	case "start":
-		return cl.processContainer(ctx, event.Actor.ID)
+		cl.stops.forget(ev.ContainerID)
+		return cl.bufferingWrites(func() error {
+			return cl.processContainer(ctx, ev.ContainerID)
+		})
+	case "kill":
+		if isStopSignal(ev.Signal) {
+			cl.stops.stopRequested(ev.ContainerID, ev.Time)
+		}
+		return nil
 	case "die":
-		return cl.removeTraefikConfig(event.Actor.ID)
+		cl.recordStop(ev)
+		return cl.bufferingWrites(func() error {
+			if err := cl.removeTraefikConfig(ev.ContainerID); err != nil {
+				return err
+			}
+			return cl.refreshReplicas(ctx, ev.Labels)
+		})
+	case "destroy":
+		cl.stops.forget(ev.ContainerID)
+		return nil
+	case "connect", "disconnect", "restart", "unpause":
+		if ev.Action == "restart" {
+			cl.stops.forget(ev.ContainerID)
+		}
+		return cl.bufferingWrites(func() error {
+			return cl.refreshContainerIP(ctx, ev.ContainerID, ev.Network)
+		})
 	default:
 		// Unhandled events are not an error, just log and continue
-		cl.logger.Debug("Unhandled container action", "action", event.Action, "container_id", utils.FormatDockerID(event.Actor.ID))
+		cl.logger.Debug("Unhandled container action", ev.LogArgs()...)
 		return nil
 	}
 }
 
+// ContainerActions subscribes to the kill events telling requested stops
+// from crashes, to destroy events forgetting removed containers, and to the
+// restart and unpause events after which a container's IP may differ.
+func (cl *CompatibilityLayer) ContainerActions() []events.Action {
+	return []events.Action{events.ActionKill, events.ActionDestroy, events.ActionRestart, events.ActionUnPause}
+}
+
+// NetworkActions subscribes to containers being connected to or
+// disconnected from networks, which can change the IP they are reached on.
+func (cl *CompatibilityLayer) NetworkActions() []events.Action {
+	return []events.Action{events.ActionConnect, events.ActionDisconnect}
+}
+
+// recordStop remembers why a container serving routes stopped.
+func (cl *CompatibilityLayer) recordStop(ev service.ContainerEvent) {
+	routes, ok := cl.routes.get(ev.ContainerID)
+	if !ok {
+		cl.stops.forget(ev.ContainerID)
+		return
+	}
+
+	entry := cl.stops.died(routes, ev)
+	args := append(ev.LogArgs(), "reason", entry.Reason, "hostnames", routes.Hostnames)
+	if entry.Reason == stopReasonCrashed {
+		cl.logger.Warn("Container exited unexpectedly, removing its routes", args...)
+	} else {
+		cl.logger.Info("Container stopped, parking its routes", args...)
+	}
+}
+
 func main() {
 	ctx := context.Background()
 
 	// Initialize configuration
 	cfg := &CompatibilityConfig{
-		DryRun:            config.GetEnvOrDefault("DRY_RUN", "false") == "true",
-		LogLevel:          config.GetEnvOrDefault("LOG_LEVEL", "info"),
-		TraefikDynamicDir: config.GetEnvOrDefault("TRAEFIK_DYNAMIC_DIR", DefaultTraefikDynamicDir),
+		DryRun:             config.GetEnvOrDefault("DRY_RUN", "false") == "true",
+		LogLevel:           config.GetEnvOrDefault("LOG_LEVEL", "info"),
+		TraefikDynamicDir:  config.GetEnvOrDefault("TRAEFIK_DYNAMIC_DIR", DefaultTraefikDynamicDir),
+		TemplateFile:       config.GetEnvOrDefault("TRAEFIK_CONFIG_TEMPLATE", ""),
+		MDNSEnabled:        config.GetEnvOrDefault("HTTP_PROXY_MDNS_ENABLED", "false") == "true",
+		MDNSIP:             config.GetEnvOrDefault("HTTP_PROXY_MDNS_IP", ""),
+		AdminAddr:          config.GetEnvOrDefault("HTTP_PROXY_ADMIN_ADDR", DefaultAdminAddr),
+		CertsDir:           config.GetEnvOrDefault("HTTP_PROXY_CERTS_DIR", DefaultCertsDir),
+		CertsHostDir:       config.GetEnvOrDefault("HTTP_PROXY_CERTS_HOST_DIR", ""),
+		ProbeTarget:        config.GetEnvOrDefault("HTTP_PROXY_PROBE_TARGET", DefaultProbeTarget),
+		ProbePath:          config.GetEnvOrDefault("HTTP_PROXY_PROBE_PATH", DefaultProbePath),
+		CertProbeTarget:    config.GetEnvOrDefault("HTTP_PROXY_CERT_PROBE_TARGET", DefaultCertProbeTarget),
+		MetadataLabels:     config.GetEnvOrDefault("HTTP_PROXY_METADATA_LABELS", DefaultMetadataLabels),
+		OverridesDir:       config.GetEnvOrDefault("HTTP_PROXY_OVERRIDES_DIR", DefaultOverridesDir),
+		StateDir:           config.GetEnvOrDefault("HTTP_PROXY_STATE_DIR", state.DefaultDir),
+		PreferredNetworks:  parsePreferredNetworks(config.GetEnvOrDefault("HTTP_PROXY_PREFERRED_NETWORKS", "")),
+		FaultEndpoint:      config.GetEnvOrDefault("HTTP_PROXY_FAULT_ENDPOINT", DefaultFaultEndpoint),
+		ForceHTTPS:         config.GetEnvOrDefault("HTTP_PROXY_FORCE_HTTPS", "false") == "true",
+		PortProbe:          config.GetEnvOrDefault("HTTP_PROXY_PORT_PROBE", "false") == "true",
+		PortProbeContainer: config.GetEnvOrDefault("HTTP_PROXY_PORT_PROBE_CONTAINER", DefaultPortProbeContainer),
+		MergeReplicas:      config.GetEnvOrDefault("HTTP_PROXY_MERGE_REPLICAS", "true") == "true",
+		HostCollisions:     config.GetEnvOrDefault("HTTP_PROXY_HOST_COLLISIONS", collisionsWarn),
+		RedirectsDir:       config.GetEnvOrDefault("HTTP_PROXY_REDIRECTS_DIR", DefaultRedirectsDir),
+		ProxyContainer:     config.GetEnvOrDefault("HTTP_PROXY_CONTAINER_NAME", DefaultProxyContainer),
+		SelectionMode:      config.GetEnvOrDefault("HTTP_PROXY_MODE", selectionAll),
+		DryRunColor:        os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout),
+		RoutesFile:         config.GetEnvOrDefault("HTTP_PROXY_ROUTES_FILE", ""),
+		DefaultCert:        strings.TrimSpace(config.GetEnvOrDefault("HTTP_PROXY_DEFAULT_CERT", "")),
+		StreamEntryPoints:  splitList(config.GetEnvOrDefault("HTTP_PROXY_STREAM_ENTRYPOINTS", "")),
+	}
+
+	portProbePorts, err := parsePortProbePorts(config.GetEnvOrDefault("HTTP_PROXY_PORT_PROBE_PORTS", DefaultPortProbePorts))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+	cfg.PortProbePorts = portProbePorts
+
+	probeInterval, err := time.ParseDuration(config.GetEnvOrDefault("HTTP_PROXY_PROBE_INTERVAL", "0s"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: HTTP_PROXY_PROBE_INTERVAL: %v\n", err)
+		os.Exit(1)
+	}
+	cfg.ProbeInterval = probeInterval
+
+	certProbeInterval, err := time.ParseDuration(config.GetEnvOrDefault("HTTP_PROXY_CERT_PROBE_INTERVAL", "0s"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: HTTP_PROXY_CERT_PROBE_INTERVAL: %v\n", err)
+		os.Exit(1)
+	}
+	cfg.CertProbeInterval = certProbeInterval
+
+	reconcileInterval, err := time.ParseDuration(config.GetEnvOrDefault("HTTP_PROXY_RECONCILE_INTERVAL", DefaultReconcileInterval))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: HTTP_PROXY_RECONCILE_INTERVAL: %v\n", err)
+		os.Exit(1)
+	}
+	cfg.ReconcileInterval = reconcileInterval
+
+	writeDebounce, err := time.ParseDuration(config.GetEnvOrDefault("HTTP_PROXY_WRITE_DEBOUNCE", DefaultWriteDebounce))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: HTTP_PROXY_WRITE_DEBOUNCE: %v\n", err)
+		os.Exit(1)
+	}
+	cfg.WriteDebounce = writeDebounce
+	if cfg.RoutesFile == "" {
+		cfg.RoutesFile = filepath.Join(filepath.Dir(cfg.TraefikDynamicDir), routesFileName)
 	}
 
 	// Validate configuration
@@ -163,7 +714,11 @@ func main() {
 	}
 
 	// Create handler
-	handler := NewCompatibilityLayer(cfg)
+	handler, err := NewCompatibilityLayer(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
 
 	// Run service with shared framework
 	if err := service.RunWithSignalHandling(ctx, handler.GetName(), cfg.LogLevel, handler); err != nil {
@@ -172,98 +727,184 @@ func main() {
 	}
 }
 
+// processContainer generates a container's config and routes, writes the
+// config and records the routes in the inventory.
 func (cl *CompatibilityLayer) processContainer(ctx context.Context, containerID string) error {
-	inspect, err := utils.RetryContainerInspect(ctx, cl.dockerClient, containerID)
-	if err != nil {
-		return fmt.Errorf("failed to inspect container %s: %w", containerID, err)
+	render, err := cl.loadContainer(ctx, containerID)
+	if err != nil || render == nil {
+		return err
 	}
 
-	// Extract container information
-	containerInfo := cl.extractContainerInfo(inspect)
-
-	// Skip if container is not running
-	if !containerInfo.IsRunning {
-		cl.logger.Debug("Skipping non-running container",
-			"container_id", utils.FormatDockerID(containerID),
-			"container_name", containerInfo.Name)
+	if render.labelled {
+		cl.importLabelRoutes(render.inspect, render.info)
 		return nil
 	}
 
-	// Skip if no VIRTUAL_HOST found
-	if containerInfo.VirtualHost == "" {
-		cl.logger.Debug("Skipping container without VIRTUAL_HOST",
-			"container_id", utils.FormatDockerID(containerID),
-			"container_name", containerInfo.Name)
-		return nil
+	cl.probeContainerPort(ctx, render.inspect, &render.info)
+
+	cl.logger.Info("Found container with VIRTUAL_HOST",
+		"container_id", utils.FormatDockerID(containerID),
+		"container_name", render.info.Name,
+		"virtual_host", render.info.VirtualHost,
+		"virtual_port", render.info.VirtualPort,
+		"virtual_path", render.info.VirtualPath)
+
+	if err := cl.renderContainer(ctx, render); err != nil {
+		return err
+	}
+	if render.leaderID != "" {
+		return cl.deferToReplicaLeader(ctx, render.inspect.ID, render.leaderID, render.replicaSet)
+	}
+
+	// Replicas merged into the leader's config drop the configs they wrote
+	for _, replica := range render.replicas {
+		if err := cl.removeTraefikConfig(replica.ID); err != nil {
+			return err
+		}
 	}
 
-	// Skip if traefik labels are already set; native labels take precedence and
-	// Traefik's Docker provider handles those containers directly.
-	if utils.HasTraefikLabel(inspect.Config.Labels) {
-		cl.logger.Debug("Skipping container with existing Traefik label",
+	if render.traefik != nil {
+		cl.logger.Info("Generated Traefik configuration",
 			"container_id", utils.FormatDockerID(containerID),
-			"container_name", containerInfo.Name)
-		return nil
+			"routers", len(render.traefik.HTTP.Routers),
+			"services", len(render.traefik.HTTP.Services))
+	} else {
+		cl.logger.Info("Rendered Traefik configuration from template",
+			"container_id", utils.FormatDockerID(containerID),
+			"template", cl.config.TemplateFile)
 	}
 
-	cl.logger.Info("Found container with VIRTUAL_HOST",
-		"container_id", utils.FormatDockerID(containerID),
-		"container_name", containerInfo.Name,
-		"virtual_host", containerInfo.VirtualHost,
-		"virtual_port", containerInfo.VirtualPort)
+	if err := cl.writeConfigData(containerID, render.config); err != nil {
+		return err
+	}
+	cl.recordReplicaSet(containerID, render.replicaSet)
 
-	// Generate Traefik configuration
-	traefikConfig := cl.generateTraefikConfig(inspect, containerInfo)
+	if render.routes.ServiceName != "" {
+		cl.recordRoutes(render.routes)
+	}
+	return nil
+}
 
-	cl.logger.Info("Generated Traefik configuration",
-		"container_id", utils.FormatDockerID(containerID),
-		"routers", len(traefikConfig.HTTP.Routers),
-		"services", len(traefikConfig.HTTP.Services))
+// recordRoutes adds a container's generated routes to the inventory.
+func (cl *CompatibilityLayer) recordRoutes(routes ContainerRoutes) {
+	cl.routes.set(routes)
+	cl.routesChanged()
+}
 
-	// Write Traefik configuration to file
-	return cl.writeTraefikConfig(containerID, traefikConfig)
+// routeClaim returns the routes a container's VIRTUAL_HOST asks for,
+// without the backend.
+func (cl *CompatibilityLayer) routeClaim(containerInfo ContainerInfo, serviceName string) ContainerRoutes {
+	var hostnames []string
+	for _, host := range parseVirtualHosts(containerInfo.VirtualHost) {
+		hostnames = append(hostnames, host.hostname)
+	}
+
+	path, _ := parseVirtualPath(containerInfo.VirtualPath, containerInfo.VirtualDest)
+	settings, _ := parseNginxProxySettings(containerInfo.HTTPSMethod, containerInfo.HostWeight, containerInfo.CertName, containerInfo.NetworkAccess)
+	if strings.TrimSpace(containerInfo.HTTPSMethod) == "" {
+		if redirect, _ := parseHTTPSRedirect(containerInfo.HTTPSRedirect, cl.config.ForceHTTPS); redirect {
+			settings.httpsMethod = httpsMethodRedirect
+		}
+	}
+	return ContainerRoutes{
+		ContainerID:   containerInfo.ID,
+		ContainerName: containerInfo.Name,
+		ServiceName:   serviceName,
+		Hostnames:     hostnames,
+		Path:          path.prefix,
+		Source:        routeSourceVirtualHost,
+		Project:       containerInfo.Project,
+		Metadata:      containerInfo.Metadata,
+		Created:       containerInfo.Created,
+		Weight:        settings.weight,
+		TLS:           routeTLS(settings.httpsMethod),
+	}
 }
 
 func (cl *CompatibilityLayer) generateTraefikConfig(inspect types.ContainerJSON, containerInfo ContainerInfo) *config.TraefikConfig {
 	traefikConfig := config.NewTraefikConfig()
 
 	// Generate service name from container name
-	serviceName := generateServiceName(inspect.Name)
+	serviceName := containerServiceName(inspect)
 
 	// Parse VIRTUAL_HOST (can contain multiple hosts separated by commas)
 	hosts := parseVirtualHosts(containerInfo.VirtualHost)
 
 	// Get container IP address
-	containerIP := getContainerIP(inspect)
+	containerIP := cl.containerIP(inspect)
 	if containerIP == "" {
 		cl.logger.Error("Could not determine container IP", "container_id", utils.FormatDockerID(inspect.ID))
 		return traefikConfig
 	}
 
+	cl.addStreamRouters(traefikConfig, serviceName, containerIP, hosts, containerInfo)
+	if len(hosts) == 0 {
+		return traefikConfig
+	}
+
+	// Routing a path of the host is no substitute for routing none of it
+	path, err := parseVirtualPath(containerInfo.VirtualPath, containerInfo.VirtualDest)
+	if err != nil {
+		cl.logger.Error("Skipping container with invalid path routing",
+			"container_id", utils.FormatDockerID(inspect.ID),
+			"error", err)
+		return traefikConfig
+	}
+	proto, err := parseBackendProto(containerInfo.VirtualProto, containerInfo.BackendSkipVerify)
+	if err != nil {
+		cl.logger.Error("Skipping container with unsupported backend protocol",
+			"container_id", utils.FormatDockerID(inspect.ID),
+			"error", err)
+		return traefikConfig
+	}
+	// A container asking for credentials is not served without them
+	users, ok := cl.basicAuthUsers(containerInfo)
+	if !ok {
+		cl.logger.Error("Skipping container without valid basic auth users",
+			"container_id", utils.FormatDockerID(inspect.ID))
+		return traefikConfig
+	}
+
+	// Every host is routed to its own port, each port to its own service
+	primaryPort := getEffectivePort(hosts, containerInfo.VirtualPort, inspect)
+
+	// The container terminates TLS itself: its hosts get no HTTP routers
+	if passthrough, err := parseSwitch("VIRTUAL_TLS_PASSTHROUGH", containerInfo.TLSPassthrough); err != nil || passthrough {
+		if err == nil {
+			err = addPassthroughRouter(traefikConfig, serviceName, containerIP, primaryPort, hosts)
+		}
+		if err != nil {
+			cl.logger.Error("Skipping container with invalid TLS passthrough",
+				"container_id", utils.FormatDockerID(inspect.ID),
+				"error", err)
+		}
+		return traefikConfig
+	}
+	defaultPort := fallbackPort(containerInfo.VirtualPort, inspect)
+	services := newPortServices(serviceName, primaryPort, cl.routes.serviceNames(inspect.ID))
+	servicePorts := map[string]string{serviceName: primaryPort}
+	hostRouters := make(map[string][]string)
+
 	for i, host := range hosts {
 		routerName := fmt.Sprintf("%s-%d", serviceName, i)
 
 		// Set up router rule
-		var rule string
-		if isWildcardHost(host.hostname) {
-			// Handle wildcard hosts
-			regexPattern := convertWildcardToRegex(host.hostname)
-			if regexPattern == "" {
-				cl.logger.Warn("Skipping invalid hostname (potential ReDoS attack)",
-					"container_id", utils.FormatDockerID(inspect.ID),
-					"hostname", host.hostname)
-				continue
-			}
-			rule = fmt.Sprintf("HostRegexp(`%s`)", regexPattern)
-		} else {
-			// Regular host
-			rule = fmt.Sprintf("Host(`%s`)", host.hostname)
+		rule := path.rule(hostRule(host.hostname))
+		if rule == "" {
+			cl.logger.Warn("Skipping invalid hostname (potential ReDoS attack)",
+				"container_id", utils.FormatDockerID(inspect.ID),
+				"hostname", host.hostname)
+			continue
 		}
 
+		port := hostPort(host, defaultPort)
+		hostService := services.name(port)
+		servicePorts[hostService] = port
+
 		// Create HTTP router
 		httpRouter := &config.Router{
 			Rule:        rule,
-			Service:     serviceName,
+			Service:     hostService,
 			EntryPoints: []string{"http"},
 		}
 		traefikConfig.HTTP.Routers[routerName] = httpRouter
@@ -272,50 +913,124 @@ func (cl *CompatibilityLayer) generateTraefikConfig(inspect types.ContainerJSON,
 		httpsRouterName := fmt.Sprintf("%s-tls-%d", serviceName, i)
 		httpsRouter := &config.Router{
 			Rule:        rule,
-			Service:     serviceName,
+			Service:     hostService,
 			EntryPoints: []string{"https"},
 			TLS:         &config.RouterTLSConfig{},
 		}
 		traefikConfig.HTTP.Routers[httpsRouterName] = httpsRouter
+		hostRouters[host.hostname] = append(hostRouters[host.hostname], routerName, httpsRouterName)
 	}
 
-	// Set up service
-	port := getEffectivePort(hosts, containerInfo.VirtualPort, inspect)
-	serverURL := fmt.Sprintf("http://%s:%s", containerIP, port)
+	addBasicAuthMiddleware(traefikConfig, serviceName, users)
+	addCORSMiddleware(traefikConfig, serviceName, cl.cors(containerInfo))
+	if headers := cl.requestHeaders(containerInfo); headers != nil {
+		addRequestHeadersMiddleware(traefikConfig, serviceName, headers)
+	}
+	addSecurityHeadersMiddleware(traefikConfig, serviceName, cl.securityHeaders(containerInfo))
+	addPathMiddleware(traefikConfig, serviceName, path)
+	addFaultMiddleware(traefikConfig, serviceName, cl.config.FaultEndpoint, cl.faults(containerInfo))
+
+	nginxProxy := cl.nginxProxySettings(containerInfo)
+	applyNginxProxySettings(traefikConfig, serviceName, nginxProxy)
+	if won, ok := cl.collisionRouters(cl.routeClaim(containerInfo, serviceName)); ok {
+		raiseCollisionWinners(traefikConfig, won, path.prefix, hostRouters)
+	}
+	cl.addNamedCertificate(traefikConfig, containerInfo, nginxProxy)
+
+	// Set up services
+	for name, port := range servicePorts {
+		traefikConfig.HTTP.Services[name] = &config.Service{
+			LoadBalancer: &config.LoadBalancer{
+				Servers: []config.Server{
+					{URL: proto.serverURL(containerIP, port)},
+				},
+			},
+		}
+	}
+	addStickyCookie(traefikConfig, serviceName, containerInfo.StickyCookie)
+	preset := cl.routePreset(containerInfo)
+	preset.apply(traefikConfig, serviceName)
+	addServersTransport(traefikConfig, serviceName, preset.transport(proto.transport(hosts)))
+
+	// Labels adjust the generated routers; the override file, written for
+	// this container alone, may still replace any of them
+	cl.labelOverride(containerInfo).apply(traefikConfig)
+
+	// The override may replace any of the above, including the service
+	if override := cl.overrideFor(containerInfo); override != nil {
+		override.apply(traefikConfig)
+	}
+	setRulePriorities(traefikConfig)
+
+	// Bypass routers copy the final middleware chains, so they come last
+	addAuthBypassRouters(traefikConfig, serviceName, cl.authBypassPaths(containerInfo))
+
+	return traefikConfig
+}
 
-	loadBalancer := &config.LoadBalancer{
-		Servers: []config.Server{
-			{URL: serverURL},
-		},
+// requestHeaders parses a container's synthetic request headers, logging
+// malformed entries.
+func (cl *CompatibilityLayer) requestHeaders(containerInfo ContainerInfo) map[string]string {
+	headers, invalid := parseRequestHeaders(containerInfo.GeoCountry, containerInfo.RequestHeaders)
+	for _, entry := range invalid {
+		cl.logger.Warn("Ignoring malformed HTTP_PROXY_REQUEST_HEADERS entry, expected \"Name: value\"",
+			"container_id", utils.FormatDockerID(containerInfo.ID),
+			"entry", entry)
 	}
+	return headers
+}
 
-	traefikConfig.HTTP.Services[serviceName] = &config.Service{
-		LoadBalancer: loadBalancer,
+// securityHeaders resolves a container's security headers preset, logging
+// unknown presets.
+func (cl *CompatibilityLayer) securityHeaders(containerInfo ContainerInfo) *config.HeadersMiddleware {
+	headers, ok := securityHeadersPreset(containerInfo.SecurityHeaders)
+	if !ok {
+		cl.logger.Warn("Ignoring unknown HTTP_PROXY_SECURITY_HEADERS preset",
+			"container_id", utils.FormatDockerID(containerInfo.ID),
+			"preset", containerInfo.SecurityHeaders,
+			"supported", securityHeadersStrict)
 	}
+	return headers
+}
 
-	return traefikConfig
+// basicAuthUsers parses a container's basic auth users, logging malformed
+// entries. It reports false when users were asked for but none is valid.
+func (cl *CompatibilityLayer) basicAuthUsers(containerInfo ContainerInfo) ([]string, bool) {
+	users, invalid := parseBasicAuthUsers(containerInfo.BasicAuth)
+	for _, entry := range invalid {
+		user, _, _ := strings.Cut(entry, ":")
+		cl.logger.Warn("Ignoring malformed HTTP_PROXY_BASIC_AUTH entry, expected \"user:hash\" with a bcrypt, MD5 or SHA-1 hash",
+			"container_id", utils.FormatDockerID(containerInfo.ID),
+			"user", user)
+	}
+	return users, len(users) > 0 || len(invalid) == 0
 }
 
-func getContainerIP(inspect types.ContainerJSON) string {
-	if inspect.NetworkSettings == nil || inspect.NetworkSettings.Networks == nil {
-		return ""
+// authBypassPaths parses a container's unauthenticated paths, logging
+// malformed entries.
+func (cl *CompatibilityLayer) authBypassPaths(containerInfo ContainerInfo) []string {
+	matchers, invalid := parseAuthBypassPaths(containerInfo.AuthBypassPaths)
+	for _, entry := range invalid {
+		cl.logger.Warn("Ignoring malformed HTTP_PROXY_AUTH_BYPASS_PATHS entry, expected an absolute path",
+			"container_id", utils.FormatDockerID(containerInfo.ID),
+			"entry", entry)
 	}
+	return matchers
+}
 
-	// Sort network names so the chosen IP is deterministic across restarts and
-	// events. Go map iteration order is randomized, which would otherwise make
-	// the routed backend IP vary for containers attached to multiple networks.
-	names := make([]string, 0, len(inspect.NetworkSettings.Networks))
-	for name := range inspect.NetworkSettings.Networks {
-		names = append(names, name)
+// hostRule returns the Traefik router rule matching hostname: HostRegexp for
+// wildcard and regex hosts, Host otherwise. It returns "" for wildcard hosts
+// rejected by convertWildcardToRegex.
+func hostRule(hostname string) string {
+	if !isWildcardHost(hostname) {
+		return fmt.Sprintf("Host(`%s`)", hostname)
 	}
-	sort.Strings(names)
 
-	for _, name := range names {
-		if ip := inspect.NetworkSettings.Networks[name].IPAddress; ip != "" {
-			return ip
-		}
+	regexPattern := convertWildcardToRegex(hostname)
+	if regexPattern == "" {
+		return ""
 	}
-	return ""
+	return fmt.Sprintf("HostRegexp(`%s`)", regexPattern)
 }
 
 func getEffectivePort(hosts []virtualHost, virtualPort string, inspect types.ContainerJSON) string {
@@ -335,56 +1050,114 @@ func getEffectivePort(hosts []virtualHost, virtualPort string, inspect types.Con
 	return getDefaultPort(inspect)
 }
 
-func (cl *CompatibilityLayer) writeTraefikConfig(containerID string, cfg *config.TraefikConfig) error {
+// writeConfigData writes rendered YAML for a container into the dynamic directory.
+func (cl *CompatibilityLayer) writeConfigData(containerID string, configData []byte) error {
 	if cl.config.DryRun {
-		cl.logger.Info("DRY RUN: Would write Traefik config",
+		if action := cl.printPlan(cl.configFileName(containerID), configData); action != "" {
+			cl.logger.Info("DRY RUN: Would write Traefik config",
+				"container_id", utils.FormatDockerID(containerID),
+				"config_file", cl.configFileName(containerID),
+				"action", action)
+		}
+		return nil
+	}
+
+	name := cl.configFileName(containerID)
+	if cl.buffering {
+		cl.writes.put(name, configData)
+		cl.logger.Debug("Buffered Traefik configuration",
 			"container_id", utils.FormatDockerID(containerID),
-			"config_file", cl.configFileName(containerID))
+			"config_file", name)
 		return nil
 	}
+	cl.writes.forget(name)
+
+	configFile, err := cl.writeDynamicFile(name, configData)
+	if err != nil {
+		return err
+	}
 
+	cl.logger.Info("Wrote Traefik configuration",
+		"container_id", utils.FormatDockerID(containerID),
+		"config_file", configFile)
+
+	return nil
+}
+
+// writeDynamicFile atomically writes a file into the dynamic directory and
+// returns its path.
+func (cl *CompatibilityLayer) writeDynamicFile(name string, data []byte) (string, error) {
 	// Ensure the dynamic config directory exists
 	if err := os.MkdirAll(cl.config.TraefikDynamicDir, ConfigDirPermissions); err != nil {
-		return fmt.Errorf("failed to create Traefik dynamic directory: %w", err)
+		return "", fmt.Errorf("failed to create Traefik dynamic directory: %w", err)
 	}
 
 	// Generate config file path
-	configFile := filepath.Join(cl.config.TraefikDynamicDir, cl.configFileName(containerID))
+	configFile := filepath.Join(cl.config.TraefikDynamicDir, name)
+	if err := writeFileAtomic(configFile, data); err != nil {
+		return "", err
+	}
+	return configFile, nil
+}
 
-	// Marshal config to YAML
-	configData, err := yaml.Marshal(cfg)
+// writeFileAtomic replaces path with data through a uniquely named temporary
+// file Traefik ignores (no .yaml extension), flushed to disk before it is
+// renamed over path.
+func writeFileAtomic(path string, data []byte) error {
+	name := filepath.Base(path)
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+name+".*.tmp")
 	if err != nil {
-		return fmt.Errorf("failed to marshal Traefik config: %w", err)
+		return fmt.Errorf("failed to create temporary file for %s: %w", name, err)
 	}
-
-	// Write atomically using temporary file
-	tempFile := configFile + ".tmp"
-	if err := os.WriteFile(tempFile, configData, ConfigFilePermissions); err != nil {
-		return fmt.Errorf("failed to write temporary config file: %w", err)
+	tempFile := temp.Name()
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempFile, ConfigFilePermissions)
+	}
+	if err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to write temporary file for %s: %w", name, err)
 	}
 
-	// Atomically rename temporary file to final config file
-	if err := os.Rename(tempFile, configFile); err != nil {
+	// Atomically rename temporary file to final file
+	if err := os.Rename(tempFile, path); err != nil {
 		os.Remove(tempFile) // Clean up on failure
-		return fmt.Errorf("failed to rename config file: %w", err)
+		return fmt.Errorf("failed to rename %s: %w", name, err)
 	}
-
-	cl.logger.Info("Wrote Traefik configuration",
-		"container_id", utils.FormatDockerID(containerID),
-		"config_file", configFile)
-
 	return nil
 }
 
 func (cl *CompatibilityLayer) removeTraefikConfig(containerID string) error {
+	cl.probedPorts.Delete(containerID)
+	delete(cl.replicaSets, containerID)
+	if cl.routes.remove(containerID) {
+		cl.routesChanged()
+	}
+
 	if cl.config.DryRun {
-		cl.logger.Info("DRY RUN: Would remove Traefik config",
-			"container_id", utils.FormatDockerID(containerID),
-			"config_file", cl.configFileName(containerID))
+		if action := cl.printPlan(cl.configFileName(containerID), nil); action != "" {
+			cl.logger.Info("DRY RUN: Would remove Traefik config",
+				"container_id", utils.FormatDockerID(containerID),
+				"config_file", cl.configFileName(containerID),
+				"action", action)
+		}
 		return nil
 	}
 
-	configFile := filepath.Join(cl.config.TraefikDynamicDir, cl.configFileName(containerID))
+	name := cl.configFileName(containerID)
+	if cl.buffering {
+		cl.writes.put(name, nil)
+		return nil
+	}
+	cl.writes.forget(name)
+
+	configFile := filepath.Join(cl.config.TraefikDynamicDir, name)
 
 	// Check if file exists
 	if _, err := os.Stat(configFile); os.IsNotExist(err) {
@@ -491,7 +1264,29 @@ func generateServiceName(containerName string) string {
 	return name
 }
 
+// uniqueName returns name, or name with the lowest "-<n>" suffix from 2 not
+// in used, and marks the result as used. generateServiceName maps different
+// inputs such as "a.b.loc" and "a-b.loc" to the same name; callers naming
+// several of them in one config keep them apart with it.
+func uniqueName(used map[string]bool, name string) string {
+	unique := name
+	for n := 2; used[unique]; n++ {
+		unique = fmt.Sprintf("%s-%d", name, n)
+	}
+	used[unique] = true
+	return unique
+}
+
 func getDefaultPort(inspect types.ContainerJSON) string {
+	if port := detectedPort(inspect); port != "" {
+		return port
+	}
+	return "80"
+}
+
+// detectedPort returns the port a container's exposed or bound ports point
+// to, or "" when it has none.
+func detectedPort(inspect types.ContainerJSON) string {
 	// Prefer the lowest exposed TCP port, then fall back to the lowest bound TCP
 	// port. Sorting makes the selection deterministic; Go map iteration order is
 	// randomized, which would otherwise pick a different port across restarts for
@@ -516,11 +1311,7 @@ func getDefaultPort(inspect types.ContainerJSON) string {
 			}
 		}
 	}
-	if port := lowestTCPPort(bound); port != "" {
-		return port
-	}
-
-	return "80"
+	return lowestTCPPort(bound)
 }
 
 // lowestTCPPort returns the smallest port in the slice as a string, or "" if empty.
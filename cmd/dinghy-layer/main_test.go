@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/docker/docker/api/types"
@@ -14,6 +17,8 @@ func testLayer() *CompatibilityLayer {
 	return &CompatibilityLayer{
 		logger: logger.New("test"),
 		config: &CompatibilityConfig{TraefikDynamicDir: "/tmp"},
+		routes: newRouteInventory(),
+		stops:  newStopTracker(),
 	}
 }
 
@@ -143,6 +148,18 @@ func TestGenerateServiceName(t *testing.T) {
 	}
 }
 
+func TestUniqueName(t *testing.T) {
+	used := make(map[string]bool)
+	for _, want := range []string{"a-b-loc", "a-b-loc-2", "a-b-loc-3"} {
+		if got := uniqueName(used, "a-b-loc"); got != want {
+			t.Errorf("uniqueName() = %q, want %q", got, want)
+		}
+	}
+	if got := uniqueName(used, "other"); got != "other" {
+		t.Errorf("uniqueName() = %q, want other", got)
+	}
+}
+
 func TestTCPPortNumber(t *testing.T) {
 	tests := []struct {
 		in   string
@@ -178,44 +195,6 @@ func TestGetEffectivePort(t *testing.T) {
 	}
 }
 
-func TestGetContainerIPDeterministic(t *testing.T) {
-	inspect := types.ContainerJSON{
-		NetworkSettings: &types.NetworkSettings{
-			Networks: map[string]*network.EndpointSettings{
-				"zeta":  {IPAddress: "172.0.0.9"},
-				"alpha": {IPAddress: "172.0.0.1"},
-				"beta":  {IPAddress: "172.0.0.2"},
-			},
-		},
-	}
-	// Lowest network name ("alpha") must always win, regardless of map order.
-	for i := 0; i < 20; i++ {
-		if got := getContainerIP(inspect); got != "172.0.0.1" {
-			t.Fatalf("getContainerIP = %q, want 172.0.0.1 (deterministic)", got)
-		}
-	}
-}
-
-func TestGetContainerIPSkipsEmpty(t *testing.T) {
-	inspect := types.ContainerJSON{
-		NetworkSettings: &types.NetworkSettings{
-			Networks: map[string]*network.EndpointSettings{
-				"alpha": {IPAddress: ""},
-				"beta":  {IPAddress: "172.0.0.2"},
-			},
-		},
-	}
-	if got := getContainerIP(inspect); got != "172.0.0.2" {
-		t.Errorf("getContainerIP = %q, want 172.0.0.2", got)
-	}
-}
-
-func TestGetContainerIPNilSettings(t *testing.T) {
-	if got := getContainerIP(types.ContainerJSON{}); got != "" {
-		t.Errorf("getContainerIP with nil settings = %q, want empty", got)
-	}
-}
-
 func TestGetDefaultPortLowestExposed(t *testing.T) {
 	inspect := types.ContainerJSON{
 		Config: &container.Config{
@@ -323,3 +302,18 @@ func TestGenerateTraefikConfigMultiHost(t *testing.T) {
 		t.Errorf("service count = %d, want 1", got)
 	}
 }
+
+func TestProcessContainerRejectsInvalidConfig(t *testing.T) {
+	cl := testLayerWithDocker(t, managedContainer("aaaaaaaaaaaa0000", "web", "we`b.loc", "172.0.0.90"))
+
+	err := cl.processContainer(context.Background(), "aaaaaaaaaaaa0000")
+	if err == nil || !strings.Contains(err.Error(), "invalid Traefik config") {
+		t.Fatalf("processContainer() error = %v, want the invalid rule rejected", err)
+	}
+	if entries, _ := os.ReadDir(cl.config.TraefikDynamicDir); len(entries) != 0 {
+		t.Errorf("invalid config written: %v", entries)
+	}
+	if len(cl.routes.list()) != 0 {
+		t.Errorf("invalid config recorded routes: %+v", cl.routes.list())
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/sparkfabrik/http-proxy/pkg/mdns"
+)
+
+// mdnsNames maps proxied hostnames to their .local aliases, dropping hosts
+// that have none (wildcards, regexes) and duplicates.
+func mdnsNames(hostnames []string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, hostname := range hostnames {
+		alias := mdns.LocalAlias(hostname)
+		if alias == "" || seen[alias] {
+			continue
+		}
+		seen[alias] = true
+		names = append(names, alias)
+	}
+	return names
+}
+
+// routesChanged propagates the current route inventory to the subsystems that
+// consume it.
+func (cl *CompatibilityLayer) routesChanged() {
+	cl.updateCollisions()
+	if cl.mdns != nil {
+		cl.mdns.SetNames(mdnsNames(cl.routes.hostnames()))
+	}
+	if cl.events != nil {
+		cl.events.updateRoutes(cl.routes.list())
+	}
+	cl.writeRoutesFile()
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMDNSNames(t *testing.T) {
+	got := mdnsNames([]string{"app.loc", "app.dev", "*.app.loc", "api.app.loc"})
+	want := []string{"app.local", "api.app.local"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mdnsNames = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultMetadataLabels selects the container labels attached to routes as
+// metadata: the Compose project plus free-form owner and ticket labels
+const DefaultMetadataLabels = "project=com.docker.compose.project,owner,ticket"
+
+// metadataKeyPattern matches the metadata key names allowed in
+// HTTP_PROXY_METADATA_LABELS.
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// metadataLabel maps a container label to the metadata key it is reported as.
+type metadataLabel struct {
+	key   string
+	label string
+}
+
+// parseMetadataLabels parses HTTP_PROXY_METADATA_LABELS, a comma-separated
+// list of label keys, each optionally renamed with "<key>=<label>".
+func parseMetadataLabels(spec string) ([]metadataLabel, error) {
+	var selected []metadataLabel
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		key, label, renamed := strings.Cut(entry, "=")
+		key, label = strings.TrimSpace(key), strings.TrimSpace(label)
+		if !renamed {
+			label = key
+		}
+		if !metadataKeyPattern.MatchString(key) || label == "" {
+			return nil, fmt.Errorf("invalid metadata label %q: expected \"<label>\" or \"<key>=<label>\"", entry)
+		}
+		selected = append(selected, metadataLabel{key: key, label: label})
+	}
+	return selected, nil
+}
+
+// routeMetadata picks the selected labels of a container. Only the first
+// line of a value is kept, so values cannot inject lines into generated
+// files. It returns nil when no selected label is set.
+func routeMetadata(selected []metadataLabel, labels map[string]string) map[string]string {
+	var metadata map[string]string
+	for _, s := range selected {
+		value := labels[s.label]
+		if i := strings.IndexAny(value, "\r\n"); i >= 0 {
+			value = value[:i]
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[s.key] = value
+	}
+	return metadata
+}
+
+// metadataHeader renders route metadata as YAML comments heading a
+// generated config file; Traefik rejects unknown fields, so the metadata
+// cannot be stored as configuration.
+func metadataHeader(metadata map[string]string) []byte {
+	if len(metadata) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "# %s: %s\n", key, metadata[key])
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMetadataLabels(t *testing.T) {
+	got, err := parseMetadataLabels(DefaultMetadataLabels + ", team = com.example.team ,")
+	if err != nil {
+		t.Fatalf("parseMetadataLabels: %v", err)
+	}
+	want := []metadataLabel{
+		{key: "project", label: "com.docker.compose.project"},
+		{key: "owner", label: "owner"},
+		{key: "ticket", label: "ticket"},
+		{key: "team", label: "com.example.team"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, spec := range []string{"bad key=x", "project=", "a:b"} {
+		if _, err := parseMetadataLabels(spec); err == nil {
+			t.Errorf("parseMetadataLabels(%q): expected error", spec)
+		}
+	}
+}
+
+func TestRouteMetadata(t *testing.T) {
+	selected, _ := parseMetadataLabels(DefaultMetadataLabels)
+
+	if got := routeMetadata(selected, map[string]string{"other": "x"}); got != nil {
+		t.Errorf("expected nil metadata, got %v", got)
+	}
+
+	got := routeMetadata(selected, map[string]string{
+		"com.docker.compose.project": "shop",
+		"owner":                      "payments-team\ninjected: true",
+		"ticket":                     "  ",
+	})
+	want := map[string]string{"project": "shop", "owner": "payments-team"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestProcessContainerWritesMetadata(t *testing.T) {
+	const id = "0123456789abcdef0123"
+	inspect := managedContainer(id, "web", "web.loc", "172.0.0.5")
+	inspect.Config.Labels = map[string]string{"com.docker.compose.project": "shop", "ticket": "OPS-42"}
+	cl := testLayerWithDocker(t, inspect)
+	cl.metadata, _ = parseMetadataLabels(DefaultMetadataLabels)
+
+	if err := cl.processContainer(context.Background(), id); err != nil {
+		t.Fatalf("processContainer: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(cl.config.TraefikDynamicDir, cl.configFileName(id)))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "# project: shop\n# ticket: OPS-42\nhttp:") {
+		t.Errorf("missing metadata header:\n%s", data)
+	}
+
+	routes, _ := cl.routes.get(id)
+	if routes.Metadata["project"] != "shop" {
+		t.Errorf("inventory metadata = %v", routes.Metadata)
+	}
+}
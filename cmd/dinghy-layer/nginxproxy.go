@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// HTTPS_METHOD values, as defined by nginx-proxy
+const (
+	httpsMethodRedirect   = "redirect"
+	httpsMethodNoRedirect = "noredirect"
+	httpsMethodNoHTTPS    = "nohttps"
+	httpsMethodNoHTTP     = "nohttp"
+)
+
+// NETWORK_ACCESS values, as defined by nginx-proxy
+const (
+	networkAccessExternal = "external"
+	networkAccessInternal = "internal"
+)
+
+// internalSourceRanges are the client addresses NETWORK_ACCESS=internal lets
+// through: loopback and private ranges, which include the Docker gateways
+// requests from the host arrive from.
+var internalSourceRanges = []string{
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1/128",
+	"fc00::/7",
+}
+
+// nginxProxySettings are the nginx-proxy variables beyond VIRTUAL_HOST and
+// VIRTUAL_PORT that a container can carry over unchanged.
+type nginxProxySettings struct {
+	httpsMethod string
+	weight      int
+	certName    string
+	internal    bool
+}
+
+// parseNginxProxySettings parses HTTPS_METHOD (both schemes are served by
+// default, like noredirect), VIRTUAL_HOST_WEIGHT (a non-negative integer),
+// CERT_NAME (a certificate file name without extension) and NETWORK_ACCESS.
+func parseNginxProxySettings(httpsMethod, weight, certName, networkAccess string) (nginxProxySettings, error) {
+	var settings nginxProxySettings
+
+	switch method := strings.ToLower(strings.TrimSpace(httpsMethod)); method {
+	case "", httpsMethodNoRedirect:
+	case httpsMethodRedirect, httpsMethodNoHTTPS, httpsMethodNoHTTP:
+		settings.httpsMethod = method
+	default:
+		return nginxProxySettings{}, fmt.Errorf("unsupported HTTPS_METHOD %q, expected %s, %s, %s or %s",
+			httpsMethod, httpsMethodRedirect, httpsMethodNoRedirect, httpsMethodNoHTTPS, httpsMethodNoHTTP)
+	}
+
+	if weight = strings.TrimSpace(weight); weight != "" {
+		n, err := strconv.Atoi(weight)
+		if err != nil || n < 0 {
+			return nginxProxySettings{}, fmt.Errorf("invalid VIRTUAL_HOST_WEIGHT %q, expected a non-negative integer", weight)
+		}
+		settings.weight = n
+	}
+
+	if certName = strings.TrimSpace(certName); certName != "" {
+		if certName != filepath.Base(certName) || strings.HasPrefix(certName, ".") {
+			return nginxProxySettings{}, fmt.Errorf("invalid CERT_NAME %q, expected a file name in the certificates directory", certName)
+		}
+		settings.certName = certName
+	}
+
+	switch access := strings.ToLower(strings.TrimSpace(networkAccess)); access {
+	case "", networkAccessExternal:
+	case networkAccessInternal:
+		settings.internal = true
+	default:
+		return nginxProxySettings{}, fmt.Errorf("unsupported NETWORK_ACCESS %q, expected %s or %s",
+			networkAccess, networkAccessExternal, networkAccessInternal)
+	}
+
+	return settings, nil
+}
+
+// parseHTTPSRedirect parses HTTPS_REDIRECT: "true" redirects the container's
+// HTTP routes to HTTPS and "false" opts it out of forceHTTPS
+// (HTTP_PROXY_FORCE_HTTPS), which applies when the variable is not set.
+func parseHTTPSRedirect(value string, forceHTTPS bool) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "":
+		return forceHTTPS, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		return forceHTTPS, fmt.Errorf("invalid HTTPS_REDIRECT %q, expected true or false", value)
+	}
+}
+
+// certificate returns the certificate named by CERT_NAME in dir:
+// "<name>.crt" or "<name>.pem", with a key file the Traefik entrypoint would
+// also accept.
+func (s nginxProxySettings) certificate(dir string) (*config.TLSCertificate, error) {
+	if s.certName == "" {
+		return nil, nil
+	}
+	for _, ext := range []string{".crt", ".pem"} {
+		certFile := filepath.Join(dir, s.certName+ext)
+		if _, err := os.Stat(certFile); err != nil {
+			continue
+		}
+		key := keyFile(dir, s.certName)
+		if key == "" {
+			return nil, fmt.Errorf("certificate %s has no key file", certFile)
+		}
+		return &config.TLSCertificate{CertFile: certFile, KeyFile: key}, nil
+	}
+	return nil, fmt.Errorf("no certificate %s.crt or %s.pem in %s", s.certName, s.certName, dir)
+}
+
+// httpsRedirectMiddlewareName returns the name of the middleware redirecting
+// a service's HTTP requests to HTTPS.
+func httpsRedirectMiddlewareName(serviceName string) string {
+	return serviceName + "-https-redirect"
+}
+
+// internalMiddlewareName returns the name of the middleware restricting a
+// service to internal clients.
+func internalMiddlewareName(serviceName string) string {
+	return serviceName + "-internal"
+}
+
+// applyNginxProxySettings translates the settings into the service's routers
+// and middlewares. The access restriction and the redirect run before any
+// other middleware; the redirect is temporary, so browsers do not remember it
+// for local hosts.
+func applyNginxProxySettings(traefikConfig *config.TraefikConfig, serviceName string, settings nginxProxySettings) {
+	if settings.internal {
+		name := internalMiddlewareName(serviceName)
+		traefikConfig.HTTP.Middlewares[name] = &config.Middleware{
+			IPAllowList: &config.IPAllowListMiddleware{SourceRange: internalSourceRanges},
+		}
+		for _, router := range traefikConfig.HTTP.Routers {
+			if isContainerService(router.Service, serviceName) {
+				router.Middlewares = append([]string{name}, router.Middlewares...)
+			}
+		}
+	}
+
+	for routerName, router := range traefikConfig.HTTP.Routers {
+		if !isContainerService(router.Service, serviceName) {
+			continue
+		}
+		if settings.weight > 0 {
+			router.Priority = routerPriority(router) + settings.weight
+		}
+
+		secure := router.TLS != nil
+		switch {
+		case settings.httpsMethod == httpsMethodNoHTTP && !secure,
+			settings.httpsMethod == httpsMethodNoHTTPS && secure:
+			delete(traefikConfig.HTTP.Routers, routerName)
+		case settings.httpsMethod == httpsMethodRedirect && !secure:
+			name := httpsRedirectMiddlewareName(serviceName)
+			traefikConfig.HTTP.Middlewares[name] = &config.Middleware{
+				RedirectScheme: &config.RedirectSchemeMiddleware{Scheme: "https"},
+			}
+			router.Middlewares = append([]string{name}, router.Middlewares...)
+		}
+	}
+}
+
+// nginxProxySettings parses a container's nginx-proxy settings, logging
+// invalid ones, which are ignored as a whole. Without HTTPS_METHOD, the
+// redirect to HTTPS follows HTTPS_REDIRECT and HTTP_PROXY_FORCE_HTTPS.
+func (cl *CompatibilityLayer) nginxProxySettings(containerInfo ContainerInfo) nginxProxySettings {
+	settings, err := parseNginxProxySettings(containerInfo.HTTPSMethod, containerInfo.HostWeight, containerInfo.CertName, containerInfo.NetworkAccess)
+	if err != nil {
+		cl.logger.Warn("Ignoring nginx-proxy settings",
+			"container_id", utils.FormatDockerID(containerInfo.ID),
+			"error", err)
+		settings = nginxProxySettings{}
+	}
+
+	if strings.TrimSpace(containerInfo.HTTPSMethod) == "" {
+		redirect, err := parseHTTPSRedirect(containerInfo.HTTPSRedirect, cl.config.ForceHTTPS)
+		if err != nil {
+			cl.logger.Warn("Ignoring HTTPS_REDIRECT",
+				"container_id", utils.FormatDockerID(containerInfo.ID),
+				"error", err)
+		}
+		if redirect {
+			settings.httpsMethod = httpsMethodRedirect
+		}
+	}
+	return settings
+}
+
+// addNamedCertificate loads the certificate named by CERT_NAME with the
+// container's routes, so Traefik serves it even when it was added to the
+// certificates directory after Traefik started. A missing certificate is
+// logged and the hosts fall back to the other certificates.
+func (cl *CompatibilityLayer) addNamedCertificate(traefikConfig *config.TraefikConfig, containerInfo ContainerInfo, settings nginxProxySettings) {
+	cert, err := settings.certificate(cl.config.CertsDir)
+	if err != nil {
+		cl.logger.Warn("Ignoring CERT_NAME",
+			"container_id", utils.FormatDockerID(containerInfo.ID),
+			"error", err)
+		return
+	}
+	if cert == nil {
+		return
+	}
+	traefikConfig.TLS = &config.TLSConfig{Certificates: []config.TLSCertificate{*cert}}
+}
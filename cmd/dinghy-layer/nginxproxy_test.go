@@ -0,0 +1,199 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+)
+
+func TestParseNginxProxySettings(t *testing.T) {
+	tests := []struct {
+		name          string
+		httpsMethod   string
+		weight        string
+		certName      string
+		networkAccess string
+		want          nginxProxySettings
+		wantErr       bool
+	}{
+		{name: "defaults", want: nginxProxySettings{}},
+		{name: "noredirect", httpsMethod: "noredirect", networkAccess: "external", want: nginxProxySettings{}},
+		{name: "redirect", httpsMethod: " Redirect ", want: nginxProxySettings{httpsMethod: "redirect"}},
+		{name: "nohttp", httpsMethod: "nohttp", want: nginxProxySettings{httpsMethod: "nohttp"}},
+		{name: "weight", weight: "10", want: nginxProxySettings{weight: 10}},
+		{name: "cert name", certName: "shared", want: nginxProxySettings{certName: "shared"}},
+		{name: "internal", networkAccess: "internal", want: nginxProxySettings{internal: true}},
+		{name: "unknown method", httpsMethod: "always", wantErr: true},
+		{name: "negative weight", weight: "-1", wantErr: true},
+		{name: "cert path", certName: "../etc/shared", wantErr: true},
+		{name: "unknown access", networkAccess: "vpn", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNginxProxySettings(tt.httpsMethod, tt.weight, tt.certName, tt.networkAccess)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNginxProxySettings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseNginxProxySettings() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNginxProxySettingsCertificate(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"shared.crt", "shared.key", "nokey.pem"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := nginxProxySettings{certName: "shared"}.certificate(dir)
+	if err != nil {
+		t.Fatalf("certificate() error = %v", err)
+	}
+	want := &config.TLSCertificate{CertFile: filepath.Join(dir, "shared.crt"), KeyFile: filepath.Join(dir, "shared.key")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("certificate() = %+v, want %+v", got, want)
+	}
+
+	for _, name := range []string{"nokey", "missing"} {
+		if _, err := (nginxProxySettings{certName: name}).certificate(dir); err == nil {
+			t.Errorf("certificate() for %s succeeded, want an error", name)
+		}
+	}
+}
+
+func TestGenerateTraefikConfigNginxProxySettings(t *testing.T) {
+	tests := []struct {
+		name        string
+		info        ContainerInfo
+		wantRouters []string
+		wantChains  map[string][]string
+	}{
+		{
+			name:        "redirect",
+			info:        ContainerInfo{HTTPSMethod: "redirect"},
+			wantRouters: []string{"app-0", "app-tls-0"},
+			wantChains:  map[string][]string{"app-0": {"app-https-redirect"}, "app-tls-0": nil},
+		},
+		{
+			name:        "nohttp",
+			info:        ContainerInfo{HTTPSMethod: "nohttp"},
+			wantRouters: []string{"app-tls-0"},
+		},
+		{
+			name:        "nohttps",
+			info:        ContainerInfo{HTTPSMethod: "nohttps"},
+			wantRouters: []string{"app-0"},
+		},
+		{
+			name:        "internal redirect",
+			info:        ContainerInfo{HTTPSMethod: "redirect", NetworkAccess: "internal", RequestHeaders: "X-Env: local"},
+			wantRouters: []string{"app-0", "app-tls-0"},
+			wantChains: map[string][]string{
+				"app-0":     {"app-https-redirect", "app-internal", "app-headers"},
+				"app-tls-0": {"app-internal", "app-headers"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := testLayer()
+			info := tt.info
+			info.Name, info.VirtualHost = "app", "app.loc"
+
+			cfg := cl.generateTraefikConfig(inspectWithIP("/app", "172.0.0.20"), info)
+
+			var routers []string
+			for name := range cfg.HTTP.Routers {
+				routers = append(routers, name)
+			}
+			if len(routers) != len(tt.wantRouters) {
+				t.Fatalf("routers = %v, want %v", routers, tt.wantRouters)
+			}
+			for _, name := range tt.wantRouters {
+				router, ok := cfg.HTTP.Routers[name]
+				if !ok {
+					t.Fatalf("missing router %s in %v", name, routers)
+				}
+				if chain, ok := tt.wantChains[name]; ok && !reflect.DeepEqual(router.Middlewares, chain) {
+					t.Errorf("%s middlewares = %v, want %v", name, router.Middlewares, chain)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateTraefikConfigHostWeight(t *testing.T) {
+	cl := testLayer()
+	info := ContainerInfo{Name: "app", VirtualHost: "app.loc", HostWeight: "5"}
+
+	cfg := cl.generateTraefikConfig(inspectWithIP("/app", "172.0.0.20"), info)
+
+	for name, router := range cfg.HTTP.Routers {
+		if want := rulePriority(router.Rule) + 5; router.Priority != want {
+			t.Errorf("%s priority = %d, want %d", name, router.Priority, want)
+		}
+	}
+}
+
+func TestParseHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		value   string
+		force   bool
+		want    bool
+		wantErr bool
+	}{
+		{value: "", want: false},
+		{value: "", force: true, want: true},
+		{value: " TRUE ", want: true},
+		{value: "false", force: true, want: false},
+		{value: "yes", force: true, want: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseHTTPSRedirect(tt.value, tt.force)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseHTTPSRedirect(%q, %v) = %v, %v; want %v, error %v", tt.value, tt.force, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGenerateTraefikConfigHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		name       string
+		info       ContainerInfo
+		forceHTTPS bool
+		want       []string
+	}{
+		{name: "default", want: nil},
+		{name: "container", info: ContainerInfo{HTTPSRedirect: "true"}, want: []string{"app-https-redirect"}},
+		{name: "global", forceHTTPS: true, want: []string{"app-https-redirect"}},
+		{name: "container opt-out", info: ContainerInfo{HTTPSRedirect: "false"}, forceHTTPS: true, want: nil},
+		{name: "HTTPS_METHOD wins", info: ContainerInfo{HTTPSMethod: "noredirect"}, forceHTTPS: true, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := testLayer()
+			cl.config.ForceHTTPS = tt.forceHTTPS
+			info := tt.info
+			info.Name, info.VirtualHost = "app", "app.loc"
+
+			cfg := cl.generateTraefikConfig(inspectWithIP("/app", "172.0.0.20"), info)
+
+			if got := cfg.HTTP.Routers["app-0"].Middlewares; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("app-0 middlewares = %v, want %v", got, tt.want)
+			}
+			if got := cfg.HTTP.Routers["app-tls-0"].Middlewares; got != nil {
+				t.Errorf("app-tls-0 middlewares = %v, want none", got)
+			}
+			if _, ok := cfg.HTTP.Middlewares["app-https-redirect"]; ok != (tt.want != nil) {
+				t.Errorf("redirect middleware defined = %v, want %v", ok, tt.want != nil)
+			}
+		})
+	}
+}
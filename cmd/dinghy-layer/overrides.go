@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultOverridesDir holds the per-container override snippets
+const DefaultOverridesDir = "/traefik/overrides"
+
+// containerOverride is a per-container snippet merged into the generated
+// config. Priority and Middlewares apply to every router of the container
+// (Priority only to routers without an explicit one, so unauthenticated
+// path routers keep theirs); HTTP entries are added to the config, replacing
+// generated entries of the same name.
+type containerOverride struct {
+	Priority    int                `yaml:"priority,omitempty"`
+	Middlewares []string           `yaml:"middlewares,omitempty"`
+	HTTP        *config.HTTPConfig `yaml:"http,omitempty"`
+}
+
+// loadOverride reads the override snippet of a container from dir: the
+// first of <name>.yaml and <name>.yml. It returns nil when there is none.
+func loadOverride(dir, containerName string) (*containerOverride, string, error) {
+	if dir == "" || containerName == "" || strings.ContainsAny(containerName, `/\`) {
+		return nil, "", nil
+	}
+	for _, ext := range []string{".yaml", ".yml"} {
+		path := filepath.Join(dir, containerName+ext)
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, path, fmt.Errorf("failed to read override: %w", err)
+		}
+
+		var override containerOverride
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&override); err != nil && !errors.Is(err, io.EOF) {
+			return nil, path, fmt.Errorf("invalid override %s: %w", filepath.Base(path), err)
+		}
+		return &override, path, nil
+	}
+	return nil, "", nil
+}
+
+// apply merges the override into cfg.
+func (o *containerOverride) apply(cfg *config.TraefikConfig) {
+	if cfg.HTTP == nil {
+		cfg.HTTP = &config.HTTPConfig{}
+	}
+	for _, router := range cfg.HTTP.Routers {
+		if o.Priority != 0 && router.Priority == 0 {
+			router.Priority = o.Priority
+		}
+		router.Middlewares = append(router.Middlewares, o.Middlewares...)
+	}
+
+	if o.HTTP == nil {
+		return
+	}
+	for name, router := range o.HTTP.Routers {
+		if cfg.HTTP.Routers == nil {
+			cfg.HTTP.Routers = make(map[string]*config.Router)
+		}
+		cfg.HTTP.Routers[name] = router
+	}
+	for name, svc := range o.HTTP.Services {
+		if cfg.HTTP.Services == nil {
+			cfg.HTTP.Services = make(map[string]*config.Service)
+		}
+		cfg.HTTP.Services[name] = svc
+	}
+	for name, middleware := range o.HTTP.Middlewares {
+		if cfg.HTTP.Middlewares == nil {
+			cfg.HTTP.Middlewares = make(map[string]*config.Middleware)
+		}
+		cfg.HTTP.Middlewares[name] = middleware
+	}
+	for name, transport := range o.HTTP.ServersTransports {
+		if cfg.HTTP.ServersTransports == nil {
+			cfg.HTTP.ServersTransports = make(map[string]*config.ServersTransport)
+		}
+		cfg.HTTP.ServersTransports[name] = transport
+	}
+}
+
+// overrideFor returns the override snippet of a container, or nil. A
+// broken snippet is logged and skipped so the container stays routed.
+func (cl *CompatibilityLayer) overrideFor(containerInfo ContainerInfo) *containerOverride {
+	override, path, err := loadOverride(cl.config.OverridesDir, containerInfo.Name)
+	if err != nil {
+		cl.logger.Warn("Ignoring container override",
+			"container_id", utils.FormatDockerID(containerInfo.ID),
+			"container_name", containerInfo.Name,
+			"error", err)
+		return nil
+	}
+	if override != nil {
+		cl.logger.Info("Applying container override",
+			"container_id", utils.FormatDockerID(containerInfo.ID),
+			"container_name", containerInfo.Name,
+			"override_file", path)
+	}
+	return override
+}
+
+// overrideRendered merges an override into a config rendered from a user
+// template.
+func overrideRendered(configData []byte, override *containerOverride) ([]byte, error) {
+	cfg, err := config.ParseTraefikConfig(configData)
+	if err != nil {
+		return nil, err
+	}
+	override.apply(cfg)
+	return yaml.Marshal(cfg)
+}
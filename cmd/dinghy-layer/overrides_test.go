@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+)
+
+func TestLoadOverride(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "web.yml"), []byte("priority: 50\nmiddlewares: [compress@file]\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "empty.yaml"), nil, 0644)
+	os.WriteFile(filepath.Join(dir, "typo.yaml"), []byte("middleware: [compress@file]\n"), 0644)
+
+	override, path, err := loadOverride(dir, "web")
+	if err != nil || override == nil || override.Priority != 50 || path != filepath.Join(dir, "web.yml") {
+		t.Errorf("loadOverride(web) = %+v, %q, %v", override, path, err)
+	}
+	if override, _, err := loadOverride(dir, "empty"); err != nil || override == nil {
+		t.Errorf("loadOverride(empty) = %+v, %v, want an empty override", override, err)
+	}
+	if _, _, err := loadOverride(dir, "typo"); err == nil {
+		t.Error("loadOverride() accepted an unknown field")
+	}
+	for _, name := range []string{"missing", "", "../web"} {
+		if override, _, err := loadOverride(dir, name); override != nil || err != nil {
+			t.Errorf("loadOverride(%q) = %+v, %v, want none", name, override, err)
+		}
+	}
+	if override, _, err := loadOverride("", "web"); override != nil || err != nil {
+		t.Errorf("loadOverride() with overrides disabled = %+v, %v", override, err)
+	}
+}
+
+func TestContainerOverrideApply(t *testing.T) {
+	cfg := config.NewTraefikConfig()
+	cfg.HTTP.Routers["web-0"] = &config.Router{Rule: "Host(`web.loc`)", Service: "web", Middlewares: []string{"web-headers"}}
+	cfg.HTTP.Routers["web-bypass-0"] = &config.Router{Rule: "Host(`web.loc`) && PathPrefix(`/health`)", Service: "web", Priority: 100}
+	cfg.HTTP.Services["web"] = &config.Service{LoadBalancer: &config.LoadBalancer{Servers: []config.Server{{URL: "http://172.0.0.5:80"}}}}
+
+	override := &containerOverride{
+		Priority:    10,
+		Middlewares: []string{"compress@file"},
+		HTTP: &config.HTTPConfig{
+			Routers: map[string]*config.Router{"web-admin": {Rule: "Host(`admin.web.loc`)", Service: "web"}},
+			Middlewares: map[string]*config.Middleware{
+				"web-headers": {Headers: &config.HeadersMiddleware{CustomRequestHeaders: map[string]string{"X-Team": "web"}}},
+			},
+		},
+	}
+	override.apply(cfg)
+
+	router := cfg.HTTP.Routers["web-0"]
+	if router.Priority != 10 || !reflect.DeepEqual(router.Middlewares, []string{"web-headers", "compress@file"}) {
+		t.Errorf("router = %+v, want priority 10 and the extra middleware appended", router)
+	}
+	if got := cfg.HTTP.Routers["web-bypass-0"].Priority; got != 100 {
+		t.Errorf("explicit priority = %d, want it kept", got)
+	}
+	if cfg.HTTP.Routers["web-admin"] == nil {
+		t.Error("override router not added")
+	}
+	if got := cfg.HTTP.Middlewares["web-headers"].Headers.CustomRequestHeaders["X-Team"]; got != "web" {
+		t.Errorf("middleware not replaced by the override: %q", got)
+	}
+}
+
+func TestProcessContainerAppliesOverride(t *testing.T) {
+	const id = "0123456789abcdef0123"
+	cl := testLayerWithDocker(t, managedContainer(id, "web", "web.loc", "172.0.0.5"))
+	cl.config.OverridesDir = t.TempDir()
+	os.WriteFile(filepath.Join(cl.config.OverridesDir, "web.yaml"), []byte("middlewares: [compress@file]\n"), 0644)
+
+	if err := cl.processContainer(context.Background(), id); err != nil {
+		t.Fatalf("processContainer() error = %v", err)
+	}
+	cfg, err := config.LoadTraefikConfigFile(filepath.Join(cl.config.TraefikDynamicDir, "0123456789ab.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, router := range cfg.HTTP.Routers {
+		if n := len(router.Middlewares); n == 0 || router.Middlewares[n-1] != "compress@file" {
+			t.Errorf("router %s middlewares = %v, want the override's appended", name, router.Middlewares)
+		}
+	}
+
+	// A broken override is skipped and the container stays routed
+	os.WriteFile(filepath.Join(cl.config.OverridesDir, "web.yaml"), []byte("priority: high\n"), 0644)
+	if err := cl.processContainer(context.Background(), id); err != nil {
+		t.Fatalf("processContainer() with a broken override error = %v", err)
+	}
+	cfg, _ = config.LoadTraefikConfigFile(filepath.Join(cl.config.TraefikDynamicDir, "0123456789ab.yaml"))
+	if len(cfg.HTTP.Routers) != 2 || len(cfg.HTTP.Routers["web-0"].Middlewares) != 0 {
+		t.Errorf("routers = %+v, want the generated ones without the override", cfg.HTTP.Routers)
+	}
+}
+
+func TestGenerateTraefikConfigOverrideAuth(t *testing.T) {
+	cl := testLayer()
+	cl.config.OverridesDir = t.TempDir()
+	os.WriteFile(filepath.Join(cl.config.OverridesDir, "myapp.yaml"), []byte(`
+middlewares: [myapp-auth]
+http:
+  middlewares:
+    myapp-auth:
+      basicAuth:
+        users: ["dev:$apr1$x$y"]
+`), 0644)
+	info := ContainerInfo{Name: "myapp", VirtualHost: "myapp.loc", AuthBypassPaths: "/healthz"}
+
+	cfg := cl.generateTraefikConfig(inspectWithIP("/myapp", "172.0.0.5"), info)
+
+	// Unauthenticated paths skip the auth middleware added by the override
+	bypass, ok := cfg.HTTP.Routers["myapp-0-noauth"]
+	if !ok {
+		t.Fatalf("missing bypass router; got %v", cfg.HTTP.Routers)
+	}
+	if len(bypass.Middlewares) != 0 {
+		t.Errorf("bypass middlewares = %v, want none", bypass.Middlewares)
+	}
+	if cfg.HTTP.Middlewares["myapp-auth"] == nil {
+		t.Error("override middleware not added")
+	}
+}
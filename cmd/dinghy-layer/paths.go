@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+)
+
+// virtualPath is a container's VIRTUAL_PATH routing: the path prefix its
+// routes match and, with VIRTUAL_DEST, what that prefix is rewritten to before
+// the request reaches the backend. The zero value routes whole hosts.
+type virtualPath struct {
+	prefix string
+	dest   string
+}
+
+// parseVirtualPath parses VIRTUAL_PATH and VIRTUAL_DEST. Like nginx-proxy's
+// location blocks, the path is a literal prefix ("/api/" matches "/api/users"
+// but not "/api") and the destination replaces it ("/" strips it). A path of
+// "/" is the whole host. Regex locations and values that could break out of a
+// rule are rejected.
+func parseVirtualPath(path, dest string) (virtualPath, error) {
+	path, dest = strings.TrimSpace(path), strings.TrimSpace(dest)
+	if path == "" || path == "/" {
+		if dest != "" && dest != "/" {
+			return virtualPath{}, fmt.Errorf("VIRTUAL_DEST %q needs a VIRTUAL_PATH", dest)
+		}
+		return virtualPath{}, nil
+	}
+
+	if err := validateRoutePath(path); err != nil {
+		return virtualPath{}, fmt.Errorf("invalid VIRTUAL_PATH: %w", err)
+	}
+	if dest != "" {
+		if err := validateRoutePath(dest); err != nil {
+			return virtualPath{}, fmt.Errorf("invalid VIRTUAL_DEST: %w", err)
+		}
+	}
+	return virtualPath{prefix: path, dest: dest}, nil
+}
+
+// validateRoutePath checks that a path is absolute and can be quoted in a
+// Traefik rule.
+func validateRoutePath(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("%q is not an absolute path", path)
+	}
+	if strings.ContainsAny(path, "`\\ \t") {
+		return fmt.Errorf("%q contains characters not allowed in a path prefix", path)
+	}
+	return nil
+}
+
+// rule restricts a host rule to the path prefix.
+func (p virtualPath) rule(hostRule string) string {
+	if p.prefix == "" || hostRule == "" {
+		return hostRule
+	}
+	return fmt.Sprintf("%s && PathPrefix(`%s`)", hostRule, p.prefix)
+}
+
+// middleware returns the middleware rewriting the prefix to the destination:
+// stripPrefix for "/", replacePathRegex otherwise. It returns nil when the
+// path is passed through unchanged.
+func (p virtualPath) middleware() *config.Middleware {
+	switch p.dest {
+	case "", p.prefix:
+		return nil
+	case "/":
+		return &config.Middleware{
+			StripPrefix: &config.StripPrefixMiddleware{Prefixes: []string{p.prefix}},
+		}
+	default:
+		return &config.Middleware{
+			ReplacePathRegex: &config.ReplacePathRegexMiddleware{
+				Regex:       "^" + regexp.QuoteMeta(p.prefix) + "(.*)",
+				Replacement: p.dest + "$1",
+			},
+		}
+	}
+}
+
+// pathMiddlewareName returns the name of the middleware rewriting a service's
+// path prefix.
+func pathMiddlewareName(serviceName string) string {
+	return serviceName + "-path"
+}
+
+// addPathMiddleware defines the path rewrite middleware for a service, if
+// any, and attaches it to all of the service's routers.
+func addPathMiddleware(traefikConfig *config.TraefikConfig, serviceName string, path virtualPath) {
+	middleware := path.middleware()
+	if middleware == nil {
+		return
+	}
+
+	name := pathMiddlewareName(serviceName)
+	traefikConfig.HTTP.Middlewares[name] = middleware
+	for _, router := range traefikConfig.HTTP.Routers {
+		if isContainerService(router.Service, serviceName) {
+			router.Middlewares = append(router.Middlewares, name)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+)
+
+func TestParseVirtualPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		dest    string
+		want    virtualPath
+		wantErr bool
+	}{
+		{name: "none", want: virtualPath{}},
+		{name: "root is the whole host", path: "/", dest: "/", want: virtualPath{}},
+		{name: "prefix", path: "/api/", want: virtualPath{prefix: "/api/"}},
+		{name: "prefix with destination", path: " /api/ ", dest: "/v1/", want: virtualPath{prefix: "/api/", dest: "/v1/"}},
+		{name: "destination without path", dest: "/v1/", wantErr: true},
+		{name: "relative path", path: "api", wantErr: true},
+		{name: "regex location", path: "~^/api", wantErr: true},
+		{name: "backtick", path: "/api`)", wantErr: true},
+		{name: "relative destination", path: "/api", dest: "v1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseVirtualPath(tt.path, tt.dest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseVirtualPath(%q, %q) error = %v, wantErr %v", tt.path, tt.dest, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseVirtualPath(%q, %q) = %+v, want %+v", tt.path, tt.dest, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVirtualPathMiddleware(t *testing.T) {
+	tests := []struct {
+		name string
+		path virtualPath
+		want *config.Middleware
+	}{
+		{name: "no destination", path: virtualPath{prefix: "/api/"}},
+		{name: "same destination", path: virtualPath{prefix: "/api/", dest: "/api/"}},
+		{
+			name: "strip",
+			path: virtualPath{prefix: "/api/", dest: "/"},
+			want: &config.Middleware{StripPrefix: &config.StripPrefixMiddleware{Prefixes: []string{"/api/"}}},
+		},
+		{
+			name: "replace",
+			path: virtualPath{prefix: "/api.v2/", dest: "/v2/"},
+			want: &config.Middleware{ReplacePathRegex: &config.ReplacePathRegexMiddleware{
+				Regex:       `^/api\.v2/(.*)`,
+				Replacement: "/v2/$1",
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.path.middleware(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("middleware() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateTraefikConfigVirtualPath(t *testing.T) {
+	cl := testLayer()
+	info := ContainerInfo{Name: "api", VirtualHost: "shop.loc,*.shop.loc", VirtualPath: "/api/", VirtualDest: "/"}
+
+	cfg := cl.generateTraefikConfig(inspectWithIP("/api", "172.0.0.10"), info)
+
+	rules := map[string]string{
+		"api-0":     "Host(`shop.loc`) && PathPrefix(`/api/`)",
+		"api-tls-1": "HostRegexp(`^.*\\.shop\\.loc$`) && PathPrefix(`/api/`)",
+	}
+	for name, want := range rules {
+		router, ok := cfg.HTTP.Routers[name]
+		if !ok {
+			t.Fatalf("missing router %s", name)
+		}
+		if router.Rule != want {
+			t.Errorf("%s rule = %q, want %q", name, router.Rule, want)
+		}
+	}
+	for name, router := range cfg.HTTP.Routers {
+		if !reflect.DeepEqual(router.Middlewares[len(router.Middlewares)-1:], []string{"api-path"}) {
+			t.Errorf("%s middlewares = %v, want the path middleware", name, router.Middlewares)
+		}
+	}
+	if cfg.HTTP.Middlewares["api-path"].StripPrefix == nil {
+		t.Errorf("api-path = %+v, want a stripPrefix middleware", cfg.HTTP.Middlewares["api-path"])
+	}
+
+	info.VirtualPath = "api"
+	if cfg := cl.generateTraefikConfig(inspectWithIP("/api", "172.0.0.10"), info); len(cfg.HTTP.Routers) != 0 || len(cfg.HTTP.Services) != 0 {
+		t.Errorf("invalid VIRTUAL_PATH generated %d routers and %d services, want none", len(cfg.HTTP.Routers), len(cfg.HTTP.Services))
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sparkfabrik/http-proxy/pkg/permissions"
+)
+
+// DefaultCertsDir is where the certificates shared with Traefik are mounted
+const DefaultCertsDir = "/traefik/certs"
+
+var (
+	// dynamicDirRequirement: the layer writes here and Traefik must list it
+	dynamicDirRequirement = permissions.Requirement{Writable: true, Mode: ConfigDirPermissions}
+
+	// dynamicFileRequirement: generated files must be readable by Traefik
+	dynamicFileRequirement = permissions.Requirement{Mode: ConfigFilePermissions}
+)
+
+// checkPermissions validates the dynamic directory and the certificates at
+// startup. Problems in the dynamic directory are repaired in place; the
+// certificates are mounted read-only, so their problems are only reported,
+// with paths translated to the host when CertsHostDir is set. An error is
+// returned only when the dynamic directory stays unwritable.
+func (cl *CompatibilityLayer) checkPermissions() error {
+	dir := cl.config.TraefikDynamicDir
+
+	if !cl.config.DryRun {
+		if err := os.MkdirAll(dir, ConfigDirPermissions); err != nil {
+			return fmt.Errorf("failed to create Traefik dynamic directory: %w", err)
+		}
+	}
+
+	problems, err := permissions.CheckDir(dir, dynamicDirRequirement, dynamicFileRequirement)
+	if err != nil {
+		cl.logger.Debug("Skipping dynamic directory permission check", "error", err)
+	}
+	cl.repairPermissions(problems)
+
+	if !cl.config.DryRun {
+		remaining, err := permissions.Check(dir, permissions.Requirement{Writable: true})
+		if err != nil {
+			return err
+		}
+		if len(remaining) > 0 {
+			return fmt.Errorf("traefik dynamic directory %s is %s (fix: %s)", dir, remaining[0].Issue, remaining[0].Fix())
+		}
+	}
+
+	cl.checkCertPermissions()
+	return nil
+}
+
+// repairPermissions fixes what it can and reports the rest with the command
+// that fixes it.
+func (cl *CompatibilityLayer) repairPermissions(problems []permissions.Problem) {
+	for _, p := range problems {
+		if !cl.config.DryRun && p.Fix() != "" {
+			if err := permissions.Repair(p); err == nil {
+				cl.logger.Info("Repaired permissions", "path", p.Path, "issue", p.Issue, "applied", p.Fix())
+				continue
+			}
+		}
+		cl.reportPermissionProblem(p)
+	}
+}
+
+// checkCertPermissions reports certificate files Traefik may fail to read.
+func (cl *CompatibilityLayer) checkCertPermissions() {
+	dir := cl.config.CertsDir
+	if dir == "" {
+		return
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		cl.logger.Debug("Certificates directory not mounted, skipping permission check", "path", dir)
+		return
+	}
+
+	problems, err := permissions.CheckDir(dir, permissions.Requirement{}, permissions.Requirement{})
+	if err != nil {
+		cl.logger.Warn("Failed to check certificate permissions", "path", dir, "error", err)
+		return
+	}
+
+	for _, p := range problems {
+		p.Path = hostPath(p.Path, dir, cl.config.CertsHostDir)
+		cl.reportPermissionProblem(p)
+	}
+}
+
+// reportPermissionProblem logs a problem together with its fix, if any.
+func (cl *CompatibilityLayer) reportPermissionProblem(p permissions.Problem) {
+	if fix := p.Fix(); fix != "" {
+		cl.logger.Warn("Permission problem", "path", p.Path, "issue", p.Issue, "fix", fix)
+		return
+	}
+	cl.logger.Warn("Permission problem", "path", p.Path, "issue", p.Issue)
+}
+
+// hostPath translates a path under the container directory dir to the host
+// directory it is mounted from, so reported commands can be run on the host.
+func hostPath(path, dir, hostDir string) string {
+	if hostDir == "" {
+		return path
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return filepath.Join(hostDir, rel)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+func TestCheckPermissionsRepairsDynamicDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dynamic")
+	cl := &CompatibilityLayer{
+		config: &CompatibilityConfig{TraefikDynamicDir: dir},
+		logger: logger.New("test"),
+	}
+
+	// The directory is created when missing
+	if err := cl.checkPermissions(); err != nil {
+		t.Fatalf("checkPermissions: %v", err)
+	}
+
+	file := filepath.Join(dir, "app.yaml")
+	if err := os.WriteFile(file, []byte("http: {}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cl.checkPermissions(); err != nil {
+		t.Fatalf("checkPermissions: %v", err)
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != ConfigFilePermissions {
+		t.Errorf("mode = %04o, want %04o", info.Mode().Perm(), ConfigFilePermissions)
+	}
+}
+
+func TestCheckPermissionsDryRunDoesNotRepair(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.yaml")
+	if err := os.WriteFile(file, []byte("http: {}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cl := &CompatibilityLayer{
+		config: &CompatibilityConfig{TraefikDynamicDir: dir, DryRun: true},
+		logger: logger.New("test"),
+	}
+	if err := cl.checkPermissions(); err != nil {
+		t.Fatalf("checkPermissions: %v", err)
+	}
+
+	info, _ := os.Stat(file)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("dry run changed mode to %04o", info.Mode().Perm())
+	}
+}
+
+func TestHostPath(t *testing.T) {
+	tests := []struct {
+		path, hostDir, want string
+	}{
+		{"/traefik/certs/local.pem", "/home/dev/.local/spark/http-proxy/certs", "/home/dev/.local/spark/http-proxy/certs/local.pem"},
+		{"/traefik/certs", "/home/dev/certs", "/home/dev/certs"},
+		{"/traefik/certs/local.pem", "", "/traefik/certs/local.pem"},
+		{"/elsewhere/local.pem", "/home/dev/certs", "/elsewhere/local.pem"},
+	}
+	for _, tt := range tests {
+		if got := hostPath(tt.path, "/traefik/certs", tt.hostDir); got != tt.want {
+			t.Errorf("hostPath(%q, %q) = %q, want %q", tt.path, tt.hostDir, got, tt.want)
+		}
+	}
+}
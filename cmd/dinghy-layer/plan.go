@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// Plan actions, from the dynamic directory to the generated configs
+const (
+	planCreate = "create"
+	planUpdate = "update"
+	planDelete = "delete"
+)
+
+// diffContext is how many unchanged lines surround each hunk of a plan diff
+const diffContext = 3
+
+// ANSI colours of the dry-run diffs
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1m"
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiCyan  = "\x1b[36m"
+)
+
+// PlanEntry is one config file the layer would create, update or delete,
+// with the unified diff from the file in the dynamic directory to the
+// generated config.
+type PlanEntry struct {
+	ContainerID   string `json:"container_id"`
+	ContainerName string `json:"container_name,omitempty"`
+	ConfigFile    string `json:"config_file"`
+	Action        string `json:"action"`
+	Diff          string `json:"diff"`
+}
+
+// planResponse is the body returned by GET /plan.
+type planResponse struct {
+	DryRun  bool        `json:"dry_run"`
+	Changes []PlanEntry `json:"changes"`
+}
+
+// driftActions maps the drift found by a reconciliation to the plan action
+// correcting it.
+var driftActions = map[string]string{
+	driftMissing:  planCreate,
+	driftModified: planUpdate,
+	driftOrphaned: planDelete,
+}
+
+// handlePlan serves GET /plan: the configs the layer would write or remove,
+// as diffs against the dynamic directory. With DRY_RUN the directory is never
+// changed, so this is everything the layer would have done; otherwise it is
+// the drift a reconciliation would repair.
+func (cl *CompatibilityLayer) handlePlan(w http.ResponseWriter, r *http.Request) {
+	changes, err := cl.plan(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, planResponse{DryRun: cl.config.DryRun, Changes: changes})
+}
+
+// plan renders the config of every running container in memory and diffs
+// it with the dynamic directory, without changing anything.
+func (cl *CompatibilityLayer) plan(ctx context.Context) ([]PlanEntry, error) {
+	containers, err := utils.RetryContainerList(ctx, cl.dockerClient, cl.dockerTimeout, container.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.flushWrites()
+	expected, names := cl.renderConfigs(ctx, containers)
+	drift, err := cl.configDrift(expected, names)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]PlanEntry, 0, len(drift))
+	for _, entry := range drift {
+		current, err := cl.readConfigFile(entry.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, PlanEntry{
+			ContainerID:   entry.ContainerID,
+			ContainerName: entry.ContainerName,
+			ConfigFile:    entry.ConfigFile,
+			Action:        driftActions[entry.Drift],
+			Diff:          configDiff(entry.ConfigFile, current, expected[entry.ContainerID]),
+		})
+	}
+	return changes, nil
+}
+
+// readConfigFile returns a file of the dynamic directory, or nil when it
+// does not exist.
+func (cl *CompatibilityLayer) readConfigFile(name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(cl.config.TraefikDynamicDir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// printPlan prints, in dry-run mode, the diff of the write (data) or removal
+// (nil data) of a config file the layer skips, and returns the action: "" when
+// the file already matches.
+func (cl *CompatibilityLayer) printPlan(name string, data []byte) string {
+	current, err := cl.readConfigFile(name)
+	if err != nil {
+		cl.logger.Warn("Failed to read config file for the dry-run diff", "config_file", name, "error", err)
+	}
+
+	var action string
+	switch {
+	case data == nil && current == nil:
+		return ""
+	case data == nil:
+		action = planDelete
+	case current == nil:
+		action = planCreate
+	default:
+		action = planUpdate
+	}
+
+	diff := configDiff(name, current, data)
+	if diff == "" {
+		return ""
+	}
+	if cl.config.DryRunColor {
+		diff = colorizeDiff(diff)
+	}
+	out := cl.planOut
+	if out == nil {
+		out = os.Stdout
+	}
+	fmt.Fprint(out, diff)
+	return action
+}
+
+// configDiff returns the unified diff from the current content of a config
+// file to the new one, nil standing for a missing file as in git diffs.
+func configDiff(name string, current, next []byte) string {
+	from, to := "a/"+name, "b/"+name
+	if current == nil {
+		from = "/dev/null"
+	}
+	if next == nil {
+		to = "/dev/null"
+	}
+	return unifiedDiff(from, to, splitLines(current), splitLines(next))
+}
+
+// splitLines splits a file into its lines, without the final newline.
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// diffLine is a line of a diff: ' ' kept, '-' removed or '+' added.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// diffLines returns the edit script from a to b along their longest common
+// subsequence. Config files are small, so the quadratic table is fine.
+func diffLines(a, b []string) []diffLine {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		// Removed lines come before the lines replacing them, as in git
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	return lines
+}
+
+// unifiedDiff formats the changes from a to b as a unified diff with
+// diffContext lines of context, or returns "" when they are equal.
+func unifiedDiff(fromName, toName string, a, b []string) string {
+	lines := diffLines(a, b)
+
+	// Line numbers in a and b before each diff line
+	aLine := make([]int, len(lines)+1)
+	bLine := make([]int, len(lines)+1)
+	for k, line := range lines {
+		aLine[k+1], bLine[k+1] = aLine[k], bLine[k]
+		if line.op != '+' {
+			aLine[k+1]++
+		}
+		if line.op != '-' {
+			bLine[k+1]++
+		}
+	}
+
+	var sb strings.Builder
+	for k := 0; k < len(lines); k++ {
+		if lines[k].op == ' ' {
+			continue
+		}
+		// A hunk runs until the next change is too far to share context
+		start, end := max(0, k-diffContext), k
+		for next := k + 1; next < len(lines) && next <= end+2*diffContext; next++ {
+			if lines[next].op != ' ' {
+				end = next
+			}
+		}
+		end = min(len(lines), end+diffContext+1)
+
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n",
+			hunkRange(aLine[start], aLine[end]-aLine[start]),
+			hunkRange(bLine[start], bLine[end]-bLine[start]))
+		for _, line := range lines[start:end] {
+			sb.WriteByte(line.op)
+			sb.WriteString(line.text)
+			sb.WriteByte('\n')
+		}
+		k = end - 1
+	}
+	return sb.String()
+}
+
+// hunkRange formats the range of a hunk header: an empty range is given by
+// the line before it.
+func hunkRange(before, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
+
+// isTerminal reports whether f is a terminal, so redirected output and logs
+// collected by Docker without a TTY get no colour codes.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorizeDiff colours a unified diff for a terminal.
+func colorizeDiff(diff string) string {
+	var sb strings.Builder
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		if line == "" {
+			continue
+		}
+		text := strings.TrimSuffix(line, "\n")
+		var color string
+		switch {
+		case strings.HasPrefix(text, "--- "), strings.HasPrefix(text, "+++ "):
+			color = ansiBold
+		case strings.HasPrefix(text, "@@"):
+			color = ansiCyan
+		case strings.HasPrefix(text, "-"):
+			color = ansiRed
+		case strings.HasPrefix(text, "+"):
+			color = ansiGreen
+		}
+		if color == "" {
+			sb.WriteString(line)
+			continue
+		}
+		sb.WriteString(color + text + ansiReset + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	lines := func(s string) []string { return splitLines([]byte(s)) }
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{name: "equal", a: "a\nb\n", b: "a\nb\n", want: ""},
+		{
+			name: "create",
+			b:    "a\nb\n",
+			want: "--- from\n+++ to\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "change with context",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b:    "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want: "--- from\n+++ to\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "distant changes get their own hunks",
+			a:    "a\n1\n2\n3\n4\n5\n6\n7\n8\nz\n",
+			b:    "A\n1\n2\n3\n4\n5\n6\n7\n8\nZ\n",
+			want: "--- from\n+++ to\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n@@ -7,4 +7,4 @@\n 6\n 7\n 8\n-z\n+Z\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("from", "to", lines(tt.a), lines(tt.b)); got != tt.want {
+				t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColorizeDiff(t *testing.T) {
+	got := colorizeDiff("--- a/x\n+++ b/x\n@@ -1 +1 @@\n-old\n+new\n same\n")
+	want := ansiBold + "--- a/x" + ansiReset + "\n" + ansiBold + "+++ b/x" + ansiReset + "\n" +
+		ansiCyan + "@@ -1 +1 @@" + ansiReset + "\n" + ansiRed + "-old" + ansiReset + "\n" +
+		ansiGreen + "+new" + ansiReset + "\n same\n"
+	if got != want {
+		t.Errorf("colorizeDiff() = %q, want %q", got, want)
+	}
+}
+
+func TestDryRunPrintsPlan(t *testing.T) {
+	const id = "0123456789abcdef0123"
+	cl := testLayerWithDocker(t, managedContainer(id, "web", "web.loc", "172.0.0.5"))
+	cl.config.DryRun = true
+	var out bytes.Buffer
+	cl.planOut = &out
+	ctx := context.Background()
+
+	if err := cl.processContainer(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "--- /dev/null\n+++ b/0123456789ab.yaml\n") || !strings.Contains(out.String(), "+            rule: Host(`web.loc`)") {
+		t.Errorf("printed plan:\n%s", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(cl.config.TraefikDynamicDir, "0123456789ab.yaml")); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote the config: %v", err)
+	}
+
+	out.Reset()
+	if err := cl.removeTraefikConfig(id); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("removing a config that does not exist printed:\n%s", out.String())
+	}
+}
+
+func TestHandlePlan(t *testing.T) {
+	const id = "0123456789abcdef0123"
+	cl := testLayerWithDocker(t, managedContainer(id, "web", "web.loc", "172.0.0.5"))
+	dir := cl.config.TraefikDynamicDir
+	for name, content := range map[string]string{"0123456789ab.yaml": "http: {}\n", "dddddddddddd.yaml": "http: {}\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), ConfigFilePermissions); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/plan", nil))
+
+	var resp planResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if rec.Code != http.StatusOK || len(resp.Changes) != 2 {
+		t.Fatalf("unexpected response %d: %+v", rec.Code, resp)
+	}
+	update, remove := resp.Changes[0], resp.Changes[1]
+	if update.Action != planUpdate || update.ContainerName != "web" || !strings.Contains(update.Diff, "-http: {}\n") {
+		t.Errorf("update = %+v", update)
+	}
+	if remove.Action != planDelete || !strings.HasPrefix(remove.Diff, "--- a/dddddddddddd.yaml\n+++ /dev/null\n") {
+		t.Errorf("delete = %+v", remove)
+	}
+
+	// The plan changes nothing
+	if data, _ := os.ReadFile(filepath.Join(dir, "0123456789ab.yaml")); string(data) != "http: {}\n" {
+		t.Errorf("plan rewrote the config: %s", data)
+	}
+}
+
+func TestPlanHasNoSideEffects(t *testing.T) {
+	const id = "0123456789abcdef0123"
+	var calls int
+	cl := testLayerWithDocker(t, managedContainer(id, "web", "web.loc", "172.0.0.5"))
+	cl.config.PortProbe = true
+	cl.config.PortProbePorts = []string{"80", "3000"}
+	cl.portDial = listeningOn(&calls, "172.0.0.5:3000")
+
+	changes, err := cl.plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Action != planCreate {
+		t.Fatalf("changes = %+v", changes)
+	}
+	if calls != 0 {
+		t.Errorf("plan probed %d ports", calls)
+	}
+	if routes := cl.routes.list(); len(routes) != 0 {
+		t.Errorf("plan recorded routes: %+v", routes)
+	}
+
+	// Ports probed before are used
+	cl.probedPorts.Store(id, "3000")
+	changes, err = cl.plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || !strings.Contains(changes[0].Diff, "172.0.0.5:3000") {
+		t.Errorf("plan ignored the probed port: %+v", changes)
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if isTerminal(f) {
+		t.Error("isTerminal() = true for a regular file")
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+const (
+	// DefaultPortProbePorts are the ports probed, in order, on containers
+	// without port information
+	DefaultPortProbePorts = "80,8080,3000,5000"
+
+	// DefaultPortProbeContainer is the proxy container the probes dial from
+	DefaultPortProbeContainer = "http-proxy"
+
+	// portProbeDialTimeout is the timeout of a single probe, in seconds
+	portProbeDialTimeout = 1
+)
+
+// portDialFunc opens a TCP connection to addr, returning nil when something
+// listens there.
+type portDialFunc func(ctx context.Context, addr string) error
+
+// parsePortProbePorts parses HTTP_PROXY_PORT_PROBE_PORTS, a comma-separated
+// list of ports.
+func parsePortProbePorts(spec string) ([]string, error) {
+	ports := splitList(spec)
+	for _, port := range ports {
+		if !isPort(port) {
+			return nil, fmt.Errorf("invalid HTTP_PROXY_PORT_PROBE_PORTS entry %q", port)
+		}
+	}
+	return ports, nil
+}
+
+// needsPortProbe reports whether nothing tells which port a container
+// serves: no VIRTUAL_HOST or VIRTUAL_PORT port, and no exposed or bound port.
+func needsPortProbe(containerInfo ContainerInfo, inspect types.ContainerJSON) bool {
+	if containerInfo.VirtualPort != "" || detectedPort(inspect) != "" {
+		return false
+	}
+	for _, host := range parseVirtualHosts(containerInfo.VirtualHost) {
+		if host.port != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// probePort dials ip on each port in order and returns the first one
+// accepting connections, or "" when none does.
+func probePort(ctx context.Context, dial portDialFunc, ip string, ports []string) string {
+	for _, port := range ports {
+		if ctx.Err() != nil {
+			return ""
+		}
+		if dial(ctx, net.JoinHostPort(ip, port)) == nil {
+			return port
+		}
+	}
+	return ""
+}
+
+// proxyPortDial dials from inside the proxy container with its nc: the
+// proxy, unlike dinghy-layer, is attached to the application networks.
+func (cl *CompatibilityLayer) proxyPortDial(containerName string) portDialFunc {
+	return func(ctx context.Context, addr string) error {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+
+		// The exec calls and the dial share one deadline, so a stalled
+		// daemon cannot hang the probe
+		ctx, cancel := utils.WithDockerTimeout(ctx, cl.dockerTimeout)
+		defer cancel()
+
+		exec, err := cl.dockerClient.ContainerExecCreate(ctx, containerName, container.ExecOptions{
+			Cmd:          []string{"nc", "-z", "-w", strconv.Itoa(portProbeDialTimeout), host, port},
+			AttachStdout: true,
+			AttachStderr: true,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create probe exec: %w", err)
+		}
+
+		resp, err := cl.dockerClient.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to start probe exec: %w", err)
+		}
+		// The attached stream ignores the context once established
+		stop := context.AfterFunc(ctx, resp.Close)
+		defer stop()
+		_, _ = io.Copy(io.Discard, resp.Reader)
+		resp.Close()
+
+		result, err := cl.dockerClient.ContainerExecInspect(ctx, exec.ID)
+		if err != nil {
+			return fmt.Errorf("failed to inspect probe exec: %w", err)
+		}
+		if result.ExitCode != 0 {
+			return fmt.Errorf("nothing listens on %s", addr)
+		}
+		return nil
+	}
+}
+
+// probeContainerPort fills in the VIRTUAL_PORT of a container without port
+// information with the first probed port it answers on, when probing is
+// enabled. The result is cached per container; a container answering on
+// none of the ports keeps the port 80 default.
+func (cl *CompatibilityLayer) probeContainerPort(ctx context.Context, inspect types.ContainerJSON, containerInfo *ContainerInfo) {
+	if !cl.config.PortProbe || !needsPortProbe(*containerInfo, inspect) {
+		return
+	}
+	if port, ok := cl.probedPort(inspect, *containerInfo); ok {
+		containerInfo.VirtualPort = port
+		return
+	}
+
+	ip := cl.containerIP(inspect)
+	if ip == "" {
+		return
+	}
+
+	dial := cl.portDial
+	if dial == nil {
+		dial = cl.proxyPortDial(cl.config.PortProbeContainer)
+	}
+	port := probePort(ctx, dial, ip, cl.config.PortProbePorts)
+	if port == "" {
+		cl.logger.Warn("No probed port answered, using port 80",
+			"container_id", utils.FormatDockerID(inspect.ID),
+			"container_name", containerInfo.Name,
+			"probed_ports", cl.config.PortProbePorts)
+		return
+	}
+
+	cl.logger.Info("Using probed port for container without port information",
+		"container_id", utils.FormatDockerID(inspect.ID),
+		"container_name", containerInfo.Name,
+		"port", port)
+	cl.probedPorts.Store(inspect.ID, port)
+	containerInfo.VirtualPort = port
+}
+
+// probedPort returns the port an earlier probe found for a container needing
+// one, without probing.
+func (cl *CompatibilityLayer) probedPort(inspect types.ContainerJSON, containerInfo ContainerInfo) (string, bool) {
+	if !cl.config.PortProbe || !needsPortProbe(containerInfo, inspect) {
+		return "", false
+	}
+	port, ok := cl.probedPorts.Load(inspect.ID)
+	if !ok {
+		return "", false
+	}
+	return port.(string), true
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+// listeningOn returns a dial answering only on addrs, counting the dials.
+func listeningOn(calls *int, addrs ...string) portDialFunc {
+	return func(_ context.Context, addr string) error {
+		*calls++
+		for _, a := range addrs {
+			if a == addr {
+				return nil
+			}
+		}
+		return errors.New("refused")
+	}
+}
+
+func TestParsePortProbePorts(t *testing.T) {
+	if ports, err := parsePortProbePorts(DefaultPortProbePorts); err != nil || len(ports) != 4 {
+		t.Errorf("parsePortProbePorts(default) = %v, %v", ports, err)
+	}
+	if _, err := parsePortProbePorts("80,http"); err == nil {
+		t.Error("parsePortProbePorts() accepted a non-numeric port")
+	}
+}
+
+func TestNeedsPortProbe(t *testing.T) {
+	exposed := inspectWithIP("/app", "172.0.0.50")
+	exposed.Config = &container.Config{ExposedPorts: nat.PortSet{"8000/tcp": {}}}
+
+	tests := []struct {
+		name string
+		info ContainerInfo
+		want bool
+	}{
+		{name: "no port information", info: ContainerInfo{VirtualHost: "app.loc"}, want: true},
+		{name: "VIRTUAL_PORT", info: ContainerInfo{VirtualHost: "app.loc", VirtualPort: "8000"}},
+		{name: "host port", info: ContainerInfo{VirtualHost: "app.loc:8000"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := needsPortProbe(tt.info, inspectWithIP("/app", "172.0.0.50")); got != tt.want {
+				t.Errorf("needsPortProbe() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if needsPortProbe(ContainerInfo{VirtualHost: "app.loc"}, exposed) {
+		t.Error("needsPortProbe() = true for a container exposing a port")
+	}
+}
+
+func TestProbeContainerPort(t *testing.T) {
+	var calls int
+	cl := testLayer()
+	cl.config.PortProbe = true
+	cl.config.PortProbePorts = []string{"80", "8080", "3000", "5000"}
+	cl.portDial = listeningOn(&calls, "172.0.0.50:3000")
+
+	inspect := inspectWithIP("/app", "172.0.0.50")
+	inspect.ID = "app"
+	info := ContainerInfo{VirtualHost: "app.loc"}
+	cl.probeContainerPort(context.Background(), inspect, &info)
+	if info.VirtualPort != "3000" || calls != 3 {
+		t.Fatalf("VirtualPort = %q after %d dials, want 3000 after 3", info.VirtualPort, calls)
+	}
+
+	// The probed port is remembered until the container's config is removed
+	info = ContainerInfo{VirtualHost: "app.loc"}
+	cl.probeContainerPort(context.Background(), inspect, &info)
+	if info.VirtualPort != "3000" || calls != 3 {
+		t.Errorf("VirtualPort = %q after %d dials, want the cached 3000", info.VirtualPort, calls)
+	}
+
+	cl.config.PortProbe = false
+	info = ContainerInfo{VirtualHost: "other.loc"}
+	inspect.ID = "other"
+	cl.probeContainerPort(context.Background(), inspect, &info)
+	if info.VirtualPort != "" || calls != 3 {
+		t.Errorf("probing disabled: VirtualPort = %q after %d dials", info.VirtualPort, calls)
+	}
+}
+
+func TestProbeContainerPortNoAnswer(t *testing.T) {
+	var calls int
+	cl := testLayer()
+	cl.config.PortProbe = true
+	cl.config.PortProbePorts = []string{"80", "8080"}
+	cl.portDial = listeningOn(&calls)
+
+	info := ContainerInfo{VirtualHost: "app.loc"}
+	cl.probeContainerPort(context.Background(), inspectWithIP("/app", "172.0.0.50"), &info)
+	if info.VirtualPort != "" || calls != 2 {
+		t.Errorf("VirtualPort = %q after %d dials, want the default kept after 2", info.VirtualPort, calls)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// fallbackPort returns the port of the hosts that name none: VIRTUAL_PORT,
+// else the port detected from the container.
+func fallbackPort(virtualPort string, inspect types.ContainerJSON) string {
+	if virtualPort != "" {
+		return virtualPort
+	}
+	return getDefaultPort(inspect)
+}
+
+// hostPort returns the backend port of a VIRTUAL_HOST entry: its own
+// (host:port), else fallback.
+func hostPort(host virtualHost, fallback string) string {
+	if host.port != "" {
+		return host.port
+	}
+	return fallback
+}
+
+// portServices names the services of a container's ports. The primary port,
+// as picked by getEffectivePort, keeps the plain service name so single-port
+// containers are unchanged; other ports get serviceName-<port>, kept apart by
+// uniqueName from the services of other containers: the file provider shares
+// one namespace, and a container may be named like another one's port service.
+type portServices struct {
+	serviceName string
+	primary     string
+	used        map[string]bool
+	names       map[string]string
+}
+
+// newPortServices names the ports of serviceName; used holds the service
+// names of the other containers and may be nil.
+func newPortServices(serviceName, primary string, used map[string]bool) *portServices {
+	if used == nil {
+		used = make(map[string]bool)
+	}
+	used[serviceName] = true
+	return &portServices{serviceName: serviceName, primary: primary, used: used, names: make(map[string]string)}
+}
+
+// name returns the service of the routes to port.
+func (p *portServices) name(port string) string {
+	if port == p.primary {
+		return p.serviceName
+	}
+	if name, ok := p.names[port]; ok {
+		return name
+	}
+	name := uniqueName(p.used, p.serviceName+"-"+port)
+	p.names[port] = name
+	return name
+}
+
+// isContainerService reports whether name is the service of the container
+// whose primary service is serviceName, including its per-port services and
+// their uniqueName suffix.
+func isContainerService(name, serviceName string) bool {
+	if name == serviceName {
+		return true
+	}
+	suffix, ok := strings.CutPrefix(name, serviceName+"-")
+	if !ok {
+		return false
+	}
+	port, n, suffixed := strings.Cut(suffix, "-")
+	if !suffixed {
+		return isPort(port)
+	}
+	count, err := strconv.Atoi(n)
+	return isPort(port) && err == nil && count >= 2 && strconv.Itoa(count) == n
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsContainerService(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"web", true},
+		{"web-8080", true},
+		{"web-8080-2", true},
+		{"web-8080-1", false},
+		{"web-8080-02", false},
+		{"web-8080-admin", false},
+		{"web-admin", false},
+		{"web-99999", false},
+		{"webapp", false},
+	}
+	for _, tt := range tests {
+		if got := isContainerService(tt.name, "web"); got != tt.want {
+			t.Errorf("isContainerService(%q, web) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateTraefikConfigPerHostPorts(t *testing.T) {
+	cl := testLayer()
+	info := ContainerInfo{
+		Name:            "app",
+		VirtualHost:     "api.app.loc:8080,web.app.loc:3000,docs.app.loc",
+		VirtualPort:     "4000",
+		SecurityHeaders: "strict",
+	}
+
+	cfg := cl.generateTraefikConfig(inspectWithIP("/app", "172.0.0.14"), info)
+
+	wantServices := map[string]string{
+		"app":      "http://172.0.0.14:8080",
+		"app-3000": "http://172.0.0.14:3000",
+		"app-4000": "http://172.0.0.14:4000",
+	}
+	gotServices := make(map[string]string)
+	for name, svc := range cfg.HTTP.Services {
+		gotServices[name] = svc.LoadBalancer.Servers[0].URL
+	}
+	if !reflect.DeepEqual(gotServices, wantServices) {
+		t.Errorf("services = %v, want %v", gotServices, wantServices)
+	}
+
+	wantRouters := map[string]string{
+		"app-0": "app", "app-tls-0": "app",
+		"app-1": "app-3000", "app-tls-1": "app-3000",
+		"app-2": "app-4000", "app-tls-2": "app-4000",
+	}
+	for name, service := range wantRouters {
+		router := cfg.HTTP.Routers[name]
+		if router == nil || router.Service != service {
+			t.Errorf("router %s = %+v, want service %s", name, router, service)
+			continue
+		}
+		if router.TLS != nil && !reflect.DeepEqual(router.Middlewares, []string{"app-security-headers"}) {
+			t.Errorf("router %s middlewares = %v, want the security headers", name, router.Middlewares)
+		}
+	}
+}
+
+func TestGenerateTraefikConfigPortServiceCollision(t *testing.T) {
+	cl := testLayer()
+	// Another container is named like app's port 3000 service
+	cl.routes.set(ContainerRoutes{ContainerID: "bbbbbbbbbbbb", ContainerName: "app-3000", ServiceName: "app-3000"})
+	info := ContainerInfo{Name: "app", VirtualHost: "api.app.loc:8080,web.app.loc:3000,admin.app.loc:3000"}
+
+	cfg := cl.generateTraefikConfig(inspectWithIP("/app", "172.0.0.14"), info)
+
+	if _, ok := cfg.HTTP.Services["app-3000"]; ok {
+		t.Error("port service took the name of another container's service")
+	}
+	for _, name := range []string{"app-1", "app-tls-1", "app-2", "app-tls-2"} {
+		if router := cfg.HTTP.Routers[name]; router == nil || router.Service != "app-3000-2" {
+			t.Errorf("router %s = %+v, want service app-3000-2", name, router)
+		}
+	}
+	if svc := cfg.HTTP.Services["app-3000-2"]; svc == nil || svc.LoadBalancer.Servers[0].URL != "http://172.0.0.14:3000" {
+		t.Errorf("service app-3000-2 = %+v, want port 3000", svc)
+	}
+}
+
+func TestNewTemplateDataPerHostPorts(t *testing.T) {
+	info := ContainerInfo{Name: "app", VirtualHost: "api.app.loc:8080,web.app.loc:3000,admin.app.loc:3000"}
+
+	data, err := newTemplateData(inspectWithIP("/app", "172.0.0.14"), info, "172.0.0.14", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TemplateService{
+		{Name: "app", Port: "8080", ServerURL: "http://172.0.0.14:8080"},
+		{Name: "app-3000", Port: "3000", ServerURL: "http://172.0.0.14:3000"},
+	}
+	if !reflect.DeepEqual(data.Services, want) {
+		t.Errorf("Services = %+v, want %+v", data.Services, want)
+	}
+	if data.Hosts[2].ServiceName != "app-3000" || data.Hosts[2].Port != "3000" {
+		t.Errorf("third host = %+v, want service app-3000", data.Hosts[2])
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// HTTP_PROXY_PRESET values
+const (
+	presetPHPFPM     = "php-fpm"
+	presetNodeSSE    = "node-sse"
+	presetFileUpload = "file-upload"
+)
+
+const mib = 1 << 20
+
+// routePreset bundles the settings a kind of application gets in production:
+// request body limits, timeouts towards the backend, how responses are
+// flushed and extra response headers. Unset fields keep Traefik's defaults.
+type routePreset struct {
+	buffering       *config.BufferingMiddleware
+	timeouts        *config.ForwardingTimeouts
+	flushInterval   string
+	responseHeaders map[string]string
+}
+
+// routePresets mirror common production setups:
+//   - php-fpm: nginx in front of PHP-FPM, with a 64 MiB client_max_body_size
+//     and a 60s fastcgi_read_timeout
+//   - node-sse: streamed responses (server-sent events, long polling), flushed
+//     on every write and never buffered by proxies further out
+//   - file-upload: request bodies up to 1 GiB, spooled to disk past 2 MiB, and
+//     backends given 5 minutes to process them
+var routePresets = map[string]routePreset{
+	presetPHPFPM: {
+		buffering: &config.BufferingMiddleware{MaxRequestBodyBytes: 64 * mib, MemRequestBodyBytes: 1 * mib},
+		timeouts:  &config.ForwardingTimeouts{ResponseHeaderTimeout: "60s"},
+	},
+	presetNodeSSE: {
+		timeouts:        &config.ForwardingTimeouts{IdleConnTimeout: "300s"},
+		flushInterval:   "-1",
+		responseHeaders: map[string]string{"X-Accel-Buffering": "no"},
+	},
+	presetFileUpload: {
+		buffering: &config.BufferingMiddleware{MaxRequestBodyBytes: 1024 * mib, MemRequestBodyBytes: 2 * mib},
+		timeouts:  &config.ForwardingTimeouts{ResponseHeaderTimeout: "300s"},
+	},
+}
+
+// parseRoutePreset returns the preset named by HTTP_PROXY_PRESET, nil when
+// none is set. ok is false for unknown presets.
+func parseRoutePreset(name string) (preset *routePreset, ok bool) {
+	switch name = strings.ToLower(strings.TrimSpace(name)); name {
+	case "", "off", "none":
+		return nil, true
+	default:
+		p, ok := routePresets[name]
+		if !ok {
+			return nil, false
+		}
+		return &p, true
+	}
+}
+
+// presetBufferingMiddlewareName returns the name of the middleware limiting
+// and buffering a service's request bodies.
+func presetBufferingMiddlewareName(serviceName string) string {
+	return serviceName + "-preset-buffering"
+}
+
+// presetHeadersMiddlewareName returns the name of the middleware carrying a
+// service's preset response headers.
+func presetHeadersMiddlewareName(serviceName string) string {
+	return serviceName + "-preset-headers"
+}
+
+// apply attaches the preset's middlewares to the service's routers and sets
+// up the response forwarding of its load balancers. The timeouts go to the
+// servers transport, see transport.
+func (p *routePreset) apply(traefikConfig *config.TraefikConfig, serviceName string) {
+	if p == nil {
+		return
+	}
+
+	var middlewares []string
+	if p.buffering != nil {
+		name := presetBufferingMiddlewareName(serviceName)
+		buffering := *p.buffering
+		traefikConfig.HTTP.Middlewares[name] = &config.Middleware{Buffering: &buffering}
+		middlewares = append(middlewares, name)
+	}
+	if len(p.responseHeaders) > 0 {
+		name := presetHeadersMiddlewareName(serviceName)
+		headers := make(map[string]string, len(p.responseHeaders))
+		for k, v := range p.responseHeaders {
+			headers[k] = v
+		}
+		traefikConfig.HTTP.Middlewares[name] = &config.Middleware{
+			Headers: &config.HeadersMiddleware{CustomResponseHeaders: headers},
+		}
+		middlewares = append(middlewares, name)
+	}
+	for _, router := range traefikConfig.HTTP.Routers {
+		if isContainerService(router.Service, serviceName) {
+			router.Middlewares = append(router.Middlewares, middlewares...)
+		}
+	}
+
+	if p.flushInterval == "" {
+		return
+	}
+	for name, svc := range traefikConfig.HTTP.Services {
+		if isContainerService(name, serviceName) && svc.LoadBalancer != nil {
+			svc.LoadBalancer.ResponseForwarding = &config.ResponseForwarding{FlushInterval: p.flushInterval}
+		}
+	}
+}
+
+// transport adds the preset's timeouts to the servers transport the backend
+// needs, creating one when it needs none.
+func (p *routePreset) transport(transport *config.ServersTransport) *config.ServersTransport {
+	if p == nil || p.timeouts == nil {
+		return transport
+	}
+	if transport == nil {
+		transport = &config.ServersTransport{}
+	}
+	timeouts := *p.timeouts
+	transport.ForwardingTimeouts = &timeouts
+	return transport
+}
+
+// routePreset parses a container's preset, logging unknown ones, which are
+// ignored.
+func (cl *CompatibilityLayer) routePreset(containerInfo ContainerInfo) *routePreset {
+	preset, ok := parseRoutePreset(containerInfo.Preset)
+	if !ok {
+		cl.logger.Warn("Ignoring unknown HTTP_PROXY_PRESET",
+			"container_id", utils.FormatDockerID(containerInfo.ID),
+			"preset", containerInfo.Preset,
+			"supported", strings.Join([]string{presetPHPFPM, presetNodeSSE, presetFileUpload}, ", "))
+	}
+	return preset
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseRoutePreset(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		want   bool
+		wantOK bool
+	}{
+		{name: "unset", value: "", wantOK: true},
+		{name: "off", value: "off", wantOK: true},
+		{name: "php-fpm", value: "php-fpm", want: true, wantOK: true},
+		{name: "case and spaces", value: " Node-SSE ", want: true, wantOK: true},
+		{name: "unknown", value: "rails"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preset, ok := parseRoutePreset(tt.value)
+			if ok != tt.wantOK || (preset != nil) != tt.want {
+				t.Errorf("parseRoutePreset(%q) = %+v, %v", tt.value, preset, ok)
+			}
+		})
+	}
+}
+
+func TestGenerateTraefikConfigAppliesPreset(t *testing.T) {
+	cl := testLayer()
+
+	cfg := cl.generateTraefikConfig(inspectWithIP("/shop", "172.0.0.8"), ContainerInfo{
+		Name:         "shop",
+		VirtualHost:  "shop.loc",
+		VirtualProto: "https",
+		Preset:       presetFileUpload,
+	})
+
+	buffering := cfg.HTTP.Middlewares[presetBufferingMiddlewareName("shop")]
+	if buffering == nil || buffering.Buffering == nil || buffering.Buffering.MaxRequestBodyBytes != 1024*mib {
+		t.Fatalf("buffering middleware = %+v", buffering)
+	}
+	for _, name := range []string{"shop-0", "shop-tls-0"} {
+		if !slices.Contains(cfg.HTTP.Routers[name].Middlewares, presetBufferingMiddlewareName("shop")) {
+			t.Errorf("router %s middlewares = %v", name, cfg.HTTP.Routers[name].Middlewares)
+		}
+	}
+
+	// The timeouts join the transport the HTTPS backend already needs
+	transport := cfg.HTTP.ServersTransports[serversTransportName("shop")]
+	if transport == nil || !transport.InsecureSkipVerify || transport.ForwardingTimeouts == nil ||
+		transport.ForwardingTimeouts.ResponseHeaderTimeout != "300s" {
+		t.Errorf("servers transport = %+v", transport)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestGenerateTraefikConfigStreamingPreset(t *testing.T) {
+	cl := testLayer()
+
+	cfg := cl.generateTraefikConfig(inspectWithIP("/events", "172.0.0.9"), ContainerInfo{
+		Name:        "events",
+		VirtualHost: "events.loc",
+		Preset:      presetNodeSSE,
+	})
+
+	if _, ok := cfg.HTTP.Middlewares[presetBufferingMiddlewareName("events")]; ok {
+		t.Error("streaming preset buffers request bodies")
+	}
+	headers := cfg.HTTP.Middlewares[presetHeadersMiddlewareName("events")]
+	if headers == nil || headers.Headers.CustomResponseHeaders["X-Accel-Buffering"] != "no" {
+		t.Errorf("headers middleware = %+v", headers)
+	}
+	lb := cfg.HTTP.Services["events"].LoadBalancer
+	if lb.ResponseForwarding == nil || lb.ResponseForwarding.FlushInterval != "-1" {
+		t.Errorf("response forwarding = %+v", lb.ResponseForwarding)
+	}
+	if lb.ServersTransport != serversTransportName("events") {
+		t.Errorf("load balancer transport = %q, want the preset's timeouts", lb.ServersTransport)
+	}
+}
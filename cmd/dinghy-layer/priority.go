@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+)
+
+// Router priorities keep exact hosts on Traefik's own default, the rule
+// length, and rank wildcard and regex hosts on a band below every rule
+// matching an exact hostname (at least "Host(`a.b`)", 11 characters): by the
+// labels of their literal suffix, a catch-all having none, then one more when
+// the rule matches a path too. Routers from Traefik labels, on the same rule
+// length scale, therefore keep winning over generated wildcards.
+const (
+	// priorityWildcardLevels caps the suffix labels ranking wildcard hosts;
+	// deeper suffixes rank like the last level
+	priorityWildcardLevels = 4
+
+	// priorityWildcardMax is the highest priority of a wildcard host rule
+	priorityWildcardMax = 2*priorityWildcardLevels + 2
+)
+
+// rulePriority returns the priority of a rule starting with its host matcher,
+// as generated by hostRule, or Traefik's default of the rule length for other
+// rules.
+func rulePriority(rule string) int {
+	matcher := strings.TrimLeft(rule, "(")
+	if !strings.HasPrefix(matcher, "HostRegexp(`") {
+		return len(rule)
+	}
+
+	pattern, _, _ := strings.Cut(strings.TrimPrefix(matcher, "HostRegexp(`"), "`")
+	priority := 1 + 2*min(literalSuffixLabels(pattern), priorityWildcardLevels)
+	if strings.Contains(rule, "&&") {
+		priority++
+	}
+	return priority
+}
+
+// literalSuffixLabels returns the number of labels a host regex matches
+// literally at its end: 2 for ^.*\.app\.loc$, 0 for ^.*$.
+func literalSuffixLabels(pattern string) int {
+	pattern = strings.TrimSuffix(pattern, "$")
+	var suffix []byte
+	for i := len(pattern) - 1; i >= 0; i-- {
+		c := pattern[i]
+		switch {
+		case i > 0 && pattern[i-1] == '\\' && c == '.':
+			i--
+		case c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9'):
+		default:
+			return countLabels(suffix)
+		}
+		suffix = append(suffix, c)
+	}
+	return countLabels(suffix)
+}
+
+// countLabels counts the non-empty dot-separated labels of a name.
+func countLabels(name []byte) int {
+	n := 0
+	for _, label := range strings.Split(string(name), ".") {
+		if label != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// setRulePriorities gives every HTTP router without a priority its rule
+// priority, so overlapping hosts of different containers are matched by
+// specificity rather than rule length.
+func setRulePriorities(cfg *config.TraefikConfig) {
+	if cfg.HTTP == nil {
+		return
+	}
+	for _, router := range cfg.HTTP.Routers {
+		if router != nil && router.Priority == 0 {
+			router.Priority = rulePriority(router.Rule)
+		}
+	}
+}
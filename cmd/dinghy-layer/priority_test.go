@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestLiteralSuffixLabels(t *testing.T) {
+	tests := map[string]int{
+		`^.*\.app\.loc$`:    2,
+		`^.*\.loc$`:         1,
+		`^.*$`:              0,
+		`^api\..*$`:         0,
+		`^api-[0-9]+\.loc$`: 1,
+		`^app.loc$`:         1,
+	}
+	for pattern, want := range tests {
+		if got := literalSuffixLabels(pattern); got != want {
+			t.Errorf("literalSuffixLabels(%q) = %d, want %d", pattern, got, want)
+		}
+	}
+}
+
+func TestRulePriorityOrdersBySpecificity(t *testing.T) {
+	// From the most to the least specific
+	rules := []string{
+		hostRule("api.shop.loc") + " && PathPrefix(`/v1`)",
+		hostRule("api.shop.loc"),
+		hostRule("a.loc"),
+		hostRule("*.api.shop.loc"),
+		hostRule("*.shop.loc") + " && PathPrefix(`/v1`)",
+		hostRule("*.shop.loc"),
+		hostRule("*.loc"),
+		hostRule("*"),
+	}
+	for i := 1; i < len(rules); i++ {
+		if prev, cur := rulePriority(rules[i-1]), rulePriority(rules[i]); prev <= cur {
+			t.Errorf("rulePriority(%s) = %d, want above rulePriority(%s) = %d", rules[i-1], prev, rules[i], cur)
+		}
+	}
+
+	if got, rule := rulePriority("PathPrefix(`/api`)"), "PathPrefix(`/api`)"; got != len(rule) {
+		t.Errorf("rulePriority(%s) = %d, want the rule length", rule, got)
+	}
+
+	// Wildcards stay below the rule length priorities of Traefik label routers
+	deep := hostRule("*.a.b.c.d.e.loc") + " && PathPrefix(`/v1`)"
+	if got := rulePriority(deep); got != priorityWildcardMax || got >= len(hostRule("a.b")) {
+		t.Errorf("rulePriority(%s) = %d, want %d, below any exact host", deep, got, priorityWildcardMax)
+	}
+}
+
+func TestGenerateTraefikConfigPriorities(t *testing.T) {
+	cl := testLayer()
+	exact := cl.generateTraefikConfig(inspectWithIP("/api", "172.0.0.30"), ContainerInfo{Name: "api", VirtualHost: "api.loc"})
+	wildcard := cl.generateTraefikConfig(inspectWithIP("/catchall", "172.0.0.31"), ContainerInfo{Name: "catchall", VirtualHost: "*.loc"})
+
+	exactRouter, wildcardRouter := exact.HTTP.Routers["api-0"], wildcard.HTTP.Routers["catchall-0"]
+	if exactRouter == nil || wildcardRouter == nil {
+		t.Fatalf("missing routers: %v %v", exact.HTTP.Routers, wildcard.HTTP.Routers)
+	}
+	if exactRouter.Priority <= wildcardRouter.Priority {
+		t.Errorf("exact priority %d, want above the wildcard's %d", exactRouter.Priority, wildcardRouter.Priority)
+	}
+}
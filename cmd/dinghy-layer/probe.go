@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
+
+const (
+	// DefaultProbeTarget is the proxy entrypoint probes are sent through
+	DefaultProbeTarget = "http://http-proxy"
+
+	// DefaultProbePath is the path requested on every route
+	DefaultProbePath = "/"
+
+	// probeTimeout bounds a single probe request
+	probeTimeout = 5 * time.Second
+
+	// probeBodyLimit caps how much of a response body is drained, so the
+	// connection can be reused without reading large pages
+	probeBodyLimit = 64 * 1024
+)
+
+// ProbeResult is the outcome of the last probe of one hostname. A route is
+// healthy when the proxy returned a response below 500; connection errors,
+// timeouts and 5xx responses (e.g. 502 from a dead backend) mark it degraded.
+type ProbeResult struct {
+	Hostname      string    `json:"hostname"`
+	ContainerName string    `json:"container_name"`
+	Healthy       bool      `json:"healthy"`
+	StatusCode    int       `json:"status_code,omitempty"`
+	LatencyMS     float64   `json:"latency_ms"`
+	Error         string    `json:"error,omitempty"`
+	CheckedAt     time.Time `json:"checked_at"`
+}
+
+// routeProber periodically requests every managed route through the proxy,
+// so broken backends are noticed even when no one is browsing. Results feed
+// the metrics registry and the admin API route status.
+type routeProber struct {
+	target   *url.URL
+	path     string
+	interval time.Duration
+	client   *http.Client
+	routes   *routeInventory
+	logger   *logger.Logger
+
+	up      *metrics.Vec
+	latency *metrics.Vec
+	total   *metrics.Vec
+
+	mu      sync.RWMutex
+	results map[string]ProbeResult
+}
+
+// newRouteProber creates a prober sending requests for the routes in the
+// inventory to target, registering its metrics in registry.
+func newRouteProber(target, path string, interval time.Duration, routes *routeInventory, registry *metrics.Registry, log *logger.Logger) (*routeProber, error) {
+	targetURL, err := parseProbeTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return &routeProber{
+		target:   targetURL,
+		path:     path,
+		interval: interval,
+		client: &http.Client{
+			Timeout: probeTimeout,
+			// A redirect (e.g. to HTTPS or a login page) is an answer from the app
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+			Transport: &http.Transport{
+				// Probes check availability, not certificates
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		},
+		routes:  routes,
+		logger:  log,
+		up:      registry.Gauge("http_proxy_route_up", "Whether the last probe of the route through the proxy succeeded.", "host", "container"),
+		latency: registry.Gauge("http_proxy_route_probe_duration_seconds", "Duration of the last probe of the route.", "host", "container"),
+		total:   registry.Counter("http_proxy_route_probes_total", "Route probes by result.", "host", "result"),
+		results: make(map[string]ProbeResult),
+	}, nil
+}
+
+// parseProbeTarget validates the proxy entrypoint probes are sent to.
+func parseProbeTarget(target string) (*url.URL, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid probe target %q: %w", target, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid probe target %q: must be an http or https URL", target)
+	}
+	return u, nil
+}
+
+// Run probes all routes every interval until ctx is done.
+func (p *routeProber) Run(ctx context.Context) error {
+	p.logger.Info("Route probes enabled", "interval", p.interval, "target", p.target.String())
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			p.probeAll(ctx)
+		}
+	}
+}
+
+// probeAll probes every probeable hostname and forgets results for hostnames
+// that are no longer routed.
+func (p *routeProber) probeAll(ctx context.Context) {
+	current := make(map[string]bool)
+	for _, routes := range p.routes.list() {
+		for _, hostname := range routes.Hostnames {
+			// Wildcard and regex hosts have no single name to request
+			if isWildcardHost(hostname) || current[hostname] {
+				continue
+			}
+			current[hostname] = true
+			p.record(p.probe(ctx, hostname, routes.ContainerName))
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for hostname, result := range p.results {
+		if !current[hostname] {
+			delete(p.results, hostname)
+			p.up.Delete(hostname, result.ContainerName)
+			p.latency.Delete(hostname, result.ContainerName)
+			p.total.DeleteMatching(hostname)
+		}
+	}
+}
+
+// probe requests one hostname through the proxy.
+func (p *routeProber) probe(ctx context.Context, hostname, containerName string) ProbeResult {
+	result := ProbeResult{Hostname: hostname, ContainerName: containerName}
+
+	u := *p.target
+	u.Path = p.path
+
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err == nil {
+		req.Host = hostname
+		req.Header.Set("User-Agent", "http-proxy-route-probe")
+
+		var resp *http.Response
+		resp, err = p.client.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, probeBodyLimit))
+			resp.Body.Close()
+			result.StatusCode = resp.StatusCode
+		}
+	}
+
+	result.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+	result.CheckedAt = time.Now().UTC()
+
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+	case result.StatusCode >= 500:
+		result.Error = fmt.Sprintf("proxy returned %d", result.StatusCode)
+	default:
+		result.Healthy = true
+	}
+	return result
+}
+
+// record stores a result, updates metrics and logs health transitions.
+func (p *routeProber) record(result ProbeResult) {
+	p.mu.Lock()
+	previous, seen := p.results[result.Hostname]
+	p.results[result.Hostname] = result
+	p.mu.Unlock()
+
+	// The hostname moved to another container: drop the old container's series
+	if seen && previous.ContainerName != result.ContainerName {
+		p.up.Delete(result.Hostname, previous.ContainerName)
+		p.latency.Delete(result.Hostname, previous.ContainerName)
+	}
+
+	outcome := "ok"
+	up := 1.0
+	if !result.Healthy {
+		outcome = "error"
+		up = 0
+	}
+	p.up.Set(up, result.Hostname, result.ContainerName)
+	p.latency.Set(result.LatencyMS/1000, result.Hostname, result.ContainerName)
+	p.total.Inc(result.Hostname, outcome)
+
+	switch {
+	case !result.Healthy && (!seen || previous.Healthy):
+		p.logger.Warn("Route degraded", "host", result.Hostname, "container", result.ContainerName, "error", result.Error)
+	case result.Healthy && seen && !previous.Healthy:
+		p.logger.Info("Route recovered", "host", result.Hostname, "container", result.ContainerName)
+	}
+}
+
+// result returns the last probe result of a hostname.
+func (p *routeProber) result(hostname string) (ProbeResult, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	result, ok := p.results[hostname]
+	return result, ok
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
+
+// newTestProber returns a prober sending requests to a fake proxy that
+// answers 200 for ok.loc, 302 for login.loc and 502 for anything else.
+func newTestProber(t *testing.T, routes *routeInventory, registry *metrics.Registry) *routeProber {
+	t.Helper()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Host {
+		case "ok.loc":
+			w.WriteHeader(http.StatusOK)
+		case "login.loc":
+			http.Redirect(w, r, "/login", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	t.Cleanup(proxy.Close)
+
+	p, err := newRouteProber(proxy.URL, "/", time.Minute, routes, registry, logger.New("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestRouteProberProbeAll(t *testing.T) {
+	routes := newRouteInventory()
+	routes.set(ContainerRoutes{ContainerID: "a", ContainerName: "app", Hostnames: []string{"ok.loc", "login.loc", "*.app.loc"}})
+	routes.set(ContainerRoutes{ContainerID: "b", ContainerName: "broken", Hostnames: []string{"broken.loc"}})
+
+	registry := metrics.NewRegistry()
+	p := newTestProber(t, routes, registry)
+	p.probeAll(context.Background())
+
+	for hostname, healthy := range map[string]bool{"ok.loc": true, "login.loc": true, "broken.loc": false} {
+		result, ok := p.result(hostname)
+		if !ok {
+			t.Errorf("%s was not probed", hostname)
+			continue
+		}
+		if result.Healthy != healthy {
+			t.Errorf("%s healthy = %v, want %v (%+v)", hostname, result.Healthy, healthy, result)
+		}
+	}
+	if _, ok := p.result("*.app.loc"); ok {
+		t.Error("wildcard hosts must not be probed")
+	}
+	if got := p.up.Value("broken.loc", "broken"); got != 0 {
+		t.Errorf("up{broken.loc} = %v, want 0", got)
+	}
+	if got := p.total.Value("ok.loc", "ok"); got != 1 {
+		t.Errorf("probes_total{ok.loc,ok} = %v, want 1", got)
+	}
+
+	// A removed container's results and series are dropped
+	routes.remove("b")
+	p.probeAll(context.Background())
+	if _, ok := p.result("broken.loc"); ok {
+		t.Error("result of a removed route was kept")
+	}
+	var out strings.Builder
+	registry.Write(&out)
+	if strings.Contains(out.String(), "broken.loc") {
+		t.Errorf("metrics still mention a removed route:\n%s", out.String())
+	}
+}
+
+func TestParseProbeTarget(t *testing.T) {
+	for target, valid := range map[string]bool{
+		"http://http-proxy":       true,
+		"https://http-proxy:8443": true,
+		"http-proxy":              false,
+		"ftp://http-proxy":        false,
+	} {
+		if _, err := parseProbeTarget(target); (err == nil) != valid {
+			t.Errorf("parseProbeTarget(%q) error = %v, want valid %v", target, err, valid)
+		}
+	}
+}
+
+func TestHandleRoutesReportsProbeStatus(t *testing.T) {
+	cl := testLayerWithDocker(t)
+	cl.routes.set(ContainerRoutes{ContainerID: "a", ContainerName: "app", Hostnames: []string{"ok.loc"}})
+	cl.routes.set(ContainerRoutes{ContainerID: "b", ContainerName: "broken", Hostnames: []string{"ok.loc", "broken.loc"}})
+	cl.routes.set(ContainerRoutes{ContainerID: "c", ContainerName: "unprobed", Hostnames: []string{"*.wild.loc"}})
+	cl.prober = newTestProber(t, cl.routes, cl.metrics)
+	cl.prober.probeAll(context.Background())
+
+	rec := httptest.NewRecorder()
+	cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/routes", nil))
+
+	var got []routeStatus
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"app": "healthy", "broken": "degraded", "unprobed": "unknown"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d routes, want %d", len(got), len(want))
+	}
+	for _, status := range got {
+		if status.Status != want[status.ContainerName] {
+			t.Errorf("%s status = %q, want %q", status.ContainerName, status.Status, want[status.ContainerName])
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `http_proxy_route_up{host="broken.loc",container="broken"} 0`) {
+		t.Errorf("metrics missing route_up series:\n%s", rec.Body.String())
+	}
+}
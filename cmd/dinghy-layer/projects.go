@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// projectRoutes lists the URLs served for the containers of a compose
+// project.
+type projectRoutes struct {
+	Project    string   `json:"project"`
+	Containers []string `json:"containers"`
+	URLs       []string `json:"urls"`
+}
+
+// containerProject returns the compose project of a container, if any.
+func containerProject(inspect types.ContainerJSON) string {
+	if inspect.Config == nil {
+		return ""
+	}
+	return utils.ComposeProject(inspect.Config.Labels)
+}
+
+// containerCreated returns when a container was created, zero when unknown.
+func containerCreated(inspect types.ContainerJSON) time.Time {
+	if inspect.ContainerJSONBase == nil {
+		return time.Time{}
+	}
+	created, _ := time.Parse(time.RFC3339Nano, inspect.Created)
+	return created
+}
+
+// containerServiceName returns the name of a container's routers and
+// services. The routers and services of a compose project share the project
+// name as prefix, so they group together in the Traefik dashboard: compose
+// names its containers "<project>-<service>-<n>" already, a container_name
+// set in the compose file gets the prefix added.
+func containerServiceName(inspect types.ContainerJSON) string {
+	name := generateServiceName(inspect.Name)
+	project := containerProject(inspect)
+	if project == "" {
+		return name
+	}
+	prefix := generateServiceName(project)
+	if name == prefix || strings.HasPrefix(name, prefix+"-") {
+		return name
+	}
+	return prefix + "-" + name
+}
+
+// projectURLs returns the URLs a container's routes answer on. Regex hosts
+// have no URL.
+func projectURLs(routes ContainerRoutes) []string {
+	var urls []string
+	for _, hostname := range routes.Hostnames {
+		if strings.HasPrefix(hostname, "~") || strings.Contains(hostname, "*") {
+			continue
+		}
+		urls = append(urls, "https://"+hostname+routes.Path)
+	}
+	return urls
+}
+
+// listProjects groups the served routes by compose project, sorted by
+// project name. Containers outside a project are left out.
+func (cl *CompatibilityLayer) listProjects() []projectRoutes {
+	byName := make(map[string]*projectRoutes)
+	for _, routes := range cl.routes.list() {
+		if routes.Project == "" {
+			continue
+		}
+		project, ok := byName[routes.Project]
+		if !ok {
+			project = &projectRoutes{Project: routes.Project, Containers: []string{}, URLs: []string{}}
+			byName[routes.Project] = project
+		}
+		project.Containers = append(project.Containers, routes.ContainerName)
+		project.URLs = append(project.URLs, projectURLs(routes)...)
+	}
+
+	result := make([]projectRoutes, 0, len(byName))
+	for _, project := range byName {
+		sort.Strings(project.URLs)
+		result = append(result, *project)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Project < result[j].Project })
+	return result
+}
+
+// handleProjects lists the URLs served for each compose project.
+func (cl *CompatibilityLayer) handleProjects(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, cl.listProjects())
+}
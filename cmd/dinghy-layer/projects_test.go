@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/sparkfabrik/http-proxy/pkg/service"
+)
+
+func TestContainerServiceName(t *testing.T) {
+	tests := []struct {
+		name      string
+		container string
+		project   string
+		want      string
+	}{
+		{"no project", "/web", "", "web"},
+		{"compose name", "/shop-web-1", "shop", "shop-web-1"},
+		{"project only", "/shop", "shop", "shop"},
+		{"container_name", "/web", "shop", "shop-web"},
+		{"project sanitized", "/web", "my_shop", "my-shop-web"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inspect := inspectWithIP(tt.container, "172.0.0.2")
+			if tt.project != "" {
+				inspect.Config.Labels = map[string]string{service.ComposeProjectLabel: tt.project}
+			}
+			if got := containerServiceName(inspect); got != tt.want {
+				t.Errorf("containerServiceName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleProjects(t *testing.T) {
+	api := projectContainer("0123456789abcdef0001", "shop-api-1", "shop")
+	api.Config.Env = []string{"VIRTUAL_HOST=api.shop.loc,~^.*\\.shop\\.loc$", "VIRTUAL_PATH=/v1"}
+	web := projectContainer("0123456789abcdef0002", "web", "shop")
+	blog := projectContainer("0123456789abcdef0003", "blog-web-1", "blog")
+	standalone := managedContainer("0123456789abcdef0004", "tools", "tools.loc", "172.0.0.61")
+	cl := testLayerWithDocker(t, api, web, blog, standalone)
+	if err := cl.HandleInitialScan(context.Background()); err != nil {
+		t.Fatalf("HandleInitialScan() error = %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/projects", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200; body %s", rec.Code, rec.Body)
+	}
+	var got []projectRoutes
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	want := []projectRoutes{
+		{Project: "blog", Containers: []string{"blog-web-1"}, URLs: []string{"https://blog-web-1.loc"}},
+		{Project: "shop", Containers: []string{"shop-api-1", "web"}, URLs: []string{"https://api.shop.loc/v1", "https://web.loc"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("projects = %+v, want %+v", got, want)
+	}
+
+	routes, ok := cl.routes.get(web.ID)
+	if !ok || routes.ServiceName != "shop-web" || routes.Project != "shop" {
+		t.Errorf("routes of web = %+v, want service shop-web in project shop", routes)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// DefaultReconcileInterval is how often drift is repaired when
+// HTTP_PROXY_RECONCILE_INTERVAL is not set, as a safety net for missed events
+const DefaultReconcileInterval = "5m"
+
+// reconcileHealthGrace is how much longer than the reconcile interval the
+// last successful reconciliation may be before GET /health reports it, to
+// leave time for the run in progress
+const reconcileHealthGrace = 30 * time.Second
+
+// Kinds of drift between the generated configs and the dynamic directory
+const (
+	driftMissing  = "missing"  // a managed container has no config file
+	driftModified = "modified" // the file differs from the generated config
+	driftOrphaned = "orphaned" // a config file belongs to no managed container
+)
+
+// configFilePattern matches the config files the layer writes, named after
+// the short container ID. Other files in the directory are left alone.
+var configFilePattern = regexp.MustCompile(`^[0-9a-f]{12}\.yaml$`)
+
+// DriftEntry is one config file that does not match its container.
+type DriftEntry struct {
+	ContainerID   string `json:"container_id"`
+	ContainerName string `json:"container_name,omitempty"`
+	ConfigFile    string `json:"config_file"`
+	Drift         string `json:"drift"`
+}
+
+// ReconcileReport is the outcome of a reconciliation. Checked counts the
+// running containers inspected; Repaired is set when the drift was corrected.
+type ReconcileReport struct {
+	Checked  int          `json:"checked"`
+	Drift    []DriftEntry `json:"drift"`
+	Repaired bool         `json:"repaired"`
+}
+
+// reconcile re-inspects every running container, regenerates its config in
+// memory and compares the result with the dynamic directory, catching
+// manually edited files and missed events. With repair, drifted files are
+// rewritten and orphaned ones removed.
+func (cl *CompatibilityLayer) reconcile(ctx context.Context, repair bool) (ReconcileReport, error) {
+	report := ReconcileReport{Drift: []DriftEntry{}}
+
+	containers, err := utils.RetryContainerList(ctx, cl.dockerClient, cl.dockerTimeout, container.ListOptions{})
+	if err != nil {
+		return report, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	// The dynamic directory is compared once debounced writes are on disk
+	cl.flushWrites()
+
+	// Certificates issued since the last run can become the default one
+	if repair {
+		if err := cl.applyDefaultCert(); err != nil {
+			cl.logger.Error("Failed to set the default certificate", "error", err)
+		}
+	}
+
+	report.Checked = len(containers)
+	expected, names := cl.renderConfigs(ctx, containers)
+
+	// Containers that stopped without an event are still in the inventory
+	stale := make(map[string]string)
+	for _, routes := range cl.routes.list() {
+		if routes.Source == routeSourceStatic {
+			continue
+		}
+		id := utils.FormatDockerID(routes.ContainerID)
+		if _, running := names[id]; !running {
+			stale[id] = routes.ContainerID
+			names[id] = routes.ContainerName
+		}
+	}
+
+	report.Drift, err = cl.configDrift(expected, names)
+	if err != nil {
+		return report, err
+	}
+	cl.recordDrift(report.Drift)
+
+	if !repair || len(report.Drift) == 0 {
+		cl.lastReconcile = time.Now()
+		return report, nil
+	}
+	for _, entry := range report.Drift {
+		if entry.Drift == driftOrphaned {
+			id := entry.ContainerID
+			if fullID, ok := stale[id]; ok {
+				id = fullID
+			}
+			err = cl.removeTraefikConfig(id)
+		} else {
+			err = cl.writeConfigData(entry.ContainerID, expected[entry.ContainerID])
+		}
+		if err != nil {
+			return report, err
+		}
+	}
+	report.Repaired = true
+	cl.lastReconcile = time.Now()
+	return report, nil
+}
+
+// renderConfigs regenerates the configs of the running containers in memory,
+// keyed by short container ID, without writing them or touching the route
+// inventory, and returns them with the container names. Callers hold cl.mu.
+func (cl *CompatibilityLayer) renderConfigs(ctx context.Context, containers []types.Container) (map[string][]byte, map[string]string) {
+	expected := make(map[string][]byte)
+	names := make(map[string]string)
+	for _, cont := range containers {
+		name := ""
+		if len(cont.Names) > 0 {
+			name = strings.TrimPrefix(cont.Names[0], "/")
+		}
+		names[utils.FormatDockerID(cont.ID)] = name
+		render, err := cl.planContainer(ctx, cont.ID)
+		if err != nil {
+			cl.logger.Error("Failed to regenerate container config",
+				"error", err,
+				"container_id", utils.FormatDockerID(cont.ID),
+				"container_name", cont.Names)
+			continue
+		}
+		if render != nil {
+			expected[utils.FormatDockerID(cont.ID)] = render.config
+		}
+	}
+	return expected, names
+}
+
+// configDrift compares the expected configs, keyed by short container ID,
+// with the config files in the dynamic directory.
+func (cl *CompatibilityLayer) configDrift(expected map[string][]byte, names map[string]string) ([]DriftEntry, error) {
+	drift := []DriftEntry{}
+
+	entries, err := os.ReadDir(cl.config.TraefikDynamicDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read Traefik dynamic directory: %w", err)
+	}
+	onDisk := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() || !configFilePattern.MatchString(entry.Name()) {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), ".yaml")
+		onDisk[id] = true
+
+		want, ok := expected[id]
+		if !ok {
+			drift = append(drift, DriftEntry{ContainerID: id, ContainerName: names[id], ConfigFile: entry.Name(), Drift: driftOrphaned})
+			continue
+		}
+		got, err := os.ReadFile(filepath.Join(cl.config.TraefikDynamicDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file %s: %w", entry.Name(), err)
+		}
+		if !bytes.Equal(got, want) {
+			drift = append(drift, DriftEntry{ContainerID: id, ContainerName: names[id], ConfigFile: entry.Name(), Drift: driftModified})
+		}
+	}
+
+	for id := range expected {
+		if !onDisk[id] {
+			drift = append(drift, DriftEntry{ContainerID: id, ContainerName: names[id], ConfigFile: cl.configFileName(id), Drift: driftMissing})
+		}
+	}
+
+	sort.Slice(drift, func(i, j int) bool { return drift[i].ConfigFile < drift[j].ConfigFile })
+	return drift, nil
+}
+
+// removeOrphanedConfigs removes the config files of containers that are not
+// running, left behind when containers stopped while the layer was down, so
+// Traefik stops routing to their dead IPs. It returns how many it removed.
+// Callers hold cl.mu.
+func (cl *CompatibilityLayer) removeOrphanedConfigs(running []types.Container) (int, error) {
+	entries, err := os.ReadDir(cl.config.TraefikDynamicDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read Traefik dynamic directory: %w", err)
+	}
+
+	ids := make(map[string]bool, len(running))
+	for _, cont := range running {
+		ids[utils.FormatDockerID(cont.ID)] = true
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !configFilePattern.MatchString(entry.Name()) {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), ".yaml")
+		if ids[id] {
+			continue
+		}
+		if err := cl.removeTraefikConfig(id); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		cl.logger.Info("Removed orphaned Traefik configs", "count", removed)
+	}
+	return removed, nil
+}
+
+// recordDrift logs the drift found by a reconciliation and updates its gauge.
+func (cl *CompatibilityLayer) recordDrift(drift []DriftEntry) {
+	counts := map[string]float64{driftMissing: 0, driftModified: 0, driftOrphaned: 0}
+	for _, entry := range drift {
+		counts[entry.Drift]++
+		cl.logger.Warn("Config drift detected",
+			"container_id", entry.ContainerID,
+			"container_name", entry.ContainerName,
+			"config_file", entry.ConfigFile,
+			"drift", entry.Drift)
+	}
+	for kind, count := range counts {
+		cl.drift.Set(count, kind)
+	}
+}
+
+// runReconciler reconciles and repairs the dynamic directory on the
+// configured interval until ctx is done.
+func (cl *CompatibilityLayer) runReconciler(ctx context.Context) error {
+	cl.mu.Lock()
+	cl.lastReconcile = time.Now()
+	cl.mu.Unlock()
+
+	ticker := time.NewTicker(cl.config.ReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			report, err := cl.reconcile(ctx, true)
+			if err != nil {
+				cl.logger.Error("Reconciliation failed", "error", err)
+				continue
+			}
+			cl.logger.Debug("Reconciled Traefik configuration",
+				"checked", report.Checked,
+				"drift", len(report.Drift))
+		}
+	}
+}
+
+// reconcilerHealth reports the reconciler in GET /health: in error when the
+// last successful reconciliation is older than the interval, so drift is no
+// longer repaired. It returns false when periodic reconciliation is off.
+func (cl *CompatibilityLayer) reconcilerHealth(now time.Time) (componentHealth, bool) {
+	if cl.config.ReconcileInterval <= 0 {
+		return componentHealth{}, false
+	}
+
+	cl.mu.Lock()
+	last := cl.lastReconcile
+	cl.mu.Unlock()
+
+	health := componentHealth{Name: "reconciler", Status: "ok"}
+	switch age := now.Sub(last); {
+	case last.IsZero():
+		health.Status = "unknown"
+	case age > cl.config.ReconcileInterval+reconcileHealthGrace:
+		health.Status = "error"
+		health.Error = fmt.Sprintf("last successful reconciliation %s ago, interval %s", age.Round(time.Second), cl.config.ReconcileInterval)
+	}
+	return health, true
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	cl := testLayerWithDocker(t,
+		managedContainer("aaaaaaaaaaaa0000", "web", "web.loc", "172.0.0.5"),
+		managedContainer("bbbbbbbbbbbb0000", "api", "api.loc", "172.0.0.6"),
+		managedContainer("cccccccccccc0000", "docs", "docs.loc", "172.0.0.7"),
+	)
+	ctx := context.Background()
+	dir := cl.config.TraefikDynamicDir
+
+	if report, err := cl.reconcile(ctx, true); err != nil || len(report.Drift) != 3 || !report.Repaired {
+		t.Fatalf("initial reconcile() = %+v, %v, want 3 missing configs repaired", report, err)
+	}
+
+	// Edit one file by hand, delete another and leave a config behind for a
+	// container that is gone; files the layer does not own are ignored
+	writeFile := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), ConfigFilePermissions); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("aaaaaaaaaaaa.yaml", "http: {}\n")
+	if err := os.Remove(filepath.Join(dir, "bbbbbbbbbbbb.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile("dddddddddddd.yaml", "http: {}\n")
+	writeFile("middlewares.yaml", "http: {}\n")
+
+	report, err := cl.reconcile(ctx, false)
+	if err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+	want := []DriftEntry{
+		{ContainerID: "aaaaaaaaaaaa", ContainerName: "web", ConfigFile: "aaaaaaaaaaaa.yaml", Drift: driftModified},
+		{ContainerID: "bbbbbbbbbbbb", ContainerName: "api", ConfigFile: "bbbbbbbbbbbb.yaml", Drift: driftMissing},
+		{ContainerID: "dddddddddddd", ConfigFile: "dddddddddddd.yaml", Drift: driftOrphaned},
+	}
+	if report.Checked != 3 || report.Repaired || !reflect.DeepEqual(report.Drift, want) {
+		t.Errorf("reconcile() = %+v, want drift %+v", report, want)
+	}
+	if got := cl.drift.Value(driftModified); got != 1 {
+		t.Errorf("drift gauge = %v, want 1", got)
+	}
+
+	// A check leaves the files alone
+	if _, err := os.Stat(filepath.Join(dir, "dddddddddddd.yaml")); err != nil {
+		t.Errorf("check removed the orphaned config: %v", err)
+	}
+
+	if report, err := cl.reconcile(ctx, true); err != nil || !report.Repaired {
+		t.Fatalf("reconcile() = %+v, %v, want a repair", report, err)
+	}
+	if report, err := cl.reconcile(ctx, false); err != nil || len(report.Drift) != 0 {
+		t.Errorf("reconcile() after repair = %+v, %v, want no drift", report, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "middlewares.yaml")); err != nil {
+		t.Errorf("unmanaged file removed: %v", err)
+	}
+}
+
+func TestHandleInitialScanRemovesOrphanedConfigs(t *testing.T) {
+	cl := testLayerWithDocker(t, managedContainer("aaaaaaaaaaaa0000", "web", "web.loc", "172.0.0.5"))
+	dir := cl.config.TraefikDynamicDir
+
+	// Configs of containers that stopped while the layer was down
+	for _, name := range []string{"dddddddddddd.yaml", "eeeeeeeeeeee.yaml", "middlewares.yaml"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("http: {}\n"), ConfigFilePermissions); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cl.HandleInitialScan(context.Background()); err != nil {
+		t.Fatalf("HandleInitialScan() error = %v", err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"aaaaaaaaaaaa.yaml", "middlewares.yaml"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("files after the scan = %v, want %v", names, want)
+	}
+}
+
+func TestHandleReconcile(t *testing.T) {
+	cl := testLayerWithDocker(t, managedContainer("aaaaaaaaaaaa0000", "web", "web.loc", "172.0.0.5"))
+
+	rec := httptest.NewRecorder()
+	cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reconcile?check=true", nil))
+
+	var report ReconcileReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if rec.Code != http.StatusOK || len(report.Drift) != 1 || report.Drift[0].Drift != driftMissing || report.Repaired {
+		t.Errorf("unexpected response %d: %+v", rec.Code, report)
+	}
+	if _, err := os.Stat(filepath.Join(cl.config.TraefikDynamicDir, "aaaaaaaaaaaa.yaml")); !os.IsNotExist(err) {
+		t.Errorf("check wrote the missing config: %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultRedirectsDir holds the catalog of retired hostnames
+const DefaultRedirectsDir = "/traefik/redirects"
+
+const (
+	// redirectsFileName is the dynamic config file holding the redirect
+	// routers; the reconciler's container file pattern never matches it
+	redirectsFileName = "redirects.yaml"
+
+	// redirectsPollInterval is how often the catalog is checked for changes
+	redirectsPollInterval = 5 * time.Second
+)
+
+// hostRedirect sends a retired hostname to its replacement: a hostname,
+// keeping the scheme and path of the request, or an http(s) URL the request
+// path is appended to. File is the catalog file it comes from.
+type hostRedirect struct {
+	Hostname string `json:"hostname"`
+	Target   string `json:"target"`
+	File     string `json:"file"`
+}
+
+// validate checks the retired hostname and the target.
+func (r hostRedirect) validate() error {
+	if !staticHostnamePattern.MatchString(r.Hostname) {
+		return fmt.Errorf("invalid hostname %q", r.Hostname)
+	}
+	if !strings.Contains(r.Target, "://") {
+		if !staticHostnamePattern.MatchString(r.Target) {
+			return fmt.Errorf("invalid target %q for %s: want a hostname or an http(s) URL", r.Target, r.Hostname)
+		}
+		if strings.EqualFold(r.Target, r.Hostname) {
+			return fmt.Errorf("%s redirects to itself", r.Hostname)
+		}
+		return nil
+	}
+	target, err := url.Parse(r.Target)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("invalid target %q for %s: want a hostname or an http(s) URL", r.Target, r.Hostname)
+	}
+	return nil
+}
+
+// middleware returns the permanent redirect of the retired hostname.
+func (r hostRedirect) middleware() *config.Middleware {
+	if !strings.Contains(r.Target, "://") {
+		return &config.Middleware{RedirectRegex: &config.RedirectRegexMiddleware{
+			Regex:       `^(https?)://[^/]+(.*)$`,
+			Replacement: "${1}://" + r.Target + "${2}",
+			Permanent:   true,
+		}}
+	}
+	return &config.Middleware{RedirectRegex: &config.RedirectRegexMiddleware{
+		Regex:       `^https?://[^/]+/?(.*)$`,
+		Replacement: strings.TrimSuffix(r.Target, "/") + "/${1}",
+		Permanent:   true,
+	}}
+}
+
+// loadRedirectCatalog reads the *.yaml and *.yml files of dir, each mapping
+// retired hostnames to their replacements, sorted by hostname. A hostname
+// listed in several files keeps the target of the first file by name.
+// Unreadable files and invalid entries are skipped and reported as problems.
+func loadRedirectCatalog(dir string) ([]hostRedirect, []string) {
+	var paths []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+
+	var problems []string
+	byHost := make(map[string]hostRedirect)
+	for _, path := range paths {
+		file := filepath.Base(path)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", file, err))
+			continue
+		}
+		var entries map[string]string
+		if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&entries); err != nil && !errors.Is(err, io.EOF) {
+			problems = append(problems, fmt.Sprintf("%s: %v", file, err))
+			continue
+		}
+		for hostname, target := range entries {
+			r := hostRedirect{Hostname: strings.ToLower(strings.TrimSpace(hostname)), Target: strings.TrimSpace(target), File: file}
+			if err := r.validate(); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", file, err))
+				continue
+			}
+			if previous, ok := byHost[r.Hostname]; ok {
+				if previous.File != file {
+					problems = append(problems, fmt.Sprintf("%s: %s is already redirected by %s", file, r.Hostname, previous.File))
+				}
+				continue
+			}
+			byHost[r.Hostname] = r
+		}
+	}
+
+	redirects := make([]hostRedirect, 0, len(byHost))
+	for _, r := range byHost {
+		redirects = append(redirects, r)
+	}
+	sort.Slice(redirects, func(i, j int) bool { return redirects[i].Hostname < redirects[j].Hostname })
+	sort.Strings(problems)
+	return redirects, problems
+}
+
+// redirectsConfig generates an HTTP and an HTTPS router for each retired
+// hostname, answering with a permanent redirect. The routers have the lowest
+// priority, so a container still serving a retired hostname keeps it.
+func redirectsConfig(redirects []hostRedirect) *config.TraefikConfig {
+	cfg := config.NewTraefikConfig()
+	used := make(map[string]bool)
+	for _, r := range redirects {
+		name := uniqueName(used, "redirect-"+generateServiceName(r.Hostname))
+		cfg.HTTP.Middlewares[name] = r.middleware()
+		cfg.HTTP.Routers[name] = &config.Router{
+			Rule:        hostRule(r.Hostname),
+			Service:     "noop@internal",
+			EntryPoints: []string{"http"},
+			Middlewares: []string{name},
+			Priority:    1,
+		}
+		cfg.HTTP.Routers[name+"-tls"] = &config.Router{
+			Rule:        hostRule(r.Hostname),
+			Service:     "noop@internal",
+			EntryPoints: []string{"https"},
+			Middlewares: []string{name},
+			Priority:    1,
+			TLS:         &config.RouterTLSConfig{},
+		}
+	}
+	return cfg
+}
+
+// applyRedirects reads the redirect catalog and, when it changed, rewrites
+// the redirect routers, removing their file when the catalog is empty.
+// Problems are logged when they change. It runs with cl.mu held.
+func (cl *CompatibilityLayer) applyRedirects() error {
+	if cl.config.RedirectsDir == "" {
+		return nil
+	}
+	redirects, problems := loadRedirectCatalog(cl.config.RedirectsDir)
+	if !slices.Equal(problems, cl.redirectProblems) {
+		for _, problem := range problems {
+			cl.logger.Warn("Ignoring invalid redirect catalog entry", "dir", cl.config.RedirectsDir, "problem", problem)
+		}
+		cl.redirectProblems = problems
+	}
+	if cl.redirectsLoaded && slices.Equal(redirects, cl.redirects) {
+		return nil
+	}
+
+	if err := cl.writeRedirects(redirects); err != nil {
+		return err
+	}
+	cl.redirects = redirects
+	cl.redirectsLoaded = true
+	cl.logger.Info("Applied redirect catalog", "dir", cl.config.RedirectsDir, "redirects", len(redirects))
+	return nil
+}
+
+// writeRedirects writes the redirect routers, or removes their file when
+// there are none.
+func (cl *CompatibilityLayer) writeRedirects(redirects []hostRedirect) error {
+	if cl.config.DryRun {
+		cl.logger.Info("DRY RUN: Would write redirect catalog", "config_file", redirectsFileName, "redirects", len(redirects))
+		return nil
+	}
+	if len(redirects) == 0 {
+		err := os.Remove(filepath.Join(cl.config.TraefikDynamicDir, redirectsFileName))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove redirect catalog config: %w", err)
+		}
+		return nil
+	}
+
+	cfg := redirectsConfig(redirects)
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid redirect catalog config: %w", err)
+	}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal Traefik config: %w", err)
+	}
+	_, err = cl.writeDynamicFile(redirectsFileName, data)
+	return err
+}
+
+// runRedirectsWatcher applies changes of the redirect catalog until ctx is
+// cancelled.
+func (cl *CompatibilityLayer) runRedirectsWatcher(ctx context.Context) error {
+	ticker := time.NewTicker(redirectsPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			cl.mu.Lock()
+			err := cl.applyRedirects()
+			cl.mu.Unlock()
+			if err != nil {
+				cl.logger.Error("Failed to apply redirect catalog", "error", err)
+			}
+		}
+	}
+}
+
+// handleRedirects lists the retired hostnames and where they redirect.
+func (cl *CompatibilityLayer) handleRedirects(w http.ResponseWriter, r *http.Request) {
+	cl.mu.Lock()
+	redirects := append([]hostRedirect{}, cl.redirects...)
+	cl.mu.Unlock()
+	writeJSON(w, http.StatusOK, redirects)
+}
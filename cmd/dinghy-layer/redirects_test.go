@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeCatalog(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadRedirectCatalog(t *testing.T) {
+	dir := t.TempDir()
+	writeCatalog(t, dir, "a-shop.yaml", "old-shop.loc: shop.loc\nLegacy-API.loc: https://api.loc/v1/\n")
+	writeCatalog(t, dir, "b-team.yml", "old-shop.loc: other.loc\nself.loc: self.loc\nbad.loc: ftp://files.loc\n")
+	writeCatalog(t, dir, "broken.yaml", "- not a map\n")
+	writeCatalog(t, dir, "notes.txt", "ignored.loc: shop.loc\n")
+
+	redirects, problems := loadRedirectCatalog(dir)
+
+	want := []hostRedirect{
+		{Hostname: "legacy-api.loc", Target: "https://api.loc/v1/", File: "a-shop.yaml"},
+		{Hostname: "old-shop.loc", Target: "shop.loc", File: "a-shop.yaml"},
+	}
+	if !reflect.DeepEqual(redirects, want) {
+		t.Errorf("redirects = %+v, want %+v", redirects, want)
+	}
+	if len(problems) != 4 {
+		t.Errorf("problems = %q, want the duplicate, the self redirect, the ftp target and the broken file", problems)
+	}
+}
+
+func TestRedirectsConfig(t *testing.T) {
+	cfg := redirectsConfig([]hostRedirect{
+		{Hostname: "old-shop.loc", Target: "shop.loc"},
+		{Hostname: "legacy.loc", Target: "https://api.loc/v1/"},
+	})
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		replacement string
+	}{
+		{"redirect-old-shop-loc", "${1}://shop.loc${2}"},
+		{"redirect-legacy-loc", "https://api.loc/v1/${1}"},
+	}
+	for _, tt := range tests {
+		middleware := cfg.HTTP.Middlewares[tt.name]
+		if middleware == nil || middleware.RedirectRegex == nil {
+			t.Fatalf("middleware %s missing", tt.name)
+		}
+		if got := middleware.RedirectRegex; got.Replacement != tt.replacement || !got.Permanent {
+			t.Errorf("%s redirect = %+v, want permanent to %s", tt.name, got, tt.replacement)
+		}
+		for _, router := range []string{tt.name, tt.name + "-tls"} {
+			if r := cfg.HTTP.Routers[router]; r == nil || r.Priority != 1 || r.Middlewares[0] != tt.name {
+				t.Errorf("router %s = %+v", router, r)
+			}
+		}
+	}
+	if cfg.HTTP.Routers["redirect-old-shop-loc-tls"].TLS == nil {
+		t.Error("HTTPS router without TLS")
+	}
+}
+
+func TestRedirectsConfigDistinctNames(t *testing.T) {
+	cfg := redirectsConfig([]hostRedirect{
+		{Hostname: "a-b.loc", Target: "one.loc"},
+		{Hostname: "a.b.loc", Target: "two.loc"},
+	})
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if len(cfg.HTTP.Middlewares) != 2 || len(cfg.HTTP.Routers) != 4 {
+		t.Fatalf("middlewares = %d, routers = %d, want 2 and 4", len(cfg.HTTP.Middlewares), len(cfg.HTTP.Routers))
+	}
+	if got := cfg.HTTP.Routers["redirect-a-b-loc-2"]; got == nil || got.Rule != hostRule("a.b.loc") {
+		t.Errorf("second router = %+v", got)
+	}
+}
+
+func TestApplyRedirects(t *testing.T) {
+	cl := testLayerWithDocker(t)
+	cl.config.RedirectsDir = t.TempDir()
+	configFile := filepath.Join(cl.config.TraefikDynamicDir, redirectsFileName)
+
+	writeCatalog(t, cl.config.RedirectsDir, "shop.yaml", "old-shop.loc: shop.loc\n")
+	if err := cl.applyRedirects(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(configFile); err != nil {
+		t.Fatalf("redirect config not written: %v", err)
+	}
+
+	// An unchanged catalog is not written again
+	if err := os.Remove(configFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.applyRedirects(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(configFile); !os.IsNotExist(err) {
+		t.Errorf("unchanged catalog rewritten: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/redirects", nil))
+	var listed []hostRedirect
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].Hostname != "old-shop.loc" || listed[0].Target != "shop.loc" {
+		t.Errorf("GET /redirects = %+v", listed)
+	}
+
+	// An emptied catalog removes the file
+	writeCatalog(t, cl.config.RedirectsDir, "shop.yaml", "other.loc: shop.loc\n")
+	if err := cl.applyRedirects(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(cl.config.RedirectsDir, "shop.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.applyRedirects(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(configFile); !os.IsNotExist(err) {
+		t.Errorf("redirect config kept for an empty catalog: %v", err)
+	}
+}
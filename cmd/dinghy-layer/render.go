@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// containerRender is a container's generated config and routes, computed in
+// memory. Loading and rendering it writes no file, leaves the route inventory
+// alone and probes no port; processContainer applies it.
+type containerRender struct {
+	inspect types.ContainerJSON
+	info    ContainerInfo
+
+	// labelled containers are routed by Traefik's Docker provider; only
+	// their label routes are imported
+	labelled bool
+
+	// leaderID is set for a replica routed through its leader's config,
+	// which is rendered instead of its own
+	leaderID string
+
+	// replicas are the other replicas merged into the leader's config;
+	// replicaSet identifies all of them, the leader included
+	replicas   []types.Container
+	replicaSet string
+
+	// traefik is the generated config, nil when rendered from a template;
+	// config is the file content, headed by the route metadata
+	traefik *config.TraefikConfig
+	config  []byte
+	routes  ContainerRoutes
+}
+
+// loadContainer inspects a container and applies the rules deciding whether
+// the layer routes it. It returns nil for containers it leaves alone.
+func (cl *CompatibilityLayer) loadContainer(ctx context.Context, containerID string) (*containerRender, error) {
+	if cl.shuttingDown {
+		cl.logger.Debug("Skipping container during stack shutdown",
+			"container_id", utils.FormatDockerID(containerID))
+		return nil, nil
+	}
+
+	inspect, err := utils.RetryContainerInspect(ctx, cl.dockerClient, cl.dockerTimeout, containerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container %s: %w", containerID, err)
+	}
+
+	// Extract container information
+	containerInfo := cl.extractContainerInfo(inspect)
+
+	// Skip if container is not running
+	if !containerInfo.IsRunning {
+		cl.logger.Debug("Skipping non-running container",
+			"container_id", utils.FormatDockerID(containerID),
+			"container_name", containerInfo.Name)
+		return nil, nil
+	}
+
+	// Native labels take precedence: Traefik's Docker provider routes those
+	// containers directly, so their rules are only imported into the inventory
+	labelled := utils.HasTraefikLabel(inspect.Config.Labels)
+
+	// Skip if no VIRTUAL_HOST found; TCP and UDP services may have none
+	streamOnly := containerInfo.VirtualHost == "" && (containerInfo.VirtualTCPPort != "" || containerInfo.VirtualUDPPort != "")
+	if containerInfo.VirtualHost == "" && !labelled && (!streamOnly || cl.template != nil) {
+		cl.logger.Debug("Skipping container without VIRTUAL_HOST",
+			"container_id", utils.FormatDockerID(containerID),
+			"container_name", containerInfo.Name)
+		return nil, nil
+	}
+
+	// Containers sharing another container's network namespace have no
+	// endpoints of their own; they are reached at the owner's addresses
+	if parent := utils.NetworkNamespaceParent(inspect.HostConfig); parent != "" {
+		owner, err := utils.ResolveNetworkNamespaceOwner(ctx, cl.dockerClient, cl.dockerTimeout, inspect)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve network namespace of container %s: %w", utils.FormatDockerID(containerID), err)
+		}
+		if owner.NetworkSettings != nil {
+			if inspect.NetworkSettings == nil {
+				inspect.NetworkSettings = &types.NetworkSettings{}
+			}
+			inspect.NetworkSettings.Networks = owner.NetworkSettings.Networks
+		}
+		cl.logger.Debug("Using network namespace owner's addresses",
+			"container_id", utils.FormatDockerID(containerID),
+			"owner_id", utils.FormatDockerID(owner.ID))
+	}
+
+	if labelled {
+		return &containerRender{inspect: inspect, info: containerInfo, labelled: true}, nil
+	}
+
+	// Leftover VIRTUAL_HOST variables of containers not opted in are ignored;
+	// reconciliation removes configs written before they opted out
+	if !cl.selected(containerInfo) {
+		cl.logger.Debug("Skipping container not opted in",
+			"container_id", utils.FormatDockerID(containerID),
+			"container_name", containerInfo.Name,
+			"mode", cl.config.SelectionMode)
+		return nil, nil
+	}
+
+	// Projects paused through the admin API keep no generated routes
+	if cl.isPaused(inspect.Config.Labels) {
+		cl.logger.Debug("Skipping container of a paused project",
+			"container_id", utils.FormatDockerID(containerID),
+			"container_name", containerInfo.Name)
+		return nil, nil
+	}
+
+	return &containerRender{inspect: inspect, info: containerInfo}, nil
+}
+
+// renderContainer generates the config and routes of a loaded container.
+func (cl *CompatibilityLayer) renderContainer(ctx context.Context, render *containerRender) error {
+	inspect, containerInfo := render.inspect, render.info
+
+	// A user template replaces the built-in generator entirely
+	if cl.template != nil {
+		return cl.renderTemplate(render)
+	}
+
+	// Replicas of a compose service are routed through the config of the first
+	replicas, err := cl.replicas(ctx, inspect.Config.Labels)
+	if err != nil {
+		cl.logger.Warn("Routing container without its replicas",
+			"container_id", utils.FormatDockerID(inspect.ID),
+			"error", err)
+	}
+	if len(replicas) > 1 {
+		render.replicaSet = replicaSetKey(replicas)
+	}
+	if len(replicas) > 1 && replicas[0].ID != inspect.ID {
+		render.leaderID = replicas[0].ID
+		return nil
+	}
+
+	// Generate Traefik configuration
+	serviceName := containerServiceName(inspect)
+	traefikConfig := cl.generateTraefikConfig(inspect, containerInfo)
+	if len(replicas) > 1 {
+		render.replicas = replicas[1:]
+		if err := cl.mergeReplicas(ctx, traefikConfig, serviceName, render.replicas); err != nil {
+			return err
+		}
+	}
+
+	if err := traefikConfig.Validate(); err != nil {
+		return fmt.Errorf("refusing to write invalid Traefik config for container %s: %w", utils.FormatDockerID(inspect.ID), err)
+	}
+	configData, err := yaml.Marshal(traefikConfig)
+	if err != nil {
+		return fmt.Errorf("failed to marshal Traefik config: %w", err)
+	}
+	render.traefik = traefikConfig
+	render.config = append(metadataHeader(containerInfo.Metadata), configData...)
+
+	if svc, ok := traefikConfig.HTTP.Services[serviceName]; ok && len(svc.LoadBalancer.Servers) > 0 {
+		render.routes = cl.routeClaim(containerInfo, serviceName)
+		render.routes.BackendURL = svc.LoadBalancer.Servers[0].URL
+	} else if svc := passthroughService(traefikConfig, serviceName); svc != nil {
+		render.routes = cl.routeClaim(containerInfo, serviceName)
+		render.routes.BackendURL = "https://" + svc.LoadBalancer.Servers[0].Address
+	}
+	return nil
+}
+
+// renderTemplate renders the container's route model through the
+// user-provided config template.
+func (cl *CompatibilityLayer) renderTemplate(render *containerRender) error {
+	inspect, containerInfo := render.inspect, render.info
+
+	data, err := newTemplateData(inspect, containerInfo, cl.containerIP(inspect), cl.routes.serviceNames(inspect.ID))
+	if err != nil {
+		return fmt.Errorf("failed to build template data for container %s: %w", utils.FormatDockerID(inspect.ID), err)
+	}
+
+	configData, err := renderConfigTemplate(cl.template, data)
+	if err != nil {
+		return fmt.Errorf("failed to render config for container %s: %w", utils.FormatDockerID(inspect.ID), err)
+	}
+
+	if override := cl.overrideFor(containerInfo); override != nil {
+		if configData, err = overrideRendered(configData, override); err != nil {
+			return fmt.Errorf("failed to apply override for container %s: %w", utils.FormatDockerID(inspect.ID), err)
+		}
+	}
+
+	render.config = append(metadataHeader(containerInfo.Metadata), configData...)
+	render.routes = cl.routeClaim(containerInfo, data.ServiceName)
+	render.routes.BackendURL = data.ServerURL
+	return nil
+}
+
+// planContainer renders the config a container would get, for reconciliation,
+// GET /plan and batches. Unlike processContainer it has no side effects: ports
+// are taken from earlier probes only. It returns nil for containers without a
+// config of their own: unrouted, labelled and replicas other than the leader.
+func (cl *CompatibilityLayer) planContainer(ctx context.Context, containerID string) (*containerRender, error) {
+	render, err := cl.loadContainer(ctx, containerID)
+	if err != nil || render == nil || render.labelled {
+		return nil, err
+	}
+
+	if port, ok := cl.probedPort(render.inspect, render.info); ok {
+		render.info.VirtualPort = port
+	}
+
+	if err := cl.renderContainer(ctx, render); err != nil {
+		return nil, err
+	}
+	if render.leaderID != "" {
+		return nil, nil
+	}
+	return render, nil
+}
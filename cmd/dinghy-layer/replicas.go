@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// replicas returns the running replicas of the compose service a container
+// belongs to, the leader first: the lowest container number, then the lowest
+// ID. It returns nil when merging is disabled or the container is not part
+// of a compose service.
+func (cl *CompatibilityLayer) replicas(ctx context.Context, labels map[string]string) ([]types.Container, error) {
+	ref := utils.ParseComposeLabels(labels)
+	if !cl.config.MergeReplicas || !ref.IsService() {
+		return nil, nil
+	}
+
+	list, err := utils.RetryContainerList(ctx, cl.dockerClient, cl.dockerTimeout, container.ListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("label", utils.ComposeProjectLabel+"="+ref.Project),
+			filters.Arg("label", utils.ComposeServiceLabel+"="+ref.Service),
+		),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list replicas of %s: %w", ref, err)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		ni, nj := utils.ComposeContainerNumber(list[i].Labels), utils.ComposeContainerNumber(list[j].Labels)
+		if ni != nj {
+			return ni < nj
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// replicaSetKey identifies the running replicas of a compose service and
+// their addresses, in order.
+func replicaSetKey(replicas []types.Container) string {
+	parts := make([]string, 0, len(replicas))
+	for _, replica := range replicas {
+		var ips []string
+		if replica.NetworkSettings != nil {
+			for _, endpoint := range replica.NetworkSettings.Networks {
+				if endpoint != nil && endpoint.IPAddress != "" {
+					ips = append(ips, endpoint.IPAddress)
+				}
+			}
+		}
+		sort.Strings(ips)
+		parts = append(parts, replica.ID+"="+strings.Join(ips, ","))
+	}
+	return strings.Join(parts, ";")
+}
+
+// recordReplicaSet remembers the replicas a leader's config was written with.
+func (cl *CompatibilityLayer) recordReplicaSet(leaderID, replicaSet string) {
+	if replicaSet == "" {
+		delete(cl.replicaSets, leaderID)
+		return
+	}
+	if cl.replicaSets == nil {
+		cl.replicaSets = make(map[string]string)
+	}
+	cl.replicaSets[leaderID] = replicaSet
+}
+
+// deferToReplicaLeader routes a replica through the config of its leader:
+// its own config is removed and the leader's regenerated with it, unless the
+// leader's config already has the same replicas.
+func (cl *CompatibilityLayer) deferToReplicaLeader(ctx context.Context, containerID, leaderID, replicaSet string) error {
+	cl.logger.Debug("Routing replica through the config of the first one",
+		"container_id", utils.FormatDockerID(containerID),
+		"leader_id", utils.FormatDockerID(leaderID))
+
+	if err := cl.removeTraefikConfig(containerID); err != nil {
+		return err
+	}
+	if cl.replicaSets[leaderID] == replicaSet {
+		cl.logger.Debug("Replica set unchanged, keeping the leader's config",
+			"leader_id", utils.FormatDockerID(leaderID))
+		return nil
+	}
+	return cl.processContainer(ctx, leaderID)
+}
+
+// refreshReplicas regenerates the config of the replicas of a compose
+// service after one of them stopped, so its server is dropped or, when it
+// was the leader, the next replica takes over.
+func (cl *CompatibilityLayer) refreshReplicas(ctx context.Context, labels map[string]string) error {
+	replicas, err := cl.replicas(ctx, labels)
+	if err != nil || len(replicas) == 0 {
+		return err
+	}
+	return cl.processContainer(ctx, replicas[0].ID)
+}
+
+// mergeReplicas adds the other replicas of a compose service as servers of
+// the leader's services, on the same ports.
+func (cl *CompatibilityLayer) mergeReplicas(ctx context.Context, traefikConfig *config.TraefikConfig, serviceName string, others []types.Container) error {
+	for _, replica := range others {
+		inspect, err := utils.RetryContainerInspect(ctx, cl.dockerClient, cl.dockerTimeout, replica.ID)
+		if err != nil {
+			return fmt.Errorf("failed to inspect replica %s: %w", utils.FormatDockerID(replica.ID), err)
+		}
+		ip := cl.containerIP(inspect)
+		if ip == "" {
+			cl.logger.Warn("Skipping replica without an IP address",
+				"container_id", utils.FormatDockerID(replica.ID))
+			continue
+		}
+		addReplicaServers(traefikConfig, serviceName, ip)
+	}
+
+	cl.logger.Info("Merged replicas into one service",
+		"service", serviceName,
+		"replicas", len(others)+1)
+	return nil
+}
+
+// addReplicaServers adds a server at ip for each of the container's
+// services, copying the scheme and port of its first server.
+func addReplicaServers(traefikConfig *config.TraefikConfig, serviceName, ip string) {
+	for name, svc := range traefikConfig.HTTP.Services {
+		if !isContainerService(name, serviceName) || svc.LoadBalancer == nil || len(svc.LoadBalancer.Servers) == 0 {
+			continue
+		}
+		server, err := url.Parse(svc.LoadBalancer.Servers[0].URL)
+		if err != nil {
+			continue
+		}
+		server.Host = net.JoinHostPort(ip, server.Port())
+		svc.LoadBalancer.Servers = append(svc.LoadBalancer.Servers, config.Server{URL: server.String()})
+	}
+
+	if traefikConfig.TCP != nil {
+		for name, svc := range traefikConfig.TCP.Services {
+			if strings.HasPrefix(name, serviceName+"-") && svc.LoadBalancer != nil && len(svc.LoadBalancer.Servers) > 0 {
+				svc.LoadBalancer.Servers = append(svc.LoadBalancer.Servers, config.TCPServer{Address: replicaAddress(svc.LoadBalancer.Servers[0].Address, ip)})
+			}
+		}
+	}
+	if traefikConfig.UDP != nil {
+		for name, svc := range traefikConfig.UDP.Services {
+			if strings.HasPrefix(name, serviceName+"-") && svc.LoadBalancer != nil && len(svc.LoadBalancer.Servers) > 0 {
+				svc.LoadBalancer.Servers = append(svc.LoadBalancer.Servers, config.UDPServer{Address: replicaAddress(svc.LoadBalancer.Servers[0].Address, ip)})
+			}
+		}
+	}
+}
+
+// replicaAddress returns address with its host replaced by ip.
+func replicaAddress(address, ip string) string {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return net.JoinHostPort(ip, port)
+}
+
+// addStickyCookie pins the clients of a container's HTTP services to one
+// server with the named cookie, when set.
+func addStickyCookie(traefikConfig *config.TraefikConfig, serviceName, cookie string) {
+	cookie = strings.TrimSpace(cookie)
+	if cookie == "" {
+		return
+	}
+	for name, svc := range traefikConfig.HTTP.Services {
+		if isContainerService(name, serviceName) && svc.LoadBalancer != nil {
+			svc.LoadBalancer.Sticky = &config.Sticky{Cookie: &config.StickyCookie{Name: cookie, HTTPOnly: true}}
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/sparkfabrik/http-proxy/pkg/service"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// replicaContainer returns a running replica of the shop project's web service.
+func replicaContainer(id, number, ip string) types.ContainerJSON {
+	inspect := managedContainer(id, "shop-web-"+number, "shop.loc", ip)
+	inspect.Config.Env = append(inspect.Config.Env, "VIRTUAL_PORT=3000")
+	inspect.Config.Labels = map[string]string{
+		service.ComposeProjectLabel:       "shop",
+		service.ComposeServiceLabel:       "web",
+		utils.ComposeContainerNumberLabel: number,
+	}
+	return inspect
+}
+
+// readServers returns the server URLs of a service in a written config file.
+func readServers(t *testing.T, path, serviceName string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	var cfg config.TraefikConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+	svc, ok := cfg.HTTP.Services[serviceName]
+	if !ok {
+		t.Fatalf("service %s not found in %s", serviceName, path)
+	}
+	var urls []string
+	for _, server := range svc.LoadBalancer.Servers {
+		urls = append(urls, server.URL)
+	}
+	return urls
+}
+
+func TestProcessContainerMergesReplicas(t *testing.T) {
+	cl := testLayerWithDocker(t,
+		replicaContainer("bbbbbbbbbbbb0000", "2", "172.0.0.72"),
+		replicaContainer("aaaaaaaaaaaa0000", "1", "172.0.0.71"),
+		replicaContainer("cccccccccccc0000", "3", "172.0.0.73"))
+	cl.config.MergeReplicas = true
+	dir := cl.config.TraefikDynamicDir
+
+	// A config written before the replicas were merged
+	if err := os.WriteFile(filepath.Join(dir, "cccccccccccc.yaml"), []byte("http: {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"cccccccccccc0000", "aaaaaaaaaaaa0000", "bbbbbbbbbbbb0000"} {
+		if err := cl.processContainer(context.Background(), id); err != nil {
+			t.Fatalf("processContainer(%s) error = %v", id, err)
+		}
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "aaaaaaaaaaaa.yaml" {
+		t.Fatalf("config files = %v, want only the leader's", entries)
+	}
+	want := []string{"http://172.0.0.71:3000", "http://172.0.0.72:3000", "http://172.0.0.73:3000"}
+	if got := readServers(t, filepath.Join(dir, "aaaaaaaaaaaa.yaml"), "shop-web-1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("servers = %v, want %v", got, want)
+	}
+	if routes := cl.routes.list(); len(routes) != 1 || routes[0].ContainerName != "shop-web-1" {
+		t.Errorf("inventory = %+v, want the leader only", routes)
+	}
+}
+
+func TestProcessContainerKeepsLeaderWhenReplicasUnchanged(t *testing.T) {
+	cl := testLayerWithDocker(t,
+		replicaContainer("aaaaaaaaaaaa0000", "1", "172.0.0.71"),
+		replicaContainer("bbbbbbbbbbbb0000", "2", "172.0.0.72"))
+	cl.config.MergeReplicas = true
+	leaderFile := filepath.Join(cl.config.TraefikDynamicDir, "aaaaaaaaaaaa.yaml")
+
+	if err := cl.processContainer(context.Background(), "bbbbbbbbbbbb0000"); err != nil {
+		t.Fatalf("processContainer() error = %v", err)
+	}
+	if _, err := os.Stat(leaderFile); err != nil {
+		t.Fatalf("leader config not written: %v", err)
+	}
+
+	// Another event of the same replica finds the same replicas running
+	if err := os.WriteFile(leaderFile, []byte("http: {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.processContainer(context.Background(), "bbbbbbbbbbbb0000"); err != nil {
+		t.Fatalf("processContainer() error = %v", err)
+	}
+	if data, _ := os.ReadFile(leaderFile); string(data) != "http: {}\n" {
+		t.Error("leader config regenerated although its replicas did not change")
+	}
+}
+
+func TestReplicaSetKey(t *testing.T) {
+	replica := func(id, ip string) types.Container {
+		return types.Container{ID: id, NetworkSettings: &types.SummaryNetworkSettings{
+			Networks: map[string]*network.EndpointSettings{"shop_default": {IPAddress: ip}},
+		}}
+	}
+	key := replicaSetKey([]types.Container{replica("a", "172.0.0.71"), replica("b", "172.0.0.72")})
+	if key != replicaSetKey([]types.Container{replica("a", "172.0.0.71"), replica("b", "172.0.0.72")}) {
+		t.Error("key differs for the same replicas")
+	}
+	if key == replicaSetKey([]types.Container{replica("a", "172.0.0.71")}) {
+		t.Error("key unchanged when a replica left")
+	}
+	if key == replicaSetKey([]types.Container{replica("a", "172.0.0.71"), replica("b", "172.0.0.99")}) {
+		t.Error("key unchanged when a replica moved to another address")
+	}
+}
+
+func TestProcessContainerReplicasNotMerged(t *testing.T) {
+	cl := testLayerWithDocker(t,
+		replicaContainer("aaaaaaaaaaaa0000", "1", "172.0.0.71"),
+		replicaContainer("bbbbbbbbbbbb0000", "2", "172.0.0.72"))
+
+	for _, id := range []string{"aaaaaaaaaaaa0000", "bbbbbbbbbbbb0000"} {
+		if err := cl.processContainer(context.Background(), id); err != nil {
+			t.Fatalf("processContainer(%s) error = %v", id, err)
+		}
+	}
+	if entries, _ := os.ReadDir(cl.config.TraefikDynamicDir); len(entries) != 2 {
+		t.Errorf("merging disabled: %d config files, want one per replica", len(entries))
+	}
+}
+
+func TestHandleEventDieHandsOverReplicas(t *testing.T) {
+	// The leader died: only replicas 2 and 3 are still running
+	cl := testLayerWithDocker(t,
+		replicaContainer("bbbbbbbbbbbb0000", "2", "172.0.0.72"),
+		replicaContainer("cccccccccccc0000", "3", "172.0.0.73"))
+	cl.config.MergeReplicas = true
+	dir := cl.config.TraefikDynamicDir
+	if err := os.WriteFile(filepath.Join(dir, "aaaaaaaaaaaa.yaml"), []byte("http: {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := cl.HandleEvent(context.Background(), events.Message{
+		Action: events.ActionDie,
+		Actor: events.Actor{ID: "aaaaaaaaaaaa0000", Attributes: map[string]string{
+			"name":                      "shop-web-1",
+			service.ComposeProjectLabel: "shop",
+			service.ComposeServiceLabel: "web",
+		}},
+	})
+	if err != nil {
+		t.Fatalf("HandleEvent() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "aaaaaaaaaaaa.yaml")); !os.IsNotExist(err) {
+		t.Errorf("config of the dead leader was not removed: %v", err)
+	}
+	want := []string{"http://172.0.0.72:3000", "http://172.0.0.73:3000"}
+	if got := readServers(t, filepath.Join(dir, "bbbbbbbbbbbb.yaml"), "shop-web-2"); !reflect.DeepEqual(got, want) {
+		t.Errorf("servers = %v, want %v", got, want)
+	}
+}
+
+func TestAddStickyCookie(t *testing.T) {
+	cl := testLayer()
+	info := ContainerInfo{Name: "web", VirtualHost: "web.loc,admin.web.loc:8080", StickyCookie: "web_replica"}
+	cfg := cl.generateTraefikConfig(inspectWithIP("/web", "172.0.0.74"), info)
+
+	if len(cfg.HTTP.Services) != 2 {
+		t.Fatalf("services = %v, want one per port", cfg.HTTP.Services)
+	}
+	for name, svc := range cfg.HTTP.Services {
+		sticky := svc.LoadBalancer.Sticky
+		if sticky == nil || sticky.Cookie.Name != "web_replica" || !sticky.Cookie.HTTPOnly {
+			t.Errorf("service %s sticky = %+v, want the web_replica cookie", name, sticky)
+		}
+	}
+
+	cfg = cl.generateTraefikConfig(inspectWithIP("/web", "172.0.0.74"), ContainerInfo{Name: "web", VirtualHost: "web.loc"})
+	data, _ := yaml.Marshal(cfg)
+	if strings.Contains(string(data), "sticky") {
+		t.Errorf("sticky sessions without HTTP_PROXY_STICKY_COOKIE:\n%s", data)
+	}
+}
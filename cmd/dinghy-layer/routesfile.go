@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// routesFileName is the routes snapshot written next to the dynamic
+// directory when HTTP_PROXY_ROUTES_FILE is not set, outside of it so that
+// Traefik does not reload on every write.
+const routesFileName = "routes.json"
+
+// TLS modes of a route in the routes snapshot
+const (
+	routeTLSBoth      = "both"       // HTTP and HTTPS are served
+	routeTLSRedirect  = "redirect"   // HTTP redirects to HTTPS
+	routeTLSHTTPSOnly = "https-only" // HTTPS_METHOD=nohttp
+	routeTLSHTTPOnly  = "http-only"  // HTTPS_METHOD=nohttps
+)
+
+// routesSnapshot is the content of the routes file: one entry per hostname
+// and container, for tools that cannot reach Docker or the admin API.
+type routesSnapshot struct {
+	UpdatedAt time.Time        `json:"updated_at"`
+	Routes    []routesFileItem `json:"routes"`
+}
+
+// routesFileItem is a hostname served by a container. TLS is empty when the
+// layer does not generate the routers (Traefik labels).
+type routesFileItem struct {
+	Hostname      string `json:"hostname"`
+	Path          string `json:"path,omitempty"`
+	URL           string `json:"url"`
+	ContainerID   string `json:"container_id"`
+	ContainerName string `json:"container_name"`
+	Project       string `json:"project,omitempty"`
+	BackendURL    string `json:"backend_url"`
+	Source        string `json:"source"`
+	TLS           string `json:"tls,omitempty"`
+}
+
+// routeTLS returns the TLS mode of a container's generated routes from its
+// effective HTTPS_METHOD.
+func routeTLS(httpsMethod string) string {
+	switch httpsMethod {
+	case httpsMethodRedirect:
+		return routeTLSRedirect
+	case httpsMethodNoHTTP:
+		return routeTLSHTTPSOnly
+	case httpsMethodNoHTTPS:
+		return routeTLSHTTPOnly
+	default:
+		return routeTLSBoth
+	}
+}
+
+// routesFileItems flattens the inventory into one item per hostname, sorted
+// by hostname and path. Regex hosts of Traefik labels name no hostname and
+// are left out.
+func routesFileItems(routes []ContainerRoutes) []routesFileItem {
+	items := []routesFileItem{}
+	for _, r := range routes {
+		for _, hostname := range r.Hostnames {
+			if strings.HasPrefix(hostname, "~") {
+				continue
+			}
+			scheme := "https"
+			if r.TLS == routeTLSHTTPOnly {
+				scheme = "http"
+			}
+			items = append(items, routesFileItem{
+				Hostname:      hostname,
+				Path:          r.Path,
+				URL:           scheme + "://" + hostname + r.Path,
+				ContainerID:   utils.FormatDockerID(r.ContainerID),
+				ContainerName: r.ContainerName,
+				Project:       r.Project,
+				BackendURL:    r.BackendURL,
+				Source:        r.Source,
+				TLS:           r.TLS,
+			})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Hostname != items[j].Hostname {
+			return items[i].Hostname < items[j].Hostname
+		}
+		return items[i].Path < items[j].Path
+	})
+	return items
+}
+
+// writeRoutesFile rewrites the routes file when the routes changed since it
+// was last written. Failures are logged: the file is a convenience for
+// tooling and never blocks routing.
+func (cl *CompatibilityLayer) writeRoutesFile() {
+	if cl.config.RoutesFile == "" || cl.config.DryRun {
+		return
+	}
+
+	items := routesFileItems(cl.routes.list())
+	data, err := json.Marshal(items)
+	if err != nil {
+		cl.logger.Warn("Failed to encode routes file", "error", err)
+		return
+	}
+
+	cl.routesFileMu.Lock()
+	defer cl.routesFileMu.Unlock()
+	if cl.routesFileLast != nil && bytes.Equal(data, cl.routesFileLast) {
+		return
+	}
+
+	snapshot := routesSnapshot{UpdatedAt: time.Now().UTC(), Routes: items}
+	if err := writeJSONFile(cl.config.RoutesFile, snapshot); err != nil {
+		cl.logger.Warn("Failed to write routes file", "path", cl.config.RoutesFile, "error", err)
+		return
+	}
+	cl.routesFileLast = data
+	cl.logger.Debug("Wrote routes file", "path", cl.config.RoutesFile, "routes", len(snapshot.Routes))
+}
+
+// writeJSONFile atomically replaces path with v as indented JSON, readable
+// by the host user the directory is shared with.
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", filepath.Base(path), err)
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, ConfigDirPermissions); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
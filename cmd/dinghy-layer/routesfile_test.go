@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRouteTLS(t *testing.T) {
+	tests := map[string]string{
+		"":                    routeTLSBoth,
+		httpsMethodNoRedirect: routeTLSBoth,
+		httpsMethodRedirect:   routeTLSRedirect,
+		httpsMethodNoHTTP:     routeTLSHTTPSOnly,
+		httpsMethodNoHTTPS:    routeTLSHTTPOnly,
+	}
+	for method, want := range tests {
+		if got := routeTLS(method); got != want {
+			t.Errorf("routeTLS(%q) = %q, want %q", method, got, want)
+		}
+	}
+}
+
+func TestRoutesFileItems(t *testing.T) {
+	routes := []ContainerRoutes{
+		{
+			ContainerID:   "0123456789abcdef",
+			ContainerName: "web",
+			Hostnames:     []string{"web.loc", "api.loc"},
+			Path:          "/v1",
+			Project:       "shop",
+			BackendURL:    "http://172.17.0.2:80",
+			Source:        routeSourceVirtualHost,
+			TLS:           routeTLSHTTPOnly,
+		},
+		{
+			ContainerID:   "fedcba9876543210",
+			ContainerName: "admin",
+			Hostnames:     []string{"~^admin\\..*$", "admin.loc"},
+			BackendURL:    "http://172.17.0.3:8080",
+			Source:        routeSourceTraefikLabels,
+		},
+	}
+
+	want := []routesFileItem{
+		{Hostname: "admin.loc", URL: "https://admin.loc", ContainerID: "fedcba987654", ContainerName: "admin", BackendURL: "http://172.17.0.3:8080", Source: routeSourceTraefikLabels},
+		{Hostname: "api.loc", Path: "/v1", URL: "http://api.loc/v1", ContainerID: "0123456789ab", ContainerName: "web", Project: "shop", BackendURL: "http://172.17.0.2:80", Source: routeSourceVirtualHost, TLS: routeTLSHTTPOnly},
+		{Hostname: "web.loc", Path: "/v1", URL: "http://web.loc/v1", ContainerID: "0123456789ab", ContainerName: "web", Project: "shop", BackendURL: "http://172.17.0.2:80", Source: routeSourceVirtualHost, TLS: routeTLSHTTPOnly},
+	}
+	if got := routesFileItems(routes); !reflect.DeepEqual(got, want) {
+		t.Errorf("routesFileItems() = %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteRoutesFile(t *testing.T) {
+	cl := testLayer()
+	cl.config.RoutesFile = filepath.Join(t.TempDir(), "run", routesFileName)
+	cl.routes.set(ContainerRoutes{ContainerID: "a", ContainerName: "web", Hostnames: []string{"web.loc"}, Source: routeSourceVirtualHost, TLS: routeTLSBoth})
+
+	cl.writeRoutesFile()
+	data, err := os.ReadFile(cl.config.RoutesFile)
+	if err != nil {
+		t.Fatalf("routes file not written: %v", err)
+	}
+	var snapshot routesSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatalf("invalid routes file: %v", err)
+	}
+	if len(snapshot.Routes) != 1 || snapshot.Routes[0].Hostname != "web.loc" || snapshot.Routes[0].TLS != routeTLSBoth {
+		t.Errorf("routes = %+v, want web.loc", snapshot.Routes)
+	}
+
+	// Unchanged routes leave the file alone
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(cl.config.RoutesFile, old, old); err != nil {
+		t.Fatal(err)
+	}
+	cl.writeRoutesFile()
+	if info, _ := os.Stat(cl.config.RoutesFile); !info.ModTime().Equal(old) {
+		t.Error("routes file rewritten without changes")
+	}
+
+	cl.routes.remove("a")
+	cl.writeRoutesFile()
+	data, _ = os.ReadFile(cl.config.RoutesFile)
+	snapshot = routesSnapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil || len(snapshot.Routes) != 0 {
+		t.Errorf("routes file = %s, want no routes", data)
+	}
+
+	// Dry runs do not touch the host
+	cl.config.DryRun = true
+	cl.routes.set(ContainerRoutes{ContainerID: "b", Hostnames: []string{"b.loc"}})
+	cl.writeRoutesFile()
+	data, _ = os.ReadFile(cl.config.RoutesFile)
+	if err := json.Unmarshal(data, &snapshot); err != nil || len(snapshot.Routes) != 0 {
+		t.Errorf("routes file = %s, want no routes in dry-run mode", data)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// HTTP_PROXY_MODE values
+const (
+	// selectionAll routes every container with VIRTUAL_HOST unless it opts out
+	selectionAll = "all"
+	// selectionExplicit only routes containers opting in
+	selectionExplicit = "explicit"
+)
+
+// enableLabel opts a container in or out, like its HTTP_PROXY_ENABLE variable
+const enableLabel = "http-proxy.enable"
+
+// parseSelectionMode parses HTTP_PROXY_MODE, all when unset.
+func parseSelectionMode(mode string) (string, error) {
+	switch mode = strings.ToLower(strings.TrimSpace(mode)); mode {
+	case "", selectionAll:
+		return selectionAll, nil
+	case selectionExplicit:
+		return selectionExplicit, nil
+	default:
+		return "", fmt.Errorf("invalid HTTP_PROXY_MODE %q, expected %s or %s", mode, selectionAll, selectionExplicit)
+	}
+}
+
+// enableSetting returns whether a container opts in or out: the
+// HTTP_PROXY_ENABLE variable, else the http-proxy.enable label.
+func enableSetting(env []string, labels map[string]string) string {
+	if enable := utils.GetDockerEnvVar(env, "HTTP_PROXY_ENABLE"); enable != "" {
+		return enable
+	}
+	return labels[enableLabel]
+}
+
+// parseEnable parses an opt-in setting: set reports whether the container
+// chose at all, enabled what it chose.
+func parseEnable(value string) (enabled, set bool, err error) {
+	if strings.TrimSpace(value) == "" {
+		return false, false, nil
+	}
+	enabled, err = parseSwitch("HTTP_PROXY_ENABLE", value)
+	if err != nil {
+		return false, false, err
+	}
+	return enabled, true, nil
+}
+
+// selected reports whether a container's VIRTUAL_HOST is routed: in explicit
+// mode it must opt in, otherwise it must not opt out. An invalid setting is
+// logged and counts as none.
+func (cl *CompatibilityLayer) selected(containerInfo ContainerInfo) bool {
+	enabled, set, err := parseEnable(containerInfo.Enable)
+	if err != nil {
+		cl.logger.Warn("Ignoring invalid opt-in setting",
+			"container_id", utils.FormatDockerID(containerInfo.ID),
+			"error", err)
+	}
+	if cl.config.SelectionMode == selectionExplicit {
+		return set && enabled
+	}
+	return !set || enabled
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSelected(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		enable string
+		want   bool
+	}{
+		{name: "all, unset", mode: selectionAll, want: true},
+		{name: "all, opted out", mode: selectionAll, enable: "false"},
+		{name: "all, opted in", mode: selectionAll, enable: "true", want: true},
+		{name: "all, invalid", mode: selectionAll, enable: "nope", want: true},
+		{name: "explicit, unset", mode: selectionExplicit},
+		{name: "explicit, opted in", mode: selectionExplicit, enable: " TRUE ", want: true},
+		{name: "explicit, opted out", mode: selectionExplicit, enable: "false"},
+		{name: "explicit, invalid", mode: selectionExplicit, enable: "yes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := testLayer()
+			cl.config.SelectionMode = tt.mode
+			if got := cl.selected(ContainerInfo{Enable: tt.enable}); got != tt.want {
+				t.Errorf("selected() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnableSetting(t *testing.T) {
+	labels := map[string]string{enableLabel: "true"}
+	if got := enableSetting(nil, labels); got != "true" {
+		t.Errorf("label setting = %q", got)
+	}
+	if got := enableSetting([]string{"HTTP_PROXY_ENABLE=false"}, labels); got != "false" {
+		t.Errorf("variable setting = %q, want it to win over the label", got)
+	}
+}
+
+func TestProcessContainerSkipsOptedOut(t *testing.T) {
+	const id = "0123456789abcdef0123"
+	inspect := managedContainer(id, "scratch", "scratch.loc", "172.0.0.5")
+	inspect.Config.Env = append(inspect.Config.Env, "HTTP_PROXY_ENABLE=false")
+	cl := testLayerWithDocker(t, inspect)
+
+	if err := cl.processContainer(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cl.routes.get(id); ok {
+		t.Error("opted-out container got routes")
+	}
+
+	inspect.Config.Env = []string{"VIRTUAL_HOST=scratch.loc"}
+	inspect.Config.Labels = map[string]string{enableLabel: "true"}
+	cl = testLayerWithDocker(t, inspect)
+	cl.config.SelectionMode = selectionExplicit
+	if err := cl.processContainer(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cl.routes.get(id); !ok {
+		t.Error("opted-in container has no routes in explicit mode")
+	}
+}
+
+func TestCompatibilityConfigValidateSelectionMode(t *testing.T) {
+	cfg := &CompatibilityConfig{TraefikDynamicDir: "/tmp", LogLevel: "info", SelectionMode: "manual"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() accepted HTTP_PROXY_MODE=manual")
+	}
+
+	cfg.SelectionMode = " Explicit "
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if cfg.SelectionMode != selectionExplicit {
+		t.Errorf("SelectionMode = %q, want %q", cfg.SelectionMode, selectionExplicit)
+	}
+
+	cl := testLayer()
+	cl.config = cfg
+	if cl.selected(ContainerInfo{ID: "abc"}) {
+		t.Error("container without opt-in selected with HTTP_PROXY_MODE=Explicit")
+	}
+}
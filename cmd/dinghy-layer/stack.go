@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// State store snapshots written by the other services of the stack
+const (
+	networksStateName = "join-networks"
+	dnsStateName      = "dns-server"
+)
+
+// containerStatus is one entry of GET /containers: a container's virtual
+// hosts and the routers serving them. Routers are read from the generated
+// config file, so routes imported from Traefik labels, which Traefik reads
+// itself, have none.
+type containerStatus struct {
+	ContainerID   string         `json:"container_id"`
+	ContainerName string         `json:"container_name"`
+	VirtualHosts  []string       `json:"virtual_hosts"`
+	BackendURL    string         `json:"backend_url"`
+	Source        string         `json:"source,omitempty"`
+	Routers       []routerStatus `json:"routers"`
+}
+
+// routerStatus describes a generated Traefik router.
+type routerStatus struct {
+	Name        string   `json:"name"`
+	Rule        string   `json:"rule"`
+	EntryPoints []string `json:"entry_points,omitempty"`
+	Service     string   `json:"service"`
+	Middlewares []string `json:"middlewares,omitempty"`
+	Priority    int      `json:"priority,omitempty"`
+	TLS         bool     `json:"tls"`
+}
+
+// networkRef mirrors a network of the join-networks snapshot.
+type networkRef struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// networksStatus mirrors the latest change recorded by join-networks; the
+// body of GET /networks. Networks are those the proxy is attached to.
+type networksStatus struct {
+	Container  string       `json:"container"`
+	Generation uint64       `json:"generation"`
+	Trigger    string       `json:"trigger"`
+	Timestamp  time.Time    `json:"timestamp"`
+	Networks   []networkRef `json:"networks"`
+}
+
+// dnsDomain mirrors a domain of the dns-server snapshot.
+type dnsDomain struct {
+	Domain     string `json:"domain"`
+	TargetIP   string `json:"target_ip"`
+	TargetIPv6 string `json:"target_ipv6,omitempty"`
+}
+
+// dnsDomainsStatus mirrors the status published by the DNS server; the body
+// of GET /dns/domains.
+type dnsDomainsStatus struct {
+	Domains        []dnsDomain `json:"domains"`
+	Port           string      `json:"port"`
+	ConfiguredPort string      `json:"configured_port"`
+	Fallback       bool        `json:"fallback"`
+	Profile        string      `json:"profile,omitempty"`
+}
+
+// componentHealth is the state of one part of the stack in GET /health:
+// "ok", "error", or "unknown" when it has not reported yet.
+type componentHealth struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// stackHealth is the body of GET /health. Status is "ok" unless a component
+// is in error, then "degraded".
+type stackHealth struct {
+	Status     string            `json:"status"`
+	Components []componentHealth `json:"components"`
+}
+
+// handleContainers lists the managed containers with their virtual hosts and
+// routers.
+func (cl *CompatibilityLayer) handleContainers(w http.ResponseWriter, r *http.Request) {
+	result := []containerStatus{}
+	for _, routes := range cl.routes.list() {
+		result = append(result, containerStatus{
+			ContainerID:   routes.ContainerID,
+			ContainerName: routes.ContainerName,
+			VirtualHosts:  routes.Hostnames,
+			BackendURL:    routes.BackendURL,
+			Source:        routes.Source,
+			Routers:       cl.routers(routes),
+		})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// routers returns the routers of the config file generated for routes,
+// sorted by name, or none when there is no such file.
+func (cl *CompatibilityLayer) routers(routes ContainerRoutes) []routerStatus {
+	result := []routerStatus{}
+
+	var fileName string
+	switch routes.Source {
+	case routeSourceTraefikLabels:
+		return result
+	case routeSourceStatic:
+		fileName = staticFileName(routes.ContainerName)
+	default:
+		fileName = cl.configFileName(routes.ContainerID)
+	}
+
+	cfg, err := config.LoadTraefikConfigFile(filepath.Join(cl.config.TraefikDynamicDir, fileName))
+	if err != nil || cfg.HTTP == nil {
+		return result
+	}
+	for name, router := range cfg.HTTP.Routers {
+		result = append(result, routerStatus{
+			Name:        name,
+			Rule:        router.Rule,
+			EntryPoints: router.EntryPoints,
+			Service:     router.Service,
+			Middlewares: router.Middlewares,
+			Priority:    router.Priority,
+			TLS:         router.TLS != nil,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// handleNetworks reports the networks join-networks attached the proxy to.
+func (cl *CompatibilityLayer) handleNetworks(w http.ResponseWriter, r *http.Request) {
+	var status networksStatus
+	if !cl.readState(w, networksStateName, &status) {
+		return
+	}
+	if status.Networks == nil {
+		status.Networks = []networkRef{}
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handleDNSDomains reports the domains the DNS server answers.
+func (cl *CompatibilityLayer) handleDNSDomains(w http.ResponseWriter, r *http.Request) {
+	var status dnsDomainsStatus
+	if !cl.readState(w, dnsStateName, &status) {
+		return
+	}
+	if status.Domains == nil {
+		status.Domains = []dnsDomain{}
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// readState decodes a snapshot of another service into v, answering 404
+// when it has not been written yet. It reports whether v was read.
+func (cl *CompatibilityLayer) readState(w http.ResponseWriter, name string, v any) bool {
+	if cl.state == nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "state directory not configured"})
+		return false
+	}
+	if err := cl.state.Read(name, v); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: name + " has not reported its state yet"})
+			return false
+		}
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return false
+	}
+	return true
+}
+
+// handleStackHealth checks the layer's Docker connection, whether the other
+// services have reported their state and whether reconciliations still
+// succeed. It answers 503 when a component is in error.
+func (cl *CompatibilityLayer) handleStackHealth(w http.ResponseWriter, r *http.Request) {
+	health := stackHealth{Status: "ok", Components: []componentHealth{{Name: "dinghy-layer", Status: "ok"}}}
+
+	docker := componentHealth{Name: "docker", Status: "ok"}
+	ctx, cancel := utils.WithDockerTimeout(r.Context(), cl.dockerTimeout)
+	defer cancel()
+	if _, err := cl.dockerClient.Ping(ctx); err != nil {
+		docker.Status = "error"
+		docker.Error = err.Error()
+	}
+	health.Components = append(health.Components, docker)
+
+	for _, name := range []string{networksStateName, dnsStateName} {
+		component := componentHealth{Name: name, Status: "ok"}
+		var snapshot map[string]any
+		if cl.state == nil {
+			component.Status = "unknown"
+		} else if err := cl.state.Read(name, &snapshot); errors.Is(err, os.ErrNotExist) {
+			component.Status = "unknown"
+		} else if err != nil {
+			component.Status = "error"
+			component.Error = err.Error()
+		}
+		health.Components = append(health.Components, component)
+	}
+
+	if reconciler, ok := cl.reconcilerHealth(time.Now()); ok {
+		health.Components = append(health.Components, reconciler)
+	}
+
+	status := http.StatusOK
+	for _, component := range health.Components {
+		if component.Status == "error" {
+			health.Status = "degraded"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, health)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sparkfabrik/http-proxy/pkg/state"
+)
+
+func TestHandleContainers(t *testing.T) {
+	const id = "0123456789abcdef0123"
+	cl := testLayerWithDocker(t, managedContainer(id, "web", "web.loc", "172.0.0.5"))
+	if err := cl.processContainer(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	cl.routes.set(ContainerRoutes{ContainerID: "fedcba987654", ContainerName: "labelled", Hostnames: []string{"labelled.loc"}, Source: routeSourceTraefikLabels})
+
+	rec := httptest.NewRecorder()
+	cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/containers", nil))
+
+	var containers []containerStatus
+	if err := json.NewDecoder(rec.Body).Decode(&containers); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET /containers = %d, %v", rec.Code, err)
+	}
+	if len(containers) != 2 {
+		t.Fatalf("containers = %+v, want 2", containers)
+	}
+	if c := containers[0]; c.ContainerName != "labelled" || len(c.Routers) != 0 {
+		t.Errorf("label route = %+v, want no routers", c)
+	}
+	web := containers[1]
+	if web.ContainerID != id || len(web.VirtualHosts) != 1 || web.VirtualHosts[0] != "web.loc" {
+		t.Errorf("container = %+v", web)
+	}
+	if len(web.Routers) != 2 || web.Routers[0].Rule != "Host(`web.loc`)" || web.Routers[0].TLS == web.Routers[1].TLS {
+		t.Errorf("routers = %+v, want an HTTP and an HTTPS router for web.loc", web.Routers)
+	}
+}
+
+func TestHandleStateSnapshots(t *testing.T) {
+	cl := testLayerWithDocker(t)
+	dir := t.TempDir()
+	cl.state = state.NewStore(dir)
+
+	// Nothing reported yet
+	for _, path := range []string{"/networks", "/dns/domains"} {
+		rec := httptest.NewRecorder()
+		cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", path, rec.Code)
+		}
+	}
+
+	cl.state.Write(networksStateName, map[string]any{
+		"container": "http-proxy", "generation": 3,
+		"networks": []map[string]string{{"id": "abc", "name": "shop_default"}},
+	})
+	cl.state.Write(dnsStateName, map[string]any{
+		"port": "19322", "configured_port": "19322",
+		"domains": []map[string]string{{"domain": "loc", "target_ip": "127.0.0.1"}},
+	})
+
+	rec := httptest.NewRecorder()
+	cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/networks", nil))
+	var networks networksStatus
+	json.NewDecoder(rec.Body).Decode(&networks)
+	if rec.Code != http.StatusOK || networks.Generation != 3 || len(networks.Networks) != 1 || networks.Networks[0].Name != "shop_default" {
+		t.Errorf("GET /networks = %d %+v", rec.Code, networks)
+	}
+
+	rec = httptest.NewRecorder()
+	cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dns/domains", nil))
+	var domains dnsDomainsStatus
+	json.NewDecoder(rec.Body).Decode(&domains)
+	if rec.Code != http.StatusOK || domains.Port != "19322" || len(domains.Domains) != 1 || domains.Domains[0].TargetIP != "127.0.0.1" {
+		t.Errorf("GET /dns/domains = %d %+v", rec.Code, domains)
+	}
+}
+
+func TestHandleStackHealth(t *testing.T) {
+	cl := testLayerWithDocker(t)
+	dir := t.TempDir()
+	cl.state = state.NewStore(dir)
+	cl.state.Write(networksStateName, map[string]any{"container": "http-proxy"})
+
+	health := func() (int, map[string]string) {
+		rec := httptest.NewRecorder()
+		cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+		var body stackHealth
+		json.NewDecoder(rec.Body).Decode(&body)
+		statuses := map[string]string{"": body.Status}
+		for _, c := range body.Components {
+			statuses[c.Name] = c.Status
+		}
+		return rec.Code, statuses
+	}
+
+	code, statuses := health()
+	if code != http.StatusOK || statuses[""] != "ok" || statuses["docker"] != "ok" ||
+		statuses[networksStateName] != "ok" || statuses[dnsStateName] != "unknown" {
+		t.Errorf("GET /health = %d %v", code, statuses)
+	}
+
+	// An unreadable snapshot degrades the stack
+	os.WriteFile(filepath.Join(dir, dnsStateName+".json"), []byte("{"), 0644)
+	if code, statuses := health(); code != http.StatusServiceUnavailable || statuses[""] != "degraded" || statuses[dnsStateName] != "error" {
+		t.Errorf("GET /health with a broken snapshot = %d %v", code, statuses)
+	}
+}
+
+func TestReconcilerHealth(t *testing.T) {
+	cl := testLayerWithDocker(t)
+	now := time.Now()
+
+	if _, ok := cl.reconcilerHealth(now); ok {
+		t.Error("reconciler reported with periodic reconciliation off")
+	}
+
+	cl.config.ReconcileInterval = 5 * time.Minute
+	if health, _ := cl.reconcilerHealth(now); health.Status != "unknown" {
+		t.Errorf("before the reconciler started = %+v", health)
+	}
+
+	if _, err := cl.reconcile(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if health, _ := cl.reconcilerHealth(time.Now()); health.Status != "ok" {
+		t.Errorf("after a reconciliation = %+v", health)
+	}
+
+	// Reconciliations failing for longer than the interval degrade the stack
+	if health, _ := cl.reconcilerHealth(time.Now().Add(6 * time.Minute)); health.Status != "error" || health.Error == "" {
+		t.Errorf("past the interval = %+v", health)
+	}
+}
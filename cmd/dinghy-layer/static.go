@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// staticFilePrefix starts the config file names of static routes, which
+	// the reconciler's container file pattern never matches
+	staticFilePrefix = "static-"
+
+	// staticHeader starts the comment line holding a static route's
+	// definition, so routes can be restored from their files at startup
+	staticHeader = "# static-route: "
+
+	// staticIDPrefix marks static routes in the inventory, which is keyed by
+	// container ID
+	staticIDPrefix = "static:"
+)
+
+var (
+	// staticNamePattern matches static route names, used in file and router names
+	staticNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+	// staticHostnamePattern matches the exact hostnames a static route serves
+	staticHostnamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*$`)
+)
+
+// StaticRoute sends hostnames to a backend that is not a managed container,
+// such as a service running on the host or another machine.
+type StaticRoute struct {
+	Name       string   `json:"name"`
+	Hostnames  []string `json:"hostnames"`
+	BackendURL string   `json:"backend_url"`
+}
+
+// validate checks the route's name, hostnames and backend URL.
+func (r StaticRoute) validate() error {
+	if !staticNamePattern.MatchString(r.Name) {
+		return fmt.Errorf("invalid name %q: use lowercase letters, digits and dashes", r.Name)
+	}
+	if len(r.Hostnames) == 0 {
+		return errors.New("at least one hostname is required")
+	}
+	for _, hostname := range r.Hostnames {
+		if !staticHostnamePattern.MatchString(hostname) {
+			return fmt.Errorf("invalid hostname %q", hostname)
+		}
+	}
+	backend, err := url.Parse(r.BackendURL)
+	if err != nil || (backend.Scheme != "http" && backend.Scheme != "https") || backend.Host == "" {
+		return fmt.Errorf("invalid backend URL %q: want http://host:port or https://host:port", r.BackendURL)
+	}
+	return nil
+}
+
+// staticFileName returns the config file name of a static route.
+func staticFileName(name string) string {
+	return staticFilePrefix + name + ".yaml"
+}
+
+// staticConfig generates the HTTP and HTTPS routers of a static route and
+// its service.
+func staticConfig(r StaticRoute) *config.TraefikConfig {
+	cfg := config.NewTraefikConfig()
+	serviceName := staticFilePrefix + r.Name
+
+	for i, hostname := range r.Hostnames {
+		rule := hostRule(hostname)
+		cfg.HTTP.Routers[fmt.Sprintf("%s-%d", serviceName, i)] = &config.Router{
+			Rule:        rule,
+			Service:     serviceName,
+			EntryPoints: []string{"http"},
+		}
+		cfg.HTTP.Routers[fmt.Sprintf("%s-tls-%d", serviceName, i)] = &config.Router{
+			Rule:        rule,
+			Service:     serviceName,
+			EntryPoints: []string{"https"},
+			TLS:         &config.RouterTLSConfig{},
+		}
+	}
+
+	cfg.HTTP.Services[serviceName] = &config.Service{
+		LoadBalancer: &config.LoadBalancer{Servers: []config.Server{{URL: r.BackendURL}}},
+	}
+	setRulePriorities(cfg)
+	return cfg
+}
+
+// setStaticRoute writes a static route's config, replacing any route with the
+// same name, and records it in the inventory.
+func (cl *CompatibilityLayer) setStaticRoute(r StaticRoute) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+
+	definition, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal static route: %w", err)
+	}
+	configData, err := yaml.Marshal(staticConfig(r))
+	if err != nil {
+		return fmt.Errorf("failed to marshal Traefik config: %w", err)
+	}
+	data := append([]byte(staticHeader+string(definition)+"\n"), configData...)
+
+	if err := cl.writeStaticFile(r.Name, data); err != nil {
+		return err
+	}
+	cl.recordStaticRoute(r)
+	cl.logger.Info("Wrote static route",
+		"name", r.Name,
+		"hostnames", strings.Join(r.Hostnames, ","),
+		"backend_url", r.BackendURL)
+	return nil
+}
+
+// removeStaticRoute deletes a static route and reports whether it existed.
+func (cl *CompatibilityLayer) removeStaticRoute(name string) (bool, error) {
+	if !staticNamePattern.MatchString(name) {
+		return false, nil
+	}
+	known := cl.routes.remove(staticIDPrefix + name)
+	if known {
+		cl.routesChanged()
+	}
+	if cl.config.DryRun {
+		cl.logger.Info("DRY RUN: Would remove static route", "name", name)
+		return known, nil
+	}
+
+	err := os.Remove(filepath.Join(cl.config.TraefikDynamicDir, staticFileName(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return known, nil
+	}
+	if err != nil {
+		return known, fmt.Errorf("failed to remove static route config: %w", err)
+	}
+	cl.logger.Info("Removed static route", "name", name)
+	return true, nil
+}
+
+// writeStaticFile writes a static route's config file atomically.
+func (cl *CompatibilityLayer) writeStaticFile(name string, data []byte) error {
+	if cl.config.DryRun {
+		cl.logger.Info("DRY RUN: Would write static route", "config_file", staticFileName(name))
+		return nil
+	}
+
+	_, err := cl.writeDynamicFile(staticFileName(name), data)
+	return err
+}
+
+// recordStaticRoute adds a static route to the inventory.
+func (cl *CompatibilityLayer) recordStaticRoute(r StaticRoute) {
+	cl.routes.set(ContainerRoutes{
+		ContainerID:   staticIDPrefix + r.Name,
+		ContainerName: r.Name,
+		ServiceName:   staticFilePrefix + r.Name,
+		Hostnames:     r.Hostnames,
+		BackendURL:    r.BackendURL,
+		Source:        routeSourceStatic,
+		TLS:           routeTLSBoth,
+	})
+	cl.routesChanged()
+}
+
+// loadStaticRoutes restores the static routes written by a previous run into
+// the inventory. Unreadable files are logged and skipped.
+func (cl *CompatibilityLayer) loadStaticRoutes() {
+	paths, _ := filepath.Glob(filepath.Join(cl.config.TraefikDynamicDir, staticFilePrefix+"*.yaml"))
+	for _, path := range paths {
+		r, err := readStaticRoute(path)
+		if err != nil {
+			cl.logger.Warn("Ignoring unreadable static route", "config_file", path, "error", err)
+			continue
+		}
+		cl.recordStaticRoute(r)
+		cl.logger.Debug("Restored static route", "name", r.Name)
+	}
+}
+
+// readStaticRoute reads the definition heading a static route's config file.
+func readStaticRoute(path string) (StaticRoute, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return StaticRoute{}, err
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	definition, ok := strings.CutPrefix(line, staticHeader)
+	if !ok {
+		return StaticRoute{}, errors.New("missing static route header")
+	}
+
+	var r StaticRoute
+	if err := json.Unmarshal([]byte(definition), &r); err != nil {
+		return StaticRoute{}, fmt.Errorf("invalid static route header: %w", err)
+	}
+	if err := r.validate(); err != nil {
+		return StaticRoute{}, err
+	}
+	return r, nil
+}
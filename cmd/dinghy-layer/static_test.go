@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+)
+
+func TestStaticRouteValidate(t *testing.T) {
+	valid := StaticRoute{Name: "docs", Hostnames: []string{"docs.loc"}, BackendURL: "http://host.docker.internal:3000"}
+	if err := valid.validate(); err != nil {
+		t.Errorf("validate() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(r *StaticRoute)
+	}{
+		{"uppercase name", func(r *StaticRoute) { r.Name = "Docs" }},
+		{"path in name", func(r *StaticRoute) { r.Name = "../docs" }},
+		{"no hostnames", func(r *StaticRoute) { r.Hostnames = nil }},
+		{"wildcard hostname", func(r *StaticRoute) { r.Hostnames = []string{"*.docs.loc"} }},
+		{"rule injection", func(r *StaticRoute) { r.Hostnames = []string{"docs.loc`) || Host(`x"} }},
+		{"no scheme", func(r *StaticRoute) { r.BackendURL = "host.docker.internal:3000" }},
+		{"tcp scheme", func(r *StaticRoute) { r.BackendURL = "tcp://10.0.0.1:3000" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid
+			tt.modify(&r)
+			if err := r.validate(); err == nil {
+				t.Errorf("validate() accepted %+v", r)
+			}
+		})
+	}
+}
+
+func TestStaticRouteLifecycle(t *testing.T) {
+	cl := testLayerWithDocker(t)
+	route := StaticRoute{Name: "docs", Hostnames: []string{"docs.loc", "api.docs.loc"}, BackendURL: "http://192.168.1.20:8080"}
+
+	if err := cl.setStaticRoute(route); err != nil {
+		t.Fatalf("setStaticRoute() error = %v", err)
+	}
+	path := filepath.Join(cl.config.TraefikDynamicDir, "static-docs.yaml")
+	cfg, err := config.LoadTraefikConfigFile(path)
+	if err != nil {
+		t.Fatalf("failed to load generated config: %v", err)
+	}
+	if len(cfg.HTTP.Routers) != 4 || cfg.HTTP.Routers["static-docs-tls-1"].Rule != "Host(`api.docs.loc`)" {
+		t.Errorf("unexpected routers %v", cfg.HTTP.Routers)
+	}
+	if got := cfg.HTTP.Services["static-docs"].LoadBalancer.Servers[0].URL; got != route.BackendURL {
+		t.Errorf("backend = %s, want %s", got, route.BackendURL)
+	}
+
+	// A restarted layer restores the route from its file
+	restarted := testLayerWithDocker(t)
+	restarted.config.TraefikDynamicDir = cl.config.TraefikDynamicDir
+	restarted.loadStaticRoutes()
+	routes, ok := restarted.routes.get("static:docs")
+	if !ok || routes.Source != routeSourceStatic || !reflect.DeepEqual(routes.Hostnames, route.Hostnames) {
+		t.Errorf("restored routes = %+v, %v", routes, ok)
+	}
+
+	if removed, err := restarted.removeStaticRoute("docs"); err != nil || !removed {
+		t.Fatalf("removeStaticRoute() = %v, %v", removed, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("config file still present: %v", err)
+	}
+	if removed, _ := restarted.removeStaticRoute("docs"); removed {
+		t.Error("removeStaticRoute() removed an unknown route")
+	}
+}
+
+func TestHandleStaticRoutes(t *testing.T) {
+	cl := testLayerWithDocker(t)
+	handler := cl.adminHandler()
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := serve(http.MethodPut, "/static-routes/docs", `{"hostnames":["docs.loc"],"backend_url":"http://10.0.0.5:3000"}`); rec.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d, body %s", rec.Code, rec.Body)
+	}
+	if rec := serve(http.MethodGet, "/routes", ""); !strings.Contains(rec.Body.String(), `"source":"static"`) {
+		t.Errorf("GET /routes does not list the static route: %s", rec.Body)
+	}
+	if rec := serve(http.MethodPut, "/static-routes/docs", `{"hostnames":[],"backend_url":"http://10.0.0.5:3000"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT without hostnames status = %d, want 400", rec.Code)
+	}
+	if rec := serve(http.MethodDelete, "/static-routes/docs", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE status = %d, want 204", rec.Code)
+	}
+	if rec := serve(http.MethodDelete, "/static-routes/docs", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("second DELETE status = %d, want 404", rec.Code)
+	}
+	if rec := serve(http.MethodGet, "/healthz", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET /healthz status = %d", rec.Code)
+	}
+}
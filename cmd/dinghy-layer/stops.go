@@ -0,0 +1,128 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sparkfabrik/http-proxy/pkg/service"
+)
+
+// Reasons a container's routes were removed
+const (
+	stopReasonParked  = "parked"
+	stopReasonCrashed = "crashed"
+)
+
+// stopRequestTTL is how long a stop signal marks a container as stopping: a
+// container that handles SIGTERM without exiting is not parked by a later
+// crash.
+const stopRequestTTL = 5 * time.Minute
+
+// stopSignals are the signals of docker stop and docker kill, as numbers (the
+// form Docker reports) and names. Other signals, such as the SIGHUP that
+// reloads a configuration, do not stop the container.
+var stopSignals = map[string]bool{
+	"15": true, "SIGTERM": true, "TERM": true,
+	"9": true, "SIGKILL": true, "KILL": true,
+}
+
+// isStopSignal reports whether a kill event's signal asks the container to
+// stop.
+func isStopSignal(signal string) bool {
+	return stopSignals[strings.ToUpper(strings.TrimSpace(signal))]
+}
+
+// StoppedRoutes are the routes of a container that stopped, with why: parked
+// when the stop was requested (docker stop, compose stop/down, docker kill),
+// crashed when the container exited on its own.
+type StoppedRoutes struct {
+	ContainerRoutes
+	Reason    string
+	ExitCode  *int
+	StoppedAt time.Time
+}
+
+// stopTracker remembers the routes of stopped containers so status can tell
+// a route removed on purpose from a broken one. Docker reports a kill with a
+// stop signal before the die of a requested stop, and only the die of a
+// crash. Entries are dropped when the container starts again or is removed.
+// It is safe for concurrent use.
+type stopTracker struct {
+	mu        sync.Mutex
+	requested map[string]time.Time
+	stopped   map[string]StoppedRoutes
+}
+
+func newStopTracker() *stopTracker {
+	return &stopTracker{
+		requested: make(map[string]time.Time),
+		stopped:   make(map[string]StoppedRoutes),
+	}
+}
+
+// stopRequested records a kill of the container with a stop signal, sent by
+// docker stop/kill at the given time, and drops the expired ones.
+func (t *stopTracker) stopRequested(containerID string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if at.IsZero() {
+		at = time.Now()
+	}
+	for id, requested := range t.requested {
+		if at.Sub(requested) > stopRequestTTL {
+			delete(t.requested, id)
+		}
+	}
+	t.requested[containerID] = at
+}
+
+// died records that a container serving routes exited and returns the entry.
+func (t *stopTracker) died(routes ContainerRoutes, ev service.ContainerEvent) StoppedRoutes {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry := StoppedRoutes{
+		ContainerRoutes: routes,
+		Reason:          stopReasonCrashed,
+		ExitCode:        ev.ExitCode,
+		StoppedAt:       ev.Time,
+	}
+	if entry.StoppedAt.IsZero() {
+		entry.StoppedAt = time.Now()
+	}
+	if requested, ok := t.requested[routes.ContainerID]; ok && entry.StoppedAt.Sub(requested) <= stopRequestTTL {
+		entry.Reason = stopReasonParked
+	}
+	delete(t.requested, routes.ContainerID)
+	t.stopped[routes.ContainerID] = entry
+	return entry
+}
+
+// forget drops what is known about a container, once it starts again or is
+// removed.
+func (t *stopTracker) forget(containerID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.requested, containerID)
+	delete(t.stopped, containerID)
+}
+
+// list returns the stopped containers sorted by container name.
+func (t *stopTracker) list() []StoppedRoutes {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make([]StoppedRoutes, 0, len(t.stopped))
+	for _, entry := range t.stopped {
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ContainerName != result[j].ContainerName {
+			return result[i].ContainerName < result[j].ContainerName
+		}
+		return result[i].ContainerID < result[j].ContainerID
+	})
+	return result
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/sparkfabrik/http-proxy/pkg/service"
+)
+
+func containerEvent(action events.Action, id string, attrs map[string]string) events.Message {
+	return events.Message{
+		Type:   events.ContainerEventType,
+		Action: action,
+		Actor:  events.Actor{ID: id, Attributes: attrs},
+	}
+}
+
+func TestHandleEventTracksStops(t *testing.T) {
+	tests := []struct {
+		name       string
+		events     []events.Message
+		wantReason string
+		wantExit   int
+	}{
+		{
+			name: "stop requested",
+			events: []events.Message{
+				containerEvent(events.ActionKill, "a", map[string]string{"signal": "15"}),
+				containerEvent(events.ActionDie, "a", map[string]string{"exitCode": "0"}),
+			},
+			wantReason: stopReasonParked,
+		},
+		{
+			name: "crashed",
+			events: []events.Message{
+				containerEvent(events.ActionDie, "a", map[string]string{"exitCode": "137"}),
+			},
+			wantReason: stopReasonCrashed,
+			wantExit:   137,
+		},
+		{
+			name: "crashed after a reload signal",
+			events: []events.Message{
+				containerEvent(events.ActionKill, "a", map[string]string{"signal": "1"}),
+				containerEvent(events.ActionDie, "a", map[string]string{"exitCode": "1"}),
+			},
+			wantReason: stopReasonCrashed,
+			wantExit:   1,
+		},
+		{
+			name: "crashed after a restart",
+			events: []events.Message{
+				containerEvent(events.ActionKill, "a", map[string]string{"signal": "15"}),
+				containerEvent(events.ActionRestart, "a", nil),
+				containerEvent(events.ActionDie, "a", map[string]string{"exitCode": "2"}),
+			},
+			wantReason: stopReasonCrashed,
+			wantExit:   2,
+		},
+		{
+			name: "removed",
+			events: []events.Message{
+				containerEvent(events.ActionDie, "a", map[string]string{"exitCode": "1"}),
+				containerEvent(events.ActionDestroy, "a", nil),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := testLayerWithDocker(t)
+			cl.routes.set(ContainerRoutes{ContainerID: "a", ContainerName: "app", Hostnames: []string{"app.loc"}})
+
+			for _, event := range tt.events {
+				if err := cl.HandleEvent(context.Background(), event); err != nil {
+					t.Fatalf("HandleEvent(%s) error = %v", event.Action, err)
+				}
+			}
+
+			stopped := cl.stops.list()
+			if tt.wantReason == "" {
+				if len(stopped) != 0 {
+					t.Fatalf("stopped = %+v, want none", stopped)
+				}
+				return
+			}
+			if len(stopped) != 1 || stopped[0].Reason != tt.wantReason {
+				t.Fatalf("stopped = %+v, want reason %s", stopped, tt.wantReason)
+			}
+			if stopped[0].ExitCode == nil || *stopped[0].ExitCode != tt.wantExit {
+				t.Errorf("exit code = %v, want %d", stopped[0].ExitCode, tt.wantExit)
+			}
+			if _, ok := cl.routes.get("a"); ok {
+				t.Error("routes of the stopped container are still active")
+			}
+		})
+	}
+}
+
+func TestStopRequestExpires(t *testing.T) {
+	tracker := newStopTracker()
+	killed := time.Now()
+	tracker.stopRequested("a", killed)
+
+	routes := ContainerRoutes{ContainerID: "a", ContainerName: "app"}
+	entry := tracker.died(routes, service.ContainerEvent{Time: killed.Add(stopRequestTTL + time.Second)})
+	if entry.Reason != stopReasonCrashed {
+		t.Errorf("reason = %s, want %s for a die long after the stop signal", entry.Reason, stopReasonCrashed)
+	}
+}
+
+func TestIsStopSignal(t *testing.T) {
+	for signal, want := range map[string]bool{"15": true, "9": true, "SIGTERM": true, "kill": true, "1": false, "SIGHUP": false, "": false} {
+		if got := isStopSignal(signal); got != want {
+			t.Errorf("isStopSignal(%q) = %v, want %v", signal, got, want)
+		}
+	}
+}
+
+func TestHandleEventIgnoresUnroutedStops(t *testing.T) {
+	cl := testLayerWithDocker(t)
+	cl.HandleEvent(context.Background(), containerEvent(events.ActionKill, "b", nil))
+	cl.HandleEvent(context.Background(), containerEvent(events.ActionDie, "b", map[string]string{"exitCode": "0"}))
+
+	if stopped := cl.stops.list(); len(stopped) != 0 {
+		t.Errorf("stopped = %+v, want none for a container without routes", stopped)
+	}
+}
+
+func TestHandleRoutesListsStoppedWithAll(t *testing.T) {
+	cl := testLayerWithDocker(t)
+	cl.routes.set(ContainerRoutes{ContainerID: "a", ContainerName: "app", Hostnames: []string{"app.loc"}})
+	cl.routes.set(ContainerRoutes{ContainerID: "b", ContainerName: "worker", Hostnames: []string{"worker.loc"}})
+	cl.HandleEvent(context.Background(), containerEvent(events.ActionKill, "b", map[string]string{"signal": "15"}))
+	cl.HandleEvent(context.Background(), containerEvent(events.ActionDie, "b", map[string]string{"exitCode": "0"}))
+
+	for query, want := range map[string]map[string]string{
+		"/routes":          {"app": "unknown"},
+		"/routes?all=true": {"app": "unknown", "worker": stopReasonParked},
+	} {
+		rec := httptest.NewRecorder()
+		cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, query, nil))
+
+		var got []routeStatus
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d routes, want %d", query, len(got), len(want))
+		}
+		for _, status := range got {
+			if status.Status != want[status.ContainerName] {
+				t.Errorf("%s: %s status = %q, want %q", query, status.ContainerName, status.Status, want[status.ContainerName])
+			}
+			if status.Status == stopReasonParked && status.StoppedAt == nil {
+				t.Errorf("%s: %s has no stopped_at", query, status.ContainerName)
+			}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// entryPointNamePattern matches a Traefik entry point name.
+var entryPointNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// streamPort routes the connections of a Traefik entry point to a container
+// port.
+type streamPort struct {
+	entryPoint string
+	port       string
+}
+
+// parseStreamPorts parses VIRTUAL_TCP_PORT or VIRTUAL_UDP_PORT, named by
+// variable: comma-separated [entrypoint:]port entries. An entry without an
+// entry point is served on "<protocol>-<port>", e.g. tcp-5432, which Traefik's
+// static configuration must declare like any other entry point (see
+// checkStreamEntryPoints).
+func parseStreamPorts(variable, protocol, value string) ([]streamPort, error) {
+	var ports []streamPort
+	seen := make(map[string]bool)
+	for _, entry := range splitList(value) {
+		entryPoint, port, found := strings.Cut(entry, ":")
+		if !found {
+			entryPoint, port = protocol+"-"+entry, entry
+		}
+		if !entryPointNamePattern.MatchString(entryPoint) || !isPort(port) {
+			return nil, fmt.Errorf("invalid %s entry %q, expected [entrypoint:]port", variable, entry)
+		}
+		// One container cannot take every connection of an entry point twice
+		if seen[entryPoint] {
+			return nil, fmt.Errorf("invalid %s: entry point %s is used twice", variable, entryPoint)
+		}
+		seen[entryPoint] = true
+		ports = append(ports, streamPort{entryPoint: entryPoint, port: port})
+	}
+	return ports, nil
+}
+
+// checkStreamEntryPoints rejects ports served on entry points missing from
+// HTTP_PROXY_STREAM_ENTRYPOINTS: Traefik cannot add entry points at runtime,
+// so their routes would be accepted and never receive a connection.
+func (cl *CompatibilityLayer) checkStreamEntryPoints(variable string, ports []streamPort) error {
+	for _, p := range ports {
+		if !slices.Contains(cl.config.StreamEntryPoints, p.entryPoint) {
+			return fmt.Errorf("invalid %s: entry point %s is not declared; add it to Traefik's static configuration, publish its port on the http-proxy container and list it in HTTP_PROXY_STREAM_ENTRYPOINTS", variable, p.entryPoint)
+		}
+	}
+	return nil
+}
+
+// passthroughEntryPoint is the entry point TLS passthrough routes take
+// connections from, the one HTTPS routers use
+const passthroughEntryPoint = "https"
+
+// parseTCPTLS parses VIRTUAL_TCP_TLS: true has Traefik terminate TLS on the
+// TCP routes and pick the container by the server name clients send, so
+// containers can share an entry point.
+func parseTCPTLS(value string) (bool, error) {
+	return parseSwitch("VIRTUAL_TCP_TLS", value)
+}
+
+// parseSwitch parses a true or false container variable, false when unset.
+func parseSwitch(variable, value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "false":
+		return false, nil
+	case "true":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid %s %q, expected true or false", variable, value)
+	}
+}
+
+// hostSNIRule returns the TCP rule matching the TLS server names of hosts,
+// or "" when none of them is valid.
+func hostSNIRule(hosts []virtualHost) string {
+	var rules []string
+	for _, host := range hosts {
+		if !isWildcardHost(host.hostname) {
+			rules = append(rules, fmt.Sprintf("HostSNI(`%s`)", host.hostname))
+		} else if pattern := convertWildcardToRegex(host.hostname); pattern != "" {
+			rules = append(rules, fmt.Sprintf("HostSNIRegexp(`%s`)", pattern))
+		}
+	}
+	return strings.Join(rules, " || ")
+}
+
+// streamServiceName returns the name of the TCP or UDP router and service
+// serving an entry point for a container.
+func streamServiceName(serviceName, protocol, entryPoint string) string {
+	return serviceName + "-" + protocol + "-" + entryPoint
+}
+
+// addStreamRouters routes a container's VIRTUAL_TCP_PORT and VIRTUAL_UDP_PORT
+// entry points to containerIP. Plain TCP routes take every connection of
+// their entry point (HostSNI(`*`)); with VIRTUAL_TCP_TLS=true they match the
+// VIRTUAL_HOST names instead. Invalid settings and undeclared entry points are
+// logged and skipped.
+func (cl *CompatibilityLayer) addStreamRouters(traefikConfig *config.TraefikConfig, serviceName, containerIP string, hosts []virtualHost, containerInfo ContainerInfo) {
+	tcpPorts, err := parseStreamPorts("VIRTUAL_TCP_PORT", "tcp", containerInfo.VirtualTCPPort)
+	if err == nil {
+		err = cl.checkStreamEntryPoints("VIRTUAL_TCP_PORT", tcpPorts)
+	}
+	if err == nil && len(tcpPorts) > 0 {
+		err = cl.addTCPRouters(traefikConfig, serviceName, containerIP, hosts, containerInfo.VirtualTCPTLS, tcpPorts)
+	}
+	if err != nil {
+		cl.logger.Warn("Ignoring TCP routes",
+			"container_id", utils.FormatDockerID(containerInfo.ID),
+			"error", err)
+	}
+
+	udpPorts, err := parseStreamPorts("VIRTUAL_UDP_PORT", "udp", containerInfo.VirtualUDPPort)
+	if err == nil {
+		err = cl.checkStreamEntryPoints("VIRTUAL_UDP_PORT", udpPorts)
+	}
+	if err != nil {
+		cl.logger.Warn("Ignoring UDP routes",
+			"container_id", utils.FormatDockerID(containerInfo.ID),
+			"error", err)
+		return
+	}
+	for _, p := range udpPorts {
+		if traefikConfig.UDP == nil {
+			traefikConfig.UDP = &config.UDPConfig{
+				Routers:  make(map[string]*config.UDPRouter),
+				Services: make(map[string]*config.UDPService),
+			}
+		}
+		name := streamServiceName(serviceName, "udp", p.entryPoint)
+		traefikConfig.UDP.Routers[name] = &config.UDPRouter{
+			Service:     name,
+			EntryPoints: []string{p.entryPoint},
+		}
+		traefikConfig.UDP.Services[name] = &config.UDPService{
+			LoadBalancer: &config.UDPLoadBalancer{
+				Servers: []config.UDPServer{{Address: net.JoinHostPort(containerIP, p.port)}},
+			},
+		}
+	}
+}
+
+// passthroughServiceName returns the name of the TCP router and service
+// passing a container's TLS connections through.
+func passthroughServiceName(serviceName string) string {
+	return serviceName + "-tcp-passthrough"
+}
+
+// addPassthroughRouter routes the TLS connections of the HTTPS entry point
+// whose server name is one of hosts to port, with TLS left to the container
+// end to end. It replaces the container's HTTP routers: the proxy cannot see
+// the requests, so HTTP middlewares do not apply.
+func addPassthroughRouter(traefikConfig *config.TraefikConfig, serviceName, containerIP, port string, hosts []virtualHost) error {
+	rule := hostSNIRule(hosts)
+	if rule == "" {
+		return fmt.Errorf("VIRTUAL_TLS_PASSTHROUGH=true requires VIRTUAL_HOST names to match the TLS server name against")
+	}
+
+	ensureTCP(traefikConfig)
+	name := passthroughServiceName(serviceName)
+	traefikConfig.TCP.Routers[name] = &config.TCPRouter{
+		Rule:        rule,
+		Service:     name,
+		EntryPoints: []string{passthroughEntryPoint},
+		TLS:         &config.TCPRouterTLSConfig{Passthrough: true},
+	}
+	traefikConfig.TCP.Services[name] = &config.TCPService{
+		LoadBalancer: &config.TCPLoadBalancer{
+			Servers: []config.TCPServer{{Address: net.JoinHostPort(containerIP, port)}},
+		},
+	}
+	return nil
+}
+
+// passthroughService returns a container's TLS passthrough service, or nil
+// when it has none.
+func passthroughService(traefikConfig *config.TraefikConfig, serviceName string) *config.TCPService {
+	if traefikConfig.TCP == nil {
+		return nil
+	}
+	svc := traefikConfig.TCP.Services[passthroughServiceName(serviceName)]
+	if svc == nil || svc.LoadBalancer == nil || len(svc.LoadBalancer.Servers) == 0 {
+		return nil
+	}
+	return svc
+}
+
+// ensureTCP creates the TCP section of a config unless it has one.
+func ensureTCP(traefikConfig *config.TraefikConfig) {
+	if traefikConfig.TCP == nil {
+		traefikConfig.TCP = &config.TCPConfig{
+			Routers:  make(map[string]*config.TCPRouter),
+			Services: make(map[string]*config.TCPService),
+		}
+	}
+}
+
+// addTCPRouters adds the TCP routers and services of a container's ports.
+func (cl *CompatibilityLayer) addTCPRouters(traefikConfig *config.TraefikConfig, serviceName, containerIP string, hosts []virtualHost, tlsSetting string, ports []streamPort) error {
+	terminateTLS, err := parseTCPTLS(tlsSetting)
+	if err != nil {
+		return err
+	}
+
+	rule := "HostSNI(`*`)"
+	if terminateTLS {
+		if rule = hostSNIRule(hosts); rule == "" {
+			return fmt.Errorf("VIRTUAL_TCP_TLS=true requires VIRTUAL_HOST names to match the TLS server name against")
+		}
+	}
+
+	ensureTCP(traefikConfig)
+	for _, p := range ports {
+		name := streamServiceName(serviceName, "tcp", p.entryPoint)
+		router := &config.TCPRouter{
+			Rule:        rule,
+			Service:     name,
+			EntryPoints: []string{p.entryPoint},
+		}
+		if terminateTLS {
+			router.TLS = &config.TCPRouterTLSConfig{}
+		}
+		traefikConfig.TCP.Routers[name] = router
+		traefikConfig.TCP.Services[name] = &config.TCPService{
+			LoadBalancer: &config.TCPLoadBalancer{
+				Servers: []config.TCPServer{{Address: net.JoinHostPort(containerIP, p.port)}},
+			},
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStreamPorts(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []streamPort
+		wantErr bool
+	}{
+		{name: "unset"},
+		{name: "default entry point", value: "5432", want: []streamPort{{entryPoint: "tcp-5432", port: "5432"}}},
+		{
+			name:  "named entry points",
+			value: "postgres:5432, smtp:1025",
+			want:  []streamPort{{entryPoint: "postgres", port: "5432"}, {entryPoint: "smtp", port: "1025"}},
+		},
+		{name: "bad port", value: "postgres:http", wantErr: true},
+		{name: "out of range", value: "70000", wantErr: true},
+		{name: "bad entry point", value: "my db:5432", wantErr: true},
+		{name: "entry point twice", value: "db:5432,db:5433", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStreamPorts("VIRTUAL_TCP_PORT", "tcp", tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseStreamPorts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseStreamPorts() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostSNIRule(t *testing.T) {
+	hosts := parseVirtualHosts("grpc.loc:9000,*.grpc.loc")
+	want := "HostSNI(`grpc.loc`) || HostSNIRegexp(`^.*\\.grpc\\.loc$`)"
+	if got := hostSNIRule(hosts); got != want {
+		t.Errorf("hostSNIRule() = %q, want %q", got, want)
+	}
+}
+
+func TestGenerateTraefikConfigStreams(t *testing.T) {
+	cl := testLayer()
+	cl.config.StreamEntryPoints = []string{"postgres", "udp-53", "grpc", "tcp-5432"}
+
+	t.Run("plain TCP and UDP without VIRTUAL_HOST", func(t *testing.T) {
+		info := ContainerInfo{Name: "db", VirtualTCPPort: "postgres:5432", VirtualUDPPort: "53"}
+		cfg := cl.generateTraefikConfig(inspectWithIP("/db", "172.0.0.40"), info)
+
+		if len(cfg.HTTP.Routers) != 0 || len(cfg.HTTP.Services) != 0 {
+			t.Errorf("HTTP routes generated without VIRTUAL_HOST: %+v", cfg.HTTP)
+		}
+		router := cfg.TCP.Routers["db-tcp-postgres"]
+		if router == nil || router.Rule != "HostSNI(`*`)" || router.TLS != nil || !reflect.DeepEqual(router.EntryPoints, []string{"postgres"}) {
+			t.Fatalf("tcp router = %+v", router)
+		}
+		if servers := cfg.TCP.Services["db-tcp-postgres"].LoadBalancer.Servers; len(servers) != 1 || servers[0].Address != "172.0.0.40:5432" {
+			t.Errorf("tcp servers = %+v", servers)
+		}
+		if udp := cfg.UDP.Routers["db-udp-udp-53"]; udp == nil || !reflect.DeepEqual(udp.EntryPoints, []string{"udp-53"}) {
+			t.Errorf("udp router = %+v", udp)
+		}
+		if servers := cfg.UDP.Services["db-udp-udp-53"].LoadBalancer.Servers; len(servers) != 1 || servers[0].Address != "172.0.0.40:53" {
+			t.Errorf("udp servers = %+v", servers)
+		}
+	})
+
+	t.Run("TLS matched by server name next to HTTP", func(t *testing.T) {
+		info := ContainerInfo{Name: "api", VirtualHost: "api.loc", VirtualTCPPort: "grpc:9000", VirtualTCPTLS: "true"}
+		cfg := cl.generateTraefikConfig(inspectWithIP("/api", "172.0.0.41"), info)
+
+		if len(cfg.HTTP.Routers) == 0 {
+			t.Error("HTTP routes missing")
+		}
+		router := cfg.TCP.Routers["api-tcp-grpc"]
+		if router == nil || router.Rule != "HostSNI(`api.loc`)" || router.TLS == nil {
+			t.Errorf("tcp router = %+v", router)
+		}
+	})
+
+	t.Run("TLS passthrough instead of HTTP", func(t *testing.T) {
+		info := ContainerInfo{Name: "pinned", VirtualHost: "pinned.loc,*.pinned.loc", VirtualPort: "8443", VirtualTCPPort: "5432", TLSPassthrough: "true"}
+		cfg := cl.generateTraefikConfig(inspectWithIP("/pinned", "172.0.0.42"), info)
+
+		if len(cfg.HTTP.Routers) != 0 || len(cfg.HTTP.Services) != 0 {
+			t.Errorf("HTTP routes generated for a passthrough container: %+v", cfg.HTTP)
+		}
+		router := cfg.TCP.Routers["pinned-tcp-passthrough"]
+		if router == nil || router.TLS == nil || !router.TLS.Passthrough || !reflect.DeepEqual(router.EntryPoints, []string{"https"}) {
+			t.Fatalf("passthrough router = %+v", router)
+		}
+		if want := "HostSNI(`pinned.loc`) || HostSNIRegexp(`^.*\\.pinned\\.loc$`)"; router.Rule != want {
+			t.Errorf("rule = %s, want %s", router.Rule, want)
+		}
+		if svc := passthroughService(cfg, "pinned"); svc == nil || svc.LoadBalancer.Servers[0].Address != "172.0.0.42:8443" {
+			t.Errorf("passthrough service = %+v", svc)
+		}
+		if cfg.TCP.Routers["pinned-tcp-tcp-5432"] == nil {
+			t.Error("VIRTUAL_TCP_PORT routes dropped next to passthrough")
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Validate() error = %v", err)
+		}
+	})
+
+	t.Run("invalid TLS passthrough", func(t *testing.T) {
+		info := ContainerInfo{Name: "pinned", VirtualHost: "pinned.loc", TLSPassthrough: "yes"}
+		cfg := cl.generateTraefikConfig(inspectWithIP("/pinned", "172.0.0.42"), info)
+		if len(cfg.HTTP.Routers) != 0 || cfg.TCP != nil {
+			t.Errorf("routes generated for an invalid VIRTUAL_TLS_PASSTHROUGH: %+v %+v", cfg.HTTP, cfg.TCP)
+		}
+	})
+
+	t.Run("undeclared entry point", func(t *testing.T) {
+		info := ContainerInfo{Name: "db", VirtualTCPPort: "postgres:5432,mysql:3306", VirtualUDPPort: "5353"}
+		cfg := cl.generateTraefikConfig(inspectWithIP("/db", "172.0.0.40"), info)
+		if cfg.TCP != nil || cfg.UDP != nil {
+			t.Errorf("routes generated for undeclared entry points: %+v %+v", cfg.TCP, cfg.UDP)
+		}
+	})
+
+	t.Run("TLS without hostnames", func(t *testing.T) {
+		info := ContainerInfo{Name: "db", VirtualTCPPort: "5432", VirtualTCPTLS: "true"}
+		if cfg := cl.generateTraefikConfig(inspectWithIP("/db", "172.0.0.40"), info); cfg.TCP != nil {
+			t.Errorf("tcp section = %+v, want none", cfg.TCP)
+		}
+	})
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"gopkg.in/yaml.v3"
+)
+
+// TemplateData is the route model handed to a user-provided config template.
+// It carries the same values the built-in generator uses, so a template can
+// reproduce the default output and extend it (extra middlewares, tags, ...).
+// RequestHeaders holds the container's synthetic request headers and
+// SecurityHeaders its security headers preset, if any. CORS is the headers
+// middleware answering its cross-origin requests, if any. Path is the
+// VIRTUAL_PATH prefix already part of the host rules, and PathRewrite the
+// middleware applying VIRTUAL_DEST, if any. ServersTransport is the transport
+// an HTTPS backend (VIRTUAL_PROTO) needs, nil otherwise. Port and ServerURL
+// are those of the primary service; Services lists every service, one per
+// port of the hosts. BasicAuthUsers are the valid htpasswd entries of
+// HTTP_PROXY_BASIC_AUTH. Metadata holds the container labels selected by
+// HTTP_PROXY_METADATA_LABELS. Container holds the raw settings read from the
+// container's variables and labels, for needs the model does not cover.
+type TemplateData struct {
+	ContainerID      string
+	ContainerName    string
+	ServiceName      string
+	IP               string
+	Port             string
+	ServerURL        string
+	ServersTransport *config.ServersTransport
+	Services         []TemplateService
+	Hosts            []TemplateHost
+	RequestHeaders   map[string]string
+	SecurityHeaders  *config.HeadersMiddleware
+	CORS             *config.HeadersMiddleware
+	Path             string
+	PathRewrite      *config.Middleware
+	BasicAuthUsers   []string
+	Metadata         map[string]string
+	Container        ContainerInfo
+}
+
+// TemplateService is a service of the container: the primary one, named
+// after the container, or the one of another port of its hosts.
+type TemplateService struct {
+	Name      string
+	Port      string
+	ServerURL string
+}
+
+// TemplateHost describes a single VIRTUAL_HOST entry and the router names,
+// rule, priority and service the built-in generator would assign to it.
+type TemplateHost struct {
+	Hostname      string
+	Rule          string
+	Priority      int
+	RouterName    string
+	TLSRouterName string
+	ServiceName   string
+	Port          string
+}
+
+// templateFuncs are the helper functions available inside config templates.
+// Those beyond join take their arguments in the order of the sprig functions
+// of the same name, so snippets written for Helm charts work unchanged.
+var templateFuncs = template.FuncMap{
+	"join":      strings.Join,
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"quote":     func(s string) string { return fmt.Sprintf("%q", s) },
+	"trim":      strings.TrimSpace,
+	"default":   defaultValue,
+	"splitList": func(sep, s string) []string { return strings.Split(s, sep) },
+	"contains":  func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix": func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix": func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"replace":   func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"indent":    indent,
+	"nindent":   func(n int, s string) string { return "\n" + indent(n, s) },
+	"toYaml":    toYAML,
+}
+
+// defaultValue returns value, or def when value is empty: nil, a zero
+// number, false, or an empty string, slice or map.
+func defaultValue(def, value any) any {
+	if value == nil {
+		return def
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if v.Len() == 0 {
+			return def
+		}
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return def
+		}
+	default:
+		if v.IsZero() {
+			return def
+		}
+	}
+	return value
+}
+
+// indent prefixes every line of s with n spaces.
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+// toYAML renders v as YAML without the trailing newline, for nesting with
+// indent or nindent. Values that cannot be marshalled render empty.
+func toYAML(v any) string {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(string(data), "\n")
+}
+
+// loadConfigTemplate parses the template file at path. Missing keys are an
+// error so typos in field names fail loudly instead of rendering "<no value>".
+func loadConfigTemplate(path string) (*template.Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config template: %w", err)
+	}
+
+	tmpl, err := template.New("traefik-config").Funcs(templateFuncs).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// newTemplateData builds the route model for a container reached at
+// containerIP. It returns an error when the container IP cannot be determined
+// or its path routing or backend protocol is invalid, mirroring
+// generateTraefikConfig which produces no routes in those cases. Per-port
+// service names are kept apart from usedServices, the services of the other
+// containers.
+func newTemplateData(inspect types.ContainerJSON, containerInfo ContainerInfo, containerIP string, usedServices map[string]bool) (*TemplateData, error) {
+	if containerIP == "" {
+		return nil, fmt.Errorf("could not determine container IP")
+	}
+	path, err := parseVirtualPath(containerInfo.VirtualPath, containerInfo.VirtualDest)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := parseBackendProto(containerInfo.VirtualProto, containerInfo.BackendSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	users, invalid := parseBasicAuthUsers(containerInfo.BasicAuth)
+	if len(users) == 0 && len(invalid) > 0 {
+		return nil, fmt.Errorf("no valid basic auth users")
+	}
+
+	serviceName := containerServiceName(inspect)
+	hosts := parseVirtualHosts(containerInfo.VirtualHost)
+	port := getEffectivePort(hosts, containerInfo.VirtualPort, inspect)
+
+	data := &TemplateData{
+		ContainerID:   inspect.ID,
+		ContainerName: containerInfo.Name,
+		ServiceName:   serviceName,
+		IP:            containerIP,
+		Port:          port,
+		ServerURL:     proto.serverURL(containerIP, port),
+	}
+	data.ServersTransport = proto.transport(hosts)
+	data.RequestHeaders, _ = parseRequestHeaders(containerInfo.GeoCountry, containerInfo.RequestHeaders)
+	data.SecurityHeaders, _ = securityHeadersPreset(containerInfo.SecurityHeaders)
+	data.CORS, _ = parseCORS(containerInfo.CORSAllowOrigins, containerInfo.CORSAllowMethods,
+		containerInfo.CORSAllowHeaders, containerInfo.CORSAllowCredentials, containerInfo.CORSMaxAge)
+	data.Path = path.prefix
+	data.PathRewrite = path.middleware()
+	data.BasicAuthUsers = users
+	data.Metadata = containerInfo.Metadata
+	data.Container = containerInfo
+	data.Services = []TemplateService{{Name: serviceName, Port: port, ServerURL: data.ServerURL}}
+
+	defaultPort := fallbackPort(containerInfo.VirtualPort, inspect)
+	services := newPortServices(serviceName, port, usedServices)
+	for i, host := range hosts {
+		rule := path.rule(hostRule(host.hostname))
+		if rule == "" {
+			continue
+		}
+		servicePort := hostPort(host, defaultPort)
+		hostService := services.name(servicePort)
+		if !slices.ContainsFunc(data.Services, func(s TemplateService) bool { return s.Name == hostService }) {
+			data.Services = append(data.Services, TemplateService{Name: hostService, Port: servicePort, ServerURL: proto.serverURL(containerIP, servicePort)})
+		}
+		data.Hosts = append(data.Hosts, TemplateHost{
+			Hostname:      host.hostname,
+			Rule:          rule,
+			Priority:      rulePriority(rule),
+			RouterName:    fmt.Sprintf("%s-%d", serviceName, i),
+			TLSRouterName: fmt.Sprintf("%s-tls-%d", serviceName, i),
+			ServiceName:   hostService,
+			Port:          servicePort,
+		})
+	}
+
+	return data, nil
+}
+
+// renderConfigTemplate executes tmpl against data and checks that the result
+// is well-formed YAML and a valid Traefik config, so a broken template never
+// reaches Traefik's file provider.
+func renderConfigTemplate(tmpl *template.Template, data *TemplateData) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to execute config template: %w", err)
+	}
+
+	cfg, err := config.ParseTraefikConfig(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("config template produced invalid YAML: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("config template produced an invalid Traefik config: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
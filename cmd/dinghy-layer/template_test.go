@@ -0,0 +1,180 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeTemplate(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.tmpl")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	return path
+}
+
+func TestNewTemplateData(t *testing.T) {
+	inspect := inspectWithIP("/myapp", "172.0.0.5")
+	inspect.ID = "abc123"
+	info := ContainerInfo{Name: "myapp", VirtualHost: "myapp.loc,*.myapp.loc", VirtualPort: "8080"}
+
+	data, err := newTemplateData(inspect, info, "172.0.0.5", nil)
+	if err != nil {
+		t.Fatalf("newTemplateData returned error: %v", err)
+	}
+	if data.ServiceName != "myapp" || data.IP != "172.0.0.5" || data.Port != "8080" {
+		t.Errorf("unexpected model: %+v", data)
+	}
+	if data.ServerURL != "http://172.0.0.5:8080" {
+		t.Errorf("ServerURL = %q, want http://172.0.0.5:8080", data.ServerURL)
+	}
+	if len(data.Hosts) != 2 {
+		t.Fatalf("host count = %d, want 2", len(data.Hosts))
+	}
+	if data.Hosts[0].Rule != "Host(`myapp.loc`)" || data.Hosts[0].RouterName != "myapp-0" || data.Hosts[0].TLSRouterName != "myapp-tls-0" {
+		t.Errorf("unexpected first host: %+v", data.Hosts[0])
+	}
+	if !strings.HasPrefix(data.Hosts[1].Rule, "HostRegexp(") {
+		t.Errorf("wildcard host rule = %q, want HostRegexp", data.Hosts[1].Rule)
+	}
+}
+
+func TestNewTemplateDataWithoutIP(t *testing.T) {
+	inspect := inspectWithIP("/myapp", "")
+	if _, err := newTemplateData(inspect, ContainerInfo{VirtualHost: "myapp.loc"}, "", nil); err == nil {
+		t.Error("expected error when container IP is unknown")
+	}
+}
+
+func TestRenderConfigTemplate(t *testing.T) {
+	path := writeTemplate(t, `http:
+  routers:
+{{- range .Hosts }}
+    {{ .RouterName }}:
+      rule: {{ quote .Rule }}
+      service: {{ $.ServiceName }}
+      middlewares: [extra@file]
+{{- end }}
+  services:
+    {{ .ServiceName }}:
+      loadBalancer:
+        servers:
+          - url: {{ .ServerURL }}
+`)
+	tmpl, err := loadConfigTemplate(path)
+	if err != nil {
+		t.Fatalf("loadConfigTemplate returned error: %v", err)
+	}
+
+	data, err := newTemplateData(inspectWithIP("/myapp", "172.0.0.5"), ContainerInfo{VirtualHost: "myapp.loc", VirtualPort: "80"}, "172.0.0.5", nil)
+	if err != nil {
+		t.Fatalf("newTemplateData returned error: %v", err)
+	}
+
+	out, err := renderConfigTemplate(tmpl, data)
+	if err != nil {
+		t.Fatalf("renderConfigTemplate returned error: %v", err)
+	}
+	for _, want := range []string{"myapp-0:", "rule: \"Host(`myapp.loc`)\"", "extra@file", "url: http://172.0.0.5:80"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("rendered config missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRenderConfigTemplateRejectsInvalidYAML(t *testing.T) {
+	tmpl, err := loadConfigTemplate(writeTemplate(t, "http: [unterminated"))
+	if err != nil {
+		t.Fatalf("loadConfigTemplate returned error: %v", err)
+	}
+	if _, err := renderConfigTemplate(tmpl, &TemplateData{}); err == nil {
+		t.Error("expected error for invalid YAML output")
+	}
+}
+
+func TestLoadConfigTemplateMissingKey(t *testing.T) {
+	tmpl, err := loadConfigTemplate(writeTemplate(t, "{{ .NoSuchField }}"))
+	if err != nil {
+		t.Fatalf("loadConfigTemplate returned error: %v", err)
+	}
+	if _, err := renderConfigTemplate(tmpl, &TemplateData{}); err == nil {
+		t.Error("expected error for unknown template field")
+	}
+}
+
+func TestLoadConfigTemplateMissingFile(t *testing.T) {
+	if _, err := loadConfigTemplate(filepath.Join(t.TempDir(), "missing.tmpl")); err == nil {
+		t.Error("expected error for missing template file")
+	}
+}
+
+func TestRenderConfigTemplateHelpers(t *testing.T) {
+	path := writeTemplate(t, `http:
+  routers:
+{{- range .Hosts }}
+    {{ .RouterName }}:
+      rule: {{ quote .Rule }}
+      service: {{ $.ServiceName }}
+      middlewares:
+{{- range splitList "," (default "compress@file" $.Container.Middlewares) }}
+        - {{ trim . }}
+{{- end }}
+{{- end }}
+  services:
+    {{ .ServiceName }}:
+      loadBalancer:
+        servers:
+          - url: {{ .ServerURL }}
+        serversTransport: {{ .ServiceName }}-transport
+  serversTransports:
+    {{ .ServiceName }}-transport:
+      {{- toYaml .ServersTransport | nindent 6 }}
+      forwardingTimeouts:
+        responseHeaderTimeout: {{ default "30s" (index .Metadata "timeout") }}
+`)
+	tmpl, err := loadConfigTemplate(path)
+	if err != nil {
+		t.Fatalf("loadConfigTemplate returned error: %v", err)
+	}
+
+	info := ContainerInfo{VirtualHost: "myapp.loc", VirtualPort: "443", VirtualProto: "https", Middlewares: "auth@file, ratelimit@file"}
+	data, err := newTemplateData(inspectWithIP("/myapp", "172.0.0.5"), info, "172.0.0.5", nil)
+	if err != nil {
+		t.Fatalf("newTemplateData returned error: %v", err)
+	}
+
+	out, err := renderConfigTemplate(tmpl, data)
+	if err != nil {
+		t.Fatalf("renderConfigTemplate returned error: %v", err)
+	}
+	for _, want := range []string{"- auth@file\n", "- ratelimit@file\n", "      insecureSkipVerify: true\n", "responseHeaderTimeout: 30s"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("rendered config missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestDefaultValue(t *testing.T) {
+	var nilMap map[string]string
+	tests := []struct {
+		value any
+		want  any
+	}{
+		{nil, "def"},
+		{"", "def"},
+		{"set", "set"},
+		{0, "def"},
+		{8080, 8080},
+		{nilMap, "def"},
+		{[]string{"a"}, []string{"a"}},
+	}
+	for _, tt := range tests {
+		if got := defaultValue("def", tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("defaultValue(%v) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
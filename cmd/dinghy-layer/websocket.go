@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Where a WebSocket upgrade through the proxy failed
+const (
+	wsStageProxy      = "proxy"
+	wsStageMiddleware = "middleware"
+	wsStageBackend    = "backend"
+)
+
+const (
+	// websocketGUID is appended to the client key to compute the accept key
+	// (RFC 6455, section 1.3)
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// traefikNotFound is the body Traefik answers when no router matches
+	traefikNotFound = "404 page not found"
+
+	// websocketProbeTimeout bounds a WebSocket probe, below the CLI's admin
+	// API timeout so a silent backend is reported rather than timing out
+	websocketProbeTimeout = 3 * time.Second
+)
+
+// WebSocketProbeResult is the outcome of opening a WebSocket through the
+// proxy. When the upgrade fails, Stage tells where: "proxy" (the request did
+// not reach a router), "middleware" (a middleware of the route answered) or
+// "backend" (the app did not switch protocols or exchange frames).
+type WebSocketProbeResult struct {
+	Hostname      string    `json:"hostname"`
+	Path          string    `json:"path"`
+	ContainerName string    `json:"container_name,omitempty"`
+	OK            bool      `json:"ok"`
+	Stage         string    `json:"stage,omitempty"`
+	StatusCode    int       `json:"status_code,omitempty"`
+	Middlewares   []string  `json:"middlewares,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	LatencyMS     float64   `json:"latency_ms"`
+	CheckedAt     time.Time `json:"checked_at"`
+}
+
+// handleWebSocketProbe opens a WebSocket upgrade for a routed hostname through
+// the proxy at ProbeTarget and reports where it fails. ?path= selects the
+// path to upgrade (default "/").
+func (cl *CompatibilityLayer) handleWebSocketProbe(w http.ResponseWriter, r *http.Request) {
+	hostname := r.PathValue("host")
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = "/"
+	}
+	if !strings.HasPrefix(path, "/") {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "path must start with /"})
+		return
+	}
+
+	routes, ok := cl.routeFor(hostname)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "no route serves " + hostname})
+		return
+	}
+	target, err := parseProbeTarget(cl.config.ProbeTarget)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), websocketProbeTimeout)
+	defer cancel()
+	result := probeWebSocket(ctx, target, hostname, path, routeMiddlewares(cl.routers(routes)))
+	result.ContainerName = routes.ContainerName
+
+	if result.OK {
+		cl.logger.Info("WebSocket probe succeeded", "host", hostname, "path", path)
+	} else {
+		cl.logger.Warn("WebSocket probe failed", "host", hostname, "path", path, "stage", result.Stage, "error", result.Error)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// routeFor returns the routes serving hostname, matching wildcard and regex
+// hosts when no route names it exactly.
+func (cl *CompatibilityLayer) routeFor(hostname string) (ContainerRoutes, bool) {
+	all := cl.routes.list()
+	for _, routes := range all {
+		for _, h := range routes.Hostnames {
+			if h == hostname {
+				return routes, true
+			}
+		}
+	}
+	for _, routes := range all {
+		for _, h := range routes.Hostnames {
+			if !isWildcardHost(h) {
+				continue
+			}
+			if re, err := regexp.Compile(convertWildcardToRegex(h)); err == nil && re.MatchString(hostname) {
+				return routes, true
+			}
+		}
+	}
+	return ContainerRoutes{}, false
+}
+
+// routeMiddlewares returns the middlewares of routers, sorted and without
+// duplicates.
+func routeMiddlewares(routers []routerStatus) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, router := range routers {
+		for _, middleware := range router.Middlewares {
+			if !seen[middleware] {
+				seen[middleware] = true
+				result = append(result, middleware)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// probeWebSocket sends an upgrade request for hostname and path to the proxy
+// at target and, once switched, checks that frames flow by sending a ping.
+// middlewares are those of the route, used to tell whether an answer came
+// from a middleware.
+func probeWebSocket(ctx context.Context, target *url.URL, hostname, path string, middlewares []string) (result WebSocketProbeResult) {
+	result = WebSocketProbeResult{Hostname: hostname, Path: path, Middlewares: middlewares}
+	start := time.Now()
+	defer func() {
+		result.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+		result.CheckedAt = time.Now().UTC()
+	}()
+
+	fail := func(stage, format string, args ...any) WebSocketProbeResult {
+		result.Stage = stage
+		result.Error = fmt.Sprintf(format, args...)
+		return result
+	}
+
+	conn, err := dialProbeTarget(ctx, target, hostname)
+	if err != nil {
+		return fail(wsStageProxy, "cannot connect to the proxy: %v", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	encodedKey := base64.StdEncoding.EncodeToString(key)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.Scheme+"://"+hostname+path, nil)
+	if err != nil {
+		return fail(wsStageProxy, "invalid request: %v", err)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", encodedKey)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	// Browsers always send an Origin; apps often reject upgrades without one
+	req.Header.Set("Origin", target.Scheme+"://"+hostname)
+	req.Header.Set("User-Agent", "http-proxy-websocket-probe")
+	if err := req.Write(conn); err != nil {
+		return fail(wsStageProxy, "cannot send the upgrade request: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return fail(wsStageProxy, "no answer from the proxy: %v", err)
+	}
+	result.StatusCode = resp.StatusCode
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, probeBodyLimit))
+		resp.Body.Close()
+		stage, reason := classifyUpgradeFailure(resp.StatusCode, string(body), middlewares)
+		return fail(stage, "%s", reason)
+	}
+
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+		return fail(wsStageBackend, "switched to %q instead of websocket", resp.Header.Get("Upgrade"))
+	}
+	if got, want := resp.Header.Get("Sec-WebSocket-Accept"), websocketAccept(encodedKey); got != want {
+		return fail(wsStageBackend, "invalid Sec-WebSocket-Accept %q, want %q", got, want)
+	}
+
+	if _, err := conn.Write(maskedFrame(0x9, []byte("http-proxy"))); err != nil {
+		return fail(wsStageProxy, "cannot send a ping after the upgrade: %v", err)
+	}
+	// Any frame back, the pong or a message from the app, shows frames flow
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return fail(wsStageBackend, "upgraded but no frame came back after a ping: %v", err)
+	}
+	conn.Write(maskedFrame(0x8, nil))
+
+	result.OK = true
+	return result
+}
+
+// dialProbeTarget connects to the proxy entrypoint at target, with TLS for
+// https targets. Like route probes, certificates are not verified.
+func dialProbeTarget(ctx context.Context, target *url.URL, hostname string) (net.Conn, error) {
+	addr := target.Host
+	if target.Port() == "" {
+		port := "80"
+		if target.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(target.Hostname(), port)
+	}
+
+	dialer := &net.Dialer{}
+	if target.Scheme != "https" {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+	tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{
+		ServerName:         hostname,
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"http/1.1"},
+	}}
+	return tlsDialer.DialContext(ctx, "tcp", addr)
+}
+
+// classifyUpgradeFailure tells which part of the chain answered an upgrade
+// request with status instead of switching protocols, and why.
+func classifyUpgradeFailure(status int, body string, middlewares []string) (string, string) {
+	switch {
+	case status == http.StatusNotFound && strings.TrimSpace(body) == traefikNotFound:
+		return wsStageProxy, "no router of the proxy matched the request (404)"
+	case status == http.StatusBadGateway:
+		return wsStageBackend, "the proxy could not connect to the backend (502)"
+	case status == http.StatusServiceUnavailable:
+		return wsStageBackend, "the service has no available server (503)"
+	case status == http.StatusGatewayTimeout:
+		return wsStageBackend, "the backend did not answer in time (504)"
+	}
+
+	middlewareStatus := status == http.StatusUnauthorized || status == http.StatusForbidden ||
+		status == http.StatusTooManyRequests || (status >= 300 && status < 400)
+	if middlewareStatus && len(middlewares) > 0 {
+		return wsStageMiddleware, fmt.Sprintf("answered %d before the backend, check the middlewares %s", status, strings.Join(middlewares, ", "))
+	}
+	if status < 300 {
+		return wsStageBackend, fmt.Sprintf("the backend answered %d without switching protocols; it does not handle WebSocket upgrades on this path", status)
+	}
+	return wsStageBackend, fmt.Sprintf("the backend refused the upgrade (%d)", status)
+}
+
+// websocketAccept returns the Sec-WebSocket-Accept value for a client key.
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// maskedFrame encodes a final client frame with opcode and a payload shorter
+// than 126 bytes; client frames must be masked.
+func maskedFrame(opcode byte, payload []byte) []byte {
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	mask := make([]byte, 4)
+	rand.Read(mask)
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
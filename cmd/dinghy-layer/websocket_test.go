@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// newWebSocketProxy returns a fake proxy that upgrades ws.loc and answers a
+// ping with a pong, upgrades silent.loc without ever sending a frame, and
+// answers other hosts like Traefik and its middlewares would.
+func newWebSocketProxy(t *testing.T) *url.URL {
+	t.Helper()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Host {
+		case "ws.loc", "silent.loc":
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+			rw.WriteString("Sec-WebSocket-Accept: " + websocketAccept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+			rw.Flush()
+			if r.Host == "silent.loc" {
+				io.Copy(io.Discard, rw)
+				return
+			}
+			frame := make([]byte, 2)
+			if _, err := io.ReadFull(rw, frame); err == nil && frame[0]&0x0f == 0x9 {
+				rw.Write([]byte{0x8a, 0x00})
+				rw.Flush()
+			}
+			io.Copy(io.Discard, rw)
+		case "plain.loc":
+			w.Write([]byte("<html></html>"))
+		case "auth.loc":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "down.loc":
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		default:
+			http.Error(w, "404 page not found", http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(proxy.Close)
+
+	target, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return target
+}
+
+func TestProbeWebSocket(t *testing.T) {
+	target := newWebSocketProxy(t)
+	tests := []struct {
+		host        string
+		middlewares []string
+		wantOK      bool
+		wantStage   string
+	}{
+		{host: "ws.loc", wantOK: true},
+		{host: "silent.loc", wantStage: wsStageBackend},
+		{host: "plain.loc", wantStage: wsStageBackend},
+		{host: "auth.loc", middlewares: []string{"app-auth"}, wantStage: wsStageMiddleware},
+		{host: "auth.loc", wantStage: wsStageBackend},
+		{host: "down.loc", wantStage: wsStageBackend},
+		{host: "missing.loc", wantStage: wsStageProxy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+
+			result := probeWebSocket(ctx, target, tt.host, "/ws", tt.middlewares)
+			if result.OK != tt.wantOK || result.Stage != tt.wantStage {
+				t.Errorf("probeWebSocket(%s) = %+v, want ok %v, stage %q", tt.host, result, tt.wantOK, tt.wantStage)
+			}
+			if result.CheckedAt.IsZero() {
+				t.Error("CheckedAt is not set")
+			}
+			if !result.OK && result.Error == "" {
+				t.Error("a failed probe must explain why")
+			}
+		})
+	}
+
+	// An unreachable proxy fails at the proxy stage
+	closed := &url.URL{Scheme: "http", Host: "127.0.0.1:1"}
+	if result := probeWebSocket(context.Background(), closed, "ws.loc", "/", nil); result.Stage != wsStageProxy {
+		t.Errorf("probe through a closed port = %+v, want the proxy stage", result)
+	}
+}
+
+func TestHandleWebSocketProbe(t *testing.T) {
+	cl := testLayerWithDocker(t)
+	cl.config.ProbeTarget = newWebSocketProxy(t).String()
+	cl.routes.set(ContainerRoutes{ContainerID: "a", ContainerName: "app", Hostnames: []string{"*.ws.loc", "ws.loc"}})
+
+	tests := []struct {
+		path     string
+		wantCode int
+		wantOK   bool
+	}{
+		{"/routes/ws.loc/websocket?path=/socket", http.StatusOK, true},
+		{"/routes/chat.ws.loc/websocket", http.StatusOK, false},
+		{"/routes/other.loc/websocket", http.StatusNotFound, false},
+		{"/routes/ws.loc/websocket?path=socket", http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		cl.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("GET %s = %d, want %d: %s", tt.path, rec.Code, tt.wantCode, rec.Body)
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+		var result WebSocketProbeResult
+		if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		if result.OK != tt.wantOK || result.ContainerName != "app" {
+			t.Errorf("GET %s = %+v, want ok %v for app", tt.path, result, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
+
+// maxRateLimitedClients caps the clients tracked by the rate limiter, so
+// queries from spoofed source addresses cannot grow it without bound
+const maxRateLimitedClients = 10000
+
+// clientACL decides which clients may query the server from their address.
+// A nil clientACL allows every client.
+type clientACL struct {
+	allowed []netip.Prefix // empty allows every client not denied
+	denied  []netip.Prefix
+}
+
+// newClientACL parses the allowed and denied networks, given as CIDRs or
+// single addresses. It returns nil when both lists are empty.
+func newClientACL(allowed, denied []string) (*clientACL, error) {
+	if len(allowed) == 0 && len(denied) == 0 {
+		return nil, nil
+	}
+	acl := &clientACL{}
+	var err error
+	if acl.allowed, err = parsePrefixes(allowed); err != nil {
+		return nil, fmt.Errorf("invalid HTTP_PROXY_DNS_ALLOWED_CIDRS: %w", err)
+	}
+	if acl.denied, err = parsePrefixes(denied); err != nil {
+		return nil, fmt.Errorf("invalid HTTP_PROXY_DNS_DENIED_CIDRS: %w", err)
+	}
+	return acl, nil
+}
+
+// parsePrefixes parses CIDRs, reading a bare address as a single-host network.
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a CIDR or IP address", value)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a CIDR or IP address", value)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// allows reports whether client may query: it is not in a denied network
+// and, when networks are allowed, in one of them.
+func (a *clientACL) allows(client netip.Addr) bool {
+	if a == nil {
+		return true
+	}
+	for _, prefix := range a.denied {
+		if prefix.Contains(client) {
+			return false
+		}
+	}
+	if len(a.allowed) == 0 {
+		return true
+	}
+	for _, prefix := range a.allowed {
+		if prefix.Contains(client) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the IP of a client address, ok false when it is
+// unknown.
+func clientAddr(addr net.Addr) (netip.Addr, bool) {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	default:
+		return netip.Addr{}, false
+	}
+	client, ok := netip.AddrFromSlice(ip)
+	return client.Unmap(), ok
+}
+
+// tokenBucket holds the queries a client may still send right away.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter limits the queries answered per client IP with a token bucket
+// refilled at rate per second and holding up to one second of queries. Its
+// rate can be changed on reload while the buckets are kept. It is safe for
+// concurrent use; a nil rateLimiter allows every query.
+type rateLimiter struct {
+	now      func() time.Time
+	rejected *metrics.Vec
+
+	mu      sync.Mutex
+	rate    float64
+	buckets map[netip.Addr]*tokenBucket
+}
+
+// newRateLimiter creates a disabled rate limiter; setRate enables it.
+func newRateLimiter(registry *metrics.Registry) *rateLimiter {
+	return &rateLimiter{
+		now:      time.Now,
+		rejected: registry.Counter("http_proxy_dns_rejected_queries_total", "Queries not answered because of the client ACL or rate limit, by reason.", "reason"),
+		buckets:  make(map[netip.Addr]*tokenBucket),
+	}
+}
+
+// setRate sets the queries per second answered per client, 0 disabling the
+// limit.
+func (l *rateLimiter) setRate(rate int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = float64(max(rate, 0))
+	if l.rate == 0 {
+		clear(l.buckets)
+	}
+}
+
+// allow takes a token from the bucket of client, reporting whether the
+// query may be answered.
+func (l *rateLimiter) allow(client netip.Addr) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		return true
+	}
+
+	now := l.now()
+	bucket, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitedClients {
+			l.sweep(now)
+		}
+		bucket = &tokenBucket{tokens: l.rate, last: now}
+		l.buckets[client] = bucket
+	}
+
+	bucket.tokens = min(l.rate, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// sweep forgets the clients whose bucket has refilled, which behave like
+// clients never seen.
+func (l *rateLimiter) sweep(now time.Time) {
+	for client, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.rate {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// reject counts a query not answered for reason.
+func (l *rateLimiter) reject(reason string) {
+	if l != nil {
+		l.rejected.Inc(reason)
+	}
+}
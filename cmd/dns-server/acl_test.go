@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
+
+func TestClientACL(t *testing.T) {
+	acl, err := newClientACL([]string{"127.0.0.1", "172.16.0.0/12", "fd00::/8"}, []string{"172.17.0.99"})
+	if err != nil {
+		t.Fatalf("newClientACL() error = %v", err)
+	}
+
+	tests := []struct {
+		client string
+		want   bool
+	}{
+		{"127.0.0.1", true},
+		{"::ffff:127.0.0.1", true},
+		{"172.17.0.2", true},
+		{"172.17.0.99", false},
+		{"192.168.1.10", false},
+		{"fd12::1", true},
+		{"2001:db8::1", false},
+	}
+	for _, tt := range tests {
+		client, _ := clientAddr(&net.UDPAddr{IP: net.ParseIP(tt.client)})
+		if got := acl.allows(client); got != tt.want {
+			t.Errorf("allows(%s) = %v, want %v", tt.client, got, tt.want)
+		}
+	}
+
+	denyOnly, _ := newClientACL(nil, []string{"10.0.0.0/8"})
+	if !denyOnly.allows(netip.MustParseAddr("192.168.1.10")) || denyOnly.allows(netip.MustParseAddr("10.1.2.3")) {
+		t.Error("a denylist without allowlist must allow every other client")
+	}
+
+	if acl, err := newClientACL(nil, nil); acl != nil || err != nil {
+		t.Errorf("newClientACL(nil, nil) = %v, %v, want nil", acl, err)
+	}
+	if _, err := newClientACL([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Error("newClientACL() accepted an invalid CIDR")
+	}
+	if _, err := newClientACL(nil, []string{"example.com"}); err == nil {
+		t.Error("newClientACL() accepted a hostname")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := newRateLimiter(metrics.NewRegistry())
+	limiter.now = func() time.Time { return now }
+	limiter.setRate(2)
+
+	a, b := netip.MustParseAddr("192.168.1.10"), netip.MustParseAddr("192.168.1.11")
+	for i, want := range []bool{true, true, false} {
+		if got := limiter.allow(a); got != want {
+			t.Errorf("query %d: allow() = %v, want %v", i, got, want)
+		}
+	}
+	if !limiter.allow(b) {
+		t.Error("another client is limited by the first one's rate")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if !limiter.allow(a) || limiter.allow(a) {
+		t.Error("half a second should refill one query")
+	}
+
+	limiter.setRate(0)
+	for range 10 {
+		if !limiter.allow(a) {
+			t.Fatal("a disabled limiter limited a query")
+		}
+	}
+}
+
+func TestRejectClient(t *testing.T) {
+	acl, _ := newClientACL(nil, []string{"10.0.0.0/8"})
+	limiter := newRateLimiter(metrics.NewRegistry())
+	limiter.setRate(1)
+	s := &DNSServer{customDomains: []string{"loc"}, targetIP: "127.0.0.1", acl: acl, limiter: limiter, logger: logger.New("test")}
+
+	query := func(addr net.Addr) *dns.Msg {
+		r := new(dns.Msg)
+		r.SetQuestion("myapp.loc.", dns.TypeA)
+		w := &dohResponseWriter{remote: addr}
+		s.handleDNSRequest(w, r)
+		return w.msg
+	}
+
+	if msg := query(&net.UDPAddr{IP: net.ParseIP("10.1.2.3")}); msg == nil || msg.Rcode != dns.RcodeRefused {
+		t.Errorf("denied client answer = %v, want REFUSED", msg)
+	}
+
+	udp := &net.UDPAddr{IP: net.ParseIP("192.168.1.10")}
+	if msg := query(udp); msg == nil || len(msg.Answer) != 1 {
+		t.Fatalf("first query answer = %v", msg)
+	}
+	if msg := query(udp); msg != nil {
+		t.Errorf("rate limited UDP query answered %v, want no answer", msg)
+	}
+	if msg := query(&net.TCPAddr{IP: net.ParseIP("192.168.1.10")}); msg == nil || msg.Rcode != dns.RcodeRefused {
+		t.Errorf("rate limited TCP query answer = %v, want REFUSED", msg)
+	}
+
+	if got := limiter.rejected.Value(sourceDenied); got != 1 {
+		t.Errorf("denied count = %v, want 1", got)
+	}
+	if got := limiter.rejected.Value(sourceLimited); got != 2 {
+		t.Errorf("rate limited count = %v, want 2", got)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+// defaultProcRoot is where port owners are looked up
+const defaultProcRoot = "/proc"
+
+// PortConflict describes who holds a port the server could not bind. PID and
+// Process are empty when the owner is not visible, e.g. it lives in another
+// PID namespace.
+type PortConflict struct {
+	Port    string `json:"port"`
+	Proto   string `json:"proto"`
+	PID     int    `json:"pid,omitempty"`
+	Process string `json:"process,omitempty"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// String renders the conflict for log messages.
+func (c *PortConflict) String() string {
+	owner := "an unknown process"
+	if c.Process != "" {
+		owner = fmt.Sprintf("%s (pid %d)", c.Process, c.PID)
+	}
+	return fmt.Sprintf("%s port %s is in use by %s", c.Proto, c.Port, owner)
+}
+
+// listeners holds the UDP and TCP sockets the DNS servers serve on.
+type listeners struct {
+	port   string
+	packet net.PacketConn
+	stream net.Listener
+}
+
+// close releases both sockets.
+func (l *listeners) close() {
+	l.packet.Close()
+	l.stream.Close()
+}
+
+// listen binds UDP and TCP on port. Binding up front, rather than inside
+// dns.Server.ListenAndServe, lets a busy port be diagnosed before serving.
+func listen(port string) (*listeners, string, error) {
+	packet, err := net.ListenPacket("udp", ":"+port)
+	if err != nil {
+		return nil, "udp", err
+	}
+
+	stream, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		packet.Close()
+		return nil, "tcp", err
+	}
+
+	return &listeners{port: port, packet: packet, stream: stream}, "", nil
+}
+
+// bindWithFallback binds the configured port and, when it is already in use,
+// tries the fallback ports in order. The conflict on the configured port is
+// returned alongside the listeners so it can be reported in the status.
+func bindWithFallback(port string, fallbackPorts []string, procRoot string, log *logger.Logger) (*listeners, *PortConflict, error) {
+	l, proto, err := listen(port)
+	if err == nil {
+		return l, nil, nil
+	}
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		return nil, nil, fmt.Errorf("failed to bind %s port %s: %w", proto, port, err)
+	}
+
+	conflict := findPortOwner(procRoot, port, proto)
+	log.Warn("DNS port is busy", "conflict", conflict.String(), "hint", conflict.Hint)
+
+	for _, fallback := range fallbackPorts {
+		l, proto, err := listen(fallback)
+		if err == nil {
+			log.Warn("Using fallback DNS port", "configured_port", port, "port", fallback)
+			return l, conflict, nil
+		}
+		log.Warn("Fallback DNS port unavailable", "proto", proto, "port", fallback, "error", err)
+	}
+
+	if len(fallbackPorts) == 0 {
+		return nil, conflict, fmt.Errorf("%s; set HTTP_PROXY_DNS_PORT or HTTP_PROXY_DNS_FALLBACK_PORTS", conflict)
+	}
+	return nil, conflict, fmt.Errorf("%s and no fallback port is available", conflict)
+}
+
+// findPortOwner looks up the process listening on port from procRoot's socket
+// tables. It always returns a conflict, with the owner filled in when found.
+func findPortOwner(procRoot, port, proto string) *PortConflict {
+	conflict := &PortConflict{Port: port, Proto: proto}
+	defer func() { conflict.Hint = conflictHint(conflict) }()
+
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return conflict
+	}
+
+	// Listening TCP sockets are in state 0A, bound UDP sockets in state 07
+	state := "0A"
+	if proto == "udp" {
+		state = "07"
+	}
+
+	var inodes []string
+	for _, table := range []string{proto, proto + "6"} {
+		inodes = append(inodes, socketInodes(filepath.Join(procRoot, "net", table), uint16(portNum), state)...)
+	}
+	if len(inodes) == 0 {
+		return conflict
+	}
+
+	conflict.PID, conflict.Process = socketOwner(procRoot, inodes)
+	return conflict
+}
+
+// socketInodes returns the inodes of sockets in a /proc/net table bound to
+// port in the given state.
+func socketInodes(path string, port uint16, state string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var inodes []string
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != state {
+			continue
+		}
+		_, hexPort, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		if p, err := strconv.ParseUint(hexPort, 16, 16); err == nil && uint16(p) == port {
+			inodes = append(inodes, fields[9])
+		}
+	}
+	return inodes
+}
+
+// socketOwner finds the first process holding one of the socket inodes.
+func socketOwner(procRoot string, inodes []string) (int, string) {
+	targets := make(map[string]bool, len(inodes))
+	for _, inode := range inodes {
+		targets["socket:["+inode+"]"] = true
+	}
+
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return 0, ""
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join(procRoot, entry.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !targets[link] {
+				continue
+			}
+			comm, _ := os.ReadFile(filepath.Join(procRoot, entry.Name(), "comm"))
+			return pid, strings.TrimSpace(string(comm))
+		}
+	}
+	return 0, ""
+}
+
+// conflictHint suggests how to resolve the usual conflicts.
+func conflictHint(c *PortConflict) string {
+	switch {
+	case strings.Contains(c.Process, "systemd-resolve") || (c.Process == "" && c.Port == "53"):
+		return "the systemd-resolved stub listener usually holds port 53; set DNSStubListener=no in /etc/systemd/resolved.conf or use another port"
+	case c.Process == "dnsmasq":
+		return "another dnsmasq instance is running; stop it or use another port"
+	case c.Process == "dns-server":
+		return "another http-proxy DNS server is already running"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+// writeProcFixture builds a fake /proc with a UDP socket table and one process
+// holding the socket with the given inode.
+func writeProcFixture(t *testing.T, udpTable string, pid int, comm, inode string) string {
+	t.Helper()
+	root := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(root, "net"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "net", "udp"), []byte(udpTable), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	procDir := filepath.Join(root, strconv.Itoa(pid))
+	if err := os.MkdirAll(filepath.Join(procDir, "fd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(procDir, "comm"), []byte(comm+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("socket:["+inode+"]", filepath.Join(procDir, "fd", "3")); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+const udpTable = `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  100: 3500007F:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 23456 2 0000000000000000 0
+  101: 00000000:14A9 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 34567 2 0000000000000000 0
+`
+
+func TestFindPortOwner(t *testing.T) {
+	root := writeProcFixture(t, udpTable, 812, "systemd-resolve", "23456")
+
+	conflict := findPortOwner(root, "53", "udp")
+	if conflict.PID != 812 || conflict.Process != "systemd-resolve" {
+		t.Errorf("owner = %d %q, want 812 systemd-resolve", conflict.PID, conflict.Process)
+	}
+	if conflict.Hint == "" {
+		t.Error("expected a systemd-resolved hint")
+	}
+
+	// Port 5353 is not in the table
+	unknown := findPortOwner(root, "5353", "udp")
+	if unknown.PID != 0 || unknown.Process != "" {
+		t.Errorf("unexpected owner for a free port: %+v", unknown)
+	}
+	if got := unknown.String(); got != "udp port 5353 is in use by an unknown process" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+// freePort returns a port that was free when checked.
+func freePort(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+}
+
+func TestBindWithFallback(t *testing.T) {
+	busy, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	busyPort := strconv.Itoa(busy.LocalAddr().(*net.UDPAddr).Port)
+
+	log := logger.New("test")
+
+	if _, conflict, err := bindWithFallback(busyPort, nil, t.TempDir(), log); err == nil {
+		t.Fatal("expected an error without fallback ports")
+	} else if conflict == nil || conflict.Proto != "udp" {
+		t.Errorf("conflict = %+v", conflict)
+	}
+
+	fallback := freePort(t)
+	bound, conflict, err := bindWithFallback(busyPort, []string{busyPort, fallback}, t.TempDir(), log)
+	if err != nil {
+		t.Fatalf("bindWithFallback: %v", err)
+	}
+	defer bound.close()
+
+	if bound.port != fallback {
+		t.Errorf("bound port = %s, want %s", bound.port, fallback)
+	}
+	if conflict == nil || conflict.Port != busyPort {
+		t.Errorf("conflict = %+v", conflict)
+	}
+
+	status := newDNSStatus(busyPort, bound.port, conflict, nil)
+	if !status.Fallback {
+		t.Error("status should report the fallback")
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+// blockResponseNXDomain answers blocked names as nonexistent
+const blockResponseNXDomain = "nxdomain"
+
+// domainBlocklist answers the names of blocked domains, and every name below
+// them, with NXDOMAIN or a sinkhole address, before any other lookup. A nil
+// domainBlocklist blocks nothing.
+type domainBlocklist struct {
+	domains  map[string]bool // lowercase, without the trailing dot
+	sinkhole net.IP          // nil answers NXDOMAIN
+	file     string          // read on every reload and watched for changes
+}
+
+// newDomainBlocklist builds the blocklist from the domains of
+// HTTP_PROXY_DNS_BLOCKLIST and the file of HTTP_PROXY_DNS_BLOCKLIST_FILE.
+// response is nxdomain or the sinkhole IP blocked names resolve to. A file
+// that cannot be read is logged and the other domains are still blocked. It
+// returns nil when no domain is blocked.
+func newDomainBlocklist(domains []string, file, response string, log *logger.Logger) (*domainBlocklist, error) {
+	b := &domainBlocklist{domains: make(map[string]bool), file: file}
+
+	switch response = strings.ToLower(strings.TrimSpace(response)); response {
+	case "", blockResponseNXDomain:
+	default:
+		ip := net.ParseIP(response)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid HTTP_PROXY_DNS_BLOCK_RESPONSE %q: expected %s or an IP address", response, blockResponseNXDomain)
+		}
+		b.sinkhole = ip
+	}
+
+	for _, domain := range domains {
+		if err := b.add(domain); err != nil {
+			return nil, fmt.Errorf("invalid HTTP_PROXY_DNS_BLOCKLIST: %w", err)
+		}
+	}
+	if file != "" {
+		if err := b.load(file, log); err != nil {
+			log.Warn("Failed to load DNS blocklist file", "file", file, "error", err)
+		}
+	}
+
+	if len(b.domains) == 0 && file == "" {
+		return nil, nil
+	}
+	return b, nil
+}
+
+// load reads a blocklist file: one domain per line, or hosts file lines
+// ("0.0.0.0 tracker.example"), as published blocklists are. Blank lines and
+// comments starting with # are ignored, and so are the local names of a hosts
+// file header; invalid names are logged and skipped.
+func (b *domainBlocklist) load(path string, log *logger.Logger) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read blocklist: %w", err)
+	}
+	for n, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// Hosts file lines start with the address the names resolve to,
+		// possibly zoned (fe80::1%lo0)
+		if _, err := netip.ParseAddr(fields[0]); err == nil {
+			fields = fields[1:]
+		}
+		for _, domain := range fields {
+			if hostsLocalName(domain) {
+				continue
+			}
+			if err := b.add(domain); err != nil {
+				log.Warn("Skipping invalid DNS blocklist entry", "file", path, "line", n+1, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// hostsLocalName reports whether a hosts file name is local to the machine,
+// like those of the header published blocklists start with: localhost,
+// broadcasthost, ip6-* names, addresses and any other single-label name.
+func hostsLocalName(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if _, err := netip.ParseAddr(name); err == nil {
+		return true
+	}
+	return name == "localhost.localdomain" || strings.HasPrefix(name, "ip6-") || !strings.Contains(name, ".")
+}
+
+// add blocks a domain; a leading "*." is accepted, since subdomains are
+// blocked anyway.
+func (b *domainBlocklist) add(domain string) error {
+	domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "*.")
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" || strings.ContainsAny(domain, "/:@% ") {
+		return fmt.Errorf("invalid domain %q", domain)
+	}
+	// localhost is never blocked
+	if domain == "localhost" {
+		return nil
+	}
+	b.domains[domain] = true
+	return nil
+}
+
+// blocked reports whether name is a blocked domain or below one.
+func (b *domainBlocklist) blocked(name string) bool {
+	if b == nil {
+		return false
+	}
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for {
+		if b.domains[name] {
+			return true
+		}
+		_, parent, found := strings.Cut(name, ".")
+		if !found {
+			return false
+		}
+		name = parent
+	}
+}
+
+// blocks reports whether a query asks for a blocked name.
+func (b *domainBlocklist) blocks(r *dns.Msg) bool {
+	for _, question := range r.Question {
+		if b.blocked(question.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// answer answers a query for blocked names: NXDOMAIN, or the sinkhole
+// address for the A or AAAA questions matching its family and no data for
+// the others.
+func (b *domainBlocklist) answer(r *dns.Msg) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.Authoritative = true
+	if b.sinkhole == nil {
+		msg.Rcode = dns.RcodeNameError
+		return msg
+	}
+
+	for _, question := range r.Question {
+		hdr := dns.RR_Header{Name: question.Name, Class: dns.ClassINET, Ttl: defaultRecordTTL}
+		switch ip4 := b.sinkhole.To4(); {
+		case question.Qtype == dns.TypeA && ip4 != nil:
+			hdr.Rrtype = dns.TypeA
+			msg.Answer = append(msg.Answer, &dns.A{Hdr: hdr, A: ip4})
+		case question.Qtype == dns.TypeAAAA && ip4 == nil:
+			hdr.Rrtype = dns.TypeAAAA
+			msg.Answer = append(msg.Answer, &dns.AAAA{Hdr: hdr, AAAA: b.sinkhole})
+		}
+	}
+	return msg
+}
+
+// files returns the blocklist file to watch, if any.
+func (b *domainBlocklist) files() []string {
+	if b == nil || b.file == "" {
+		return nil
+	}
+	return []string{b.file}
+}
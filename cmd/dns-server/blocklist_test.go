@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+func TestDomainBlocklist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "blocklist.txt")
+	content := "# trackers\nads.example\n0.0.0.0 metrics.example cdn.metrics.example # hosts format\n127.0.0.1 localhost\n*.pixel.example\n"
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := newDomainBlocklist([]string{"Analytics.Example."}, file, "nxdomain", logger.New("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"analytics.example.", true},
+		{"www.analytics.example.", true},
+		{"ADS.example.", true},
+		{"metrics.example.", true},
+		{"a.pixel.example.", true},
+		{"pixel.example.", true},
+		{"localhost.", false},
+		{"example.", false},
+		{"notads.example.", false},
+	}
+	for _, tt := range tests {
+		if got := b.blocked(tt.name); got != tt.want {
+			t.Errorf("blocked(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDomainBlocklistHostsFileHeader(t *testing.T) {
+	// The header of the StevenBlack/hosts list, followed by a bad line
+	file := filepath.Join(t.TempDir(), "hosts")
+	content := `# Title: StevenBlack/hosts
+127.0.0.1 localhost
+127.0.0.1 localhost.localdomain
+127.0.0.1 local
+255.255.255.255 broadcasthost
+::1 localhost
+::1 ip6-localhost
+::1 ip6-loopback
+fe80::1%lo0 localhost
+ff00::0 ip6-localnet
+ff00::0 ip6-mcastprefix
+ff02::1 ip6-allnodes
+ff02::2 ip6-allrouters
+ff02::3 ip6-allhosts
+0.0.0.0 0.0.0.0
+# Custom host records are listed here.
+
+# End of custom host records.
+0.0.0.0 ads.example
+0.0.0.0 http://bad.example
+0.0.0.0 tracker.example
+`
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := newDomainBlocklist(nil, file, "nxdomain", logger.New("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.domains) != 2 || !b.blocked("ads.example.") || !b.blocked("tracker.example.") {
+		t.Errorf("domains = %v, want ads.example and tracker.example", b.domains)
+	}
+}
+
+func TestNewDomainBlocklistValidation(t *testing.T) {
+	log := logger.New("test")
+	if b, err := newDomainBlocklist(nil, "", "nxdomain", log); b != nil || err != nil {
+		t.Errorf("empty blocklist = %v, %v, want nil", b, err)
+	}
+	if _, err := newDomainBlocklist([]string{"ads.example"}, "", "sinkhole", log); err == nil {
+		t.Error("accepted a block response that is neither nxdomain nor an IP")
+	}
+	// A missing file is logged, the other domains are still blocked
+	b, err := newDomainBlocklist([]string{"ads.example"}, filepath.Join(t.TempDir(), "missing.txt"), "", log)
+	if err != nil || !b.blocked("ads.example.") {
+		t.Errorf("blocklist with a missing file = %v, %v, want ads.example blocked", b, err)
+	}
+	if _, err := newDomainBlocklist([]string{"http://ads.example"}, "", "", log); err == nil {
+		t.Error("accepted a URL as a domain")
+	}
+}
+
+func TestResolveBlockedDomain(t *testing.T) {
+	tests := []struct {
+		name      string
+		response  string
+		qtype     uint16
+		wantRcode int
+		wantIP    string
+	}{
+		{name: "nxdomain", response: "nxdomain", qtype: dns.TypeA, wantRcode: dns.RcodeNameError},
+		{name: "sinkhole A", response: "0.0.0.0", qtype: dns.TypeA, wantRcode: dns.RcodeSuccess, wantIP: "0.0.0.0"},
+		{name: "sinkhole AAAA without IPv6", response: "0.0.0.0", qtype: dns.TypeAAAA, wantRcode: dns.RcodeSuccess},
+		{name: "IPv6 sinkhole", response: "::", qtype: dns.TypeAAAA, wantRcode: dns.RcodeSuccess, wantIP: "::"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocklist, err := newDomainBlocklist([]string{"tracker.example"}, "", tt.response, logger.New("test"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Forwarding is on: a blocked name must not reach the upstreams
+			s := &DNSServer{customDomains: []string{"loc"}, forwardEnabled: true, blocklist: blocklist, logger: logger.New("test")}
+			query := new(dns.Msg)
+			query.SetQuestion("www.tracker.example.", tt.qtype)
+
+			resp, source := s.resolve(netip.Addr{}, query)
+			if source != sourceBlocked {
+				t.Fatalf("source = %q, want %q", source, sourceBlocked)
+			}
+			if resp.Rcode != tt.wantRcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			var ip string
+			switch rr := firstAnswer(resp).(type) {
+			case *dns.A:
+				ip = rr.A.String()
+			case *dns.AAAA:
+				ip = rr.AAAA.String()
+			}
+			if ip != tt.wantIP {
+				t.Errorf("answer = %q, want %q", ip, tt.wantIP)
+			}
+		})
+	}
+}
+
+// firstAnswer returns the first answer record of a response, if any.
+func firstAnswer(resp *dns.Msg) dns.RR {
+	if len(resp.Answer) == 0 {
+		return nil
+	}
+	return resp.Answer[0]
+}
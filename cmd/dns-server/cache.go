@@ -0,0 +1,472 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
+
+// cacheFilePermissions is the mode of the persisted cache file
+const cacheFilePermissions = 0644
+
+// staleAnswerTTL is the TTL of stale answers, as RFC 8767 recommends, so
+// clients ask again soon after the upstreams are back
+const staleAnswerTTL = 30
+
+// cacheKey identifies a cached response by its question.
+type cacheKey struct {
+	Name   string
+	Qtype  uint16
+	Qclass uint16
+}
+
+// cacheEntry is a cached upstream response and its validity window.
+type cacheEntry struct {
+	msg     *dns.Msg
+	stored  time.Time
+	expires time.Time
+}
+
+// dnsCache caches responses forwarded from upstream servers, negative ones
+// included, honouring the TTLs they returned clamped to [ttlFloor,
+// ttlCeiling] (0 disables a bound). Expired entries are kept for staleFor,
+// to answer while the upstreams fail (RFC 8767). When maxEntries is reached,
+// entries past that window are purged and then the entry closest to expiry
+// is evicted. It is safe for concurrent use.
+type dnsCache struct {
+	mu         sync.Mutex
+	entries    map[cacheKey]cacheEntry
+	maxEntries int
+	ttlFloor   uint32
+	ttlCeiling uint32
+	staleFor   time.Duration
+	refreshing map[cacheKey]bool
+	now        func() time.Time
+
+	lookups   *metrics.Vec
+	evictions *metrics.Vec
+	size      *metrics.Vec
+}
+
+// newDNSCache creates a cache holding up to maxEntries responses (0 means
+// unlimited) and registers its metrics in registry.
+func newDNSCache(maxEntries int, registry *metrics.Registry) *dnsCache {
+	return &dnsCache{
+		entries:    make(map[cacheKey]cacheEntry),
+		refreshing: make(map[cacheKey]bool),
+		maxEntries: maxEntries,
+		now:        time.Now,
+		lookups:    registry.Counter("http_proxy_dns_cache_lookups_total", "Forwarded queries looked up in the cache, by result (hit, miss or stale).", "result"),
+		evictions:  registry.Counter("http_proxy_dns_cache_evictions_total", "Fresh entries evicted because the cache was full."),
+		size:       registry.Gauge("http_proxy_dns_cache_entries", "Entries in the forwarding cache."),
+	}
+}
+
+// setTTLBounds clamps the TTLs of cached responses to [floor, ceiling]
+// seconds, so upstreams answering with TTL 0 do not defeat the cache and
+// long TTLs do not pin stale answers. 0 disables a bound.
+func (c *dnsCache) setTTLBounds(floor, ceiling int) error {
+	if floor < 0 || ceiling < 0 {
+		return fmt.Errorf("TTL bounds cannot be negative")
+	}
+	if ceiling > 0 && floor > ceiling {
+		return fmt.Errorf("minimum TTL %d exceeds maximum TTL %d", floor, ceiling)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttlFloor, c.ttlCeiling = uint32(floor), uint32(ceiling)
+	return nil
+}
+
+// setStaleWindow keeps expired entries for seconds, to answer with them when
+// the upstreams fail. 0 disables stale answers.
+func (c *dnsCache) setStaleWindow(seconds int) error {
+	if seconds < 0 {
+		return fmt.Errorf("stale window cannot be negative")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.staleFor = time.Duration(seconds) * time.Second
+	return nil
+}
+
+// dead reports whether an entry is past its stale window and can be dropped.
+// The caller holds c.mu.
+func (c *dnsCache) dead(entry cacheEntry, now time.Time) bool {
+	return !now.Before(entry.expires.Add(c.staleFor))
+}
+
+// clampTTL applies the TTL bounds to ttl.
+func (c *dnsCache) clampTTL(ttl uint32) uint32 {
+	if ttl < c.ttlFloor {
+		ttl = c.ttlFloor
+	}
+	if c.ttlCeiling > 0 && ttl > c.ttlCeiling {
+		ttl = c.ttlCeiling
+	}
+	return ttl
+}
+
+// keyFor returns the cache key of a single-question query.
+func keyFor(r *dns.Msg) (cacheKey, bool) {
+	if len(r.Question) != 1 {
+		return cacheKey{}, false
+	}
+	q := r.Question[0]
+	return cacheKey{Name: strings.ToLower(q.Name), Qtype: q.Qtype, Qclass: q.Qclass}, true
+}
+
+// get returns a cached response for the query with TTLs reduced by the time
+// spent in the cache, or nil when there is no fresh entry.
+func (c *dnsCache) get(r *dns.Msg) *dns.Msg {
+	key, ok := keyFor(r)
+	if !ok {
+		return nil
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	now := c.now()
+	if ok && !now.Before(entry.expires) {
+		if c.dead(entry, now) {
+			delete(c.entries, key)
+			c.size.Set(float64(len(c.entries)))
+		}
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	resp := entry.msg.Copy()
+	resp.Id = r.Id
+	resp.Question = r.Question
+	ageRecords(resp, uint32(now.Sub(entry.stored)/time.Second))
+	return resp
+}
+
+// countLookup records how a forwarded query was answered: "hit" from the
+// cache, "stale" from the stale window or "miss" by the upstreams. Callers
+// count each query once, by its final outcome.
+func (c *dnsCache) countLookup(result string) {
+	c.lookups.Inc(result)
+}
+
+// getStale returns the expired response cached for the query while it is
+// in the stale window, with every TTL set to staleAnswerTTL, or nil.
+func (c *dnsCache) getStale(r *dns.Msg) *dns.Msg {
+	entry, ok := c.staleEntry(r)
+	if !ok {
+		return nil
+	}
+
+	resp := entry.msg.Copy()
+	resp.Id = r.Id
+	resp.Question = r.Question
+	for _, rr := range records(resp) {
+		rr.Header().Ttl = staleAnswerTTL
+	}
+	return resp
+}
+
+// staleEntry returns the entry of the query when it expired and is in its
+// stale window.
+func (c *dnsCache) staleEntry(r *dns.Msg) (cacheEntry, bool) {
+	key, ok := keyFor(r)
+	if !ok {
+		return cacheEntry{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	now := c.now()
+	return entry, ok && !now.Before(entry.expires) && !c.dead(entry, now)
+}
+
+// startRefresh marks the query as being refreshed in the background and
+// reports whether it was not already.
+func (c *dnsCache) startRefresh(r *dns.Msg) bool {
+	key, ok := keyFor(r)
+	if !ok {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refreshing[key] {
+		return false
+	}
+	c.refreshing[key] = true
+	return true
+}
+
+// endRefresh clears the mark set by startRefresh.
+func (c *dnsCache) endRefresh(r *dns.Msg) {
+	if key, ok := keyFor(r); ok {
+		c.mu.Lock()
+		delete(c.refreshing, key)
+		c.mu.Unlock()
+	}
+}
+
+// isRefreshing reports whether the query is being refreshed in the
+// background, which means its upstreams failed moments ago.
+func (c *dnsCache) isRefreshing(r *dns.Msg) bool {
+	key, ok := keyFor(r)
+	if !ok {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.refreshing[key]
+}
+
+// set caches an upstream response to the query. Successful answers are
+// cached, and so are negative ones (NXDOMAIN and NODATA) carrying the zone's
+// SOA, for the SOA's negative TTL (RFC 2308). Truncated and failed responses,
+// negative ones without SOA, and those with a zero TTL after clamping are not
+// cached. The cached copy carries the clamped TTLs.
+func (c *dnsCache) set(r, resp *dns.Msg) {
+	key, ok := keyFor(r)
+	if !ok || resp.Truncated {
+		return
+	}
+	negative := isNegative(resp)
+	if !negative && (resp.Rcode != dns.RcodeSuccess || len(resp.Answer) == 0) {
+		return
+	}
+
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msg := resp.Copy()
+	if negative {
+		// The negative TTL is the lower of the SOA's TTL and MINIMUM field
+		soa := negativeSOA(msg)
+		soa.Hdr.Ttl = min(soa.Hdr.Ttl, soa.Minttl)
+	}
+	for _, rr := range records(msg) {
+		rr.Header().Ttl = c.clampTTL(rr.Header().Ttl)
+	}
+	ttl := minTTL(msg)
+	if ttl == 0 {
+		return
+	}
+
+	c.makeRoom(key, now)
+	c.entries[key] = cacheEntry{
+		msg:     msg,
+		stored:  now,
+		expires: now.Add(time.Duration(ttl) * time.Second),
+	}
+	c.size.Set(float64(len(c.entries)))
+}
+
+// isNegative reports whether resp is a cacheable negative answer: NXDOMAIN
+// or NODATA with the zone's SOA in the authority section.
+func isNegative(resp *dns.Msg) bool {
+	if resp.Rcode != dns.RcodeNameError && (resp.Rcode != dns.RcodeSuccess || len(resp.Answer) > 0) {
+		return false
+	}
+	return negativeSOA(resp) != nil
+}
+
+// negativeSOA returns the SOA record in the authority section, or nil.
+func negativeSOA(msg *dns.Msg) *dns.SOA {
+	for _, rr := range msg.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa
+		}
+	}
+	return nil
+}
+
+// makeRoom frees a slot for key when the cache is full: entries past their
+// stale window are purged first, then the entry expiring soonest is evicted.
+// The caller holds c.mu.
+func (c *dnsCache) makeRoom(key cacheKey, now time.Time) {
+	if _, exists := c.entries[key]; exists || c.maxEntries <= 0 || len(c.entries) < c.maxEntries {
+		return
+	}
+
+	for k, entry := range c.entries {
+		if c.dead(entry, now) {
+			delete(c.entries, k)
+		}
+	}
+
+	for len(c.entries) >= c.maxEntries {
+		var victim cacheKey
+		var soonest time.Time
+		for k, entry := range c.entries {
+			if soonest.IsZero() || entry.expires.Before(soonest) {
+				victim, soonest = k, entry.expires
+			}
+		}
+		delete(c.entries, victim)
+		// Expired entries still kept for the stale window are no loss
+		if now.Before(soonest) {
+			c.evictions.Inc()
+		}
+	}
+}
+
+// len returns the number of cached entries, including expired ones not yet purged.
+func (c *dnsCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// records returns every resource record of a message except the OPT pseudo-record.
+func records(msg *dns.Msg) []dns.RR {
+	var result []dns.RR
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				result = append(result, rr)
+			}
+		}
+	}
+	return result
+}
+
+// minTTL returns the lowest TTL among the records of a message.
+func minTTL(msg *dns.Msg) uint32 {
+	rrs := records(msg)
+	if len(rrs) == 0 {
+		return 0
+	}
+	ttl := rrs[0].Header().Ttl
+	for _, rr := range rrs[1:] {
+		ttl = min(ttl, rr.Header().Ttl)
+	}
+	return ttl
+}
+
+// ageRecords reduces the TTL of every record by elapsed seconds.
+func ageRecords(msg *dns.Msg, elapsed uint32) {
+	for _, rr := range records(msg) {
+		hdr := rr.Header()
+		if hdr.Ttl > elapsed {
+			hdr.Ttl -= elapsed
+		} else {
+			hdr.Ttl = 0
+		}
+	}
+}
+
+// persistedEntry is the on-disk form of a cache entry. The response is
+// stored in wire format so any record type round-trips unchanged.
+type persistedEntry struct {
+	Name    string    `json:"name"`
+	Qtype   uint16    `json:"qtype"`
+	Qclass  uint16    `json:"qclass"`
+	Stored  time.Time `json:"stored"`
+	Expires time.Time `json:"expires"`
+	Msg     []byte    `json:"msg"`
+}
+
+// save writes the cache entries that are fresh or in their stale window to
+// path, replacing it atomically.
+func (c *dnsCache) save(path string) (int, error) {
+	now := c.now()
+
+	c.mu.Lock()
+	var persisted []persistedEntry
+	for key, entry := range c.entries {
+		if c.dead(entry, now) {
+			continue
+		}
+		packed, err := entry.msg.Pack()
+		if err != nil {
+			continue
+		}
+		persisted = append(persisted, persistedEntry{
+			Name:    key.Name,
+			Qtype:   key.Qtype,
+			Qclass:  key.Qclass,
+			Stored:  entry.stored,
+			Expires: entry.expires,
+			Msg:     packed,
+		})
+	}
+	c.mu.Unlock()
+
+	data, err := json.Marshal(persisted)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal DNS cache: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create DNS cache directory: %w", err)
+	}
+
+	tmpFile := path + ".tmp"
+	if err := os.WriteFile(tmpFile, data, cacheFilePermissions); err != nil {
+		return 0, fmt.Errorf("failed to write DNS cache: %w", err)
+	}
+	if err := os.Rename(tmpFile, path); err != nil {
+		os.Remove(tmpFile)
+		return 0, fmt.Errorf("failed to rename DNS cache: %w", err)
+	}
+
+	return len(persisted), nil
+}
+
+// load restores entries saved by save, skipping any that left their stale
+// window while the server was down. A missing file is not an error.
+func (c *dnsCache) load(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read DNS cache: %w", err)
+	}
+
+	var persisted []persistedEntry
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		return 0, fmt.Errorf("failed to parse DNS cache: %w", err)
+	}
+
+	now := c.now()
+	loaded := 0
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range persisted {
+		if c.dead(cacheEntry{expires: p.Expires}, now) {
+			continue
+		}
+		if c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+			break
+		}
+		msg := new(dns.Msg)
+		if err := msg.Unpack(p.Msg); err != nil {
+			continue
+		}
+		c.entries[cacheKey{Name: p.Name, Qtype: p.Qtype, Qclass: p.Qclass}] = cacheEntry{
+			msg:     msg,
+			stored:  p.Stored,
+			expires: p.Expires,
+		}
+		loaded++
+	}
+	c.size.Set(float64(len(c.entries)))
+
+	return loaded, nil
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
+
+// fakeClock is a settable time source for cache tests.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func testCache() (*dnsCache, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := newDNSCache(0, metrics.NewRegistry())
+	c.now = clock.now
+	return c, clock
+}
+
+func upstreamResponse(name string, ttl uint32) (*dns.Msg, *dns.Msg) {
+	query := new(dns.Msg)
+	query.SetQuestion(name, dns.TypeA)
+
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	resp.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+		A:   net.ParseIP("93.184.216.34"),
+	}}
+	return query, resp
+}
+
+func TestDNSCacheGetAgesTTL(t *testing.T) {
+	c, clock := testCache()
+	query, resp := upstreamResponse("example.com.", 300)
+	c.set(query, resp)
+
+	clock.t = clock.t.Add(100 * time.Second)
+
+	// A later query with a different ID and case is served from the cache
+	again := new(dns.Msg)
+	again.SetQuestion("EXAMPLE.com.", dns.TypeA)
+
+	cached := c.get(again)
+	if cached == nil {
+		t.Fatal("expected a cache hit")
+	}
+	if cached.Id != again.Id {
+		t.Errorf("Id = %d, want %d", cached.Id, again.Id)
+	}
+	if cached.Question[0].Name != "EXAMPLE.com." {
+		t.Errorf("question not copied from query: %v", cached.Question)
+	}
+	if ttl := cached.Answer[0].Header().Ttl; ttl != 200 {
+		t.Errorf("TTL = %d, want 200", ttl)
+	}
+	if ttl := resp.Answer[0].Header().Ttl; ttl != 300 {
+		t.Errorf("stored response was modified, TTL = %d", ttl)
+	}
+}
+
+func TestDNSCacheExpires(t *testing.T) {
+	c, clock := testCache()
+	query, resp := upstreamResponse("example.com.", 60)
+	c.set(query, resp)
+
+	clock.t = clock.t.Add(60 * time.Second)
+	if c.get(query) != nil {
+		t.Error("expected expired entry to miss")
+	}
+	if c.len() != 0 {
+		t.Errorf("expired entry not purged, len = %d", c.len())
+	}
+}
+
+func TestDNSCacheSkipsUncacheable(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*dns.Msg)
+	}{
+		{"zero ttl", func(m *dns.Msg) { m.Answer[0].Header().Ttl = 0 }},
+		{"truncated", func(m *dns.Msg) { m.Truncated = true }},
+		{"servfail", func(m *dns.Msg) { m.Rcode = dns.RcodeServerFailure }},
+		{"no answer", func(m *dns.Msg) { m.Answer = nil }},
+		{"nxdomain without soa", func(m *dns.Msg) { m.Answer = nil; m.Rcode = dns.RcodeNameError }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := testCache()
+			query, resp := upstreamResponse("example.com.", 300)
+			tt.modify(resp)
+			c.set(query, resp)
+			if c.len() != 0 {
+				t.Error("response should not be cached")
+			}
+		})
+	}
+}
+
+func TestDNSCacheNegativeAnswers(t *testing.T) {
+	tests := []struct {
+		name  string
+		rcode int
+	}{
+		{"nxdomain", dns.RcodeNameError},
+		{"nodata", dns.RcodeSuccess},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, clock := testCache()
+			query, resp := upstreamResponse("missing.example.com.", 300)
+			resp.Rcode = tt.rcode
+			resp.Answer = nil
+			resp.Ns = []dns.RR{&dns.SOA{
+				Hdr:    dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+				Ns:     "ns.example.com.",
+				Mbox:   "hostmaster.example.com.",
+				Minttl: 120,
+			}}
+			c.set(query, resp)
+
+			cached := c.get(query)
+			if cached == nil {
+				t.Fatal("expected a cache hit")
+			}
+			if cached.Rcode != tt.rcode {
+				t.Errorf("rcode = %d, want %d", cached.Rcode, tt.rcode)
+			}
+			// Cached for the SOA MINIMUM, lower than its TTL
+			if ttl := cached.Ns[0].Header().Ttl; ttl != 120 {
+				t.Errorf("SOA TTL = %d, want 120", ttl)
+			}
+			clock.t = clock.t.Add(120 * time.Second)
+			if c.get(query) != nil {
+				t.Error("negative answer outlived the SOA MINIMUM")
+			}
+		})
+	}
+}
+
+func TestDNSCacheClampsTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream uint32
+		wantTTL  uint32
+	}{
+		{"zero raised to floor", 0, 30},
+		{"within bounds", 300, 300},
+		{"capped at ceiling", 86400 * 7, 3600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, clock := testCache()
+			if err := c.setTTLBounds(30, 3600); err != nil {
+				t.Fatal(err)
+			}
+			query, resp := upstreamResponse("example.com.", tt.upstream)
+			c.set(query, resp)
+
+			cached := c.get(query)
+			if cached == nil {
+				t.Fatal("expected a cache hit")
+			}
+			if ttl := cached.Answer[0].Header().Ttl; ttl != tt.wantTTL {
+				t.Errorf("TTL = %d, want %d", ttl, tt.wantTTL)
+			}
+			if resp.Answer[0].Header().Ttl != tt.upstream {
+				t.Error("the upstream response was modified")
+			}
+
+			clock.t = clock.t.Add(time.Duration(tt.wantTTL) * time.Second)
+			if c.get(query) != nil {
+				t.Error("entry outlived its clamped TTL")
+			}
+		})
+	}
+}
+
+func TestDNSCacheSetTTLBoundsValidation(t *testing.T) {
+	c, _ := testCache()
+	for _, bounds := range [][2]int{{-1, 0}, {0, -1}, {600, 60}} {
+		if err := c.setTTLBounds(bounds[0], bounds[1]); err == nil {
+			t.Errorf("setTTLBounds(%d, %d) accepted invalid bounds", bounds[0], bounds[1])
+		}
+	}
+	if err := c.setTTLBounds(60, 0); err != nil {
+		t.Errorf("a floor without ceiling must be valid: %v", err)
+	}
+}
+
+func TestDNSCacheSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "dns.json")
+
+	c, clock := testCache()
+	shortQuery, shortResp := upstreamResponse("short.example.", 30)
+	longQuery, longResp := upstreamResponse("long.example.", 600)
+	c.set(shortQuery, shortResp)
+	c.set(longQuery, longResp)
+
+	if n, err := c.save(path); err != nil || n != 2 {
+		t.Fatalf("save = %d, %v; want 2, nil", n, err)
+	}
+
+	// Restart 60s later: the short entry expired while the server was down
+	restored, restoredClock := testCache()
+	restoredClock.t = clock.t.Add(60 * time.Second)
+
+	n, err := restored.load(path)
+	if err != nil || n != 1 {
+		t.Fatalf("load = %d, %v; want 1, nil", n, err)
+	}
+	if restored.get(shortQuery) != nil {
+		t.Error("expired entry was restored")
+	}
+
+	cached := restored.get(longQuery)
+	if cached == nil {
+		t.Fatal("expected restored entry to hit")
+	}
+	if ttl := cached.Answer[0].Header().Ttl; ttl != 540 {
+		t.Errorf("TTL = %d, want 540", ttl)
+	}
+}
+
+func TestDNSCacheLoadMissingFile(t *testing.T) {
+	c, _ := testCache()
+	if n, err := c.load(filepath.Join(t.TempDir(), "missing.json")); err != nil || n != 0 {
+		t.Errorf("load = %d, %v; want 0, nil", n, err)
+	}
+}
+
+func TestDNSCacheCountsHitsAndMisses(t *testing.T) {
+	c, _ := testCache()
+	if err := c.setTTLBounds(60, 3600); err != nil {
+		t.Fatal(err)
+	}
+	s := &DNSServer{
+		logger:         logger.New("test"),
+		forwardEnabled: true,
+		cache:          c,
+		upstreams:      newTestUpstreamPool(&fakeUpstreams{up: map[string]time.Duration{"upstream:53": 0}}, upstreamStrategySequential, "upstream:53"),
+		ctx:            t.Context(),
+	}
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeTXT)
+
+	for range 3 {
+		s.resolveNonMatchingDomain(query)
+	}
+
+	if got := c.lookups.Value("miss"); got != 1 {
+		t.Errorf("misses = %v, want 1", got)
+	}
+	if got := c.lookups.Value("hit"); got != 2 {
+		t.Errorf("hits = %v, want 2", got)
+	}
+	if got := c.size.Value(); got != 1 {
+		t.Errorf("entries gauge = %v, want 1", got)
+	}
+}
+
+func TestDNSCacheEvictsWhenFull(t *testing.T) {
+	c, clock := testCache()
+	c.maxEntries = 2
+
+	shortQuery, shortResp := upstreamResponse("short.example.", 10)
+	longQuery, longResp := upstreamResponse("long.example.", 600)
+	c.set(shortQuery, shortResp)
+	c.set(longQuery, longResp)
+
+	// Full: the entry closest to expiry makes room
+	newQuery, newResp := upstreamResponse("new.example.", 300)
+	c.set(newQuery, newResp)
+	if c.len() != 2 || c.get(shortQuery) != nil || c.get(longQuery) == nil || c.get(newQuery) == nil {
+		t.Fatalf("unexpected entries after eviction: %d", c.len())
+	}
+	if got := c.evictions.Value(); got != 1 {
+		t.Errorf("evictions = %v, want 1", got)
+	}
+
+	// Expired entries are purged before anything fresh is evicted
+	clock.t = clock.t.Add(400 * time.Second)
+	otherQuery, otherResp := upstreamResponse("other.example.", 300)
+	c.set(otherQuery, otherResp)
+	if c.get(longQuery) == nil || c.get(otherQuery) == nil {
+		t.Error("fresh entries were evicted while an expired one was present")
+	}
+	if got := c.evictions.Value(); got != 1 {
+		t.Errorf("evictions = %v, want still 1", got)
+	}
+
+	// Refreshing an existing key never evicts
+	c.set(longQuery, longResp)
+	if got := c.evictions.Value(); got != 1 {
+		t.Errorf("evictions = %v after refreshing a key", got)
+	}
+
+	// Dropping an expired entry kept for the stale window is not counted
+	if err := c.setStaleWindow(3600); err != nil {
+		t.Fatal(err)
+	}
+	clock.t = clock.t.Add(400 * time.Second)
+	c.set(newQuery, newResp)
+	if c.get(newQuery) == nil || c.len() != 2 {
+		t.Fatalf("unexpected entries after evicting a stale one: %d", c.len())
+	}
+	if got := c.evictions.Value(); got != 1 {
+		t.Errorf("evictions = %v after dropping a stale entry, want still 1", got)
+	}
+}
+
+func TestDNSCacheServesStale(t *testing.T) {
+	c, clock := testCache()
+	if err := c.setStaleWindow(3600); err != nil {
+		t.Fatal(err)
+	}
+	query, resp := upstreamResponse("example.com.", 60)
+	c.set(query, resp)
+
+	// Expired: no longer a hit, but still served as stale with a short TTL
+	clock.t = clock.t.Add(60 * time.Second)
+	if c.get(query) != nil {
+		t.Fatal("expired entry returned as a hit")
+	}
+	stale := c.getStale(query)
+	if stale == nil {
+		t.Fatal("expired entry inside the stale window not served")
+	}
+	if got := stale.Answer[0].Header().Ttl; got != staleAnswerTTL {
+		t.Errorf("stale TTL = %d, want %d", got, staleAnswerTTL)
+	}
+
+	// One refresh at a time
+	if !c.startRefresh(query) || c.startRefresh(query) || !c.isRefreshing(query) {
+		t.Error("refresh of a query not deduplicated")
+	}
+	c.endRefresh(query)
+	if c.isRefreshing(query) {
+		t.Error("query still refreshing after endRefresh")
+	}
+
+	// Past the stale window the entry is gone
+	clock.t = clock.t.Add(3600 * time.Second)
+	if c.getStale(query) != nil {
+		t.Error("entry served past the stale window")
+	}
+	if err := c.setStaleWindow(-1); err == nil {
+		t.Error("negative stale window accepted")
+	}
+}
+
+func TestResolveServesStaleWhileUpstreamsFail(t *testing.T) {
+	interval := staleRefreshInterval
+	staleRefreshInterval = 10 * time.Millisecond
+	t.Cleanup(func() { staleRefreshInterval = interval })
+
+	c, clock := testCache()
+	if err := c.setTTLBounds(60, 3600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.setStaleWindow(3600); err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeUpstreams{up: map[string]time.Duration{"upstream:53": 0}}
+	s := &DNSServer{
+		logger:         logger.New("test"),
+		forwardEnabled: true,
+		cache:          c,
+		upstreams:      newTestUpstreamPool(f, upstreamStrategySequential, "upstream:53"),
+		ctx:            t.Context(),
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeTXT)
+	if _, source := s.resolveNonMatchingDomain(query); source != sourceForwarded {
+		t.Fatalf("source = %q, want %q", source, sourceForwarded)
+	}
+
+	// The upstream goes down after the answer expired
+	clock.t = clock.t.Add(60 * time.Second)
+	f.mu.Lock()
+	f.up = map[string]time.Duration{}
+	f.mu.Unlock()
+	if _, source := s.resolveNonMatchingDomain(query); source != sourceStale {
+		t.Fatalf("source = %q, want %q", source, sourceStale)
+	}
+	if _, source := s.resolveNonMatchingDomain(query); source != sourceStale {
+		t.Fatalf("source while refreshing = %q, want %q", source, sourceStale)
+	}
+	// Each query is counted once, by how it was answered
+	if miss, stale := c.lookups.Value("miss"), c.lookups.Value("stale"); miss != 1 || stale != 2 {
+		t.Errorf("lookups miss=%v stale=%v, want 1 and 2", miss, stale)
+	}
+
+	// The background refresh caches the answer once the upstream is back
+	f.mu.Lock()
+	f.up = map[string]time.Duration{"upstream:53": 0}
+	f.mu.Unlock()
+	deadline := time.Now().Add(2 * time.Second)
+	for c.get(query) == nil || c.isRefreshing(query) {
+		if time.Now().After(deadline) {
+			t.Fatal("stale entry not refreshed after the upstream recovered")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, source := s.resolveNonMatchingDomain(query); source != sourceCache {
+		t.Errorf("source after refresh = %q, want %q", source, sourceCache)
+	}
+}
+
+func TestRefreshStaleStopsWithContext(t *testing.T) {
+	interval := staleRefreshInterval
+	staleRefreshInterval = 10 * time.Millisecond
+	t.Cleanup(func() { staleRefreshInterval = interval })
+
+	c, clock := testCache()
+	if err := c.setStaleWindow(3600); err != nil {
+		t.Fatal(err)
+	}
+	s := &DNSServer{
+		logger:    logger.New("test"),
+		cache:     c,
+		upstreams: newTestUpstreamPool(&fakeUpstreams{up: map[string]time.Duration{}}, upstreamStrategySequential, "upstream:53"),
+	}
+
+	// The entry stays stale for the whole test, so only ctx ends the refresh
+	query, resp := upstreamResponse("example.com.", 60)
+	c.set(query, resp)
+	clock.t = clock.t.Add(120 * time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.refreshStale(ctx, query)
+	if !c.isRefreshing(query) {
+		t.Fatal("refresh not started")
+	}
+	cancel()
+	deadline := time.Now().Add(2 * time.Second)
+	for c.isRefreshing(query) {
+		if time.Now().After(deadline) {
+			t.Fatal("refresh still running after the context was cancelled")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// clientOverride is where the names of a domain resolve for the clients of
+// a network. An empty domain covers every configured domain.
+type clientOverride struct {
+	clients netip.Prefix
+	domain  string
+	target  domainTarget
+}
+
+// clientMap holds the per-client targets of HTTP_PROXY_DNS_CLIENT_MAP, most
+// specific first.
+type clientMap []clientOverride
+
+// parseClientMap parses HTTP_PROXY_DNS_CLIENT_MAP: comma-separated
+// "[<domain>@]<client>=<ip>" entries, the client given as an address or a
+// CIDR, e.g.
+//
+//	192.168.1.50=192.168.1.10,api.loc@10.0.0.0/8=10.0.0.5,api.loc@10.0.0.0/8=fd00::5
+//
+// Like HTTP_PROXY_DNS_DOMAIN_MAP, an override takes one IPv4 address and
+// optionally one IPv6 address, given as two entries.
+func parseClientMap(spec string) (clientMap, error) {
+	type key struct {
+		clients netip.Prefix
+		domain  string
+	}
+	targets := make(map[key]domainTarget)
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		client, value, ok := strings.Cut(entry, "=")
+		domain, client, scoped := strings.Cut(strings.TrimSpace(client), "@")
+		if !scoped {
+			domain, client = "", domain
+		}
+		domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+		ip := net.ParseIP(strings.TrimSpace(value))
+		if !ok || ip == nil || (scoped && domain == "") {
+			return nil, fmt.Errorf("invalid client mapping %q: expected \"[<domain>@]<client>=<ip>\"", entry)
+		}
+		prefixes, err := parsePrefixes([]string{strings.TrimSpace(client)})
+		if err != nil {
+			return nil, fmt.Errorf("invalid client mapping %q: %w", entry, err)
+		}
+
+		k := key{clients: prefixes[0], domain: domain}
+		target := targets[k]
+		if ip.To4() != nil {
+			if target.ipv4 != "" {
+				return nil, fmt.Errorf("invalid client mapping %q: %s already maps to %s", entry, client, target.ipv4)
+			}
+			target.ipv4 = ip.String()
+		} else {
+			if target.ipv6 != "" {
+				return nil, fmt.Errorf("invalid client mapping %q: %s already maps to %s", entry, client, target.ipv6)
+			}
+			target.ipv6 = ip.String()
+		}
+		targets[k] = target
+	}
+
+	overrides := make(clientMap, 0, len(targets))
+	for k, target := range targets {
+		if target.ipv4 == "" {
+			return nil, fmt.Errorf("invalid client mapping for %s: an IPv4 address is required", k.clients)
+		}
+		overrides = append(overrides, clientOverride{clients: k.clients, domain: k.domain, target: target})
+	}
+
+	// The most specific domain wins, then the smallest network
+	sort.Slice(overrides, func(i, j int) bool {
+		a, b := overrides[i], overrides[j]
+		if la, lb := domainDepth(a.domain), domainDepth(b.domain); la != lb {
+			return la > lb
+		}
+		if a.clients.Bits() != b.clients.Bits() {
+			return a.clients.Bits() > b.clients.Bits()
+		}
+		if a.domain != b.domain {
+			return a.domain < b.domain
+		}
+		return a.clients.String() < b.clients.String()
+	})
+	return overrides, nil
+}
+
+// domainDepth returns the number of labels of a domain, 0 for none.
+func domainDepth(domain string) int {
+	if domain == "" {
+		return 0
+	}
+	return strings.Count(domain, ".") + 1
+}
+
+// lookup returns the target of the most specific override covering client
+// and name. An unknown client has no override.
+func (m clientMap) lookup(client netip.Addr, name string) (domainTarget, bool) {
+	if !client.IsValid() {
+		return domainTarget{}, false
+	}
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for _, override := range m {
+		if !override.clients.Contains(client) {
+			continue
+		}
+		if override.domain == "" || name == override.domain || strings.HasSuffix(name, "."+override.domain) {
+			return override.target, true
+		}
+	}
+	return domainTarget{}, false
+}
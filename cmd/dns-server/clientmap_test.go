@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+func TestParseClientMap(t *testing.T) {
+	overrides, err := parseClientMap(" 192.168.1.0/24=192.168.1.10, App.Loc.@192.168.1.50=192.168.1.20,app.loc@192.168.1.50=FD00::20,,")
+	if err != nil {
+		t.Fatalf("parseClientMap: %v", err)
+	}
+	want := []clientOverride{
+		{clients: netip.MustParsePrefix("192.168.1.50/32"), domain: "app.loc", target: domainTarget{ipv4: "192.168.1.20", ipv6: "fd00::20"}},
+		{clients: netip.MustParsePrefix("192.168.1.0/24"), target: domainTarget{ipv4: "192.168.1.10"}},
+	}
+	if len(overrides) != len(want) {
+		t.Fatalf("overrides = %+v, want %+v", overrides, want)
+	}
+	for i := range want {
+		if overrides[i] != want[i] {
+			t.Errorf("overrides[%d] = %+v, want %+v", i, overrides[i], want[i])
+		}
+	}
+}
+
+func TestParseClientMapErrors(t *testing.T) {
+	tests := []string{
+		"192.168.1.50",
+		"192.168.1.50=",
+		"=192.168.1.10",
+		"phone=192.168.1.10",
+		"@192.168.1.50=192.168.1.10",
+		"192.168.1.50=not-an-ip",
+		"192.168.1.50=192.168.1.10,192.168.1.50=192.168.1.11",
+		"192.168.1.50=fd00::10",
+	}
+	for _, spec := range tests {
+		if _, err := parseClientMap(spec); err == nil {
+			t.Errorf("parseClientMap(%q): expected error", spec)
+		}
+	}
+}
+
+func TestCreateDNSResponseClientMap(t *testing.T) {
+	domains, err := parseDomainMap("api.loc=10.0.0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	overrides, err := parseClientMap("192.168.1.0/24=192.168.1.10,app.loc@192.168.1.50=192.168.1.20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &DNSServer{
+		customDomains: []string{"loc"},
+		targetIP:      "127.0.0.1",
+		domainTargets: domains,
+		clientTargets: overrides,
+		logger:        logger.New("test"),
+	}
+
+	tests := []struct {
+		client string
+		name   string
+		want   string
+	}{
+		{"127.0.0.1", "app.loc.", "127.0.0.1"},
+		{"", "app.loc.", "127.0.0.1"}, // unknown client
+		{"192.168.1.7", "app.loc.", "192.168.1.10"},
+		{"192.168.1.7", "api.loc.", "192.168.1.10"}, // client overrides win over the domain map
+		{"192.168.1.50", "v2.app.loc.", "192.168.1.20"},
+		{"192.168.1.50", "blog.loc.", "192.168.1.10"},
+		{"::ffff:192.168.1.50", "app.loc.", "192.168.1.20"},
+		{"10.1.0.1", "api.loc.", "10.0.0.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.client+"/"+tt.name, func(t *testing.T) {
+			var client netip.Addr
+			if tt.client != "" {
+				client, _ = clientAddr(&net.UDPAddr{IP: net.ParseIP(tt.client)})
+			}
+			query := new(dns.Msg)
+			query.SetQuestion(tt.name, dns.TypeA)
+			resp := s.createDNSResponse(client, query)
+			if len(resp.Answer) != 1 {
+				t.Fatalf("answer = %v, want one record", resp.Answer)
+			}
+			if got := resp.Answer[0].(*dns.A).A.String(); got != tt.want {
+				t.Errorf("resolved to %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
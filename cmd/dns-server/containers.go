@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/service"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// containerRecords tracks the hostnames of running containers, so the DNS
+// server answers them whatever their domain while the container runs. It is
+// a service.EventHandler fed by container start and die events. onChange,
+// when set, is called after the hostnames change.
+type containerRecords struct {
+	dockerClient  *client.Client
+	dockerTimeout time.Duration
+	logger        *logger.Logger
+	onChange      func()
+
+	mu         sync.RWMutex
+	containers map[string][]string // container ID -> hostnames
+	names      map[string]int      // hostname -> number of containers serving it
+}
+
+// newContainerRecords creates an empty set of container records.
+func newContainerRecords() *containerRecords {
+	return &containerRecords{
+		containers: make(map[string][]string),
+		names:      make(map[string]int),
+	}
+}
+
+// GetName returns the service name
+func (c *containerRecords) GetName() string {
+	return "dns-container-records"
+}
+
+// SetDockerTimeout sets the timeout of each Docker API call from the service
+// framework
+func (c *containerRecords) SetDockerTimeout(timeout time.Duration) {
+	c.dockerTimeout = timeout
+}
+
+// SetDependencies sets the Docker client and logger from the service framework
+func (c *containerRecords) SetDependencies(dockerClient *client.Client, logger *logger.Logger) {
+	c.dockerClient = dockerClient
+	c.logger = logger
+}
+
+// HandleInitialScan registers the hostnames of the running containers,
+// replacing any registered before (e.g. when the service restarts).
+func (c *containerRecords) HandleInitialScan(ctx context.Context) error {
+	containers, err := utils.RetryContainerList(ctx, c.dockerClient, c.dockerTimeout, container.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.containers = make(map[string][]string)
+	c.names = make(map[string]int)
+	c.mu.Unlock()
+
+	for _, ctr := range containers {
+		if err := c.register(ctx, ctr.ID); err != nil {
+			c.logger.Warn("Failed to read container hostnames", "container_id", utils.FormatDockerID(ctr.ID), "error", err)
+		}
+	}
+	c.changed()
+	return nil
+}
+
+// HandleEvent registers the hostnames of started containers and removes the
+// ones of stopped containers.
+func (c *containerRecords) HandleEvent(ctx context.Context, event events.Message) error {
+	ev := service.ParseContainerEvent(event)
+	switch ev.Action {
+	case "start":
+		return c.register(ctx, ev.ContainerID)
+	case "die":
+		c.remove(ev.ContainerID)
+		return nil
+	default:
+		c.logger.Debug("Unhandled container action", ev.LogArgs()...)
+		return nil
+	}
+}
+
+// register inspects a container and records its hostnames.
+func (c *containerRecords) register(ctx context.Context, containerID string) error {
+	inspect, err := utils.RetryContainerInspect(ctx, c.dockerClient, c.dockerTimeout, containerID)
+	if err != nil {
+		return err
+	}
+	if inspect.State == nil || !inspect.State.Running {
+		return nil
+	}
+
+	hostnames := inspectHostnames(inspect)
+	if len(hostnames) == 0 {
+		return nil
+	}
+	c.set(containerID, hostnames)
+	c.logger.Info("Registered container DNS records",
+		"container", strings.TrimPrefix(inspect.Name, "/"), "hostnames", hostnames)
+	return nil
+}
+
+// set records the hostnames of a container, replacing its previous ones.
+func (c *containerRecords) set(containerID string, hostnames []string) {
+	c.mu.Lock()
+	c.unsetLocked(containerID)
+	c.containers[containerID] = hostnames
+	for _, hostname := range hostnames {
+		c.names[hostname]++
+	}
+	c.mu.Unlock()
+
+	c.changed()
+}
+
+// remove forgets the hostnames of a container.
+func (c *containerRecords) remove(containerID string) {
+	c.mu.Lock()
+	hostnames := c.unsetLocked(containerID)
+	c.mu.Unlock()
+
+	if len(hostnames) == 0 {
+		return
+	}
+	if c.logger != nil {
+		c.logger.Info("Removed container DNS records", "container_id", utils.FormatDockerID(containerID), "hostnames", hostnames)
+	}
+	c.changed()
+}
+
+// changed calls onChange, if set.
+func (c *containerRecords) changed() {
+	if c.onChange != nil {
+		c.onChange()
+	}
+}
+
+// unsetLocked drops a container's hostnames and returns them. The caller
+// holds c.mu.
+func (c *containerRecords) unsetLocked(containerID string) []string {
+	hostnames := c.containers[containerID]
+	for _, hostname := range hostnames {
+		if c.names[hostname]--; c.names[hostname] <= 0 {
+			delete(c.names, hostname)
+		}
+	}
+	delete(c.containers, containerID)
+	return hostnames
+}
+
+// has reports whether a running container serves the name. It is safe to
+// call on a nil receiver, when container records are disabled.
+func (c *containerRecords) has(name string) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.names[strings.TrimSuffix(strings.ToLower(name), ".")] > 0
+}
+
+// hostnames returns the sorted hostnames of the running containers. It is
+// safe to call on a nil receiver.
+func (c *containerRecords) hostnames() []string {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := make([]string, 0, len(c.names))
+	for name := range c.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// inspectHostnames returns the hostnames a container is routed on.
+func inspectHostnames(inspect types.ContainerJSON) []string {
+	if inspect.Config == nil {
+		return nil
+	}
+	return containerHostnames(utils.GetDockerEnvVar(inspect.Config.Env, "VIRTUAL_HOST"), inspect.Config.Labels)
+}
+
+// containerHostnames returns the hostnames of VIRTUAL_HOST and of the Host()
+// matchers of Traefik router rules that can be answered by name: wildcard
+// hosts are skipped.
+func containerHostnames(virtualHost string, labels map[string]string) []string {
+	var hostnames []string
+	for _, host := range utils.ContainerHostnames(virtualHost, labels) {
+		if !strings.HasPrefix(host, "*.") {
+			hostnames = append(hostnames, host)
+		}
+	}
+	return hostnames
+}
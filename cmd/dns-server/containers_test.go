@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+func TestContainerHostnames(t *testing.T) {
+	tests := []struct {
+		name        string
+		virtualHost string
+		labels      map[string]string
+		want        []string
+	}{
+		{"virtual host with port", "App.example.com:8080, api.loc", nil, []string{"app.example.com", "api.loc"}},
+		{"wildcard and regex skipped", "*.app.loc,~^api\\..*", nil, nil},
+		{
+			"traefik rules",
+			"",
+			map[string]string{
+				"traefik.http.routers.web.rule":                      "Host(`web.test`) || Host(`www.web.test`, `alt.test`)",
+				"traefik.http.routers.api.rule":                      "Host(`api.test`) && PathPrefix(`/v1`)",
+				"traefik.http.routers.regex.rule":                    "HostRegexp(`.+\\.test`)",
+				"traefik.http.services.web.loadbalancer.server.port": "80",
+			},
+			[]string{"api.test", "web.test", "www.web.test", "alt.test"},
+		},
+		{
+			"duplicates across sources",
+			"app.test",
+			map[string]string{"traefik.http.routers.app.rule": "Host(`APP.test`)"},
+			[]string{"app.test"},
+		},
+		{"invalid name skipped", "bad..name", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := containerHostnames(tt.virtualHost, tt.labels); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("containerHostnames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainerRecordsSharedHostname(t *testing.T) {
+	c := newContainerRecords()
+	c.set("a", []string{"app.test", "a.test"})
+	c.set("b", []string{"app.test"})
+
+	c.remove("a")
+	if !c.has("APP.test.") {
+		t.Error("hostname still served by b was removed")
+	}
+	if c.has("a.test.") {
+		t.Error("hostname of a removed container is still answered")
+	}
+
+	// Restarting a container replaces its hostnames
+	c.set("b", []string{"b.test"})
+	if c.has("app.test.") || !c.has("b.test.") {
+		t.Errorf("hostnames not replaced: %v", c.names)
+	}
+
+	var disabled *containerRecords
+	if disabled.has("app.test.") {
+		t.Error("nil records must not answer")
+	}
+}
+
+func TestCreateDNSResponseContainerHostname(t *testing.T) {
+	s := &DNSServer{
+		customDomains: []string{"loc"},
+		targetIP:      "127.0.0.1",
+		containers:    newContainerRecords(),
+		logger:        logger.New("test"),
+	}
+	s.containers.set("a", []string{"app.example.com"})
+
+	if !s.isDomainHandled("app.example.com.") {
+		t.Fatal("container hostname is not handled")
+	}
+	if s.isDomainHandled("other.example.com.") {
+		t.Error("only the container hostname itself must be handled")
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion("app.example.com.", dns.TypeMX)
+	resp := s.createDNSResponse(netip.Addr{}, query)
+	if len(resp.Answer) != 0 || len(resp.Ns) != 1 || resp.Ns[0].Header().Name != "app.example.com." {
+		t.Errorf("MX answer = %v, authority = %v, want NODATA with the hostname's SOA", resp.Answer, resp.Ns)
+	}
+
+	query.SetQuestion("app.example.com.", dns.TypeA)
+	resp = s.createDNSResponse(netip.Addr{}, query)
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "127.0.0.1" {
+		t.Errorf("A answer = %v, want 127.0.0.1", resp.Answer)
+	}
+
+	s.containers.remove("a")
+	if s.isDomainHandled("app.example.com.") {
+		t.Error("hostname of a stopped container is still handled")
+	}
+}
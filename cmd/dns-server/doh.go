@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+const (
+	// dohPath is the RFC 8484 endpoint path
+	dohPath = "/dns-query"
+
+	// dohContentType is the RFC 8484 wire format media type
+	dohContentType = "application/dns-message"
+
+	// dohMaxMessageSize caps DNS messages read from requests
+	dohMaxMessageSize = dns.MaxMsgSize
+)
+
+// dohHandler serves DNS-over-HTTPS (RFC 8484) queries through a DNS handler,
+// so DoH clients get exactly the answers of the UDP/TCP listeners.
+type dohHandler struct {
+	handler dns.HandlerFunc
+	logger  *logger.Logger
+}
+
+// ServeHTTP decodes the query from a GET ?dns= parameter or a POST body,
+// resolves it and writes the wire format answer.
+func (h *dohHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var wire []byte
+	switch r.Method {
+	case http.MethodGet:
+		var err error
+		wire, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		if err != nil || len(wire) == 0 {
+			http.Error(w, "missing or malformed dns parameter", http.StatusBadRequest)
+			return
+		}
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dohContentType {
+			http.Error(w, "content type must be "+dohContentType, http.StatusUnsupportedMediaType)
+			return
+		}
+		var err error
+		wire, err = io.ReadAll(io.LimitReader(r.Body, dohMaxMessageSize+1))
+		if err != nil || len(wire) == 0 || len(wire) > dohMaxMessageSize {
+			http.Error(w, "missing or oversized DNS message", http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := new(dns.Msg)
+	if err := query.Unpack(wire); err != nil {
+		http.Error(w, "malformed DNS message", http.StatusBadRequest)
+		return
+	}
+
+	rw := &dohResponseWriter{remote: httpRemoteAddr(r)}
+	h.handler(rw, query)
+	if rw.msg == nil {
+		http.Error(w, "query not answered", http.StatusServiceUnavailable)
+		return
+	}
+
+	packed, err := rw.msg.Pack()
+	if err != nil {
+		h.logger.Error("Failed to pack DoH response", "error", err)
+		http.Error(w, "failed to encode answer", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", dohContentType)
+	// HTTP caches must not keep the answer longer than its records
+	if len(records(rw.msg)) > 0 {
+		w.Header().Set("Cache-Control", "max-age="+strconv.FormatUint(uint64(minTTL(rw.msg)), 10))
+	}
+	w.Write(packed)
+}
+
+// httpRemoteAddr returns the client address of an HTTP request as a TCP
+// address, as the DNS handler would see it on a TCP connection.
+func httpRemoteAddr(r *http.Request) net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+	return addr
+}
+
+// dohResponseWriter captures the answer a DNS handler writes.
+type dohResponseWriter struct {
+	remote net.Addr
+	msg    *dns.Msg
+}
+
+func (w *dohResponseWriter) LocalAddr() net.Addr  { return &net.TCPAddr{} }
+func (w *dohResponseWriter) RemoteAddr() net.Addr { return w.remote }
+func (w *dohResponseWriter) Close() error         { return nil }
+func (w *dohResponseWriter) TsigStatus() error    { return nil }
+func (w *dohResponseWriter) TsigTimersOnly(bool)  {}
+func (w *dohResponseWriter) Hijack()              {}
+
+func (w *dohResponseWriter) WriteMsg(msg *dns.Msg) error {
+	w.msg = msg
+	return nil
+}
+
+func (w *dohResponseWriter) Write(wire []byte) (int, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(wire); err != nil {
+		return 0, err
+	}
+	w.msg = msg
+	return len(wire), nil
+}
+
+// startDoHServer serves DoH on addr in the background, over TLS when a
+// certificate and key are given. Without them it serves plain HTTP, for use
+// behind a TLS-terminating proxy. A failure to listen is logged and does not
+// stop the DNS server.
+func startDoHServer(addr, certFile, keyFile string, handler dns.HandlerFunc, log *logger.Logger) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle(dohPath, &dohHandler{handler: handler, logger: log})
+
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load DoH certificate: %w", err)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Warn("DoH endpoint stopped", "addr", addr, "error", err)
+		}
+	}()
+	log.Info("Serving DNS-over-HTTPS", "addr", addr, "path", dohPath, "tls", server.TLSConfig != nil)
+	return server, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+func testDoHHandler() *dohHandler {
+	s := &DNSServer{customDomains: []string{"loc"}, targetIP: "127.0.0.1", logger: logger.New("test")}
+	return &dohHandler{handler: s.handleDNSRequest, logger: s.logger}
+}
+
+func packedQuery(t *testing.T, name string, qtype uint16) []byte {
+	t.Helper()
+	query := new(dns.Msg)
+	query.SetQuestion(name, qtype)
+	query.Id = 0
+	wire, err := query.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+	return wire
+}
+
+func decodeDoHAnswer(t *testing.T, rec *httptest.ResponseRecorder) *dns.Msg {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != dohContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	msg := new(dns.Msg)
+	if err := msg.Unpack(body); err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+	return msg
+}
+
+func TestDoHGet(t *testing.T) {
+	wire := packedQuery(t, "app.loc.", dns.TypeA)
+	req := httptest.NewRequest(http.MethodGet, dohPath+"?dns="+base64.RawURLEncoding.EncodeToString(wire), nil)
+	rec := httptest.NewRecorder()
+
+	testDoHHandler().ServeHTTP(rec, req)
+
+	msg := decodeDoHAnswer(t, rec)
+	if len(msg.Answer) != 1 || msg.Answer[0].(*dns.A).A.String() != "127.0.0.1" {
+		t.Errorf("unexpected answer %v", msg.Answer)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "max-age=60" {
+		t.Errorf("Cache-Control = %q, want max-age=60", cc)
+	}
+}
+
+func TestDoHPost(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, dohPath, bytes.NewReader(packedQuery(t, "app.loc.", dns.TypeAAAA)))
+	req.Header.Set("Content-Type", dohContentType)
+	rec := httptest.NewRecorder()
+
+	testDoHHandler().ServeHTTP(rec, req)
+
+	msg := decodeDoHAnswer(t, rec)
+	if len(msg.Answer) != 0 || len(msg.Ns) != 1 {
+		t.Errorf("got %d answers and %d authority records, want NODATA with SOA", len(msg.Answer), len(msg.Ns))
+	}
+}
+
+func TestDoHRejectsBadRequests(t *testing.T) {
+	wire := packedQuery(t, "app.loc.", dns.TypeA)
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want int
+	}{
+		{"missing parameter", httptest.NewRequest(http.MethodGet, dohPath, nil), http.StatusBadRequest},
+		{"malformed parameter", httptest.NewRequest(http.MethodGet, dohPath+"?dns=%%%", nil), http.StatusBadRequest},
+		{"garbage message", httptest.NewRequest(http.MethodGet, dohPath+"?dns=AAAA", nil), http.StatusBadRequest},
+		{"wrong content type", httptest.NewRequest(http.MethodPost, dohPath, bytes.NewReader(wire)), http.StatusUnsupportedMediaType},
+		{"wrong method", httptest.NewRequest(http.MethodPut, dohPath, nil), http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			testDoHHandler().ServeHTTP(rec, tt.req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// domainTarget is where the names of a domain resolve.
+type domainTarget struct {
+	ipv4 string
+	ipv6 string // empty answers AAAA queries with NODATA
+}
+
+// domainMap holds the per-domain targets of HTTP_PROXY_DNS_DOMAIN_MAP, keyed
+// by lowercase domain.
+type domainMap map[string]domainTarget
+
+// parseDomainMap parses HTTP_PROXY_DNS_DOMAIN_MAP: comma-separated
+// "<domain>=<ip>" entries, e.g.
+//
+//	loc=127.0.0.1,test=192.168.64.2,test=fd00::2
+//
+// A domain takes one IPv4 address and optionally one IPv6 address, given as
+// two entries. Every mapped domain needs an IPv4 address.
+func parseDomainMap(spec string) (domainMap, error) {
+	targets := make(domainMap)
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		domain, value, ok := strings.Cut(entry, "=")
+		domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+		ip := net.ParseIP(strings.TrimSpace(value))
+		if !ok || domain == "" || ip == nil {
+			return nil, fmt.Errorf("invalid domain mapping %q: expected \"<domain>=<ip>\"", entry)
+		}
+
+		target := targets[domain]
+		if ip.To4() != nil {
+			if target.ipv4 != "" {
+				return nil, fmt.Errorf("invalid domain mapping %q: %s already maps to %s", entry, domain, target.ipv4)
+			}
+			target.ipv4 = ip.String()
+		} else {
+			if target.ipv6 != "" {
+				return nil, fmt.Errorf("invalid domain mapping %q: %s already maps to %s", entry, domain, target.ipv6)
+			}
+			target.ipv6 = ip.String()
+		}
+		targets[domain] = target
+	}
+
+	for domain, target := range targets {
+		if target.ipv4 == "" {
+			return nil, fmt.Errorf("invalid domain mapping for %s: an IPv4 address is required", domain)
+		}
+	}
+	return targets, nil
+}
+
+// lookup returns the target of the most specific mapped domain name belongs
+// to.
+func (m domainMap) lookup(name string) (domainTarget, bool) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for {
+		if target, ok := m[name]; ok {
+			return target, true
+		}
+		_, parent, found := strings.Cut(name, ".")
+		if !found {
+			return domainTarget{}, false
+		}
+		name = parent
+	}
+}
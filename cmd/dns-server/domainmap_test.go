@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+func TestParseDomainMap(t *testing.T) {
+	targets, err := parseDomainMap(" loc=127.0.0.1, Test.=192.168.64.2,test=FD00::2,,")
+	if err != nil {
+		t.Fatalf("parseDomainMap: %v", err)
+	}
+	want := domainMap{
+		"loc":  {ipv4: "127.0.0.1"},
+		"test": {ipv4: "192.168.64.2", ipv6: "fd00::2"},
+	}
+	if len(targets) != len(want) {
+		t.Fatalf("targets = %v, want %v", targets, want)
+	}
+	for domain, target := range want {
+		if targets[domain] != target {
+			t.Errorf("targets[%s] = %+v, want %+v", domain, targets[domain], target)
+		}
+	}
+}
+
+func TestParseDomainMapErrors(t *testing.T) {
+	tests := []string{
+		"loc",
+		"loc=",
+		"=127.0.0.1",
+		"loc=not-an-ip",
+		"loc=127.0.0.1,loc=127.0.0.2",
+		"loc=::1",
+	}
+	for _, spec := range tests {
+		if _, err := parseDomainMap(spec); err == nil {
+			t.Errorf("parseDomainMap(%q): expected error", spec)
+		}
+	}
+}
+
+func TestCreateDNSResponseDomainMap(t *testing.T) {
+	targets, err := parseDomainMap("test=192.168.64.2,api.loc=10.0.0.5,api.loc=fd00::5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &DNSServer{
+		customDomains: []string{"loc", "test"},
+		targetIP:      "127.0.0.1",
+		targetIPv6:    "::1",
+		domainTargets: targets,
+		logger:        logger.New("test"),
+	}
+
+	tests := []struct {
+		name  string
+		qtype uint16
+		want  string // "" for NODATA
+	}{
+		{"app.loc.", dns.TypeA, "127.0.0.1"},
+		{"app.loc.", dns.TypeAAAA, "::1"},
+		{"app.test.", dns.TypeA, "192.168.64.2"},
+		{"app.test.", dns.TypeAAAA, ""}, // mapped without IPv6: no fallback to ::1
+		{"API.loc.", dns.TypeA, "10.0.0.5"},
+		{"v1.api.loc.", dns.TypeAAAA, "fd00::5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+dns.TypeToString[tt.qtype], func(t *testing.T) {
+			query := new(dns.Msg)
+			query.SetQuestion(tt.name, tt.qtype)
+			resp := s.createDNSResponse(netip.Addr{}, query)
+
+			if tt.want == "" {
+				if len(resp.Answer) != 0 {
+					t.Errorf("answer = %v, want NODATA", resp.Answer)
+				}
+				return
+			}
+			if len(resp.Answer) != 1 {
+				t.Fatalf("answer = %v, want one record", resp.Answer)
+			}
+			var got string
+			switch rr := resp.Answer[0].(type) {
+			case *dns.A:
+				got = rr.A.String()
+			case *dns.AAAA:
+				got = rr.AAAA.String()
+			}
+			if got != tt.want {
+				t.Errorf("resolved to %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
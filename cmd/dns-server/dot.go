@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// dotScheme prefixes upstream servers reached over DNS-over-TLS
+	dotScheme = "tls://"
+
+	// dotDefaultPort is used for DNS-over-TLS servers listed without a port
+	dotDefaultPort = "853"
+
+	// dotIdleTimeout is how long an unused connection is kept for reuse;
+	// public resolvers close idle connections after a few seconds anyway
+	dotIdleTimeout = 10 * time.Second
+
+	// dotMaxIdle caps the idle connections kept per server
+	dotMaxIdle = 4
+)
+
+// dotServer is a parsed DNS-over-TLS upstream entry.
+type dotServer struct {
+	addr       string // host:port to dial
+	serverName string // name the certificate must be valid for
+}
+
+// parseDoTServer parses a tls://host[:port][#name] upstream entry. The
+// certificate is checked against host, or against name when given, so an
+// IP address can be dialed and a resolver name verified
+// (tls://1.1.1.1#cloudflare-dns.com).
+func parseDoTServer(server string) (dotServer, error) {
+	rest, ok := strings.CutPrefix(server, dotScheme)
+	if !ok {
+		return dotServer{}, fmt.Errorf("%q is not a %s server", server, dotScheme)
+	}
+	hostPort, serverName, _ := strings.Cut(rest, "#")
+
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		// No port: the whole value is the host
+		host, port = strings.Trim(hostPort, "[]"), dotDefaultPort
+	}
+	if host == "" {
+		return dotServer{}, fmt.Errorf("missing host in %q", server)
+	}
+	if !validPort(port) {
+		return dotServer{}, fmt.Errorf("invalid port in %q", server)
+	}
+	if serverName == "" {
+		serverName = host
+	}
+	return dotServer{addr: net.JoinHostPort(host, port), serverName: serverName}, nil
+}
+
+// validateUpstream checks an upstream entry: host:port for plain DNS, or a
+// tls:// entry for DNS-over-TLS.
+func validateUpstream(server string) error {
+	if strings.HasPrefix(server, dotScheme) {
+		_, err := parseDoTServer(server)
+		return err
+	}
+	if _, port, err := net.SplitHostPort(server); err != nil || !validPort(port) {
+		return fmt.Errorf("invalid upstream server %q, want ip:port or %shost[:port]", server, dotScheme)
+	}
+	return nil
+}
+
+// validPort reports whether port is a TCP/UDP port number.
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// idleConn is a connection waiting to be reused.
+type idleConn struct {
+	conn     *dns.Conn
+	lastUsed time.Time
+}
+
+// dotClient forwards queries over DNS-over-TLS, keeping connections open
+// between queries so the TLS handshake is not paid on every lookup. Each
+// connection carries one query at a time. It is safe for concurrent use.
+type dotClient struct {
+	timeout   time.Duration
+	tlsConfig *tls.Config // base config; ServerName is set per server
+	now       func() time.Time
+
+	mu   sync.Mutex
+	idle map[string][]idleConn
+}
+
+// newDoTClient creates a client whose queries time out after timeout.
+func newDoTClient(timeout time.Duration) *dotClient {
+	return &dotClient{
+		timeout:   timeout,
+		tlsConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+		now:       time.Now,
+		idle:      make(map[string][]idleConn),
+	}
+}
+
+// exchange sends the query to a tls:// server. A reused connection the
+// server has closed in the meantime is replaced by a new one.
+func (c *dotClient) exchange(r *dns.Msg, server string) (*dns.Msg, error) {
+	target, err := parseDoTServer(server)
+	if err != nil {
+		return nil, err
+	}
+
+	client := c.client(target)
+	if conn := c.take(server); conn != nil {
+		resp, _, err := client.ExchangeWithConn(r, conn)
+		if err == nil {
+			c.put(server, conn)
+			return resp, nil
+		}
+		conn.Close()
+	}
+
+	conn, err := client.Dial(target.addr)
+	if err != nil {
+		return nil, err
+	}
+	resp, _, err := client.ExchangeWithConn(r, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.put(server, conn)
+	return resp, nil
+}
+
+// client returns a DNS client dialing target over TLS.
+func (c *dotClient) client(target dotServer) *dns.Client {
+	tlsConfig := c.tlsConfig.Clone()
+	tlsConfig.ServerName = target.serverName
+	return &dns.Client{Net: "tcp-tls", TLSConfig: tlsConfig, Timeout: c.timeout}
+}
+
+// take returns an idle connection to server, or nil, closing those idle for
+// longer than dotIdleTimeout.
+func (c *dotClient) take(server string) *dns.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	conns := c.idle[server]
+	for len(conns) > 0 {
+		last := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if now.Sub(last.lastUsed) < dotIdleTimeout {
+			c.idle[server] = conns
+			return last.conn
+		}
+		last.conn.Close()
+	}
+	delete(c.idle, server)
+	return nil
+}
+
+// put keeps a connection for reuse, closing it when enough are idle.
+func (c *dotClient) put(server string, conn *dns.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.idle[server]) >= dotMaxIdle {
+		conn.Close()
+		return
+	}
+	c.idle[server] = append(c.idle[server], idleConn{conn: conn, lastUsed: c.now()})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// countingListener counts the connections accepted by a listener.
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+// startDoTServer serves DNS-over-TLS on a local port with the httptest
+// certificate (valid for 127.0.0.1 and example.com), answering every A query
+// with 192.0.2.1, and returns a client trusting it.
+func startDoTServer(t *testing.T) (*countingListener, *dotClient) {
+	t.Helper()
+
+	certs := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(certs.Close)
+
+	inner, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certs.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := &countingListener{Listener: inner}
+
+	server := &dns.Server{Listener: ln, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		resp.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.ParseIP("192.0.2.1")}}
+		w.WriteMsg(resp)
+	})}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+
+	client := newDoTClient(2 * time.Second)
+	client.tlsConfig.RootCAs = certs.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	return ln, client
+}
+
+func TestDoTClientReusesConnections(t *testing.T) {
+	ln, client := startDoTServer(t)
+	server := dotScheme + ln.Addr().String()
+
+	for range 3 {
+		query := new(dns.Msg)
+		query.SetQuestion("example.com.", dns.TypeA)
+		resp, err := client.exchange(query, server)
+		if err != nil {
+			t.Fatalf("exchange() error = %v", err)
+		}
+		if len(resp.Answer) != 1 {
+			t.Fatalf("exchange() answer = %v", resp.Answer)
+		}
+	}
+	if got := ln.accepted.Load(); got != 1 {
+		t.Errorf("accepted %d connections, want 1 reused", got)
+	}
+
+	// An expired idle connection is replaced
+	client.now = func() time.Time { return time.Now().Add(dotIdleTimeout) }
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+	if _, err := client.exchange(query, server); err != nil {
+		t.Fatalf("exchange() error = %v", err)
+	}
+	if got := ln.accepted.Load(); got != 2 {
+		t.Errorf("accepted %d connections, want a new one after the idle timeout", got)
+	}
+}
+
+func TestDoTClientVerifiesServerName(t *testing.T) {
+	ln, client := startDoTServer(t)
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+	if _, err := client.exchange(query, dotScheme+"127.0.0.1:"+port+"#example.com"); err != nil {
+		t.Errorf("exchange() with a matching name error = %v", err)
+	}
+	if _, err := client.exchange(query, dotScheme+"127.0.0.1:"+port+"#dns.example.net"); err == nil {
+		t.Error("exchange() accepted a certificate for another name")
+	}
+}
+
+func TestParseDoTServer(t *testing.T) {
+	tests := []struct {
+		server  string
+		want    dotServer
+		wantErr bool
+	}{
+		{server: "tls://1.1.1.1:853", want: dotServer{addr: "1.1.1.1:853", serverName: "1.1.1.1"}},
+		{server: "tls://1.1.1.1", want: dotServer{addr: "1.1.1.1:853", serverName: "1.1.1.1"}},
+		{server: "tls://1.1.1.1#cloudflare-dns.com", want: dotServer{addr: "1.1.1.1:853", serverName: "cloudflare-dns.com"}},
+		{server: "tls://dns.quad9.net:8853", want: dotServer{addr: "dns.quad9.net:8853", serverName: "dns.quad9.net"}},
+		{server: "tls://[2606:4700::1111]", want: dotServer{addr: "[2606:4700::1111]:853", serverName: "2606:4700::1111"}},
+		{server: "tls://", wantErr: true},
+		{server: "1.1.1.1:53", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.server, func(t *testing.T) {
+			got, err := parseDoTServer(tt.server)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseDoTServer() = %+v, %v, want %+v (error %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateUpstream(t *testing.T) {
+	for _, server := range []string{"8.8.8.8:53", "tls://1.1.1.1:853", "tls://dns.google"} {
+		if err := validateUpstream(server); err != nil {
+			t.Errorf("validateUpstream(%q) error = %v", server, err)
+		}
+	}
+	for _, server := range []string{"8.8.8.8", "https://dns.google/dns-query", "tls://"} {
+		if err := validateUpstream(server); err == nil {
+			t.Errorf("validateUpstream(%q) accepted an invalid server", server)
+		}
+	}
+}
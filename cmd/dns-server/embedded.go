@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+// embeddedResolver answers the TLDs of HTTP_PROXY_DNS_EMBEDDED_TLDS by asking
+// Docker's embedded DNS server, which only listens inside the networks the
+// DNS server container is attached to: "web.docker-internal" resolves like
+// "web" does from a container on those networks.
+type embeddedResolver struct {
+	domains  []string
+	server   string
+	exchange func(r *dns.Msg, server string) (*dns.Msg, error)
+	logger   *logger.Logger
+}
+
+// newEmbeddedResolver returns a resolver for domains, or nil when there are
+// none. The domains must not overlap the locally answered ones, which would
+// make it ambiguous which of the two answers a name.
+func newEmbeddedResolver(domains []string, server string, localDomains []string, log *logger.Logger) (*embeddedResolver, error) {
+	if len(domains) == 0 {
+		return nil, nil
+	}
+	if err := validateUpstream(server); err != nil || strings.HasPrefix(server, dotScheme) {
+		return nil, fmt.Errorf("invalid HTTP_PROXY_DNS_EMBEDDED_SERVER %q, want ip:port", server)
+	}
+
+	e := &embeddedResolver{
+		server:   server,
+		exchange: newUpstreamExchange(),
+		logger:   log,
+	}
+	for _, domain := range domains {
+		domain = strings.Trim(strings.ToLower(domain), ".")
+		if domain == "" {
+			return nil, fmt.Errorf("invalid HTTP_PROXY_DNS_EMBEDDED_TLDS: empty domain")
+		}
+		for _, local := range localDomains {
+			local = strings.ToLower(local)
+			if domain == local || strings.HasSuffix(domain, "."+local) || strings.HasSuffix(local, "."+domain) {
+				return nil, fmt.Errorf("invalid HTTP_PROXY_DNS_EMBEDDED_TLDS: %q overlaps the configured domain %q", domain, local)
+			}
+		}
+		e.domains = append(e.domains, domain)
+	}
+	return e, nil
+}
+
+// zoneFor returns the embedded domain a name belongs to, or "".
+func (e *embeddedResolver) zoneFor(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for _, domain := range e.domains {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return domain
+		}
+	}
+	return ""
+}
+
+// handles reports whether all the questions of a query are for embedded
+// domains. A nil resolver handles nothing.
+func (e *embeddedResolver) handles(r *dns.Msg) bool {
+	if e == nil || len(r.Question) == 0 {
+		return false
+	}
+	for _, question := range r.Question {
+		if e.zoneFor(question.Name) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// containerName returns the name asked to the embedded DNS server for a
+// question, or "" when the question cannot name a container. Only single
+// labels are passed on: the embedded server forwards any other name to the
+// host's resolvers, which would make the domain an alias of the whole
+// internet.
+func (e *embeddedResolver) containerName(name string) string {
+	zone := e.zoneFor(name)
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	label := strings.TrimSuffix(strings.TrimSuffix(name, zone), ".")
+	if label == "" || strings.Contains(label, ".") {
+		return ""
+	}
+	return label + "."
+}
+
+// resolve answers a query for embedded domains. The answers of the embedded
+// server are renamed back to the names asked; a name that cannot be a
+// container is NXDOMAIN, and an unreachable server SERVFAIL.
+func (e *embeddedResolver) resolve(r *dns.Msg) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	msg.Authoritative = true
+
+	for _, question := range r.Question {
+		e.logger.Debug("DNS query",
+			"type", dns.TypeToString[question.Qtype],
+			"name", strings.ToLower(question.Name),
+			"source", sourceEmbedded)
+
+		if !e.answer(question, msg) {
+			break
+		}
+	}
+
+	if len(msg.Answer) == 0 {
+		msg.Ns = append(msg.Ns, soaRecord(e.zoneFor(r.Question[0].Name)))
+	}
+	return msg
+}
+
+// answer adds the embedded server's answers to one question to msg, reporting
+// whether the other questions are worth asking.
+func (e *embeddedResolver) answer(question dns.Question, msg *dns.Msg) bool {
+	name := e.containerName(question.Name)
+	if name == "" {
+		msg.Rcode = dns.RcodeNameError
+		return false
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion(name, question.Qtype)
+	resp, err := e.exchange(query, e.server)
+	if err != nil {
+		e.logger.Debug("Failed to query the embedded DNS server", "server", e.server, "error", err)
+		msg.Rcode = dns.RcodeServerFailure
+		return false
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		msg.Rcode = resp.Rcode
+		return false
+	}
+
+	// Only the records of the container itself are kept: anything else
+	// names Docker's internal view of the network
+	for _, rr := range resp.Answer {
+		if !strings.EqualFold(rr.Header().Name, name) {
+			continue
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Name = question.Name
+		msg.Answer = append(msg.Answer, rr)
+	}
+	return true
+}
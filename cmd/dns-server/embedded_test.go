@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+// testEmbeddedResolver returns a resolver for docker-internal backed by a
+// fake embedded server knowing the containers in ips, and the names it was
+// asked.
+func testEmbeddedResolver(t *testing.T, ips map[string]string) (*embeddedResolver, *[]string) {
+	t.Helper()
+	e, err := newEmbeddedResolver([]string{"docker-internal"}, "127.0.0.11:53", []string{"loc"}, logger.New("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var asked []string
+	e.exchange = func(r *dns.Msg, server string) (*dns.Msg, error) {
+		name := r.Question[0].Name
+		asked = append(asked, name)
+		if ips == nil {
+			return nil, fmt.Errorf("timeout")
+		}
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		ip, ok := ips[name]
+		if !ok {
+			resp.Rcode = dns.RcodeNameError
+			return resp, nil
+		}
+		resp.Answer = []dns.RR{
+			&dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 600}, A: net.ParseIP(ip)},
+			&dns.A{Hdr: dns.RR_Header{Name: "other.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 600}, A: net.ParseIP(ip)},
+		}
+		return resp, nil
+	}
+	return e, &asked
+}
+
+func TestNewEmbeddedResolver(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains []string
+		server  string
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "disabled", server: "127.0.0.11:53", wantNil: true},
+		{name: "tld", domains: []string{"Docker-Internal."}, server: "127.0.0.11:53"},
+		{name: "same as local", domains: []string{"loc"}, server: "127.0.0.11:53", wantErr: true},
+		{name: "below local", domains: []string{"docker.loc"}, server: "127.0.0.11:53", wantErr: true},
+		{name: "above local", domains: []string{"dev"}, server: "127.0.0.11:53", wantErr: true},
+		{name: "empty domain", domains: []string{"."}, server: "127.0.0.11:53", wantErr: true},
+		{name: "no port", domains: []string{"docker-internal"}, server: "127.0.0.11", wantErr: true},
+		{name: "dot server", domains: []string{"docker-internal"}, server: "tls://127.0.0.11", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := newEmbeddedResolver(tt.domains, tt.server, []string{"loc", "spark.dev"}, logger.New("test"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newEmbeddedResolver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (e == nil) != tt.wantNil {
+				t.Errorf("newEmbeddedResolver() = %v, want nil %v", e, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestEmbeddedResolverHandles(t *testing.T) {
+	e, _ := testEmbeddedResolver(t, nil)
+
+	tests := []struct {
+		name  string
+		names []string
+		want  bool
+	}{
+		{"container", []string{"web.docker-internal."}, true},
+		{"case insensitive", []string{"WEB.Docker-Internal."}, true},
+		{"apex", []string{"docker-internal."}, true},
+		{"local domain", []string{"web.loc."}, false},
+		{"mixed", []string{"web.docker-internal.", "web.loc."}, false},
+		{"suffix not matched", []string{"web.notdocker-internal."}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := new(dns.Msg)
+			for _, name := range tt.names {
+				r.Question = append(r.Question, dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET})
+			}
+			if got := e.handles(r); got != tt.want {
+				t.Errorf("handles(%v) = %v, want %v", tt.names, got, tt.want)
+			}
+		})
+	}
+
+	var disabled *embeddedResolver
+	if disabled.handles(new(dns.Msg).SetQuestion("web.docker-internal.", dns.TypeA)) {
+		t.Error("a nil resolver handles queries")
+	}
+}
+
+func TestEmbeddedResolverResolve(t *testing.T) {
+	tests := []struct {
+		name      string
+		qname     string
+		ips       map[string]string
+		wantRcode int
+		wantA     string
+		wantAsked []string
+	}{
+		{
+			name:      "container",
+			qname:     "Web.docker-internal.",
+			ips:       map[string]string{"web.": "172.18.0.5"},
+			wantRcode: dns.RcodeSuccess,
+			wantA:     "172.18.0.5",
+			wantAsked: []string{"web."},
+		},
+		{
+			name:      "unknown container",
+			qname:     "db.docker-internal.",
+			ips:       map[string]string{"web.": "172.18.0.5"},
+			wantRcode: dns.RcodeNameError,
+			wantAsked: []string{"db."},
+		},
+		{
+			name:      "dotted name not passed on",
+			qname:     "example.com.docker-internal.",
+			ips:       map[string]string{"example.com.": "93.184.216.34"},
+			wantRcode: dns.RcodeNameError,
+		},
+		{
+			name:      "apex",
+			qname:     "docker-internal.",
+			ips:       map[string]string{},
+			wantRcode: dns.RcodeNameError,
+		},
+		{
+			name:      "server unreachable",
+			qname:     "web.docker-internal.",
+			wantRcode: dns.RcodeServerFailure,
+			wantAsked: []string{"web."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, asked := testEmbeddedResolver(t, tt.ips)
+			query := new(dns.Msg).SetQuestion(tt.qname, dns.TypeA)
+
+			resp := e.resolve(query)
+
+			if resp.Rcode != tt.wantRcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			if fmt.Sprint(*asked) != fmt.Sprint(tt.wantAsked) {
+				t.Errorf("asked %v, want %v", *asked, tt.wantAsked)
+			}
+			if tt.wantA == "" {
+				if len(resp.Answer) != 0 || len(resp.Ns) != 1 {
+					t.Fatalf("got %d answers and %d authority records, want 0 and the SOA", len(resp.Answer), len(resp.Ns))
+				}
+				if soa := resp.Ns[0].Header().Name; soa != "docker-internal." {
+					t.Errorf("SOA name = %s, want docker-internal.", soa)
+				}
+				return
+			}
+			if len(resp.Answer) != 1 {
+				t.Fatalf("got answers %v, want only the container", resp.Answer)
+			}
+			a, ok := resp.Answer[0].(*dns.A)
+			if !ok || a.Hdr.Name != tt.qname || a.A.String() != tt.wantA {
+				t.Errorf("answer = %v, want %s A %s", resp.Answer[0], tt.qname, tt.wantA)
+			}
+		})
+	}
+}
+
+func TestResolveEmbeddedDomain(t *testing.T) {
+	e, _ := testEmbeddedResolver(t, map[string]string{"web.": "172.18.0.5"})
+	s := &DNSServer{customDomains: []string{"loc"}, targetIP: "127.0.0.1", embedded: e, logger: logger.New("test")}
+
+	resp, source := s.resolve(netip.Addr{}, new(dns.Msg).SetQuestion("web.docker-internal.", dns.TypeA))
+	if source != sourceEmbedded || len(resp.Answer) != 1 {
+		t.Errorf("got source %s and answers %v, want the embedded answer", source, resp.Answer)
+	}
+
+	_, source = s.resolve(netip.Addr{}, new(dns.Msg).SetQuestion("web.loc.", dns.TypeA))
+	if source != sourceLocal {
+		t.Errorf("local domain answered from %s, want %s", source, sourceLocal)
+	}
+}
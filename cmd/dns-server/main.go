@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"strings"
@@ -12,11 +15,18 @@ import (
 	"github.com/miekg/dns"
 	"github.com/sparkfabrik/http-proxy/pkg/config"
 	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/mdns"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+	"github.com/sparkfabrik/http-proxy/pkg/service"
+	"github.com/sparkfabrik/http-proxy/pkg/state"
 )
 
 // DNS_UPSTREAM_TIMEOUT defines the timeout for DNS queries to upstream servers
 const DNS_UPSTREAM_TIMEOUT = 5 * time.Second
 
+// maxQuestions caps the questions of one query
+const maxQuestions = 10
+
 // defaultRecordTTL is the TTL (seconds) applied to generated A records. It is
 // intentionally short: this is a local development resolver, so a low TTL lets
 // a changed HTTP_PROXY_DNS_TARGET_IP propagate quickly instead of being cached
@@ -24,18 +34,39 @@ const DNS_UPSTREAM_TIMEOUT = 5 * time.Second
 const defaultRecordTTL = 60
 
 type DNSServer struct {
-	customDomains   []string
-	targetIP        string
-	port            string
-	forwardEnabled  bool
-	upstreamServers []string
-	logger          *logger.Logger
+	customDomains    []string
+	targetIP         string
+	targetIPv6       string
+	domainTargets    domainMap
+	clientTargets    clientMap
+	port             string
+	forwardEnabled   bool
+	upstreamServers  []string
+	upstreamStrategy string
+	upstreams        *upstreamPool
+	cache            *dnsCache
+	nxdomain         *nxdomainGuard
+	records          extraRecords
+	containers       *containerRecords
+	mdns             *mdnsAdvertiser
+	queryLog         *queryLog
+	acl              *clientACL
+	blocklist        *domainBlocklist
+	rateLimit        int
+	limiter          *rateLimiter
+	embedded         *embeddedResolver
+	profile          string // configuration profile the settings come from
+	logger           *logger.Logger
+
+	// ctx is the lifetime of the server; background refreshes of stale
+	// cache entries stop when it is done
+	ctx context.Context
 }
 
 // forwardDNSQuery forwards DNS queries to upstream servers
 func (s *DNSServer) forwardDNSQuery(r *dns.Msg) (*dns.Msg, error) {
 	// Basic validation to prevent abuse
-	if len(r.Question) == 0 || len(r.Question) > 10 {
+	if len(r.Question) == 0 || len(r.Question) > maxQuestions {
 		return nil, fmt.Errorf("invalid query: bad question count")
 	}
 
@@ -52,18 +83,10 @@ func (s *DNSServer) forwardDNSQuery(r *dns.Msg) (*dns.Msg, error) {
 		}
 	}
 
-	c := dns.Client{Timeout: DNS_UPSTREAM_TIMEOUT}
-
-	for _, server := range s.upstreamServers {
-		resp, _, err := c.Exchange(r, server)
-		if err == nil {
-			s.logger.Debug("Forwarded query", "server", server)
-			return resp, nil
-		}
-		s.logger.Debug("Failed to forward", "server", server, "error", err)
+	if s.upstreams == nil {
+		return nil, fmt.Errorf("no upstream servers configured")
 	}
-
-	return nil, fmt.Errorf("all upstream servers failed")
+	return s.upstreams.forward(r)
 }
 
 // writeMsg writes a DNS response, logging any write failure.
@@ -84,15 +107,25 @@ func (s *DNSServer) createRefusedResponse(r *dns.Msg) *dns.Msg {
 
 // isDomainHandled checks if a domain matches any configured domain/TLD
 func (s *DNSServer) isDomainHandled(domain string) bool {
+	return s.zoneFor(domain) != ""
+}
+
+// zoneFor returns the configured domain/TLD a domain belongs to, or "" when
+// it belongs to none. A hostname of a running container outside the
+// configured domains is its own zone.
+func (s *DNSServer) zoneFor(domain string) string {
 	domainWithoutDot := strings.TrimSuffix(strings.ToLower(domain), ".")
 
 	for _, configuredDomain := range s.customDomains {
 		// Check if it's an exact match or a subdomain
 		if domainWithoutDot == configuredDomain || strings.HasSuffix(domainWithoutDot, "."+configuredDomain) {
-			return true
+			return configuredDomain
 		}
 	}
-	return false
+	if s.containers.has(domainWithoutDot) {
+		return domainWithoutDot
+	}
+	return ""
 }
 
 // validateAllQuestions checks if all questions in the request are for domains we handle
@@ -111,30 +144,119 @@ func (s *DNSServer) validateAllQuestions(r *dns.Msg) bool {
 	return true
 }
 
-// handleNonMatchingDomain handles queries for domains we don't manage
-func (s *DNSServer) handleNonMatchingDomain(w dns.ResponseWriter, r *dns.Msg) {
-	if s.forwardEnabled {
-		// Forward to upstream DNS servers
-		s.logger.Debug("Forwarding query to upstream servers")
-		response, err := s.forwardDNSQuery(r)
-		if err != nil {
-			s.logger.Debug("Failed to forward query", "error", err)
-			// If forwarding fails, return REFUSED
-			s.writeMsg(w, s.createRefusedResponse(r))
-		} else {
-			s.writeMsg(w, response)
-		}
-	} else {
+// Answer sources reported by the query log
+const (
+	sourceLocal     = "local"
+	sourceCache     = "cache"
+	sourceStale     = "stale"
+	sourceForwarded = "forwarded"
+	sourceEmbedded  = "embedded"
+	sourceBlocked   = "blocked"
+	sourceRefused   = "refused"
+	sourceDropped   = "dropped"
+	sourceDenied    = "denied"
+	sourceLimited   = "rate_limited"
+)
+
+// resolveNonMatchingDomain answers queries for domains we don't manage and
+// reports where the answer came from
+func (s *DNSServer) resolveNonMatchingDomain(r *dns.Msg) (*dns.Msg, string) {
+	if !s.forwardEnabled {
 		// Forwarding disabled - send REFUSED response
 		s.logger.Debug("Sending REFUSED response (not matching configured domains)")
-		s.writeMsg(w, s.createRefusedResponse(r))
+		return s.createRefusedResponse(r), sourceRefused
+	}
+
+	// Forward to upstream DNS servers
+	if s.cache != nil {
+		if cached := s.cache.get(r); cached != nil {
+			s.logger.Debug("Answered query from cache")
+			s.cache.countLookup("hit")
+			return cached, sourceCache
+		}
+		// The upstreams failed moments ago: do not wait for them again
+		if s.cache.isRefreshing(r) {
+			if stale := s.cache.getStale(r); stale != nil {
+				s.cache.countLookup("stale")
+				return stale, sourceStale
+			}
+		}
+	}
+
+	s.logger.Debug("Forwarding query to upstream servers")
+	response, err := s.forwardDNSQuery(r)
+	if err != nil {
+		if s.cache != nil {
+			if stale := s.cache.getStale(r); stale != nil {
+				s.logger.Debug("Upstream servers failed, answering from stale cache", "error", err)
+				s.refreshStale(s.ctx, r)
+				s.cache.countLookup("stale")
+				return stale, sourceStale
+			}
+			s.cache.countLookup("miss")
+		}
+		s.logger.Debug("Failed to forward query", "error", err)
+		// If forwarding fails, return REFUSED
+		return s.createRefusedResponse(r), sourceRefused
 	}
+	response = s.nxdomain.check(r, response)
+	if s.cache != nil {
+		s.cache.countLookup("miss")
+		s.cache.set(r, response)
+	}
+	return response, sourceForwarded
+}
+
+// staleRefreshInterval is how often a stale answer is asked for again while
+// the upstreams fail
+var staleRefreshInterval = 5 * time.Second
+
+// refreshStale asks the upstreams for a query answered from the stale cache
+// in the background, until they answer, the stale entry expires or ctx is
+// done, and caches the answer. A query is refreshed once at a time.
+func (s *DNSServer) refreshStale(ctx context.Context, r *dns.Msg) {
+	if !s.cache.startRefresh(r) {
+		return
+	}
+	query := r.Copy()
+	go func() {
+		defer s.cache.endRefresh(query)
+		for {
+			response, err := s.forwardDNSQuery(query)
+			if err == nil {
+				s.cache.set(query, s.nxdomain.check(query, response))
+				s.logger.Debug("Refreshed stale cache entry", "name", query.Question[0].Name)
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(staleRefreshInterval):
+			}
+			if _, ok := s.cache.staleEntry(query); !ok {
+				return
+			}
+		}
+	}()
+}
+
+// targetFor returns where name resolves for client: the target of its
+// override in HTTP_PROXY_DNS_CLIENT_MAP, of its mapped domain in
+// HTTP_PROXY_DNS_DOMAIN_MAP, or the default target IPs.
+func (s *DNSServer) targetFor(client netip.Addr, name string) domainTarget {
+	if target, ok := s.clientTargets.lookup(client, name); ok {
+		return target
+	}
+	if target, ok := s.domainTargets.lookup(name); ok {
+		return target
+	}
+	return domainTarget{ipv4: s.targetIP, ipv6: s.targetIPv6}
 }
 
 // createARecord creates an A record for the given question. The target IP is
 // validated at startup, so it is constructed directly rather than parsed from a
 // zone-file string on every query.
-func (s *DNSServer) createARecord(question dns.Question) dns.RR {
+func (s *DNSServer) createARecord(client netip.Addr, question dns.Question) dns.RR {
 	return &dns.A{
 		Hdr: dns.RR_Header{
 			Name:   question.Name,
@@ -142,36 +264,151 @@ func (s *DNSServer) createARecord(question dns.Question) dns.RR {
 			Class:  dns.ClassINET,
 			Ttl:    defaultRecordTTL,
 		},
-		A: net.ParseIP(s.targetIP),
+		A: net.ParseIP(s.targetFor(client, question.Name).ipv4),
+	}
+}
+
+// createAAAARecord creates an AAAA record for the given question, answering
+// with the IPv6 target validated at startup. The name must have one.
+func (s *DNSServer) createAAAARecord(client netip.Addr, question dns.Question) dns.RR {
+	return &dns.AAAA{
+		Hdr: dns.RR_Header{
+			Name:   question.Name,
+			Rrtype: dns.TypeAAAA,
+			Class:  dns.ClassINET,
+			Ttl:    defaultRecordTTL,
+		},
+		AAAA: net.ParseIP(s.targetFor(client, question.Name).ipv6),
 	}
 }
 
-// handleQuestion processes a single DNS question and adds answers to the response
-func (s *DNSServer) handleQuestion(question dns.Question, msg *dns.Msg) {
+// createSOARecord creates the SOA record of the configured domain a name
+// belongs to. It goes in the authority section of empty (NODATA) answers, so
+// resolvers cache the absence of the record instead of retrying or waiting
+// for a timeout.
+func (s *DNSServer) createSOARecord(name string) dns.RR {
+	return soaRecord(s.zoneFor(name))
+}
+
+// soaRecord creates the SOA record synthesized for a zone.
+func soaRecord(zone string) dns.RR {
+	zone = dns.Fqdn(zone)
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   zone,
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    defaultRecordTTL,
+		},
+		Ns:      nameServerOf(zone),
+		Mbox:    "hostmaster." + zone,
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  defaultRecordTTL,
+	}
+}
+
+// createNSRecord creates the NS record of the configured domain a name
+// belongs to, naming this server as ns.<domain>.
+func (s *DNSServer) createNSRecord(name string) dns.RR {
+	zone := dns.Fqdn(s.zoneFor(name))
+	return &dns.NS{
+		Hdr: dns.RR_Header{
+			Name:   zone,
+			Rrtype: dns.TypeNS,
+			Class:  dns.ClassINET,
+			Ttl:    defaultRecordTTL,
+		},
+		Ns: nameServerOf(zone),
+	}
+}
+
+// nameServerOf returns the name server hostname synthesized for a zone.
+func nameServerOf(zone string) string {
+	return "ns." + zone
+}
+
+// isZoneApex reports whether name is a configured domain itself rather than
+// a name below it.
+func (s *DNSServer) isZoneApex(name string) bool {
+	zone := s.zoneFor(name)
+	return zone != "" && strings.TrimSuffix(strings.ToLower(name), ".") == zone
+}
+
+// handleQuestion processes a single DNS question from client and adds answers
+// to the response
+func (s *DNSServer) handleQuestion(client netip.Addr, question dns.Question, msg *dns.Msg) {
 	name := strings.ToLower(question.Name)
 
+	// A CNAME answers every type; A queries also get the target's address
+	// when the target is one of our names
+	if cname := s.records.cname(question); cname != nil && question.Qtype != dns.TypeCNAME {
+		msg.Answer = append(msg.Answer, cname)
+		if question.Qtype == dns.TypeA && s.isDomainHandled(cname.Target) {
+			msg.Answer = append(msg.Answer, s.createARecord(client, dns.Question{Name: cname.Target}))
+		}
+		s.logger.Info("Resolved CNAME record", "name", name, "target", cname.Target)
+		return
+	}
+
 	switch question.Qtype {
 	case dns.TypeA:
 		// Respond with our target IP for A records
-		msg.Answer = append(msg.Answer, s.createARecord(question))
-		s.logger.Info("Resolved A record", "name", name, "ip", s.targetIP)
+		msg.Answer = append(msg.Answer, s.createARecord(client, question))
+		s.logger.Info("Resolved A record", "name", name, "ip", s.targetFor(client, name).ipv4, "client", client)
 	case dns.TypeAAAA:
-		// For IPv6 queries, return empty response (no IPv6 support)
-		s.logger.Debug("IPv6 query - returning empty response", "name", name)
+		target := s.targetFor(client, name)
+		if target.ipv6 == "" {
+			// No IPv6 target: answer NODATA so dual-stack clients fall back to A
+			s.logger.Debug("IPv6 query without IPv6 target - returning empty response", "name", name)
+			return
+		}
+		msg.Answer = append(msg.Answer, s.createAAAARecord(client, question))
+		s.logger.Info("Resolved AAAA record", "name", name, "ip", target.ipv6, "client", client)
+	case dns.TypeSOA, dns.TypeNS:
+		// Only the domain itself has SOA and NS records; names below it get
+		// NODATA with the SOA, which resolvers cache
+		if !s.isZoneApex(name) {
+			s.logger.Debug("No zone record below the domain", "type", dns.TypeToString[question.Qtype], "name", name)
+			return
+		}
+		if question.Qtype == dns.TypeSOA {
+			msg.Answer = append(msg.Answer, s.createSOARecord(name))
+			return
+		}
+		ns := s.createNSRecord(name)
+		msg.Answer = append(msg.Answer, ns)
+		glue := dns.Question{Name: ns.(*dns.NS).Ns}
+		msg.Extra = append(msg.Extra, s.createARecord(client, glue))
+		if s.targetFor(client, glue.Name).ipv6 != "" {
+			msg.Extra = append(msg.Extra, s.createAAAARecord(client, glue))
+		}
+		s.logger.Debug("Resolved NS record", "name", name, "ns", glue.Name)
+	case dns.TypeCNAME, dns.TypeTXT:
+		answers := s.records.lookup(question, question.Qtype)
+		msg.Answer = append(msg.Answer, answers...)
+		s.logger.Debug("Resolved configured records", "type", dns.TypeToString[question.Qtype], "name", name, "count", len(answers))
 	default:
 		// For other query types, return empty response
 		s.logger.Debug("Unsupported query type", "type", dns.TypeToString[question.Qtype], "name", name)
 	}
 }
 
-// createDNSResponse creates a DNS response for queries we handle
-func (s *DNSServer) createDNSResponse(r *dns.Msg) *dns.Msg {
+// createDNSResponse creates a DNS response for queries we handle, answered
+// for client; the zero address is an unknown client
+func (s *DNSServer) createDNSResponse(client netip.Addr, r *dns.Msg) *dns.Msg {
 	msg := dns.Msg{}
 	msg.SetReply(r)
 	msg.Authoritative = true
 
 	for _, question := range r.Question {
-		s.handleQuestion(question, &msg)
+		s.handleQuestion(client, question, &msg)
+	}
+
+	if len(msg.Answer) == 0 && len(r.Question) > 0 {
+		msg.Ns = append(msg.Ns, s.createSOARecord(r.Question[0].Name))
 	}
 
 	return &msg
@@ -179,72 +416,265 @@ func (s *DNSServer) createDNSResponse(r *dns.Msg) *dns.Msg {
 
 // handleDNSRequest processes incoming DNS queries
 func (s *DNSServer) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
+	start := time.Now()
+	msg, source, rejected := s.rejectClient(w.RemoteAddr(), r)
+	if !rejected {
+		client, _ := clientAddr(w.RemoteAddr())
+		msg, source = s.resolve(client, r)
+	}
+	if msg != nil {
+		// A UDP answer must fit the client's buffer; the TC bit set on a
+		// truncated answer makes it retry over TCP, where nothing is cut
+		if _, udp := w.RemoteAddr().(*net.UDPAddr); udp {
+			msg.Truncate(udpSize(r))
+		}
+		s.writeMsg(w, msg)
+	}
+	s.queryLog.record(w.RemoteAddr(), r, msg, source, time.Since(start))
+}
+
+// rejectClient checks the client against the ACL and rate limit. A denied
+// client is refused; a client over its rate is answered nothing over UDP,
+// so the server cannot be used to amplify spoofed traffic, and refused over
+// TCP and DoH.
+func (s *DNSServer) rejectClient(addr net.Addr, r *dns.Msg) (*dns.Msg, string, bool) {
+	client, ok := clientAddr(addr)
+	if !ok {
+		return nil, "", false
+	}
+	if !s.acl.allows(client) {
+		s.limiter.reject(sourceDenied)
+		s.logger.Debug("Refusing query from denied client", "client", client)
+		return s.createRefusedResponse(r), sourceDenied, true
+	}
+	if !s.limiter.allow(client) {
+		s.limiter.reject(sourceLimited)
+		if _, udp := addr.(*net.UDPAddr); udp {
+			return nil, sourceLimited, true
+		}
+		return s.createRefusedResponse(r), sourceLimited, true
+	}
+	return nil, "", false
+}
+
+// acceptMsg applies the checks of dns.DefaultMsgAcceptFunc, which rejects
+// queries with more than one question, allowing up to maxQuestions.
+func acceptMsg(dh dns.Header) dns.MsgAcceptAction {
+	if dh.Qdcount > 1 && dh.Qdcount <= maxQuestions {
+		dh.Qdcount = 1
+	}
+	return dns.DefaultMsgAcceptFunc(dh)
+}
+
+// udpSize returns the largest UDP answer the client accepts: its EDNS0
+// buffer size, or 512 bytes without EDNS0.
+func udpSize(r *dns.Msg) int {
+	if opt := r.IsEdns0(); opt != nil {
+		return max(int(opt.UDPSize()), dns.MinMsgSize)
+	}
+	return dns.MinMsgSize
+}
+
+// resolve answers a query from client, returning nil for queries that are
+// dropped, and reports where the answer came from
+func (s *DNSServer) resolve(client netip.Addr, r *dns.Msg) (*dns.Msg, string) {
 	// Only respond to queries for our configured domains/TLDs
 	// Security: Silently drop queries for domains we're not authoritative for
 	// This prevents DNS amplification attacks and reduces information leakage
 	if len(s.customDomains) == 0 {
 		s.logger.Debug("No custom domains/TLDs configured, dropping query")
-		return
+		return nil, sourceDropped
+	}
+
+	// Blocked names never reach the local records or the upstreams
+	if s.blocklist.blocks(r) {
+		return s.blocklist.answer(r), sourceBlocked
+	}
+
+	if s.embedded.handles(r) {
+		return s.embedded.resolve(r), sourceEmbedded
 	}
 
 	// First, validate that all questions are for domains we handle
 	if !s.validateAllQuestions(r) {
 		// Handle queries for domains we don't manage
-		s.handleNonMatchingDomain(w, r)
-		return
+		return s.resolveNonMatchingDomain(r)
 	}
 
 	// All queries are for our domains - create and send response
-	s.writeMsg(w, s.createDNSResponse(r))
+	return s.createDNSResponse(client, r), sourceLocal
+}
+
+// startMetricsServer serves the metrics registry on addr in the background
+// until ctx is done. A failure to listen is logged and does not stop the DNS
+// server.
+func startMetricsServer(ctx context.Context, addr string, registry *metrics.Registry, log *logger.Logger) {
+	go func() {
+		if err := metrics.Serve(ctx, addr, registry.ServeMux()); err != nil {
+			log.Warn("Metrics endpoint stopped", "addr", addr, "error", err)
+		}
+	}()
+	log.Info("Serving metrics", "addr", addr)
 }
 
 func main() {
-	// Load configuration
-	cfg := config.Load()
+	// Load configuration, with HTTP_PROXY_DNS_CONFIG_FILE overriding the
+	// environment so settings can be changed and reloaded without a restart
 	log := logger.NewWithEnv("dns-server")
-
-	server := &DNSServer{
-		customDomains:   cfg.Domains,
-		targetIP:        cfg.DNSIP,
-		port:            cfg.DNSPort,
-		forwardEnabled:  cfg.DNSForwardEnabled,
-		upstreamServers: cfg.DNSUpstreamServers,
-		logger:          log,
+	configFile := config.GetEnvOrDefault("HTTP_PROXY_DNS_CONFIG_FILE", "")
+	cfg, err := config.LoadWithFile(configFile)
+	if err != nil {
+		log.Error("Failed to load configuration", "file", configFile, "error", err)
+		os.Exit(1)
 	}
 
-	if len(server.customDomains) == 0 {
-		log.Error("No domains/TLDs configured")
+	server, err := newDNSServer(cfg, log)
+	if err != nil {
+		log.Error("Invalid configuration", "error", err)
 		os.Exit(1)
 	}
 
-	// Validate target IP. The server answers A records only, so the target must
-	// be IPv4; an IPv6 address would be silently truncated into a 4-byte A record.
-	if ip := net.ParseIP(cfg.DNSIP); ip == nil || ip.To4() == nil {
-		log.Error("Invalid target IP address, must be IPv4", "ip", cfg.DNSIP)
+	// Track the health of the upstream servers across reloads
+	registry := metrics.NewRegistry()
+	server.upstreams = newUpstreamPool(registry, log)
+	server.upstreams.configure(server.upstreamServers, server.upstreamStrategy)
+
+	// Limit the queries answered per client across reloads
+	server.limiter = newRateLimiter(registry)
+	server.limiter.setRate(server.rateLimit)
+
+	// Cache forwarded answers, optionally restoring the cache saved by the
+	// previous run so a restart does not start from cold lookups
+	if cfg.DNSForwardEnabled {
+		server.cache = newDNSCache(cfg.DNSCacheSize, registry)
+		if err := server.cache.setTTLBounds(cfg.DNSCacheMinTTL, cfg.DNSCacheMaxTTL); err != nil {
+			log.Error("Invalid DNS cache TTL bounds", "error", err)
+			os.Exit(1)
+		}
+		if err := server.cache.setStaleWindow(cfg.DNSCacheStaleTTL); err != nil {
+			log.Error("Invalid DNS cache stale TTL", "error", err)
+			os.Exit(1)
+		}
+		if cfg.DNSCacheFile != "" {
+			if n, err := server.cache.load(cfg.DNSCacheFile); err != nil {
+				log.Warn("Failed to load DNS cache, starting empty", "file", cfg.DNSCacheFile, "error", err)
+			} else {
+				log.Info("Loaded DNS cache", "file", cfg.DNSCacheFile, "entries", n)
+			}
+		}
+
+		// Undo upstreams that answer nonexistent names with an ad page
+		guard, err := newNXDomainGuard(cfg.DNSNXDomainProtection, cfg.DNSSinkholeIPs, cfg.DNSCleanUpstream, registry, log)
+		if err != nil {
+			log.Error("Invalid NXDOMAIN protection configuration", "error", err)
+			os.Exit(1)
+		}
+		if guard != nil {
+			log.Info("NXDOMAIN protection enabled", "mode", cfg.DNSNXDomainProtection, "clean_upstream", cfg.DNSCleanUpstream)
+			go guard.learnSinkholes(cfg.DNSUpstreamServers)
+			server.nxdomain = guard
+		}
+	}
+
+	// Bind before serving so a busy port can be diagnosed and, when fallback
+	// ports are configured, replaced
+	bound, conflict, err := bindWithFallback(cfg.DNSPort, cfg.DNSFallbackPorts, defaultProcRoot, log)
+	if err != nil {
+		log.Error("Server startup failed", "error", err)
 		os.Exit(1)
 	}
+	server.port = bound.port
 
-	log.Info("Starting DNS server", "port", cfg.DNSPort)
+	log.Info("Starting DNS server", "port", bound.port)
 	log.Info("Handling domains/TLDs", "domains", cfg.Domains)
 	log.Info("Resolving to", "target_ip", cfg.DNSIP)
+	for domain, target := range server.domainTargets {
+		log.Info("Resolving domain to", "domain", domain, "target_ip", target.ipv4, "target_ipv6", target.ipv6)
+	}
+	for _, override := range server.clientTargets {
+		log.Info("Resolving for clients to", "clients", override.clients, "domain", override.domain, "target_ip", override.target.ipv4, "target_ipv6", override.target.ipv6)
+	}
+	if server.acl != nil {
+		log.Info("DNS client ACL", "allowed", cfg.DNSAllowedCIDRs, "denied", cfg.DNSDeniedCIDRs)
+	}
+	if server.rateLimit > 0 {
+		log.Info("DNS rate limit", "queries_per_second", server.rateLimit)
+	}
+	if server.blocklist != nil {
+		log.Info("DNS blocklist", "domains", len(server.blocklist.domains), "file", cfg.DNSBlocklistFile, "response", cfg.DNSBlockResponse)
+	}
 	log.Info("DNS forwarding", "forward_enabled", cfg.DNSForwardEnabled)
 	if cfg.DNSForwardEnabled {
-		log.Info("DNS upstream servers", "servers", cfg.DNSUpstreamServers)
+		log.Info("DNS upstream servers", "servers", cfg.DNSUpstreamServers, "strategy", cfg.DNSUpstreamStrategy)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server.ctx = ctx
+
+	// Advertise the .local names over multicast DNS, for resolvers that use
+	// Avahi or Bonjour instead of this server
+	var containers *containerRecords
+	if cfg.DNSDockerRecords || cfg.DNSMDNSEnabled {
+		containers = newContainerRecords()
+	}
+	if cfg.DNSMDNSEnabled {
+		mdnsIP := cfg.DNSMDNSIP
+		if mdnsIP == "" {
+			mdnsIP = cfg.DNSIP
+		}
+		ip := net.ParseIP(mdnsIP)
+		if ip == nil || ip.To4() == nil {
+			log.Error("Invalid configuration", "error", fmt.Errorf("mDNS IP %q must be a valid IPv4 address", mdnsIP))
+			os.Exit(1)
+		}
+
+		server.mdns = &mdnsAdvertiser{
+			responder:  mdns.NewResponder(ip, log.With("subsystem", "mdns")),
+			containers: containers,
+		}
+		containers.onChange = server.mdns.update
+		server.mdns.setDomains(server.customDomains)
+		go func() {
+			if err := server.mdns.responder.Run(ctx); err != nil {
+				log.Error("mDNS responder stopped", "error", err)
+			}
+		}()
+	}
+
+	// Follow container hostnames from Docker events
+	if containers != nil {
+		svc, err := service.NewService(ctx, "dns-server", config.GetEnvOrDefault("LOG_LEVEL", "info"), containers)
+		if err != nil {
+			log.Error("Docker container records startup failed", "error", err)
+			os.Exit(1)
+		}
+		defer svc.Close()
+		go func() {
+			if err := svc.Run(ctx); err != nil {
+				log.Error("Docker container records stopped", "error", err)
+			}
+		}()
+	}
+	if cfg.DNSDockerRecords {
+		server.containers = containers
+		log.Info("Answering container hostnames from Docker events")
 	}
 
 	// Create DNS server
-	dns.HandleFunc(".", server.handleDNSRequest)
+	reloader := newDNSReloader(server, configFile, cfg.Files, log)
+	dns.HandleFunc(".", reloader.handleDNSRequest)
 
 	udpServer := &dns.Server{
-		Addr:    ":" + cfg.DNSPort,
-		Net:     "udp",
-		Handler: dns.DefaultServeMux,
+		PacketConn:    bound.packet,
+		Handler:       dns.DefaultServeMux,
+		MsgAcceptFunc: acceptMsg,
 	}
 
 	tcpServer := &dns.Server{
-		Addr:    ":" + cfg.DNSPort,
-		Net:     "tcp",
-		Handler: dns.DefaultServeMux,
+		Listener:      bound.stream,
+		Handler:       dns.DefaultServeMux,
+		MsgAcceptFunc: acceptMsg,
 	}
 
 	// Create error channel for server startup errors
@@ -252,13 +682,13 @@ func main() {
 
 	// Start servers in goroutines
 	go func() {
-		if err := udpServer.ListenAndServe(); err != nil {
+		if err := udpServer.ActivateAndServe(); err != nil {
 			errChan <- fmt.Errorf("UDP server failed: %v", err)
 		}
 	}()
 
 	go func() {
-		if err := tcpServer.ListenAndServe(); err != nil {
+		if err := tcpServer.ActivateAndServe(); err != nil {
 			errChan <- fmt.Errorf("TCP server failed: %v", err)
 		}
 	}()
@@ -267,18 +697,79 @@ func main() {
 	select {
 	case err := <-errChan:
 		log.Error("Server startup failed", "error", err)
+		bound.close()
 		os.Exit(1)
 	case <-time.After(100 * time.Millisecond):
 	}
 
+	store := state.NewStore(config.GetEnvOrDefault("HTTP_PROXY_STATE_DIR", state.DefaultDir))
+	status := newDNSStatus(cfg.DNSPort, bound.port, conflict, server.domainStatus())
+	status.Profile = cfg.Profile
+	if err := writeStatus(store, status); err != nil {
+		log.Warn("Failed to write DNS server status", "error", err)
+	}
+	// Keep the published domains in line with reloads
+	reloader.onReload = func(next *DNSServer) {
+		status.Profile = next.profile
+		status.Domains = next.domainStatus()
+		if err := writeStatus(store, status); err != nil {
+			log.Warn("Failed to write DNS server status", "error", err)
+		}
+	}
+
 	log.Info("DNS server started successfully")
 
+	// Query the server through its own listener, so a wrong target IP or a
+	// broken upstream shows now rather than on a user's first lookup
+	if cfg.DNSSelfTest {
+		tester := newSelfTester(selfTestAddr(bound.packet.LocalAddr()), registry, log)
+		go tester.run(ctx, server, cfg.DNSSelfTestName)
+	}
+
+	if cfg.DNSMetricsAddr != "" {
+		startMetricsServer(ctx, cfg.DNSMetricsAddr, registry, log)
+	}
+
+	var dohServer *http.Server
+	if cfg.DNSDoHAddr != "" {
+		dohServer, err = startDoHServer(cfg.DNSDoHAddr, cfg.DNSDoHCertFile, cfg.DNSDoHKeyFile, reloader.handleDNSRequest, log)
+		if err != nil {
+			log.Error("DoH startup failed", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Reload the configuration on SIGHUP or when the config file changes,
+	// keeping the listeners up
+	go reloader.watch(ctx, configFilePollInterval)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reloader.reload(); err != nil {
+				log.Error("Failed to reload DNS configuration, keeping the previous one", "error", err)
+			}
+		}
+	}()
+
 	// Wait for interrupt signal
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	<-c
 
 	log.Info("Shutting down DNS server...")
+	cancel()
 	udpServer.Shutdown()
 	tcpServer.Shutdown()
+	if dohServer != nil {
+		dohServer.Close()
+	}
+
+	if server.cache != nil && cfg.DNSCacheFile != "" {
+		if n, err := server.cache.save(cfg.DNSCacheFile); err != nil {
+			log.Warn("Failed to save DNS cache", "file", cfg.DNSCacheFile, "error", err)
+		} else {
+			log.Info("Saved DNS cache", "file", cfg.DNSCacheFile, "entries", n)
+		}
+	}
 }
@@ -1,6 +1,16 @@
 package main
 
-import "testing"
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
 
 func TestIsDomainHandled(t *testing.T) {
 	s := &DNSServer{customDomains: []string{"loc", "spark.dev"}}
@@ -28,3 +38,183 @@ func TestIsDomainHandled(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateDNSResponseAAAA(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetQuestion("app.spark.dev.", dns.TypeAAAA)
+
+	t.Run("with IPv6 target", func(t *testing.T) {
+		s := &DNSServer{customDomains: []string{"loc", "spark.dev"}, targetIP: "127.0.0.1", targetIPv6: "::1", logger: logger.New("test")}
+		resp := s.createDNSResponse(netip.Addr{}, query)
+		if len(resp.Answer) != 1 || len(resp.Ns) != 0 {
+			t.Fatalf("got %d answers and %d authority records, want 1 and 0", len(resp.Answer), len(resp.Ns))
+		}
+		if aaaa, ok := resp.Answer[0].(*dns.AAAA); !ok || aaaa.AAAA.String() != "::1" {
+			t.Errorf("unexpected answer %v", resp.Answer[0])
+		}
+	})
+
+	t.Run("without IPv6 target", func(t *testing.T) {
+		s := &DNSServer{customDomains: []string{"loc", "spark.dev"}, targetIP: "127.0.0.1", logger: logger.New("test")}
+		resp := s.createDNSResponse(netip.Addr{}, query)
+		if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 || len(resp.Ns) != 1 {
+			t.Fatalf("got rcode %d, %d answers, %d authority records; want NODATA with SOA", resp.Rcode, len(resp.Answer), len(resp.Ns))
+		}
+		if soa, ok := resp.Ns[0].(*dns.SOA); !ok || soa.Hdr.Name != "spark.dev." || soa.Minttl != defaultRecordTTL {
+			t.Errorf("unexpected SOA %v", resp.Ns[0])
+		}
+	})
+}
+
+func TestCreateDNSResponseZoneRecords(t *testing.T) {
+	s := &DNSServer{customDomains: []string{"loc", "spark.dev"}, targetIP: "127.0.0.1", logger: logger.New("test")}
+
+	tests := []struct {
+		name     string
+		qname    string
+		qtype    uint16
+		wantType uint16 // answer type; 0 for NODATA with SOA
+	}{
+		{"soa at apex", "spark.dev.", dns.TypeSOA, dns.TypeSOA},
+		{"ns at apex", "LOC.", dns.TypeNS, dns.TypeNS},
+		{"soa below apex", "app.spark.dev.", dns.TypeSOA, 0},
+		{"ns below apex", "app.loc.", dns.TypeNS, 0},
+		{"mx", "app.loc.", dns.TypeMX, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := new(dns.Msg)
+			query.SetQuestion(tt.qname, tt.qtype)
+			resp := s.createDNSResponse(netip.Addr{}, query)
+
+			if resp.Rcode != dns.RcodeSuccess || !resp.Authoritative {
+				t.Fatalf("rcode = %d, authoritative = %v", resp.Rcode, resp.Authoritative)
+			}
+			if tt.wantType == 0 {
+				if len(resp.Answer) != 0 || len(resp.Ns) != 1 || resp.Ns[0].Header().Rrtype != dns.TypeSOA {
+					t.Errorf("answer = %v, authority = %v, want NODATA with SOA", resp.Answer, resp.Ns)
+				}
+				return
+			}
+			if len(resp.Answer) != 1 || resp.Answer[0].Header().Rrtype != tt.wantType {
+				t.Fatalf("answer = %v, want one %s record", resp.Answer, dns.TypeToString[tt.wantType])
+			}
+		})
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion("loc.", dns.TypeNS)
+	resp := s.createDNSResponse(netip.Addr{}, query)
+	if ns := resp.Answer[0].(*dns.NS); ns.Ns != "ns.loc." {
+		t.Errorf("NS = %s, want ns.loc.", ns.Ns)
+	}
+	if len(resp.Extra) != 1 || resp.Extra[0].Header().Name != "ns.loc." {
+		t.Errorf("glue = %v, want the A record of ns.loc.", resp.Extra)
+	}
+}
+
+// serveLocal serves handler over UDP and TCP on the same local port and
+// returns the address.
+func serveLocal(t *testing.T, handler dns.Handler) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		t.Fatal(err)
+	}
+
+	for _, server := range []*dns.Server{
+		{PacketConn: pc, Handler: handler, MsgAcceptFunc: acceptMsg},
+		{Listener: ln, Handler: handler, MsgAcceptFunc: acceptMsg},
+	} {
+		go server.ActivateAndServe()
+		t.Cleanup(func() { server.Shutdown() })
+	}
+	return pc.LocalAddr().String()
+}
+
+// largeTXTExchange answers every query with 20 TXT records of 200 bytes.
+func largeTXTExchange(r *dns.Msg, _ string) (*dns.Msg, error) {
+	resp := new(dns.Msg)
+	resp.SetReply(r)
+	for i := range 20 {
+		resp.Answer = append(resp.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+			Txt: []string{fmt.Sprintf("%03d", i) + strings.Repeat("x", 197)},
+		})
+	}
+	return resp, nil
+}
+
+func TestHandleDNSRequestTruncatesUDP(t *testing.T) {
+	upstreams := newUpstreamPool(metrics.NewRegistry(), logger.New("test"))
+	upstreams.exchange = largeTXTExchange
+	upstreams.configure([]string{"upstream:53"}, upstreamStrategyRace)
+	s := &DNSServer{
+		customDomains:  []string{"loc"},
+		targetIP:       "127.0.0.1",
+		forwardEnabled: true,
+		upstreams:      upstreams,
+		cache:          newDNSCache(100, metrics.NewRegistry()),
+		logger:         logger.New("test"),
+	}
+	addr := serveLocal(t, dns.HandlerFunc(s.handleDNSRequest))
+
+	// Ten long names without a common suffix to compress: the query fits in
+	// 512 bytes, the answer does not
+	multi := new(dns.Msg)
+	for i := range 10 {
+		name := fmt.Sprintf("%02d%s.loc.", i, strings.Repeat(string(rune('a'+i)), 36))
+		multi.Question = append(multi.Question, dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	}
+	multi.Id = dns.Id()
+	multi.RecursionDesired = true
+
+	txt := new(dns.Msg)
+	txt.SetQuestion("large.example.com.", dns.TypeTXT)
+
+	edns := new(dns.Msg)
+	edns.SetQuestion("large.example.com.", dns.TypeTXT)
+	edns.SetEdns0(dns.DefaultMsgSize, false)
+
+	tests := []struct {
+		name        string
+		query       *dns.Msg
+		wantAnswers int
+		maxUDPSize  int
+	}{
+		{"multi-question local", multi, 10, dns.MinMsgSize},
+		{"large TXT forwarded", txt, 20, dns.MinMsgSize},
+		{"large TXT with EDNS0", edns, 20, dns.DefaultMsgSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			udp := &dns.Client{Net: "udp", UDPSize: dns.MaxMsgSize}
+			resp, _, err := udp.Exchange(tt.query.Copy(), addr)
+			if err != nil {
+				t.Fatalf("UDP exchange error = %v", err)
+			}
+			if !resp.Truncated || len(resp.Answer) >= tt.wantAnswers {
+				t.Errorf("UDP answer: truncated = %v with %d answers, want TC set and fewer than %d", resp.Truncated, len(resp.Answer), tt.wantAnswers)
+			}
+			resp.Compress = true
+			if size := resp.Len(); size > tt.maxUDPSize {
+				t.Errorf("UDP answer is %d bytes, over the %d advertised", size, tt.maxUDPSize)
+			}
+
+			tcp := &dns.Client{Net: "tcp"}
+			resp, _, err = tcp.Exchange(tt.query.Copy(), addr)
+			if err != nil {
+				t.Fatalf("TCP exchange error = %v", err)
+			}
+			if resp.Truncated || len(resp.Answer) != tt.wantAnswers {
+				t.Errorf("TCP answer: truncated = %v with %d answers, want the full %d", resp.Truncated, len(resp.Answer), tt.wantAnswers)
+			}
+		})
+	}
+}
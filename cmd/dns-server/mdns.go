@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sparkfabrik/http-proxy/pkg/mdns"
+)
+
+// mdnsAdvertiser keeps the names of an mDNS responder in line with the
+// configured domains and the container hostnames, advertising those under
+// .local. It is safe to call on a nil receiver, when mDNS is disabled.
+type mdnsAdvertiser struct {
+	responder  *mdns.Responder
+	containers *containerRecords
+
+	mu      sync.Mutex
+	domains []string
+}
+
+// setDomains replaces the configured domains, e.g. on reload.
+func (a *mdnsAdvertiser) setDomains(domains []string) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	a.domains = domains
+	a.mu.Unlock()
+	a.update()
+}
+
+// update advertises the current .local names.
+func (a *mdnsAdvertiser) update() {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.responder.SetNames(localNames(a.domains, a.containers.hostnames()))
+}
+
+// localNames returns the sorted, de-duplicated names under .local among the
+// configured domains and container hostnames. Multicast resolvers only ask
+// for .local names, and only exact names can be advertised, so other
+// domains, the bare "local" TLD and wildcard or regex hosts are left out.
+func localNames(domains, hostnames []string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range append(append([]string{}, domains...), hostnames...) {
+		name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+		if !strings.HasSuffix(name, ".local") || mdns.LocalAlias(name) != name || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
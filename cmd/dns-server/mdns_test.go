@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/mdns"
+)
+
+func TestLocalNames(t *testing.T) {
+	domains := []string{"loc", "local", "Docs.Local.", "*.wild.local"}
+	hostnames := []string{"app.local", "app.loc", "docs.local", "api.app.local"}
+
+	want := []string{"api.app.local", "app.local", "docs.local"}
+	if got := localNames(domains, hostnames); !reflect.DeepEqual(got, want) {
+		t.Errorf("localNames() = %v, want %v", got, want)
+	}
+}
+
+func TestMDNSAdvertiserFollowsContainers(t *testing.T) {
+	containers := newContainerRecords()
+	advertiser := &mdnsAdvertiser{
+		responder:  mdns.NewResponder(net.ParseIP("192.168.1.10"), logger.New("test")),
+		containers: containers,
+	}
+	containers.onChange = advertiser.update
+	advertiser.setDomains([]string{"loc", "docs.local"})
+
+	containers.set("a", []string{"app.local", "app.loc"})
+	want := []string{"app.local.", "docs.local."}
+	if got := advertiser.responder.Names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("names after start = %v, want %v", got, want)
+	}
+
+	containers.remove("a")
+	want = []string{"docs.local."}
+	if got := advertiser.responder.Names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("names after stop = %v, want %v", got, want)
+	}
+
+	// Reloads replace the configured names
+	advertiser.setDomains([]string{"loc"})
+	if got := advertiser.responder.Names(); len(got) != 0 {
+		t.Errorf("names after reload = %v, want none", got)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
+
+// NXDOMAIN protection modes (HTTP_PROXY_DNS_NXDOMAIN_PROTECTION)
+const (
+	nxdomainProtectionOff      = "off"
+	nxdomainProtectionNXDomain = "nxdomain"
+	nxdomainProtectionRequery  = "requery"
+)
+
+// knownSinkholeIPs are ad/search page addresses some resolvers answer with
+// instead of NXDOMAIN. ISP-specific ones are added with
+// HTTP_PROXY_DNS_SINKHOLE_IPS or learned by probing the upstreams.
+var knownSinkholeIPs = []string{
+	"67.215.65.132", // OpenDNS (legacy) search page
+	"92.242.140.2",  // Barefruit, used by several ISPs
+	"92.242.140.21", // Barefruit
+}
+
+// sinkholeProbeTLD hosts the random names used to detect rewriting; the
+// names cannot exist, so any address returned for them is a sinkhole.
+const sinkholeProbeTLD = "invalid."
+
+// nxdomainGuard detects upstream answers that replace NXDOMAIN with sinkhole
+// addresses and either turns them back into NXDOMAIN or asks a clean
+// resolver instead. It is safe for concurrent use.
+type nxdomainGuard struct {
+	mode          string
+	cleanUpstream string
+	exchange      func(r *dns.Msg, server string) (*dns.Msg, error)
+	logger        *logger.Logger
+	rewrites      *metrics.Vec
+
+	mu        sync.RWMutex
+	sinkholes map[string]bool
+}
+
+// newNXDomainGuard returns a guard for mode, or an error for an unknown mode.
+// A nil guard (mode off) leaves responses untouched.
+func newNXDomainGuard(mode string, extraSinkholes []string, cleanUpstream string, registry *metrics.Registry, log *logger.Logger) (*nxdomainGuard, error) {
+	switch mode {
+	case "", nxdomainProtectionOff:
+		return nil, nil
+	case nxdomainProtectionNXDomain, nxdomainProtectionRequery:
+	default:
+		return nil, fmt.Errorf("invalid NXDOMAIN protection mode %q (want %s, %s or %s)",
+			mode, nxdomainProtectionOff, nxdomainProtectionNXDomain, nxdomainProtectionRequery)
+	}
+
+	g := &nxdomainGuard{
+		mode:          mode,
+		cleanUpstream: cleanUpstream,
+		exchange:      newUpstreamExchange(),
+		logger:        log,
+		rewrites:      registry.Counter("http_proxy_dns_nxdomain_rewrites_total", "Upstream answers detected as rewritten NXDOMAIN, by action taken.", "action"),
+		sinkholes:     make(map[string]bool),
+	}
+
+	for _, ip := range append(append([]string(nil), knownSinkholeIPs...), extraSinkholes...) {
+		parsed := net.ParseIP(strings.TrimSpace(ip))
+		if parsed == nil {
+			return nil, fmt.Errorf("invalid sinkhole IP %q", ip)
+		}
+		g.sinkholes[parsed.String()] = true
+	}
+	return g, nil
+}
+
+// learnSinkholes asks each upstream for a name that cannot exist; any
+// address it answers with is recorded as a sinkhole.
+func (g *nxdomainGuard) learnSinkholes(upstreams []string) {
+	for _, server := range upstreams {
+		label := make([]byte, 8)
+		if _, err := rand.Read(label); err != nil {
+			return
+		}
+		query := new(dns.Msg)
+		query.SetQuestion("http-proxy-probe-"+hex.EncodeToString(label)+"."+sinkholeProbeTLD, dns.TypeA)
+
+		resp, err := g.exchange(query, server)
+		if err != nil || resp.Rcode != dns.RcodeSuccess {
+			continue
+		}
+
+		learned := answerIPs(resp)
+		if len(learned) == 0 {
+			continue
+		}
+		g.mu.Lock()
+		for _, ip := range learned {
+			g.sinkholes[ip] = true
+		}
+		g.mu.Unlock()
+		g.logger.Warn("Upstream rewrites NXDOMAIN answers", "server", server, "sinkhole_ips", learned)
+	}
+}
+
+// answerIPs returns the A and AAAA addresses in a response's answer section.
+func answerIPs(resp *dns.Msg) []string {
+	var ips []string
+	for _, rr := range resp.Answer {
+		switch record := rr.(type) {
+		case *dns.A:
+			ips = append(ips, record.A.String())
+		case *dns.AAAA:
+			ips = append(ips, record.AAAA.String())
+		}
+	}
+	return ips
+}
+
+// isRewritten reports whether every address in a successful response is a
+// known sinkhole. Answers mixing real and sinkhole addresses are left alone.
+func (g *nxdomainGuard) isRewritten(resp *dns.Msg) bool {
+	if resp.Rcode != dns.RcodeSuccess {
+		return false
+	}
+	ips := answerIPs(resp)
+	if len(ips) == 0 {
+		return false
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, ip := range ips {
+		if !g.sinkholes[ip] {
+			return false
+		}
+	}
+	return true
+}
+
+// check returns resp unchanged unless it is a rewritten NXDOMAIN, in which
+// case it returns the clean resolver's answer (requery mode) or an honest
+// NXDOMAIN.
+func (g *nxdomainGuard) check(r, resp *dns.Msg) *dns.Msg {
+	if g == nil || !g.isRewritten(resp) {
+		return resp
+	}
+
+	if g.mode == nxdomainProtectionRequery {
+		clean, err := g.exchange(r, g.cleanUpstream)
+		if err == nil && !g.isRewritten(clean) {
+			g.rewrites.Inc("requery")
+			g.logger.Debug("Replaced rewritten NXDOMAIN with clean resolver answer", "server", g.cleanUpstream)
+			return clean
+		}
+		g.logger.Debug("Clean resolver unavailable, answering NXDOMAIN", "server", g.cleanUpstream, "error", err)
+	}
+
+	g.rewrites.Inc("nxdomain")
+	nx := new(dns.Msg)
+	nx.SetRcode(r, dns.RcodeNameError)
+	nx.RecursionAvailable = resp.RecursionAvailable
+	return nx
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
+
+func answerWith(query *dns.Msg, ips ...string) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	for _, ip := range ips {
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP(ip),
+		})
+	}
+	return resp
+}
+
+func testGuard(t *testing.T, mode string, exchange func(*dns.Msg, string) (*dns.Msg, error)) *nxdomainGuard {
+	t.Helper()
+	g, err := newNXDomainGuard(mode, []string{"10.9.9.9"}, "192.0.2.1:53", metrics.NewRegistry(), logger.New("test"))
+	if err != nil {
+		t.Fatalf("newNXDomainGuard: %v", err)
+	}
+	if exchange != nil {
+		g.exchange = exchange
+	}
+	return g
+}
+
+func TestNewNXDomainGuardModes(t *testing.T) {
+	for _, mode := range []string{"", "off"} {
+		g, err := newNXDomainGuard(mode, nil, "", metrics.NewRegistry(), logger.New("test"))
+		if err != nil || g != nil {
+			t.Errorf("mode %q: got guard %v, err %v; want nil, nil", mode, g, err)
+		}
+	}
+	if _, err := newNXDomainGuard("rewrite", nil, "", metrics.NewRegistry(), logger.New("test")); err == nil {
+		t.Error("unknown mode: expected error")
+	}
+	if _, err := newNXDomainGuard("nxdomain", []string{"not-an-ip"}, "", metrics.NewRegistry(), logger.New("test")); err == nil {
+		t.Error("invalid sinkhole IP: expected error")
+	}
+}
+
+func TestNXDomainGuardIsRewritten(t *testing.T) {
+	g := testGuard(t, nxdomainProtectionNXDomain, nil)
+	query := new(dns.Msg)
+	query.SetQuestion("typo.example.", dns.TypeA)
+
+	nx := new(dns.Msg)
+	nx.SetRcode(query, dns.RcodeNameError)
+
+	tests := []struct {
+		name string
+		resp *dns.Msg
+		want bool
+	}{
+		{"built-in sinkhole", answerWith(query, "92.242.140.21"), true},
+		{"configured sinkhole", answerWith(query, "10.9.9.9"), true},
+		{"real address", answerWith(query, "93.184.216.34"), false},
+		{"mixed addresses", answerWith(query, "10.9.9.9", "93.184.216.34"), false},
+		{"empty answer", answerWith(query), false},
+		{"honest NXDOMAIN", nx, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := g.isRewritten(tt.resp); got != tt.want {
+				t.Errorf("isRewritten = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNXDomainGuardCheck(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetQuestion("typo.example.", dns.TypeA)
+	rewritten := answerWith(query, "10.9.9.9")
+
+	t.Run("nil guard passes through", func(t *testing.T) {
+		var g *nxdomainGuard
+		if got := g.check(query, rewritten); got != rewritten {
+			t.Error("nil guard changed the response")
+		}
+	})
+
+	t.Run("nxdomain mode", func(t *testing.T) {
+		g := testGuard(t, nxdomainProtectionNXDomain, func(*dns.Msg, string) (*dns.Msg, error) {
+			t.Fatal("nxdomain mode must not query the clean resolver")
+			return nil, nil
+		})
+		got := g.check(query, rewritten)
+		if got.Rcode != dns.RcodeNameError || len(got.Answer) != 0 || got.Id != query.Id {
+			t.Errorf("got rcode %d with %d answers, want empty NXDOMAIN", got.Rcode, len(got.Answer))
+		}
+		if v := g.rewrites.Value("nxdomain"); v != 1 {
+			t.Errorf("nxdomain counter = %v, want 1", v)
+		}
+	})
+
+	t.Run("requery mode uses clean answer", func(t *testing.T) {
+		clean := new(dns.Msg)
+		clean.SetRcode(query, dns.RcodeNameError)
+		g := testGuard(t, nxdomainProtectionRequery, func(r *dns.Msg, server string) (*dns.Msg, error) {
+			if server != "192.0.2.1:53" {
+				t.Errorf("queried %s, want the clean upstream", server)
+			}
+			return clean, nil
+		})
+		if got := g.check(query, rewritten); got != clean {
+			t.Error("expected the clean resolver's answer")
+		}
+		if v := g.rewrites.Value("requery"); v != 1 {
+			t.Errorf("requery counter = %v, want 1", v)
+		}
+	})
+
+	t.Run("requery mode falls back to NXDOMAIN", func(t *testing.T) {
+		g := testGuard(t, nxdomainProtectionRequery, func(*dns.Msg, string) (*dns.Msg, error) {
+			return nil, errors.New("timeout")
+		})
+		if got := g.check(query, rewritten); got.Rcode != dns.RcodeNameError {
+			t.Errorf("rcode = %d, want NXDOMAIN", got.Rcode)
+		}
+	})
+
+	t.Run("genuine answers untouched", func(t *testing.T) {
+		g := testGuard(t, nxdomainProtectionNXDomain, nil)
+		real := answerWith(query, "93.184.216.34")
+		if got := g.check(query, real); got != real {
+			t.Error("genuine answer was changed")
+		}
+	})
+}
+
+func TestNXDomainGuardLearnSinkholes(t *testing.T) {
+	g := testGuard(t, nxdomainProtectionNXDomain, func(r *dns.Msg, server string) (*dns.Msg, error) {
+		switch server {
+		case "hijacking:53":
+			return answerWith(r, "203.0.113.7"), nil
+		case "honest:53":
+			nx := new(dns.Msg)
+			nx.SetRcode(r, dns.RcodeNameError)
+			return nx, nil
+		}
+		return nil, errors.New("unreachable")
+	})
+	g.learnSinkholes([]string{"hijacking:53", "honest:53", "down:53"})
+
+	query := new(dns.Msg)
+	query.SetQuestion("typo.example.", dns.TypeA)
+	if !g.isRewritten(answerWith(query, "203.0.113.7")) {
+		t.Error("address returned for a nonexistent name was not learned")
+	}
+}
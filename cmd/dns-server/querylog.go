@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+// queryLog writes a JSON record for a sample of the queries: client, name,
+// type, response code, answer source and latency. A nil queryLog records
+// nothing.
+type queryLog struct {
+	logger *logger.Logger
+	rate   float64
+	sample func() float64
+}
+
+// newQueryLog returns a query log recording the given fraction of queries.
+func newQueryLog(rate float64, log *logger.Logger) (*queryLog, error) {
+	if rate <= 0 || rate > 1 {
+		return nil, fmt.Errorf("invalid query log sample rate %v (want a fraction above 0, up to 1)", rate)
+	}
+	return &queryLog{logger: log, rate: rate, sample: rand.Float64}, nil
+}
+
+// sampleRate returns the fraction of queries recorded, 0 when disabled.
+func (q *queryLog) sampleRate() float64 {
+	if q == nil {
+		return 0
+	}
+	return q.rate
+}
+
+// record logs a query and its response, which is nil when the query was
+// dropped.
+func (q *queryLog) record(client net.Addr, r, resp *dns.Msg, source string, latency time.Duration) {
+	if q == nil || len(r.Question) == 0 || (q.rate < 1 && q.sample() >= q.rate) {
+		return
+	}
+
+	question := r.Question[0]
+	args := []any{
+		"client", addrString(client),
+		"qname", question.Name,
+		"qtype", dns.TypeToString[question.Qtype],
+		"source", source,
+		"latency_ms", float64(latency.Microseconds()) / 1000,
+	}
+	if resp != nil {
+		args = append(args, "rcode", dns.RcodeToString[resp.Rcode], "answers", len(resp.Answer))
+	}
+	q.logger.Info("dns query", args...)
+}
+
+// addrString returns the IP of a client address, or "" when it is unknown.
+func addrString(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.TCPAddr:
+		return a.IP.String()
+	case nil:
+		return ""
+	default:
+		return a.String()
+	}
+}
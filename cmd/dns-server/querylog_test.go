@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+func TestQueryLogRecords(t *testing.T) {
+	var out bytes.Buffer
+	queries, err := newQueryLog(1, logger.NewJSON("dns-query", &out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &DNSServer{customDomains: []string{"loc"}, targetIP: "127.0.0.1", queryLog: queries, logger: logger.New("test")}
+
+	tests := []struct {
+		qname      string
+		wantSource string
+		wantRcode  string
+	}{
+		{"myapp.loc.", sourceLocal, "NOERROR"},
+		{"example.com.", sourceRefused, "REFUSED"},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		query := new(dns.Msg)
+		query.SetQuestion(tt.qname, dns.TypeA)
+		s.handleDNSRequest(&dohResponseWriter{remote: &net.UDPAddr{IP: net.ParseIP("172.17.0.1"), Port: 5300}}, query)
+
+		var record map[string]any
+		if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+			t.Fatalf("%s: invalid JSON record %q: %v", tt.qname, out.String(), err)
+		}
+		want := map[string]any{"client": "172.17.0.1", "qname": tt.qname, "qtype": "A", "source": tt.wantSource, "rcode": tt.wantRcode}
+		for key, value := range want {
+			if record[key] != value {
+				t.Errorf("%s: %s = %v, want %v", tt.qname, key, record[key], value)
+			}
+		}
+		if _, ok := record["latency_ms"].(float64); !ok {
+			t.Errorf("%s: missing latency_ms in %v", tt.qname, record)
+		}
+	}
+}
+
+func TestQueryLogSampling(t *testing.T) {
+	var out bytes.Buffer
+	queries, err := newQueryLog(0.25, logger.NewJSON("dns-query", &out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := []float64{0.1, 0.5, 0.9, 0.2}
+	queries.sample = func() float64 {
+		v := samples[0]
+		samples = samples[1:]
+		return v
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion("myapp.loc.", dns.TypeA)
+	for range 4 {
+		queries.record(nil, query, nil, sourceDropped, 0)
+	}
+	if got := strings.Count(out.String(), "\n"); got != 2 {
+		t.Errorf("recorded %d queries, want the 2 sampled:\n%s", got, out.String())
+	}
+}
+
+func TestNewQueryLogRejectsInvalidRate(t *testing.T) {
+	for _, rate := range []float64{0, -0.5, 1.5} {
+		if _, err := newQueryLog(rate, logger.New("test")); err == nil {
+			t.Errorf("newQueryLog(%v): expected error", rate)
+		}
+	}
+}
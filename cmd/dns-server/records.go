@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// extraRecordTypes are the record types HTTP_PROXY_DNS_EXTRA_RECORDS accepts
+var extraRecordTypes = map[string]uint16{
+	"CNAME": dns.TypeCNAME,
+	"TXT":   dns.TypeTXT,
+}
+
+// extraRecords holds the configured non-A records, keyed by lowercase FQDN.
+type extraRecords map[string][]dns.RR
+
+// parseExtraRecords parses HTTP_PROXY_DNS_EXTRA_RECORDS: "<name> <TYPE>
+// <value>" entries separated by ";" or newlines, e.g.
+//
+//	www.app.loc CNAME app.loc; app.loc TXT "v=spf1 -all"
+//
+// TXT values use zone file syntax, so quote values containing spaces or ";"
+// (DKIM keys). A name can have several TXT records but only one CNAME, and no
+// other record alongside it.
+func parseExtraRecords(spec string) (extraRecords, error) {
+	records := make(extraRecords)
+
+	for _, entry := range splitRecordEntries(spec) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		fields := strings.Fields(entry)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("invalid DNS record %q: expected \"<name> <type> <value>\"", entry)
+		}
+		name := dns.Fqdn(strings.ToLower(fields[0]))
+		typeName := strings.ToUpper(fields[1])
+		if _, ok := extraRecordTypes[typeName]; !ok {
+			return nil, fmt.Errorf("invalid DNS record %q: unsupported type %s (want CNAME or TXT)", entry, fields[1])
+		}
+		value := strings.TrimSpace(strings.TrimPrefix(entry, fields[0]))
+		value = strings.TrimSpace(strings.TrimPrefix(value, fields[1]))
+		if typeName == "CNAME" {
+			value = dns.Fqdn(strings.ToLower(value))
+		}
+
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, defaultRecordTTL, typeName, value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid DNS record %q: %w", entry, err)
+		}
+
+		existing := records[name]
+		if len(existing) > 0 && (typeName == "CNAME" || existing[0].Header().Rrtype == dns.TypeCNAME) {
+			return nil, fmt.Errorf("invalid DNS record %q: a CNAME cannot coexist with other records for %s", entry, name)
+		}
+		records[name] = append(existing, rr)
+	}
+
+	return records, nil
+}
+
+// splitRecordEntries splits spec on ";" and newlines outside double quotes,
+// keeping backslash escapes inside quotes.
+func splitRecordEntries(spec string) []string {
+	var (
+		entries []string
+		start   int
+		quoted  bool
+		escaped bool
+	)
+	for i, r := range spec {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quoted:
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case (r == ';' || r == '\n') && !quoted:
+			entries = append(entries, spec[start:i])
+			start = i + 1
+		}
+	}
+	return append(entries, spec[start:])
+}
+
+// cname returns the CNAME record configured for the question's name, if any,
+// owned by the name as the client spelled it.
+func (r extraRecords) cname(question dns.Question) *dns.CNAME {
+	if answers := r.lookup(question, dns.TypeCNAME); len(answers) > 0 {
+		return answers[0].(*dns.CNAME)
+	}
+	return nil
+}
+
+// lookup returns copies of the records of type qtype configured for the
+// question's name, owned by the name as the client spelled it.
+func (r extraRecords) lookup(question dns.Question, qtype uint16) []dns.RR {
+	var answers []dns.RR
+	for _, rr := range r[strings.ToLower(question.Name)] {
+		if rr.Header().Rrtype == qtype {
+			answer := dns.Copy(rr)
+			answer.Header().Name = question.Name
+			answers = append(answers, answer)
+		}
+	}
+	return answers
+}
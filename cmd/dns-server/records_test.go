@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+func TestParseExtraRecords(t *testing.T) {
+	records, err := parseExtraRecords(`www.App.loc CNAME app.loc; app.loc TXT "v=spf1 -all"; app.loc txt plain ;`)
+	if err != nil {
+		t.Fatalf("parseExtraRecords: %v", err)
+	}
+
+	cname, ok := records["www.app.loc."][0].(*dns.CNAME)
+	if !ok || cname.Target != "app.loc." || cname.Hdr.Ttl != defaultRecordTTL {
+		t.Errorf("unexpected CNAME: %v", records["www.app.loc."])
+	}
+
+	txt := records["app.loc."]
+	if len(txt) != 2 || strings.Join(txt[0].(*dns.TXT).Txt, "") != "v=spf1 -all" || txt[1].(*dns.TXT).Txt[0] != "plain" {
+		t.Errorf("unexpected TXT records: %v", txt)
+	}
+}
+
+func TestParseExtraRecordsDKIM(t *testing.T) {
+	const dkim = "v=DKIM1; k=rsa; p=MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQC1"
+	spec := "mail._domainkey.app.loc TXT \"" + dkim + "\"\nwww.app.loc CNAME app.loc\napp.loc TXT \"say \\\"hi;\\\"\""
+	records, err := parseExtraRecords(spec)
+	if err != nil {
+		t.Fatalf("parseExtraRecords: %v", err)
+	}
+
+	txt := records["mail._domainkey.app.loc."]
+	if len(txt) != 1 || strings.Join(txt[0].(*dns.TXT).Txt, "") != dkim {
+		t.Errorf("unexpected DKIM record: %v", txt)
+	}
+	if _, ok := records["www.app.loc."][0].(*dns.CNAME); !ok {
+		t.Errorf("unexpected CNAME: %v", records["www.app.loc."])
+	}
+	escaped := records["app.loc."]
+	if len(escaped) != 1 || strings.Join(escaped[0].(*dns.TXT).Txt, "") != `say \"hi;\"` {
+		t.Errorf("unexpected escaped TXT record: %v", escaped)
+	}
+}
+
+func TestParseExtraRecordsErrors(t *testing.T) {
+	tests := []string{
+		"app.loc CNAME",
+		"app.loc MX 10 mail.app.loc",
+		"app.loc CNAME a.loc; app.loc CNAME b.loc",
+		"app.loc TXT x; app.loc CNAME b.loc",
+		"app.loc CNAME b.loc; app.loc TXT x",
+	}
+	for _, spec := range tests {
+		if _, err := parseExtraRecords(spec); err == nil {
+			t.Errorf("parseExtraRecords(%q): expected error", spec)
+		}
+	}
+}
+
+func TestHandleQuestionExtraRecords(t *testing.T) {
+	records, err := parseExtraRecords(`www.app.loc CNAME app.loc; ext.app.loc CNAME example.com; app.loc TXT "hello"`)
+	if err != nil {
+		t.Fatalf("parseExtraRecords: %v", err)
+	}
+	s := &DNSServer{customDomains: []string{"loc"}, targetIP: "127.0.0.1", records: records, logger: logger.New("test")}
+
+	tests := []struct {
+		name  string
+		qname string
+		qtype uint16
+		want  []uint16
+	}{
+		{"A follows local CNAME", "WWW.app.loc.", dns.TypeA, []uint16{dns.TypeCNAME, dns.TypeA}},
+		{"A stops at external CNAME", "ext.app.loc.", dns.TypeA, []uint16{dns.TypeCNAME}},
+		{"CNAME query", "www.app.loc.", dns.TypeCNAME, []uint16{dns.TypeCNAME}},
+		{"TXT via CNAME", "www.app.loc.", dns.TypeTXT, []uint16{dns.TypeCNAME}},
+		{"TXT", "app.loc.", dns.TypeTXT, []uint16{dns.TypeTXT}},
+		{"TXT without records", "other.loc.", dns.TypeTXT, nil},
+		{"A without records", "app.loc.", dns.TypeA, []uint16{dns.TypeA}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := new(dns.Msg)
+			s.handleQuestion(netip.Addr{}, dns.Question{Name: tt.qname, Qtype: tt.qtype, Qclass: dns.ClassINET}, msg)
+
+			var got []uint16
+			for _, rr := range msg.Answer {
+				got = append(got, rr.Header().Rrtype)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("answer types = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("answer types = %v, want %v", got, tt.want)
+				}
+			}
+			if len(msg.Answer) > 0 && msg.Answer[0].Header().Name != tt.qname {
+				t.Errorf("answer owner = %q, want the question name %q", msg.Answer[0].Header().Name, tt.qname)
+			}
+		})
+	}
+}
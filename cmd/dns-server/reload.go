@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+// configFilePollInterval is how often the config file is checked for changes
+const configFilePollInterval = 2 * time.Second
+
+// newDNSServer builds a server answering with the reloadable settings of cfg
+// (domains, target IPs and domain map, forwarding, upstreams, extra records,
+// query log, client ACL, rate limit, blocklist and embedded DNS domains),
+// validating them. Listeners, the cache and the other long-lived parts are
+// attached by the caller.
+func newDNSServer(cfg *config.Config, log *logger.Logger) (*DNSServer, error) {
+	server := &DNSServer{
+		customDomains:    cfg.Domains,
+		targetIP:         cfg.DNSIP,
+		targetIPv6:       cfg.DNSIPv6,
+		port:             cfg.DNSPort,
+		forwardEnabled:   cfg.DNSForwardEnabled,
+		upstreamServers:  cfg.DNSUpstreamServers,
+		upstreamStrategy: cfg.DNSUpstreamStrategy,
+		rateLimit:        cfg.DNSRateLimit,
+		profile:          cfg.Profile,
+		logger:           log,
+		ctx:              context.Background(),
+	}
+
+	if server.rateLimit < 0 {
+		return nil, fmt.Errorf("invalid HTTP_PROXY_DNS_RATE_LIMIT %d, must not be negative", server.rateLimit)
+	}
+	acl, err := newClientACL(cfg.DNSAllowedCIDRs, cfg.DNSDeniedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	server.acl = acl
+
+	if !validUpstreamStrategy(server.upstreamStrategy) {
+		return nil, fmt.Errorf("invalid upstream strategy %q (want %s or %s)",
+			server.upstreamStrategy, upstreamStrategyRace, upstreamStrategySequential)
+	}
+
+	for _, upstream := range server.upstreamServers {
+		if err := validateUpstream(upstream); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(server.customDomains) == 0 {
+		return nil, fmt.Errorf("no domains/TLDs configured")
+	}
+
+	// The target must be IPv4; an IPv6 address would be silently truncated
+	// into a 4-byte A record
+	if ip := net.ParseIP(cfg.DNSIP); ip == nil || ip.To4() == nil {
+		return nil, fmt.Errorf("invalid target IP address %q, must be IPv4", cfg.DNSIP)
+	}
+	if cfg.DNSIPv6 != "" {
+		if ip := net.ParseIP(cfg.DNSIPv6); ip == nil || ip.To4() != nil {
+			return nil, fmt.Errorf("invalid IPv6 target address %q", cfg.DNSIPv6)
+		}
+	}
+
+	embedded, err := newEmbeddedResolver(cfg.DNSEmbeddedDomains, cfg.DNSEmbeddedServer, cfg.Domains, log)
+	if err != nil {
+		return nil, err
+	}
+	server.embedded = embedded
+
+	targets, err := parseDomainMap(cfg.DNSDomainMap)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP_PROXY_DNS_DOMAIN_MAP: %w", err)
+	}
+	for domain := range targets {
+		if !server.isDomainHandled(domain) {
+			return nil, fmt.Errorf("mapped domain %q outside the configured domains %v", domain, cfg.Domains)
+		}
+	}
+	server.domainTargets = targets
+
+	overrides, err := parseClientMap(cfg.DNSClientMap)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP_PROXY_DNS_CLIENT_MAP: %w", err)
+	}
+	for _, override := range overrides {
+		if override.domain != "" && !server.isDomainHandled(override.domain) {
+			return nil, fmt.Errorf("client mapped domain %q outside the configured domains %v", override.domain, cfg.Domains)
+		}
+	}
+	server.clientTargets = overrides
+
+	if cfg.DNSQueryLog {
+		queries, err := newQueryLog(cfg.DNSQueryLogSample, logger.NewJSON("dns-query", os.Stdout))
+		if err != nil {
+			return nil, fmt.Errorf("invalid HTTP_PROXY_DNS_QUERY_LOG_SAMPLE: %w", err)
+		}
+		server.queryLog = queries
+	}
+
+	records, err := parseExtraRecords(cfg.DNSExtraRecords)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP_PROXY_DNS_EXTRA_RECORDS: %w", err)
+	}
+	for name := range records {
+		if !server.isDomainHandled(name) {
+			return nil, fmt.Errorf("extra DNS record %q outside the configured domains %v", name, cfg.Domains)
+		}
+	}
+	server.records = records
+
+	blocklist, err := newDomainBlocklist(cfg.DNSBlocklist, cfg.DNSBlocklistFile, cfg.DNSBlockResponse, log)
+	if err != nil {
+		return nil, err
+	}
+	server.blocklist = blocklist
+
+	return server, nil
+}
+
+// dnsReloader serves queries with the current server and swaps in a new one
+// when the configuration is reloaded. Queries in flight finish with the
+// settings they started with; the listeners are never restarted.
+type dnsReloader struct {
+	configFile string
+	logger     *logger.Logger
+	current    atomic.Pointer[DNSServer]
+
+	// files are those the configuration was read from: the config file and
+	// the profile files, watched for changes with the blocklist file
+	files []string
+
+	// onReload, when set, is called with each server swapped in
+	onReload func(*DNSServer)
+
+	// mu serializes reloads
+	mu sync.Mutex
+}
+
+// newDNSReloader serves queries with server, reloading from the environment,
+// the selected profile and configFile (which may be empty). files are
+// watched for changes, as returned in config.Config.Files.
+func newDNSReloader(server *DNSServer, configFile string, files []string, log *logger.Logger) *dnsReloader {
+	r := &dnsReloader{configFile: configFile, files: files, logger: log}
+	r.current.Store(server)
+	return r
+}
+
+// handleDNSRequest answers a query with the current server.
+func (r *dnsReloader) handleDNSRequest(w dns.ResponseWriter, msg *dns.Msg) {
+	r.current.Load().handleDNSRequest(w, msg)
+}
+
+// reload reads the configuration again and, when it is valid, atomically
+// replaces the answering settings. An invalid configuration keeps the
+// previous one. The port, cache, NXDOMAIN protection, container records,
+// mDNS responder, upstream health and rate limit buckets are kept from the
+// running server.
+func (r *dnsReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := config.LoadWithFile(r.configFile)
+	if err != nil {
+		return err
+	}
+	next, err := newDNSServer(cfg, r.logger)
+	if err != nil {
+		return err
+	}
+	// A new profile selection changes the files to watch
+	r.files = cfg.Files
+
+	previous := r.current.Load()
+	next.port = previous.port
+	next.ctx = previous.ctx
+	next.cache = previous.cache
+	next.nxdomain = previous.nxdomain
+	next.containers = previous.containers
+	next.mdns = previous.mdns
+	next.upstreams = previous.upstreams
+	next.limiter = previous.limiter
+	next.limiter.setRate(next.rateLimit)
+	if next.upstreams != nil {
+		next.upstreams.configure(next.upstreamServers, next.upstreamStrategy)
+	}
+	if next.forwardEnabled && next.cache == nil {
+		r.logger.Warn("Forwarding enabled by reload; answers are not cached until restart")
+	}
+	r.current.Store(next)
+	next.mdns.setDomains(next.customDomains)
+	if r.onReload != nil {
+		r.onReload(next)
+	}
+
+	r.logger.Info("Reloaded DNS configuration",
+		"profile", next.profile,
+		"domains", next.customDomains,
+		"target_ip", next.targetIP,
+		"forward_enabled", next.forwardEnabled,
+		"upstream_servers", next.upstreamServers,
+		"changed", !sameSettings(previous, next))
+	return nil
+}
+
+// watch reloads the configuration when one of its files changes, until ctx
+// is done. It does nothing without files to watch.
+func (r *dnsReloader) watch(ctx context.Context, interval time.Duration) {
+	if len(r.watchedFiles()) == 0 {
+		return
+	}
+
+	last := filesVersion(r.watchedFiles())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			version := filesVersion(r.watchedFiles())
+			if version == last {
+				continue
+			}
+			if err := r.reload(); err != nil {
+				r.logger.Error("Failed to reload DNS configuration, keeping the previous one", "file", r.configFile, "error", err)
+			}
+			// Files may have been added or dropped by a new profile selection
+			last = filesVersion(r.watchedFiles())
+		}
+	}
+}
+
+// watchedFiles returns the files of the current configuration and the
+// blocklist file it reads.
+func (r *dnsReloader) watchedFiles() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append(slices.Clone(r.files), r.current.Load().blocklist.files()...)
+}
+
+// filesVersion identifies the content of files, see fileVersion.
+func filesVersion(files []string) string {
+	versions := make([]string, len(files))
+	for i, file := range files {
+		versions[i] = file + "@" + fileVersion(file)
+	}
+	return strings.Join(versions, ",")
+}
+
+// fileVersion identifies the content of a file by modification time and
+// size; it is empty when the file cannot be read.
+func fileVersion(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", info.ModTime().UnixNano(), info.Size())
+}
+
+// sameSettings reports whether two servers answer with the same settings.
+func sameSettings(a, b *DNSServer) bool {
+	return reflect.DeepEqual(a.customDomains, b.customDomains) &&
+		a.targetIP == b.targetIP &&
+		a.targetIPv6 == b.targetIPv6 &&
+		reflect.DeepEqual(a.domainTargets, b.domainTargets) &&
+		reflect.DeepEqual(a.clientTargets, b.clientTargets) &&
+		a.forwardEnabled == b.forwardEnabled &&
+		reflect.DeepEqual(a.upstreamServers, b.upstreamServers) &&
+		a.upstreamStrategy == b.upstreamStrategy &&
+		reflect.DeepEqual(a.records, b.records) &&
+		a.queryLog.sampleRate() == b.queryLog.sampleRate() &&
+		reflect.DeepEqual(a.acl, b.acl) &&
+		a.rateLimit == b.rateLimit &&
+		reflect.DeepEqual(a.blocklist, b.blocklist) &&
+		a.profile == b.profile
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
+
+func TestNewDNSServerValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantErr bool
+	}{
+		{"valid", config.Config{Domains: []string{"loc"}, DNSIP: "127.0.0.1"}, false},
+		{"no domains", config.Config{DNSIP: "127.0.0.1"}, true},
+		{"IPv6 target IP", config.Config{Domains: []string{"loc"}, DNSIP: "::1"}, true},
+		{"IPv4 AAAA target", config.Config{Domains: []string{"loc"}, DNSIP: "127.0.0.1", DNSIPv6: "127.0.0.1"}, true},
+		{"unknown upstream strategy", config.Config{Domains: []string{"loc"}, DNSIP: "127.0.0.1", DNSUpstreamStrategy: "random"}, true},
+		{"record outside domains", config.Config{Domains: []string{"loc"}, DNSIP: "127.0.0.1", DNSExtraRecords: "www.example.com CNAME app.loc"}, true},
+		{"domain map", config.Config{Domains: []string{"loc", "test"}, DNSIP: "127.0.0.1", DNSDomainMap: "test=192.168.64.2"}, false},
+		{"query log sample out of range", config.Config{Domains: []string{"loc"}, DNSIP: "127.0.0.1", DNSQueryLog: true, DNSQueryLogSample: 2}, true},
+		{"mapped domain outside domains", config.Config{Domains: []string{"loc"}, DNSIP: "127.0.0.1", DNSDomainMap: "test=192.168.64.2"}, true},
+		{"client map", config.Config{Domains: []string{"loc"}, DNSIP: "127.0.0.1", DNSClientMap: "192.168.1.50=192.168.1.10"}, false},
+		{"client mapped domain outside domains", config.Config{Domains: []string{"loc"}, DNSIP: "127.0.0.1", DNSClientMap: "app.test@192.168.1.50=192.168.1.10"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newDNSServer(&tt.cfg, logger.New("test")); (err != nil) != tt.wantErr {
+				t.Errorf("newDNSServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDomainStatus(t *testing.T) {
+	server, err := newDNSServer(&config.Config{
+		Domains:      []string{"loc", "test"},
+		DNSIP:        "127.0.0.1",
+		DNSIPv6:      "::1",
+		DNSDomainMap: "test=192.168.64.2,api.loc=10.0.0.5",
+	}, logger.New("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []DomainStatus{
+		{Domain: "loc", TargetIP: "127.0.0.1", TargetIPv6: "::1"},
+		{Domain: "test", TargetIP: "192.168.64.2"},
+		{Domain: "api.loc", TargetIP: "10.0.0.5"},
+	}
+	if got := server.domainStatus(); !reflect.DeepEqual(got, want) {
+		t.Errorf("domainStatus() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDNSReloaderReload(t *testing.T) {
+	t.Setenv("HTTP_PROXY_DNS_TLDS", "loc")
+	file := filepath.Join(t.TempDir(), "dns.env")
+	if err := os.WriteFile(file, []byte("HTTP_PROXY_DNS_TARGET_IP=10.0.0.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	initial := &DNSServer{customDomains: []string{"loc"}, targetIP: "127.0.0.1", port: "5353", cache: newDNSCache(10, metrics.NewRegistry()), logger: logger.New("test")}
+	r := newDNSReloader(initial, file, []string{file}, logger.New("test"))
+	var reloaded *DNSServer
+	r.onReload = func(s *DNSServer) { reloaded = s }
+
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	current := r.current.Load()
+	if current.targetIP != "10.0.0.1" {
+		t.Errorf("target IP = %q, want the config file value", current.targetIP)
+	}
+	if reloaded != current {
+		t.Error("onReload was not called with the new server")
+	}
+	if current.port != "5353" || current.cache != initial.cache {
+		t.Error("the port and cache must be kept across reloads")
+	}
+
+	// An invalid configuration keeps the previous one
+	if err := os.WriteFile(file, []byte("HTTP_PROXY_DNS_TARGET_IP=not-an-ip\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reload(); err == nil {
+		t.Error("expected an error for an invalid target IP")
+	}
+	if r.current.Load() != current {
+		t.Error("an invalid configuration replaced the running one")
+	}
+}
+
+func TestDNSReloaderWatch(t *testing.T) {
+	t.Setenv("HTTP_PROXY_DNS_TLDS", "loc")
+	file := filepath.Join(t.TempDir(), "dns.env")
+	if err := os.WriteFile(file, []byte("HTTP_PROXY_DNS_TLDS=loc\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newDNSReloader(&DNSServer{customDomains: []string{"loc"}, targetIP: "127.0.0.1", logger: logger.New("test")}, file, []string{file}, logger.New("test"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.watch(ctx, 10*time.Millisecond)
+
+	// Let the watcher record the initial version before changing the file
+	time.Sleep(30 * time.Millisecond)
+	if err := os.WriteFile(file, []byte("HTTP_PROXY_DNS_TLDS=loc,test\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !r.current.Load().isDomainHandled("app.test.") {
+		if time.Now().After(deadline) {
+			t.Fatal("config file change was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDNSReloaderWatchProfile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HTTP_PROXY_DNS_TLDS", "loc")
+	t.Setenv("HTTP_PROXY_PROFILES_DIR", dir)
+	t.Setenv("HTTP_PROXY_PROFILE", "")
+	if err := os.WriteFile(filepath.Join(dir, "office.env"), []byte("HTTP_PROXY_DNS_TARGET_IP=10.1.2.3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.LoadWithFile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := newDNSServer(cfg, logger.New("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newDNSReloader(server, "", cfg.Files, logger.New("test"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.watch(ctx, 10*time.Millisecond)
+
+	// Selecting a profile at runtime reloads without a config file
+	time.Sleep(30 * time.Millisecond)
+	if err := config.WriteActiveProfile(dir, "office"); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for current := r.current.Load(); current.profile != "office" || current.targetIP != "10.1.2.3"; current = r.current.Load() {
+		if time.Now().After(deadline) {
+			t.Fatalf("profile selection was not reloaded: profile %q, target %q", current.profile, current.targetIP)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
+
+const (
+	// selfTestTimeout bounds each self-test query
+	selfTestTimeout = 5 * time.Second
+
+	// selfTestLabel is the name queried under each handled domain
+	selfTestLabel = "http-proxy-self-test"
+)
+
+// Self-test checks
+const (
+	selfTestDomain  = "domain"
+	selfTestForward = "forward"
+)
+
+// selfTestResult is the outcome of one self-test query.
+type selfTestResult struct {
+	Check    string
+	Name     string
+	Answer   string
+	Duration time.Duration
+	Err      error
+}
+
+// selfTester queries the server through its own listener right after it
+// binds, so a wrong target IP or a broken upstream shows at startup instead
+// of when a user first notices failures.
+type selfTester struct {
+	addr     string
+	client   *dns.Client
+	logger   *logger.Logger
+	success  *metrics.Vec
+	duration *metrics.Vec
+}
+
+func newSelfTester(addr string, registry *metrics.Registry, log *logger.Logger) *selfTester {
+	return &selfTester{
+		addr:     addr,
+		client:   &dns.Client{Net: "udp", Timeout: selfTestTimeout},
+		logger:   log,
+		success:  registry.Gauge("http_proxy_dns_self_test_success", "Whether a startup self-test query got the expected answer (1) or not (0), by check and name.", "check", "name"),
+		duration: registry.Gauge("http_proxy_dns_self_test_duration_seconds", "Time a startup self-test query took, by check and name.", "check", "name"),
+	}
+}
+
+// selfTestAddr returns the address to query a listener bound to addr: the
+// loopback address of its family when it listens on every interface.
+func selfTestAddr(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+		if ip != nil && ip.To4() == nil {
+			host = "::1"
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// run queries a name under every domain the server answers and, when
+// forwarding is enabled, externalName (empty skips it). Each result is
+// logged and exported.
+func (t *selfTester) run(ctx context.Context, server *DNSServer, externalName string) []selfTestResult {
+	var results []selfTestResult
+	for _, domain := range server.domainStatus() {
+		results = append(results, t.checkDomain(ctx, domain))
+	}
+	if server.forwardEnabled && externalName != "" {
+		results = append(results, t.checkForward(ctx, externalName))
+	}
+
+	for _, result := range results {
+		args := []any{"check", result.Check, "name", result.Name, "duration", result.Duration}
+		if result.Err != nil {
+			t.success.Set(0, result.Check, result.Name)
+			t.logger.Warn("DNS self-test failed", append(args, "error", result.Err)...)
+		} else {
+			t.success.Set(1, result.Check, result.Name)
+			t.logger.Info("DNS self-test passed", append(args, "answer", result.Answer)...)
+		}
+		t.duration.Set(result.Duration.Seconds(), result.Check, result.Name)
+	}
+	return results
+}
+
+// checkDomain expects the A record of a name under domain to be its target.
+func (t *selfTester) checkDomain(ctx context.Context, domain DomainStatus) selfTestResult {
+	name := selfTestLabel + "." + domain.Domain
+	result, resp := t.query(ctx, selfTestDomain, name)
+	if result.Err != nil {
+		return result
+	}
+
+	if ip := net.ParseIP(domain.TargetIP); ip != nil && (ip.IsUnspecified() || ip.IsMulticast()) {
+		result.Err = fmt.Errorf("target IP %s is not reachable by clients", domain.TargetIP)
+		return result
+	}
+	for _, rr := range resp.Answer {
+		if a, ok := rr.(*dns.A); ok && a.A.String() == domain.TargetIP {
+			return result
+		}
+	}
+	result.Err = fmt.Errorf("answered %q, want %s", result.Answer, domain.TargetIP)
+	return result
+}
+
+// checkForward expects an external name to resolve through the upstreams.
+func (t *selfTester) checkForward(ctx context.Context, name string) selfTestResult {
+	result, resp := t.query(ctx, selfTestForward, name)
+	if result.Err == nil && len(resp.Answer) == 0 {
+		result.Err = errors.New("no answer from the upstream servers")
+	}
+	return result
+}
+
+// query sends an A query for name to the server. The result has an error
+// when the query fails or is not answered with NOERROR.
+func (t *selfTester) query(ctx context.Context, check, name string) (selfTestResult, *dns.Msg) {
+	result := selfTestResult{Check: check, Name: name}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	start := time.Now()
+	resp, _, err := t.client.ExchangeContext(ctx, msg, t.addr)
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Err = err
+		return result, nil
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		result.Err = fmt.Errorf("answered %s", dns.RcodeToString[resp.Rcode])
+		return result, resp
+	}
+	for _, rr := range resp.Answer {
+		if a, ok := rr.(*dns.A); ok {
+			result.Answer = a.A.String()
+			break
+		}
+	}
+	return result, resp
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
+
+func TestSelfTest(t *testing.T) {
+	upstreams := newUpstreamPool(metrics.NewRegistry(), logger.New("test"))
+	upstreams.exchange = func(r *dns.Msg, server string) (*dns.Msg, error) {
+		if r.Question[0].Name != "example.com." {
+			return nil, errors.New("unreachable")
+		}
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("93.184.216.34"),
+		})
+		return resp, nil
+	}
+	upstreams.configure([]string{"upstream:53"}, upstreamStrategyRace)
+	s := &DNSServer{
+		customDomains:  []string{"loc", "test"},
+		targetIP:       "127.0.0.1",
+		domainTargets:  domainMap{"test": {ipv4: "0.0.0.0"}},
+		forwardEnabled: true,
+		upstreams:      upstreams,
+		logger:         logger.New("test"),
+	}
+	addr := serveLocal(t, dns.HandlerFunc(s.handleDNSRequest))
+	registry := metrics.NewRegistry()
+	tester := newSelfTester(addr, registry, logger.New("test"))
+
+	results := tester.run(context.Background(), s, "example.com")
+	if len(results) != 3 {
+		t.Fatalf("results = %+v, want two domains and the forward check", results)
+	}
+	if r := results[0]; r.Name != "http-proxy-self-test.loc" || r.Err != nil || r.Answer != "127.0.0.1" {
+		t.Errorf("loc check = %+v", r)
+	}
+	if r := results[1]; r.Err == nil {
+		t.Errorf("unroutable target check = %+v, want an error", r)
+	}
+	if r := results[2]; r.Check != selfTestForward || r.Err != nil || r.Answer != "93.184.216.34" {
+		t.Errorf("forward check = %+v", r)
+	}
+	if got := tester.success.Value(selfTestDomain, "http-proxy-self-test.test"); got != 0 {
+		t.Errorf("success gauge of the failed check = %v, want 0", got)
+	}
+	if got := tester.success.Value(selfTestForward, "example.com"); got != 1 {
+		t.Errorf("success gauge of the forward check = %v, want 1", got)
+	}
+
+	// A broken upstream fails the forward check
+	if results := tester.run(context.Background(), s, "broken.example"); results[2].Err == nil {
+		t.Errorf("forward check with a failing upstream = %+v, want an error", results[2])
+	}
+}
+
+func TestSelfTestAddr(t *testing.T) {
+	tests := []struct {
+		addr net.Addr
+		want string
+	}{
+		{&net.UDPAddr{IP: net.IPv4zero, Port: 19322}, "127.0.0.1:19322"},
+		{&net.UDPAddr{IP: net.IPv6unspecified, Port: 19322}, "[::1]:19322"},
+		{&net.UDPAddr{Port: 19322}, "127.0.0.1:19322"},
+		{&net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 53}, "192.168.1.10:53"},
+	}
+	for _, tt := range tests {
+		if got := selfTestAddr(tt.addr); got != tt.want {
+			t.Errorf("selfTestAddr(%v) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
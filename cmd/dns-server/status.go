@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/sparkfabrik/http-proxy/pkg/state"
+)
+
+// statusName is the state store snapshot describing the running server
+const statusName = "dns-server"
+
+// DNSStatus records where the server actually listens, so the CLI can report
+// a fallback port instead of assuming the configured one, and the domains it
+// answers with their targets and the configuration profile in use.
+type DNSStatus struct {
+	ConfiguredPort string         `json:"configured_port"`
+	Port           string         `json:"port"`
+	Fallback       bool           `json:"fallback"`
+	Conflict       *PortConflict  `json:"conflict,omitempty"`
+	Domains        []DomainStatus `json:"domains"`
+	Profile        string         `json:"profile,omitempty"`
+	StartedAt      time.Time      `json:"started_at"`
+}
+
+// DomainStatus is a domain the server answers and the addresses its names
+// resolve to. TargetIPv6 is empty when AAAA queries get no answer.
+type DomainStatus struct {
+	Domain     string `json:"domain"`
+	TargetIP   string `json:"target_ip"`
+	TargetIPv6 string `json:"target_ipv6,omitempty"`
+}
+
+// newDNSStatus describes a server bound to port after trying configuredPort.
+func newDNSStatus(configuredPort, port string, conflict *PortConflict, domains []DomainStatus) DNSStatus {
+	return DNSStatus{
+		ConfiguredPort: configuredPort,
+		Port:           port,
+		Fallback:       port != configuredPort,
+		Conflict:       conflict,
+		Domains:        domains,
+		StartedAt:      time.Now().UTC(),
+	}
+}
+
+// domainStatus lists the configured domains with their targets, followed by
+// the mapped subdomains that resolve elsewhere, sorted.
+func (s *DNSServer) domainStatus() []DomainStatus {
+	domains := make([]DomainStatus, 0, len(s.customDomains)+len(s.domainTargets))
+	seen := make(map[string]bool)
+	for _, domain := range s.customDomains {
+		target := s.targetFor(netip.Addr{}, domain)
+		domains = append(domains, DomainStatus{Domain: domain, TargetIP: target.ipv4, TargetIPv6: target.ipv6})
+		seen[domain] = true
+	}
+
+	var mapped []string
+	for domain := range s.domainTargets {
+		if !seen[domain] {
+			mapped = append(mapped, domain)
+		}
+	}
+	sort.Strings(mapped)
+	for _, domain := range mapped {
+		target := s.domainTargets[domain]
+		domains = append(domains, DomainStatus{Domain: domain, TargetIP: target.ipv4, TargetIPv6: target.ipv6})
+	}
+	return domains
+}
+
+// writeStatus publishes the status snapshot; a missing store is not an error.
+func writeStatus(store *state.Store, status DNSStatus) error {
+	if store == nil {
+		return nil
+	}
+	return store.Write(statusName, status)
+}
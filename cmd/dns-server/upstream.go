@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
+
+// Upstream strategies (HTTP_PROXY_DNS_UPSTREAM_STRATEGY)
+const (
+	upstreamStrategyRace       = "race"
+	upstreamStrategySequential = "sequential"
+)
+
+const (
+	// upstreamFailureThreshold is the number of consecutive failures after
+	// which an upstream is demoted
+	upstreamFailureThreshold = 3
+
+	// upstreamRetryMin and upstreamRetryMax bound how long a demoted upstream
+	// waits before it is probed again; the wait doubles on each failed probe
+	upstreamRetryMin = 10 * time.Second
+	upstreamRetryMax = 5 * time.Minute
+)
+
+// validUpstreamStrategy reports whether strategy is a known upstream
+// strategy; empty means race.
+func validUpstreamStrategy(strategy string) bool {
+	return strategy == "" || strategy == upstreamStrategyRace || strategy == upstreamStrategySequential
+}
+
+// upstreamHealth tracks the recent failures of an upstream server.
+type upstreamHealth struct {
+	failures int           // consecutive failures
+	retryAt  time.Time     // demoted until then; zero when healthy
+	backoff  time.Duration // current demotion length
+}
+
+// upstreamPool forwards queries to the upstream servers, racing them or
+// trying them in order. Servers failing upstreamFailureThreshold times in a
+// row are demoted: they are skipped while healthy servers remain, and once
+// their retry time has passed the next query probes them again alongside the
+// healthy ones. A successful answer restores a server. It is safe for
+// concurrent use.
+type upstreamPool struct {
+	exchange func(r *dns.Msg, server string) (*dns.Msg, error)
+	now      func() time.Time
+	logger   *logger.Logger
+	queries  *metrics.Vec
+	healthy  *metrics.Vec
+
+	mu       sync.Mutex
+	strategy string
+	servers  []string
+	health   map[string]*upstreamHealth
+}
+
+// newUpstreamPool creates a pool without servers and registers its metrics
+// in registry; servers are set with configure.
+func newUpstreamPool(registry *metrics.Registry, log *logger.Logger) *upstreamPool {
+	return &upstreamPool{
+		exchange: newUpstreamExchange(),
+		now:      time.Now,
+		logger:   log,
+		queries:  registry.Counter("http_proxy_dns_upstream_queries_total", "Queries forwarded to upstream servers, by server and result.", "server", "result"),
+		healthy:  registry.Gauge("http_proxy_dns_upstream_healthy", "Whether an upstream server is in use (1) or demoted after repeated failures (0).", "server"),
+		strategy: upstreamStrategyRace,
+		health:   make(map[string]*upstreamHealth),
+	}
+}
+
+// configure sets the servers and strategy, keeping the health of servers
+// that remain configured. The strategy must be valid.
+func (p *upstreamPool) configure(servers []string, strategy string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	health := make(map[string]*upstreamHealth, len(servers))
+	for _, server := range servers {
+		if h, ok := p.health[server]; ok {
+			health[server] = h
+			continue
+		}
+		health[server] = &upstreamHealth{}
+		p.healthy.Set(1, server)
+	}
+	for server := range p.health {
+		if _, ok := health[server]; !ok {
+			p.healthy.Delete(server)
+			p.queries.DeleteMatching(server)
+		}
+	}
+
+	p.servers = append([]string(nil), servers...)
+	p.strategy = strategy
+	p.health = health
+}
+
+// forward sends the query to the upstreams and returns the first answer.
+// Demoted servers not yet due for a probe are only asked when every other
+// server failed.
+func (p *upstreamPool) forward(r *dns.Msg) (*dns.Msg, error) {
+	active, reserve, strategy := p.candidates()
+	if len(active) == 0 && len(reserve) == 0 {
+		return nil, fmt.Errorf("no upstream servers configured")
+	}
+
+	var errs []error
+	for _, servers := range [][]string{active, reserve} {
+		if len(servers) == 0 {
+			continue
+		}
+		var resp *dns.Msg
+		var err error
+		if strategy == upstreamStrategySequential {
+			resp, err = p.sequential(r, servers)
+		} else {
+			resp, err = p.race(r, servers)
+		}
+		if err == nil {
+			return resp, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("all upstream servers failed: %w", errors.Join(errs...))
+}
+
+// candidates splits the servers, in configured order, into those to ask now
+// (healthy or due for a probe) and the demoted ones.
+func (p *upstreamPool) candidates() (active, reserve []string, strategy string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	for _, server := range p.servers {
+		if h := p.health[server]; h.retryAt.IsZero() || !now.Before(h.retryAt) {
+			active = append(active, server)
+		} else {
+			reserve = append(reserve, server)
+		}
+	}
+	return active, reserve, p.strategy
+}
+
+// sequential asks the servers in order and returns the first answer.
+func (p *upstreamPool) sequential(r *dns.Msg, servers []string) (*dns.Msg, error) {
+	var errs []error
+	for _, server := range servers {
+		resp, err := p.ask(r, server)
+		if err == nil {
+			return resp, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// race asks the servers concurrently and returns the first answer. Slower
+// answers are still used to track the health of their server.
+func (p *upstreamPool) race(r *dns.Msg, servers []string) (*dns.Msg, error) {
+	type result struct {
+		resp *dns.Msg
+		err  error
+	}
+	results := make(chan result, len(servers))
+	for _, server := range servers {
+		query := r.Copy()
+		go func() {
+			resp, err := p.ask(query, server)
+			results <- result{resp, err}
+		}()
+	}
+
+	var errs []error
+	for range servers {
+		res := <-results
+		if res.err == nil {
+			return res.resp, nil
+		}
+		errs = append(errs, res.err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// ask sends the query to one server and records the outcome.
+func (p *upstreamPool) ask(r *dns.Msg, server string) (*dns.Msg, error) {
+	resp, err := p.exchange(r, server)
+	p.record(server, err)
+	if err != nil {
+		p.logger.Debug("Failed to forward", "server", server, "error", err)
+		return nil, fmt.Errorf("%s: %w", server, err)
+	}
+	p.logger.Debug("Forwarded query", "server", server)
+	return resp, nil
+}
+
+// record updates the health of server after a query, demoting it after
+// repeated failures and restoring it after a success.
+func (p *upstreamPool) record(server string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h, ok := p.health[server]
+	if !ok {
+		// Removed by a reload while the query was in flight
+		return
+	}
+
+	if err == nil {
+		p.queries.Inc(server, "success")
+		if !h.retryAt.IsZero() {
+			p.logger.Info("Upstream DNS server recovered", "server", server)
+		}
+		*h = upstreamHealth{}
+		p.healthy.Set(1, server)
+		return
+	}
+
+	p.queries.Inc(server, "error")
+	h.failures++
+	probing := !h.retryAt.IsZero()
+	if !probing && h.failures < upstreamFailureThreshold {
+		return
+	}
+	if h.backoff == 0 {
+		h.backoff = upstreamRetryMin
+	} else {
+		h.backoff = min(2*h.backoff, upstreamRetryMax)
+	}
+	h.retryAt = p.now().Add(h.backoff)
+	p.healthy.Set(0, server)
+	if !probing {
+		p.logger.Warn("Demoting failing upstream DNS server", "server", server, "failures", h.failures, "retry_in", h.backoff)
+	}
+}
+
+// newUpstreamExchange returns the function forwarding a query to one
+// upstream server: over DNS-over-TLS for tls:// servers, plain UDP otherwise,
+// falling back to TCP when the UDP answer is truncated.
+func newUpstreamExchange() func(r *dns.Msg, server string) (*dns.Msg, error) {
+	dot := newDoTClient(DNS_UPSTREAM_TIMEOUT)
+	return func(r *dns.Msg, server string) (*dns.Msg, error) {
+		if strings.HasPrefix(server, dotScheme) {
+			return dot.exchange(r, server)
+		}
+		c := dns.Client{Timeout: DNS_UPSTREAM_TIMEOUT}
+		resp, _, err := c.Exchange(r, server)
+		if err == nil && resp.Truncated {
+			// Ask again over TCP for the full answer; it is truncated again
+			// only for clients that asked over UDP
+			c.Net = "tcp"
+			resp, _, err = c.Exchange(r, server)
+		}
+		return resp, err
+	}
+}
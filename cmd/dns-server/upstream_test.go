@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
+
+// fakeUpstreams answers for the servers listed in up, after their delay, and
+// fails for the others. It records the servers asked.
+type fakeUpstreams struct {
+	mu    sync.Mutex
+	up    map[string]time.Duration
+	asked []string
+}
+
+func (f *fakeUpstreams) exchange(r *dns.Msg, server string) (*dns.Msg, error) {
+	f.mu.Lock()
+	f.asked = append(f.asked, server)
+	delay, ok := f.up[server]
+	f.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("timeout")
+	}
+	time.Sleep(delay)
+	resp := new(dns.Msg)
+	resp.SetReply(r)
+	resp.Answer = []dns.RR{&dns.TXT{Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET}, Txt: []string{server}}}
+	return resp, nil
+}
+
+func (f *fakeUpstreams) reset() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	asked := f.asked
+	f.asked = nil
+	return asked
+}
+
+func newTestUpstreamPool(f *fakeUpstreams, strategy string, servers ...string) *upstreamPool {
+	p := newUpstreamPool(metrics.NewRegistry(), logger.New("test"))
+	p.exchange = f.exchange
+	p.configure(servers, strategy)
+	return p
+}
+
+func answeredBy(t *testing.T, resp *dns.Msg) string {
+	t.Helper()
+	if resp == nil || len(resp.Answer) != 1 {
+		t.Fatalf("unexpected response %v", resp)
+	}
+	return resp.Answer[0].(*dns.TXT).Txt[0]
+}
+
+func TestUpstreamPoolRaceFirstAnswerWins(t *testing.T) {
+	f := &fakeUpstreams{up: map[string]time.Duration{"slow:53": 200 * time.Millisecond, "fast:53": 0}}
+	p := newTestUpstreamPool(f, upstreamStrategyRace, "dead:53", "slow:53", "fast:53")
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeTXT)
+	start := time.Now()
+	resp, err := p.forward(query)
+	if err != nil {
+		t.Fatalf("forward() error = %v", err)
+	}
+	if got := answeredBy(t, resp); got != "fast:53" {
+		t.Errorf("answered by %s, want fast:53", got)
+	}
+	if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+		t.Errorf("forward() waited %v for the slow upstream", elapsed)
+	}
+}
+
+func TestUpstreamPoolDemotesAndReprobes(t *testing.T) {
+	f := &fakeUpstreams{up: map[string]time.Duration{"backup:53": 0}}
+	p := newTestUpstreamPool(f, upstreamStrategySequential, "primary:53", "backup:53")
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeTXT)
+	for range upstreamFailureThreshold {
+		if _, err := p.forward(query); err != nil {
+			t.Fatalf("forward() error = %v", err)
+		}
+	}
+	if got := p.healthy.Value("primary:53"); got != 0 {
+		t.Fatalf("primary healthy = %v after %d failures, want 0", got, upstreamFailureThreshold)
+	}
+
+	// Demoted: the backup answers without waiting for the primary
+	f.reset()
+	if resp, _ := p.forward(query); answeredBy(t, resp) != "backup:53" {
+		t.Error("backup did not answer")
+	}
+	if asked := f.reset(); len(asked) != 1 || asked[0] != "backup:53" {
+		t.Errorf("asked %v, want only the backup", asked)
+	}
+
+	// Once the retry time has passed the primary is probed again and,
+	// answering, restored
+	f.up["primary:53"] = 0
+	now = now.Add(upstreamRetryMin)
+	if resp, _ := p.forward(query); answeredBy(t, resp) != "primary:53" {
+		t.Error("primary was not probed again")
+	}
+	if got := p.healthy.Value("primary:53"); got != 1 {
+		t.Errorf("primary healthy = %v after recovering, want 1", got)
+	}
+}
+
+func TestUpstreamPoolFailedProbeBacksOff(t *testing.T) {
+	f := &fakeUpstreams{up: map[string]time.Duration{}}
+	p := newTestUpstreamPool(f, upstreamStrategySequential, "dead:53")
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeTXT)
+	for range upstreamFailureThreshold + 1 {
+		if _, err := p.forward(query); err == nil {
+			t.Fatal("forward() succeeded without a live upstream")
+		}
+		now = now.Add(upstreamRetryMin)
+	}
+
+	// A demoted server is still asked when it is the only one left, and
+	// each failed probe doubles its wait
+	if got, want := p.health["dead:53"].backoff, 2*upstreamRetryMin; got != want {
+		t.Errorf("backoff = %v, want %v", got, want)
+	}
+}
+
+func TestUpstreamPoolConfigureKeepsHealth(t *testing.T) {
+	f := &fakeUpstreams{up: map[string]time.Duration{}}
+	p := newTestUpstreamPool(f, upstreamStrategySequential, "a:53", "b:53")
+	p.health["a:53"].failures = 2
+
+	p.configure([]string{"a:53", "c:53"}, upstreamStrategyRace)
+	if p.health["a:53"].failures != 2 {
+		t.Error("health of a retained server was reset")
+	}
+	if _, ok := p.health["b:53"]; ok {
+		t.Error("removed server is still tracked")
+	}
+	if p.strategy != upstreamStrategyRace {
+		t.Errorf("strategy = %q, want %q", p.strategy, upstreamStrategyRace)
+	}
+}
+
+func TestUpstreamExchangeRetriesTruncatedOverTCP(t *testing.T) {
+	// The upstream only fits the answer in a TCP response
+	addr := serveLocal(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		resp, _ := largeTXTExchange(r, "")
+		if _, udp := w.RemoteAddr().(*net.UDPAddr); udp {
+			resp.Answer = nil
+			resp.Truncated = true
+		}
+		w.WriteMsg(resp)
+	}))
+
+	query := new(dns.Msg)
+	query.SetQuestion("large.example.com.", dns.TypeTXT)
+	resp, err := newUpstreamExchange()(query, addr)
+	if err != nil {
+		t.Fatalf("exchange() error = %v", err)
+	}
+	if resp.Truncated || len(resp.Answer) != 20 {
+		t.Errorf("exchange() truncated = %v with %d answers, want the full TCP answer", resp.Truncated, len(resp.Answer))
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// joinNetworks connects to each network with join, then checks the new
+// endpoints with verify. One at a time, each join is verified before the
+// next; in a batch, all networks are joined first and verified in a single
+// pass at the end, so their endpoints settle concurrently. Networks removed
+// since the scan are skipped. It returns the networks joined.
+func joinNetworks(ctx context.Context, networkIDs []string, batch bool, join func(context.Context, string) error, verify func(context.Context, string)) ([]string, error) {
+	var joined []string
+	for _, networkID := range networkIDs {
+		if err := utils.CheckContext(ctx); err != nil {
+			return joined, err
+		}
+
+		if err := join(ctx, networkID); err != nil {
+			if errors.Is(err, ErrNetworkGone) {
+				continue
+			}
+			return joined, err
+		}
+		joined = append(joined, networkID)
+
+		if !batch {
+			verify(ctx, networkID)
+		}
+	}
+
+	if batch {
+		for _, networkID := range joined {
+			if err := utils.CheckContext(ctx); err != nil {
+				return joined, err
+			}
+			verify(ctx, networkID)
+		}
+	}
+	return joined, nil
+}
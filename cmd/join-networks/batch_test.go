@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestJoinNetworks(t *testing.T) {
+	gone := fmt.Errorf("failed to join network b: %w", ErrNetworkGone)
+	missingProxy := &NetworkOpError{Op: opConnect, NetworkID: "b", Class: errClassNotFound, Err: errors.New("No such container: http-proxy")}
+	tests := []struct {
+		name       string
+		batch      bool
+		failures   map[string]error
+		wantCalls  []string
+		wantJoined []string
+		wantErr    bool
+	}{
+		{
+			name:       "one at a time verifies each join",
+			wantCalls:  []string{"join a", "verify a", "join b", "verify b", "join c", "verify c"},
+			wantJoined: []string{"a", "b", "c"},
+		},
+		{
+			name:       "batch verifies in a single pass",
+			batch:      true,
+			wantCalls:  []string{"join a", "join b", "join c", "verify a", "verify b", "verify c"},
+			wantJoined: []string{"a", "b", "c"},
+		},
+		{
+			name:       "removed networks are skipped",
+			batch:      true,
+			failures:   map[string]error{"b": gone},
+			wantCalls:  []string{"join a", "join b", "join c", "verify a", "verify c"},
+			wantJoined: []string{"a", "c"},
+		},
+		{
+			name:       "a missing proxy container is not a removed network",
+			failures:   map[string]error{"b": missingProxy},
+			wantCalls:  []string{"join a", "verify a", "join b"},
+			wantJoined: []string{"a"},
+			wantErr:    true,
+		},
+		{
+			name:       "a failure stops before the validation pass",
+			batch:      true,
+			failures:   map[string]error{"b": errors.New("boom")},
+			wantCalls:  []string{"join a", "join b"},
+			wantJoined: []string{"a"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			join := func(_ context.Context, id string) error {
+				calls = append(calls, "join "+id)
+				return tt.failures[id]
+			}
+			verify := func(_ context.Context, id string) {
+				calls = append(calls, "verify "+id)
+			}
+
+			joined, err := joinNetworks(context.Background(), []string{"a", "b", "c"}, tt.batch, join, verify)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("joinNetworks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", calls, tt.wantCalls)
+			}
+			if !reflect.DeepEqual(joined, tt.wantJoined) {
+				t.Errorf("joined = %v, want %v", joined, tt.wantJoined)
+			}
+		})
+	}
+}
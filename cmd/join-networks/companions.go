@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types/network"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// joinCompanions connects the companion containers to a network the proxy
+// joined, so that services reading Docker's embedded DNS from them, like the
+// DNS server's embedded TLDs, see the same containers as the proxy. Failures
+// are logged, not retried: a missing companion does not stop the proxy from
+// routing, and the companions stay out of the proxy's error metrics.
+func (nj *NetworkJoiner) joinCompanions(ctx context.Context, networkID string) {
+	for _, name := range nj.companions {
+		callCtx, cancel := utils.WithDockerTimeout(ctx, nj.dockerTimeout)
+		err := nj.dockerClient.NetworkConnect(callCtx, networkID, name, &network.EndpointSettings{})
+		cancel()
+		if err != nil && classifyNetworkError(err) != errClassAlreadyConnected {
+			nj.logger.Warn("Failed to attach companion container to network",
+				"container", name, "network_id", utils.FormatDockerID(networkID), "error", err)
+		}
+	}
+}
+
+// leaveCompanions disconnects the companion containers from a network the
+// proxy left.
+func (nj *NetworkJoiner) leaveCompanions(ctx context.Context, networkID string) {
+	for _, name := range nj.companions {
+		callCtx, cancel := utils.WithDockerTimeout(ctx, nj.dockerTimeout)
+		err := nj.dockerClient.NetworkDisconnect(callCtx, networkID, name, true)
+		cancel()
+		if err == nil {
+			continue
+		}
+		if class := classifyNetworkError(err); class != errClassNotConnected && class != errClassNotFound {
+			nj.logger.Warn("Failed to detach companion container from network",
+				"container", name, "network_id", utils.FormatDockerID(networkID), "error", err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Operations a skipped network change was planned as
+const (
+	diffOpJoin  = "join"
+	diffOpLeave = "leave"
+)
+
+// Reasons a planned network change was skipped, besides the leave verdicts
+const (
+	skipReasonDryRun   = "dry-run"
+	skipReasonNotFound = "network-not-found"
+	skipReasonFailed   = "failed"
+)
+
+// SkippedNetwork is a planned join or leave that did not happen, with the
+// reason it was skipped.
+type SkippedNetwork struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Operation string `json:"operation"`
+	Reason    string `json:"reason"`
+}
+
+// networkDiff is the change of the proxy's network membership made by one
+// reconciliation: the networks joined and left, and the planned changes
+// skipped. reasons gives the join reason of the selected networks by ID.
+type networkDiff struct {
+	joined  []string
+	left    []string
+	skipped []SkippedNetwork
+	reasons map[string]string
+}
+
+// changed reports whether the proxy joined or left a network.
+func (d networkDiff) changed() bool {
+	return len(d.joined) > 0 || len(d.left) > 0
+}
+
+// skip records a planned change that did not happen.
+func (d *networkDiff) skip(id, name, operation, reason string) {
+	d.skipped = append(d.skipped, SkippedNetwork{ID: id, Name: name, Operation: operation, Reason: reason})
+}
+
+// skipBlockedLeaves records the leaves the plan simulated as unsafe, with
+// their verdict as reason.
+func (d *networkDiff) skipBlockedLeaves(plan *NetworkPlan) {
+	for _, leave := range plan.Leaves {
+		if leave.Verdict != VerdictSafe {
+			d.skip(leave.NetworkID, leave.NetworkName, diffOpLeave, string(leave.Verdict))
+		}
+	}
+}
+
+// skipAll records every planned change as skipped for reason, for dry runs.
+func (nj *NetworkJoiner) skipAll(ctx context.Context, d *networkDiff, plan *NetworkPlan, reason string) {
+	for _, id := range plan.ToJoin {
+		d.skip(id, nj.getNetworkName(ctx, id), diffOpJoin, reason)
+	}
+	for _, leave := range plan.Leaves {
+		if leave.Verdict == VerdictSafe {
+			d.skip(leave.NetworkID, leave.NetworkName, diffOpLeave, reason)
+		}
+	}
+}
+
+// recordDiff logs a reconciliation's membership diff by network name and
+// counts it in http_proxy_join_network_changes_total. A diff of only skipped
+// changes is logged at debug level, since blocked leaves recur on every
+// event.
+func (nj *NetworkJoiner) recordDiff(trigger string, event NetworkChangeEvent) {
+	for _, joined := range event.Joined {
+		nj.networkChanges.Inc("joined", joined.Reason)
+	}
+	for range event.Left {
+		nj.networkChanges.Inc("left", "")
+	}
+	for _, skipped := range event.Skipped {
+		nj.networkChanges.Inc("skipped", skipped.Reason)
+	}
+
+	if len(event.Joined) == 0 && len(event.Left) == 0 && len(event.Skipped) == 0 {
+		return
+	}
+
+	level := slog.LevelInfo
+	if len(event.Joined) == 0 && len(event.Left) == 0 {
+		level = slog.LevelDebug
+	}
+	nj.logger.Log(context.Background(), level, "Network membership diff",
+		"trigger", trigger,
+		"generation", event.Generation,
+		"joined", refNames(event.Joined),
+		"left", refNames(event.Left),
+		"skipped", event.Skipped)
+}
+
+// refNames returns the names of network references.
+func refNames(refs []NetworkRef) []string {
+	names := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		names = append(names, ref.Name)
+	}
+	return names
+}
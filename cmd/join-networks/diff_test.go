@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
+
+func TestSkipBlockedLeaves(t *testing.T) {
+	plan := &NetworkPlan{Leaves: []LeaveImpact{
+		{NetworkID: "app-id", NetworkName: "app_default", Verdict: VerdictSafe},
+		{NetworkID: "proxy-id", NetworkName: "http-proxy_default", Verdict: VerdictBlockedPortBindings},
+	}}
+
+	var diff networkDiff
+	diff.skipBlockedLeaves(plan)
+	want := SkippedNetwork{ID: "proxy-id", Name: "http-proxy_default", Operation: diffOpLeave, Reason: string(VerdictBlockedPortBindings)}
+	if len(diff.skipped) != 1 || diff.skipped[0] != want {
+		t.Errorf("skipped = %+v, want %+v", diff.skipped, want)
+	}
+	if diff.changed() {
+		t.Error("changed() = true for a diff of skipped changes only")
+	}
+}
+
+func TestRecordDiff(t *testing.T) {
+	registry := metrics.NewRegistry()
+	nj := &NetworkJoiner{
+		logger:         logger.New("test"),
+		networkChanges: registry.Counter("changes", "test", "change", "reason"),
+	}
+
+	nj.recordDiff(triggerContainerStart, NetworkChangeEvent{
+		Joined: []NetworkRef{{ID: "a", Name: "a_default", Reason: joinReasonManageable}, {ID: "b", Name: "b_default", Reason: joinReasonManageable}},
+		Left:   []NetworkRef{{ID: "c", Name: "c_default"}},
+		Skipped: []SkippedNetwork{
+			{ID: "d", Name: "d_default", Operation: diffOpJoin, Reason: skipReasonNotFound},
+		},
+	})
+
+	tests := []struct {
+		change, reason string
+		want           float64
+	}{
+		{"joined", joinReasonManageable, 2},
+		{"left", "", 1},
+		{"skipped", skipReasonNotFound, 1},
+		{"skipped", skipReasonDryRun, 0},
+	}
+	for _, tt := range tests {
+		if got := nj.networkChanges.Value(tt.change, tt.reason); got != tt.want {
+			t.Errorf("changes{%s,%s} = %v, want %v", tt.change, tt.reason, got, tt.want)
+		}
+	}
+}
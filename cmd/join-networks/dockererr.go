@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/network"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// Network operations, as reported in errors and metrics
+const (
+	opConnect    = "connect"
+	opDisconnect = "disconnect"
+)
+
+// Classes of Docker network operation failures
+const (
+	errClassAlreadyConnected = "already_connected"
+	errClassNotConnected     = "not_connected"
+	errClassNotFound         = "not_found"
+	errClassInProgress       = "in_progress"
+	errClassTimeout          = "timeout"
+	errClassOther            = "other"
+)
+
+// Sentinel errors matched by errors.Is against a *NetworkOpError of the
+// corresponding class.
+var (
+	ErrAlreadyConnected = errors.New("already connected to network")
+	ErrNotConnected     = errors.New("not connected to network")
+	ErrNotFound         = errors.New("container or network not found")
+	ErrInProgress       = errors.New("conflicting operation in progress")
+	ErrDaemonTimeout    = errors.New("docker daemon timeout")
+)
+
+// ErrNetworkGone reports a join skipped because the network was removed
+// since it was scanned.
+var ErrNetworkGone = errors.New("network no longer exists")
+
+// classSentinels maps the classes with a sentinel error to it.
+var classSentinels = map[string]error{
+	errClassAlreadyConnected: ErrAlreadyConnected,
+	errClassNotConnected:     ErrNotConnected,
+	errClassNotFound:         ErrNotFound,
+	errClassInProgress:       ErrInProgress,
+	errClassTimeout:          ErrDaemonTimeout,
+}
+
+// NetworkOpError is a classified failure of a network connect or disconnect.
+type NetworkOpError struct {
+	Op        string
+	NetworkID string
+	Class     string
+	Err       error
+}
+
+func (e *NetworkOpError) Error() string {
+	return fmt.Sprintf("%s network %s (%s): %v", e.Op, utils.FormatDockerID(e.NetworkID), e.Class, e.Err)
+}
+
+func (e *NetworkOpError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the sentinel error of the class.
+func (e *NetworkOpError) Is(target error) bool {
+	sentinel, ok := classSentinels[e.Class]
+	return ok && target == sentinel
+}
+
+// classifyNetworkError returns the class of a Docker network operation
+// failure. The daemon reports some of them only through the message.
+func classifyNetworkError(err error) string {
+	var netErr net.Error
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "already exists in network"), strings.Contains(msg, "already connected"):
+		return errClassAlreadyConnected
+	case strings.Contains(msg, "is not connected to"):
+		return errClassNotConnected
+	case cerrdefs.IsNotFound(err), strings.Contains(msg, "no such container"), strings.Contains(msg, "no such network"):
+		return errClassNotFound
+	case strings.Contains(msg, "in progress"), strings.Contains(msg, "is restarting"):
+		return errClassInProgress
+	case cerrdefs.IsDeadlineExceeded(err), errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return errClassTimeout
+	default:
+		return errClassOther
+	}
+}
+
+// networkGone reports whether a network was removed. A connect answered with
+// not found may as well mean the proxy container is gone, so the network
+// itself is inspected.
+func (nj *NetworkJoiner) networkGone(ctx context.Context, networkID string) bool {
+	callCtx, cancel := utils.WithDockerTimeout(ctx, nj.dockerTimeout)
+	defer cancel()
+	_, err := nj.dockerClient.NetworkInspect(callCtx, networkID, network.InspectOptions{})
+	return cerrdefs.IsNotFound(err)
+}
+
+// networkRetryPolicies tells how often each failure class is retried. A
+// conflicting operation usually clears within seconds, so it is waited out
+// longer; already connected, not connected and not found are final.
+var networkRetryPolicies = map[string]utils.RetryConfig{
+	errClassInProgress: {MaxAttempts: 5, InitialDelay: 500 * time.Millisecond, MaxDelay: 5 * time.Second, BackoffMultiplier: 2.0},
+	errClassTimeout:    {MaxAttempts: 3, InitialDelay: time.Second, MaxDelay: 4 * time.Second, BackoffMultiplier: 2.0},
+	errClassOther:      utils.DefaultRetryConfig(0),
+}
+
+// runNetworkOp runs a connect or disconnect, retrying it as its failure class
+// allows and counting every failure by class. It returns nil or a
+// *NetworkOpError.
+func (nj *NetworkJoiner) runNetworkOp(ctx context.Context, op, networkID string, fn func(ctx context.Context) error) error {
+	attempts := 0
+	var delay time.Duration
+	for {
+		callCtx, cancel := utils.WithDockerTimeout(ctx, nj.dockerTimeout)
+		err := fn(callCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		class := classifyNetworkError(err)
+		nj.networkErrors.Inc(op, class)
+		opErr := &NetworkOpError{Op: op, NetworkID: networkID, Class: class, Err: err}
+
+		attempts++
+		policy, retry := networkRetryPolicies[class]
+		if !retry || attempts >= policy.MaxAttempts {
+			return opErr
+		}
+		if attempts == 1 {
+			delay = policy.InitialDelay
+		} else {
+			delay = min(time.Duration(float64(delay)*policy.BackoffMultiplier), policy.MaxDelay)
+		}
+		nj.logger.Debug("Retrying network operation",
+			"operation", op, "network_id", utils.FormatDockerID(networkID),
+			"error_class", class, "attempt", attempts, "delay", delay)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+func TestClassifyNetworkError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"endpoint exists", errors.New("Error response from daemon: endpoint with name http-proxy already exists in network app_default"), errClassAlreadyConnected},
+		{"not connected", errors.New("Error response from daemon: container 1234 is not connected to network app_default"), errClassNotConnected},
+		{"typed not found", fmt.Errorf("inspect: %w", cerrdefs.ErrNotFound), errClassNotFound},
+		{"no such network", errors.New("Error response from daemon: No such network: abc"), errClassNotFound},
+		{"in progress", errors.New("Error response from daemon: removal of network abc is already in progress"), errClassInProgress},
+		{"restarting", errors.New("Error response from daemon: container 1234 is restarting, wait until the container is running"), errClassInProgress},
+		{"deadline", fmt.Errorf("connect: %w", context.DeadlineExceeded), errClassTimeout},
+		{"other", errors.New("Error response from daemon: invalid endpoint settings"), errClassOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyNetworkError(tt.err); got != tt.want {
+				t.Errorf("classifyNetworkError() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNetworkOpErrorIs(t *testing.T) {
+	err := fmt.Errorf("join: %w", &NetworkOpError{Op: opConnect, NetworkID: "abc", Class: errClassAlreadyConnected, Err: errors.New("exists")})
+	if !errors.Is(err, ErrAlreadyConnected) {
+		t.Error("errors.Is(ErrAlreadyConnected) = false")
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Error("errors.Is(ErrNotFound) = true for another class")
+	}
+}
+
+func TestRunNetworkOpRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantCalls int
+		wantClass string
+	}{
+		{"already connected is final", errors.New("endpoint with name x already exists in network y"), 1, errClassAlreadyConnected},
+		{"not found is final", errors.New("No such network: y"), 1, errClassNotFound},
+		{"other uses the default retries", errors.New("boom"), 3, errClassOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nj := NewNetworkJoiner(&NetworkJoinerConfig{})
+			nj.logger = logger.New("test")
+
+			calls := 0
+			err := nj.runNetworkOp(context.Background(), opConnect, "abc", func(context.Context) error {
+				calls++
+				return tt.err
+			})
+
+			var opErr *NetworkOpError
+			if !errors.As(err, &opErr) || opErr.Class != tt.wantClass {
+				t.Fatalf("runNetworkOp() error = %v, want class %s", err, tt.wantClass)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if got := nj.networkErrors.Value(opConnect, tt.wantClass); got != float64(tt.wantCalls) {
+				t.Errorf("error counter = %v, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRunNetworkOpRecovers(t *testing.T) {
+	nj := NewNetworkJoiner(&NetworkJoinerConfig{})
+	nj.logger = logger.New("test")
+
+	calls := 0
+	err := nj.runNetworkOp(context.Background(), opDisconnect, "abc", func(context.Context) error {
+		calls++
+		if calls == 1 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("runNetworkOp() = %v after %d calls, want success after 2", err, calls)
+	}
+}
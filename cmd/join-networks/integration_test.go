@@ -0,0 +1,342 @@
+//go:build integration
+
+// Integration tests against the Docker daemon of the host, run with
+//
+//	go test -tags integration ./cmd/join-networks/
+//
+// They create throwaway networks and busybox containers named hp-it-*, and
+// remove them when done. The joiner scans every network of the daemon, so the
+// throwaway proxy also joins the networks of other running containers with a
+// VIRTUAL_HOST; it is removed at the end of each test, leaving them. Tests
+// checking which networks the proxy joins and leaves start it on the default
+// bridge, which is never left.
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+const integrationImage = "busybox:latest"
+
+// integrationDocker returns a client of the host daemon, skipping the test
+// when there is none or the test image cannot be pulled.
+func integrationDocker(t *testing.T) *client.Client {
+	t.Helper()
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		t.Skipf("no Docker client: %v", err)
+	}
+	t.Cleanup(func() { cli.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	if _, err := cli.Ping(ctx); err != nil {
+		t.Skipf("no Docker daemon: %v", err)
+	}
+	if _, err := cli.ImageInspect(ctx, integrationImage); err != nil {
+		reader, err := cli.ImagePull(ctx, integrationImage, image.PullOptions{})
+		if err != nil {
+			t.Skipf("cannot pull %s: %v", integrationImage, err)
+		}
+		defer reader.Close()
+		buf := make([]byte, 32*1024)
+		for {
+			if _, err := reader.Read(buf); err != nil {
+				break
+			}
+		}
+	}
+	return cli
+}
+
+// integrationName returns a name unique to the test run.
+func integrationName(suffix string) string {
+	return fmt.Sprintf("hp-it-%d-%s", time.Now().UnixNano()%1_000_000_000, suffix)
+}
+
+// createNetwork creates a bridge network removed at the end of the test.
+func createNetwork(t *testing.T, cli *client.Client, name string, internal bool) string {
+	t.Helper()
+	ctx := context.Background()
+	resp, err := cli.NetworkCreate(ctx, name, network.CreateOptions{Driver: bridgeDriverName, Internal: internal})
+	if err != nil {
+		t.Fatalf("failed to create network %s: %v", name, err)
+	}
+	t.Cleanup(func() { cli.NetworkRemove(context.Background(), resp.ID) })
+	return resp.ID
+}
+
+// runContainer starts a sleeping busybox container on networkName, removed at
+// the end of the test. env marks it manageable with a VIRTUAL_HOST; a
+// non-empty publish port is published on a random host port.
+func runContainer(t *testing.T, cli *client.Client, name, networkName string, env []string, publish string) string {
+	t.Helper()
+	ctx := context.Background()
+	cfg := &container.Config{Image: integrationImage, Cmd: []string{"sleep", "3600"}, Env: env}
+	hostCfg := &container.HostConfig{NetworkMode: container.NetworkMode(networkName)}
+	if publish != "" {
+		port := nat.Port(publish + "/tcp")
+		cfg.ExposedPorts = nat.PortSet{port: struct{}{}}
+		hostCfg.PortBindings = nat.PortMap{port: []nat.PortBinding{{HostIP: "127.0.0.1"}}}
+	}
+
+	resp, err := cli.ContainerCreate(ctx, cfg, hostCfg, nil, nil, name)
+	if err != nil {
+		t.Fatalf("failed to create container %s: %v", name, err)
+	}
+	t.Cleanup(func() {
+		cli.ContainerRemove(context.Background(), resp.ID, container.RemoveOptions{Force: true})
+	})
+	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		t.Fatalf("failed to start container %s: %v", name, err)
+	}
+	return resp.ID
+}
+
+// newIntegrationJoiner returns a joiner managing the networks of proxyName.
+func newIntegrationJoiner(t *testing.T, cli *client.Client, proxyName string) *NetworkJoiner {
+	t.Helper()
+	nj := NewNetworkJoiner(&NetworkJoinerConfig{HTTPProxyContainerName: proxyName, LogLevel: "error"})
+	nj.SetDependencies(cli, logger.New("join-networks-test"))
+	return nj
+}
+
+// proxyNetworks returns the networks proxyName is attached to.
+func proxyNetworks(t *testing.T, nj *NetworkJoiner, proxyName string) NetworkSet {
+	t.Helper()
+	info, err := nj.getContainerInfo(context.Background(), proxyName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Networks
+}
+
+func TestIntegrationJoinAndLeave(t *testing.T) {
+	cli := integrationDocker(t)
+	ctx := context.Background()
+
+	appNet := integrationName("app")
+	appID := createNetwork(t, cli, appNet, false)
+	proxyName := integrationName("http-proxy")
+	runContainer(t, cli, proxyName, defaultBridgeName, nil, "")
+	app := runContainer(t, cli, integrationName("web"), appNet, []string{"VIRTUAL_HOST=web.loc"}, "")
+
+	nj := newIntegrationJoiner(t, cli, proxyName)
+	if err := nj.performInitialNetworkJoin(ctx, proxyName, triggerInitialScan); err != nil {
+		t.Fatalf("performInitialNetworkJoin() error = %v", err)
+	}
+	if !proxyNetworks(t, nj, proxyName).Contains(appID) {
+		t.Fatal("proxy did not join the network of a container with a VIRTUAL_HOST")
+	}
+
+	// Joining again is a no-op
+	if err := nj.performInitialNetworkJoin(ctx, proxyName, triggerContainerStart); err != nil {
+		t.Fatalf("second performInitialNetworkJoin() error = %v", err)
+	}
+
+	if err := cli.ContainerRemove(ctx, app, container.RemoveOptions{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nj.handleContainerStop(ctx); err != nil {
+		t.Fatalf("handleContainerStop() error = %v", err)
+	}
+	if proxyNetworks(t, nj, proxyName).Contains(appID) {
+		t.Error("proxy stayed on a network without manageable containers")
+	}
+}
+
+func TestIntegrationSkipsUnmanagedNetworks(t *testing.T) {
+	cli := integrationDocker(t)
+	ctx := context.Background()
+
+	otherNet := integrationName("other")
+	otherID := createNetwork(t, cli, otherNet, false)
+	proxyName := integrationName("http-proxy")
+	runContainer(t, cli, proxyName, defaultBridgeName, nil, "")
+	runContainer(t, cli, integrationName("db"), otherNet, nil, "")
+
+	nj := newIntegrationJoiner(t, cli, proxyName)
+	if err := nj.performInitialNetworkJoin(ctx, proxyName, triggerInitialScan); err != nil {
+		t.Fatalf("performInitialNetworkJoin() error = %v", err)
+	}
+	if proxyNetworks(t, nj, proxyName).Contains(otherID) {
+		t.Error("proxy joined a network without manageable containers")
+	}
+}
+
+func TestIntegrationProtectsDefaultBridge(t *testing.T) {
+	cli := integrationDocker(t)
+	ctx := context.Background()
+
+	proxyName := integrationName("http-proxy")
+	runContainer(t, cli, proxyName, defaultBridgeName, nil, "")
+
+	nj := newIntegrationJoiner(t, cli, proxyName)
+	bridgeID, err := nj.getDefaultBridgeNetworkID(ctx)
+	if err != nil {
+		t.Skipf("no default bridge network: %v", err)
+	}
+	if err := nj.performInitialNetworkJoin(ctx, proxyName, triggerInitialScan); err != nil {
+		t.Fatalf("performInitialNetworkJoin() error = %v", err)
+	}
+	if err := nj.handleContainerStop(ctx); err != nil {
+		t.Fatalf("handleContainerStop() error = %v", err)
+	}
+	if !proxyNetworks(t, nj, proxyName).Contains(bridgeID) {
+		t.Error("proxy left the default bridge network")
+	}
+}
+
+func TestIntegrationKeepsLastExternalNetwork(t *testing.T) {
+	cli := integrationDocker(t)
+	ctx := context.Background()
+
+	// The proxy's only network has no manageable containers, but leaving it
+	// would cut the proxy off
+	proxyNet := integrationName("proxy")
+	proxyNetID := createNetwork(t, cli, proxyNet, false)
+	proxyName := integrationName("http-proxy")
+	runContainer(t, cli, proxyName, proxyNet, nil, "")
+
+	nj := newIntegrationJoiner(t, cli, proxyName)
+	if err := nj.performInitialNetworkJoin(ctx, proxyName, triggerInitialScan); err != nil {
+		t.Fatalf("performInitialNetworkJoin() error = %v", err)
+	}
+	if err := nj.handleContainerStop(ctx); err != nil {
+		t.Fatalf("handleContainerStop() error = %v", err)
+	}
+	if !proxyNetworks(t, nj, proxyName).Contains(proxyNetID) {
+		t.Error("proxy left its last external network")
+	}
+}
+
+func TestIntegrationKeepsPortBindingsNetwork(t *testing.T) {
+	cli := integrationDocker(t)
+	ctx := context.Background()
+
+	// The proxy publishes a port on a network without manageable
+	// containers; once it has joined another network, that one is still
+	// where its published port is bound
+	proxyNet := integrationName("proxy")
+	appNet := integrationName("app")
+	proxyNetID := createNetwork(t, cli, proxyNet, false)
+	appID := createNetwork(t, cli, appNet, false)
+	proxyName := integrationName("http-proxy")
+	runContainer(t, cli, proxyName, proxyNet, nil, "80")
+	runContainer(t, cli, integrationName("web"), appNet, []string{"VIRTUAL_HOST=web.loc"}, "")
+
+	nj := newIntegrationJoiner(t, cli, proxyName)
+	if err := nj.performInitialNetworkJoin(ctx, proxyName, triggerInitialScan); err != nil {
+		t.Fatalf("performInitialNetworkJoin() error = %v", err)
+	}
+	if err := nj.handleContainerStop(ctx); err != nil {
+		t.Fatalf("handleContainerStop() error = %v", err)
+	}
+	networks := proxyNetworks(t, nj, proxyName)
+	if !networks.Contains(appID) {
+		t.Error("proxy did not join the network of a container with a VIRTUAL_HOST")
+	}
+	if !networks.Contains(proxyNetID) {
+		t.Error("proxy left the network carrying its published ports")
+	}
+}
+
+func TestIntegrationSkipsRemovedNetwork(t *testing.T) {
+	cli := integrationDocker(t)
+	ctx := context.Background()
+
+	proxyNet := integrationName("proxy")
+	createNetwork(t, cli, proxyNet, false)
+	proxyName := integrationName("http-proxy")
+	runContainer(t, cli, proxyName, proxyNet, nil, "")
+
+	// A network removed between the scan and the join is skipped, and the
+	// other joins of the operation still happen
+	goneName := integrationName("gone")
+	gone, err := cli.NetworkCreate(ctx, goneName, network.CreateOptions{Driver: bridgeDriverName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.NetworkRemove(ctx, gone.ID); err != nil {
+		t.Fatal(err)
+	}
+	appNet := integrationName("app")
+	appID := createNetwork(t, cli, appNet, false)
+
+	nj := newIntegrationJoiner(t, cli, proxyName)
+	joined, err := nj.performNetworkOperations(ctx, &NetworkOperation{
+		HTTPProxyContainerName: proxyName,
+		ToJoin:                 []string{gone.ID, appID},
+	})
+	if err != nil {
+		t.Fatalf("performNetworkOperations() error = %v", err)
+	}
+	if len(joined) != 1 || joined[0] != appID {
+		t.Errorf("joined = %v, want only %s", joined, appID)
+	}
+	if !proxyNetworks(t, nj, proxyName).Contains(appID) {
+		t.Error("proxy not attached to the remaining network")
+	}
+}
+
+func TestIntegrationDryRunChangesNothing(t *testing.T) {
+	cli := integrationDocker(t)
+	ctx := context.Background()
+
+	appNet := integrationName("app")
+	appID := createNetwork(t, cli, appNet, false)
+	proxyName := integrationName("http-proxy")
+	runContainer(t, cli, proxyName, defaultBridgeName, nil, "")
+	runContainer(t, cli, integrationName("web"), appNet, []string{"VIRTUAL_HOST=web.loc"}, "")
+
+	nj := newIntegrationJoiner(t, cli, proxyName)
+	nj.dryRun = true
+	if err := nj.performInitialNetworkJoin(ctx, proxyName, triggerInitialScan); err != nil {
+		t.Fatalf("performInitialNetworkJoin() error = %v", err)
+	}
+	if proxyNetworks(t, nj, proxyName).Contains(appID) {
+		t.Error("dry run joined a network")
+	}
+}
+
+func TestIntegrationAttachesCompanions(t *testing.T) {
+	cli := integrationDocker(t)
+	ctx := context.Background()
+
+	appNet := integrationName("app")
+	appID := createNetwork(t, cli, appNet, false)
+	proxyName := integrationName("http-proxy")
+	companionName := integrationName("dns")
+	runContainer(t, cli, proxyName, defaultBridgeName, nil, "")
+	runContainer(t, cli, companionName, defaultBridgeName, nil, "")
+	app := runContainer(t, cli, integrationName("web"), appNet, []string{"VIRTUAL_HOST=web.loc"}, "")
+
+	nj := newIntegrationJoiner(t, cli, proxyName)
+	nj.companions = []string{companionName, integrationName("missing")}
+	if err := nj.performInitialNetworkJoin(ctx, proxyName, triggerInitialScan); err != nil {
+		t.Fatalf("performInitialNetworkJoin() error = %v", err)
+	}
+	if !proxyNetworks(t, nj, companionName).Contains(appID) {
+		t.Fatal("companion did not join the network along with the proxy")
+	}
+
+	if err := cli.ContainerRemove(ctx, app, container.RemoveOptions{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nj.handleContainerStop(ctx); err != nil {
+		t.Fatalf("handleContainerStop() error = %v", err)
+	}
+	if proxyNetworks(t, nj, companionName).Contains(appID) {
+		t.Error("companion stayed on a network the proxy left")
+	}
+}
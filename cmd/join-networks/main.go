@@ -2,16 +2,24 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/sparkfabrik/http-proxy/pkg/config"
 	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
 	"github.com/sparkfabrik/http-proxy/pkg/service"
+	"github.com/sparkfabrik/http-proxy/pkg/state"
 	"github.com/sparkfabrik/http-proxy/pkg/utils"
 )
 
@@ -26,15 +34,64 @@ const (
 // that contain manageable containers and leaving networks that become empty.
 type NetworkJoiner struct {
 	dockerClient           *client.Client
+	dockerTimeout          time.Duration
 	logger                 *logger.Logger
 	httpProxyContainerName string
+	dryRun                 bool
+	state                  *state.Store
+	webhookURLs            []string
+	settleTimeout          time.Duration
+	readinessTimeout       time.Duration
+	batchJoin              bool
+	repairInterval         time.Duration
+	companions             []string
+
+	// joinDecisions are the networks selected by the latest scan
+	joinDecisions joinDecisions
+
+	// mu serializes event handling with the dangling endpoint audits
+	mu sync.Mutex
+
+	// generation numbers published network changes
+	generation uint64
+
+	// settle learns how long new endpoints take to get an IP
+	settle settleTracker
+
+	metrics             *metrics.Registry
+	networkErrors       *metrics.Vec
+	networkReachable    *metrics.Vec
+	networkReadySeconds *metrics.Vec
+	danglingEndpoints   *metrics.Vec
+	networkChanges      *metrics.Vec
+	networkReasons      *metrics.Vec
 }
 
 // NetworkJoinerConfig holds configuration parameters for the NetworkJoiner service.
 // HTTPProxyContainerName specifies which container to manage network connections for.
+// DryRun logs the simulated plan without connecting or disconnecting anything.
+// Completed changes are written to StateDir (empty disables it) and posted to
+// WebhookURLs. Each join waits up to SettleTimeout (zero disables the wait) for
+// the new endpoint to report an IP, then up to ReadinessTimeout (zero disables
+// the check) for a container on the network to be reachable from the proxy.
+// With BatchJoin, the initial scan joins every network first and runs these
+// checks in a single pass at the end. Dangling endpoints of the proxy are
+// repaired after the initial scan and every RepairInterval (zero disables the
+// periodic audit). Metrics are served on MetricsAddr (empty disables them).
+// Companions are containers attached to and detached from networks along with
+// the proxy.
 type NetworkJoinerConfig struct {
 	HTTPProxyContainerName string
 	LogLevel               string
+	DryRun                 bool
+	StateDir               string
+	WebhookURLs            []string
+	SettleTimeout          time.Duration
+	ReadinessTimeout       time.Duration
+	BatchJoin              bool
+	RepairInterval         time.Duration
+	MetricsAddr            string
+	Companions             []string
 }
 
 // Validate checks if the configuration is valid
@@ -43,14 +100,45 @@ func (c *NetworkJoinerConfig) Validate() error {
 		return fmt.Errorf("container-name cannot be empty")
 	}
 
+	if c.SettleTimeout < 0 {
+		return fmt.Errorf("settle-timeout cannot be negative")
+	}
+
+	if c.ReadinessTimeout < 0 {
+		return fmt.Errorf("readiness-timeout cannot be negative")
+	}
+
+	if c.RepairInterval < 0 {
+		return fmt.Errorf("repair-interval cannot be negative")
+	}
+
 	return utils.ValidateLogLevel(c.LogLevel)
 }
 
 // NewNetworkJoiner creates a new NetworkJoiner with configuration
 func NewNetworkJoiner(cfg *NetworkJoinerConfig) *NetworkJoiner {
-	return &NetworkJoiner{
+	registry := metrics.NewRegistry()
+	nj := &NetworkJoiner{
 		httpProxyContainerName: cfg.HTTPProxyContainerName,
+		dryRun:                 cfg.DryRun,
+		webhookURLs:            cfg.WebhookURLs,
+		settleTimeout:          cfg.SettleTimeout,
+		readinessTimeout:       cfg.ReadinessTimeout,
+		batchJoin:              cfg.BatchJoin,
+		repairInterval:         cfg.RepairInterval,
+		companions:             cfg.Companions,
+		metrics:                registry,
+		networkErrors:          registry.Counter("http_proxy_join_network_errors_total", "Failed network connect and disconnect calls, by operation and error class.", "operation", "class"),
+		networkReachable:       registry.Gauge("http_proxy_join_network_reachable", "Whether a container on a joined network was reachable from the proxy (1) or not (0).", "network"),
+		networkReadySeconds:    registry.Gauge("http_proxy_join_network_ready_seconds", "Time a joined network took to become reachable from the proxy.", "network"),
+		danglingEndpoints:      registry.Counter("http_proxy_join_dangling_endpoints_total", "Dangling proxy endpoints found by the audit, by result (repaired, failed, skipped in dry-run mode).", "result"),
+		networkChanges:         registry.Counter("http_proxy_join_network_changes_total", "Networks joined, left and skipped by reconciliations, by change and join or skip reason.", "change", "reason"),
+		networkReasons:         registry.Gauge("http_proxy_join_network_reason", "Networks the proxy should be attached to (1), by the rule that selected them.", "network", "reason"),
+	}
+	if cfg.StateDir != "" {
+		nj.state = state.NewStore(cfg.StateDir)
 	}
+	return nj
 }
 
 // GetName returns the service name for the EventHandler interface
@@ -58,6 +146,12 @@ func (nj *NetworkJoiner) GetName() string {
 	return "join-networks"
 }
 
+// SetDockerTimeout sets the timeout of each Docker API call from the service
+// framework
+func (nj *NetworkJoiner) SetDockerTimeout(timeout time.Duration) {
+	nj.dockerTimeout = timeout
+}
+
 // SetDependencies sets the Docker client and logger from the service framework
 func (nj *NetworkJoiner) SetDependencies(dockerClient *client.Client, logger *logger.Logger) {
 	nj.dockerClient = dockerClient
@@ -66,10 +160,28 @@ func (nj *NetworkJoiner) SetDependencies(dockerClient *client.Client, logger *lo
 
 // HandleInitialScan scans all existing Docker bridge networks and connects the HTTP proxy
 // to any networks that contain manageable containers (containers with VIRTUAL_HOST or traefik labels).
-// This runs once at service startup to establish initial network connectivity.
+// This runs once at service startup to establish initial network connectivity,
+// then repairs the endpoints a crash may have left dangling.
 func (nj *NetworkJoiner) HandleInitialScan(ctx context.Context) error {
+	nj.mu.Lock()
+	defer nj.mu.Unlock()
+
 	nj.logger.Debug("Performing initial network scan and join")
-	return nj.performInitialNetworkJoin(ctx, nj.httpProxyContainerName)
+	if err := nj.performInitialNetworkJoin(ctx, nj.httpProxyContainerName, triggerInitialScan); err != nil {
+		return err
+	}
+
+	if _, err := nj.repairDanglingEndpoints(ctx); err != nil {
+		nj.logger.Warn("Failed to audit dangling endpoints", "error", err)
+	}
+	return nil
+}
+
+// RunBackground audits dangling endpoints every repairInterval.
+func (nj *NetworkJoiner) RunBackground(ctx context.Context) {
+	if nj.repairInterval > 0 {
+		nj.runRepairLoop(ctx, nj.repairInterval)
+	}
 }
 
 // HandleEvent responds to Docker container lifecycle events to dynamically manage network connections.
@@ -77,32 +189,42 @@ func (nj *NetworkJoiner) HandleInitialScan(ctx context.Context) error {
 // - Container 'die' events: Checks for empty networks (no manageable containers) and leaves them
 // - Other events: Ignored to avoid unnecessary processing
 func (nj *NetworkJoiner) HandleEvent(ctx context.Context, event events.Message) error {
-	action := string(event.Action)
-	switch action {
+	nj.mu.Lock()
+	defer nj.mu.Unlock()
+
+	ev := service.ParseContainerEvent(event)
+	switch ev.Action {
 	case "start":
 		return nj.handleContainerStart(ctx)
 	case "die":
 		return nj.handleContainerStop(ctx)
 	default:
-		nj.logger.Debug("Unhandled container action", "action", action)
+		nj.logger.Debug("Unhandled container action", ev.LogArgs()...)
 		return nil
 	}
 }
 
 // ContainerInfo consolidates essential container state from Docker API inspection.
 // Focuses on network connections to minimize API calls and provide network context.
+// PortBindingsNetworkID is the network the container was created on, where
+// Docker programs its published ports.
 type ContainerInfo struct {
-	ID       string
-	Networks NetworkSet
+	ID                    string
+	Networks              NetworkSet
+	NetworkNames          map[string]string
+	PortBindingsNetworkID string
+	HasPortBindings       bool
 }
 
 // NetworkOperation encapsulates a simple network management operation including
-// the target container and planned join/leave operations.
+// the target container and planned join/leave operations. Batch defers the
+// checks of each join to a single validation pass after the last one.
 type NetworkOperation struct {
 	HTTPProxyContainerName string
 	ContainerID            string
 	ToJoin                 []string
 	ToLeave                []string
+	Batch                  bool
 }
 
 // NetworkSet represents a set of network IDs for cleaner set operations
@@ -122,12 +244,49 @@ func (ns NetworkSet) Add(networkID string) {
 func main() {
 	containerName := flag.String("container-name", "http-proxy", "the name of this docker container")
 	logLevel := flag.String("log-level", "info", "log level (debug, info, warn, error)")
+	dryRun := flag.Bool("dry-run", false, "log the simulated network plan without joining or leaving networks")
+	stateDir := flag.String("state-dir", config.GetEnvOrDefault("HTTP_PROXY_STATE_DIR", state.DefaultDir), "directory where network changes are recorded (empty disables)")
+	webhooks := flag.String("webhooks", config.GetEnvOrDefault("HTTP_PROXY_JOIN_WEBHOOKS", ""), "comma-separated URLs that receive a POST after each network change")
+	settleTimeout := flag.String("settle-timeout", config.GetEnvOrDefault("HTTP_PROXY_JOIN_SETTLE_TIMEOUT", DefaultSettleTimeout.String()), "maximum wait for a joined network endpoint to get an IP (0 disables)")
+	readinessTimeout := flag.String("readiness-timeout", config.GetEnvOrDefault("HTTP_PROXY_JOIN_READINESS_TIMEOUT", DefaultReadinessTimeout.String()), "maximum wait for a container on a joined network to be reachable from the proxy (0 disables)")
+	batchJoin := flag.Bool("batch-join", config.GetEnvOrDefault("HTTP_PROXY_JOIN_BATCH", "true") == "true", "join all networks of the initial scan before checking any of them")
+	repairInterval := flag.String("repair-interval", config.GetEnvOrDefault("HTTP_PROXY_JOIN_REPAIR_INTERVAL", DefaultRepairInterval.String()), "how often dangling proxy endpoints are audited and disconnected (0 disables)")
+	repairOnly := flag.Bool("repair", false, "audit and disconnect dangling proxy endpoints once, print the report and exit")
+	companions := flag.String("companions", config.GetEnvOrDefault("HTTP_PROXY_JOIN_COMPANIONS", ""), "comma-separated containers attached to and detached from networks along with the proxy")
+	metricsAddr := flag.String("metrics-addr", config.GetEnvOrDefault("HTTP_PROXY_JOIN_METRICS_ADDR", ":9154"), "listen address of the Prometheus metrics endpoint (empty disables)")
 	flag.Parse()
 
+	settle, err := time.ParseDuration(*settleTimeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration error: invalid settle-timeout: %v\n", err)
+		os.Exit(1)
+	}
+
+	readiness, err := time.ParseDuration(*readinessTimeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration error: invalid readiness-timeout: %v\n", err)
+		os.Exit(1)
+	}
+
+	repairEvery, err := time.ParseDuration(*repairInterval)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration error: invalid repair-interval: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Create and validate configuration
 	cfg := &NetworkJoinerConfig{
 		HTTPProxyContainerName: *containerName,
 		LogLevel:               *logLevel,
+		DryRun:                 *dryRun,
+		StateDir:               *stateDir,
+		WebhookURLs:            splitList(*webhooks),
+		SettleTimeout:          settle,
+		ReadinessTimeout:       readiness,
+		BatchJoin:              *batchJoin,
+		RepairInterval:         repairEvery,
+		MetricsAddr:            *metricsAddr,
+		Companions:             splitList(*companions),
 	}
 
 	if err := cfg.Validate(); err != nil {
@@ -137,9 +296,16 @@ func main() {
 
 	// Create the handler
 	handler := NewNetworkJoiner(cfg)
+	ctx := context.Background()
+	if *repairOnly {
+		os.Exit(runRepairOnce(ctx, handler, cfg.LogLevel))
+	}
+
+	if cfg.MetricsAddr != "" {
+		go handler.serveHTTP(ctx, cfg.MetricsAddr)
+	}
 
 	// Run the service using the shared service framework
-	ctx := context.Background()
 	if err := service.RunWithSignalHandling(ctx, "join-networks", cfg.LogLevel, handler); err != nil {
 		fmt.Fprintf(os.Stderr, "Service failed: %v\n", err)
 		os.Exit(1)
@@ -149,7 +315,7 @@ func main() {
 // performInitialNetworkJoin orchestrates the network discovery and connection process.
 // It inspects the HTTP proxy container's current state, discovers all bridge networks with
 // manageable containers, calculates which networks to join/leave, and executes the operations.
-func (nj *NetworkJoiner) performInitialNetworkJoin(ctx context.Context, containerProxy string) error {
+func (nj *NetworkJoiner) performInitialNetworkJoin(ctx context.Context, containerProxy, trigger string) error {
 	// Get current container state
 	containerInfo, err := nj.getContainerInfo(ctx, containerProxy)
 	if err != nil {
@@ -158,10 +324,12 @@ func (nj *NetworkJoiner) performInitialNetworkJoin(ctx context.Context, containe
 
 	currentNetworks := containerInfo.Networks
 
-	bridgeNetworks, err := nj.getActiveBridgeNetworks(ctx, containerInfo.ID)
+	decisions, err := nj.getActiveBridgeNetworks(ctx, containerInfo.ID)
 	if err != nil {
 		return fmt.Errorf("failed to get bridge networks: %w", err)
 	}
+	nj.recordJoinDecisions(decisions)
+	bridgeNetworks := decisions.networks()
 
 	defaultBridgeID, err := nj.getDefaultBridgeNetworkID(ctx)
 	if err != nil {
@@ -171,21 +339,44 @@ func (nj *NetworkJoiner) performInitialNetworkJoin(ctx context.Context, containe
 	toJoin := nj.getNetworksToJoin(currentNetworks, bridgeNetworks)
 	toLeave := nj.getNetworksToLeave(currentNetworks, bridgeNetworks, defaultBridgeID)
 
-	nj.logger.Info("Network operation plan",
+	nj.logger.Debug("Network state",
 		"current_networks", len(currentNetworks),
-		"bridge_networks", len(bridgeNetworks),
-		"to_join", len(toJoin),
-		"to_leave", len(toLeave))
+		"bridge_networks", len(bridgeNetworks))
+
+	// Simulate the impact of the plan before touching anything
+	plan := nj.buildNetworkPlan(ctx, containerInfo, toJoin, toLeave)
+	diff := networkDiff{reasons: decisions.reasons()}
+	diff.skipBlockedLeaves(plan)
+	if nj.dryRun {
+		nj.skipAll(ctx, &diff, plan, skipReasonDryRun)
+		nj.recordDiff(trigger, NetworkChangeEvent{Skipped: skippedNetworks(diff.skipped)})
+		return nil
+	}
 
 	// Create operation struct
 	operation := &NetworkOperation{
 		HTTPProxyContainerName: containerProxy,
 		ContainerID:            containerInfo.ID,
-		ToJoin:                 toJoin,
-		ToLeave:                toLeave,
+		ToJoin:                 plan.ToJoin,
+		ToLeave:                plan.SafeLeaves(),
+		// Nothing routes through networks joined on a cold start yet, so
+		// they are checked once they are all joined
+		Batch: nj.batchJoin && trigger == triggerInitialScan,
+	}
+
+	joined, err := nj.performNetworkOperations(ctx, operation)
+	if err != nil {
+		return err
 	}
 
-	return nj.performNetworkOperations(ctx, operation)
+	diff.joined, diff.left = joined, operation.ToLeave
+	for _, networkID := range operation.ToJoin {
+		if !slices.Contains(joined, networkID) {
+			diff.skip(networkID, "unknown", diffOpJoin, skipReasonNotFound)
+		}
+	}
+	nj.publishChange(ctx, trigger, containerInfo, diff)
+	return nil
 }
 
 // handleContainerStart responds to container start events by re-scanning all networks
@@ -194,7 +385,7 @@ func (nj *NetworkJoiner) performInitialNetworkJoin(ctx context.Context, containe
 func (nj *NetworkJoiner) handleContainerStart(ctx context.Context) error {
 	// Re-scan and join any new bridge networks
 	nj.logger.Debug("Container started, checking for new networks to join")
-	return nj.performInitialNetworkJoin(ctx, nj.httpProxyContainerName)
+	return nj.performInitialNetworkJoin(ctx, nj.httpProxyContainerName, triggerContainerStart)
 }
 
 // handleContainerStop responds to container stop events by identifying networks that
@@ -219,7 +410,7 @@ func (nj *NetworkJoiner) handleContainerStop(ctx context.Context) error {
 		}
 
 		// Check if network has any manageable containers
-		hasActiveContainers, err := utils.HasManageableContainersInNetwork(ctx, nj.dockerClient, networkID, nj.httpProxyContainerName)
+		hasActiveContainers, err := utils.HasManageableContainersInNetwork(ctx, nj.dockerClient, nj.dockerTimeout, networkID, nj.httpProxyContainerName)
 		if err != nil {
 			nj.logger.Warn("Failed to check network for manageable containers",
 				"network_id", utils.FormatDockerID(networkID), "error", err)
@@ -234,13 +425,28 @@ func (nj *NetworkJoiner) handleContainerStop(ctx context.Context) error {
 	if len(networksToLeave) > 0 {
 		nj.logger.Info("Found empty networks to leave", "count", len(networksToLeave))
 
+		plan := nj.buildNetworkPlan(ctx, containerInfo, nil, networksToLeave)
+		diff := networkDiff{reasons: nj.joinDecisions.reasons()}
+		diff.skipBlockedLeaves(plan)
+		if nj.dryRun {
+			nj.skipAll(ctx, &diff, plan, skipReasonDryRun)
+			nj.recordDiff(triggerContainerStop, NetworkChangeEvent{Skipped: skippedNetworks(diff.skipped)})
+			return nil
+		}
+
 		// Leave empty networks
-		for _, networkID := range networksToLeave {
+		for _, networkID := range plan.SafeLeaves() {
 			if err := nj.safeLeaveNetwork(ctx, nj.httpProxyContainerName, networkID); err != nil {
 				nj.logger.Error("Failed to leave empty network",
 					"network_id", utils.FormatDockerID(networkID), "error", err)
+				diff.skip(networkID, containerInfo.NetworkNames[networkID], diffOpLeave, skipReasonFailed)
+				continue
 			}
+			diff.left = append(diff.left, networkID)
+			nj.forgetJoinDecision(networkID)
 		}
+
+		nj.publishChange(ctx, triggerContainerStop, containerInfo, diff)
 	}
 
 	return nil
@@ -250,33 +456,49 @@ func (nj *NetworkJoiner) handleContainerStop(ctx context.Context) error {
 // extracting network connections, port bindings, and connectivity status in a single API call.
 // This optimizes performance by avoiding multiple API calls and provides complete container state.
 func (nj *NetworkJoiner) getContainerInfo(ctx context.Context, containerName string) (*ContainerInfo, error) {
-	containerJSON, err := utils.RetryContainerInspect(ctx, nj.dockerClient, containerName)
+	containerJSON, err := utils.RetryContainerInspect(ctx, nj.dockerClient, nj.dockerTimeout, containerName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to inspect container %s: %w", containerName, err)
 	}
 
-	networks := make(NetworkSet)
+	info := &ContainerInfo{
+		ID:           containerJSON.ID,
+		Networks:     make(NetworkSet),
+		NetworkNames: make(map[string]string),
+	}
+
+	var networkMode string
+	if containerJSON.HostConfig != nil {
+		networkMode = string(containerJSON.HostConfig.NetworkMode)
+		info.HasPortBindings = len(containerJSON.HostConfig.PortBindings) > 0
+	}
+	// "default" is Docker's alias for the default bridge network
+	if networkMode == "default" {
+		networkMode = defaultBridgeName
+	}
 
 	if containerJSON.NetworkSettings != nil {
-		for _, networkData := range containerJSON.NetworkSettings.Networks {
-			networks.Add(networkData.NetworkID)
+		for name, networkData := range containerJSON.NetworkSettings.Networks {
+			info.Networks.Add(networkData.NetworkID)
+			info.NetworkNames[networkData.NetworkID] = name
+			if name == networkMode {
+				info.PortBindingsNetworkID = networkData.NetworkID
+			}
 		}
 	}
 
-	return &ContainerInfo{
-		ID:       containerJSON.ID,
-		Networks: networks,
-	}, nil
+	return info, nil
 }
 
 // performNetworkOperations executes the planned network join/leave operations.
 // Operations are performed in sequence: leave unwanted networks first, then join new networks.
 // If any operation fails, the process exits to allow restart and recovery.
-func (nj *NetworkJoiner) performNetworkOperations(ctx context.Context, op *NetworkOperation) error {
+// It returns the networks joined.
+func (nj *NetworkJoiner) performNetworkOperations(ctx context.Context, op *NetworkOperation) ([]string, error) {
 	// Execute leave operations first
 	if len(op.ToLeave) > 0 {
 		if err := nj.executeLeaveOperations(ctx, op); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
@@ -285,23 +507,31 @@ func (nj *NetworkJoiner) performNetworkOperations(ctx context.Context, op *Netwo
 		return nj.executeJoinOperations(ctx, op)
 	}
 
-	return nil
+	return nil, nil
 }
 
 // executeJoinOperations connects the HTTP proxy to each specified network.
-// If any operation fails, the process will exit and restart.
-func (nj *NetworkJoiner) executeJoinOperations(ctx context.Context, op *NetworkOperation) error {
-	for _, networkID := range op.ToJoin {
-		if err := utils.CheckContext(ctx); err != nil {
-			return err
-		}
+// If any operation fails, the process will exit and restart. It returns the
+// networks joined; networks removed since the scan are skipped.
+func (nj *NetworkJoiner) executeJoinOperations(ctx context.Context, op *NetworkOperation) ([]string, error) {
+	if op.Batch && len(op.ToJoin) > 1 {
+		nj.logger.Info("Joining networks in a batch", "count", len(op.ToJoin))
+	}
 
-		if err := nj.safeJoinNetwork(ctx, op.HTTPProxyContainerName, networkID); err != nil {
+	join := func(ctx context.Context, networkID string) error {
+		err := nj.safeJoinNetwork(ctx, op.HTTPProxyContainerName, networkID)
+		if errors.Is(err, ErrNetworkGone) {
+			nj.logger.Warn("Skipping network that no longer exists", "network_id", utils.FormatDockerID(networkID))
+		} else if err != nil {
 			nj.logger.Error("Failed to join network", "network_id", utils.FormatDockerID(networkID), "error", err)
-			return err
 		}
+		return err
 	}
-	return nil
+	verify := func(ctx context.Context, networkID string) {
+		nj.verifyJoin(ctx, op.HTTPProxyContainerName, networkID)
+	}
+
+	return joinNetworks(ctx, op.ToJoin, op.Batch, join, verify)
 }
 
 // executeLeaveOperations disconnects the HTTP proxy from specified networks.
@@ -320,34 +550,85 @@ func (nj *NetworkJoiner) executeLeaveOperations(ctx context.Context, op *Network
 	return nil
 }
 
-// safeJoinNetwork connects the HTTP proxy container to a specified network.
+// safeJoinNetwork connects the HTTP proxy container, then its companions, to a
+// specified network; verifyJoin checks the new endpoint.
 func (nj *NetworkJoiner) safeJoinNetwork(ctx context.Context, containerName, networkID string) error {
-	netName := nj.getNetworkName(ctx, networkID)
-	nj.logger.Info("Joining network", "name", netName, "id", utils.FormatDockerID(networkID))
-
-	err := utils.RetryNetworkConnect(ctx, nj.dockerClient, networkID, containerName, &network.EndpointSettings{})
+	op := nj.logger.WithOperation("network-join", utils.FormatDockerID(networkID)).
+		With("name", nj.getNetworkName(ctx, networkID))
+	op.Info("Joining network")
+
+	err := nj.runNetworkOp(ctx, opConnect, networkID, func(ctx context.Context) error {
+		return nj.dockerClient.NetworkConnect(ctx, networkID, containerName, &network.EndpointSettings{})
+	})
+	if errors.Is(err, ErrAlreadyConnected) {
+		op.End(nil, "already_connected", true)
+		nj.joinCompanions(ctx, networkID)
+		return nil
+	}
+	if errors.Is(err, ErrNotFound) && nj.networkGone(ctx, networkID) {
+		op.End(nil, "network_gone", true)
+		return fmt.Errorf("failed to join network %s: %w", utils.FormatDockerID(networkID), ErrNetworkGone)
+	}
 	if err != nil {
-		nj.logger.Error("Failed to join network", "name", netName, "id", utils.FormatDockerID(networkID), "error", err)
+		op.End(err)
 		return fmt.Errorf("failed to join network %s: %w", utils.FormatDockerID(networkID), err)
 	}
 
-	nj.logger.Debug("Successfully joined network", "name", netName, "id", utils.FormatDockerID(networkID))
+	op.End(nil)
+	nj.joinCompanions(ctx, networkID)
 	return nil
 }
 
-// safeLeaveNetwork disconnects the HTTP proxy container from a specified network.
+// verifyJoin waits for the proxy's endpoint on a joined network and checks
+// that a container on it is reachable, as far as the timeouts allow.
+func (nj *NetworkJoiner) verifyJoin(ctx context.Context, containerName, networkID string) {
+	netName := nj.getNetworkName(ctx, networkID)
+
+	// The connect call returns before the endpoint is fully set up; wait for
+	// its IP so routes through it work once the change is published
+	if nj.settleTimeout > 0 {
+		elapsed, ok := nj.settle.waitForEndpoint(ctx, nj.proxyEndpointLookup(containerName, networkID), nj.settleTimeout)
+		if !ok {
+			nj.logger.Warn("Network endpoint did not get an IP in time",
+				"name", netName, "id", utils.FormatDockerID(networkID), "waited", elapsed)
+		} else {
+			nj.logger.Debug("Network endpoint ready",
+				"name", netName, "id", utils.FormatDockerID(networkID),
+				"elapsed", elapsed, "average", nj.settle.Average())
+		}
+	}
+
+	// An endpoint with an IP can still drop traffic for a moment; check that
+	// the proxy reaches a container on the network before reporting the join
+	if nj.readinessTimeout > 0 {
+		nj.checkReadiness(ctx, containerName, networkID, netName)
+	}
+}
+
+// safeLeaveNetwork disconnects the HTTP proxy container and its companions from a specified network.
 // The 'force' flag ensures disconnection even if the container is running.
 func (nj *NetworkJoiner) safeLeaveNetwork(ctx context.Context, containerName, networkID string) error {
 	netName := nj.getNetworkName(ctx, networkID)
-	nj.logger.Info("Leaving network", "name", netName, "id", utils.FormatDockerID(networkID))
-
-	err := nj.dockerClient.NetworkDisconnect(ctx, networkID, containerName, true)
+	op := nj.logger.WithOperation("network-leave", utils.FormatDockerID(networkID)).With("name", netName)
+	op.Info("Leaving network")
+
+	err := nj.runNetworkOp(ctx, opDisconnect, networkID, func(ctx context.Context) error {
+		return nj.dockerClient.NetworkDisconnect(ctx, networkID, containerName, true)
+	})
+	if errors.Is(err, ErrNotConnected) || errors.Is(err, ErrNotFound) {
+		op.End(nil, "already_disconnected", true)
+		nj.leaveCompanions(ctx, networkID)
+		return nil
+	}
 	if err != nil {
-		nj.logger.Error("Failed to leave network", "name", netName, "id", utils.FormatDockerID(networkID), "error", err)
+		op.End(err)
 		return fmt.Errorf("failed to leave network %s: %w", utils.FormatDockerID(networkID), err)
 	}
 
-	nj.logger.Debug("Successfully left network", "name", netName, "id", utils.FormatDockerID(networkID))
+	op.End(nil)
+	nj.leaveCompanions(ctx, networkID)
+	nj.networkReachable.Delete(netName)
+	nj.networkReadySeconds.Delete(netName)
 	return nil
 }
 
@@ -355,7 +636,7 @@ func (nj *NetworkJoiner) safeLeaveNetwork(ctx context.Context, containerName, ne
 // Falls back to a formatted ID if the network name cannot be determined, ensuring
 // consistent logging even when networks are in transitional states.
 func (nj *NetworkJoiner) getNetworkName(ctx context.Context, networkID string) string {
-	if netResource, err := utils.RetryNetworkInspect(ctx, nj.dockerClient, networkID, network.InspectOptions{}); err == nil {
+	if netResource, err := utils.RetryNetworkInspect(ctx, nj.dockerClient, nj.dockerTimeout, networkID, network.InspectOptions{}); err == nil {
 		return netResource.Name
 	}
 	return "unknown"
@@ -365,7 +646,7 @@ func (nj *NetworkJoiner) getNetworkName(ctx context.Context, networkID string) s
 // The default bridge is excluded from automatic management because it contains system
 // containers and should not be used for custom application routing.
 func (nj *NetworkJoiner) getDefaultBridgeNetworkID(ctx context.Context) (string, error) {
-	networks, err := nj.dockerClient.NetworkList(ctx, network.ListOptions{})
+	networks, err := utils.RetryNetworkList(ctx, nj.dockerClient, nj.dockerTimeout, network.ListOptions{})
 	if err != nil {
 		return "", err
 	}
@@ -381,21 +662,31 @@ func (nj *NetworkJoiner) getDefaultBridgeNetworkID(ctx context.Context) (string,
 // getActiveBridgeNetworks discovers all Docker bridge networks that contain manageable containers.
 // Scans each bridge network to identify containers with VIRTUAL_HOST environment variables
 // or Traefik labels, excluding the HTTP proxy container itself and any non-manageable containers.
-// Only considers containers that have dinghy env vars (VIRTUAL_HOST) or traefik labels
-func (nj *NetworkJoiner) getActiveBridgeNetworks(ctx context.Context, containerID string) (NetworkSet, error) {
-	networks := make(NetworkSet)
+// Only considers containers that have dinghy env vars (VIRTUAL_HOST) or traefik labels, besides
+// the default bridge.
+// Each selected network is returned with the rule that selected it.
+func (nj *NetworkJoiner) getActiveBridgeNetworks(ctx context.Context, containerID string) (joinDecisions, error) {
+	networks := make(joinDecisions)
 
-	allNetworks, err := nj.dockerClient.NetworkList(ctx, network.ListOptions{})
+	allNetworks, err := utils.RetryNetworkList(ctx, nj.dockerClient, nj.dockerTimeout, network.ListOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list networks: %w", err)
 	}
 
+	// Manageable containers sharing another container's network namespace are
+	// not listed on any network; count them on their namespace owner's
+	sharedNetworks, err := nj.sharedNamespaceNetworks(ctx, containerID)
+	if err != nil {
+		nj.logger.Warn("Failed to check containers sharing network namespaces", "error", err)
+		sharedNetworks = make(NetworkSet)
+	}
+
 	for _, netOverview := range allNetworks {
 		if netOverview.Driver != bridgeDriverName {
 			continue
 		}
 
-		net, err := utils.RetryNetworkInspect(ctx, nj.dockerClient, netOverview.ID, network.InspectOptions{})
+		net, err := utils.RetryNetworkInspect(ctx, nj.dockerClient, nj.dockerTimeout, netOverview.ID, network.InspectOptions{})
 		if err != nil {
 			nj.logger.Warn("Failed to get info for network", "network_id", netOverview.ID, "error", err)
 			continue
@@ -405,15 +696,23 @@ func (nj *NetworkJoiner) getActiveBridgeNetworks(ctx context.Context, containerI
 
 		// Always include default bridge
 		if isDefaultBridge {
-			networks.Add(net.ID)
+			networks[net.ID] = joinDecision{Name: net.Name, Reason: joinReasonDefaultBridge}
 			nj.logger.Debug("Including default bridge network",
 				"name", net.Name,
 				"id", utils.FormatDockerID(net.ID))
 			continue
 		}
 
+		if sharedNetworks.Contains(net.ID) {
+			networks[net.ID] = joinDecision{Name: net.Name, Reason: joinReasonSharedNamespace}
+			nj.logger.Info("Including bridge network shared with manageable containers",
+				"name", net.Name,
+				"id", utils.FormatDockerID(net.ID))
+			continue
+		}
+
 		// For non-default networks, only include if they have manageable containers
-		hasManageableContainers, err := utils.HasManageableContainersInNetwork(ctx, nj.dockerClient, net.ID, containerID)
+		hasManageableContainers, err := utils.HasManageableContainersInNetwork(ctx, nj.dockerClient, nj.dockerTimeout, net.ID, containerID)
 		if err != nil {
 			nj.logger.Warn("Failed to check network for manageable containers",
 				"network_id", utils.FormatDockerID(net.ID), "error", err)
@@ -421,7 +720,7 @@ func (nj *NetworkJoiner) getActiveBridgeNetworks(ctx context.Context, containerI
 		}
 
 		if hasManageableContainers {
-			networks.Add(net.ID)
+			networks[net.ID] = joinDecision{Name: net.Name, Reason: joinReasonManageable}
 			nj.logger.Info("Including bridge network with manageable containers",
 				"name", net.Name,
 				"id", utils.FormatDockerID(net.ID))
@@ -466,3 +765,52 @@ func (nj *NetworkJoiner) getNetworksToLeave(currentNetworks, bridgeNetworks Netw
 	}
 	return networkIDs
 }
+
+// runRepairOnce audits dangling endpoints without starting the service and
+// prints the report as JSON. It returns the process exit code.
+func runRepairOnce(ctx context.Context, nj *NetworkJoiner, logLevel string) int {
+	svc, err := service.NewService(ctx, "join-networks", logLevel, nj)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize join-networks: %v\n", err)
+		return 1
+	}
+	defer svc.Close()
+
+	report, err := nj.repair(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Repair failed: %v\n", err)
+		return 1
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print report: %v\n", err)
+		return 1
+	}
+	if report.Failed() {
+		return 1
+	}
+	return 0
+}
+
+// serveHTTP serves the metrics registry and the repair trigger on addr until
+// ctx is done. A failure to listen is reported and does not stop the service.
+func (nj *NetworkJoiner) serveHTTP(ctx context.Context, addr string) {
+	mux := nj.metrics.ServeMux()
+	mux.HandleFunc("POST /repair", nj.handleRepair)
+	if err := metrics.Serve(ctx, addr, mux); err != nil {
+		fmt.Fprintf(os.Stderr, "Metrics endpoint stopped: %v\n", err)
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// namespaceSharingContainers returns the containers started with network_mode
+// "container:<id>" (Compose "service:<name>"), excluding the proxy itself.
+// They have no endpoints of their own, so network inspection never lists them.
+func namespaceSharingContainers(containers []types.Container, excludeContainerName string) []types.Container {
+	var sharing []types.Container
+	for _, c := range containers {
+		if !container.NetworkMode(c.HostConfig.NetworkMode).IsContainer() {
+			continue
+		}
+		excluded := false
+		for _, name := range c.Names {
+			if strings.TrimPrefix(name, "/") == excludeContainerName {
+				excluded = true
+			}
+		}
+		if !excluded {
+			sharing = append(sharing, c)
+		}
+	}
+	return sharing
+}
+
+// sharedNamespaceNetworks returns the networks reached by running manageable
+// containers through another container's network namespace: the networks of
+// the namespace owner, which is usually not manageable itself (e.g. an app
+// with network_mode "service:db").
+func (nj *NetworkJoiner) sharedNamespaceNetworks(ctx context.Context, excludeContainerName string) (NetworkSet, error) {
+	networks := make(NetworkSet)
+
+	containers, err := utils.RetryContainerList(ctx, nj.dockerClient, nj.dockerTimeout, container.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	for _, c := range namespaceSharingContainers(containers, excludeContainerName) {
+		inspect, err := utils.RetryContainerInspect(ctx, nj.dockerClient, nj.dockerTimeout, c.ID)
+		if err != nil || !inspect.State.Running || !utils.ShouldManageContainer(inspect.Config.Env, inspect.Config.Labels) {
+			continue
+		}
+
+		owner, err := utils.ResolveNetworkNamespaceOwner(ctx, nj.dockerClient, nj.dockerTimeout, inspect)
+		if err != nil {
+			nj.logger.Warn("Failed to resolve network namespace owner",
+				"container_id", utils.FormatDockerID(c.ID), "error", err)
+			continue
+		}
+		if owner.NetworkSettings == nil {
+			continue
+		}
+
+		for _, endpoint := range owner.NetworkSettings.Networks {
+			networks.Add(endpoint.NetworkID)
+		}
+		nj.logger.Debug("Container shares another container's network namespace",
+			"container_id", utils.FormatDockerID(c.ID),
+			"owner_id", utils.FormatDockerID(owner.ID),
+			"networks", len(owner.NetworkSettings.Networks))
+	}
+
+	return networks, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestNamespaceSharingContainers(t *testing.T) {
+	summary := func(id, name, mode string) types.Container {
+		c := types.Container{ID: id, Names: []string{"/" + name}}
+		c.HostConfig.NetworkMode = mode
+		return c
+	}
+	containers := []types.Container{
+		summary("a", "db", "bridge"),
+		summary("b", "app", "container:a"),
+		summary("c", "http-proxy", "container:a"),
+		summary("d", "tool", "host"),
+		summary("e", "worker", "default"),
+	}
+
+	got := namespaceSharingContainers(containers, "http-proxy")
+	if len(got) != 1 || got[0].ID != "b" {
+		t.Errorf("got %v, want only the app container", got)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	"github.com/docker/docker/api/types/network"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// LeaveVerdict is the simulated outcome of leaving a network.
+type LeaveVerdict string
+
+const (
+	// VerdictSafe means the leave does not affect external connectivity.
+	VerdictSafe LeaveVerdict = "safe"
+	// VerdictBlockedPortBindings means the network carries the proxy's published
+	// ports; Docker programs port bindings on the endpoint of the network the
+	// container was created on, so leaving it makes ports 80/443 unreachable.
+	VerdictBlockedPortBindings LeaveVerdict = "blocked-port-bindings"
+	// VerdictBlockedLastExternal means the leave would leave the proxy with no
+	// non-internal network, cutting off external connectivity.
+	VerdictBlockedLastExternal LeaveVerdict = "blocked-last-external-network"
+)
+
+// LeaveImpact is the simulated effect of a single planned leave.
+type LeaveImpact struct {
+	NetworkID   string       `json:"network_id"`
+	NetworkName string       `json:"network_name"`
+	Verdict     LeaveVerdict `json:"verdict"`
+}
+
+// NetworkPlan is the simulated join/leave plan, logged before execution in
+// both dry-run and live mode.
+type NetworkPlan struct {
+	DryRun bool          `json:"dry_run"`
+	ToJoin []string      `json:"to_join"`
+	Leaves []LeaveImpact `json:"leaves"`
+}
+
+// SafeLeaves returns the network IDs whose leave was simulated as safe.
+func (p *NetworkPlan) SafeLeaves() []string {
+	var ids []string
+	for _, leave := range p.Leaves {
+		if leave.Verdict == VerdictSafe {
+			ids = append(ids, leave.NetworkID)
+		}
+	}
+	return ids
+}
+
+// simulateLeaves evaluates each planned leave against the proxy's current
+// networks. Leaves run before joins, so only networks the proxy is already
+// attached to count towards remaining connectivity. internal reports which of
+// the current networks are Docker internal (no external connectivity).
+func simulateLeaves(info *ContainerInfo, toLeave []string, internal map[string]bool) []LeaveImpact {
+	ids := append([]string(nil), toLeave...)
+	sort.Strings(ids)
+
+	remaining := make(NetworkSet)
+	for id := range info.Networks {
+		if !internal[id] {
+			remaining.Add(id)
+		}
+	}
+
+	impacts := make([]LeaveImpact, 0, len(ids))
+	for _, id := range ids {
+		impact := LeaveImpact{NetworkID: id, NetworkName: info.NetworkNames[id], Verdict: VerdictSafe}
+
+		switch {
+		case info.HasPortBindings && id == info.PortBindingsNetworkID:
+			impact.Verdict = VerdictBlockedPortBindings
+		case remaining.Contains(id) && len(remaining) == 1:
+			impact.Verdict = VerdictBlockedLastExternal
+		default:
+			delete(remaining, id)
+		}
+
+		impacts = append(impacts, impact)
+	}
+	return impacts
+}
+
+// buildNetworkPlan simulates the planned operations and logs the resulting plan.
+func (nj *NetworkJoiner) buildNetworkPlan(ctx context.Context, info *ContainerInfo, toJoin, toLeave []string) *NetworkPlan {
+	plan := &NetworkPlan{
+		DryRun: nj.dryRun,
+		ToJoin: toJoin,
+		Leaves: simulateLeaves(info, toLeave, nj.getInternalNetworks(ctx, info.Networks)),
+	}
+
+	for _, leave := range plan.Leaves {
+		if leave.Verdict != VerdictSafe {
+			nj.logger.Warn("Skipping planned network leave",
+				"name", leave.NetworkName,
+				"id", utils.FormatDockerID(leave.NetworkID),
+				"verdict", leave.Verdict)
+		}
+	}
+
+	nj.logger.Info("Network operation plan",
+		"dry_run", plan.DryRun,
+		"to_join", len(plan.ToJoin),
+		"to_leave", len(plan.SafeLeaves()),
+		"plan", plan)
+
+	return plan
+}
+
+// getInternalNetworks reports which of the given networks are Docker internal
+// networks. Networks that cannot be inspected are treated as internal so the
+// simulation never counts on connectivity it cannot confirm.
+func (nj *NetworkJoiner) getInternalNetworks(ctx context.Context, networks NetworkSet) map[string]bool {
+	internal := make(map[string]bool, len(networks))
+	for id := range networks {
+		net, err := utils.RetryNetworkInspect(ctx, nj.dockerClient, nj.dockerTimeout, id, network.InspectOptions{})
+		if err != nil {
+			nj.logger.Debug("Failed to inspect network for plan simulation",
+				"network_id", utils.FormatDockerID(id), "error", err)
+			internal[id] = true
+			continue
+		}
+		internal[id] = net.Internal
+	}
+	return internal
+}
@@ -0,0 +1,99 @@
+package main
+
+import "testing"
+
+func testContainerInfo(networks ...string) *ContainerInfo {
+	info := &ContainerInfo{Networks: make(NetworkSet), NetworkNames: make(map[string]string)}
+	for _, id := range networks {
+		info.Networks.Add(id)
+		info.NetworkNames[id] = "net-" + id
+	}
+	return info
+}
+
+func TestSimulateLeaves(t *testing.T) {
+	tests := []struct {
+		name     string
+		info     *ContainerInfo
+		toLeave  []string
+		internal map[string]bool
+		want     map[string]LeaveVerdict
+	}{
+		{
+			name:    "safe when other external networks remain",
+			info:    testContainerInfo("a", "b", "c"),
+			toLeave: []string{"b"},
+			want:    map[string]LeaveVerdict{"b": VerdictSafe},
+		},
+		{
+			name: "blocked when network carries port bindings",
+			info: func() *ContainerInfo {
+				info := testContainerInfo("a", "b")
+				info.HasPortBindings = true
+				info.PortBindingsNetworkID = "a"
+				return info
+			}(),
+			toLeave: []string{"a", "b"},
+			want:    map[string]LeaveVerdict{"a": VerdictBlockedPortBindings, "b": VerdictSafe},
+		},
+		{
+			name: "port bindings network safe without published ports",
+			info: func() *ContainerInfo {
+				info := testContainerInfo("a", "b")
+				info.PortBindingsNetworkID = "a"
+				return info
+			}(),
+			toLeave: []string{"a"},
+			want:    map[string]LeaveVerdict{"a": VerdictSafe},
+		},
+		{
+			name:    "last external network is kept",
+			info:    testContainerInfo("a", "b"),
+			toLeave: []string{"a", "b"},
+			want:    map[string]LeaveVerdict{"a": VerdictSafe, "b": VerdictBlockedLastExternal},
+		},
+		{
+			name:     "internal networks do not count as external",
+			info:     testContainerInfo("a", "internal"),
+			toLeave:  []string{"a"},
+			internal: map[string]bool{"internal": true},
+			want:     map[string]LeaveVerdict{"a": VerdictBlockedLastExternal},
+		},
+		{
+			name:     "leaving internal network is always safe",
+			info:     testContainerInfo("a", "internal"),
+			toLeave:  []string{"internal"},
+			internal: map[string]bool{"internal": true},
+			want:     map[string]LeaveVerdict{"internal": VerdictSafe},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := simulateLeaves(tt.info, tt.toLeave, tt.internal)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d impacts, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for _, impact := range got {
+				if want := tt.want[impact.NetworkID]; impact.Verdict != want {
+					t.Errorf("verdict for %s = %s, want %s", impact.NetworkID, impact.Verdict, want)
+				}
+				if impact.NetworkName != "net-"+impact.NetworkID {
+					t.Errorf("network name for %s = %q", impact.NetworkID, impact.NetworkName)
+				}
+			}
+		})
+	}
+}
+
+func TestNetworkPlanSafeLeaves(t *testing.T) {
+	plan := &NetworkPlan{Leaves: []LeaveImpact{
+		{NetworkID: "a", Verdict: VerdictSafe},
+		{NetworkID: "b", Verdict: VerdictBlockedPortBindings},
+		{NetworkID: "c", Verdict: VerdictSafe},
+	}}
+	got := plan.SafeLeaves()
+	if len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Errorf("SafeLeaves = %v, want [a c]", got)
+	}
+}
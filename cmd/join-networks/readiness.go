@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+const (
+	// DefaultReadinessTimeout caps how long a join waits for a container on
+	// the new network to be reachable from the proxy
+	DefaultReadinessTimeout = 10 * time.Second
+
+	// readinessMinInterval and readinessMaxInterval bound the dial interval
+	readinessMinInterval = 100 * time.Millisecond
+	readinessMaxInterval = time.Second
+
+	// readinessDialTimeout is the timeout of a single dial, in seconds
+	readinessDialTimeout = 2
+)
+
+// dialFunc opens a TCP connection to addr from the proxy's network namespace.
+type dialFunc func(ctx context.Context, addr string) error
+
+// waitReachable dials addr until it succeeds or timeout elapses, doubling the
+// interval between attempts. It returns the time it took and the last error,
+// nil when addr was reached.
+func waitReachable(ctx context.Context, dial dialFunc, addr string, timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	deadline := start.Add(timeout)
+	interval := readinessMinInterval
+
+	for {
+		err := dial(ctx, addr)
+		if err == nil {
+			return time.Since(start), nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return time.Since(start), err
+		}
+
+		timer := time.NewTimer(min(interval, remaining))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return time.Since(start), ctx.Err()
+		case <-timer.C:
+		}
+		interval = min(interval*2, readinessMaxInterval)
+	}
+}
+
+// proxyDial dials from inside the proxy container with its nc, so the check
+// goes through the proxy's own network namespace and endpoints.
+func (nj *NetworkJoiner) proxyDial(containerName string) dialFunc {
+	return func(ctx context.Context, addr string) error {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+
+		// The exec calls and the dial share one deadline, so a stalled
+		// daemon cannot hang the readiness check
+		ctx, cancel := utils.WithDockerTimeout(ctx, nj.dockerTimeout)
+		defer cancel()
+
+		exec, err := nj.dockerClient.ContainerExecCreate(ctx, containerName, container.ExecOptions{
+			Cmd:          []string{"nc", "-z", "-w", strconv.Itoa(readinessDialTimeout), host, port},
+			AttachStdout: true,
+			AttachStderr: true,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create dial exec: %w", err)
+		}
+
+		resp, err := nj.dockerClient.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to start dial exec: %w", err)
+		}
+		// The attached stream ignores the context once established
+		stop := context.AfterFunc(ctx, resp.Close)
+		defer stop()
+		output, _ := io.ReadAll(resp.Reader)
+		resp.Close()
+
+		result, err := nj.dockerClient.ContainerExecInspect(ctx, exec.ID)
+		if err != nil {
+			return fmt.Errorf("failed to inspect dial exec: %w", err)
+		}
+		return dialResult(result.ExitCode, string(output))
+	}
+}
+
+// dialResult interprets the outcome of an nc dial. A refused connection
+// counts as reachable: the container answered, it just does not listen on
+// the port (yet).
+func dialResult(exitCode int, output string) error {
+	if exitCode == 0 || strings.Contains(strings.ToLower(output), "refused") {
+		return nil
+	}
+	if output = strings.TrimSpace(output); output != "" {
+		return errors.New(output)
+	}
+	return fmt.Errorf("dial exited with code %d", exitCode)
+}
+
+// sampleAddress returns the address of a running manageable container on
+// the network to dial, other than the proxy, or "" when there is none.
+// Containers are tried in ID order so the same one is picked every time.
+func (nj *NetworkJoiner) sampleAddress(ctx context.Context, containerName, networkID string) (string, error) {
+	netResource, err := utils.RetryNetworkInspect(ctx, nj.dockerClient, nj.dockerTimeout, networkID, network.InspectOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	ids := make([]string, 0, len(netResource.Containers))
+	for id, endpoint := range netResource.Containers {
+		if strings.TrimPrefix(endpoint.Name, "/") != containerName && endpoint.IPv4Address != "" {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		inspect, err := utils.RetryContainerInspect(ctx, nj.dockerClient, nj.dockerTimeout, id)
+		if err != nil || inspect.State == nil || !inspect.State.Running {
+			continue
+		}
+		if !utils.ShouldManageContainer(inspect.Config.Env, inspect.Config.Labels) {
+			continue
+		}
+		ip, _, _ := strings.Cut(netResource.Containers[id].IPv4Address, "/")
+		return endpointAddress(ip, inspect), nil
+	}
+	return "", nil
+}
+
+// endpointAddress returns the address to dial on a container: its
+// VIRTUAL_PORT, else its lowest exposed TCP port, else port 80.
+func endpointAddress(ip string, inspect types.ContainerJSON) string {
+	port := utils.GetDockerEnvVar(inspect.Config.Env, "VIRTUAL_PORT")
+	if port == "" {
+		lowest := 0
+		for p := range inspect.Config.ExposedPorts {
+			if p.Proto() == "tcp" && (lowest == 0 || p.Int() < lowest) {
+				lowest = p.Int()
+			}
+		}
+		port = "80"
+		if lowest > 0 {
+			port = strconv.Itoa(lowest)
+		}
+	}
+	return net.JoinHostPort(ip, port)
+}
+
+// checkReadiness waits until the proxy reaches a container on a network it
+// just joined: Docker reports endpoints before their data path always works.
+// The outcome is logged and exported per network.
+func (nj *NetworkJoiner) checkReadiness(ctx context.Context, containerName, networkID, netName string) {
+	addr, err := nj.sampleAddress(ctx, containerName, networkID)
+	if err != nil {
+		nj.logger.Warn("Failed to pick a container to check network reachability",
+			"name", netName, "id", utils.FormatDockerID(networkID), "error", err)
+		return
+	}
+	if addr == "" {
+		nj.logger.Debug("No container to check network reachability",
+			"name", netName, "id", utils.FormatDockerID(networkID))
+		return
+	}
+
+	elapsed, err := waitReachable(ctx, nj.proxyDial(containerName), addr, nj.readinessTimeout)
+	if err != nil {
+		nj.networkReachable.Set(0, netName)
+		nj.logger.Warn("Network not reachable from the proxy",
+			"name", netName, "id", utils.FormatDockerID(networkID),
+			"address", addr, "waited", elapsed, "error", err)
+		return
+	}
+
+	nj.networkReachable.Set(1, netName)
+	nj.networkReadySeconds.Set(elapsed.Seconds(), netName)
+	nj.logger.Debug("Network reachable from the proxy",
+		"name", netName, "id", utils.FormatDockerID(networkID),
+		"address", addr, "elapsed", elapsed)
+}
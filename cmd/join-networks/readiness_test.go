@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+func TestWaitReachable(t *testing.T) {
+	calls := 0
+	dial := func(context.Context, string) error {
+		calls++
+		if calls < 3 {
+			return errors.New("timed out")
+		}
+		return nil
+	}
+
+	if _, err := waitReachable(context.Background(), dial, "172.18.0.3:80", time.Second); err != nil {
+		t.Fatalf("waitReachable() error = %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("dials = %d, want 3", calls)
+	}
+}
+
+func TestWaitReachableTimesOut(t *testing.T) {
+	dial := func(context.Context, string) error { return errors.New("timed out") }
+
+	start := time.Now()
+	if _, err := waitReachable(context.Background(), dial, "172.18.0.3:80", 150*time.Millisecond); err == nil {
+		t.Fatal("waitReachable() should fail")
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("waited %v, expected the timeout to cap the wait", waited)
+	}
+}
+
+func TestDialResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		exitCode int
+		output   string
+		wantErr  bool
+	}{
+		{"connected", 0, "", false},
+		{"refused", 1, "nc: can't connect to remote host (172.18.0.3): Connection refused\n", false},
+		{"timed out", 1, "nc: timeout\n", true},
+		{"no output", 127, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := dialResult(tt.exitCode, tt.output); (err != nil) != tt.wantErr {
+				t.Errorf("dialResult() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEndpointAddress(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *container.Config
+		want   string
+	}{
+		{"virtual port", &container.Config{Env: []string{"VIRTUAL_PORT=3000"}, ExposedPorts: nat.PortSet{"80/tcp": {}}}, "172.18.0.3:3000"},
+		{"lowest exposed tcp", &container.Config{ExposedPorts: nat.PortSet{"9000/tcp": {}, "8080/tcp": {}, "53/udp": {}}}, "172.18.0.3:8080"},
+		{"default", &container.Config{}, "172.18.0.3:80"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := endpointAddress("172.18.0.3", types.ContainerJSON{Config: tt.config}); got != tt.want {
+				t.Errorf("endpointAddress() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
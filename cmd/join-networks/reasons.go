@@ -0,0 +1,61 @@
+package main
+
+// Rules that make the proxy join a network, recorded as the join reason
+const (
+	joinReasonDefaultBridge   = "default-bridge"
+	joinReasonSharedNamespace = "shared-namespace"
+	joinReasonManageable      = "manageable-container"
+)
+
+// joinDecision is why the proxy should be attached to a network.
+type joinDecision struct {
+	Name   string
+	Reason string
+}
+
+// joinDecisions maps the IDs of the networks the proxy should be attached to
+// to the rule that selected each of them.
+type joinDecisions map[string]joinDecision
+
+// networks returns the set of selected networks.
+func (d joinDecisions) networks() NetworkSet {
+	set := make(NetworkSet, len(d))
+	for id := range d {
+		set.Add(id)
+	}
+	return set
+}
+
+// reasons returns the join reason of each selected network by ID.
+func (d joinDecisions) reasons() map[string]string {
+	reasons := make(map[string]string, len(d))
+	for id, decision := range d {
+		reasons[id] = decision.Reason
+	}
+	return reasons
+}
+
+// recordJoinDecisions keeps the decisions of the latest scan and exports them
+// as http_proxy_join_network_reason, one series per selected network.
+func (nj *NetworkJoiner) recordJoinDecisions(decisions joinDecisions) {
+	for id, previous := range nj.joinDecisions {
+		if current, ok := decisions[id]; !ok || current != previous {
+			nj.networkReasons.Delete(previous.Name, previous.Reason)
+		}
+	}
+	for _, decision := range decisions {
+		nj.networkReasons.Set(1, decision.Name, decision.Reason)
+	}
+	nj.joinDecisions = decisions
+}
+
+// forgetJoinDecision drops a network the proxy has left from the latest
+// decisions and deletes its http_proxy_join_network_reason series.
+func (nj *NetworkJoiner) forgetJoinDecision(networkID string) {
+	decision, ok := nj.joinDecisions[networkID]
+	if !ok {
+		return
+	}
+	nj.networkReasons.Delete(decision.Name, decision.Reason)
+	delete(nj.joinDecisions, networkID)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sparkfabrik/http-proxy/pkg/metrics"
+)
+
+func TestRecordJoinDecisions(t *testing.T) {
+	registry := metrics.NewRegistry()
+	nj := &NetworkJoiner{networkReasons: registry.Gauge("reasons", "test", "network", "reason")}
+
+	nj.recordJoinDecisions(joinDecisions{
+		"bridge-id": {Name: "bridge", Reason: joinReasonDefaultBridge},
+		"app-id":    {Name: "app_default", Reason: joinReasonManageable},
+		"web-id":    {Name: "web_default", Reason: joinReasonManageable},
+	})
+	// app_default now shares a namespace and web_default is gone
+	nj.recordJoinDecisions(joinDecisions{
+		"bridge-id": {Name: "bridge", Reason: joinReasonDefaultBridge},
+		"app-id":    {Name: "app_default", Reason: joinReasonSharedNamespace},
+	})
+
+	tests := []struct {
+		network, reason string
+		want            float64
+	}{
+		{"bridge", joinReasonDefaultBridge, 1},
+		{"app_default", joinReasonSharedNamespace, 1},
+		{"app_default", joinReasonManageable, 0},
+		{"web_default", joinReasonManageable, 0},
+	}
+	for _, tt := range tests {
+		if got := nj.networkReasons.Value(tt.network, tt.reason); got != tt.want {
+			t.Errorf("reasons{%s,%s} = %v, want %v", tt.network, tt.reason, got, tt.want)
+		}
+	}
+	if nj.joinDecisions["app-id"].Reason != joinReasonSharedNamespace {
+		t.Error("latest decisions not kept")
+	}
+}
+
+func TestForgetJoinDecision(t *testing.T) {
+	registry := metrics.NewRegistry()
+	nj := &NetworkJoiner{networkReasons: registry.Gauge("reasons", "test", "network", "reason")}
+	nj.recordJoinDecisions(joinDecisions{
+		"bridge-id": {Name: "bridge", Reason: joinReasonDefaultBridge},
+		"app-id":    {Name: "app_default", Reason: joinReasonManageable},
+	})
+
+	nj.forgetJoinDecision("app-id")
+	nj.forgetJoinDecision("missing-id")
+
+	if got := nj.networkReasons.Value("app_default", joinReasonManageable); got != 0 {
+		t.Errorf("reasons{app_default} = %v, want 0", got)
+	}
+	if got := nj.networkReasons.Value("bridge", joinReasonDefaultBridge); got != 1 {
+		t.Errorf("reasons{bridge} = %v, want 1", got)
+	}
+	if _, ok := nj.joinDecisions["app-id"]; ok {
+		t.Error("left network still in the join decisions")
+	}
+}
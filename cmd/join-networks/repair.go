@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/network"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// DefaultRepairInterval is how often dangling endpoints are audited.
+const DefaultRepairInterval = 30 * time.Minute
+
+// Outcomes of a dangling endpoint, as reported and counted in metrics
+const (
+	repairRepaired = "repaired"
+	repairFailed   = "failed"
+	repairSkipped  = "skipped"
+)
+
+// errEarlierContainer explains why endpoints of earlier proxy containers are
+// reported but not disconnected.
+const errEarlierContainer = "endpoint of an earlier container with the proxy's name; " +
+	"Docker resolves the name to the running proxy, so disconnect it with " +
+	"docker network disconnect -f while the proxy is stopped"
+
+// DanglingEndpoint is an endpoint of the proxy that Docker still lists on a
+// network the proxy is not attached to, typically left behind by a crash.
+// Such endpoints make removing the network fail with "network has active
+// endpoints".
+type DanglingEndpoint struct {
+	NetworkID   string `json:"network_id"`
+	NetworkName string `json:"network_name"`
+	EndpointID  string `json:"endpoint_id"`
+	Container   string `json:"container"`
+	Result      string `json:"result,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// RepairReport is the outcome of an audit of dangling endpoints.
+type RepairReport struct {
+	DryRun   bool               `json:"dry_run"`
+	Checked  int                `json:"checked"`
+	Dangling []DanglingEndpoint `json:"dangling"`
+}
+
+// Failed reports whether any dangling endpoint could not be disconnected.
+func (r RepairReport) Failed() bool {
+	for _, ep := range r.Dangling {
+		if ep.Result == repairFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// findDanglingEndpoints returns the endpoints of the proxy on networks it is
+// not attached to according to its own inspection, and the endpoints of
+// earlier proxy containers with the same name, which Docker keeps after a
+// crash. Container is the ID the network lists the endpoint under.
+func findDanglingEndpoints(proxy *ContainerInfo, proxyName string, networks []network.Inspect) []DanglingEndpoint {
+	proxyName = strings.TrimPrefix(proxyName, "/")
+
+	var dangling []DanglingEndpoint
+	for _, net := range networks {
+		for key, endpoint := range net.Containers {
+			switch {
+			case key == proxy.ID:
+				if proxy.Networks.Contains(net.ID) {
+					continue
+				}
+			case strings.TrimPrefix(endpoint.Name, "/") == proxyName:
+			default:
+				continue
+			}
+			dangling = append(dangling, DanglingEndpoint{
+				NetworkID:   net.ID,
+				NetworkName: net.Name,
+				EndpointID:  endpoint.EndpointID,
+				Container:   key,
+			})
+		}
+	}
+
+	sort.Slice(dangling, func(i, j int) bool {
+		if dangling[i].NetworkName != dangling[j].NetworkName {
+			return dangling[i].NetworkName < dangling[j].NetworkName
+		}
+		return dangling[i].EndpointID < dangling[j].EndpointID
+	})
+	return dangling
+}
+
+// repair audits and repairs dangling endpoints, one run at a time and never
+// while a Docker event is being handled.
+func (nj *NetworkJoiner) repair(ctx context.Context) (RepairReport, error) {
+	nj.mu.Lock()
+	defer nj.mu.Unlock()
+	return nj.repairDanglingEndpoints(ctx)
+}
+
+// repairDanglingEndpoints force-disconnects the dangling endpoints of the
+// proxy, or only reports them in dry-run mode. A failed disconnect is
+// recorded in the report and does not stop the others.
+func (nj *NetworkJoiner) repairDanglingEndpoints(ctx context.Context) (RepairReport, error) {
+	report := RepairReport{DryRun: nj.dryRun}
+
+	proxy, err := nj.getContainerInfo(ctx, nj.httpProxyContainerName)
+	if err != nil {
+		return report, fmt.Errorf("failed to get container info: %w", err)
+	}
+
+	summaries, err := utils.RetryNetworkList(ctx, nj.dockerClient, nj.dockerTimeout, network.ListOptions{})
+	if err != nil {
+		return report, fmt.Errorf("failed to list networks: %w", err)
+	}
+
+	var networks []network.Inspect
+	for _, summary := range summaries {
+		net, err := utils.RetryNetworkInspect(ctx, nj.dockerClient, nj.dockerTimeout, summary.ID, network.InspectOptions{})
+		if err != nil {
+			nj.logger.Warn("Failed to inspect network for dangling endpoints",
+				"network_id", utils.FormatDockerID(summary.ID), "error", err)
+			continue
+		}
+		networks = append(networks, net)
+	}
+	report.Checked = len(networks)
+
+	report.Dangling = findDanglingEndpoints(proxy, nj.httpProxyContainerName, networks)
+	for i := range report.Dangling {
+		ep := &report.Dangling[i]
+		nj.logger.Warn("Found dangling proxy endpoint",
+			"name", ep.NetworkName,
+			"id", utils.FormatDockerID(ep.NetworkID),
+			"endpoint", utils.FormatDockerID(ep.EndpointID),
+			"dry_run", nj.dryRun)
+
+		if nj.dryRun {
+			ep.Result = repairSkipped
+		} else if ep.Container != proxy.ID {
+			// Docker only finds the endpoint of a removed container by its
+			// name, which now resolves to the running proxy
+			nj.logger.Warn("Skipping endpoint of an earlier proxy container",
+				"name", ep.NetworkName, "id", utils.FormatDockerID(ep.NetworkID))
+			ep.Result, ep.Error = repairSkipped, errEarlierContainer
+		} else if err := nj.forceDisconnect(ctx, ep); err != nil {
+			nj.logger.Error("Failed to disconnect dangling endpoint",
+				"name", ep.NetworkName, "id", utils.FormatDockerID(ep.NetworkID), "error", err)
+			ep.Result, ep.Error = repairFailed, err.Error()
+		} else {
+			nj.logger.Info("Disconnected dangling endpoint",
+				"name", ep.NetworkName, "id", utils.FormatDockerID(ep.NetworkID))
+			ep.Result = repairRepaired
+		}
+		nj.danglingEndpoints.Inc(ep.Result)
+	}
+
+	return report, nil
+}
+
+// forceDisconnect removes a dangling endpoint of the running proxy. A network
+// that is already gone counts as repaired; a "not connected" answer only does
+// when the network no longer lists the endpoint.
+func (nj *NetworkJoiner) forceDisconnect(ctx context.Context, ep *DanglingEndpoint) error {
+	err := nj.runNetworkOp(ctx, opDisconnect, ep.NetworkID, func(ctx context.Context) error {
+		return nj.dockerClient.NetworkDisconnect(ctx, ep.NetworkID, ep.Container, true)
+	})
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return nil
+	case errors.Is(err, ErrNotConnected):
+		net, inspectErr := utils.RetryNetworkInspect(ctx, nj.dockerClient, nj.dockerTimeout, ep.NetworkID, network.InspectOptions{})
+		if inspectErr != nil {
+			return fmt.Errorf("%w, and the network could not be inspected: %w", err, inspectErr)
+		}
+		if _, listed := net.Containers[ep.Container]; listed {
+			return err
+		}
+		return nil
+	default:
+		return err
+	}
+}
+
+// runRepairLoop audits dangling endpoints every interval until ctx is done.
+func (nj *NetworkJoiner) runRepairLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := nj.repair(ctx); err != nil {
+				nj.logger.Error("Failed to audit dangling endpoints", "error", err)
+			}
+		}
+	}
+}
+
+// handleRepair runs an audit on demand and answers with its report.
+func (nj *NetworkJoiner) handleRepair(w http.ResponseWriter, r *http.Request) {
+	report, err := nj.repair(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
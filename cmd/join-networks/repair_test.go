@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestFindDanglingEndpoints(t *testing.T) {
+	proxy := testContainerInfo("attached")
+	proxy.ID = "proxy-id"
+
+	networks := []network.Inspect{
+		{
+			ID:   "attached",
+			Name: "net-attached",
+			Containers: map[string]network.EndpointResource{
+				"proxy-id": {Name: "http-proxy", EndpointID: "ep-1"},
+				"app-id":   {Name: "app", EndpointID: "ep-2"},
+			},
+		},
+		{
+			ID:   "left",
+			Name: "net-left",
+			Containers: map[string]network.EndpointResource{
+				"proxy-id": {Name: "http-proxy", EndpointID: "ep-3"},
+			},
+		},
+		{
+			ID:   "crashed",
+			Name: "net-crashed",
+			Containers: map[string]network.EndpointResource{
+				"ep-4":   {Name: "http-proxy", EndpointID: "ep-4"},
+				"app-id": {Name: "app", EndpointID: "ep-5"},
+			},
+		},
+		{ID: "empty", Name: "net-empty"},
+	}
+
+	got := findDanglingEndpoints(proxy, "/http-proxy", networks)
+	want := []DanglingEndpoint{
+		{NetworkID: "crashed", NetworkName: "net-crashed", EndpointID: "ep-4", Container: "ep-4"},
+		{NetworkID: "left", NetworkName: "net-left", EndpointID: "ep-3", Container: "proxy-id"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findDanglingEndpoints() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRepairReportFailed(t *testing.T) {
+	report := RepairReport{Dangling: []DanglingEndpoint{{Result: repairRepaired}, {Result: repairSkipped}}}
+	if report.Failed() {
+		t.Error("Failed() = true without failed endpoints")
+	}
+
+	report.Dangling = append(report.Dangling, DanglingEndpoint{Result: repairFailed})
+	if !report.Failed() {
+		t.Error("Failed() = false with a failed endpoint")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+const (
+	// DefaultSettleTimeout caps how long a join waits for the new endpoint
+	DefaultSettleTimeout = 10 * time.Second
+
+	// settleMinInterval and settleMaxInterval bound the endpoint poll interval
+	settleMinInterval = 20 * time.Millisecond
+	settleMaxInterval = 500 * time.Millisecond
+
+	// settleSmoothing weights the latest settle time in the running average
+	settleSmoothing = 0.3
+)
+
+// settleTracker learns how long the Docker daemon takes to give a newly
+// joined endpoint an IP, so polling starts around the usual settle time:
+// quick daemons are polled quickly, slow ones are not hammered.
+type settleTracker struct {
+	mu      sync.Mutex
+	average time.Duration
+}
+
+// firstInterval is the delay before the first poll: half the average settle
+// time, bounded by the poll interval limits.
+func (t *settleTracker) firstInterval() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return clampDuration(t.average/2, settleMinInterval, settleMaxInterval)
+}
+
+// observe records a settle time.
+func (t *settleTracker) observe(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.average == 0 {
+		t.average = d
+		return
+	}
+	t.average = time.Duration(settleSmoothing*float64(d) + (1-settleSmoothing)*float64(t.average))
+}
+
+// Average returns the running average settle time.
+func (t *settleTracker) Average() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.average
+}
+
+func clampDuration(d, min, max time.Duration) time.Duration {
+	if d < min {
+		return min
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// endpointLookup returns the IP of the proxy's endpoint on a network, empty
+// while the daemon has not assigned one yet.
+type endpointLookup func(ctx context.Context) (string, error)
+
+// waitForEndpoint polls lookup until it reports an IP or timeout elapses,
+// doubling the interval from the tracker's first interval. It returns the
+// time the endpoint took to settle; ok is false when it never did.
+func (t *settleTracker) waitForEndpoint(ctx context.Context, lookup endpointLookup, timeout time.Duration) (elapsed time.Duration, ok bool) {
+	start := time.Now()
+	deadline := start.Add(timeout)
+	interval := t.firstInterval()
+
+	for {
+		if ip, err := lookup(ctx); err == nil && ip != "" {
+			elapsed = time.Since(start)
+			t.observe(elapsed)
+			return elapsed, true
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return time.Since(start), false
+		}
+
+		timer := time.NewTimer(min(interval, remaining))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return time.Since(start), false
+		case <-timer.C:
+		}
+		interval = min(interval*2, settleMaxInterval)
+	}
+}
+
+// proxyEndpointLookup looks up the proxy container's endpoint on networkID.
+func (nj *NetworkJoiner) proxyEndpointLookup(containerName, networkID string) endpointLookup {
+	return func(ctx context.Context) (string, error) {
+		inspect, err := utils.RetryContainerInspect(ctx, nj.dockerClient, nj.dockerTimeout, containerName)
+		if err != nil {
+			return "", err
+		}
+		if inspect.NetworkSettings == nil {
+			return "", nil
+		}
+		for _, endpoint := range inspect.NetworkSettings.Networks {
+			if endpoint.NetworkID != networkID {
+				continue
+			}
+			if endpoint.IPAddress != "" {
+				return endpoint.IPAddress, nil
+			}
+			return endpoint.GlobalIPv6Address, nil
+		}
+		return "", nil
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// lookupAfter returns a lookup reporting an IP from the given call on.
+func lookupAfter(readyCall int, calls *int) endpointLookup {
+	return func(context.Context) (string, error) {
+		*calls++
+		switch {
+		case *calls >= readyCall:
+			return "172.18.0.2", nil
+		case *calls == 1:
+			return "", errors.New("transient inspect failure")
+		default:
+			return "", nil
+		}
+	}
+}
+
+func TestWaitForEndpoint(t *testing.T) {
+	var tracker settleTracker
+	var calls int
+
+	elapsed, ok := tracker.waitForEndpoint(context.Background(), lookupAfter(3, &calls), time.Second)
+	if !ok {
+		t.Fatal("endpoint should have settled")
+	}
+	if calls != 3 {
+		t.Errorf("lookups = %d, want 3", calls)
+	}
+	if tracker.Average() != elapsed {
+		t.Errorf("average = %v, want the first observation %v", tracker.Average(), elapsed)
+	}
+}
+
+func TestWaitForEndpointTimesOut(t *testing.T) {
+	var tracker settleTracker
+	var calls int
+
+	start := time.Now()
+	if _, ok := tracker.waitForEndpoint(context.Background(), lookupAfter(1000, &calls), 100*time.Millisecond); ok {
+		t.Fatal("endpoint should not have settled")
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("waited %v, expected the timeout to cap the wait", waited)
+	}
+	if tracker.Average() != 0 {
+		t.Error("timeouts must not be recorded as settle times")
+	}
+}
+
+func TestWaitForEndpointStopsOnCancel(t *testing.T) {
+	var tracker settleTracker
+	var calls int
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := tracker.waitForEndpoint(ctx, lookupAfter(1000, &calls), time.Minute); ok {
+		t.Fatal("endpoint should not have settled")
+	}
+}
+
+func TestSettleTrackerFirstInterval(t *testing.T) {
+	var tracker settleTracker
+	if got := tracker.firstInterval(); got != settleMinInterval {
+		t.Errorf("first interval without history = %v, want %v", got, settleMinInterval)
+	}
+
+	tracker.observe(200 * time.Millisecond)
+	if got := tracker.firstInterval(); got != 100*time.Millisecond {
+		t.Errorf("first interval = %v, want 100ms", got)
+	}
+
+	// A slow daemon pulls the average up, bounded by the maximum interval
+	for i := 0; i < 20; i++ {
+		tracker.observe(5 * time.Second)
+	}
+	if got := tracker.firstInterval(); got != settleMaxInterval {
+		t.Errorf("first interval = %v, want %v", got, settleMaxInterval)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	// stateName is the state store snapshot holding the latest change
+	stateName = "join-networks"
+
+	// networksChangedEvent is the event name published to webhooks
+	networksChangedEvent = "networks.changed"
+
+	// webhookTimeout bounds each webhook delivery
+	webhookTimeout = 5 * time.Second
+)
+
+// Triggers recorded in published changes
+const (
+	triggerInitialScan    = "initial-scan"
+	triggerContainerStart = "container-start"
+	triggerContainerStop  = "container-stop"
+)
+
+// NetworkRef identifies a network in published changes. Reason is the rule
+// the proxy joined it for, when known.
+type NetworkRef struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// NetworkChangeEvent is published after the proxy joins or leaves networks,
+// and once after the initial scan, so scripts that depend on proxy
+// reachability can wait for it instead of sleeping. Generation increases with
+// every event, across restarts. Skipped lists the planned joins and leaves
+// that did not happen, with the reason.
+type NetworkChangeEvent struct {
+	Event      string           `json:"event"`
+	Generation uint64           `json:"generation"`
+	Trigger    string           `json:"trigger"`
+	Timestamp  time.Time        `json:"timestamp"`
+	Container  string           `json:"container"`
+	Joined     []NetworkRef     `json:"joined"`
+	Left       []NetworkRef     `json:"left"`
+	Skipped    []SkippedNetwork `json:"skipped"`
+	Networks   []NetworkRef     `json:"networks"`
+}
+
+// networkRefs resolves IDs to sorted references, naming them from names and
+// giving them their join reason from reasons.
+func networkRefs(ids []string, names, reasons map[string]string) []NetworkRef {
+	refs := make([]NetworkRef, 0, len(ids))
+	for _, id := range ids {
+		name := names[id]
+		if name == "" {
+			name = "unknown"
+		}
+		refs = append(refs, NetworkRef{ID: id, Name: name, Reason: reasons[id]})
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Name != refs[j].Name {
+			return refs[i].Name < refs[j].Name
+		}
+		return refs[i].ID < refs[j].ID
+	})
+	return refs
+}
+
+// skippedNetworks returns skipped changes sorted by name, never nil.
+func skippedNetworks(skipped []SkippedNetwork) []SkippedNetwork {
+	sorted := append([]SkippedNetwork{}, skipped...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Name != sorted[j].Name {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
+
+// newNetworkChangeEvent describes a completed change. before is the proxy
+// state the change was planned on and after the state once it was applied;
+// names of left networks are only known from before.
+func newNetworkChangeEvent(trigger, container string, before, after *ContainerInfo, diff networkDiff) NetworkChangeEvent {
+	names := make(map[string]string)
+	for id, name := range before.NetworkNames {
+		names[id] = name
+	}
+	for id, name := range after.NetworkNames {
+		names[id] = name
+	}
+
+	current := make([]string, 0, len(after.Networks))
+	for id := range after.Networks {
+		current = append(current, id)
+	}
+
+	return NetworkChangeEvent{
+		Event:     networksChangedEvent,
+		Trigger:   trigger,
+		Timestamp: time.Now().UTC(),
+		Container: container,
+		Joined:    networkRefs(diff.joined, names, diff.reasons),
+		Left:      networkRefs(diff.left, names, nil),
+		Skipped:   skippedNetworks(diff.skipped),
+		Networks:  networkRefs(current, names, diff.reasons),
+	}
+}
+
+// publishChange records a completed change in the state store and posts it to
+// the configured webhooks, and logs and counts its membership diff. Nothing
+// is published when no network was joined or left, except after the initial
+// scan. Failures are logged and never fail the operation.
+func (nj *NetworkJoiner) publishChange(ctx context.Context, trigger string, before *ContainerInfo, diff networkDiff) {
+	if !diff.changed() && trigger != triggerInitialScan {
+		nj.recordDiff(trigger, NetworkChangeEvent{Skipped: skippedNetworks(diff.skipped)})
+		return
+	}
+
+	after, err := nj.getContainerInfo(ctx, nj.httpProxyContainerName)
+	if err != nil {
+		nj.logger.Warn("Failed to inspect proxy after network change, not publishing it", "error", err)
+		return
+	}
+
+	event := newNetworkChangeEvent(trigger, nj.httpProxyContainerName, before, after, diff)
+	event.Generation = nj.nextGeneration()
+	nj.recordDiff(trigger, event)
+
+	if nj.state != nil {
+		if err := nj.state.Write(stateName, event); err != nil {
+			nj.logger.Warn("Failed to write network state", "error", err)
+		}
+	}
+
+	for _, url := range nj.webhookURLs {
+		if err := postWebhook(ctx, url, event); err != nil {
+			nj.logger.Warn("Failed to deliver network change webhook", "url", url, "error", err)
+			continue
+		}
+		nj.logger.Debug("Delivered network change webhook", "url", url, "generation", event.Generation)
+	}
+
+	nj.logger.Info("Published network change",
+		"trigger", trigger,
+		"generation", event.Generation,
+		"joined", len(event.Joined),
+		"left", len(event.Left),
+		"skipped", len(event.Skipped))
+}
+
+// nextGeneration returns the next event generation, continuing from the last
+// published event when the state store has one.
+func (nj *NetworkJoiner) nextGeneration() uint64 {
+	if nj.generation == 0 && nj.state != nil {
+		var previous NetworkChangeEvent
+		if err := nj.state.Read(stateName, &previous); err == nil {
+			nj.generation = previous.Generation
+		} else if !errors.Is(err, os.ErrNotExist) {
+			nj.logger.Debug("Ignoring unreadable network state", "error", err)
+		}
+	}
+	nj.generation++
+	return nj.generation
+}
+
+// postWebhook delivers an event as a JSON POST; any non-2xx status is an error.
+func postWebhook(ctx context.Context, url string, event NetworkChangeEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-HTTP-Proxy-Event", event.Event)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
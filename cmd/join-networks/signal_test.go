@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+	"github.com/sparkfabrik/http-proxy/pkg/state"
+)
+
+func TestNewNetworkChangeEvent(t *testing.T) {
+	before := &ContainerInfo{
+		Networks:     NetworkSet{"bridge-id": true, "old-id": true},
+		NetworkNames: map[string]string{"bridge-id": "bridge", "old-id": "old_default"},
+	}
+	after := &ContainerInfo{
+		Networks:     NetworkSet{"bridge-id": true, "app-id": true},
+		NetworkNames: map[string]string{"bridge-id": "bridge", "app-id": "app_default"},
+	}
+
+	event := newNetworkChangeEvent(triggerContainerStart, "http-proxy", before, after, networkDiff{
+		joined:  []string{"app-id"},
+		left:    []string{"old-id"},
+		skipped: []SkippedNetwork{{ID: "db-id", Name: "db_default", Operation: diffOpLeave, Reason: string(VerdictBlockedPortBindings)}},
+		reasons: map[string]string{"app-id": joinReasonManageable, "bridge-id": joinReasonDefaultBridge},
+	})
+
+	if event.Event != networksChangedEvent || event.Trigger != triggerContainerStart || event.Container != "http-proxy" {
+		t.Errorf("unexpected header: %+v", event)
+	}
+	if len(event.Joined) != 1 || event.Joined[0] != (NetworkRef{ID: "app-id", Name: "app_default", Reason: joinReasonManageable}) {
+		t.Errorf("joined = %+v", event.Joined)
+	}
+	// The left network is only named in the state before the change
+	if len(event.Left) != 1 || event.Left[0] != (NetworkRef{ID: "old-id", Name: "old_default"}) {
+		t.Errorf("left = %+v", event.Left)
+	}
+	if len(event.Skipped) != 1 || event.Skipped[0].Reason != string(VerdictBlockedPortBindings) {
+		t.Errorf("skipped = %+v", event.Skipped)
+	}
+	want := []NetworkRef{
+		{ID: "app-id", Name: "app_default", Reason: joinReasonManageable},
+		{ID: "bridge-id", Name: "bridge", Reason: joinReasonDefaultBridge},
+	}
+	if len(event.Networks) != 2 || event.Networks[0] != want[0] || event.Networks[1] != want[1] {
+		t.Errorf("networks = %+v, want %+v", event.Networks, want)
+	}
+}
+
+func TestNextGenerationContinuesFromState(t *testing.T) {
+	store := state.NewStore(t.TempDir())
+	if err := store.Write(stateName, NetworkChangeEvent{Generation: 41}); err != nil {
+		t.Fatal(err)
+	}
+
+	nj := &NetworkJoiner{state: store, logger: logger.New("test")}
+	if got := nj.nextGeneration(); got != 42 {
+		t.Errorf("first generation = %d, want 42", got)
+	}
+	if got := nj.nextGeneration(); got != 43 {
+		t.Errorf("second generation = %d, want 43", got)
+	}
+
+	fresh := &NetworkJoiner{state: state.NewStore(t.TempDir()), logger: logger.New("test")}
+	if got := fresh.nextGeneration(); got != 1 {
+		t.Errorf("generation without state = %d, want 1", got)
+	}
+}
+
+func TestPostWebhook(t *testing.T) {
+	var received NetworkChangeEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("X-HTTP-Proxy-Event") != networksChangedEvent {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	event := NetworkChangeEvent{Event: networksChangedEvent, Generation: 7}
+	if err := postWebhook(context.Background(), srv.URL, event); err != nil {
+		t.Fatalf("postWebhook: %v", err)
+	}
+	if received.Generation != 7 {
+		t.Errorf("received generation %d, want 7", received.Generation)
+	}
+}
+
+func TestPostWebhookRejectsErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	if err := postWebhook(context.Background(), srv.URL, NetworkChangeEvent{}); err == nil {
+		t.Error("expected an error for a 500 response")
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	got := splitList(" http://a/hook , ,http://b/hook,")
+	if len(got) != 2 || got[0] != "http://a/hook" || got[1] != "http://b/hook" {
+		t.Errorf("splitList = %v", got)
+	}
+	if splitList("") != nil {
+		t.Error("expected nil for an empty value")
+	}
+}
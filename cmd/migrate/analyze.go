@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Support describes how http-proxy handles a dinghy/nginx-proxy feature.
+type Support string
+
+const (
+	// SupportFull features work unchanged
+	SupportFull Support = "supported"
+
+	// SupportEquivalent features are replaced by the generated configuration
+	SupportEquivalent Support = "equivalent"
+
+	// SupportNone features have no http-proxy counterpart and need manual work
+	SupportNone Support = "unsupported"
+)
+
+// featureInfo describes a known feature and how to migrate it.
+type featureInfo struct {
+	support Support
+	note    string
+}
+
+// containerEnvFeatures are the per-application variables read by
+// nginx-proxy, dinghy-http-proxy and their companions.
+var containerEnvFeatures = map[string]featureInfo{
+	"VIRTUAL_HOST":        {SupportFull, "routed by dinghy-layer, HTTP and HTTPS"},
+	"VIRTUAL_PORT":        {SupportFull, "used as the backend port"},
+	"VIRTUAL_NETWORK":     {SupportEquivalent, "not needed: join-networks connects the proxy to application networks automatically"},
+	"CERT_NAME":           {SupportFull, "the named certificate is loaded from ~/.local/spark/http-proxy/certs; copy it there or generate it with the commands below"},
+	"VIRTUAL_PROTO":       {SupportFull, "http, https, h2c, grpc and grpcs are supported; https backend certificates are not verified unless HTTP_PROXY_BACKEND_SKIP_VERIFY=false"},
+	"VIRTUAL_PATH":        {SupportFull, "routed by dinghy-layer with a PathPrefix rule"},
+	"VIRTUAL_DEST":        {SupportFull, "the path prefix is rewritten by a stripPrefix or replacePathRegex middleware"},
+	"HTTPS_METHOD":        {SupportFull, "redirect, noredirect, nohttp and nohttps are translated into routers and a redirectScheme middleware"},
+	"VIRTUAL_HOST_WEIGHT": {SupportFull, "added to the priority of the container's routers"},
+	"HSTS":                {SupportEquivalent, "HSTS is stripped by the HTTPS entrypoint; HTTP_PROXY_SECURITY_HEADERS=strict sends the other production security headers"},
+	"SSL_POLICY":          {SupportNone, "TLS options need a Traefik dynamic file"},
+	"NETWORK_ACCESS":      {SupportFull, "internal becomes an ipAllowList middleware admitting loopback and private addresses"},
+	"LETSENCRYPT_HOST":    {SupportNone, "local certificates come from mkcert; generate them with the commands below"},
+	"LETSENCRYPT_EMAIL":   {SupportNone, "not needed with mkcert certificates"},
+}
+
+// proxyEnvFeatures are settings of the proxy container itself.
+var proxyEnvFeatures = map[string]featureInfo{
+	"DOMAIN_TLD":   {SupportEquivalent, "becomes HTTP_PROXY_DNS_TLDS"},
+	"DNS_IP":       {SupportEquivalent, "becomes HTTP_PROXY_DNS_TARGET_IP"},
+	"DEFAULT_HOST": {SupportNone, "configure a catch-all router with Traefik labels"},
+}
+
+// proxyMountFeatures are proxy container paths used to customize nginx.
+var proxyMountFeatures = map[string]featureInfo{
+	"/etc/nginx/vhost.d":        {SupportNone, "per-vhost nginx snippets; translate them into Traefik middlewares (see TRAEFIK_CONFIG_TEMPLATE)"},
+	"/etc/nginx/conf.d":         {SupportNone, "custom nginx config; translate it into Traefik dynamic configuration"},
+	"/app/nginx.tmpl":           {SupportNone, "custom vhost template; use TRAEFIK_CONFIG_TEMPLATE for the generated config"},
+	"/etc/docker-gen/templates": {SupportNone, "custom docker-gen template; use TRAEFIK_CONFIG_TEMPLATE for the generated config"},
+	"/etc/nginx/htpasswd":       {SupportEquivalent, "set the htpasswd entries of each host in HTTP_PROXY_BASIC_AUTH on its container"},
+	"/etc/nginx/certs":          {SupportEquivalent, "copy the certificates to ~/.local/spark/http-proxy/certs"},
+}
+
+// proxyImages identify the proxy containers http-proxy replaces.
+var proxyImages = []string{"nginx-proxy", "dinghy-http-proxy", "docker-gen"}
+
+// Finding is one feature used by one workload.
+type Finding struct {
+	Source   string  `json:"source"`
+	Workload string  `json:"workload"`
+	Feature  string  `json:"feature"`
+	Value    string  `json:"value,omitempty"`
+	Support  Support `json:"support"`
+	Note     string  `json:"note"`
+}
+
+// Report is the result of a migration analysis.
+type Report struct {
+	Findings []Finding `json:"findings"`
+
+	// ProxyWorkloads are nginx-proxy/dinghy containers to remove
+	ProxyWorkloads []string `json:"proxy_workloads,omitempty"`
+
+	// Env is the http-proxy environment equivalent to the proxy settings
+	Env map[string]string `json:"env,omitempty"`
+
+	// CertDomains need certificates generated with generate-mkcert
+	CertDomains []string `json:"cert_domains,omitempty"`
+}
+
+// Unsupported returns the findings that need manual work.
+func (r *Report) Unsupported() []Finding {
+	var result []Finding
+	for _, f := range r.Findings {
+		if f.Support == SupportNone {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// isProxyImage reports whether image is an nginx-proxy/dinghy proxy image.
+func isProxyImage(image string) bool {
+	for _, name := range proxyImages {
+		if strings.Contains(image, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// virtualHostNames splits a VIRTUAL_HOST value into hostnames without ports.
+func virtualHostNames(value string) []string {
+	var hosts []string
+	for _, entry := range strings.Split(value, ",") {
+		host, _, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// hostTLD returns the last label of a hostname, or "" for regex hosts.
+func hostTLD(host string) string {
+	if strings.HasPrefix(host, "~") {
+		return ""
+	}
+	labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+	if len(labels) < 2 {
+		return ""
+	}
+	return labels[len(labels)-1]
+}
+
+// analyze reports the features each workload uses and derives the equivalent
+// http-proxy configuration.
+func analyze(workloads []Workload) *Report {
+	report := &Report{Env: make(map[string]string)}
+	tlds := make(map[string]bool)
+	certDomains := make(map[string]bool)
+
+	add := func(w Workload, feature, value string, info featureInfo) {
+		report.Findings = append(report.Findings, Finding{
+			Source:   w.Source,
+			Workload: w.Name,
+			Feature:  feature,
+			Value:    value,
+			Support:  info.support,
+			Note:     info.note,
+		})
+	}
+
+	for _, w := range workloads {
+		if isProxyImage(w.Image) {
+			report.ProxyWorkloads = append(report.ProxyWorkloads, fmt.Sprintf("%s (%s)", w.Name, w.Image))
+
+			for _, key := range sortedKeys(w.Env) {
+				if info, ok := proxyEnvFeatures[key]; ok {
+					add(w, key, w.Env[key], info)
+				}
+			}
+			for _, mount := range w.Mounts {
+				if info, ok := proxyMountFeatures[mount]; ok {
+					add(w, "mount "+mount, "", info)
+				}
+			}
+
+			if tld := w.Env["DOMAIN_TLD"]; tld != "" {
+				tlds[tld] = true
+			}
+			if ip := w.Env["DNS_IP"]; ip != "" {
+				report.Env["HTTP_PROXY_DNS_TARGET_IP"] = ip
+			}
+			continue
+		}
+
+		for _, key := range sortedKeys(w.Env) {
+			if info, ok := containerEnvFeatures[key]; ok {
+				add(w, key, w.Env[key], info)
+			}
+		}
+
+		hosts := virtualHostNames(w.Env["VIRTUAL_HOST"])
+		for _, host := range hosts {
+			if tld := hostTLD(host); tld != "" {
+				tlds[tld] = true
+			}
+		}
+
+		// CERT_NAME and LETSENCRYPT_HOST both ask for a certificate on the hosts
+		if w.Env["CERT_NAME"] != "" {
+			for _, host := range hosts {
+				if !strings.HasPrefix(host, "~") {
+					certDomains[host] = true
+				}
+			}
+		}
+		for _, host := range virtualHostNames(w.Env["LETSENCRYPT_HOST"]) {
+			certDomains[host] = true
+		}
+	}
+
+	if len(tlds) > 0 {
+		report.Env["HTTP_PROXY_DNS_TLDS"] = strings.Join(sortedSet(tlds), ",")
+	}
+	report.CertDomains = sortedSet(certDomains)
+	return report
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedSet(set map[string]bool) []string {
+	result := make([]string, 0, len(set))
+	for key := range set {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
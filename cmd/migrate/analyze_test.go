@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	workloads, err := parseComposeFile("compose.yml", []byte(dinghyCompose))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := analyze(workloads)
+
+	if len(report.ProxyWorkloads) != 1 || !strings.HasPrefix(report.ProxyWorkloads[0], "proxy ") {
+		t.Errorf("proxy workloads = %v", report.ProxyWorkloads)
+	}
+
+	wantEnv := map[string]string{
+		"HTTP_PROXY_DNS_TLDS":      "docker",
+		"HTTP_PROXY_DNS_TARGET_IP": "10.0.0.1",
+	}
+	if !reflect.DeepEqual(report.Env, wantEnv) {
+		t.Errorf("env = %v, want %v", report.Env, wantEnv)
+	}
+
+	if want := []string{"web.docker", "www.web.docker"}; !reflect.DeepEqual(report.CertDomains, want) {
+		t.Errorf("cert domains = %v, want %v", report.CertDomains, want)
+	}
+
+	supports := make(map[string]Support)
+	for _, f := range report.Findings {
+		supports[f.Workload+" "+f.Feature] = f.Support
+	}
+	want := map[string]Support{
+		"proxy DNS_IP":                   SupportEquivalent,
+		"proxy DOMAIN_TLD":               SupportEquivalent,
+		"proxy mount /etc/nginx/vhost.d": SupportNone,
+		"proxy mount /etc/nginx/certs":   SupportEquivalent,
+		"web CERT_NAME":                  SupportFull,
+		"web HTTPS_METHOD":               SupportFull,
+		"web VIRTUAL_HOST":               SupportFull,
+	}
+	if !reflect.DeepEqual(supports, want) {
+		t.Errorf("findings = %v, want %v", supports, want)
+	}
+
+	if got := len(report.Unsupported()); got != 1 {
+		t.Errorf("unsupported = %d, want 1", got)
+	}
+}
+
+func TestAnalyzeIgnoresUnrelatedWorkloads(t *testing.T) {
+	report := analyze([]Workload{{Name: "db", Image: "postgres", Env: map[string]string{"POSTGRES_PASSWORD": "x"}}})
+	if len(report.Findings) != 0 || len(report.Env) != 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
+
+func TestHostTLD(t *testing.T) {
+	tests := map[string]string{
+		"app.loc":      "loc",
+		"api.app.dev.": "dev",
+		"*.app.docker": "docker",
+		"localhost":    "",
+		"~^api\\.loc$": "",
+	}
+	for host, want := range tests {
+		if got := hostTLD(host); got != want {
+			t.Errorf("hostTLD(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestWriteTextReport(t *testing.T) {
+	workloads, err := parseComposeFile("compose.yml", []byte(dinghyCompose))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	writeTextReport(&out, len(workloads), analyze(workloads))
+
+	for _, want := range []string{
+		"export HTTP_PROXY_DNS_TLDS=docker",
+		`spark-http-proxy generate-mkcert "web.docker"`,
+		"Needs manual migration (1):",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, out.String())
+		}
+	}
+}
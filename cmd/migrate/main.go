@@ -0,0 +1,126 @@
+// Package main implements a migration helper for projects moving from
+// nginx-proxy or dinghy-http-proxy. It scans compose files and/or running
+// containers, reports which proxy features they use, prints the equivalent
+// http-proxy configuration and flags what needs manual work.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/docker/docker/client"
+)
+
+func main() {
+	containers := flag.Bool("containers", false, "scan running containers in addition to the given compose files")
+	format := flag.String("format", "text", "output format (text, json)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-containers] [-format text|json] [compose-file ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 && !*containers {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Invalid format %q, must be text or json\n", *format)
+		os.Exit(2)
+	}
+
+	workloads, err := scanComposeFiles(flag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Scan failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *containers {
+		dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create Docker client: %v\n", err)
+			os.Exit(1)
+		}
+		defer dockerClient.Close()
+
+		running, err := scanContainers(context.Background(), dockerClient)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Scan failed: %v\n", err)
+			os.Exit(1)
+		}
+		workloads = append(workloads, running...)
+	}
+
+	report := analyze(workloads)
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	writeTextReport(os.Stdout, len(workloads), report)
+}
+
+// writeTextReport prints the report for humans.
+func writeTextReport(out io.Writer, scanned int, report *Report) {
+	fmt.Fprintf(out, "Scanned %d workloads\n", scanned)
+
+	if len(report.Findings) == 0 {
+		fmt.Fprintln(out, "\nNo nginx-proxy or dinghy features found; nothing to migrate.")
+		return
+	}
+
+	fmt.Fprintln(out, "\nFeatures in use:")
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, f := range report.Findings {
+		feature := f.Feature
+		if f.Value != "" {
+			feature += "=" + f.Value
+		}
+		fmt.Fprintf(tw, "  [%s]\t%s (%s)\t%s\t%s\n", f.Support, f.Workload, f.Source, feature, f.Note)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(out, "\nEquivalent http-proxy configuration:")
+	if len(report.ProxyWorkloads) > 0 {
+		fmt.Fprintln(out, "  # Remove these proxy services; http-proxy replaces them:")
+		for _, name := range report.ProxyWorkloads {
+			fmt.Fprintf(out, "  #   %s\n", name)
+		}
+	}
+
+	keys := make([]string, 0, len(report.Env))
+	for key := range report.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(out, "  export %s=%s\n", key, report.Env[key])
+	}
+	fmt.Fprintln(out, "  spark-http-proxy start")
+	for _, domain := range report.CertDomains {
+		fmt.Fprintf(out, "  spark-http-proxy generate-mkcert %q\n", domain)
+	}
+
+	unsupported := report.Unsupported()
+	if len(unsupported) == 0 {
+		fmt.Fprintln(out, "\nAll features in use have an http-proxy equivalent.")
+		return
+	}
+
+	fmt.Fprintf(out, "\nNeeds manual migration (%d):\n", len(unsupported))
+	for _, f := range unsupported {
+		fmt.Fprintf(out, "  - %s (%s): %s: %s\n", f.Workload, f.Source, f.Feature, f.Note)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// Workload is a compose service or running container to analyze.
+type Workload struct {
+	Source string            `json:"source"`
+	Name   string            `json:"name"`
+	Image  string            `json:"image,omitempty"`
+	Env    map[string]string `json:"env,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Mounts are the container-side targets of volumes and bind mounts
+	Mounts []string `json:"mounts,omitempty"`
+}
+
+// composeFile is the subset of a compose file the migration needs.
+type composeFile struct {
+	Services map[string]composeService `yaml:"services"`
+}
+
+// composeService accepts both the list and map forms compose allows for
+// environment and labels, and both volume syntaxes.
+type composeService struct {
+	Image       string      `yaml:"image"`
+	Environment listOrMap   `yaml:"environment"`
+	Labels      listOrMap   `yaml:"labels"`
+	Volumes     []yaml.Node `yaml:"volumes"`
+}
+
+// listOrMap decodes `["KEY=value"]` and `{KEY: value}` into the same map.
+type listOrMap map[string]string
+
+func (m *listOrMap) UnmarshalYAML(node *yaml.Node) error {
+	result := make(listOrMap)
+
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var items []string
+		if err := node.Decode(&items); err != nil {
+			return err
+		}
+		for _, item := range items {
+			key, value, _ := strings.Cut(item, "=")
+			result[strings.TrimSpace(key)] = value
+		}
+	case yaml.MappingNode:
+		var items map[string]interface{}
+		if err := node.Decode(&items); err != nil {
+			return err
+		}
+		for key, value := range items {
+			if value == nil {
+				result[key] = ""
+			} else {
+				result[key] = fmt.Sprint(value)
+			}
+		}
+	default:
+		return fmt.Errorf("expected a list or map, got %q", node.Value)
+	}
+
+	*m = result
+	return nil
+}
+
+// volumeTarget returns the container path of a short ("src:dst[:mode]") or
+// long ({target: dst}) volume entry.
+func volumeTarget(node yaml.Node) string {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		parts := strings.Split(node.Value, ":")
+		if len(parts) == 1 {
+			return parts[0]
+		}
+		return parts[1]
+	case yaml.MappingNode:
+		var long struct {
+			Target string `yaml:"target"`
+		}
+		if err := node.Decode(&long); err == nil {
+			return long.Target
+		}
+	}
+	return ""
+}
+
+// parseComposeFile returns one workload per service of a compose file,
+// sorted by service name.
+func parseComposeFile(path string, data []byte) ([]Workload, error) {
+	var file composeFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse compose file %s: %w", path, err)
+	}
+
+	names := make([]string, 0, len(file.Services))
+	for name := range file.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	workloads := make([]Workload, 0, len(names))
+	for _, name := range names {
+		svc := file.Services[name]
+		w := Workload{
+			Source: path,
+			Name:   name,
+			Image:  svc.Image,
+			Env:    svc.Environment,
+			Labels: svc.Labels,
+		}
+		for _, volume := range svc.Volumes {
+			if target := volumeTarget(volume); target != "" {
+				w.Mounts = append(w.Mounts, target)
+			}
+		}
+		workloads = append(workloads, w)
+	}
+	return workloads, nil
+}
+
+// scanComposeFiles reads and parses each compose file.
+func scanComposeFiles(paths []string) ([]Workload, error) {
+	var workloads []Workload
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read compose file: %w", err)
+		}
+		parsed, err := parseComposeFile(path, data)
+		if err != nil {
+			return nil, err
+		}
+		workloads = append(workloads, parsed...)
+	}
+	return workloads, nil
+}
+
+// scanContainers returns one workload per running container.
+func scanContainers(ctx context.Context, dockerClient *client.Client) ([]Workload, error) {
+	containers, err := utils.RetryContainerList(ctx, dockerClient, utils.DefaultDockerCallTimeout, container.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	var workloads []Workload
+	for _, c := range containers {
+		inspect, err := utils.RetryContainerInspect(ctx, dockerClient, utils.DefaultDockerCallTimeout, c.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect container %s: %w", utils.FormatDockerID(c.ID), err)
+		}
+
+		w := Workload{
+			Source: "docker",
+			Name:   strings.TrimPrefix(inspect.Name, "/"),
+			Env:    make(map[string]string),
+		}
+		if inspect.Config != nil {
+			w.Image = inspect.Config.Image
+			w.Labels = inspect.Config.Labels
+			for _, env := range inspect.Config.Env {
+				key, value, _ := strings.Cut(env, "=")
+				w.Env[key] = value
+			}
+		}
+		for _, mount := range inspect.Mounts {
+			w.Mounts = append(w.Mounts, mount.Destination)
+		}
+		workloads = append(workloads, w)
+	}
+
+	sort.Slice(workloads, func(i, j int) bool { return workloads[i].Name < workloads[j].Name })
+	return workloads, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const dinghyCompose = `services:
+  proxy:
+    image: codekitchen/dinghy-http-proxy:latest
+    environment:
+      DOMAIN_TLD: docker
+      DNS_IP: 10.0.0.1
+    volumes:
+      - /var/run/docker.sock:/tmp/docker.sock:ro
+      - ./vhost.d:/etc/nginx/vhost.d:ro
+      - type: bind
+        source: ./certs
+        target: /etc/nginx/certs
+  web:
+    image: nginx
+    environment:
+      - VIRTUAL_HOST=web.docker,www.web.docker:8080
+      - CERT_NAME=shared
+      - HTTPS_METHOD=redirect
+    labels:
+      com.example.team: web
+`
+
+func TestParseComposeFile(t *testing.T) {
+	workloads, err := parseComposeFile("compose.yml", []byte(dinghyCompose))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(workloads) != 2 {
+		t.Fatalf("workloads = %d, want 2", len(workloads))
+	}
+
+	proxy, web := workloads[0], workloads[1]
+	if proxy.Name != "proxy" || proxy.Env["DOMAIN_TLD"] != "docker" {
+		t.Errorf("unexpected proxy workload: %+v", proxy)
+	}
+	wantMounts := []string{"/tmp/docker.sock", "/etc/nginx/vhost.d", "/etc/nginx/certs"}
+	if !reflect.DeepEqual(proxy.Mounts, wantMounts) {
+		t.Errorf("mounts = %v, want %v", proxy.Mounts, wantMounts)
+	}
+
+	if web.Env["VIRTUAL_HOST"] != "web.docker,www.web.docker:8080" || web.Env["CERT_NAME"] != "shared" {
+		t.Errorf("unexpected web env: %v", web.Env)
+	}
+	if web.Labels["com.example.team"] != "web" {
+		t.Errorf("unexpected web labels: %v", web.Labels)
+	}
+}
+
+func TestParseComposeFileInvalid(t *testing.T) {
+	if _, err := parseComposeFile("bad.yml", []byte("services:\n  web:\n    environment: 3\n")); err == nil {
+		t.Error("expected an error for a scalar environment")
+	}
+}
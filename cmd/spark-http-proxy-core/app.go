@@ -0,0 +1,205 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	proxyclient "github.com/sparkfabrik/http-proxy/pkg/client"
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+const (
+	// defaultProject is the compose project name of the proxy stack
+	defaultProject = "http-proxy"
+
+	// adminTimeout bounds a single admin API request
+	adminTimeout = 5 * time.Second
+)
+
+// app holds the settings and clients shared by the commands.
+type app struct {
+	format      string
+	composeFile string
+	project     string
+	adminURL    string
+	configDir   string
+	certDir     string
+	profilesDir string
+
+	// shippedComposeFile is the compose file installed next to the binary
+	shippedComposeFile string
+
+	docker *client.Client
+	http   *http.Client
+}
+
+// newApp reads the settings from the environment the shell script exports
+// (COMPOSE_FILE, COMPOSE_PROJECT_NAME), falling back to the script's defaults.
+func newApp() *app {
+	home, _ := os.UserHomeDir()
+	configDir := filepath.Join(home, ".local", "spark", "http-proxy")
+
+	executable, _ := os.Executable()
+	binDir := filepath.Dir(executable)
+	composeFile := os.Getenv("COMPOSE_FILE")
+	if composeFile == "" {
+		composeFile = discoverComposeFile(configDir, binDir)
+	}
+
+	return &app{
+		format:      formatText,
+		composeFile: composeFile,
+		project:     config.GetEnvOrDefault("COMPOSE_PROJECT_NAME", defaultProject),
+		adminURL:    config.GetEnvOrDefault("HTTP_PROXY_ADMIN_URL", proxyclient.DefaultAdminURL),
+		configDir:   configDir,
+		certDir:     filepath.Join(configDir, "certs"),
+		profilesDir: config.GetEnvOrDefault("HTTP_PROXY_PROFILES_HOST_DIR", filepath.Join(configDir, "profiles")),
+		http:        &http.Client{Timeout: adminTimeout},
+
+		shippedComposeFile: filepath.Join(binDir, "compose.yml"),
+	}
+}
+
+// discoverComposeFile returns the user override in configDir when it exists,
+// else the compose file shipped next to the binary.
+func discoverComposeFile(configDir, binDir string) string {
+	override := filepath.Join(configDir, "compose.yml")
+	if _, err := os.Stat(override); err == nil {
+		return override
+	}
+	return filepath.Join(binDir, "compose.yml")
+}
+
+// composeFileSource describes where the compose file in use comes from.
+func (a *app) composeFileSource() string {
+	switch a.composeFile {
+	case filepath.Join(a.configDir, "compose.yml"):
+		return "user override"
+	case a.shippedComposeFile:
+		return "default"
+	default:
+		return "custom"
+	}
+}
+
+// dockerClient returns the Docker client, connecting on first use.
+func (a *app) dockerClient() (*client.Client, error) {
+	if a.docker != nil {
+		return a.docker, nil
+	}
+	docker, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	a.docker = docker
+	return docker, nil
+}
+
+// close releases the Docker client.
+func (a *app) close() {
+	if a.docker != nil {
+		a.docker.Close()
+		a.docker = nil
+	}
+}
+
+// projectContainers lists the containers of the proxy stack, stopped ones
+// included.
+func (a *app) projectContainers(ctx context.Context) ([]container.Summary, error) {
+	docker, err := a.dockerClient()
+	if err != nil {
+		return nil, err
+	}
+	containers, err := docker.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", utils.ComposeProjectLabel+"="+a.project)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	return containers, nil
+}
+
+// serviceContainer returns the container of a compose service, or nil.
+func serviceContainer(containers []container.Summary, name string) *container.Summary {
+	for i := range containers {
+		if utils.ComposeService(containers[i].Labels) == name {
+			return &containers[i]
+		}
+	}
+	return nil
+}
+
+// containerName returns the name of a container without the leading slash.
+func containerName(c *container.Summary) string {
+	if len(c.Names) == 0 {
+		return c.ID
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+// publishedPort returns the host port a container publishes privatePort on,
+// or 0.
+func publishedPort(c *container.Summary, privatePort uint16) uint16 {
+	if c == nil {
+		return 0
+	}
+	for _, port := range c.Ports {
+		if port.PrivatePort == privatePort && port.PublicPort != 0 {
+			return port.PublicPort
+		}
+	}
+	return 0
+}
+
+// readContainerFile reads a file from a container, running or not.
+func (a *app) readContainerFile(ctx context.Context, containerID, path string) ([]byte, error) {
+	docker, err := a.dockerClient()
+	if err != nil {
+		return nil, err
+	}
+	archive, _, err := docker.CopyFromContainer(ctx, containerID, path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	return readTarFile(archive)
+}
+
+// readTarFile returns the content of the first regular file of a tar stream,
+// as returned by the Docker copy API.
+func readTarFile(r io.Reader) ([]byte, error) {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no file in archive")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// adminClient returns a client for the admin API at adminURL.
+func (a *app) adminClient() *proxyclient.Client {
+	c := proxyclient.New(a.adminURL, "")
+	if a.http != nil {
+		c.HTTPClient = a.http
+	}
+	return c
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/spf13/cobra"
+)
+
+// configInfo is the configuration in use and where it comes from.
+type configInfo struct {
+	ComposeFile       string `json:"compose_file"`
+	ComposeFileSource string `json:"compose_file_source"`
+	Project           string `json:"project"`
+	ConfigDir         string `json:"config_dir"`
+	CertDir           string `json:"cert_dir"`
+	AdminURL          string `json:"admin_url"`
+}
+
+// logServices are the services offered for completion by the logs command.
+var logServices = []string{"traefik", "dinghy_layer", "join_networks", "dns", "prometheus", "grafana"}
+
+func newShowConfigCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "show-config",
+		Short: "Show current configuration and file locations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			info := configInfo{
+				ComposeFile:       a.composeFile,
+				ComposeFileSource: a.composeFileSource(),
+				Project:           a.project,
+				ConfigDir:         a.configDir,
+				CertDir:           a.certDir,
+				AdminURL:          a.adminURL,
+			}
+			if a.format == formatJSON {
+				return writeJSON(cmd.OutOrStdout(), info)
+			}
+			printConfig(cmd.OutOrStdout(), info)
+			return nil
+		},
+	}
+}
+
+// printConfig prints the configuration the way the shell script did.
+func printConfig(w io.Writer, info configInfo) {
+	fmt.Fprintln(w, "Current configuration:")
+	fmt.Fprintf(w, "  Compose file: %s\n", info.ComposeFile)
+	fmt.Fprintf(w, "  Compose project: %s\n", info.Project)
+	fmt.Fprintf(w, "  Config directory: %s\n", info.ConfigDir)
+	fmt.Fprintf(w, "  Certificate directory: %s\n", info.CertDir)
+	fmt.Fprintf(w, "  Admin API: %s\n", info.AdminURL)
+	fmt.Fprintln(w)
+	switch info.ComposeFileSource {
+	case "user override":
+		logSuccess(w, "Using user override configuration")
+	case "default":
+		logSuccess(w, "Using default configuration (shipped with script)")
+	default:
+		logInfo(w, "Using custom configuration: "+info.ComposeFile)
+	}
+}
+
+func newLogsCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:       "logs [service]",
+		Short:     "Show logs (optionally for specific service)",
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: logServices,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			composeArgs := []string{"compose", "-f", a.composeFile, "-p", a.project, "logs", "-f"}
+			if len(args) == 1 {
+				logInfo(cmd.ErrOrStderr(), "Showing logs for service: "+args[0])
+				composeArgs = append(composeArgs, args[0])
+			} else {
+				logInfo(cmd.ErrOrStderr(), "Showing logs for all services")
+			}
+
+			docker := exec.CommandContext(cmd.Context(), "docker", composeArgs...)
+			docker.Stdin, docker.Stdout, docker.Stderr = os.Stdin, cmd.OutOrStdout(), cmd.ErrOrStderr()
+			return docker.Run()
+		},
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+
+	proxyclient "github.com/sparkfabrik/http-proxy/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+// exportTarget is where exported names resolve, and the domains resolved as
+// a whole.
+type exportTarget struct {
+	IPv4    string
+	IPv6    string
+	Domains []string
+}
+
+// exportName is a hostname as resolvers can express it: an exact name, or a
+// domain and all its subdomains (from a "*.<domain>" wildcard host).
+type exportName struct {
+	Name     string
+	Wildcard bool
+}
+
+// exporters render the hostname inventory for another resolver. skipped
+// lists the hosts the format cannot express.
+var exporters = map[string]func(w io.Writer, names []exportName, skipped []string, target exportTarget){
+	"hosts":   writeHostsExport,
+	"dnsmasq": writeDnsmasqExport,
+	"unbound": writeUnboundExport,
+}
+
+func newExportCommand(a *app) *cobra.Command {
+	var target exportTarget
+	var all bool
+	cmd := &cobra.Command{
+		Use:   "export hosts|dnsmasq|unbound",
+		Short: "Print the proxy hostnames as hosts file, dnsmasq or Unbound configuration",
+		Long: "Print the hostnames served by the proxy in a format another resolver can load, " +
+			"for setups that keep their own resolver instead of the bundled DNS server.",
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"hosts", "dnsmasq", "unbound"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			export, ok := exporters[args[0]]
+			if !ok {
+				return fmt.Errorf("unknown export format %q, must be hosts, dnsmasq or unbound", args[0])
+			}
+			if a.format == formatJSON {
+				return fmt.Errorf("export does not support --format json")
+			}
+			if net.ParseIP(target.IPv4).To4() == nil {
+				return fmt.Errorf("invalid IPv4 address %q", target.IPv4)
+			}
+			if target.IPv6 != "" && net.ParseIP(target.IPv6) == nil {
+				return fmt.Errorf("invalid IPv6 address %q", target.IPv6)
+			}
+
+			routes, err := a.adminClient().Routes(cmd.Context(), all)
+			if err != nil {
+				return err
+			}
+
+			names, skipped := exportNames(routes, target.Domains)
+			export(cmd.OutOrStdout(), names, skipped, target)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&target.IPv4, "ip", "127.0.0.1", "address the names resolve to")
+	cmd.Flags().StringVar(&target.IPv6, "ipv6", "", "IPv6 address the names resolve to")
+	cmd.Flags().StringSliceVar(&target.Domains, "domain", nil, "domains resolved with all their subdomains, like HTTP_PROXY_DNS_TLDS (repeatable; not supported by hosts)")
+	cmd.Flags().BoolVar(&all, "all", false, "include the hostnames of stopped containers")
+	return cmd
+}
+
+// exportNames returns the sorted, deduplicated names of the routes and the
+// domains, and the hosts no resolver format can express (regex hosts and
+// wildcards other than a leading "*.").
+func exportNames(routes []proxyclient.Route, domains []string) (names []exportName, skipped []string) {
+	seen := make(map[exportName]bool)
+	add := func(n exportName) {
+		n.Name = strings.TrimSuffix(strings.ToLower(n.Name), ".")
+		if n.Name != "" && !seen[n] {
+			seen[n] = true
+			names = append(names, n)
+		}
+	}
+
+	for _, domain := range domains {
+		add(exportName{Name: strings.TrimSpace(domain), Wildcard: true})
+	}
+	for _, r := range routes {
+		for _, host := range r.Hostnames {
+			switch {
+			case strings.HasPrefix(host, "~"):
+				skipped = append(skipped, host)
+			case strings.HasPrefix(host, "*.") && !strings.Contains(host[2:], "*"):
+				add(exportName{Name: host[2:], Wildcard: true})
+			case strings.Contains(host, "*"):
+				skipped = append(skipped, host)
+			default:
+				add(exportName{Name: host})
+			}
+		}
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Name != names[j].Name {
+			return names[i].Name < names[j].Name
+		}
+		return !names[i].Wildcard
+	})
+	sort.Strings(skipped)
+	return names, slices.Compact(skipped)
+}
+
+// writeExportHeader starts an export with a comment naming its source and
+// the hosts left out.
+func writeExportHeader(w io.Writer, comment string, skipped []string) {
+	fmt.Fprintf(w, "%s Generated by spark-http-proxy export from the routes of the proxy\n", comment)
+	for _, host := range skipped {
+		fmt.Fprintf(w, "%s skipped %s: not expressible in this format\n", comment, host)
+	}
+}
+
+// writeHostsExport prints /etc/hosts entries. Hosts files have no
+// wildcards, so wildcard hosts and domains are listed as skipped.
+func writeHostsExport(w io.Writer, names []exportName, skipped []string, target exportTarget) {
+	var exact []string
+	for _, n := range names {
+		if n.Wildcard {
+			skipped = append(skipped, "*."+n.Name)
+			continue
+		}
+		exact = append(exact, n.Name)
+	}
+	sort.Strings(skipped)
+
+	writeExportHeader(w, "#", skipped)
+	for _, name := range exact {
+		fmt.Fprintf(w, "%s\t%s\n", target.IPv4, name)
+		if target.IPv6 != "" {
+			fmt.Fprintf(w, "%s\t%s\n", target.IPv6, name)
+		}
+	}
+}
+
+// writeDnsmasqExport prints dnsmasq options: host-record for exact names,
+// address for a domain and its subdomains.
+func writeDnsmasqExport(w io.Writer, names []exportName, skipped []string, target exportTarget) {
+	writeExportHeader(w, "#", skipped)
+	for _, n := range names {
+		if !n.Wildcard {
+			addrs := target.IPv4
+			if target.IPv6 != "" {
+				addrs += "," + target.IPv6
+			}
+			fmt.Fprintf(w, "host-record=%s,%s\n", n.Name, addrs)
+			continue
+		}
+		fmt.Fprintf(w, "address=/%s/%s\n", n.Name, target.IPv4)
+		if target.IPv6 != "" {
+			fmt.Fprintf(w, "address=/%s/%s\n", n.Name, target.IPv6)
+		}
+	}
+}
+
+// writeUnboundExport prints Unbound server: clause entries. Exact names get
+// a static local zone, so other types get NODATA instead of being forwarded;
+// domains get a redirect zone answering for every subdomain, which also
+// covers the domain itself.
+func writeUnboundExport(w io.Writer, names []exportName, skipped []string, target exportTarget) {
+	writeExportHeader(w, "#", skipped)
+	fmt.Fprintln(w, "server:")
+	for _, n := range names {
+		if !n.Wildcard && slices.Contains(names, exportName{Name: n.Name, Wildcard: true}) {
+			continue
+		}
+		zoneType := "static"
+		if n.Wildcard {
+			zoneType = "redirect"
+		}
+		fmt.Fprintf(w, "  local-zone: \"%s.\" %s\n", n.Name, zoneType)
+		fmt.Fprintf(w, "  local-data: \"%s. IN A %s\"\n", n.Name, target.IPv4)
+		if target.IPv6 != "" {
+			fmt.Fprintf(w, "  local-data: \"%s. IN AAAA %s\"\n", n.Name, target.IPv6)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	proxyclient "github.com/sparkfabrik/http-proxy/pkg/client"
+)
+
+func TestExportNames(t *testing.T) {
+	routes := []proxyclient.Route{
+		{Hostnames: []string{"web.loc", "WWW.web.loc", "*.api.loc"}},
+		{Hostnames: []string{"web.loc", "~^app-[0-9]+\\.loc$", "app-*.loc"}},
+	}
+	names, skipped := exportNames(routes, []string{"dev"})
+
+	want := []exportName{{"api.loc", true}, {"dev", true}, {"web.loc", false}, {"www.web.loc", false}}
+	if len(names) != len(want) {
+		t.Fatalf("names = %+v, want %+v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("names[%d] = %+v, want %+v", i, names[i], want[i])
+		}
+	}
+	if len(skipped) != 2 {
+		t.Errorf("skipped = %v, want the regex and the inner wildcard", skipped)
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"container_id":"abc","container_name":"web","hostnames":["web.loc","*.web.loc","~^x$"]}]`))
+	}))
+	defer admin.Close()
+
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{"hosts", []string{
+			"# skipped *.web.loc:",
+			"# skipped ~^x$:",
+			"127.0.0.1\tweb.loc\n",
+			"::1\tweb.loc\n",
+		}},
+		{"dnsmasq", []string{
+			"host-record=web.loc,127.0.0.1,::1\n",
+			"address=/web.loc/127.0.0.1\n",
+			"address=/web.loc/::1\n",
+			"address=/loc/127.0.0.1\n",
+		}},
+		{"unbound", []string{
+			"server:\n",
+			"  local-zone: \"loc.\" redirect\n",
+			"  local-zone: \"web.loc.\" redirect\n",
+			"  local-data: \"web.loc. IN AAAA ::1\"\n",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			a := &app{adminURL: admin.URL, http: admin.Client()}
+			var out bytes.Buffer
+			root := newRootCommand(a)
+			root.SetOut(&out)
+			root.SetArgs([]string{"export", tt.format, "--ipv6", "::1", "--domain", "loc"})
+			if err := root.Execute(); err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output lacks %q:\n%s", want, out.String())
+				}
+			}
+			if tt.format == "unbound" && strings.Contains(out.String(), `"web.loc." static`) {
+				t.Errorf("web.loc has both a static and a redirect zone:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestExportCommandRejectsInvalidInput(t *testing.T) {
+	for _, args := range [][]string{
+		{"export", "bind"},
+		{"export", "hosts", "--ip", "::1"},
+		{"export", "hosts", "--format", "json"},
+	} {
+		root := newRootCommand(&app{adminURL: "http://127.0.0.1:1"})
+		root.SetArgs(args)
+		root.SetOut(&bytes.Buffer{})
+		if err := root.Execute(); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
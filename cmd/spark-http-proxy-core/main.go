@@ -0,0 +1,70 @@
+// Package main implements spark-http-proxy-core, the Go side of the
+// spark-http-proxy command. It talks to the Docker API and the dinghy-layer
+// admin API directly, so its commands share one implementation across
+// platforms and can print JSON; the spark-http-proxy shell script delegates
+// the commands ported here and keeps the rest.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// version is the CLI build version, set with -ldflags "-X main.version=..."
+var version = "unknown"
+
+func main() {
+	a := newApp()
+	defer a.close()
+
+	if err := newRootCommand(a).Execute(); err != nil {
+		if !errors.Is(err, errProbeFailed) && !errors.Is(err, errManifestGaps) {
+			logError(os.Stderr, err.Error())
+		}
+		a.close()
+		os.Exit(1)
+	}
+}
+
+// newRootCommand builds the command tree around a.
+func newRootCommand(a *app) *cobra.Command {
+	root := &cobra.Command{
+		Use:           "spark-http-proxy",
+		Short:         "Manage the local HTTP proxy stack",
+		Version:       version,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if a.format != formatText && a.format != formatJSON {
+				return fmt.Errorf("invalid format %q, must be text or json", a.format)
+			}
+			return nil
+		},
+	}
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&a.format, "format", formatText, "output format (text, json)")
+	flags.StringVar(&a.composeFile, "compose-file", a.composeFile, "compose file of the proxy stack")
+	flags.StringVar(&a.project, "project", a.project, "compose project name of the proxy stack")
+	flags.StringVar(&a.adminURL, "admin-url", a.adminURL, "base URL of the dinghy-layer admin API")
+
+	root.AddCommand(
+		newStatusCommand(a),
+		newRoutesCommand(a),
+		newProjectsCommand(a),
+		newVersionCommand(a),
+		newShowConfigCommand(a),
+		newLogsCommand(a),
+		newExportCommand(a),
+		newProbeCommand(a),
+		newProfileCommand(a),
+		newManifestCommand(a),
+		newVerifyManifestCommand(a),
+		newComposeOverrideCommand(a),
+		newStopCommand(a),
+	)
+	return root
+}
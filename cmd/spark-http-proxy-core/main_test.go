@@ -0,0 +1,233 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	proxyclient "github.com/sparkfabrik/http-proxy/pkg/client"
+)
+
+func TestDiscoverComposeFile(t *testing.T) {
+	configDir := t.TempDir()
+	binDir := t.TempDir()
+
+	if got, want := discoverComposeFile(configDir, binDir), filepath.Join(binDir, "compose.yml"); got != want {
+		t.Errorf("without override = %q, want %q", got, want)
+	}
+
+	override := filepath.Join(configDir, "compose.yml")
+	if err := os.WriteFile(override, []byte("services: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := discoverComposeFile(configDir, binDir); got != override {
+		t.Errorf("with override = %q, want %q", got, override)
+	}
+}
+
+func TestReadTarFile(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "dir", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: ".version", Typeflag: tar.TypeReg, Mode: 0644, Size: 8})
+	tw.Write([]byte("abc1234\n"))
+	tw.Close()
+
+	data, err := readTarFile(&buf)
+	if err != nil {
+		t.Fatalf("readTarFile() error = %v", err)
+	}
+	if string(data) != "abc1234\n" {
+		t.Errorf("readTarFile() = %q, want %q", data, "abc1234\n")
+	}
+
+	var empty bytes.Buffer
+	tar.NewWriter(&empty).Close()
+	if _, err := readTarFile(&empty); err == nil {
+		t.Error("readTarFile() of an empty archive should fail")
+	}
+}
+
+func TestRoutesCommand(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/routes" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`[{"container_id":"abc","container_name":"web","hostnames":["web.loc","www.web.loc"],"backend_url":"http://172.17.0.2:80","status":"healthy"}]`))
+	}))
+	defer admin.Close()
+
+	tests := []struct {
+		name   string
+		format string
+		check  func(t *testing.T, out string)
+	}{
+		{"text", formatText, func(t *testing.T, out string) {
+			if !strings.Contains(out, "CONTAINER") || !strings.Contains(out, "web.loc,www.web.loc") || !strings.Contains(out, "healthy") {
+				t.Errorf("unexpected table:\n%s", out)
+			}
+		}},
+		{"json", formatJSON, func(t *testing.T, out string) {
+			var routes []proxyclient.Route
+			if err := json.Unmarshal([]byte(out), &routes); err != nil {
+				t.Fatalf("invalid JSON: %v\n%s", err, out)
+			}
+			if len(routes) != 1 || routes[0].BackendURL != "http://172.17.0.2:80" {
+				t.Errorf("routes = %+v", routes)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &app{adminURL: admin.URL + "/", http: admin.Client()}
+			var out bytes.Buffer
+			root := newRootCommand(a)
+			root.SetOut(&out)
+			root.SetArgs([]string{"routes", "--format", tt.format})
+			if err := root.Execute(); err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			tt.check(t, out.String())
+		})
+	}
+}
+
+func TestAdminClientError(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"boom"}`))
+	}))
+	defer admin.Close()
+
+	a := &app{adminURL: admin.URL, http: admin.Client()}
+	_, err := a.adminClient().Routes(t.Context(), false)
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Routes() error = %v, want the admin API error", err)
+	}
+}
+
+func TestPrintStatus(t *testing.T) {
+	collisions := []proxyclient.HostCollision{
+		{Hostname: "shop.loc", Containers: []string{"shop-new", "shop-old"}, Winner: "shop-new", Policy: "newest"},
+	}
+	var out bytes.Buffer
+	printStatus(&out, stackStatus{
+		Running:      true,
+		DashboardURL: "http://localhost:30000",
+		DNS:          &dnsStatus{ConfiguredPort: "19322", Port: "19323", Fallback: true, Domains: []string{"loc", "test"}, Profile: "office"},
+		Admin:        adminStatus{Reachable: true, Routes: 3, Collisions: collisions},
+		Networks:     []string{"http-proxy_default", "shop_default"},
+		JoinReasons:  map[string]string{"shop_default": "manageable-container"},
+	})
+	for _, want := range []string{"HTTP Proxy is running", "http://localhost:30000", "fell back to port 19323", "DNS Domains: loc, test", "DNS Profile: office", "Routes: 3", "shop.loc is served by shop-new, shop-old (shop-new wins, newest)", "Networks: http-proxy_default, shop_default (manageable-container)", "Monitoring services are not running"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("status output lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestProbeWebSocketCommand(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/routes/chat.loc/websocket":
+			w.Write([]byte(`{"hostname":"chat.loc","path":"/ws","container_name":"chat","ok":false,"stage":"middleware","status_code":401,"middlewares":["chat-auth"],"error":"answered 401 before the backend"}`))
+		case "/routes/live.loc/websocket":
+			w.Write([]byte(`{"hostname":"live.loc","path":"/","ok":true,"latency_ms":3.2}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer admin.Close()
+
+	tests := []struct {
+		host    string
+		wantErr error
+		want    []string
+	}{
+		{"live.loc", nil, []string{"live.loc/ upgraded"}},
+		{"chat.loc", errProbeFailed, []string{"failed at the middleware", "chat-auth"}},
+	}
+	for _, tt := range tests {
+		a := &app{adminURL: admin.URL, http: admin.Client()}
+		var out bytes.Buffer
+		root := newRootCommand(a)
+		root.SetOut(&out)
+		root.SetArgs([]string{"probe", "websocket", tt.host})
+		if err := root.Execute(); !errors.Is(err, tt.wantErr) {
+			t.Errorf("probe websocket %s error = %v, want %v", tt.host, err, tt.wantErr)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("probe websocket %s output lacks %q:\n%s", tt.host, want, out.String())
+			}
+		}
+	}
+
+	a := &app{adminURL: admin.URL, http: admin.Client()}
+	root := newRootCommand(a)
+	root.SetOut(io.Discard)
+	root.SetArgs([]string{"probe", "websocket", "other.loc"})
+	if err := root.Execute(); err == nil || !strings.Contains(err.Error(), "no route serves other.loc") {
+		t.Errorf("probe websocket of an unrouted host error = %v", err)
+	}
+}
+
+func TestNewDNSStatus(t *testing.T) {
+	// The status file written by the DNS server, also served by the admin API
+	data := `{"configured_port":"53","port":"19322","fallback":true,"domains":[{"domain":"loc","target_ip":"127.0.0.1"},{"domain":"test","target_ip":"10.0.0.1"}],"profile":"home"}`
+	var domains proxyclient.DNSDomains
+	if err := json.Unmarshal([]byte(data), &domains); err != nil {
+		t.Fatal(err)
+	}
+	s := newDNSStatus(domains)
+	if s.Port != "19322" || !s.Fallback || s.Profile != "home" || strings.Join(s.Domains, ",") != "loc,test" {
+		t.Errorf("newDNSStatus() = %+v", s)
+	}
+}
+
+func TestProfileCommand(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"home.env", "office.env"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := func(args ...string) (string, error) {
+		a := &app{format: formatText, profilesDir: dir}
+		var out bytes.Buffer
+		root := newRootCommand(a)
+		root.SetOut(&out)
+		root.SetArgs(args)
+		err := root.Execute()
+		return out.String(), err
+	}
+
+	if _, err := run("profile", "use", "vpn"); err == nil {
+		t.Error("selected a profile that does not exist")
+	}
+	if _, err := run("profile", "use", "office"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := run("profile", "list")
+	if err != nil || !strings.Contains(out, "  home\n* office\n") {
+		t.Errorf("profile list = %q, %v", out, err)
+	}
+
+	if _, err := run("profile", "clear"); err != nil {
+		t.Fatal(err)
+	}
+	out, err = run("profile", "list", "--format", "json")
+	var list profileList
+	if err != nil || json.Unmarshal([]byte(out), &list) != nil || list.Active != "" || len(list.Profiles) != 2 {
+		t.Errorf("profile list --format json = %s, %v", out, err)
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	proxyclient "github.com/sparkfabrik/http-proxy/pkg/client"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// manifestVersion is the version of the routes manifest format
+const manifestVersion = 1
+
+// manifestFileName is where an imported manifest is kept in the config
+// directory
+const manifestFileName = "manifest.yml"
+
+// errManifestGaps makes verify-manifest exit non-zero once the gaps are
+// printed; main does not print it again.
+var errManifestGaps = errors.New("manifest gaps found")
+
+// routeManifest is a team's convention for the local stack: the TLDs the DNS
+// server must answer and the routes the proxy must serve.
+type routeManifest struct {
+	Version int             `yaml:"version" json:"version"`
+	TLDs    []string        `yaml:"tlds,omitempty" json:"tlds,omitempty"`
+	Routes  []manifestRoute `yaml:"routes" json:"routes"`
+}
+
+// manifestRoute is a hostname the proxy must serve, optionally on a path
+// prefix, from a backend port, and with a certificate of the certs directory
+// covering it.
+type manifestRoute struct {
+	Hostname string `yaml:"hostname" json:"hostname"`
+	Path     string `yaml:"path,omitempty" json:"path,omitempty"`
+	Port     string `yaml:"port,omitempty" json:"port,omitempty"`
+	TLS      bool   `yaml:"tls,omitempty" json:"tls,omitempty"`
+}
+
+// Kinds of manifest gaps
+const (
+	gapTLD         = "tld"
+	gapRoute       = "route"
+	gapPort        = "port"
+	gapCertificate = "certificate"
+)
+
+// manifestGap is a requirement of the manifest the local stack misses.
+type manifestGap struct {
+	Kind    string `json:"kind"`
+	Subject string `json:"subject"`
+	Detail  string `json:"detail"`
+}
+
+// manifestReport is the outcome of verify-manifest.
+type manifestReport struct {
+	Manifest string        `json:"manifest"`
+	Checked  int           `json:"checked"`
+	Gaps     []manifestGap `json:"gaps"`
+}
+
+func newManifestCommand(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "manifest",
+		Short: "Share the routes of the proxy as a team manifest",
+		Long: "A routes manifest lists the hostnames a project serves, their backend ports,\n" +
+			"the TLDs the DNS server must answer and the hosts needing a certificate.\n" +
+			"Export it from a working stack, commit it with the project, and have\n" +
+			"teammates import it and run verify-manifest against their own proxy.",
+	}
+
+	var output string
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Print a manifest of the routes the proxy serves",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := a.exportManifest(cmd)
+			if err != nil {
+				return err
+			}
+			if output == "" {
+				return writeManifest(cmd.OutOrStdout(), m, a.format)
+			}
+			f, err := os.Create(output)
+			if err != nil {
+				return fmt.Errorf("failed to create manifest: %w", err)
+			}
+			defer f.Close()
+			if err := writeManifest(f, m, a.format); err != nil {
+				return err
+			}
+			logSuccess(cmd.ErrOrStderr(), fmt.Sprintf("Wrote %d routes to %s", len(m.Routes), output))
+			return nil
+		},
+	}
+	exportCmd.Flags().StringVarP(&output, "output", "o", "", "file to write the manifest to (default stdout)")
+
+	importCmd := &cobra.Command{
+		Use:   "import <file>",
+		Short: "Keep a manifest as the one verify-manifest checks by default",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := readManifest(args[0])
+			if err != nil {
+				return err
+			}
+			path := a.manifestPath()
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return fmt.Errorf("failed to create config directory: %w", err)
+			}
+			f, err := os.Create(path)
+			if err != nil {
+				return fmt.Errorf("failed to save manifest: %w", err)
+			}
+			defer f.Close()
+			if err := writeManifest(f, m, formatText); err != nil {
+				return err
+			}
+			logSuccess(cmd.OutOrStdout(), fmt.Sprintf("Imported %d routes to %s", len(m.Routes), path))
+			return nil
+		},
+	}
+
+	cmd.AddCommand(exportCmd, importCmd)
+	return cmd
+}
+
+func newVerifyManifestCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify-manifest [file]",
+		Short: "Report where the local stack differs from a routes manifest",
+		Long: "Checks the TLDs, routes, backend ports and certificates a routes manifest\n" +
+			"requires against the running proxy and the certs directory. Without a file,\n" +
+			"the manifest imported with 'manifest import' is checked. Exits non-zero when\n" +
+			"anything is missing.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := a.manifestPath()
+			if len(args) == 1 {
+				path = args[0]
+			}
+			m, err := readManifest(path)
+			if err != nil {
+				return err
+			}
+
+			client := a.adminClient()
+			routes, err := client.Routes(cmd.Context(), false)
+			if err != nil {
+				return err
+			}
+			domains, err := client.DNSDomains(cmd.Context())
+			if err != nil {
+				return err
+			}
+			certs, err := loadCertificates(a.certDir)
+			if err != nil {
+				return err
+			}
+
+			report := manifestReport{
+				Manifest: path,
+				Checked:  len(m.TLDs) + len(m.Routes),
+				Gaps:     verifyManifest(m, routes, dnsDomainNames(domains), certs, time.Now()),
+			}
+			if a.format == formatJSON {
+				if err := writeJSON(cmd.OutOrStdout(), report); err != nil {
+					return err
+				}
+			} else {
+				printManifestReport(cmd.OutOrStdout(), report)
+			}
+			if len(report.Gaps) > 0 {
+				return errManifestGaps
+			}
+			return nil
+		},
+	}
+}
+
+// manifestPath returns where an imported manifest is kept.
+func (a *app) manifestPath() string {
+	return filepath.Join(a.configDir, manifestFileName)
+}
+
+// exportManifest builds a manifest from the routes the proxy serves, the
+// domains of the DNS server and the certificates of the certs directory.
+func (a *app) exportManifest(cmd *cobra.Command) (routeManifest, error) {
+	client := a.adminClient()
+	routes, err := client.Routes(cmd.Context(), false)
+	if err != nil {
+		return routeManifest{}, err
+	}
+	domains, err := client.DNSDomains(cmd.Context())
+	if err != nil {
+		return routeManifest{}, err
+	}
+	certs, err := loadCertificates(a.certDir)
+	if err != nil {
+		return routeManifest{}, err
+	}
+	return buildManifest(routes, dnsDomainNames(domains), certs, time.Now()), nil
+}
+
+// buildManifest returns the manifest of routes: one entry per hostname and
+// path, sorted. Regex hosts are left out, since teammates' stacks cannot be
+// checked against them.
+func buildManifest(routes []proxyclient.Route, tlds []string, certs []*x509.Certificate, now time.Time) routeManifest {
+	m := routeManifest{Version: manifestVersion, TLDs: tlds, Routes: []manifestRoute{}}
+	for _, r := range routes {
+		port := backendPort(r.BackendURL)
+		for _, host := range r.Hostnames {
+			if strings.HasPrefix(host, "~") {
+				continue
+			}
+			host = strings.ToLower(host)
+			m.Routes = append(m.Routes, manifestRoute{
+				Hostname: host,
+				Path:     r.Path,
+				Port:     port,
+				TLS:      coveringCertificate(certs, host, now) != nil,
+			})
+		}
+	}
+
+	sort.Slice(m.Routes, func(i, j int) bool {
+		if m.Routes[i].Hostname != m.Routes[j].Hostname {
+			return m.Routes[i].Hostname < m.Routes[j].Hostname
+		}
+		return m.Routes[i].Path < m.Routes[j].Path
+	})
+	m.Routes = slices.Compact(m.Routes)
+	return m
+}
+
+// verifyManifest returns what the local stack misses of the manifest: TLDs
+// the DNS server does not answer, routes nothing serves, routes served from
+// another backend port, and hosts without a valid certificate.
+func verifyManifest(m routeManifest, routes []proxyclient.Route, tlds []string, certs []*x509.Certificate, now time.Time) []manifestGap {
+	var gaps []manifestGap
+
+	for _, tld := range m.TLDs {
+		tld = strings.Trim(strings.ToLower(tld), ".")
+		if !slices.Contains(tlds, tld) {
+			gaps = append(gaps, manifestGap{Kind: gapTLD, Subject: tld,
+				Detail: "not in HTTP_PROXY_DNS_TLDS (" + strings.Join(tlds, ",") + ")"})
+		}
+	}
+
+	for _, want := range m.Routes {
+		host := strings.ToLower(want.Hostname)
+		subject := host + want.Path
+		route := findManifestRoute(routes, host, want.Path)
+		switch {
+		case route == nil:
+			gaps = append(gaps, manifestGap{Kind: gapRoute, Subject: subject, Detail: "no running container serves it"})
+		case want.Port != "" && backendPort(route.BackendURL) != want.Port:
+			gaps = append(gaps, manifestGap{Kind: gapPort, Subject: subject,
+				Detail: fmt.Sprintf("served by %s on %s, want port %s", route.ContainerName, route.BackendURL, want.Port)})
+		}
+		if want.TLS && coveringCertificate(certs, host, now) == nil {
+			gaps = append(gaps, manifestGap{Kind: gapCertificate, Subject: host,
+				Detail: "no valid certificate in the certs directory covers it (see generate-mkcert)"})
+		}
+	}
+	return gaps
+}
+
+// findManifestRoute returns the route serving host on path, or nil.
+func findManifestRoute(routes []proxyclient.Route, host, path string) *proxyclient.Route {
+	for i, r := range routes {
+		if r.Path != path {
+			continue
+		}
+		for _, h := range r.Hostnames {
+			if strings.EqualFold(h, host) {
+				return &routes[i]
+			}
+		}
+	}
+	return nil
+}
+
+// backendPort returns the port of a backend URL, or "" when it has none.
+func backendPort(backendURL string) string {
+	u, err := url.Parse(backendURL)
+	if err != nil {
+		return ""
+	}
+	return u.Port()
+}
+
+// dnsDomainNames returns the domains the DNS server answers.
+func dnsDomainNames(domains proxyclient.DNSDomains) []string {
+	var names []string
+	for _, d := range domains.Domains {
+		names = append(names, strings.ToLower(d.Domain))
+	}
+	return names
+}
+
+// loadCertificates parses the leaf certificates of the .crt and .pem files in
+// dir. A missing directory has no certificates; unreadable files are skipped.
+func loadCertificates(dir string) ([]*x509.Certificate, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate directory: %w", err)
+	}
+
+	var certs []*x509.Certificate
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".crt" && ext != ".pem") || strings.HasSuffix(entry.Name(), "-key.pem") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// coveringCertificate returns a certificate valid at now for host, or nil.
+// A wildcard host needs a certificate for the same wildcard.
+func coveringCertificate(certs []*x509.Certificate, host string, now time.Time) *x509.Certificate {
+	for _, cert := range certs {
+		if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+			continue
+		}
+		if strings.HasPrefix(host, "*.") {
+			if slices.ContainsFunc(cert.DNSNames, func(name string) bool { return strings.EqualFold(name, host) }) {
+				return cert
+			}
+			continue
+		}
+		if cert.VerifyHostname(host) == nil {
+			return cert
+		}
+	}
+	return nil
+}
+
+// readManifest reads and validates a manifest file.
+func readManifest(path string) (routeManifest, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return routeManifest{}, fmt.Errorf("no manifest at %s (import one with 'manifest import <file>')", path)
+	}
+	if err != nil {
+		return routeManifest{}, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	return parseManifest(data)
+}
+
+// parseManifest parses a YAML (or JSON) manifest, rejecting unknown fields
+// so a typo does not silently drop a requirement.
+func parseManifest(data []byte) (routeManifest, error) {
+	var m routeManifest
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil && !errors.Is(err, io.EOF) {
+		return routeManifest{}, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if m.Version != manifestVersion {
+		return routeManifest{}, fmt.Errorf("unsupported manifest version %d, expected %d", m.Version, manifestVersion)
+	}
+	for _, r := range m.Routes {
+		if r.Hostname == "" {
+			return routeManifest{}, fmt.Errorf("invalid manifest: route without hostname")
+		}
+		if r.Path != "" && !strings.HasPrefix(r.Path, "/") {
+			return routeManifest{}, fmt.Errorf("invalid manifest: path %q of %s must start with /", r.Path, r.Hostname)
+		}
+		if r.Port != "" && !validPort(r.Port) {
+			return routeManifest{}, fmt.Errorf("invalid manifest: port %q of %s", r.Port, r.Hostname)
+		}
+	}
+	return m, nil
+}
+
+// validPort reports whether port is a TCP port number.
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// writeManifest prints a manifest as YAML, or JSON with --format json.
+func writeManifest(w io.Writer, m routeManifest, format string) error {
+	if format == formatJSON {
+		return writeJSON(w, m)
+	}
+	fmt.Fprintln(w, "# Routes manifest generated by spark-http-proxy manifest export")
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(m); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return enc.Close()
+}
+
+// printManifestReport prints the gaps found by verify-manifest.
+func printManifestReport(w io.Writer, report manifestReport) {
+	if len(report.Gaps) == 0 {
+		logSuccess(w, fmt.Sprintf("The stack matches %s (%d requirements checked)", report.Manifest, report.Checked))
+		return
+	}
+	logError(w, fmt.Sprintf("The stack misses %d of the requirements of %s:", len(report.Gaps), report.Manifest))
+	for _, gap := range report.Gaps {
+		fmt.Fprintf(w, "   %-12s %s: %s\n", gap.Kind, gap.Subject, gap.Detail)
+	}
+}
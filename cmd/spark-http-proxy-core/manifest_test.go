@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	proxyclient "github.com/sparkfabrik/http-proxy/pkg/client"
+)
+
+// writeTestCert writes a self-signed certificate for names, valid until
+// notAfter, to dir/name and returns it.
+func writeTestCert(t *testing.T, dir, name string, names []string, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		DNSNames:     names,
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestParseManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "yaml", data: "version: 1\ntlds: [loc]\nroutes:\n  - hostname: shop.loc\n    port: \"8080\"\n    tls: true\n"},
+		{name: "json", data: `{"version":1,"routes":[{"hostname":"shop.loc","path":"/api/"}]}`},
+		{name: "no version", data: "routes: []\n", wantErr: true},
+		{name: "unknown field", data: "version: 1\nroutes:\n  - hostname: shop.loc\n    prot: 80\n", wantErr: true},
+		{name: "no hostname", data: "version: 1\nroutes:\n  - port: \"80\"\n", wantErr: true},
+		{name: "relative path", data: "version: 1\nroutes:\n  - hostname: shop.loc\n    path: api\n", wantErr: true},
+		{name: "bad port", data: "version: 1\nroutes:\n  - hostname: shop.loc\n    port: \"http\"\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseManifest([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuildManifest(t *testing.T) {
+	now := time.Now()
+	cert := writeTestCert(t, t.TempDir(), "shop.crt", []string{"*.shop.loc", "shop.loc"}, now.Add(time.Hour))
+	routes := []proxyclient.Route{
+		{Hostnames: []string{"Shop.loc", "~^x$"}, BackendURL: "http://172.18.0.2:8080"},
+		{Hostnames: []string{"shop.loc"}, Path: "/api/", BackendURL: "http://172.18.0.3:3000"},
+		{Hostnames: []string{"blog.loc"}, BackendURL: "http://172.18.0.4:80"},
+	}
+
+	got := buildManifest(routes, []string{"loc"}, []*x509.Certificate{cert}, now)
+
+	want := routeManifest{Version: manifestVersion, TLDs: []string{"loc"}, Routes: []manifestRoute{
+		{Hostname: "blog.loc", Port: "80"},
+		{Hostname: "shop.loc", Port: "8080", TLS: true},
+		{Hostname: "shop.loc", Path: "/api/", Port: "3000", TLS: true},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildManifest() = %+v, want %+v", got, want)
+	}
+}
+
+func TestVerifyManifest(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+	valid := writeTestCert(t, dir, "shop.crt", []string{"shop.loc"}, now.Add(time.Hour))
+	expired := writeTestCert(t, dir, "blog.crt", []string{"blog.loc"}, now.Add(-time.Hour))
+	certs := []*x509.Certificate{valid, expired}
+
+	m := routeManifest{Version: manifestVersion, TLDs: []string{"loc", "test"}, Routes: []manifestRoute{
+		{Hostname: "shop.loc", Port: "8080", TLS: true},
+		{Hostname: "shop.loc", Path: "/api/", Port: "3000"},
+		{Hostname: "blog.loc", TLS: true},
+		{Hostname: "docs.loc"},
+	}}
+	routes := []proxyclient.Route{
+		{ContainerName: "shop", Hostnames: []string{"shop.loc"}, BackendURL: "http://172.18.0.2:8080"},
+		{ContainerName: "api", Hostnames: []string{"SHOP.loc"}, Path: "/api/", BackendURL: "http://172.18.0.3:80"},
+		{ContainerName: "blog", Hostnames: []string{"blog.loc"}, BackendURL: "http://172.18.0.4:80"},
+	}
+
+	var got []string
+	for _, gap := range verifyManifest(m, routes, []string{"loc"}, certs, now) {
+		got = append(got, gap.Kind+" "+gap.Subject)
+	}
+	want := []string{"tld test", "port shop.loc/api/", "certificate blog.loc", "route docs.loc"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("verifyManifest() gaps = %v, want %v", got, want)
+	}
+}
+
+func TestLoadCertificates(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCert(t, dir, "shop.pem", []string{"shop.loc"}, time.Now().Add(time.Hour))
+	for name, data := range map[string]string{"shop-key.pem": "key", "notes.txt": "x", "broken.crt": "not a certificate"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	certs, err := loadCertificates(dir)
+	if err != nil || len(certs) != 1 {
+		t.Errorf("loadCertificates() = %d certificates, %v; want 1", len(certs), err)
+	}
+	if certs, err := loadCertificates(filepath.Join(dir, "missing")); err != nil || certs != nil {
+		t.Errorf("loadCertificates() of a missing directory = %v, %v", certs, err)
+	}
+}
+
+func TestManifestCommands(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/routes":
+			w.Write([]byte(`[{"container_id":"abc","container_name":"web","hostnames":["web.loc"],"backend_url":"http://172.17.0.2:80","status":"healthy"}]`))
+		case "/dns/domains":
+			w.Write([]byte(`{"domains":[{"domain":"loc","target_ip":"127.0.0.1"}],"port":"19322"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer admin.Close()
+
+	configDir := t.TempDir()
+	run := func(args ...string) (string, error) {
+		a := &app{format: formatText, adminURL: admin.URL + "/", http: admin.Client(), configDir: configDir, certDir: filepath.Join(configDir, "certs")}
+		var out bytes.Buffer
+		root := newRootCommand(a)
+		root.SetOut(&out)
+		root.SetErr(&out)
+		root.SetArgs(args)
+		err := root.Execute()
+		return out.String(), err
+	}
+
+	exported := filepath.Join(t.TempDir(), "manifest.yml")
+	if _, err := run("manifest", "export", "-o", exported); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run("manifest", "import", exported); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := run("verify-manifest"); err != nil || !strings.Contains(out, "matches") {
+		t.Errorf("verify-manifest of the exported manifest = %q, %v", out, err)
+	}
+
+	team := filepath.Join(t.TempDir(), "team.yml")
+	if err := os.WriteFile(team, []byte("version: 1\nroutes:\n  - hostname: web.loc\n    port: \"8080\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := run("verify-manifest", team, "--format", "json")
+	if !errors.Is(err, errManifestGaps) {
+		t.Fatalf("verify-manifest error = %v, want errManifestGaps", err)
+	}
+	var report manifestReport
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, out)
+	}
+	if len(report.Gaps) != 1 || report.Gaps[0].Kind != gapPort {
+		t.Errorf("gaps = %+v, want the port", report.Gaps)
+	}
+}
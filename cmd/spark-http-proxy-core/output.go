@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Output formats selected with --format
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// The log helpers mirror the prefixes of the spark-http-proxy shell script.
+func logInfo(w io.Writer, msg string)    { fmt.Fprintf(w, "ℹ  %s\n", msg) }
+func logSuccess(w io.Writer, msg string) { fmt.Fprintf(w, "✅ %s\n", msg) }
+func logError(w io.Writer, msg string)   { fmt.Fprintf(w, "❌ %s\n", msg) }
+func logWarning(w io.Writer, msg string) { fmt.Fprintf(w, "⚠️  %s\n", msg) }
+
+// writeJSON prints v as indented JSON.
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
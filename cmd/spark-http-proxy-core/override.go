@@ -0,0 +1,331 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// nonHTTPPorts are well-known ports of databases, caches, brokers and
+// FastCGI, which the proxy cannot route HTTP to
+var nonHTTPPorts = map[int]bool{
+	25: true, 1025: true, 1433: true, 1521: true, 3306: true, 5432: true,
+	5672: true, 6379: true, 9000: true, 11211: true, 27017: true,
+}
+
+// invalidHostLabel matches the characters a compose name may have and a
+// hostname label may not
+var invalidHostLabel = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// composeProject is the subset of a compose file the override needs.
+type composeProject struct {
+	Name     string                           `yaml:"name"`
+	Services map[string]composeProjectService `yaml:"services"`
+}
+
+// composeProjectService accepts the list and map forms compose allows for
+// environment, labels and networks, and both port syntaxes.
+type composeProjectService struct {
+	Environment composeListOrMap `yaml:"environment"`
+	Labels      composeListOrMap `yaml:"labels"`
+	Networks    composeListOrMap `yaml:"networks"`
+	NetworkMode string           `yaml:"network_mode"`
+	Ports       []yaml.Node      `yaml:"ports"`
+	Expose      []yaml.Node      `yaml:"expose"`
+}
+
+// composeListOrMap decodes `["KEY=value"]`, `[name]` and `{KEY: value}` into
+// the same map.
+type composeListOrMap map[string]string
+
+func (m *composeListOrMap) UnmarshalYAML(node *yaml.Node) error {
+	result := make(composeListOrMap)
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var items []string
+		if err := node.Decode(&items); err != nil {
+			return err
+		}
+		for _, item := range items {
+			key, value, _ := strings.Cut(item, "=")
+			result[strings.TrimSpace(key)] = value
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			value := node.Content[i+1]
+			if value.Kind == yaml.ScalarNode && value.Tag != "!!null" {
+				result[node.Content[i].Value] = value.Value
+			} else {
+				result[node.Content[i].Value] = ""
+			}
+		}
+	default:
+		return fmt.Errorf("expected a list or map, got %q", node.Value)
+	}
+	*m = result
+	return nil
+}
+
+// composeOverride is the generated override file.
+type composeOverride struct {
+	Services map[string]overrideService `yaml:"services" json:"services"`
+	Networks map[string]overrideNetwork `yaml:"networks,omitempty" json:"networks,omitempty"`
+}
+
+// overrideService is what the override adds to a service.
+type overrideService struct {
+	Environment map[string]string `yaml:"environment" json:"environment"`
+	Networks    []string          `yaml:"networks,omitempty" json:"networks,omitempty"`
+}
+
+// overrideNetwork declares a network created outside the project.
+type overrideNetwork struct {
+	External bool `yaml:"external" json:"external"`
+}
+
+// overrideSkip is a service the override leaves alone, and why.
+type overrideSkip struct {
+	Service string `json:"service"`
+	Reason  string `json:"reason"`
+}
+
+// overrideResult is the outcome of compose-override.
+type overrideResult struct {
+	Project  string          `json:"project"`
+	Override composeOverride `json:"override"`
+	Skipped  []overrideSkip  `json:"skipped"`
+}
+
+// overrideOptions shape the generated hostnames and networks.
+type overrideOptions struct {
+	project string
+	tld     string
+	network string // external network every routed service joins
+}
+
+func newComposeOverrideCommand(a *app) *cobra.Command {
+	var opts overrideOptions
+	var output string
+	cmd := &cobra.Command{
+		Use:   "compose-override <compose-file>",
+		Short: "Generate a compose override routing a project through the proxy",
+		Long: "Reads a project's compose file and prints an override file adding VIRTUAL_HOST\n" +
+			"and VIRTUAL_PORT to each service publishing or exposing an HTTP port, with\n" +
+			"<service>.<project>.<tld> hostnames. Services already routed, without ports or\n" +
+			"only on database and cache ports are skipped, as listed in the header.\n" +
+			"Save it as compose.override.yml to have docker compose merge it.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to read compose file: %w", err)
+			}
+			if opts.project == "" {
+				opts.project = filepath.Base(filepath.Dir(mustAbs(args[0])))
+			}
+			result, err := buildComposeOverride(data, opts)
+			if err != nil {
+				return fmt.Errorf("%s: %w", args[0], err)
+			}
+
+			w := cmd.OutOrStdout()
+			if output != "" {
+				f, err := os.Create(output)
+				if err != nil {
+					return fmt.Errorf("failed to create override: %w", err)
+				}
+				defer f.Close()
+				w = f
+			}
+			if a.format == formatJSON {
+				return writeJSON(w, result)
+			}
+			if err := writeComposeOverride(w, args[0], result); err != nil {
+				return err
+			}
+			if output != "" {
+				logSuccess(cmd.ErrOrStderr(), fmt.Sprintf("Wrote the override of %d services to %s", len(result.Override.Services), output))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&opts.project, "name", "", "project name used in the hostnames (default: the compose file's name, else its directory)")
+	cmd.Flags().StringVar(&opts.tld, "tld", "loc", "domain the hostnames are created under, one of HTTP_PROXY_DNS_TLDS")
+	cmd.Flags().StringVar(&opts.network, "network", "", "external network to attach the routed services to")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file to write the override to (default stdout)")
+	return cmd
+}
+
+// mustAbs returns the absolute form of path, or path when it has none.
+func mustAbs(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// buildComposeOverride plans the override of a compose file: the routed
+// services with their hostname and port, and the skipped ones.
+func buildComposeOverride(data []byte, opts overrideOptions) (overrideResult, error) {
+	var project composeProject
+	if err := yaml.Unmarshal(data, &project); err != nil {
+		return overrideResult{}, fmt.Errorf("invalid compose file: %w", err)
+	}
+	if len(project.Services) == 0 {
+		return overrideResult{}, fmt.Errorf("no services found")
+	}
+
+	name := hostLabel(project.Name)
+	if name == "" {
+		name = hostLabel(opts.project)
+	}
+	tld := strings.Trim(strings.ToLower(opts.tld), ".")
+	result := overrideResult{
+		Project:  name,
+		Override: composeOverride{Services: make(map[string]overrideService)},
+		Skipped:  []overrideSkip{},
+	}
+
+	names := make([]string, 0, len(project.Services))
+	for svc := range project.Services {
+		names = append(names, svc)
+	}
+	sort.Strings(names)
+
+	for _, svc := range names {
+		service := project.Services[svc]
+		if reason := skipReason(service); reason != "" {
+			result.Skipped = append(result.Skipped, overrideSkip{Service: svc, Reason: reason})
+			continue
+		}
+		port, ok := httpPort(service)
+		if !ok {
+			result.Skipped = append(result.Skipped, overrideSkip{Service: svc, Reason: "no HTTP port published or exposed"})
+			continue
+		}
+
+		host := hostLabel(svc) + "." + tld
+		if name != "" {
+			host = hostLabel(svc) + "." + name + "." + tld
+		}
+		override := overrideService{Environment: map[string]string{
+			"VIRTUAL_HOST": host,
+			"VIRTUAL_PORT": strconv.Itoa(port),
+		}}
+		if opts.network != "" {
+			override.Networks = serviceNetworks(service, opts.network)
+		}
+		result.Override.Services[svc] = override
+	}
+
+	if opts.network != "" && len(result.Override.Services) > 0 {
+		result.Override.Networks = map[string]overrideNetwork{opts.network: {External: true}}
+	}
+	return result, nil
+}
+
+// skipReason returns why a service is left out of the override, or "" when
+// it can be routed.
+func skipReason(service composeProjectService) string {
+	if service.Environment["VIRTUAL_HOST"] != "" {
+		return "already has VIRTUAL_HOST"
+	}
+	for label := range service.Labels {
+		if strings.HasPrefix(label, "traefik.http.routers.") || label == "traefik.enable" {
+			return "already has Traefik labels"
+		}
+	}
+	if service.NetworkMode != "" && !strings.HasPrefix(service.NetworkMode, "bridge") {
+		return fmt.Sprintf("network_mode %s cannot be routed", service.NetworkMode)
+	}
+	return ""
+}
+
+// httpPort returns the first container port of a service that is not a
+// well-known non-HTTP port, published ports first.
+func httpPort(service composeProjectService) (int, bool) {
+	for _, nodes := range [][]yaml.Node{service.Ports, service.Expose} {
+		for _, node := range nodes {
+			port, ok := containerPort(node)
+			if ok && !nonHTTPPorts[port] {
+				return port, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// containerPort returns the container port of a short ("[ip:]host:container",
+// "container", optionally with a range or protocol) or long ({target: n})
+// port entry. UDP ports are not HTTP.
+func containerPort(node yaml.Node) (int, bool) {
+	value := node.Value
+	if node.Kind == yaml.MappingNode {
+		var long struct {
+			Target   int    `yaml:"target"`
+			Protocol string `yaml:"protocol"`
+		}
+		if err := node.Decode(&long); err != nil || long.Protocol == "udp" {
+			return 0, false
+		}
+		return long.Target, long.Target > 0
+	}
+
+	value, protocol, _ := strings.Cut(value, "/")
+	if protocol == "udp" {
+		return 0, false
+	}
+	if i := strings.LastIndex(value, ":"); i >= 0 {
+		value = value[i+1:]
+	}
+	value, _, _ = strings.Cut(value, "-")
+	port, err := strconv.Atoi(value)
+	return port, err == nil && port > 0
+}
+
+// serviceNetworks returns the networks of a service with network added.
+// A service without networks keeps the project's default network.
+func serviceNetworks(service composeProjectService, network string) []string {
+	networks := []string{"default"}
+	if len(service.Networks) > 0 {
+		networks = networks[:0]
+		for name := range service.Networks {
+			networks = append(networks, name)
+		}
+	}
+	networks = append(networks, network)
+	sort.Strings(networks)
+	return networks
+}
+
+// hostLabel turns a compose name into a hostname label.
+func hostLabel(name string) string {
+	return strings.Trim(invalidHostLabel.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// writeComposeOverride prints the override with a header naming its source
+// and the skipped services.
+func writeComposeOverride(w io.Writer, source string, result overrideResult) error {
+	fmt.Fprintf(w, "# Generated by spark-http-proxy compose-override from %s\n", filepath.Base(source))
+	for _, skip := range result.Skipped {
+		fmt.Fprintf(w, "# skipped %s: %s\n", skip.Service, skip.Reason)
+	}
+	if len(result.Override.Services) == 0 {
+		fmt.Fprintln(w, "# no service to route")
+		return nil
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(result.Override); err != nil {
+		return fmt.Errorf("failed to write override: %w", err)
+	}
+	return enc.Close()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const overrideComposeFile = `
+services:
+  web:
+    image: nginx
+    ports:
+      - "127.0.0.1:8080:80/tcp"
+  api:
+    build: .
+    expose: [3000]
+    networks: [backend]
+  db:
+    image: mysql:8
+    ports: ["3306:3306"]
+  worker:
+    image: shop-worker
+  admin:
+    image: adminer
+    environment:
+      VIRTUAL_HOST: admin.shop.loc
+  mail:
+    image: mailhog/mailhog
+    ports:
+      - target: 1025
+      - target: 8025
+        published: 8025
+  host:
+    image: tool
+    network_mode: host
+    ports: ["8000:8000"]
+`
+
+func TestBuildComposeOverride(t *testing.T) {
+	result, err := buildComposeOverride([]byte(overrideComposeFile), overrideOptions{project: "Shop_App", tld: "loc", network: "http-proxy"})
+	if err != nil {
+		t.Fatalf("buildComposeOverride() error = %v", err)
+	}
+
+	want := map[string]overrideService{
+		"web":  {Environment: map[string]string{"VIRTUAL_HOST": "web.shop-app.loc", "VIRTUAL_PORT": "80"}, Networks: []string{"default", "http-proxy"}},
+		"api":  {Environment: map[string]string{"VIRTUAL_HOST": "api.shop-app.loc", "VIRTUAL_PORT": "3000"}, Networks: []string{"backend", "http-proxy"}},
+		"mail": {Environment: map[string]string{"VIRTUAL_HOST": "mail.shop-app.loc", "VIRTUAL_PORT": "8025"}, Networks: []string{"default", "http-proxy"}},
+	}
+	if !reflect.DeepEqual(result.Override.Services, want) {
+		t.Errorf("services = %+v, want %+v", result.Override.Services, want)
+	}
+	if !result.Override.Networks["http-proxy"].External {
+		t.Errorf("networks = %+v, want http-proxy declared external", result.Override.Networks)
+	}
+
+	var skipped []string
+	for _, skip := range result.Skipped {
+		skipped = append(skipped, skip.Service)
+	}
+	if want := []string{"admin", "db", "host", "worker"}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("skipped = %v, want %v", skipped, want)
+	}
+}
+
+func TestBuildComposeOverrideProjectName(t *testing.T) {
+	result, err := buildComposeOverride([]byte("name: blog\nservices:\n  web:\n    expose: [\"8080\"]\n"), overrideOptions{project: "dir", tld: "dev."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Override.Services["web"].Environment["VIRTUAL_HOST"]; got != "web.blog.dev" {
+		t.Errorf("VIRTUAL_HOST = %q, want the compose name over the directory", got)
+	}
+	if result.Override.Networks != nil || result.Override.Services["web"].Networks != nil {
+		t.Errorf("networks added without --network: %+v", result.Override)
+	}
+
+	if _, err := buildComposeOverride([]byte("services: {}\n"), overrideOptions{}); err == nil {
+		t.Error("expected an error for a compose file without services")
+	}
+}
+
+func TestComposeOverrideCommand(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "shop")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "compose.yml")
+	if err := os.WriteFile(file, []byte(overrideComposeFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	root := newRootCommand(&app{})
+	root.SetOut(&out)
+	root.SetArgs([]string{"compose-override", file})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	for _, want := range []string{
+		"# Generated by spark-http-proxy compose-override from compose.yml\n",
+		"# skipped db: no HTTP port published or exposed\n",
+		"      VIRTUAL_HOST: web.shop.loc\n",
+		"      VIRTUAL_PORT: \"80\"\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	proxyclient "github.com/sparkfabrik/http-proxy/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+// errProbeFailed makes the command exit non-zero once the report is printed;
+// main does not print it again.
+var errProbeFailed = errors.New("probe failed")
+
+func newProbeCommand(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "probe",
+		Short: "Diagnose a route through the proxy",
+	}
+	cmd.AddCommand(newProbeWebSocketCommand(a))
+	return cmd
+}
+
+func newProbeWebSocketCommand(a *app) *cobra.Command {
+	var path string
+	cmd := &cobra.Command{
+		Use:   "websocket <hostname>",
+		Short: "Open a WebSocket to a route and report where the upgrade fails",
+		Long: "Sends a WebSocket upgrade for the hostname through the proxy and, once\n" +
+			"switched, a ping. A failure is reported with the stage that caused it:\n" +
+			"proxy (no router matched), middleware (a middleware of the route answered)\n" +
+			"or backend (the app did not switch protocols or exchange frames).",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			probe, err := a.adminClient().ProbeWebSocket(cmd.Context(), args[0], path)
+			if err != nil {
+				if proxyclient.IsNotFound(err) {
+					return fmt.Errorf("no route serves %s", args[0])
+				}
+				return err
+			}
+			if a.format == formatJSON {
+				if err := writeJSON(cmd.OutOrStdout(), probe); err != nil {
+					return err
+				}
+			} else {
+				printWebSocketProbe(cmd.OutOrStdout(), probe)
+			}
+			if !probe.OK {
+				return errProbeFailed
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&path, "path", "/", "path to upgrade, with an optional query")
+	return cmd
+}
+
+// printWebSocketProbe prints the outcome of a WebSocket probe.
+func printWebSocketProbe(w io.Writer, probe proxyclient.WebSocketProbe) {
+	target := probe.Hostname + probe.Path
+	if probe.OK {
+		logSuccess(w, fmt.Sprintf("WebSocket to %s upgraded and answered a ping (%.1f ms)", target, probe.LatencyMS))
+		return
+	}
+
+	logError(w, fmt.Sprintf("WebSocket to %s failed at the %s: %s", target, probe.Stage, probe.Error))
+	if probe.ContainerName != "" {
+		logInfo(w, "Container: "+probe.ContainerName)
+	}
+	if len(probe.Middlewares) > 0 {
+		logInfo(w, "Middlewares: "+strings.Join(probe.Middlewares, ", "))
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/sparkfabrik/http-proxy/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+// profileList is the profiles found in the profiles directory and the one
+// selected.
+type profileList struct {
+	Dir      string   `json:"dir"`
+	Active   string   `json:"active,omitempty"`
+	Profiles []string `json:"profiles"`
+}
+
+func newProfileCommand(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "List and select configuration profiles",
+		Long: "Profiles are env files named <profile>.env in the profiles directory,\n" +
+			"applied over the environment of the DNS server (e.g. office.env setting\n" +
+			"HTTP_PROXY_DNS_UPSTREAM_SERVERS). The selection is picked up within seconds,\n" +
+			"without a restart.",
+	}
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the profiles and the one selected",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				list, err := a.profiles()
+				if err != nil {
+					return err
+				}
+				if a.format == formatJSON {
+					return writeJSON(cmd.OutOrStdout(), list)
+				}
+				printProfiles(cmd.OutOrStdout(), list)
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:               "use <profile>",
+			Short:             "Select a profile",
+			Args:              cobra.ExactArgs(1),
+			ValidArgsFunction: a.completeProfiles,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if err := config.WriteActiveProfile(a.profilesDir, args[0]); err != nil {
+					return err
+				}
+				logSuccess(cmd.OutOrStdout(), "Selected profile "+args[0])
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "clear",
+			Short: "Stop applying a profile",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if err := config.WriteActiveProfile(a.profilesDir, ""); err != nil {
+					return err
+				}
+				logSuccess(cmd.OutOrStdout(), "No profile selected")
+				return nil
+			},
+		},
+	)
+	return cmd
+}
+
+// profiles lists the profiles directory.
+func (a *app) profiles() (profileList, error) {
+	names, err := config.ListProfiles(a.profilesDir)
+	if err != nil {
+		return profileList{}, err
+	}
+	active, err := config.ReadActiveProfile(a.profilesDir)
+	if err != nil {
+		return profileList{}, err
+	}
+	if names == nil {
+		names = []string{}
+	}
+	return profileList{Dir: a.profilesDir, Active: active, Profiles: names}, nil
+}
+
+// completeProfiles offers the profile names for completion.
+func (a *app) completeProfiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	names, _ := config.ListProfiles(a.profilesDir)
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// printProfiles prints the profiles, marking the selected one.
+func printProfiles(w io.Writer, list profileList) {
+	if len(list.Profiles) == 0 {
+		logInfo(w, "No profiles in "+list.Dir)
+		return
+	}
+	for _, name := range list.Profiles {
+		marker := " "
+		if name == list.Active {
+			marker = "*"
+		}
+		fmt.Fprintf(w, "%s %s\n", marker, name)
+	}
+	if list.Active == "" {
+		logInfo(w, "No profile selected")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	proxyclient "github.com/sparkfabrik/http-proxy/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+func newRoutesCommand(a *app) *cobra.Command {
+	var all bool
+	cmd := &cobra.Command{
+		Use:   "routes",
+		Short: "List the routes served by the proxy",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			routes, err := a.adminClient().Routes(cmd.Context(), all)
+			if err != nil {
+				return err
+			}
+			if a.format == formatJSON {
+				return writeJSON(cmd.OutOrStdout(), routes)
+			}
+			return printRoutes(cmd.OutOrStdout(), routes)
+		},
+	}
+	cmd.Flags().BoolVar(&all, "all", false, "include the parked and crashed routes of stopped containers")
+	return cmd
+}
+
+// printRoutes prints routes as a table. Routes restricted to a path show it
+// after each hostname.
+func printRoutes(w io.Writer, routes []proxyclient.Route) error {
+	if len(routes) == 0 {
+		logInfo(w, "No routes configured")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTAINER\tHOSTNAMES\tBACKEND\tSTATUS")
+	for _, r := range routes {
+		status := r.Status
+		if status == "" {
+			status = "-"
+		}
+		hostnames := r.Hostnames
+		if r.Path != "" {
+			hostnames = make([]string, len(r.Hostnames))
+			for i, hostname := range r.Hostnames {
+				hostnames[i] = hostname + r.Path
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.ContainerName, strings.Join(hostnames, ","), r.BackendURL, status)
+	}
+	return tw.Flush()
+}
+
+func newProjectsCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "projects",
+		Short: "List the URLs of each compose project",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			projects, err := a.adminClient().Projects(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if a.format == formatJSON {
+				return writeJSON(cmd.OutOrStdout(), projects)
+			}
+			return printProjects(cmd.OutOrStdout(), projects)
+		},
+	}
+}
+
+// printProjects prints each project with its URLs, one per line.
+func printProjects(w io.Writer, projects []proxyclient.Project) error {
+	if len(projects) == 0 {
+		logInfo(w, "No compose project routed")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROJECT\tCONTAINERS\tURL")
+	for _, p := range projects {
+		urls := p.URLs
+		if len(urls) == 0 {
+			urls = []string{"-"}
+		}
+		for i, url := range urls {
+			if i == 0 {
+				fmt.Fprintf(tw, "%s\t%d\t%s\n", p.Project, len(p.Containers), url)
+			} else {
+				fmt.Fprintf(tw, "\t\t%s\n", url)
+			}
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	proxyclient "github.com/sparkfabrik/http-proxy/pkg/client"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// dnsStatusPath is where the DNS server publishes the port it bound
+const dnsStatusPath = "/var/lib/http-proxy/dns-server.json"
+
+// stackStatus is the status of the proxy stack.
+type stackStatus struct {
+	Running       bool              `json:"running"`
+	DashboardURL  string            `json:"dashboard_url,omitempty"`
+	DNS           *dnsStatus        `json:"dns,omitempty"`
+	Admin         adminStatus       `json:"admin"`
+	Networks      []string          `json:"networks,omitempty"`
+	JoinReasons   map[string]string `json:"join_reasons,omitempty"`
+	Monitoring    bool              `json:"monitoring"`
+	GrafanaURL    string            `json:"grafana_url,omitempty"`
+	PrometheusURL string            `json:"prometheus_url,omitempty"`
+	Services      []serviceStatus   `json:"services"`
+}
+
+// dnsStatus mirrors the status file written by the DNS server.
+type dnsStatus struct {
+	ConfiguredPort string   `json:"configured_port"`
+	Port           string   `json:"port"`
+	Fallback       bool     `json:"fallback"`
+	Domains        []string `json:"domains,omitempty"`
+	Profile        string   `json:"profile,omitempty"`
+}
+
+// adminStatus reports whether the admin API answers, how many routes it
+// serves and the hostnames served by several containers.
+type adminStatus struct {
+	Reachable  bool                        `json:"reachable"`
+	Routes     int                         `json:"routes"`
+	Collisions []proxyclient.HostCollision `json:"collisions,omitempty"`
+	Error      string                      `json:"error,omitempty"`
+}
+
+// serviceStatus is the state of one container of the stack.
+type serviceStatus struct {
+	Service   string `json:"service"`
+	Container string `json:"container"`
+	State     string `json:"state"`
+	Status    string `json:"status"`
+}
+
+func newStatusCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show HTTP proxy status",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			status, err := a.status(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if a.format == formatJSON {
+				return writeJSON(cmd.OutOrStdout(), status)
+			}
+			printStatus(cmd.OutOrStdout(), status)
+			return nil
+		},
+	}
+}
+
+// status collects the stack status from Docker and the admin API.
+func (a *app) status(ctx context.Context) (stackStatus, error) {
+	containers, err := a.projectContainers(ctx)
+	if err != nil {
+		return stackStatus{}, err
+	}
+
+	status := stackStatus{Services: []serviceStatus{}}
+	for i := range containers {
+		c := &containers[i]
+		status.Services = append(status.Services, serviceStatus{
+			Service:   utils.ComposeService(c.Labels),
+			Container: containerName(c),
+			State:     string(c.State),
+			Status:    c.Status,
+		})
+	}
+
+	traefik := serviceContainer(containers, "traefik")
+	status.Running = isRunning(traefik)
+	if !status.Running {
+		return status, nil
+	}
+
+	if port := publishedPort(traefik, 8080); port != 0 {
+		status.DashboardURL = fmt.Sprintf("http://localhost:%d", port)
+	}
+
+	admin := a.adminClient()
+	routes, err := admin.Routes(ctx, false)
+	if err != nil {
+		status.Admin.Error = err.Error()
+	} else {
+		status.Admin.Reachable = true
+		status.Admin.Routes = len(routes)
+		if collisions, err := admin.Collisions(ctx); err == nil {
+			status.Admin.Collisions = collisions
+		}
+	}
+
+	if dns := serviceContainer(containers, "dns"); isRunning(dns) {
+		status.DNS = a.dnsStatus(ctx, dns.ID, status.Admin.Reachable)
+	}
+
+	if status.Admin.Reachable {
+		if networks, err := admin.Networks(ctx); err == nil {
+			for _, n := range networks.Networks {
+				status.Networks = append(status.Networks, n.Name)
+				if n.Reason != "" {
+					if status.JoinReasons == nil {
+						status.JoinReasons = make(map[string]string)
+					}
+					status.JoinReasons[n.Name] = n.Reason
+				}
+			}
+		}
+	}
+
+	if prometheus := serviceContainer(containers, "prometheus"); isRunning(prometheus) {
+		status.Monitoring = true
+		if port := publishedPort(prometheus, 9090); port != 0 {
+			status.PrometheusURL = fmt.Sprintf("http://localhost:%d", port)
+		}
+		if port := publishedPort(serviceContainer(containers, "grafana"), 3000); port != 0 {
+			status.GrafanaURL = fmt.Sprintf("http://localhost:%d", port)
+		}
+	}
+
+	return status, nil
+}
+
+// dnsStatus asks the admin API for the DNS server status, falling back to
+// the status file in the DNS container when the API does not have it.
+func (a *app) dnsStatus(ctx context.Context, containerID string, adminReachable bool) *dnsStatus {
+	if adminReachable {
+		if domains, err := a.adminClient().DNSDomains(ctx); err == nil && domains.Port != "" {
+			return newDNSStatus(domains)
+		}
+	}
+
+	data, err := a.readContainerFile(ctx, containerID, dnsStatusPath)
+	if err != nil {
+		return nil
+	}
+	// The admin API serves the status file as it is
+	var domains proxyclient.DNSDomains
+	if json.Unmarshal(data, &domains) != nil || domains.Port == "" {
+		return nil
+	}
+	return newDNSStatus(domains)
+}
+
+// newDNSStatus summarizes the status published by the DNS server.
+func newDNSStatus(domains proxyclient.DNSDomains) *dnsStatus {
+	s := &dnsStatus{ConfiguredPort: domains.ConfiguredPort, Port: domains.Port, Fallback: domains.Fallback, Profile: domains.Profile}
+	for _, d := range domains.Domains {
+		s.Domains = append(s.Domains, d.Domain)
+	}
+	return s
+}
+
+// isRunning reports whether c exists and is running.
+func isRunning(c *container.Summary) bool {
+	return c != nil && c.State == container.StateRunning
+}
+
+// printStatus prints the status the way the shell script did.
+func printStatus(w io.Writer, status stackStatus) {
+	logInfo(w, "HTTP Proxy Status")
+	fmt.Fprintln(w, "==================================")
+
+	if !status.Running {
+		logWarning(w, "HTTP Proxy is not running")
+		fmt.Fprintln(w, "   🚀 Start with: spark-http-proxy start")
+		return
+	}
+
+	logSuccess(w, "HTTP Proxy is running")
+	fmt.Fprintf(w, "   🌐 Traefik Dashboard: %s\n", orNotAvailable(status.DashboardURL))
+	if status.DNS != nil {
+		fmt.Fprintf(w, "   🕸️  DNS Server: port %s\n", status.DNS.Port)
+		if status.DNS.Profile != "" {
+			fmt.Fprintf(w, "   🧭 DNS Profile: %s\n", status.DNS.Profile)
+		}
+		if len(status.DNS.Domains) > 0 {
+			fmt.Fprintf(w, "   🏷️  DNS Domains: %s\n", strings.Join(status.DNS.Domains, ", "))
+		}
+		if status.DNS.Port != status.DNS.ConfiguredPort {
+			logWarning(w, fmt.Sprintf("DNS port %s was busy, the DNS server fell back to port %s", status.DNS.ConfiguredPort, status.DNS.Port))
+		}
+	}
+	if status.Admin.Reachable {
+		fmt.Fprintf(w, "   🔀 Routes: %d\n", status.Admin.Routes)
+		for _, c := range status.Admin.Collisions {
+			message := fmt.Sprintf("%s%s is served by %s", c.Hostname, c.Path, strings.Join(c.Containers, ", "))
+			if c.Winner != "" {
+				message += fmt.Sprintf(" (%s wins, %s)", c.Winner, c.Policy)
+			}
+			logWarning(w, message)
+		}
+		if len(status.Networks) > 0 {
+			names := make([]string, 0, len(status.Networks))
+			for _, name := range status.Networks {
+				if reason := status.JoinReasons[name]; reason != "" {
+					name += " (" + reason + ")"
+				}
+				names = append(names, name)
+			}
+			fmt.Fprintf(w, "   🔗 Networks: %s\n", strings.Join(names, ", "))
+		}
+	} else {
+		logWarning(w, "Routes not available: "+status.Admin.Error)
+	}
+
+	fmt.Fprintln(w)
+	for _, s := range status.Services {
+		fmt.Fprintf(w, "   %-16s %-28s %s\n", s.Service, s.Container, s.Status)
+	}
+	fmt.Fprintln(w)
+
+	if status.Monitoring {
+		logSuccess(w, "Monitoring services are running")
+		fmt.Fprintf(w, "   📊 Grafana: %s (admin/admin)\n", orNotAvailable(status.GrafanaURL))
+		fmt.Fprintf(w, "   📈 Prometheus: %s\n", orNotAvailable(status.PrometheusURL))
+	} else {
+		logWarning(w, "Monitoring services are not running")
+		fmt.Fprintln(w, "   💡 Start with: spark-http-proxy start-with-metrics")
+	}
+}
+
+// orNotAvailable returns url, or a placeholder when it is empty.
+func orNotAvailable(url string) string {
+	if url == "" {
+		return "not available"
+	}
+	return url
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	proxyclient "github.com/sparkfabrik/http-proxy/pkg/client"
+	"github.com/sparkfabrik/http-proxy/pkg/state"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// defaultDrainTimeout is how long Traefik gets to finish the requests in
+	// flight before it is killed; it exceeds the entrypoints' graceTimeOut
+	defaultDrainTimeout = 15 * time.Second
+
+	// shutdownSnapshot names the state snapshot written by stop
+	shutdownSnapshot = "shutdown"
+)
+
+// Compose services stopped first, in this order; the others follow.
+const (
+	serviceDinghyLayer  = "dinghy_layer"
+	serviceJoinNetworks = "join_networks"
+	serviceTraefik      = "traefik"
+)
+
+// keptNetworks are the networks the proxy is never detached from.
+var keptNetworks = map[string]bool{"bridge": true, "host": true, "none": true}
+
+// shutdownState is what the stack served when stop ran, written to the
+// state directory for the next start or a bug report.
+type shutdownState struct {
+	StoppedAt        time.Time           `json:"stopped_at"`
+	Routes           []proxyclient.Route `json:"routes"`
+	RemovedConfigs   int                 `json:"removed_configs"`
+	DetachedNetworks []string            `json:"detached_networks"`
+	StoppedServices  []string            `json:"stopped_services"`
+	Warnings         []string            `json:"warnings,omitempty"`
+}
+
+func newStopCommand(a *app) *cobra.Command {
+	var drain time.Duration
+	cmd := &cobra.Command{
+		Use:   "stop",
+		Short: "Stop HTTP proxy, leaving no generated configs or network attachments behind",
+		Long: "Stop the proxy stack in a safe order: remove the generated dynamic configs, " +
+			"stop join-networks, let Traefik drain the requests in flight, detach the proxy " +
+			"from the project networks, stop the other services and write a state snapshot.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if drain < 0 {
+				return fmt.Errorf("invalid drain timeout %s", drain)
+			}
+			progress := cmd.OutOrStdout()
+			if a.format == formatJSON {
+				progress = io.Discard
+			}
+
+			result, err := a.stop(cmd.Context(), drain, progress)
+			if err != nil {
+				return err
+			}
+			if result == nil {
+				logInfo(progress, "HTTP Proxy is not running")
+				return nil
+			}
+			if a.format == formatJSON {
+				return writeJSON(cmd.OutOrStdout(), result)
+			}
+			for _, warning := range result.Warnings {
+				logWarning(progress, warning)
+			}
+			logSuccess(progress, "HTTP Proxy stopped")
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&drain, "drain-timeout", defaultDrainTimeout, "how long Traefik gets to finish the requests in flight")
+	return cmd
+}
+
+// stop runs the shutdown steps in order and returns the snapshot it wrote,
+// or nil when no service of the stack is running. A failing step is recorded
+// as a warning and does not stop the next ones: the stack is stopped anyway.
+func (a *app) stop(ctx context.Context, drain time.Duration, progress io.Writer) (*shutdownState, error) {
+	containers, err := a.projectContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	order := stopOrder(containers)
+	if len(order) == 0 {
+		return nil, nil
+	}
+	docker, err := a.dockerClient()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &shutdownState{StoppedAt: time.Now().UTC(), DetachedNetworks: []string{}, StoppedServices: []string{}}
+	warn := func(format string, args ...any) {
+		result.Warnings = append(result.Warnings, fmt.Sprintf(format, args...))
+	}
+
+	// The admin API is gone once the layer stops, so ask it first
+	admin := a.adminClient()
+	if routes, err := admin.Routes(ctx, false); err != nil {
+		warn("could not read the routes: %v", err)
+	} else {
+		result.Routes = routes
+	}
+	logInfo(progress, "Removing generated configs...")
+	if shutdown, err := admin.Shutdown(ctx); err != nil {
+		warn("could not remove the generated configs: %v", err)
+	} else {
+		result.RemovedConfigs = shutdown.Removed
+	}
+
+	var proxyID string
+	for _, c := range order {
+		name := utils.ComposeService(c.Labels)
+		options := container.StopOptions{}
+		if name == serviceTraefik {
+			proxyID = c.ID
+			seconds := int(drain.Round(time.Second) / time.Second)
+			options.Timeout = &seconds
+			logInfo(progress, fmt.Sprintf("Draining Traefik (up to %s)...", drain))
+		}
+		if err := docker.ContainerStop(ctx, c.ID, options); err != nil {
+			warn("could not stop %s: %v", containerName(c), err)
+			continue
+		}
+		result.StoppedServices = append(result.StoppedServices, name)
+
+		// join-networks is stopped before Traefik, so the detach below is
+		// not undone; the remaining services do not need the networks
+		if name == serviceTraefik {
+			result.DetachedNetworks = a.detachProxy(ctx, docker, proxyID, warn, progress)
+		}
+	}
+
+	if err := state.NewStore(a.stateDir()).Write(shutdownSnapshot, result); err != nil {
+		warn("could not write the state snapshot: %v", err)
+	}
+	return result, nil
+}
+
+// detachProxy disconnects the stopped proxy container from the project
+// networks join-networks attached it to and returns their names.
+func (a *app) detachProxy(ctx context.Context, docker *client.Client, proxyID string, warn func(string, ...any), progress io.Writer) []string {
+	detached := []string{}
+	inspect, err := docker.ContainerInspect(ctx, proxyID)
+	if err != nil {
+		warn("could not inspect the proxy container: %v", err)
+		return detached
+	}
+	if inspect.NetworkSettings == nil {
+		return detached
+	}
+
+	for _, name := range detachableNetworks(inspect.NetworkSettings.Networks, a.project) {
+		logInfo(progress, "Leaving network "+name)
+		if err := docker.NetworkDisconnect(ctx, name, proxyID, true); err != nil {
+			warn("could not leave network %s: %v", name, err)
+			continue
+		}
+		detached = append(detached, name)
+	}
+	return detached
+}
+
+// stateDir is where stop writes its snapshot.
+func (a *app) stateDir() string {
+	return filepath.Join(a.configDir, "state")
+}
+
+// stopOrder returns the running containers of the stack in the order they
+// are stopped: the dinghy layer, join-networks and Traefik, then the others
+// by name.
+func stopOrder(containers []container.Summary) []*container.Summary {
+	rank := map[string]int{serviceDinghyLayer: 0, serviceJoinNetworks: 1, serviceTraefik: 2}
+	rankOf := func(c *container.Summary) int {
+		if r, ok := rank[utils.ComposeService(c.Labels)]; ok {
+			return r
+		}
+		return len(rank)
+	}
+
+	var order []*container.Summary
+	for i := range containers {
+		if containers[i].State == container.StateRunning {
+			order = append(order, &containers[i])
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		if ri, rj := rankOf(order[i]), rankOf(order[j]); ri != rj {
+			return ri < rj
+		}
+		return containerName(order[i]) < containerName(order[j])
+	})
+	return order
+}
+
+// detachableNetworks returns the sorted names of the networks the proxy
+// joined for projects: all of them except Docker's own and the stack's.
+func detachableNetworks(networks map[string]*network.EndpointSettings, project string) []string {
+	var names []string
+	for name := range networks {
+		if keptNetworks[name] || strings.HasPrefix(name, project+"_") {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
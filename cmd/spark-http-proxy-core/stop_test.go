@@ -0,0 +1,50 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/sparkfabrik/http-proxy/pkg/service"
+)
+
+func TestStopOrder(t *testing.T) {
+	summary := func(name, svc string, state container.ContainerState) container.Summary {
+		return container.Summary{
+			Names:  []string{"/" + name},
+			State:  state,
+			Labels: map[string]string{service.ComposeServiceLabel: svc},
+		}
+	}
+	containers := []container.Summary{
+		summary("http-proxy-grafana", "grafana", container.StateRunning),
+		summary("http-proxy", serviceTraefik, container.StateRunning),
+		summary("http-proxy-dns-1", "dns", container.StateRunning),
+		summary("http-proxy-prometheus", "prometheus", container.StateExited),
+		summary("http-proxy-join_networks-1", serviceJoinNetworks, container.StateRunning),
+		summary("http-proxy-dinghy_layer-1", serviceDinghyLayer, container.StateRunning),
+	}
+
+	var got []string
+	for _, c := range stopOrder(containers) {
+		got = append(got, containerName(c))
+	}
+	want := []string{"http-proxy-dinghy_layer-1", "http-proxy-join_networks-1", "http-proxy", "http-proxy-dns-1", "http-proxy-grafana"}
+	if !slices.Equal(got, want) {
+		t.Errorf("stopOrder() = %v, want %v", got, want)
+	}
+}
+
+func TestDetachableNetworks(t *testing.T) {
+	networks := map[string]*network.EndpointSettings{
+		"bridge":             {},
+		"http-proxy_default": {},
+		"shop_default":       {},
+		"blog_backend":       {},
+	}
+	want := []string{"blog_backend", "shop_default"}
+	if got := detachableNetworks(networks, "http-proxy"); !slices.Equal(got, want) {
+		t.Errorf("detachableNetworks() = %v, want %v", got, want)
+	}
+}
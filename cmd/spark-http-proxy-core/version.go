@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// versionFile is where the images record the git version they were built from
+const versionFile = "/.version"
+
+// stackServices are the services whose image version is reported.
+var stackServices = []string{"traefik", "dinghy_layer", "join_networks", "dns"}
+
+// versionInfo is the version of the CLI and of the stack containers.
+type versionInfo struct {
+	CLI      string           `json:"cli"`
+	Services []serviceVersion `json:"services"`
+	Mismatch bool             `json:"mismatch"`
+}
+
+// serviceVersion is the image version of a stack service; State is empty
+// when its container does not exist.
+type serviceVersion struct {
+	Service string `json:"service"`
+	Version string `json:"version"`
+	State   string `json:"state,omitempty"`
+}
+
+func newVersionCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Show version information for all services",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			info, err := a.versions(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if a.format == formatJSON {
+				return writeJSON(cmd.OutOrStdout(), info)
+			}
+			printVersions(cmd.OutOrStdout(), info)
+			return nil
+		},
+	}
+}
+
+// versions reads the version file of each stack container.
+func (a *app) versions(ctx context.Context) (versionInfo, error) {
+	containers, err := a.projectContainers(ctx)
+	if err != nil {
+		return versionInfo{}, err
+	}
+
+	info := versionInfo{CLI: version}
+	for _, name := range stackServices {
+		sv := serviceVersion{Service: name, Version: "unknown"}
+		if c := serviceContainer(containers, name); c != nil {
+			sv.State = string(c.State)
+			if data, err := a.readContainerFile(ctx, c.ID, versionFile); err == nil {
+				sv.Version = strings.TrimSpace(string(data))
+			}
+			if version != "unknown" && sv.Version != "unknown" && sv.Version != version {
+				info.Mismatch = true
+			}
+		}
+		info.Services = append(info.Services, sv)
+	}
+	return info, nil
+}
+
+// printVersions prints the versions the way the shell script did.
+func printVersions(w io.Writer, info versionInfo) {
+	fmt.Fprintln(w, "spark-http-proxy version information:")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "  CLI version: %s\n", info.CLI)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Container versions:")
+	fmt.Fprintln(w, "-------------------")
+	for _, sv := range info.Services {
+		if sv.State == "" {
+			fmt.Fprintf(w, "  %s: not built\n", sv.Service)
+			continue
+		}
+		fmt.Fprintf(w, "  %s: %s (%s)\n", sv.Service, sv.Version, sv.State)
+	}
+
+	if info.Mismatch {
+		fmt.Fprintln(w)
+		logWarning(w, "CLI version differs from container versions.")
+		fmt.Fprintln(w, "   Consider running 'spark-http-proxy start' to rebuild with latest changes.")
+	}
+}
@@ -0,0 +1,576 @@
+// Package client is a Go client for the dinghy-layer admin API and the proxy
+// DNS server, for tools that integrate with the proxy programmatically
+// instead of shelling out to spark-http-proxy.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// DefaultAdminURL is where compose.yml publishes the admin API
+	DefaultAdminURL = "http://127.0.0.1:30002"
+
+	// DefaultDNSAddr is where compose.yml publishes the DNS server
+	DefaultDNSAddr = "127.0.0.1:19322"
+
+	// defaultTimeout bounds a single request
+	defaultTimeout = 5 * time.Second
+)
+
+// Route is an entry of the admin API route list. Status is "healthy",
+// "degraded" or "unknown" for served routes, and "parked" or "crashed" for
+// the routes of stopped containers. Source is "virtual_host",
+// "traefik_labels" or "static". Project is the container's compose project.
+type Route struct {
+	ContainerID   string            `json:"container_id"`
+	ContainerName string            `json:"container_name"`
+	Hostnames     []string          `json:"hostnames"`
+	Path          string            `json:"path,omitempty"`
+	BackendURL    string            `json:"backend_url"`
+	Source        string            `json:"source,omitempty"`
+	Project       string            `json:"project,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	Status        string            `json:"status"`
+	StoppedAt     *time.Time        `json:"stopped_at,omitempty"`
+	ExitCode      *int              `json:"exit_code,omitempty"`
+}
+
+// Project lists the containers of a compose project and the URLs their
+// routes answer on.
+type Project struct {
+	Project    string   `json:"project"`
+	Containers []string `json:"containers"`
+	URLs       []string `json:"urls"`
+}
+
+// HostCollision is a hostname served by several containers, ordered by
+// Policy with the Winner first; Winner is empty when the policy only warns.
+type HostCollision struct {
+	Hostname   string   `json:"hostname"`
+	Path       string   `json:"path,omitempty"`
+	Containers []string `json:"containers"`
+	Winner     string   `json:"winner,omitempty"`
+	Policy     string   `json:"policy"`
+}
+
+// StaticRoute sends hostnames to a backend that is not a managed container.
+type StaticRoute struct {
+	Name       string   `json:"name"`
+	Hostnames  []string `json:"hostnames"`
+	BackendURL string   `json:"backend_url"`
+}
+
+// RegenerateResult is the outcome of regenerating a container's config.
+// Status is "regenerated", or "removed" when the container is no longer
+// managed.
+type RegenerateResult struct {
+	ContainerID   string   `json:"container_id"`
+	ContainerName string   `json:"container_name"`
+	Status        string   `json:"status"`
+	Hostnames     []string `json:"hostnames,omitempty"`
+}
+
+// DriftEntry is one config file that does not match its container. Drift is
+// "missing", "modified" or "orphaned".
+type DriftEntry struct {
+	ContainerID   string `json:"container_id"`
+	ContainerName string `json:"container_name,omitempty"`
+	ConfigFile    string `json:"config_file"`
+	Drift         string `json:"drift"`
+}
+
+// ReconcileReport is the outcome of a reconciliation.
+type ReconcileReport struct {
+	Checked  int          `json:"checked"`
+	Drift    []DriftEntry `json:"drift"`
+	Repaired bool         `json:"repaired"`
+}
+
+// ShutdownResult is the outcome of preparing the layer for a stack shutdown.
+type ShutdownResult struct {
+	Removed int `json:"removed"`
+}
+
+// BatchOperation is one operation of a batch: "pause" or "resume" of a
+// compose project, "regenerate" of all containers or a project's, or
+// "delete-orphans".
+type BatchOperation struct {
+	Op      string `json:"op"`
+	Project string `json:"project,omitempty"`
+}
+
+// BatchChange is a config file written or removed by a batch. Action is
+// "write" or "remove".
+type BatchChange struct {
+	ContainerID   string `json:"container_id"`
+	ContainerName string `json:"container_name,omitempty"`
+	Project       string `json:"project,omitempty"`
+	ConfigFile    string `json:"config_file"`
+	Action        string `json:"action"`
+}
+
+// BatchResult is the outcome of a batch. Applied is false for dry runs and
+// failed batches; Paused lists the paused projects.
+type BatchResult struct {
+	DryRun  bool          `json:"dry_run"`
+	Applied bool          `json:"applied"`
+	Changes []BatchChange `json:"changes"`
+	Paused  []string      `json:"paused"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// Container is an entry of the admin API container list: the virtual hosts
+// of a managed container and the routers generated for them.
+type Container struct {
+	ContainerID   string   `json:"container_id"`
+	ContainerName string   `json:"container_name"`
+	VirtualHosts  []string `json:"virtual_hosts"`
+	BackendURL    string   `json:"backend_url"`
+	Source        string   `json:"source,omitempty"`
+	Routers       []Router `json:"routers"`
+}
+
+// Router is a generated Traefik router.
+type Router struct {
+	Name        string   `json:"name"`
+	Rule        string   `json:"rule"`
+	EntryPoints []string `json:"entry_points,omitempty"`
+	Service     string   `json:"service"`
+	Middlewares []string `json:"middlewares,omitempty"`
+	Priority    int      `json:"priority,omitempty"`
+	TLS         bool     `json:"tls"`
+}
+
+// NetworkRef identifies a Docker network. Reason is the rule join-networks
+// joined it for, when known.
+type NetworkRef struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Networks is the latest network change recorded by join-networks, with
+// the networks the proxy container is attached to.
+type Networks struct {
+	Container  string       `json:"container"`
+	Generation uint64       `json:"generation"`
+	Trigger    string       `json:"trigger"`
+	Timestamp  time.Time    `json:"timestamp"`
+	Networks   []NetworkRef `json:"networks"`
+}
+
+// DNSDomain is a domain the DNS server answers and where its names resolve.
+type DNSDomain struct {
+	Domain     string `json:"domain"`
+	TargetIP   string `json:"target_ip"`
+	TargetIPv6 string `json:"target_ipv6,omitempty"`
+}
+
+// DNSDomains lists the domains of the DNS server, the port it bound and the
+// configuration profile in use (empty when none).
+type DNSDomains struct {
+	Domains        []DNSDomain `json:"domains"`
+	Port           string      `json:"port"`
+	ConfiguredPort string      `json:"configured_port"`
+	Fallback       bool        `json:"fallback"`
+	Profile        string      `json:"profile,omitempty"`
+}
+
+// ComponentHealth is the state of a part of the stack: "ok", "error" or
+// "unknown".
+type ComponentHealth struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// StackHealth is the health of the proxy stack; Status is "ok" or
+// "degraded".
+type StackHealth struct {
+	Status     string            `json:"status"`
+	Components []ComponentHealth `json:"components"`
+}
+
+// WebSocketProbe is the outcome of opening a WebSocket to a route through
+// the proxy. When it fails, Stage is where: "proxy", "middleware" or
+// "backend".
+type WebSocketProbe struct {
+	Hostname      string    `json:"hostname"`
+	Path          string    `json:"path"`
+	ContainerName string    `json:"container_name,omitempty"`
+	OK            bool      `json:"ok"`
+	Stage         string    `json:"stage,omitempty"`
+	StatusCode    int       `json:"status_code,omitempty"`
+	Middlewares   []string  `json:"middlewares,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	LatencyMS     float64   `json:"latency_ms"`
+	CheckedAt     time.Time `json:"checked_at"`
+}
+
+// Types of Event
+const (
+	EventContainerRouted = "container.routed"
+	EventRouteRemoved    = "route.removed"
+	EventNetworkJoined   = "network.joined"
+	EventNetworkLeft     = "network.left"
+	EventCertGenerated   = "cert.generated"
+)
+
+// Event is a change of the proxy state streamed by Events. Only the fields
+// of its Type are set: the container fields for routes, Network for networks
+// and Certificate (a file of the certs directory) for certificates.
+type Event struct {
+	ID            uint64      `json:"id"`
+	Type          string      `json:"type"`
+	Timestamp     time.Time   `json:"timestamp"`
+	ContainerID   string      `json:"container_id,omitempty"`
+	ContainerName string      `json:"container_name,omitempty"`
+	Hostnames     []string    `json:"hostnames,omitempty"`
+	BackendURL    string      `json:"backend_url,omitempty"`
+	Source        string      `json:"source,omitempty"`
+	Network       *NetworkRef `json:"network,omitempty"`
+	Certificate   string      `json:"certificate,omitempty"`
+}
+
+// DNSRecord is one answer of the proxy DNS server. Value is the address of
+// A and AAAA records and the target or text of other types.
+type DNSRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	TTL   uint32 `json:"ttl"`
+	Value string `json:"value"`
+}
+
+// APIError is returned when the admin API answers with an error status.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("admin API returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("admin API returned %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is an admin API 404.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Client talks to the admin API at AdminURL and the DNS server at DNSAddr.
+// It is safe for concurrent use.
+type Client struct {
+	AdminURL   string
+	DNSAddr    string
+	HTTPClient *http.Client
+}
+
+// New creates a client; empty addresses fall back to DefaultAdminURL and
+// DefaultDNSAddr.
+func New(adminURL, dnsAddr string) *Client {
+	if adminURL == "" {
+		adminURL = DefaultAdminURL
+	}
+	if dnsAddr == "" {
+		dnsAddr = DefaultDNSAddr
+	}
+	return &Client{
+		AdminURL:   adminURL,
+		DNSAddr:    dnsAddr,
+		HTTPClient: &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+// Routes lists the served routes and, with all, those of stopped containers.
+func (c *Client) Routes(ctx context.Context, all bool) ([]Route, error) {
+	path := "/routes"
+	if all {
+		path += "?all=true"
+	}
+	var routes []Route
+	if err := c.do(ctx, http.MethodGet, path, nil, &routes); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+// Projects lists the URLs served for each compose project.
+func (c *Client) Projects(ctx context.Context) ([]Project, error) {
+	var projects []Project
+	if err := c.do(ctx, http.MethodGet, "/projects", nil, &projects); err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+// Collisions lists the hostnames served by several containers.
+func (c *Client) Collisions(ctx context.Context) ([]HostCollision, error) {
+	var collisions []HostCollision
+	if err := c.do(ctx, http.MethodGet, "/collisions", nil, &collisions); err != nil {
+		return nil, err
+	}
+	return collisions, nil
+}
+
+// SetStaticRoute creates or replaces a static route.
+func (c *Client) SetStaticRoute(ctx context.Context, route StaticRoute) (StaticRoute, error) {
+	var result StaticRoute
+	err := c.do(ctx, http.MethodPut, "/static-routes/"+url.PathEscape(route.Name), route, &result)
+	return result, err
+}
+
+// DeleteStaticRoute removes a static route; IsNotFound tells a route that
+// does not exist.
+func (c *Client) DeleteStaticRoute(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/static-routes/"+url.PathEscape(name), nil, nil)
+}
+
+// Regenerate rewrites the config of a container, given by ID, short ID or
+// name.
+func (c *Client) Regenerate(ctx context.Context, container string) (RegenerateResult, error) {
+	var result RegenerateResult
+	err := c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(container)+"/regenerate", nil, &result)
+	return result, err
+}
+
+// Reconcile compares the generated configs with the dynamic directory and,
+// unless checkOnly, repairs the drift.
+func (c *Client) Reconcile(ctx context.Context, checkOnly bool) (ReconcileReport, error) {
+	path := "/reconcile"
+	if checkOnly {
+		path += "?check=true"
+	}
+	var report ReconcileReport
+	err := c.do(ctx, http.MethodPost, path, nil, &report)
+	return report, err
+}
+
+// Shutdown removes the generated configs and stops the layer generating new
+// ones until it restarts, before the stack stops.
+func (c *Client) Shutdown(ctx context.Context) (ShutdownResult, error) {
+	var result ShutdownResult
+	err := c.do(ctx, http.MethodPost, "/shutdown", nil, &result)
+	return result, err
+}
+
+// Batch runs operations as one transaction: either all their changes are
+// applied or none is. With dryRun, the changes are only reported.
+func (c *Client) Batch(ctx context.Context, ops []BatchOperation, dryRun bool) (BatchResult, error) {
+	body := struct {
+		Operations []BatchOperation `json:"operations"`
+		DryRun     bool             `json:"dry_run,omitempty"`
+	}{Operations: ops, DryRun: dryRun}
+	var result BatchResult
+	err := c.do(ctx, http.MethodPost, "/batch", body, &result)
+	return result, err
+}
+
+// Containers lists the managed containers with their virtual hosts and
+// routers.
+func (c *Client) Containers(ctx context.Context) ([]Container, error) {
+	var containers []Container
+	if err := c.do(ctx, http.MethodGet, "/containers", nil, &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+// Networks returns the networks the proxy is attached to; IsNotFound tells
+// that join-networks has not reported them yet.
+func (c *Client) Networks(ctx context.Context) (Networks, error) {
+	var networks Networks
+	err := c.do(ctx, http.MethodGet, "/networks", nil, &networks)
+	return networks, err
+}
+
+// DNSDomains returns the domains the DNS server answers; IsNotFound tells
+// that it has not reported them yet.
+func (c *Client) DNSDomains(ctx context.Context) (DNSDomains, error) {
+	var domains DNSDomains
+	err := c.do(ctx, http.MethodGet, "/dns/domains", nil, &domains)
+	return domains, err
+}
+
+// StackHealth checks the whole stack. A degraded stack returns its report
+// along with an *APIError.
+func (c *Client) StackHealth(ctx context.Context) (StackHealth, error) {
+	var health StackHealth
+	err := c.do(ctx, http.MethodGet, "/health", nil, &health)
+	return health, err
+}
+
+// ProbeWebSocket opens a WebSocket upgrade for hostname and path through
+// the proxy. A failed upgrade is reported in the result, not as an error;
+// IsNotFound tells that no route serves hostname.
+func (c *Client) ProbeWebSocket(ctx context.Context, hostname, path string) (WebSocketProbe, error) {
+	query := ""
+	if path != "" {
+		query = "?path=" + url.QueryEscape(path)
+	}
+	var probe WebSocketProbe
+	err := c.do(ctx, http.MethodGet, "/routes/"+url.PathEscape(hostname)+"/websocket"+query, nil, &probe)
+	return probe, err
+}
+
+// Events streams proxy events to fn until ctx is done, fn returns an error
+// or the admin API ends the stream. With a non-zero lastID, the events after
+// it that the API still holds are replayed first; pass the ID of the last
+// event handled to resume after a disconnect.
+func (c *Client) Events(ctx context.Context, lastID uint64, fn func(Event) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.AdminURL, "/")+"/events", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastID != 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(lastID, 10))
+	}
+
+	// The stream outlives the request timeout of the client
+	httpClient := http.Client{}
+	if c.HTTPClient != nil {
+		httpClient = *c.HTTPClient
+		httpClient.Timeout = 0
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("admin API unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &APIError{StatusCode: resp.StatusCode}
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+		case line == "" && data.Len() > 0:
+			var ev Event
+			if err := json.Unmarshal([]byte(data.String()), &ev); err != nil {
+				return fmt.Errorf("failed to decode event: %w", err)
+			}
+			data.Reset()
+			if err := fn(ev); err != nil {
+				return err
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
+
+// Health returns nil when the admin API answers its health check.
+func (c *Client) Health(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/healthz", nil, nil)
+}
+
+// LookupDNS asks the proxy DNS server for the records of name, with qtype
+// such as "A" or "AAAA". A name the server does not resolve returns no
+// records and no error.
+func (c *Client) LookupDNS(ctx context.Context, name, qtype string) ([]DNSRecord, error) {
+	t, ok := dns.StringToType[strings.ToUpper(qtype)]
+	if !ok {
+		return nil, fmt.Errorf("unknown record type %q", qtype)
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), t)
+	resp, _, err := (&dns.Client{Timeout: defaultTimeout}).ExchangeContext(ctx, query, c.DNSAddr)
+	if err != nil {
+		return nil, fmt.Errorf("DNS query failed: %w", err)
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("DNS server answered %s", dns.RcodeToString[resp.Rcode])
+	}
+
+	records := []DNSRecord{}
+	for _, rr := range resp.Answer {
+		header := rr.Header()
+		records = append(records, DNSRecord{
+			Name:  header.Name,
+			Type:  dns.TypeToString[header.Rrtype],
+			TTL:   header.Ttl,
+			Value: strings.TrimPrefix(rr.String(), header.String()),
+		})
+	}
+	return records, nil
+}
+
+// do sends an admin API request with body encoded as JSON and decodes the
+// answer into v, unless v is nil. The body of an error status is decoded
+// into v too when it parses.
+func (c *Client) do(ctx context.Context, method, path string, body, v any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.AdminURL, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("admin API unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read admin API response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var errBody struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(data, &errBody)
+		// Reports such as a degraded health check come with an error status
+		if v != nil {
+			json.Unmarshal(data, v)
+		}
+		return &APIError{StatusCode: resp.StatusCode, Message: errBody.Error}
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode admin API response: %w", err)
+	}
+	return nil
+}
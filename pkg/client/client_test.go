@@ -0,0 +1,268 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestRoutes(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/routes" || r.URL.Query().Get("all") != "true" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		w.Write([]byte(`[{"container_id":"abc","container_name":"web","hostnames":["web.loc"],"backend_url":"http://172.17.0.2:80","source":"virtual_host","status":"crashed","exit_code":1}]`))
+	}))
+	defer admin.Close()
+
+	routes, err := New(admin.URL+"/", "").Routes(t.Context(), true)
+	if err != nil {
+		t.Fatalf("Routes() error = %v", err)
+	}
+	if len(routes) != 1 || routes[0].ContainerName != "web" || routes[0].ExitCode == nil || *routes[0].ExitCode != 1 {
+		t.Errorf("Routes() = %+v", routes)
+	}
+}
+
+func TestProjects(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/projects" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		w.Write([]byte(`[{"project":"shop","containers":["shop-web-1"],"urls":["https://shop.loc"]}]`))
+	}))
+	defer admin.Close()
+
+	projects, err := New(admin.URL+"/", "").Projects(t.Context())
+	if err != nil {
+		t.Fatalf("Projects() error = %v", err)
+	}
+	if len(projects) != 1 || projects[0].Project != "shop" || len(projects[0].URLs) != 1 {
+		t.Errorf("Projects() = %+v", projects)
+	}
+}
+
+func TestStaticRoutes(t *testing.T) {
+	stored := map[string]StaticRoute{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /static-routes/{name}", func(w http.ResponseWriter, r *http.Request) {
+		var route StaticRoute
+		json.NewDecoder(r.Body).Decode(&route)
+		route.Name = r.PathValue("name")
+		stored[route.Name] = route
+		json.NewEncoder(w).Encode(route)
+	})
+	mux.HandleFunc("DELETE /static-routes/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := stored[r.PathValue("name")]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"static route not found"}`))
+			return
+		}
+		delete(stored, r.PathValue("name"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	admin := httptest.NewServer(mux)
+	defer admin.Close()
+
+	c := New(admin.URL, "")
+	route := StaticRoute{Name: "docs", Hostnames: []string{"docs.loc"}, BackendURL: "http://10.0.0.5:3000"}
+	got, err := c.SetStaticRoute(t.Context(), route)
+	if err != nil || got.BackendURL != route.BackendURL {
+		t.Fatalf("SetStaticRoute() = %+v, %v", got, err)
+	}
+	if stored["docs"].Hostnames[0] != "docs.loc" {
+		t.Errorf("server received %+v", stored["docs"])
+	}
+
+	if err := c.DeleteStaticRoute(t.Context(), "docs"); err != nil {
+		t.Errorf("DeleteStaticRoute() error = %v", err)
+	}
+	err = c.DeleteStaticRoute(t.Context(), "docs")
+	if !IsNotFound(err) || err.Error() != "admin API returned 404: static route not found" {
+		t.Errorf("second DeleteStaticRoute() error = %v, want a 404 APIError", err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Operations []BatchOperation `json:"operations"`
+			DryRun     bool             `json:"dry_run"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.Method != http.MethodPost || r.URL.Path != "/batch" || !req.DryRun || len(req.Operations) != 2 {
+			t.Errorf("unexpected request %s %s %+v", r.Method, r.URL, req)
+		}
+		w.Write([]byte(`{"dry_run":true,"applied":false,"changes":[{"container_id":"abc","config_file":"abc.yaml","action":"remove"}],"paused":["shop"]}`))
+	}))
+	defer admin.Close()
+
+	ops := []BatchOperation{{Op: "pause", Project: "shop"}, {Op: "delete-orphans"}}
+	result, err := New(admin.URL, "").Batch(t.Context(), ops, true)
+	if err != nil || !result.DryRun || len(result.Changes) != 1 || result.Changes[0].Action != "remove" || result.Paused[0] != "shop" {
+		t.Errorf("Batch() = %+v, %v", result, err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/shutdown" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		w.Write([]byte(`{"removed":3}`))
+	}))
+	defer admin.Close()
+
+	if result, err := New(admin.URL, "").Shutdown(t.Context()); err != nil || result.Removed != 3 {
+		t.Errorf("Shutdown() = %+v, %v", result, err)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	if err := New(admin.URL, "").Health(t.Context()); err != nil {
+		t.Errorf("Health() error = %v", err)
+	}
+	admin.Close()
+
+	if err := New(admin.URL, "").Health(t.Context()); err == nil {
+		t.Error("Health() of a closed server returned no error")
+	}
+}
+
+func TestStackStatus(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /containers", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"container_id":"abc","container_name":"web","virtual_hosts":["web.loc"],"routers":[{"name":"web-0","rule":"Host(web.loc)","service":"web","tls":false}]}]`))
+	})
+	mux.HandleFunc("GET /networks", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"container":"http-proxy","generation":2,"networks":[{"id":"n1","name":"shop_default"}]}`))
+	})
+	mux.HandleFunc("GET /dns/domains", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"dns-server has not reported its state yet"}`))
+	})
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status":"degraded","components":[{"name":"docker","status":"error","error":"timeout"}]}`))
+	})
+	admin := httptest.NewServer(mux)
+	defer admin.Close()
+	c := New(admin.URL, "")
+
+	containers, err := c.Containers(t.Context())
+	if err != nil || len(containers) != 1 || containers[0].Routers[0].Rule != "Host(web.loc)" {
+		t.Errorf("Containers() = %+v, %v", containers, err)
+	}
+	networks, err := c.Networks(t.Context())
+	if err != nil || len(networks.Networks) != 1 || networks.Networks[0].Name != "shop_default" {
+		t.Errorf("Networks() = %+v, %v", networks, err)
+	}
+	if _, err := c.DNSDomains(t.Context()); !IsNotFound(err) {
+		t.Errorf("DNSDomains() error = %v, want a 404", err)
+	}
+
+	// A degraded stack returns its report with the error
+	health, err := c.StackHealth(t.Context())
+	if err == nil || health.Status != "degraded" || len(health.Components) != 1 {
+		t.Errorf("StackHealth() = %+v, %v", health, err)
+	}
+}
+
+func TestProbeWebSocket(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/routes/chat.loc/websocket" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"no route serves it"}`))
+			return
+		}
+		w.Write([]byte(`{"hostname":"chat.loc","path":"` + r.URL.Query().Get("path") + `","ok":false,"stage":"middleware","status_code":401}`))
+	}))
+	defer admin.Close()
+	c := New(admin.URL, "")
+
+	probe, err := c.ProbeWebSocket(t.Context(), "chat.loc", "/ws?room=1")
+	if err != nil || probe.OK || probe.Stage != "middleware" || probe.Path != "/ws?room=1" {
+		t.Errorf("ProbeWebSocket() = %+v, %v", probe, err)
+	}
+	if _, err := c.ProbeWebSocket(t.Context(), "other.loc", ""); !IsNotFound(err) {
+		t.Errorf("ProbeWebSocket() of an unrouted host error = %v, want a 404", err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Last-Event-ID") != "4" {
+			t.Errorf("Last-Event-ID = %q, want 4", r.Header.Get("Last-Event-ID"))
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(": keep-alive\n\n"))
+		w.Write([]byte("id: 5\nevent: container.routed\ndata: {\"id\":5,\"type\":\"container.routed\",\"container_name\":\"web\",\"hostnames\":[\"web.loc\"]}\n\n"))
+		w.Write([]byte("id: 6\nevent: network.left\ndata: {\"id\":6,\"type\":\"network.left\",\"network\":{\"id\":\"n1\",\"name\":\"shop_default\"}}\n\n"))
+	}))
+	defer admin.Close()
+	c := New(admin.URL, "")
+
+	var events []Event
+	err := c.Events(t.Context(), 4, func(ev Event) error {
+		events = append(events, ev)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Events() error = %v", err)
+	}
+	if len(events) != 2 || events[0].Type != EventContainerRouted || events[0].Hostnames[0] != "web.loc" ||
+		events[1].Network == nil || events[1].Network.Name != "shop_default" {
+		t.Errorf("events = %+v", events)
+	}
+
+	// fn stops the stream with its error
+	stop := errors.New("stop")
+	if err := c.Events(t.Context(), 4, func(Event) error { return stop }); err != stop {
+		t.Errorf("Events() error = %v, want the error of fn", err)
+	}
+}
+
+func TestLookupDNS(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Name == "web.loc." {
+			rr, _ := dns.NewRR("web.loc. 60 IN A 127.0.0.1")
+			m.Answer = append(m.Answer, rr)
+		} else {
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m)
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	c := New("", conn.LocalAddr().String())
+	records, err := c.LookupDNS(t.Context(), "web.loc", "a")
+	if err != nil {
+		t.Fatalf("LookupDNS() error = %v", err)
+	}
+	want := DNSRecord{Name: "web.loc.", Type: "A", TTL: 60, Value: "127.0.0.1"}
+	if len(records) != 1 || records[0] != want {
+		t.Errorf("LookupDNS() = %+v, want [%+v]", records, want)
+	}
+
+	if records, err := c.LookupDNS(t.Context(), "other.example", "A"); err != nil || len(records) != 0 {
+		t.Errorf("LookupDNS(NXDOMAIN) = %+v, %v", records, err)
+	}
+	if _, err := c.LookupDNS(t.Context(), "web.loc", "BOGUS"); err == nil {
+		t.Error("LookupDNS() accepted an unknown record type")
+	}
+}
@@ -1,41 +1,178 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
 )
 
 // Config holds common configuration values used across the application
 type Config struct {
-	Domains            []string // List of domains/TLDs to handle
-	DNSIP              string
-	DNSPort            string
-	DNSForwardEnabled  bool
-	DNSUpstreamServers []string
+	Domains             []string // List of domains/TLDs to handle
+	DNSIP               string
+	DNSIPv6             string // Target of AAAA answers (empty answers AAAA queries with NODATA)
+	DNSDomainMap        string // Per-domain targets overriding DNSIP and DNSIPv6
+	DNSClientMap        string // Per-client targets overriding the domain map and default targets
+	DNSPort             string
+	DNSForwardEnabled   bool
+	DNSUpstreamServers  []string
+	DNSUpstreamStrategy string   // race or sequential: how upstream servers are asked
+	DNSCacheFile        string   // Where the forwarding cache is persisted across restarts (empty disables)
+	DNSFallbackPorts    []string // Ports tried in order when DNSPort is already in use
+	DNSCacheSize        int      // Maximum number of cached forwarded answers (0 means unlimited)
+	DNSCacheMinTTL      int      // Lowest TTL (seconds) of cached forwarded answers (0 disables)
+	DNSCacheMaxTTL      int      // Highest TTL (seconds) of cached forwarded answers (0 disables)
+	DNSCacheStaleTTL    int      // How long (seconds) expired answers are served while upstreams fail (0 disables)
+	DNSMetricsAddr      string   // Listen address of the Prometheus metrics endpoint (empty disables)
+	DNSExtraRecords     string   // CNAME/TXT records answered for the configured domains
+	DNSDoHAddr          string   // Listen address of the DNS-over-HTTPS endpoint (empty disables)
+	DNSDoHCertFile      string   // TLS certificate of the DoH endpoint (empty serves plain HTTP)
+	DNSDoHKeyFile       string   // TLS key of the DoH endpoint
+	DNSDockerRecords    bool     // Answer the hostnames of running containers, whatever their domain
+	DNSQueryLog         bool     // Log a JSON record per query
+	DNSQueryLogSample   float64  // Fraction of queries the query log records (0 to 1)
+	DNSMDNSEnabled      bool     // Advertise the .local hostnames over multicast DNS
+	DNSMDNSIP           string   // Address advertised over mDNS (empty uses DNSIP)
+	DNSSelfTest         bool     // Query the server through its listener at startup
+	DNSSelfTestName     string   // External name resolved by the self-test when forwarding
+
+	DNSNXDomainProtection string   // off, nxdomain or requery: how rewritten NXDOMAIN answers are handled
+	DNSSinkholeIPs        []string // Extra addresses treated as NXDOMAIN rewrites
+	DNSCleanUpstream      string   // Resolver asked again in requery mode
+
+	DNSAllowedCIDRs []string // Client networks allowed to query (empty allows all)
+	DNSDeniedCIDRs  []string // Client networks refused, even when allowed
+	DNSRateLimit    int      // Queries per second answered per client IP (0 disables)
+
+	DNSBlocklist     []string // Domains answered with DNSBlockResponse, with their subdomains
+	DNSBlocklistFile string   // File of further blocked domains, one per line or in hosts file format
+	DNSBlockResponse string   // nxdomain or the sinkhole IP blocked names resolve to
+
+	DNSEmbeddedDomains []string // TLDs resolved through Docker's embedded DNS (empty disables)
+	DNSEmbeddedServer  string   // Address of Docker's embedded DNS server
+
+	Profile string   // Profile applied over the environment (empty when none)
+	Files   []string // Files the configuration was read from, watched for changes
 }
 
 // Load loads configuration from environment variables with defaults
 func Load() *Config {
+	return load(os.Getenv)
+}
+
+// LoadWithFile loads configuration in layers: the selected profile
+// (HTTP_PROXY_PROFILE, else the active file of HTTP_PROXY_PROFILES_DIR) over
+// the built-in defaults, then the non-empty environment variables, then the
+// variables set in an env file (see ReadEnvFile). An empty path skips the
+// file.
+func LoadWithFile(path string) (*Config, error) {
+	var overrides map[string]string
+	if path != "" {
+		values, err := ReadEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		overrides = values
+	}
+
+	cfg, err := loadLayered(overrides, os.Getenv)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		cfg.Files = append([]string{path}, cfg.Files...)
+	}
+	return cfg, nil
+}
+
+// load builds the configuration from the variables returned by getenv.
+func load(getenv func(string) string) *Config {
 	return &Config{
-		Domains:            GetEnvOrDefaultStringSlice("HTTP_PROXY_DNS_TLDS", []string{"loc"}),
-		DNSIP:              GetEnvOrDefault("HTTP_PROXY_DNS_TARGET_IP", "127.0.0.1"),
-		DNSPort:            GetEnvOrDefault("HTTP_PROXY_DNS_PORT", "19322"),
-		DNSForwardEnabled:  strings.ToLower(GetEnvOrDefault("HTTP_PROXY_DNS_FORWARD_ENABLED", "false")) == "true",
-		DNSUpstreamServers: GetEnvOrDefaultStringSlice("HTTP_PROXY_DNS_UPSTREAM_SERVERS", []string{"8.8.8.8:53", "1.1.1.1:53"}),
+		Domains:             getOrDefaultStringSlice(getenv, "HTTP_PROXY_DNS_TLDS", []string{"loc"}),
+		DNSIP:               getOrDefault(getenv, "HTTP_PROXY_DNS_TARGET_IP", "127.0.0.1"),
+		DNSIPv6:             getOrDefault(getenv, "HTTP_PROXY_DNS_TARGET_IPV6", ""),
+		DNSDomainMap:        getOrDefault(getenv, "HTTP_PROXY_DNS_DOMAIN_MAP", ""),
+		DNSClientMap:        getOrDefault(getenv, "HTTP_PROXY_DNS_CLIENT_MAP", ""),
+		DNSPort:             getOrDefault(getenv, "HTTP_PROXY_DNS_PORT", "19322"),
+		DNSForwardEnabled:   strings.ToLower(getOrDefault(getenv, "HTTP_PROXY_DNS_FORWARD_ENABLED", "false")) == "true",
+		DNSUpstreamServers:  getOrDefaultStringSlice(getenv, "HTTP_PROXY_DNS_UPSTREAM_SERVERS", []string{"8.8.8.8:53", "1.1.1.1:53"}),
+		DNSUpstreamStrategy: strings.ToLower(getOrDefault(getenv, "HTTP_PROXY_DNS_UPSTREAM_STRATEGY", "race")),
+		DNSCacheFile:        getOrDefault(getenv, "HTTP_PROXY_DNS_CACHE_FILE", ""),
+		DNSFallbackPorts:    getOrDefaultStringSlice(getenv, "HTTP_PROXY_DNS_FALLBACK_PORTS", nil),
+		DNSCacheSize:        getOrDefaultInt(getenv, "HTTP_PROXY_DNS_CACHE_SIZE", 10000),
+		DNSCacheMinTTL:      getOrDefaultInt(getenv, "HTTP_PROXY_DNS_CACHE_MIN_TTL", 10),
+		DNSCacheMaxTTL:      getOrDefaultInt(getenv, "HTTP_PROXY_DNS_CACHE_MAX_TTL", 86400),
+		DNSCacheStaleTTL:    getOrDefaultInt(getenv, "HTTP_PROXY_DNS_CACHE_STALE_TTL", 86400),
+		DNSMetricsAddr:      getOrDefault(getenv, "HTTP_PROXY_DNS_METRICS_ADDR", ":9153"),
+		DNSExtraRecords:     getOrDefault(getenv, "HTTP_PROXY_DNS_EXTRA_RECORDS", ""),
+		DNSDoHAddr:          getOrDefault(getenv, "HTTP_PROXY_DNS_DOH_ADDR", ""),
+		DNSDoHCertFile:      getOrDefault(getenv, "HTTP_PROXY_DNS_DOH_CERT_FILE", ""),
+		DNSDoHKeyFile:       getOrDefault(getenv, "HTTP_PROXY_DNS_DOH_KEY_FILE", ""),
+		DNSDockerRecords:    strings.ToLower(getOrDefault(getenv, "HTTP_PROXY_DNS_DOCKER_RECORDS", "false")) == "true",
+		DNSQueryLog:         strings.ToLower(getOrDefault(getenv, "HTTP_PROXY_DNS_QUERY_LOG", "false")) == "true",
+		DNSQueryLogSample:   getOrDefaultFloat(getenv, "HTTP_PROXY_DNS_QUERY_LOG_SAMPLE", 1),
+		DNSMDNSEnabled:      strings.ToLower(getOrDefault(getenv, "HTTP_PROXY_DNS_MDNS_ENABLED", "false")) == "true",
+		DNSMDNSIP:           getOrDefault(getenv, "HTTP_PROXY_DNS_MDNS_IP", ""),
+		DNSSelfTest:         strings.ToLower(getOrDefault(getenv, "HTTP_PROXY_DNS_SELF_TEST", "true")) == "true",
+		DNSSelfTestName:     getOrDefault(getenv, "HTTP_PROXY_DNS_SELF_TEST_NAME", "example.com"),
+
+		DNSNXDomainProtection: strings.ToLower(getOrDefault(getenv, "HTTP_PROXY_DNS_NXDOMAIN_PROTECTION", "off")),
+		DNSSinkholeIPs:        getOrDefaultStringSlice(getenv, "HTTP_PROXY_DNS_SINKHOLE_IPS", nil),
+		DNSCleanUpstream:      getOrDefault(getenv, "HTTP_PROXY_DNS_CLEAN_UPSTREAM", "1.1.1.1:53"),
+
+		DNSAllowedCIDRs: getOrDefaultStringSlice(getenv, "HTTP_PROXY_DNS_ALLOWED_CIDRS", nil),
+		DNSDeniedCIDRs:  getOrDefaultStringSlice(getenv, "HTTP_PROXY_DNS_DENIED_CIDRS", nil),
+		DNSRateLimit:    getOrDefaultInt(getenv, "HTTP_PROXY_DNS_RATE_LIMIT", 0),
+
+		DNSBlocklist:     getOrDefaultStringSlice(getenv, "HTTP_PROXY_DNS_BLOCKLIST", nil),
+		DNSBlocklistFile: getOrDefault(getenv, "HTTP_PROXY_DNS_BLOCKLIST_FILE", ""),
+		DNSBlockResponse: getOrDefault(getenv, "HTTP_PROXY_DNS_BLOCK_RESPONSE", "nxdomain"),
+
+		DNSEmbeddedDomains: getOrDefaultStringSlice(getenv, "HTTP_PROXY_DNS_EMBEDDED_TLDS", nil),
+		DNSEmbeddedServer:  getOrDefault(getenv, "HTTP_PROXY_DNS_EMBEDDED_SERVER", "127.0.0.11:53"),
 	}
 }
 
 // GetEnvOrDefault returns the environment variable value or a default if not set
 func GetEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+	return getOrDefault(os.Getenv, key, defaultValue)
+}
+
+// GetEnvOrDefaultInt returns an environment variable as an integer, or the
+// default if it is not set or not a valid integer
+func GetEnvOrDefaultInt(key string, defaultValue int) int {
+	return getOrDefaultInt(os.Getenv, key, defaultValue)
+}
+
+// GetEnvOrDefaultStringSlice returns an environment variable as a comma-separated slice or a default
+func GetEnvOrDefaultStringSlice(key string, defaultValue []string) []string {
+	return getOrDefaultStringSlice(os.Getenv, key, defaultValue)
+}
+
+func getOrDefault(getenv func(string) string, key, defaultValue string) string {
+	if value := getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
 
-// GetEnvOrDefaultStringSlice returns an environment variable as a comma-separated slice or a default
-func GetEnvOrDefaultStringSlice(key string, defaultValue []string) []string {
-	if value := os.Getenv(key); value != "" {
+func getOrDefaultInt(getenv func(string) string, key string, defaultValue int) int {
+	if value, err := strconv.Atoi(strings.TrimSpace(getenv(key))); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getOrDefaultFloat(getenv func(string) string, key string, defaultValue float64) float64 {
+	if value, err := strconv.ParseFloat(strings.TrimSpace(getenv(key)), 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getOrDefaultStringSlice(getenv func(string) string, key string, defaultValue []string) []string {
+	if value := getenv(key); value != "" {
 		result := []string{}
 		for _, item := range strings.Split(value, ",") {
 			item = strings.TrimSpace(item)
@@ -49,3 +186,28 @@ func GetEnvOrDefaultStringSlice(key string, defaultValue []string) []string {
 	}
 	return defaultValue
 }
+
+// ReadEnvFile reads KEY=VALUE lines, as in a Docker env file. Blank lines and
+// lines starting with # are ignored; values are taken literally, without
+// quote removal.
+func ReadEnvFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+
+	values := make(map[string]string)
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n+1)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
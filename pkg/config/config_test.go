@@ -1,6 +1,8 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -61,3 +63,55 @@ func TestGetEnvOrDefaultStringSlice(t *testing.T) {
 		}
 	})
 }
+
+func TestGetEnvOrDefaultInt(t *testing.T) {
+	t.Run("default when unset", func(t *testing.T) {
+		if got := GetEnvOrDefaultInt("HTTP_PROXY_TEST_INT_UNSET", 42); got != 42 {
+			t.Errorf("got %d, want 42", got)
+		}
+	})
+	t.Run("parses value", func(t *testing.T) {
+		t.Setenv("HTTP_PROXY_TEST_INT", " 100 ")
+		if got := GetEnvOrDefaultInt("HTTP_PROXY_TEST_INT", 42); got != 100 {
+			t.Errorf("got %d, want 100", got)
+		}
+	})
+	t.Run("default when invalid", func(t *testing.T) {
+		t.Setenv("HTTP_PROXY_TEST_INT_BAD", "lots")
+		if got := GetEnvOrDefaultInt("HTTP_PROXY_TEST_INT_BAD", 42); got != 42 {
+			t.Errorf("got %d, want 42", got)
+		}
+	})
+}
+
+func TestLoadWithFile(t *testing.T) {
+	t.Setenv("HTTP_PROXY_DNS_TLDS", "loc")
+	t.Setenv("HTTP_PROXY_DNS_TARGET_IP", "10.0.0.1")
+
+	path := filepath.Join(t.TempDir(), "dns.env")
+	content := "# local overrides\n\nHTTP_PROXY_DNS_TLDS=loc,test\nHTTP_PROXY_DNS_FORWARD_ENABLED=true\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadWithFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.Domains, []string{"loc", "test"}) || !cfg.DNSForwardEnabled {
+		t.Errorf("file values not applied: %+v", cfg)
+	}
+	if cfg.DNSIP != "10.0.0.1" {
+		t.Errorf("DNSIP = %q, want the environment value", cfg.DNSIP)
+	}
+
+	if err := os.WriteFile(path, []byte("not a variable\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadWithFile(path); err == nil {
+		t.Error("expected an error for a malformed line")
+	}
+	if _, err := LoadWithFile(filepath.Join(t.TempDir(), "missing.env")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DynamicFile is a Traefik dynamic configuration file read from disk. Err is
+// set instead of Config when the file could not be read or parsed, so one
+// broken file does not hide the rest of the directory.
+type DynamicFile struct {
+	Path   string
+	Config *TraefikConfig
+	Err    error
+}
+
+// ParseTraefikConfig parses Traefik dynamic configuration YAML. An empty
+// document yields an empty configuration.
+func ParseTraefikConfig(data []byte) (*TraefikConfig, error) {
+	cfg := &TraefikConfig{}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return cfg, nil
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse Traefik config: %w", err)
+	}
+	return cfg, nil
+}
+
+// LoadTraefikConfigFile reads and parses a single dynamic configuration file.
+func LoadTraefikConfigFile(path string) (*TraefikConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	cfg, err := ParseTraefikConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// IsDynamicConfigFile reports whether Traefik's file provider loads the named
+// file: YAML files that are neither hidden nor in-progress temporary writes.
+func IsDynamicConfigFile(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	ext := filepath.Ext(name)
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// LoadDynamicDir parses every dynamic configuration file directly inside dir,
+// sorted by name. Per-file failures are reported in DynamicFile.Err; an error
+// is returned only when the directory itself cannot be read.
+func LoadDynamicDir(dir string) ([]DynamicFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dynamic directory: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && IsDynamicConfigFile(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	files := make([]DynamicFile, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		cfg, err := LoadTraefikConfigFile(path)
+		files = append(files, DynamicFile{Path: path, Config: cfg, Err: err})
+	}
+	return files, nil
+}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// userConfig uses fields the structs do not model, as files written by hand do.
+const userConfig = `http:
+  routers:
+    app:
+      rule: Host(` + "`app.loc`" + `)
+      service: app
+      entryPoints:
+        - https
+      priority: 10
+      tls:
+        options: modern
+        domains:
+          - main: app.loc
+  middlewares:
+    redirect:
+      redirectScheme:
+        scheme: https
+        permanent: true
+    limit:
+      rateLimit:
+        average: 100
+  services:
+    app:
+      loadBalancer:
+        passHostHeader: false
+        servers:
+          - url: http://172.17.0.2:80
+tcp:
+  routers:
+    db:
+      rule: HostSNI(` + "`*`" + `)
+      service: db
+tls:
+  certificates:
+    - certFile: /traefik/certs/app.pem
+      keyFile: /traefik/certs/app-key.pem
+  options:
+    modern:
+      minVersion: VersionTLS13
+`
+
+func TestParseTraefikConfig(t *testing.T) {
+	cfg, err := ParseTraefikConfig([]byte(userConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := cfg.HTTP.Routers["app"]
+	if router == nil || router.Rule != "Host(`app.loc`)" || router.Priority != 10 {
+		t.Fatalf("unexpected router: %+v", router)
+	}
+	if router.TLS == nil || router.TLS.Options != "modern" || router.TLS.Extra["domains"] == nil {
+		t.Errorf("unexpected router TLS: %+v", router.TLS)
+	}
+	if redirect := cfg.HTTP.Middlewares["redirect"].RedirectScheme; redirect == nil || redirect.Scheme != "https" || !redirect.Permanent {
+		t.Errorf("unexpected redirect middleware: %+v", redirect)
+	}
+	if cfg.HTTP.Middlewares["limit"].Extra["rateLimit"] == nil {
+		t.Error("unmodelled middleware was dropped")
+	}
+	if servers := cfg.HTTP.Services["app"].LoadBalancer.Servers; len(servers) != 1 || servers[0].URL != "http://172.17.0.2:80" {
+		t.Errorf("unexpected servers: %+v", servers)
+	}
+	if cfg.TCP == nil || cfg.TCP.Routers["db"] == nil || cfg.TCP.Routers["db"].Rule != "HostSNI(`*`)" {
+		t.Errorf("unexpected tcp section: %+v", cfg.TCP)
+	}
+	if cfg.TLS.Extra["options"] == nil || cfg.TLS.Certificates[0].CertFile != "/traefik/certs/app.pem" {
+		t.Errorf("unexpected TLS: %+v", cfg.TLS)
+	}
+}
+
+func TestTraefikConfigRoundTrip(t *testing.T) {
+	cfg, err := ParseTraefikConfig([]byte(userConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Compare generically so key order and formatting do not matter
+	var want, got map[string]interface{}
+	if err := yaml.Unmarshal([]byte(userConfig), &want); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	wantYAML, _ := yaml.Marshal(want)
+	gotYAML, _ := yaml.Marshal(got)
+	if string(wantYAML) != string(gotYAML) {
+		t.Errorf("round trip changed the config:\nwant:\n%s\ngot:\n%s", wantYAML, gotYAML)
+	}
+}
+
+func TestGeneratedConfigRoundTrip(t *testing.T) {
+	cfg := NewTraefikConfig()
+	cfg.HTTP.Routers["web-0"] = &Router{Rule: "Host(`web.loc`)", Service: "web", EntryPoints: []string{"http"}}
+	cfg.HTTP.Routers["web-tls-0"] = &Router{Rule: "Host(`web.loc`)", Service: "web", EntryPoints: []string{"https"}, TLS: &RouterTLSConfig{}}
+	cfg.HTTP.Services["web"] = &Service{LoadBalancer: &LoadBalancer{Servers: []Server{{URL: "http://172.17.0.2:80"}}}}
+
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseTraefikConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := yaml.Marshal(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != string(again) {
+		t.Errorf("round trip changed generated config:\n%s\nvs\n%s", out, again)
+	}
+	if parsed.HTTP.Routers["web-tls-0"].TLS == nil {
+		t.Error("empty router TLS block was lost")
+	}
+}
+
+func TestParseTraefikConfigEmpty(t *testing.T) {
+	cfg, err := ParseTraefikConfig([]byte("\n  \n"))
+	if err != nil || cfg == nil || cfg.HTTP != nil {
+		t.Errorf("ParseTraefikConfig(empty) = %+v, %v", cfg, err)
+	}
+}
+
+func TestLoadDynamicDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"b.yaml":          "http:\n  services:\n    b:\n      loadBalancer:\n        servers:\n          - url: http://b\n",
+		"a.yml":           "http:\n  routers:\n    a:\n      rule: Host(`a.loc`)\n",
+		"broken.yaml":     "http: [unclosed\n",
+		"c.yaml.tmp":      "ignored",
+		".hidden.yaml":    "ignored",
+		"traefik.toml":    "ignored",
+		"middlewares.yml": "",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loaded, err := LoadDynamicDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, f := range loaded {
+		names = append(names, filepath.Base(f.Path))
+	}
+	want := []string{"a.yml", "b.yaml", "broken.yaml", "middlewares.yml"}
+	if len(names) != len(want) {
+		t.Fatalf("loaded %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("loaded %v, want %v", names, want)
+		}
+	}
+
+	if loaded[0].Err != nil || loaded[0].Config.HTTP.Routers["a"] == nil {
+		t.Errorf("a.yml not parsed: %+v", loaded[0])
+	}
+	if loaded[2].Err == nil || loaded[2].Config != nil {
+		t.Errorf("broken.yaml should report an error: %+v", loaded[2])
+	}
+}
+
+func TestLoadDynamicDirMissing(t *testing.T) {
+	if _, err := LoadDynamicDir(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
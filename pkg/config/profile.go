@@ -0,0 +1,148 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// DefaultProfilesDir is where the services look for profiles
+	DefaultProfilesDir = "/etc/http-proxy/profiles"
+
+	// ActiveProfileFile, in the profiles directory, names the profile used
+	// when HTTP_PROXY_PROFILE is not set
+	ActiveProfileFile = "active"
+
+	// profileExt is the extension of profile env files
+	profileExt = ".env"
+)
+
+// profileNamePattern keeps profile names usable as file names
+var profileNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ValidProfileName reports whether name can name a profile: lowercase
+// letters, digits, "-" and "_".
+func ValidProfileName(name string) bool {
+	return profileNamePattern.MatchString(name)
+}
+
+// ProfilePath returns the env file of profile name in dir.
+func ProfilePath(dir, name string) string {
+	return filepath.Join(dir, name+profileExt)
+}
+
+// ListProfiles returns the names of the profiles in dir, sorted. A missing
+// directory has none.
+func ListProfiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list profiles: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), profileExt)
+		if !entry.IsDir() && ok && ValidProfileName(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ReadActiveProfile returns the profile named in the active file of dir,
+// empty when there is none.
+func ReadActiveProfile(dir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ActiveProfileFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read the active profile: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// WriteActiveProfile selects profile name for the services reading dir; an
+// empty name clears the selection. The profile must exist.
+func WriteActiveProfile(dir, name string) error {
+	path := filepath.Join(dir, ActiveProfileFile)
+	if name == "" {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to clear the active profile: %w", err)
+		}
+		return nil
+	}
+
+	if !ValidProfileName(name) {
+		return fmt.Errorf("invalid profile name %q", name)
+	}
+	if _, err := os.Stat(ProfilePath(dir, name)); err != nil {
+		return fmt.Errorf("unknown profile %q: %w", name, err)
+	}
+	if err := os.WriteFile(path, []byte(name+"\n"), 0o644); err != nil {
+		return fmt.Errorf("failed to select profile: %w", err)
+	}
+	return nil
+}
+
+// loadLayered builds the configuration from four layers, each overriding the
+// one before: the built-in defaults, the selected profile, env (the container
+// environment, where an empty variable counts as unset) and overrides (the
+// config file). It also returns the profile selected and the files read,
+// which may not exist yet.
+func loadLayered(overrides map[string]string, env func(string) string) (*Config, error) {
+	lookup := func(key string) string {
+		if value, ok := overrides[key]; ok {
+			return value
+		}
+		return env(key)
+	}
+
+	dir := getOrDefault(lookup, "HTTP_PROXY_PROFILES_DIR", DefaultProfilesDir)
+	files := []string{filepath.Join(dir, ActiveProfileFile)}
+
+	name := strings.TrimSpace(lookup("HTTP_PROXY_PROFILE"))
+	if name == "" {
+		active, err := ReadActiveProfile(dir)
+		if err != nil {
+			return nil, err
+		}
+		name = active
+	}
+
+	var profile map[string]string
+	if name != "" {
+		if !ValidProfileName(name) {
+			return nil, fmt.Errorf("invalid profile name %q", name)
+		}
+		path := ProfilePath(dir, name)
+		values, err := ReadEnvFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load profile %q: %w", name, err)
+		}
+		profile = values
+		files = append(files, path)
+	}
+
+	cfg := load(func(key string) string {
+		if value, ok := overrides[key]; ok {
+			return value
+		}
+		if value := env(key); value != "" {
+			return value
+		}
+		return profile[key]
+	})
+	cfg.Profile = name
+	cfg.Files = files
+	return cfg, nil
+}
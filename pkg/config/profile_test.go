@@ -0,0 +1,105 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadWithProfile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	writeFile("office.env", "HTTP_PROXY_DNS_UPSTREAM_SERVERS=10.1.0.53:53\nHTTP_PROXY_DNS_TARGET_IP=10.1.2.3\n")
+	writeFile("home.env", "HTTP_PROXY_DNS_UPSTREAM_SERVERS=192.168.1.1:53\n")
+	overrides := writeFile("dns.env", "HTTP_PROXY_DNS_TARGET_IP=10.9.9.9\n")
+
+	t.Setenv("HTTP_PROXY_PROFILES_DIR", dir)
+	t.Setenv("HTTP_PROXY_DNS_TLDS", "loc")
+	// Set but empty, as the compose files pass unset variables
+	t.Setenv("HTTP_PROXY_DNS_UPSTREAM_SERVERS", "")
+
+	tests := []struct {
+		name        string
+		envProfile  string
+		envIP       string
+		active      string
+		file        string
+		wantProfile string
+		wantIP      string
+		wantUp      []string
+		wantErr     bool
+	}{
+		{name: "no profile", envIP: "127.0.0.2", wantIP: "127.0.0.2", wantUp: []string{"8.8.8.8:53", "1.1.1.1:53"}},
+		{name: "active profile over the defaults", active: "office", wantProfile: "office", wantIP: "10.1.2.3", wantUp: []string{"10.1.0.53:53"}},
+		{name: "environment over the profile", envIP: "127.0.0.2", active: "office", wantProfile: "office", wantIP: "127.0.0.2", wantUp: []string{"10.1.0.53:53"}},
+		{name: "environment selects the profile", envProfile: "home", active: "office", wantProfile: "home", wantIP: "127.0.0.1", wantUp: []string{"192.168.1.1:53"}},
+		{name: "config file over the environment", envIP: "127.0.0.2", active: "office", file: overrides, wantProfile: "office", wantIP: "10.9.9.9", wantUp: []string{"10.1.0.53:53"}},
+		{name: "unknown profile", envProfile: "vpn", wantErr: true},
+		{name: "invalid profile name", envProfile: "../home", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HTTP_PROXY_PROFILE", tt.envProfile)
+			t.Setenv("HTTP_PROXY_DNS_TARGET_IP", tt.envIP)
+			if err := WriteActiveProfile(dir, tt.active); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := LoadWithFile(tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadWithFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if cfg.Profile != tt.wantProfile || cfg.DNSIP != tt.wantIP || !reflect.DeepEqual(cfg.DNSUpstreamServers, tt.wantUp) {
+				t.Errorf("profile %q, IP %q, upstreams %v; want %q, %q, %v",
+					cfg.Profile, cfg.DNSIP, cfg.DNSUpstreamServers, tt.wantProfile, tt.wantIP, tt.wantUp)
+			}
+			if cfg.Files[len(cfg.Files)-1] != filepath.Join(dir, tt.wantProfile+".env") && tt.wantProfile != "" {
+				t.Errorf("Files = %v, want the profile file last", cfg.Files)
+			}
+		})
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	dir := t.TempDir()
+	if names, err := ListProfiles(filepath.Join(dir, "missing")); err != nil || names != nil {
+		t.Errorf("ListProfiles(missing) = %v, %v", names, err)
+	}
+
+	for _, name := range []string{"vpn.env", "home.env", "notes.txt", "Bad Name.env"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names, err := ListProfiles(dir)
+	if err != nil || !reflect.DeepEqual(names, []string{"home", "vpn"}) {
+		t.Errorf("ListProfiles() = %v, %v", names, err)
+	}
+
+	if err := WriteActiveProfile(dir, "office"); err == nil {
+		t.Error("selected a profile that does not exist")
+	}
+	if err := WriteActiveProfile(dir, "vpn"); err != nil {
+		t.Fatal(err)
+	}
+	if name, err := ReadActiveProfile(dir); err != nil || name != "vpn" {
+		t.Errorf("ReadActiveProfile() = %q, %v", name, err)
+	}
+	if err := WriteActiveProfile(dir, ""); err != nil {
+		t.Fatal(err)
+	}
+	if name, err := ReadActiveProfile(dir); err != nil || name != "" {
+		t.Errorf("ReadActiveProfile() after clearing = %q, %v", name, err)
+	}
+}
@@ -1,72 +1,296 @@
 package config
 
-// TraefikConfig represents the structure for Traefik dynamic configuration
+// TraefikConfig represents the structure for Traefik dynamic configuration.
+// Every struct keeps keys it does not model in an inline Extra map, so files
+// written by users (other middleware types, ...) survive an unmarshal/marshal
+// round trip unchanged.
 type TraefikConfig struct {
-	HTTP *HTTPConfig `yaml:"http,omitempty"`
-	TLS  *TLSConfig  `yaml:"tls,omitempty"`
+	HTTP  *HTTPConfig            `yaml:"http,omitempty"`
+	TCP   *TCPConfig             `yaml:"tcp,omitempty"`
+	UDP   *UDPConfig             `yaml:"udp,omitempty"`
+	TLS   *TLSConfig             `yaml:"tls,omitempty"`
+	Extra map[string]interface{} `yaml:",inline"`
 }
 
 // HTTPConfig represents HTTP configuration
 type HTTPConfig struct {
-	Routers     map[string]*Router     `yaml:"routers,omitempty"`
-	Services    map[string]*Service    `yaml:"services,omitempty"`
-	Middlewares map[string]*Middleware `yaml:"middlewares,omitempty"`
+	Routers           map[string]*Router           `yaml:"routers,omitempty"`
+	Services          map[string]*Service          `yaml:"services,omitempty"`
+	Middlewares       map[string]*Middleware       `yaml:"middlewares,omitempty"`
+	ServersTransports map[string]*ServersTransport `yaml:"serversTransports,omitempty"`
+	Extra             map[string]interface{}       `yaml:",inline"`
 }
 
 // Router represents a Traefik router configuration
 type Router struct {
-	Rule        string           `yaml:"rule,omitempty"`
-	Service     string           `yaml:"service,omitempty"`
-	EntryPoints []string         `yaml:"entryPoints,omitempty"`
-	Middlewares []string         `yaml:"middlewares,omitempty"`
-	TLS         *RouterTLSConfig `yaml:"tls,omitempty"`
+	Rule        string                 `yaml:"rule,omitempty"`
+	Service     string                 `yaml:"service,omitempty"`
+	EntryPoints []string               `yaml:"entryPoints,omitempty"`
+	Middlewares []string               `yaml:"middlewares,omitempty"`
+	Priority    int                    `yaml:"priority,omitempty"`
+	TLS         *RouterTLSConfig       `yaml:"tls,omitempty"`
+	Extra       map[string]interface{} `yaml:",inline"`
 }
 
-// RouterTLSConfig represents TLS configuration for a router
+// RouterTLSConfig represents TLS configuration for a router. It enables TLS
+// with auto-generated certificates when left empty.
 type RouterTLSConfig struct {
-	// Empty struct enables TLS with auto-generated certificates
+	Options      string                 `yaml:"options,omitempty"`
+	CertResolver string                 `yaml:"certResolver,omitempty"`
+	Extra        map[string]interface{} `yaml:",inline"`
 }
 
 // Middleware represents a Traefik middleware configuration
 type Middleware struct {
-	Headers *HeadersMiddleware `yaml:"headers,omitempty"`
+	Headers          *HeadersMiddleware          `yaml:"headers,omitempty"`
+	StripPrefix      *StripPrefixMiddleware      `yaml:"stripPrefix,omitempty"`
+	ReplacePathRegex *ReplacePathRegexMiddleware `yaml:"replacePathRegex,omitempty"`
+	ForwardAuth      *ForwardAuthMiddleware      `yaml:"forwardAuth,omitempty"`
+	RedirectScheme   *RedirectSchemeMiddleware   `yaml:"redirectScheme,omitempty"`
+	RedirectRegex    *RedirectRegexMiddleware    `yaml:"redirectRegex,omitempty"`
+	IPAllowList      *IPAllowListMiddleware      `yaml:"ipAllowList,omitempty"`
+	BasicAuth        *BasicAuthMiddleware        `yaml:"basicAuth,omitempty"`
+	Buffering        *BufferingMiddleware        `yaml:"buffering,omitempty"`
+	Extra            map[string]interface{}      `yaml:",inline"`
+}
+
+// StripPrefixMiddleware represents stripPrefix middleware configuration
+type StripPrefixMiddleware struct {
+	Prefixes []string               `yaml:"prefixes,omitempty"`
+	Extra    map[string]interface{} `yaml:",inline"`
+}
+
+// ForwardAuthMiddleware represents forwardAuth middleware configuration
+type ForwardAuthMiddleware struct {
+	Address string                 `yaml:"address,omitempty"`
+	Extra   map[string]interface{} `yaml:",inline"`
+}
+
+// RedirectSchemeMiddleware represents redirectScheme middleware configuration
+type RedirectSchemeMiddleware struct {
+	Scheme    string                 `yaml:"scheme,omitempty"`
+	Permanent bool                   `yaml:"permanent,omitempty"`
+	Extra     map[string]interface{} `yaml:",inline"`
+}
+
+// RedirectRegexMiddleware represents redirectRegex middleware configuration
+type RedirectRegexMiddleware struct {
+	Regex       string                 `yaml:"regex,omitempty"`
+	Replacement string                 `yaml:"replacement,omitempty"`
+	Permanent   bool                   `yaml:"permanent,omitempty"`
+	Extra       map[string]interface{} `yaml:",inline"`
+}
+
+// IPAllowListMiddleware represents ipAllowList middleware configuration
+type IPAllowListMiddleware struct {
+	SourceRange []string               `yaml:"sourceRange,omitempty"`
+	Extra       map[string]interface{} `yaml:",inline"`
+}
+
+// BasicAuthMiddleware represents basicAuth middleware configuration
+type BasicAuthMiddleware struct {
+	Users        []string               `yaml:"users,omitempty"`
+	Realm        string                 `yaml:"realm,omitempty"`
+	RemoveHeader bool                   `yaml:"removeHeader,omitempty"`
+	Extra        map[string]interface{} `yaml:",inline"`
+}
+
+// BufferingMiddleware represents buffering middleware configuration. Request
+// bodies larger than MemRequestBodyBytes are buffered on disk; larger than
+// MaxRequestBodyBytes, they are rejected with 413.
+type BufferingMiddleware struct {
+	MaxRequestBodyBytes int64                  `yaml:"maxRequestBodyBytes,omitempty"`
+	MemRequestBodyBytes int64                  `yaml:"memRequestBodyBytes,omitempty"`
+	Extra               map[string]interface{} `yaml:",inline"`
+}
+
+// ReplacePathRegexMiddleware represents replacePathRegex middleware
+// configuration
+type ReplacePathRegexMiddleware struct {
+	Regex       string                 `yaml:"regex,omitempty"`
+	Replacement string                 `yaml:"replacement,omitempty"`
+	Extra       map[string]interface{} `yaml:",inline"`
 }
 
 // HeadersMiddleware represents headers middleware configuration
 type HeadersMiddleware struct {
-	AccessControlAllowCredentials *bool             `yaml:"accessControlAllowCredentials,omitempty"`
-	AccessControlAllowHeaders     []string          `yaml:"accessControlAllowHeaders,omitempty"`
-	AccessControlAllowMethods     []string          `yaml:"accessControlAllowMethods,omitempty"`
-	AccessControlAllowOriginList  []string          `yaml:"accessControlAllowOriginList,omitempty"`
-	AccessControlMaxAge           *int64            `yaml:"accessControlMaxAge,omitempty"`
-	CustomRequestHeaders          map[string]string `yaml:"customRequestHeaders,omitempty"`
-	CustomResponseHeaders         map[string]string `yaml:"customResponseHeaders,omitempty"`
+	AccessControlAllowCredentials *bool                  `yaml:"accessControlAllowCredentials,omitempty"`
+	AccessControlAllowHeaders     []string               `yaml:"accessControlAllowHeaders,omitempty"`
+	AccessControlAllowMethods     []string               `yaml:"accessControlAllowMethods,omitempty"`
+	AccessControlAllowOriginList  []string               `yaml:"accessControlAllowOriginList,omitempty"`
+	AccessControlMaxAge           *int64                 `yaml:"accessControlMaxAge,omitempty"`
+	CustomRequestHeaders          map[string]string      `yaml:"customRequestHeaders,omitempty"`
+	CustomResponseHeaders         map[string]string      `yaml:"customResponseHeaders,omitempty"`
+	STSSeconds                    int64                  `yaml:"stsSeconds,omitempty"`
+	STSIncludeSubdomains          bool                   `yaml:"stsIncludeSubdomains,omitempty"`
+	STSPreload                    bool                   `yaml:"stsPreload,omitempty"`
+	ContentTypeNosniff            bool                   `yaml:"contentTypeNosniff,omitempty"`
+	ReferrerPolicy                string                 `yaml:"referrerPolicy,omitempty"`
+	Extra                         map[string]interface{} `yaml:",inline"`
 }
 
 // Service represents a Traefik service configuration
 type Service struct {
-	LoadBalancer *LoadBalancer `yaml:"loadBalancer,omitempty"`
+	LoadBalancer *LoadBalancer          `yaml:"loadBalancer,omitempty"`
+	Extra        map[string]interface{} `yaml:",inline"`
 }
 
 // LoadBalancer represents a load balancer configuration
 type LoadBalancer struct {
-	Servers []Server `yaml:"servers,omitempty"`
+	Servers            []Server               `yaml:"servers,omitempty"`
+	Sticky             *Sticky                `yaml:"sticky,omitempty"`
+	ResponseForwarding *ResponseForwarding    `yaml:"responseForwarding,omitempty"`
+	ServersTransport   string                 `yaml:"serversTransport,omitempty"`
+	Extra              map[string]interface{} `yaml:",inline"`
+}
+
+// ResponseForwarding is how a load balancer forwards responses; a negative
+// FlushInterval flushes after every write
+type ResponseForwarding struct {
+	FlushInterval string                 `yaml:"flushInterval,omitempty"`
+	Extra         map[string]interface{} `yaml:",inline"`
+}
+
+// Sticky pins the clients of a load balancer to one of its servers
+type Sticky struct {
+	Cookie *StickyCookie          `yaml:"cookie,omitempty"`
+	Extra  map[string]interface{} `yaml:",inline"`
+}
+
+// StickyCookie is the cookie remembering the server of a client
+type StickyCookie struct {
+	Name     string                 `yaml:"name,omitempty"`
+	Secure   bool                   `yaml:"secure,omitempty"`
+	HTTPOnly bool                   `yaml:"httpOnly,omitempty"`
+	SameSite string                 `yaml:"sameSite,omitempty"`
+	Extra    map[string]interface{} `yaml:",inline"`
+}
+
+// ServersTransport represents how Traefik connects to the servers of a load
+// balancer
+type ServersTransport struct {
+	ServerName         string                 `yaml:"serverName,omitempty"`
+	InsecureSkipVerify bool                   `yaml:"insecureSkipVerify,omitempty"`
+	ForwardingTimeouts *ForwardingTimeouts    `yaml:"forwardingTimeouts,omitempty"`
+	Extra              map[string]interface{} `yaml:",inline"`
+}
+
+// ForwardingTimeouts bound the connections to the servers; Traefik answers
+// 504 when ResponseHeaderTimeout expires
+type ForwardingTimeouts struct {
+	DialTimeout           string                 `yaml:"dialTimeout,omitempty"`
+	ResponseHeaderTimeout string                 `yaml:"responseHeaderTimeout,omitempty"`
+	IdleConnTimeout       string                 `yaml:"idleConnTimeout,omitempty"`
+	Extra                 map[string]interface{} `yaml:",inline"`
 }
 
 // Server represents a server configuration
 type Server struct {
-	URL string `yaml:"url,omitempty"`
+	URL   string                 `yaml:"url,omitempty"`
+	Extra map[string]interface{} `yaml:",inline"`
 }
 
+// TCPConfig represents TCP configuration
+type TCPConfig struct {
+	Routers  map[string]*TCPRouter  `yaml:"routers,omitempty"`
+	Services map[string]*TCPService `yaml:"services,omitempty"`
+	Extra    map[string]interface{} `yaml:",inline"`
+}
+
+// TCPRouter represents a Traefik TCP router configuration. Its rule matches
+// the TLS server name (HostSNI), or every connection with HostSNI(`*`).
+type TCPRouter struct {
+	Rule        string                 `yaml:"rule,omitempty"`
+	Service     string                 `yaml:"service,omitempty"`
+	EntryPoints []string               `yaml:"entryPoints,omitempty"`
+	Priority    int                    `yaml:"priority,omitempty"`
+	TLS         *TCPRouterTLSConfig    `yaml:"tls,omitempty"`
+	Extra       map[string]interface{} `yaml:",inline"`
+}
+
+// TCPRouterTLSConfig represents TLS configuration for a TCP router. Traefik
+// terminates TLS unless Passthrough is set.
+type TCPRouterTLSConfig struct {
+	Passthrough  bool                   `yaml:"passthrough,omitempty"`
+	Options      string                 `yaml:"options,omitempty"`
+	CertResolver string                 `yaml:"certResolver,omitempty"`
+	Extra        map[string]interface{} `yaml:",inline"`
+}
+
+// TCPService represents a Traefik TCP service configuration
+type TCPService struct {
+	LoadBalancer *TCPLoadBalancer       `yaml:"loadBalancer,omitempty"`
+	Extra        map[string]interface{} `yaml:",inline"`
+}
+
+// TCPLoadBalancer represents a TCP load balancer configuration
+type TCPLoadBalancer struct {
+	Servers []TCPServer            `yaml:"servers,omitempty"`
+	Extra   map[string]interface{} `yaml:",inline"`
+}
+
+// TCPServer represents a TCP server configuration
+type TCPServer struct {
+	Address string                 `yaml:"address,omitempty"`
+	Extra   map[string]interface{} `yaml:",inline"`
+}
+
+// UDPConfig represents UDP configuration
+type UDPConfig struct {
+	Routers  map[string]*UDPRouter  `yaml:"routers,omitempty"`
+	Services map[string]*UDPService `yaml:"services,omitempty"`
+	Extra    map[string]interface{} `yaml:",inline"`
+}
+
+// UDPRouter represents a Traefik UDP router configuration. UDP has no rules:
+// a router takes every datagram of its entry points.
+type UDPRouter struct {
+	Service     string                 `yaml:"service,omitempty"`
+	EntryPoints []string               `yaml:"entryPoints,omitempty"`
+	Extra       map[string]interface{} `yaml:",inline"`
+}
+
+// UDPService represents a Traefik UDP service configuration
+type UDPService struct {
+	LoadBalancer *UDPLoadBalancer       `yaml:"loadBalancer,omitempty"`
+	Extra        map[string]interface{} `yaml:",inline"`
+}
+
+// UDPLoadBalancer represents a UDP load balancer configuration
+type UDPLoadBalancer struct {
+	Servers []UDPServer            `yaml:"servers,omitempty"`
+	Extra   map[string]interface{} `yaml:",inline"`
+}
+
+// UDPServer represents a UDP server configuration
+type UDPServer struct {
+	Address string                 `yaml:"address,omitempty"`
+	Extra   map[string]interface{} `yaml:",inline"`
+}
+
+// DefaultTLSStore is the TLS store Traefik's routers use unless told otherwise
+const DefaultTLSStore = "default"
+
 // TLSConfig represents TLS configuration for certificates
 type TLSConfig struct {
-	Certificates []TLSCertificate `yaml:"certificates,omitempty"`
+	Certificates []TLSCertificate       `yaml:"certificates,omitempty"`
+	Stores       map[string]*TLSStore   `yaml:"stores,omitempty"`
+	Extra        map[string]interface{} `yaml:",inline"`
+}
+
+// TLSStore represents a TLS store. DefaultCertificate is served to clients
+// whose server name no certificate matches, instead of the self-signed
+// certificate Traefik generates.
+type TLSStore struct {
+	DefaultCertificate *TLSCertificate        `yaml:"defaultCertificate,omitempty"`
+	Extra              map[string]interface{} `yaml:",inline"`
 }
 
 // TLSCertificate represents a TLS certificate configuration
 type TLSCertificate struct {
-	CertFile string `yaml:"certFile,omitempty"`
-	KeyFile  string `yaml:"keyFile,omitempty"`
+	CertFile string                 `yaml:"certFile,omitempty"`
+	KeyFile  string                 `yaml:"keyFile,omitempty"`
+	Extra    map[string]interface{} `yaml:",inline"`
 }
 
 // NewTraefikConfig creates a new Traefik configuration
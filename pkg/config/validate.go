@@ -0,0 +1,237 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Validate checks the parts of a dynamic configuration Traefik's file
+// provider rejects or silently drops: router rules that do not parse, routers
+// pointing at services the file does not define, servers without a valid URL
+// or address, and TLS store default certificates missing a file. References
+// to other providers ("name@provider") are not checked. All problems are
+// reported, sorted.
+func (c *TraefikConfig) Validate() error {
+	var problems []string
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.HTTP != nil {
+		for name, router := range c.HTTP.Routers {
+			if router == nil {
+				add("http router %s is empty", name)
+				continue
+			}
+			if err := ValidateRule(router.Rule); err != nil {
+				add("http router %s: %v", name, err)
+			}
+			if !definedService(router.Service, c.HTTP.Services[router.Service] != nil) {
+				add("http router %s: service %q is not defined", name, router.Service)
+			}
+		}
+		for name, svc := range c.HTTP.Services {
+			if svc == nil || svc.LoadBalancer == nil {
+				continue
+			}
+			for _, server := range svc.LoadBalancer.Servers {
+				if u, err := url.Parse(server.URL); err != nil || u.Scheme == "" || u.Host == "" {
+					add("http service %s: invalid server URL %q", name, server.URL)
+				}
+			}
+		}
+	}
+
+	if c.TCP != nil {
+		for name, router := range c.TCP.Routers {
+			if router == nil {
+				add("tcp router %s is empty", name)
+				continue
+			}
+			if err := ValidateRule(router.Rule); err != nil {
+				add("tcp router %s: %v", name, err)
+			}
+			if !definedService(router.Service, c.TCP.Services[router.Service] != nil) {
+				add("tcp router %s: service %q is not defined", name, router.Service)
+			}
+		}
+		for name, svc := range c.TCP.Services {
+			if svc == nil || svc.LoadBalancer == nil {
+				continue
+			}
+			for _, server := range svc.LoadBalancer.Servers {
+				if !validAddress(server.Address) {
+					add("tcp service %s: invalid server address %q", name, server.Address)
+				}
+			}
+		}
+	}
+
+	if c.UDP != nil {
+		for name, router := range c.UDP.Routers {
+			if router == nil {
+				add("udp router %s is empty", name)
+				continue
+			}
+			if !definedService(router.Service, c.UDP.Services[router.Service] != nil) {
+				add("udp router %s: service %q is not defined", name, router.Service)
+			}
+		}
+		for name, svc := range c.UDP.Services {
+			if svc == nil || svc.LoadBalancer == nil {
+				continue
+			}
+			for _, server := range svc.LoadBalancer.Servers {
+				if !validAddress(server.Address) {
+					add("udp service %s: invalid server address %q", name, server.Address)
+				}
+			}
+		}
+	}
+
+	if c.TLS != nil {
+		for name, store := range c.TLS.Stores {
+			if store == nil || store.DefaultCertificate == nil {
+				continue
+			}
+			if store.DefaultCertificate.CertFile == "" || store.DefaultCertificate.KeyFile == "" {
+				add("tls store %s: default certificate needs certFile and keyFile", name)
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return errors.New(strings.Join(problems, "; "))
+}
+
+// definedService reports whether a router's service is defined in the same
+// file or belongs to another provider.
+func definedService(service string, defined bool) bool {
+	return defined || strings.Contains(service, "@")
+}
+
+// validAddress reports whether address is a host:port server address.
+func validAddress(address string) bool {
+	host, port, err := net.SplitHostPort(address)
+	return err == nil && host != "" && port != ""
+}
+
+// ValidateRule checks that a router rule parses as Traefik's rule syntax:
+// matchers such as Host(`a.loc`) with backtick or double quoted arguments,
+// combined with &&, || and !, and grouped with parentheses. A value with a
+// stray backtick, as from a VIRTUAL_HOST containing one, fails to parse.
+func ValidateRule(rule string) error {
+	if strings.TrimSpace(rule) == "" {
+		return fmt.Errorf("empty rule")
+	}
+	p := &ruleParser{rule: rule}
+	if err := p.expr(); err != nil {
+		return fmt.Errorf("invalid rule %q: %w", rule, err)
+	}
+	p.space()
+	if p.pos < len(p.rule) {
+		return fmt.Errorf("invalid rule %q: unexpected %q at %d", rule, p.rule[p.pos:], p.pos)
+	}
+	return nil
+}
+
+// ruleParser is a recursive descent parser of router rules.
+type ruleParser struct {
+	rule string
+	pos  int
+}
+
+func (p *ruleParser) space() {
+	for p.pos < len(p.rule) && unicode.IsSpace(rune(p.rule[p.pos])) {
+		p.pos++
+	}
+}
+
+// accept consumes token when it comes next.
+func (p *ruleParser) accept(token string) bool {
+	p.space()
+	if strings.HasPrefix(p.rule[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+// expr parses terms joined by && and ||.
+func (p *ruleParser) expr() error {
+	for {
+		if err := p.term(); err != nil {
+			return err
+		}
+		if !p.accept("&&") && !p.accept("||") {
+			return nil
+		}
+	}
+}
+
+// term parses a negated term, a parenthesized expression or a matcher.
+func (p *ruleParser) term() error {
+	if p.accept("!") {
+		return p.term()
+	}
+	if p.accept("(") {
+		if err := p.expr(); err != nil {
+			return err
+		}
+		if !p.accept(")") {
+			return fmt.Errorf("missing ) at %d", p.pos)
+		}
+		return nil
+	}
+	return p.matcher()
+}
+
+// matcher parses Name(arg, ...).
+func (p *ruleParser) matcher() error {
+	p.space()
+	start := p.pos
+	for p.pos < len(p.rule) && (unicode.IsLetter(rune(p.rule[p.pos])) || unicode.IsDigit(rune(p.rule[p.pos]))) {
+		p.pos++
+	}
+	if p.pos == start {
+		return fmt.Errorf("expected a matcher at %d", p.pos)
+	}
+	name := p.rule[start:p.pos]
+	if !p.accept("(") {
+		return fmt.Errorf("matcher %s without arguments", name)
+	}
+	for {
+		if err := p.argument(); err != nil {
+			return fmt.Errorf("matcher %s: %w", name, err)
+		}
+		if p.accept(")") {
+			return nil
+		}
+		if !p.accept(",") {
+			return fmt.Errorf("matcher %s: expected , or ) at %d", name, p.pos)
+		}
+	}
+}
+
+// argument parses a backtick or double quoted string.
+func (p *ruleParser) argument() error {
+	p.space()
+	if p.pos >= len(p.rule) || (p.rule[p.pos] != '`' && p.rule[p.pos] != '"') {
+		return fmt.Errorf("expected a quoted value at %d", p.pos)
+	}
+	quote := p.rule[p.pos]
+	end := strings.IndexByte(p.rule[p.pos+1:], quote)
+	if end < 0 {
+		return fmt.Errorf("unterminated value at %d", p.pos)
+	}
+	p.pos += end + 2
+	return nil
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		wantErr bool
+	}{
+		{"Host(`web.loc`)", false},
+		{"Host(`web.loc`) || Host(`www.web.loc`)", false},
+		{"(Host(`web.loc`) && PathPrefix(`/api`)) && !Path(`/api/health`)", false},
+		{"HostRegexp(`^.+\\.web\\.loc$`)", false},
+		{"HostSNI(`*`)", false},
+		{`Header("X-Test", "1")`, false},
+		{"", true},
+		{"Host(`web`.loc`)", true},
+		{"Host(`web.loc`", true},
+		{"Host(`web.loc)", true},
+		{"Host(web.loc)", true},
+		{"Host(`web.loc`) &&", true},
+		{"(Host(`web.loc`)", true},
+		{"Host(`web.loc`) Host(`api.loc`)", true},
+	}
+	for _, tt := range tests {
+		if err := ValidateRule(tt.rule); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateRule(%q) error = %v, wantErr %v", tt.rule, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTraefikConfigValidate(t *testing.T) {
+	valid := `
+http:
+  routers:
+    web:
+      rule: Host(` + "`web.loc`" + `)
+      service: web
+    dashboard:
+      rule: Host(` + "`traefik.loc`" + `)
+      service: api@internal
+  services:
+    web:
+      loadBalancer:
+        servers:
+          - url: http://172.17.0.2:80
+tcp:
+  routers:
+    db:
+      rule: HostSNI(` + "`*`" + `)
+      service: db
+  services:
+    db:
+      loadBalancer:
+        servers:
+          - address: 172.17.0.3:5432
+tls:
+  stores:
+    default:
+      defaultCertificate:
+        certFile: /traefik/certs/_wildcard.loc.pem
+        keyFile: /traefik/certs/_wildcard.loc-key.pem
+`
+	cfg, err := ParseTraefikConfig([]byte(valid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+
+	invalid := `
+http:
+  routers:
+    web:
+      rule: Host(` + "`we`b.loc`" + `)
+      service: web
+    api:
+      rule: Host(` + "`api.loc`" + `)
+      service: missing
+  services:
+    web:
+      loadBalancer:
+        servers:
+          - url: 172.17.0.2
+udp:
+  services:
+    dns:
+      loadBalancer:
+        servers:
+          - address: 172.17.0.4
+tls:
+  stores:
+    default:
+      defaultCertificate:
+        certFile: /traefik/certs/_wildcard.loc.pem
+`
+	cfg, err = ParseTraefikConfig([]byte(invalid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate() accepted an invalid config")
+	}
+	for _, want := range []string{
+		`http router api: service "missing" is not defined`,
+		"http router web: invalid rule",
+		`http service web: invalid server URL "172.17.0.2"`,
+		`udp service dns: invalid server address "172.17.0.4"`,
+		"tls store default: default certificate needs certFile and keyFile",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() error = %v, want it to report %q", err, want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"io"
 	"log/slog"
 	"os"
 	"strings"
@@ -89,6 +90,17 @@ func NewWithLevel(component string, level LogLevel) *Logger {
 	}
 }
 
+// NewJSON creates a logger writing JSON lines to w at info level, whatever
+// LOG_FORMAT and LOG_LEVEL say. It is meant for record streams such as query
+// logs, which are parsed rather than read.
+func NewJSON(component string, w io.Writer) *Logger {
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelInfo})
+	return &Logger{
+		Logger:    slog.New(handler).With("component", component),
+		component: component,
+	}
+}
+
 // isJSONFormat determines if we should use JSON logging format
 // based on environment variables
 func isJSONFormat() bool {
@@ -0,0 +1,63 @@
+package logger
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Operation logs one run of a multi-step operation, such as joining a
+// network. Its records carry the operation name and ID, and the attributes
+// of its steps are grouped under the operation name, so the records of
+// concurrent runs can be told apart. End reports the outcome and how long
+// the run took.
+type Operation struct {
+	*Logger
+	base  *slog.Logger
+	name  string
+	attrs []interface{}
+	start time.Time
+}
+
+// WithOperation starts logging a run of the operation name identified by id,
+// e.g. WithOperation("network-join", networkID).
+func (l *Logger) WithOperation(name, id string) *Operation {
+	base := l.Logger.With("operation", name, "operation_id", id)
+	return &Operation{
+		Logger: &Logger{Logger: base.WithGroup(name), component: l.component},
+		base:   base,
+		name:   name,
+		start:  time.Now(),
+	}
+}
+
+// With returns the operation with attributes added to its later records,
+// End included.
+func (o *Operation) With(args ...interface{}) *Operation {
+	return &Operation{
+		Logger: o.Logger.With(args...),
+		base:   o.base,
+		name:   o.name,
+		attrs:  append(append([]interface{}(nil), o.attrs...), args...),
+		start:  o.start,
+	}
+}
+
+// End logs the outcome of the operation: "<name> completed" at info level,
+// or "<name> failed" at error level with err, with its duration in
+// milliseconds and the given attributes. It returns the duration.
+func (o *Operation) End(err error, args ...interface{}) time.Duration {
+	elapsed := time.Since(o.start)
+
+	logger := o.base
+	if attrs := append(append([]interface{}(nil), o.attrs...), args...); len(attrs) > 0 {
+		logger = logger.With(slog.Group(o.name, attrs...))
+	}
+
+	durationMS := float64(elapsed.Microseconds()) / 1000
+	if err != nil {
+		logger.Error(o.name+" failed", "duration_ms", durationMS, "error", err)
+	} else {
+		logger.Info(o.name+" completed", "duration_ms", durationMS)
+	}
+	return elapsed
+}
@@ -0,0 +1,68 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// records decodes the JSON lines written to buf.
+func records(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var out []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid record %q: %v", line, err)
+		}
+		out = append(out, record)
+	}
+	return out
+}
+
+func TestOperation(t *testing.T) {
+	var buf bytes.Buffer
+	op := NewJSON("test", &buf).WithOperation("network-join", "abc123").With("network", "shop_default")
+
+	op.Info("Connecting", "attempt", 1)
+	op.End(nil, "joined", true)
+
+	got := records(t, &buf)
+	if len(got) != 2 {
+		t.Fatalf("got %d records, want 2", len(got))
+	}
+	for _, record := range got {
+		if record["component"] != "test" || record["operation"] != "network-join" || record["operation_id"] != "abc123" {
+			t.Errorf("record %v misses the operation", record)
+		}
+	}
+
+	step, _ := got[0]["network-join"].(map[string]interface{})
+	if step["network"] != "shop_default" || step["attempt"] != float64(1) {
+		t.Errorf("step attributes = %v, want them grouped under the operation", got[0])
+	}
+
+	end := got[1]
+	group, _ := end["network-join"].(map[string]interface{})
+	if end["msg"] != "network-join completed" || end["level"] != "INFO" || group["network"] != "shop_default" || group["joined"] != true {
+		t.Errorf("end record = %v", end)
+	}
+	if _, ok := end["duration_ms"].(float64); !ok {
+		t.Errorf("end record %v has no duration_ms", end)
+	}
+}
+
+func TestOperationEndFailed(t *testing.T) {
+	var buf bytes.Buffer
+	NewJSON("test", &buf).WithOperation("repair", "run-1").End(errors.New("boom"))
+
+	got := records(t, &buf)
+	if len(got) != 1 || got[0]["msg"] != "repair failed" || got[0]["level"] != "ERROR" || got[0]["error"] != "boom" {
+		t.Errorf("records = %v", got)
+	}
+	if _, ok := got[0]["repair"]; ok {
+		t.Errorf("empty group logged: %v", got[0])
+	}
+}
@@ -0,0 +1,239 @@
+// Package mdns implements a minimal multicast DNS (RFC 6762) responder that
+// answers A queries for a dynamic set of .local hostnames. It lets devices that
+// cannot use the proxy's DNS server (smart TVs, locked-down tablets) resolve
+// proxied hostnames on the local network.
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+const (
+	// DefaultTTL is the TTL (seconds) of advertised records, the RFC 6762
+	// recommendation for records referencing a host name.
+	DefaultTTL = 120
+
+	// maxPacketSize is the largest mDNS packet we accept (RFC 6762 section 17).
+	maxPacketSize = 9000
+
+	// cacheFlushBit marks a record as unique (RFC 6762 section 10.2).
+	cacheFlushBit = 1 << 15
+
+	// unicastResponseBit in a question's class requests a unicast reply (RFC 6762 section 5.4).
+	unicastResponseBit = 1 << 15
+)
+
+// multicastAddr is the IPv4 mDNS group and port.
+var multicastAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Responder answers mDNS A queries for a set of registered .local names.
+type Responder struct {
+	ip     net.IP
+	logger *logger.Logger
+
+	mu    sync.RWMutex
+	names map[string]bool
+	conn  *net.UDPConn
+}
+
+// NewResponder creates a responder advertising ip for every registered name.
+func NewResponder(ip net.IP, log *logger.Logger) *Responder {
+	return &Responder{
+		ip:     ip.To4(),
+		logger: log,
+		names:  make(map[string]bool),
+	}
+}
+
+// LocalAlias returns the .local alias for a proxied hostname by replacing its
+// last label: "app.loc" becomes "app.local" and "api.app.loc" becomes
+// "api.app.local". Hostnames already under .local are returned unchanged.
+// It returns "" for wildcard or regex hosts and single-label names.
+func LocalAlias(hostname string) string {
+	hostname = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(hostname)), ".")
+	if hostname == "" || strings.ContainsAny(hostname, "*~^$\\") {
+		return ""
+	}
+	if strings.HasSuffix(hostname, ".local") {
+		return hostname
+	}
+
+	i := strings.LastIndex(hostname, ".")
+	if i <= 0 {
+		return ""
+	}
+	return hostname[:i] + ".local"
+}
+
+// SetNames replaces the advertised names and announces names that were not
+// advertised before, so peers learn about new routes without querying.
+func (r *Responder) SetNames(names []string) {
+	next := make(map[string]bool, len(names))
+	var added []string
+	for _, name := range names {
+		fqdn := dns.Fqdn(strings.ToLower(name))
+		next[fqdn] = true
+	}
+
+	r.mu.Lock()
+	for fqdn := range next {
+		if !r.names[fqdn] {
+			added = append(added, fqdn)
+		}
+	}
+	r.names = next
+	conn := r.conn
+	r.mu.Unlock()
+
+	if conn == nil || len(added) == 0 {
+		return
+	}
+
+	sort.Strings(added)
+	r.logger.Info("Announcing mDNS names", "names", added)
+	if err := r.send(conn, r.answer(added), multicastAddr); err != nil {
+		r.logger.Warn("Failed to announce mDNS names", "error", err)
+	}
+}
+
+// Names returns the currently advertised names, sorted.
+func (r *Responder) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.names))
+	for fqdn := range r.names {
+		names = append(names, fqdn)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run joins the mDNS multicast group and answers queries until ctx is done.
+func (r *Responder) Run(ctx context.Context) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, multicastAddr)
+	if err != nil {
+		return fmt.Errorf("failed to join mDNS multicast group: %w", err)
+	}
+
+	r.mu.Lock()
+	r.conn = conn
+	r.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	r.logger.Info("mDNS responder started", "ip", r.ip.String(), "names", len(r.Names()))
+
+	// Announce whatever was registered before the socket was ready
+	if names := r.Names(); len(names) > 0 {
+		if err := r.send(conn, r.answer(names), multicastAddr); err != nil {
+			r.logger.Warn("Failed to announce mDNS names", "error", err)
+		}
+	}
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read mDNS packet: %w", err)
+		}
+
+		var query dns.Msg
+		if err := query.Unpack(buf[:n]); err != nil {
+			r.logger.Debug("Ignoring malformed mDNS packet", "source", src.String(), "error", err)
+			continue
+		}
+
+		resp, unicast := r.handleQuery(&query)
+		if resp == nil {
+			continue
+		}
+
+		dst := multicastAddr
+		if unicast {
+			dst = src
+		}
+		if err := r.send(conn, resp, dst); err != nil {
+			r.logger.Debug("Failed to send mDNS response", "destination", dst.String(), "error", err)
+		}
+	}
+}
+
+// handleQuery builds the response for an mDNS query. It returns nil when the
+// message is not a query or asks for nothing we advertise, and reports whether
+// every answered question requested a unicast reply.
+func (r *Responder) handleQuery(query *dns.Msg) (*dns.Msg, bool) {
+	if query.Response || query.Opcode != dns.OpcodeQuery {
+		return nil, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matched []string
+	unicast := true
+	for _, q := range query.Question {
+		if q.Qtype != dns.TypeA && q.Qtype != dns.TypeANY {
+			continue
+		}
+		name := strings.ToLower(q.Name)
+		if !r.names[name] {
+			continue
+		}
+		matched = append(matched, name)
+		if q.Qclass&unicastResponseBit == 0 {
+			unicast = false
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil, false
+	}
+
+	r.logger.Debug("Answering mDNS query", "names", matched, "unicast", unicast)
+	return r.answer(matched), unicast
+}
+
+// answer builds an unsolicited-style mDNS response with an A record per name.
+func (r *Responder) answer(names []string) *dns.Msg {
+	msg := &dns.Msg{}
+	msg.Response = true
+	msg.Authoritative = true
+
+	for _, name := range names {
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{
+				Name:   name,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET | cacheFlushBit,
+				Ttl:    DefaultTTL,
+			},
+			A: r.ip,
+		})
+	}
+	return msg
+}
+
+// send packs and writes msg to dst.
+func (r *Responder) send(conn *net.UDPConn, msg *dns.Msg, dst *net.UDPAddr) error {
+	packed, err := msg.Pack()
+	if err != nil {
+		return fmt.Errorf("failed to pack mDNS response: %w", err)
+	}
+	_, err = conn.WriteToUDP(packed, dst)
+	return err
+}
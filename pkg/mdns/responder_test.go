@@ -0,0 +1,109 @@
+package mdns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/sparkfabrik/http-proxy/pkg/logger"
+)
+
+func TestLocalAlias(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"app.loc", "app.local"},
+		{"api.app.loc", "api.app.local"},
+		{"APP.LOC.", "app.local"},
+		{"app.local", "app.local"},
+		{"*.app.loc", ""},
+		{"~^api\\.loc$", ""},
+		{"localhost", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := LocalAlias(tt.in); got != tt.want {
+			t.Errorf("LocalAlias(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func testResponder(names ...string) *Responder {
+	r := NewResponder(net.ParseIP("192.168.1.10"), logger.New("test"))
+	r.SetNames(names)
+	return r
+}
+
+func TestSetNamesNormalizes(t *testing.T) {
+	r := testResponder("App.local", "api.local.")
+	want := []string{"api.local.", "app.local."}
+	if got := r.Names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Names = %v, want %v", got, want)
+	}
+
+	r.SetNames([]string{"other.local"})
+	if got := r.Names(); !reflect.DeepEqual(got, []string{"other.local."}) {
+		t.Errorf("Names after replace = %v", got)
+	}
+}
+
+func TestHandleQueryAnswersRegisteredName(t *testing.T) {
+	r := testResponder("app.local")
+
+	query := new(dns.Msg)
+	query.SetQuestion("app.local.", dns.TypeA)
+
+	resp, unicast := r.handleQuery(query)
+	if resp == nil {
+		t.Fatal("expected a response")
+	}
+	if unicast {
+		t.Error("expected multicast response")
+	}
+	if len(resp.Answer) != 1 {
+		t.Fatalf("answer count = %d, want 1", len(resp.Answer))
+	}
+	a, ok := resp.Answer[0].(*dns.A)
+	if !ok {
+		t.Fatalf("answer is %T, want *dns.A", resp.Answer[0])
+	}
+	if !a.A.Equal(net.ParseIP("192.168.1.10")) {
+		t.Errorf("A = %s, want 192.168.1.10", a.A)
+	}
+	if a.Hdr.Class != dns.ClassINET|cacheFlushBit {
+		t.Errorf("class = %d, want cache-flush IN", a.Hdr.Class)
+	}
+}
+
+func TestHandleQueryUnicastBit(t *testing.T) {
+	r := testResponder("app.local")
+
+	query := new(dns.Msg)
+	query.Question = []dns.Question{{Name: "app.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET | unicastResponseBit}}
+
+	if _, unicast := r.handleQuery(query); !unicast {
+		t.Error("expected unicast response when QU bit is set")
+	}
+}
+
+func TestHandleQueryIgnores(t *testing.T) {
+	r := testResponder("app.local")
+
+	unknown := new(dns.Msg)
+	unknown.SetQuestion("other.local.", dns.TypeA)
+
+	aaaa := new(dns.Msg)
+	aaaa.SetQuestion("app.local.", dns.TypeAAAA)
+
+	response := new(dns.Msg)
+	response.SetQuestion("app.local.", dns.TypeA)
+	response.Response = true
+
+	for name, msg := range map[string]*dns.Msg{"unknown": unknown, "aaaa": aaaa, "response": response} {
+		if resp, _ := r.handleQuery(msg); resp != nil {
+			t.Errorf("%s: expected no response, got %v", name, resp)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Package metrics exposes service metrics in the Prometheus text format. It
+// covers the gauges and counters the proxy services need without pulling in
+// the Prometheus client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// contentType is the Prometheus text exposition format
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Registry holds metric families and renders them for scraping. It is safe
+// for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families []*Vec
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Gauge registers a gauge family with the given label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *Vec {
+	return r.register(name, help, "gauge", labels)
+}
+
+// Counter registers a counter family with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *Vec {
+	return r.register(name, help, "counter", labels)
+}
+
+func (r *Registry) register(name, help, kind string, labels []string) *Vec {
+	v := &Vec{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*series)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, v)
+	return v
+}
+
+// Vec is a metric family: one value per combination of label values.
+type Vec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+}
+
+// Set sets the value of the series identified by labelValues.
+func (v *Vec) Set(value float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.get(labelValues).value = value
+}
+
+// Add adds delta to the series identified by labelValues.
+func (v *Vec) Add(delta float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.get(labelValues).value += delta
+}
+
+// Inc adds one to the series identified by labelValues.
+func (v *Vec) Inc(labelValues ...string) {
+	v.Add(1, labelValues...)
+}
+
+// Value returns the value of a series, or 0 when it does not exist.
+func (v *Vec) Value(labelValues ...string) float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.series[seriesKey(labelValues)]; ok {
+		return s.value
+	}
+	return 0
+}
+
+// Delete removes a series, e.g. for a route that no longer exists.
+func (v *Vec) Delete(labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.series, seriesKey(labelValues))
+}
+
+// DeleteMatching removes every series whose first label value is first.
+func (v *Vec) DeleteMatching(first string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for key, s := range v.series {
+		if len(s.labelValues) > 0 && s.labelValues[0] == first {
+			delete(v.series, key)
+		}
+	}
+}
+
+// get returns the series for labelValues, creating it. The caller holds v.mu.
+func (v *Vec) get(labelValues []string) *series {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+
+	key := seriesKey(labelValues)
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+func seriesKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+// writeTo renders the family in the text format, series sorted by labels.
+func (v *Vec) writeTo(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.kind)
+
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := v.series[key]
+		w.WriteString(v.name)
+		if len(v.labels) > 0 {
+			w.WriteByte('{')
+			for i, label := range v.labels {
+				if i > 0 {
+					w.WriteByte(',')
+				}
+				fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabelValue(s.labelValues[i]))
+			}
+			w.WriteByte('}')
+		}
+		w.WriteByte(' ')
+		w.WriteString(formatValue(s.value))
+		w.WriteByte('\n')
+	}
+}
+
+// Write renders all registered families in registration order.
+func (r *Registry) Write(out io.Writer) error {
+	r.mu.Lock()
+	families := append([]*Vec(nil), r.families...)
+	r.mu.Unlock()
+
+	w := bufio.NewWriter(out)
+	for _, family := range families {
+		family.writeTo(w)
+	}
+	return w.Flush()
+}
+
+// Handler serves the registry for Prometheus scrapes.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		// A failed write means the scraper went away; there is no one to tell
+		_ = r.Write(w)
+	})
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string       { return helpEscaper.Replace(s) }
+func escapeLabelValue(s string) string { return labelEscaper.Replace(s) }
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	up := r.Gauge("route_up", "Whether the route answered.", "host")
+	total := r.Counter("probes_total", "Probes by result.", "host", "result")
+	plain := r.Gauge("build_info", "Constant 1.")
+
+	up.Set(1, "b.loc")
+	up.Set(0, `a"quoted\.loc`)
+	total.Inc("a.loc", "ok")
+	total.Add(2, "a.loc", "ok")
+	total.Inc("a.loc", "error")
+	plain.Set(1)
+
+	var out strings.Builder
+	if err := r.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP route_up Whether the route answered.
+# TYPE route_up gauge
+route_up{host="a\"quoted\\.loc"} 0
+route_up{host="b.loc"} 1
+# HELP probes_total Probes by result.
+# TYPE probes_total counter
+probes_total{host="a.loc",result="error"} 1
+probes_total{host="a.loc",result="ok"} 3
+# HELP build_info Constant 1.
+# TYPE build_info gauge
+build_info 1
+`
+	if out.String() != want {
+		t.Errorf("output mismatch\ngot:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	if got := total.Value("a.loc", "ok"); got != 3 {
+		t.Errorf("Value = %v, want 3", got)
+	}
+}
+
+func TestVecDelete(t *testing.T) {
+	r := NewRegistry()
+	total := r.Counter("probes_total", "Probes by result.", "host", "result")
+	total.Inc("a.loc", "ok")
+	total.Inc("a.loc", "error")
+	total.Inc("b.loc", "ok")
+
+	total.DeleteMatching("a.loc")
+	if total.Value("a.loc", "ok") != 0 || total.Value("b.loc", "ok") != 1 {
+		t.Error("DeleteMatching removed the wrong series")
+	}
+
+	total.Delete("b.loc", "ok")
+	if total.Value("b.loc", "ok") != 0 {
+		t.Error("Delete did not remove the series")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("route_up", "Whether the route answered.", "host").Set(1, "a.loc")
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), `route_up{host="a.loc"} 1`) {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+}
+
+func TestLabelCountMismatchPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+	NewRegistry().Gauge("route_up", "help", "host").Set(1)
+}
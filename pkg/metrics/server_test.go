@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeMux(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("up", "Constant 1.").Set(1)
+
+	rec := httptest.NewRecorder()
+	r.ServeMux().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), "up 1\n") {
+		t.Errorf("GET /metrics = %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestServe(t *testing.T) {
+	// A busy address is reported
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if err := Serve(context.Background(), listener.Addr().String(), NewRegistry().ServeMux()); err == nil {
+		t.Error("Serve() on a busy address returned nil")
+	}
+
+	// The server stops with its context
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Serve(ctx, "127.0.0.1:0", NewRegistry().ServeMux()) }()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve() = %v after cancel", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve() did not return after cancel")
+	}
+}
@@ -0,0 +1,188 @@
+// Package permissions checks that files and directories shared between the
+// proxy containers are usable by the current process. Mounted volumes and
+// rootless Docker commonly leave them with the wrong owner or mode; problems
+// are repaired where possible and otherwise reported with the chown/chmod
+// command that fixes them.
+package permissions
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Requirement describes what the process needs from a path.
+type Requirement struct {
+	// Writable requires the process to be able to write the path; for a
+	// directory, to create files in it
+	Writable bool
+
+	// Mode lists permission bits that must be set, e.g. 0644 so another
+	// container can read a file
+	Mode os.FileMode
+}
+
+// Problem is a path that does not meet its requirement.
+type Problem struct {
+	Path  string
+	Issue string
+
+	// Mode is the mode that fixes the problem, 0 when the mode is fine
+	Mode os.FileMode
+
+	// Chown is set when ownership must change to UID:GID
+	Chown    bool
+	UID, GID int
+}
+
+// Fix returns the shell command that repairs the problem, or "" when it
+// cannot be fixed by changing ownership or mode (e.g. a read-only mount).
+func (p Problem) Fix() string {
+	var cmds []string
+	if p.Chown {
+		cmds = append(cmds, fmt.Sprintf("chown %d:%d %s", p.UID, p.GID, p.Path))
+	}
+	if p.Mode != 0 {
+		cmds = append(cmds, fmt.Sprintf("chmod %04o %s", p.Mode, p.Path))
+	}
+	return strings.Join(cmds, " && ")
+}
+
+// Repair applies the fix in-process. It usually only succeeds when running
+// as root; otherwise the caller should report Fix.
+func Repair(p Problem) error {
+	if p.Chown {
+		if err := os.Lchown(p.Path, p.UID, p.GID); err != nil {
+			return fmt.Errorf("failed to change owner of %s: %w", p.Path, err)
+		}
+	}
+	if p.Mode != 0 {
+		if err := os.Chmod(p.Path, p.Mode); err != nil {
+			return fmt.Errorf("failed to change mode of %s: %w", p.Path, err)
+		}
+	}
+	return nil
+}
+
+// Check verifies that path exists, is readable and meets req.
+func Check(path string, req Requirement) ([]Problem, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	var problems []Problem
+	perm := info.Mode().Perm()
+
+	if missing := req.Mode &^ perm; missing != 0 {
+		problems = append(problems, Problem{
+			Path:  path,
+			Issue: fmt.Sprintf("mode %04o is missing %04o", perm, missing),
+			Mode:  perm | req.Mode,
+		})
+	}
+
+	if err := probeRead(path, info.IsDir()); err != nil {
+		problems = append(problems, accessProblem(path, "readable", perm|0444, err))
+	}
+
+	if req.Writable {
+		if err := probeWrite(path, info.IsDir()); err != nil {
+			problems = append(problems, accessProblem(path, "writable", 0, err))
+		}
+	}
+
+	return problems, nil
+}
+
+// CheckDir checks dir against dirReq and each regular file directly inside it
+// against fileReq.
+func CheckDir(dir string, dirReq, fileReq Requirement) ([]Problem, error) {
+	problems, err := Check(dir, dirReq)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		// Unreadable directories are already reported by Check
+		return problems, nil
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		fileProblems, err := Check(filepath.Join(dir, entry.Name()), fileReq)
+		if err != nil {
+			// The file may have been removed since the directory was listed
+			continue
+		}
+		problems = append(problems, fileProblems...)
+	}
+
+	return problems, nil
+}
+
+// accessProblem describes a failed read or write probe. Permission errors are
+// fixed by handing the path to the current user; mode is added to the fix
+// when non-zero.
+func accessProblem(path, access string, mode os.FileMode, err error) Problem {
+	if errors.Is(err, syscall.EROFS) {
+		return Problem{Path: path, Issue: fmt.Sprintf("not %s: read-only filesystem", access)}
+	}
+	if !errors.Is(err, fs.ErrPermission) {
+		return Problem{Path: path, Issue: fmt.Sprintf("not %s: %v", access, err)}
+	}
+
+	uid, gid := os.Getuid(), os.Getgid()
+	if uid == 0 {
+		// Root is denied by the mount or user namespace, not by ownership
+		return Problem{Path: path, Issue: fmt.Sprintf("not %s by root", access), Mode: mode}
+	}
+	return Problem{
+		Path:  path,
+		Issue: fmt.Sprintf("not %s by uid %d", access, uid),
+		Mode:  mode,
+		Chown: true,
+		UID:   uid,
+		GID:   gid,
+	}
+}
+
+// probeRead opens path for reading.
+func probeRead(path string, isDir bool) error {
+	if isDir {
+		_, err := os.ReadDir(path)
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// probeWrite opens a file for writing without modifying it, or creates and
+// removes a temporary file in a directory.
+func probeWrite(path string, isDir bool) error {
+	if !isDir {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+
+	f, err := os.CreateTemp(path, ".permissions-check-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
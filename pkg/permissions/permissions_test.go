@@ -0,0 +1,115 @@
+package permissions
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckMode(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.yaml")
+	if err := os.WriteFile(file, []byte("http: {}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := Check(file, Requirement{Mode: 0644})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 {
+		t.Fatalf("problems = %+v, want 1", problems)
+	}
+
+	p := problems[0]
+	if p.Mode != 0644 || p.Chown {
+		t.Errorf("unexpected problem: %+v", p)
+	}
+	if want := "chmod 0644 " + file; p.Fix() != want {
+		t.Errorf("Fix = %q, want %q", p.Fix(), want)
+	}
+
+	if err := Repair(p); err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	if problems, _ := Check(file, Requirement{Mode: 0644}); len(problems) != 0 {
+		t.Errorf("problems after repair: %+v", problems)
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	dir := t.TempDir()
+
+	problems, err := Check(dir, Requirement{Writable: true, Mode: 0755})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("unexpected problems: %+v", problems)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("write probe left files behind: %v", entries)
+	}
+}
+
+func TestCheckWritableDenied(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("root bypasses permission bits")
+	}
+
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0755) })
+
+	problems, err := Check(dir, Requirement{Writable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || !problems[0].Chown || problems[0].UID != os.Getuid() {
+		t.Errorf("expected a chown problem, got %+v", problems)
+	}
+}
+
+func TestCheckDir(t *testing.T) {
+	dir := t.TempDir()
+	for name, mode := range map[string]os.FileMode{"ok.yaml": 0644, "private.yaml": 0600} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, mode); err != nil {
+			t.Fatal(err)
+		}
+		// WriteFile is subject to umask, so set the mode explicitly
+		if err := os.Chmod(filepath.Join(dir, name), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "nested"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := CheckDir(dir, Requirement{Writable: true}, Requirement{Mode: 0644})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || filepath.Base(problems[0].Path) != "private.yaml" {
+		t.Errorf("problems = %+v, want only private.yaml", problems)
+	}
+}
+
+func TestCheckMissing(t *testing.T) {
+	if _, err := Check(filepath.Join(t.TempDir(), "missing"), Requirement{}); err == nil {
+		t.Error("expected an error for a missing path")
+	}
+}
+
+func TestFixCombined(t *testing.T) {
+	p := Problem{Path: "/traefik/dynamic", Mode: 0755, Chown: true, UID: 1000, GID: 1000}
+	if want := "chown 1000:1000 /traefik/dynamic && chmod 0755 /traefik/dynamic"; p.Fix() != want {
+		t.Errorf("Fix = %q, want %q", p.Fix(), want)
+	}
+	if (Problem{Path: "/x", Issue: "read-only"}).Fix() != "" {
+		t.Error("expected no fix for a read-only problem")
+	}
+}
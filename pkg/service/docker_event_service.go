@@ -5,19 +5,30 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
+	"github.com/sparkfabrik/http-proxy/pkg/config"
 	"github.com/sparkfabrik/http-proxy/pkg/logger"
 	"github.com/sparkfabrik/http-proxy/pkg/utils"
 )
 
 const (
 	// DefaultDockerTimeout is the default timeout for Docker operations
-	DefaultDockerTimeout = 30 * time.Second
+	DefaultDockerTimeout = utils.DefaultDockerCallTimeout
+
+	// DockerTimeoutEnv overrides the timeout of each Docker API call, as a
+	// duration ("10s"); "0" disables it. Handlers get it through
+	// DockerTimeoutReceiver
+	DockerTimeoutEnv = "HTTP_PROXY_DOCKER_TIMEOUT"
+	// OneShotEnv makes RunWithSignalHandling perform the initial scan and
+	// exit instead of following the Docker event stream
+	OneShotEnv = "HTTP_PROXY_ONE_SHOT"
 )
 
 // EventHandler defines the interface for processing Docker events
@@ -35,6 +46,35 @@ type EventHandler interface {
 	SetDependencies(client *client.Client, logger *logger.Logger)
 }
 
+// BackgroundRunner is implemented by handlers that need work running alongside
+// the event loop (listeners, periodic jobs). RunBackground must block until ctx
+// is cancelled; the service cancels it and waits for it to return on shutdown.
+type BackgroundRunner interface {
+	RunBackground(ctx context.Context)
+}
+
+// ActionSubscriber is implemented by handlers that react to container actions
+// besides start and die (kill, destroy, ...); the event stream is widened to
+// include them.
+type ActionSubscriber interface {
+	ContainerActions() []events.Action
+}
+
+// NetworkSubscriber is implemented by handlers that react to network events
+// (connect, disconnect); the event stream is widened to include them. They
+// reach HandleEvent like container events, ParseContainerEvent reporting the
+// container connected or disconnected.
+type NetworkSubscriber interface {
+	NetworkActions() []events.Action
+}
+
+// DockerTimeoutReceiver is implemented by handlers that bound their own
+// Docker calls; SetDockerTimeout gets the per-call timeout of DockerTimeoutEnv
+// along with the dependencies, zero when disabled.
+type DockerTimeoutReceiver interface {
+	SetDockerTimeout(timeout time.Duration)
+}
+
 // eventSubscriber subscribes to the Docker event stream. It matches the
 // signature of (*client.Client).Events and exists as a seam so the reconnect
 // behavior of the event loop can be tested without a Docker daemon.
@@ -48,6 +88,7 @@ type Service struct {
 	serviceName    string
 	subscribe      eventSubscriber
 	reconnectDelay time.Duration
+	oneShot        bool
 }
 
 // NewService creates a new Docker event-driven service
@@ -55,6 +96,16 @@ func NewService(ctx context.Context, serviceName string, logLevel string, handle
 	// Initialize logger
 	log := logger.NewWithLevel(serviceName, logger.LogLevel(logLevel))
 
+	timeout, err := dockerTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	once, err := oneShot()
+	if err != nil {
+		return nil, err
+	}
+
 	// Initialize Docker client
 	dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
@@ -62,7 +113,7 @@ func NewService(ctx context.Context, serviceName string, logLevel string, handle
 	}
 
 	// Test Docker connection with timeout
-	pingCtx, cancel := context.WithTimeout(ctx, DefaultDockerTimeout)
+	pingCtx, cancel := utils.WithDockerTimeout(ctx, timeout)
 	defer cancel()
 
 	if _, err := dockerClient.Ping(pingCtx); err != nil {
@@ -70,10 +121,10 @@ func NewService(ctx context.Context, serviceName string, logLevel string, handle
 		return nil, fmt.Errorf("failed to connect to Docker daemon: %w", err)
 	}
 
-	log.Debug("Successfully connected to Docker daemon")
+	log.Debug("Successfully connected to Docker daemon", "call_timeout", timeout)
 
 	// Inject dependencies into handler
-	handler.SetDependencies(dockerClient, log)
+	setDependencies(handler, dockerClient, log, timeout)
 
 	return &Service{
 		client:         dockerClient,
@@ -82,9 +133,39 @@ func NewService(ctx context.Context, serviceName string, logLevel string, handle
 		serviceName:    serviceName,
 		subscribe:      dockerClient.Events,
 		reconnectDelay: 5 * time.Second,
+		oneShot:        once,
 	}, nil
 }
 
+// setDependencies injects the Docker client, logger and, into handlers
+// implementing DockerTimeoutReceiver, the Docker call timeout.
+func setDependencies(handler EventHandler, dockerClient *client.Client, log *logger.Logger, timeout time.Duration) {
+	handler.SetDependencies(dockerClient, log)
+	if receiver, ok := handler.(DockerTimeoutReceiver); ok {
+		receiver.SetDockerTimeout(timeout)
+	}
+}
+
+// dockerTimeout returns the Docker API call timeout from DockerTimeoutEnv.
+func dockerTimeout() (time.Duration, error) {
+	value := config.GetEnvOrDefault(DockerTimeoutEnv, DefaultDockerTimeout.String())
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-negative duration", DockerTimeoutEnv, value)
+	}
+	return timeout, nil
+}
+
+// oneShot reports whether OneShotEnv asks for a single pass.
+func oneShot() (bool, error) {
+	value := config.GetEnvOrDefault(OneShotEnv, "false")
+	once, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: must be true or false", OneShotEnv, value)
+	}
+	return once, nil
+}
+
 // GetDockerClient returns the Docker client for use by handlers
 func (s *Service) GetDockerClient() *client.Client {
 	return s.client
@@ -104,19 +185,55 @@ func (s *Service) Close() error {
 // Signal handling and lifecycle are owned by RunWithSignalHandling.
 func (s *Service) Run(ctx context.Context) error {
 	s.logger.Info("Starting service", "name", s.serviceName)
+
+	if runner, ok := s.handler.(BackgroundRunner); ok {
+		bgCtx, cancel := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runner.RunBackground(bgCtx)
+		}()
+		defer func() {
+			cancel()
+			wg.Wait()
+		}()
+	}
+
 	return s.runEventLoop(ctx)
 }
 
+// RunOnce performs the initial scan and returns its result, without
+// background work or the event loop, for CI scripts and maintenance jobs
+// that need the state of the running containers applied once.
+func (s *Service) RunOnce(ctx context.Context) error {
+	s.logger.Info("Running service once", "name", s.serviceName)
+	if err := s.handler.HandleInitialScan(ctx); err != nil {
+		s.logger.Error("Initial scan failed", "error", err)
+		return err
+	}
+	return nil
+}
+
 // containerEventOptions returns the Docker event-stream filters for the
-// container start/die events the services react to.
-func containerEventOptions() events.ListOptions {
-	return events.ListOptions{
-		Filters: filters.NewArgs(
-			filters.Arg("type", "container"),
-			filters.Arg("event", "start"),
-			filters.Arg("event", "die"),
-		),
+// container start/die events the services react to, plus the extra container
+// actions and the network actions, if any.
+func containerEventOptions(extra, network []events.Action) events.ListOptions {
+	args := filters.NewArgs(
+		filters.Arg("type", "container"),
+		filters.Arg("event", "start"),
+		filters.Arg("event", "die"),
+	)
+	for _, action := range extra {
+		args.Add("event", string(action))
+	}
+	if len(network) > 0 {
+		args.Add("type", "network")
 	}
+	for _, action := range network {
+		args.Add("event", string(action))
+	}
+	return events.ListOptions{Filters: args}
 }
 
 // runEventLoop handles the initial scan and Docker event processing
@@ -129,7 +246,15 @@ func (s *Service) runEventLoop(ctx context.Context) error {
 	}
 
 	// Listen for Docker events
-	eventsChan, errChan := s.subscribe(ctx, containerEventOptions())
+	var extra, network []events.Action
+	if subscriber, ok := s.handler.(ActionSubscriber); ok {
+		extra = subscriber.ContainerActions()
+	}
+	if subscriber, ok := s.handler.(NetworkSubscriber); ok {
+		network = subscriber.NetworkActions()
+	}
+	options := containerEventOptions(extra, network)
+	eventsChan, errChan := s.subscribe(ctx, options)
 
 	for {
 		select {
@@ -142,7 +267,7 @@ func (s *Service) runEventLoop(ctx context.Context) error {
 				if !s.backoffBeforeReconnect(ctx) {
 					return nil
 				}
-				eventsChan, errChan = s.subscribe(ctx, containerEventOptions())
+				eventsChan, errChan = s.subscribe(ctx, options)
 				continue
 			}
 			s.processEventSafely(ctx, event)
@@ -151,7 +276,7 @@ func (s *Service) runEventLoop(ctx context.Context) error {
 				if !s.backoffBeforeReconnect(ctx) {
 					return nil
 				}
-				eventsChan, errChan = s.subscribe(ctx, containerEventOptions())
+				eventsChan, errChan = s.subscribe(ctx, options)
 				continue
 			}
 			if err != nil {
@@ -159,7 +284,7 @@ func (s *Service) runEventLoop(ctx context.Context) error {
 				if !s.backoffBeforeReconnect(ctx) {
 					return nil
 				}
-				eventsChan, errChan = s.subscribe(ctx, containerEventOptions())
+				eventsChan, errChan = s.subscribe(ctx, options)
 			}
 		}
 	}
@@ -187,15 +312,16 @@ func (s *Service) processEventSafely(ctx context.Context, event events.Message)
 	default:
 	}
 
+	parsed := ParseContainerEvent(event)
+	s.logger.Debug("Received container event", parsed.LogArgs()...)
+
 	if err := s.handler.HandleEvent(ctx, event); err != nil {
-		s.logger.Error("Failed to process event",
-			"error", err,
-			"action", event.Action,
-			"container_id", utils.FormatDockerID(event.Actor.ID))
+		s.logger.Error("Failed to process event", append([]interface{}{"error", err}, parsed.LogArgs()...)...)
 	}
 }
 
-// RunWithSignalHandling is a convenience function that sets up a complete service lifecycle
+// RunWithSignalHandling is a convenience function that sets up a complete service lifecycle.
+// With OneShotEnv set it runs the initial scan only and exits non-zero when it fails.
 func RunWithSignalHandling(ctx context.Context, serviceName string, logLevel string, handler EventHandler) error {
 	service, err := NewService(ctx, serviceName, logLevel, handler)
 	if err != nil {
@@ -214,6 +340,10 @@ func RunWithSignalHandling(ctx context.Context, serviceName string, logLevel str
 	// Start the service
 	errChan := make(chan error, 1)
 	go func() {
+		if service.oneShot {
+			errChan <- service.RunOnce(serviceCtx)
+			return
+		}
 		errChan <- service.Run(serviceCtx)
 	}()
 
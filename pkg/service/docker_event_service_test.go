@@ -128,3 +128,192 @@ func TestRunEventLoopReturnsInitialScanError(t *testing.T) {
 		t.Fatalf("runEventLoop error = %v, want %v", err, wantErr)
 	}
 }
+
+func TestRunOnceSkipsEventLoopAndBackgroundWork(t *testing.T) {
+	subscribe := func(context.Context, events.ListOptions) (<-chan events.Message, <-chan error) {
+		t.Error("subscribe should not be called in one-shot mode")
+		return nil, nil
+	}
+
+	h := &backgroundHandler{started: make(chan struct{}), stopped: make(chan struct{})}
+	if err := newTestService(h, subscribe).RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce error = %v", err)
+	}
+	select {
+	case <-h.started:
+		t.Error("background runner should not be started in one-shot mode")
+	default:
+	}
+
+	wantErr := errors.New("scan failed")
+	if err := newTestService(&fakeHandler{scanErr: wantErr}, subscribe).RunOnce(context.Background()); !errors.Is(err, wantErr) {
+		t.Fatalf("RunOnce error = %v, want %v", err, wantErr)
+	}
+}
+
+// backgroundHandler records the lifecycle of its background work.
+type backgroundHandler struct {
+	fakeHandler
+	started chan struct{}
+	stopped chan struct{}
+}
+
+func (b *backgroundHandler) RunBackground(ctx context.Context) {
+	close(b.started)
+	<-ctx.Done()
+	close(b.stopped)
+}
+
+func TestRunStartsAndStopsBackgroundRunner(t *testing.T) {
+	h := &backgroundHandler{started: make(chan struct{}), stopped: make(chan struct{})}
+	subscribe := func(context.Context, events.ListOptions) (<-chan events.Message, <-chan error) {
+		return make(chan events.Message), make(chan error)
+	}
+
+	s := newTestService(h, subscribe)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	waitSignal(t, h.started, "background runner was not started")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after context cancellation")
+	}
+
+	// Run must not return before the background work has finished.
+	select {
+	case <-h.stopped:
+	default:
+		t.Fatal("Run returned before the background runner stopped")
+	}
+}
+
+// actionHandler subscribes to extra container actions.
+type actionHandler struct {
+	fakeHandler
+}
+
+func (a *actionHandler) ContainerActions() []events.Action {
+	return []events.Action{events.ActionKill, events.ActionDestroy}
+}
+
+func TestRunEventLoopSubscribesToHandlerActions(t *testing.T) {
+	got := make(chan events.ListOptions, 1)
+	subscribe := func(_ context.Context, options events.ListOptions) (<-chan events.Message, <-chan error) {
+		got <- options
+		return make(chan events.Message), make(chan error)
+	}
+
+	s := newTestService(&actionHandler{}, subscribe)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.runEventLoop(ctx)
+
+	select {
+	case options := <-got:
+		for _, action := range []string{"start", "die", "kill", "destroy"} {
+			if !options.Filters.ExactMatch("event", action) {
+				t.Errorf("event filter lacks %q: %v", action, options.Filters.Get("event"))
+			}
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("event stream was not subscribed")
+	}
+}
+
+// networkHandler subscribes to network events too.
+type networkHandler struct {
+	fakeHandler
+}
+
+func (n *networkHandler) NetworkActions() []events.Action {
+	return []events.Action{events.ActionConnect, events.ActionDisconnect}
+}
+
+func TestContainerEventOptionsNetworkActions(t *testing.T) {
+	options := containerEventOptions(nil, nil)
+	if options.Filters.ExactMatch("type", "network") {
+		t.Error("network events subscribed without network actions")
+	}
+
+	options = containerEventOptions(nil, (&networkHandler{}).NetworkActions())
+	for _, typ := range []string{"container", "network"} {
+		if !options.Filters.ExactMatch("type", typ) {
+			t.Errorf("type filter lacks %q: %v", typ, options.Filters.Get("type"))
+		}
+	}
+	for _, action := range []string{"start", "die", "connect", "disconnect"} {
+		if !options.Filters.ExactMatch("event", action) {
+			t.Errorf("event filter lacks %q: %v", action, options.Filters.Get("event"))
+		}
+	}
+}
+
+func TestDockerTimeout(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", DefaultDockerTimeout, false},
+		{"10s", 10 * time.Second, false},
+		{"0", 0, false},
+		{"-1s", 0, true},
+		{"ten", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv(DockerTimeoutEnv, tt.value)
+			got, err := dockerTimeout()
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("dockerTimeout() = %v, %v, want %v (error %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+// timeoutHandler records the Docker call timeout it receives.
+type timeoutHandler struct {
+	fakeHandler
+	timeout time.Duration
+}
+
+func (h *timeoutHandler) SetDockerTimeout(timeout time.Duration) { h.timeout = timeout }
+
+func TestSetDependenciesPassesDockerTimeout(t *testing.T) {
+	h := &timeoutHandler{}
+	setDependencies(h, nil, logger.New("test"), 10*time.Second)
+	if h.timeout != 10*time.Second {
+		t.Errorf("handler timeout = %v, want 10s", h.timeout)
+	}
+
+	// Handlers without the method are left alone
+	setDependencies(&fakeHandler{}, nil, logger.New("test"), 10*time.Second)
+}
+
+func TestOneShot(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    bool
+		wantErr bool
+	}{
+		{"", false, false},
+		{"false", false, false},
+		{"true", true, false},
+		{"1", true, false},
+		{"always", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv(OneShotEnv, tt.value)
+			got, err := oneShot()
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("oneShot() = %v, %v, want %v (error %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/sparkfabrik/http-proxy/pkg/utils"
+)
+
+// Docker Compose labels, reported as event attributes alongside the others
+const (
+	ComposeProjectLabel = utils.ComposeProjectLabel
+	ComposeServiceLabel = utils.ComposeServiceLabel
+)
+
+// Attributes Docker sets on container events that are not container labels
+var containerEventAttributes = map[string]bool{
+	"name":     true,
+	"image":    true,
+	"exitCode": true,
+	"signal":   true,
+}
+
+// ContainerEvent is a Docker container event with its actor attributes
+// parsed, so handlers do not each re-implement attribute extraction.
+// ExitCode is only set on "die" events and Signal, the signal number Docker
+// reports, on "kill" events; Labels holds the container labels
+// (attributes other than the ones Docker adds itself). Network connect and
+// disconnect events are parsed as events of the container, with Network set
+// to the network name.
+type ContainerEvent struct {
+	Action         events.Action
+	ContainerID    string
+	Network        string
+	Name           string
+	Image          string
+	ComposeProject string
+	ComposeService string
+	ExitCode       *int
+	Signal         string
+	Labels         map[string]string
+	Time           time.Time
+}
+
+// ParseContainerEvent parses a container event. Missing attributes are left
+// empty; a malformed exit code is treated as missing.
+func ParseContainerEvent(event events.Message) ContainerEvent {
+	attrs := event.Actor.Attributes
+	if event.Type == events.NetworkEventType {
+		// The actor is the network, the container is one of its attributes
+		return ContainerEvent{
+			Action:      event.Action,
+			ContainerID: attrs["container"],
+			Network:     attrs["name"],
+			Labels:      make(map[string]string),
+			Time:        eventTime(event),
+		}
+	}
+
+	parsed := ContainerEvent{
+		Action:         event.Action,
+		ContainerID:    event.Actor.ID,
+		Name:           attrs["name"],
+		Image:          attrs["image"],
+		ComposeProject: utils.ComposeProject(attrs),
+		ComposeService: utils.ComposeService(attrs),
+		Signal:         attrs["signal"],
+		Labels:         make(map[string]string),
+		Time:           eventTime(event),
+	}
+
+	if code, err := strconv.Atoi(attrs["exitCode"]); err == nil {
+		parsed.ExitCode = &code
+	}
+
+	for key, value := range attrs {
+		if !containerEventAttributes[key] {
+			parsed.Labels[key] = value
+		}
+	}
+
+	return parsed
+}
+
+// eventTime returns when an event happened, zero when Docker did not say.
+func eventTime(event events.Message) time.Time {
+	if event.TimeNano != 0 {
+		return time.Unix(0, event.TimeNano)
+	}
+	if event.Time != 0 {
+		return time.Unix(event.Time, 0)
+	}
+	return time.Time{}
+}
+
+// LogArgs returns the event as structured logging key-value pairs, omitting
+// empty values, so every handler logs events the same way.
+func (e ContainerEvent) LogArgs() []interface{} {
+	args := []interface{}{
+		"action", string(e.Action),
+		"container_id", utils.FormatDockerID(e.ContainerID),
+	}
+	if e.Name != "" {
+		args = append(args, "container_name", e.Name)
+	}
+	if e.Network != "" {
+		args = append(args, "network", e.Network)
+	}
+	if e.Image != "" {
+		args = append(args, "image", e.Image)
+	}
+	if e.ComposeProject != "" {
+		args = append(args, "compose_project", e.ComposeProject)
+	}
+	if e.ComposeService != "" {
+		args = append(args, "compose_service", e.ComposeService)
+	}
+	if e.ExitCode != nil {
+		args = append(args, "exit_code", *e.ExitCode)
+	}
+	return args
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+)
+
+func TestParseContainerEvent(t *testing.T) {
+	event := events.Message{
+		Type:     events.ContainerEventType,
+		Action:   events.ActionDie,
+		TimeNano: 1700000000123456789,
+		Actor: events.Actor{
+			ID: "0123456789abcdef0123",
+			Attributes: map[string]string{
+				"name":              "shop-web-1",
+				"image":             "nginx:alpine",
+				"exitCode":          "137",
+				ComposeProjectLabel: "shop",
+				ComposeServiceLabel: "web",
+				"traefik.enable":    "true",
+			},
+		},
+	}
+
+	got := ParseContainerEvent(event)
+
+	if got.Name != "shop-web-1" || got.Image != "nginx:alpine" {
+		t.Errorf("name/image = %q/%q", got.Name, got.Image)
+	}
+	if got.ComposeProject != "shop" || got.ComposeService != "web" {
+		t.Errorf("compose = %q/%q", got.ComposeProject, got.ComposeService)
+	}
+	if got.ExitCode == nil || *got.ExitCode != 137 {
+		t.Errorf("exit code = %v, want 137", got.ExitCode)
+	}
+	if !got.Time.Equal(time.Unix(0, 1700000000123456789)) {
+		t.Errorf("time = %v", got.Time)
+	}
+	wantLabels := map[string]string{ComposeProjectLabel: "shop", ComposeServiceLabel: "web", "traefik.enable": "true"}
+	if !reflect.DeepEqual(got.Labels, wantLabels) {
+		t.Errorf("labels = %v, want %v", got.Labels, wantLabels)
+	}
+
+	wantArgs := []interface{}{
+		"action", "die",
+		"container_id", "0123456789ab",
+		"container_name", "shop-web-1",
+		"image", "nginx:alpine",
+		"compose_project", "shop",
+		"compose_service", "web",
+		"exit_code", 137,
+	}
+	if args := got.LogArgs(); !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("LogArgs = %v, want %v", args, wantArgs)
+	}
+}
+
+func TestParseContainerEventSignal(t *testing.T) {
+	got := ParseContainerEvent(events.Message{
+		Type:   events.ContainerEventType,
+		Action: events.ActionKill,
+		Actor:  events.Actor{ID: "abc", Attributes: map[string]string{"name": "web", "signal": "15"}},
+	})
+	if got.Signal != "15" || len(got.Labels) != 0 {
+		t.Errorf("signal = %q, labels = %v; want 15 and no labels", got.Signal, got.Labels)
+	}
+}
+
+func TestParseContainerEventMinimal(t *testing.T) {
+	got := ParseContainerEvent(events.Message{
+		Action: events.ActionStart,
+		Time:   1700000000,
+		Actor:  events.Actor{ID: "abc", Attributes: map[string]string{"exitCode": "not-a-number"}},
+	})
+
+	if got.ExitCode != nil {
+		t.Errorf("malformed exit code parsed as %d", *got.ExitCode)
+	}
+	if !got.Time.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("time = %v", got.Time)
+	}
+	if len(got.Labels) != 0 {
+		t.Errorf("labels = %v", got.Labels)
+	}
+	if args := got.LogArgs(); len(args) != 4 {
+		t.Errorf("LogArgs = %v, want only action and container_id", args)
+	}
+}
+
+func TestParseContainerEventNetwork(t *testing.T) {
+	got := ParseContainerEvent(events.Message{
+		Type:   events.NetworkEventType,
+		Action: events.ActionConnect,
+		Actor: events.Actor{ID: "fedcba9876543210fedc", Attributes: map[string]string{
+			"container": "0123456789abcdef0123",
+			"name":      "shop_default",
+			"type":      "bridge",
+		}},
+	})
+
+	if got.ContainerID != "0123456789abcdef0123" || got.Network != "shop_default" {
+		t.Errorf("container/network = %q/%q", got.ContainerID, got.Network)
+	}
+	if got.Name != "" || len(got.Labels) != 0 {
+		t.Errorf("network attributes parsed as container ones: name %q, labels %v", got.Name, got.Labels)
+	}
+	wantArgs := []interface{}{"action", "connect", "container_id", "0123456789ab", "network", "shop_default"}
+	if args := got.LogArgs(); !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("LogArgs = %v, want %v", args, wantArgs)
+	}
+}
@@ -0,0 +1,70 @@
+// Package state stores JSON snapshots the proxy services share through a
+// common volume, so other services and scripts can observe what a service
+// did without talking to it directly.
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DefaultDir is where the shared state volume is mounted
+const DefaultDir = "/var/lib/http-proxy"
+
+// filePermissions keeps snapshots readable by other containers and users
+const filePermissions = 0644
+
+// Store reads and writes named snapshots in a directory.
+type Store struct {
+	dir string
+}
+
+// NewStore returns a store rooted at dir. The directory is created on the
+// first write.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Path returns the file backing the named snapshot.
+func (s *Store) Path(name string) string {
+	return filepath.Join(s.dir, name+".json")
+}
+
+// Write replaces the named snapshot atomically, so readers never observe a
+// partially written file.
+func (s *Store) Write(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state %s: %w", name, err)
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	path := s.Path(name)
+	tmpFile := path + ".tmp"
+	if err := os.WriteFile(tmpFile, append(data, '\n'), filePermissions); err != nil {
+		return fmt.Errorf("failed to write state %s: %w", name, err)
+	}
+	if err := os.Rename(tmpFile, path); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to rename state %s: %w", name, err)
+	}
+	return nil
+}
+
+// Read decodes the named snapshot into v. The returned error wraps
+// os.ErrNotExist when the snapshot has not been written yet.
+func (s *Store) Read(name string, v interface{}) error {
+	data, err := os.ReadFile(s.Path(name))
+	if err != nil {
+		return fmt.Errorf("failed to read state %s: %w", name, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse state %s: %w", name, err)
+	}
+	return nil
+}
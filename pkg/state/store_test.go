@@ -0,0 +1,43 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type snapshot struct {
+	Generation int      `json:"generation"`
+	Networks   []string `json:"networks"`
+}
+
+func TestStoreWriteRead(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "state"))
+
+	want := snapshot{Generation: 3, Networks: []string{"app_default"}}
+	if err := store.Write("join-networks", want); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	var got snapshot
+	if err := store.Read("join-networks", &got); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if got.Generation != 3 || len(got.Networks) != 1 || got.Networks[0] != "app_default" {
+		t.Errorf("Read = %+v, want %+v", got, want)
+	}
+
+	if _, err := os.Stat(store.Path("join-networks") + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temporary file left behind")
+	}
+}
+
+func TestStoreReadMissing(t *testing.T) {
+	store := NewStore(t.TempDir())
+
+	var got snapshot
+	if err := store.Read("missing", &got); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Read error = %v, want os.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Docker Compose labels naming the project, service and replica number of
+// the containers it creates
+const (
+	ComposeProjectLabel         = "com.docker.compose.project"
+	ComposeServiceLabel         = "com.docker.compose.service"
+	ComposeContainerNumberLabel = "com.docker.compose.container-number"
+)
+
+// ComposeRef is where a container belongs in a compose project, read from
+// its labels. Number is the replica number, 0 when unknown.
+type ComposeRef struct {
+	Project string
+	Service string
+	Number  int
+}
+
+// ParseComposeLabels reads the compose labels of a container. Containers not
+// created by compose get a zero ComposeRef.
+func ParseComposeLabels(labels map[string]string) ComposeRef {
+	return ComposeRef{
+		Project: ComposeProject(labels),
+		Service: ComposeService(labels),
+		Number:  ComposeContainerNumber(labels),
+	}
+}
+
+// IsService reports whether the reference names a compose service.
+func (r ComposeRef) IsService() bool {
+	return r.Project != "" && r.Service != ""
+}
+
+// String returns "project/service", with "-number" for a numbered replica,
+// the project alone without a service, or "" for containers not created by
+// compose.
+func (r ComposeRef) String() string {
+	if r.Service == "" {
+		return r.Project
+	}
+	s := r.Project + "/" + r.Service
+	if r.Number > 0 {
+		s += "-" + strconv.Itoa(r.Number)
+	}
+	return s
+}
+
+// ComposeProject returns the normalized compose project of a container, or "".
+func ComposeProject(labels map[string]string) string {
+	return NormalizeComposeProject(labels[ComposeProjectLabel])
+}
+
+// ComposeService returns the compose service of a container, or "".
+func ComposeService(labels map[string]string) string {
+	return strings.TrimSpace(labels[ComposeServiceLabel])
+}
+
+// ComposeContainerNumber returns the replica number of a compose container,
+// or 0 when it is missing or invalid.
+func ComposeContainerNumber(labels map[string]string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(labels[ComposeContainerNumberLabel]))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// NormalizeComposeProject normalizes a project name the way compose does
+// before labelling containers: lowercase, only letters, digits, dashes and
+// underscores, starting with a letter or digit. User-supplied names compare
+// equal to labels once normalized.
+func NormalizeComposeProject(name string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			sb.WriteRune(r)
+		}
+	}
+	return strings.TrimLeft(sb.String(), "-_")
+}
@@ -0,0 +1,64 @@
+package utils
+
+import "testing"
+
+func TestParseComposeLabels(t *testing.T) {
+	tests := []struct {
+		name       string
+		labels     map[string]string
+		want       ComposeRef
+		wantString string
+	}{
+		{name: "not compose", labels: nil},
+		{
+			name:       "replica",
+			labels:     map[string]string{ComposeProjectLabel: "shop", ComposeServiceLabel: "web", ComposeContainerNumberLabel: "2"},
+			want:       ComposeRef{Project: "shop", Service: "web", Number: 2},
+			wantString: "shop/web-2",
+		},
+		{
+			name:       "untrimmed, invalid number",
+			labels:     map[string]string{ComposeProjectLabel: " Shop ", ComposeServiceLabel: " web ", ComposeContainerNumberLabel: "x"},
+			want:       ComposeRef{Project: "shop", Service: "web"},
+			wantString: "shop/web",
+		},
+		{
+			name:       "negative number",
+			labels:     map[string]string{ComposeProjectLabel: "shop", ComposeContainerNumberLabel: "-1"},
+			want:       ComposeRef{Project: "shop"},
+			wantString: "shop",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseComposeLabels(tt.labels)
+			if got != tt.want {
+				t.Errorf("ParseComposeLabels() = %+v, want %+v", got, tt.want)
+			}
+			if got.String() != tt.wantString {
+				t.Errorf("String() = %q, want %q", got.String(), tt.wantString)
+			}
+			if got.IsService() != (tt.want.Project != "" && tt.want.Service != "") {
+				t.Errorf("IsService() = %v", got.IsService())
+			}
+		})
+	}
+}
+
+func TestNormalizeComposeProject(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"shop", "shop"},
+		{"My Shop", "myshop"},
+		{"_shop.v2", "shopv2"},
+		{"--Shop_Front", "shop_front"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeComposeProject(tt.in); got != tt.want {
+			t.Errorf("NormalizeComposeProject(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// hostnamePattern matches lowercase hostnames
+var hostnamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+// NormalizeHostname lowercases a hostname and drops its trailing dot.
+func NormalizeHostname(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// ValidHostname reports whether a normalized name is a hostname, optionally
+// with a leading "*." wildcard label.
+func ValidHostname(name string) bool {
+	return len(name) <= 253 && hostnamePattern.MatchString(strings.TrimPrefix(name, "*."))
+}
+
+// ContainerHostnames collects the hostnames of VIRTUAL_HOST (without ports)
+// and of the Host() matchers of Traefik router rules, normalized and without
+// duplicates. Wildcard hosts ("*.") are kept, regex hosts and invalid names
+// skipped.
+func ContainerHostnames(virtualHost string, labels map[string]string) []string {
+	var candidates []string
+	for _, entry := range strings.Split(virtualHost, ",") {
+		host, _, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		candidates = append(candidates, host)
+	}
+	labelHosts, _ := TraefikRouterHosts(labels)
+	candidates = append(candidates, labelHosts...)
+
+	seen := make(map[string]bool)
+	var hostnames []string
+	for _, host := range candidates {
+		host = NormalizeHostname(host)
+		if host == "" || seen[host] || !ValidHostname(host) {
+			continue
+		}
+		seen[host] = true
+		hostnames = append(hostnames, host)
+	}
+	return hostnames
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContainerHostnames(t *testing.T) {
+	tests := []struct {
+		name        string
+		virtualHost string
+		labels      map[string]string
+		want        []string
+	}{
+		{"virtual host with port", "App.example.com:8080, api.loc", nil, []string{"app.example.com", "api.loc"}},
+		{"wildcard kept, regex skipped", "*.app.loc,~^api\\..*", nil, []string{"*.app.loc"}},
+		{
+			"traefik rules",
+			"",
+			map[string]string{
+				"traefik.http.routers.api.rule": "Host(`API.app.loc`) || HostRegexp(`^.+\\.app\\.loc$`)",
+			},
+			[]string{"api.app.loc"},
+		},
+		{
+			"duplicates across sources",
+			"app.test",
+			map[string]string{"traefik.http.routers.app.rule": "Host(`APP.test.`)"},
+			[]string{"app.test"},
+		},
+		{"invalid names skipped", "bad..name,bad_name!.loc", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ContainerHostnames(tt.virtualHost, tt.labels); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ContainerHostnames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidHostname(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"app.loc", true},
+		{"*.spark.dev", true},
+		{"loc", true},
+		{"a.*.loc", false},
+		{"-app.loc", false},
+		{"bad_name.loc", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := ValidHostname(tt.name); got != tt.want {
+			t.Errorf("ValidHostname(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
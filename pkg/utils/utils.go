@@ -3,6 +3,8 @@ package utils
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -22,15 +24,32 @@ type RetryConfig struct {
 	MaxDelay time.Duration
 	// BackoffMultiplier is the factor by which the delay increases after each retry
 	BackoffMultiplier float64
+	// AttemptTimeout bounds each attempt; zero leaves attempts unbounded
+	AttemptTimeout time.Duration
 }
 
-// DefaultRetryConfig returns a sensible default retry configuration for Docker operations
-func DefaultRetryConfig() RetryConfig {
+// DefaultDockerCallTimeout is the default deadline of a single Docker API call
+const DefaultDockerCallTimeout = 30 * time.Second
+
+// WithDockerTimeout returns a context bounded by timeout, for Docker calls
+// made without the Retry helpers, so a stalled daemon cannot hang an event
+// loop. Zero or a negative timeout leaves the call unbounded.
+func WithDockerTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// DefaultRetryConfig returns a sensible default retry configuration for Docker
+// operations, each attempt bounded by timeout (zero leaves them unbounded).
+func DefaultRetryConfig(timeout time.Duration) RetryConfig {
 	return RetryConfig{
 		MaxAttempts:       3,
 		InitialDelay:      100 * time.Millisecond,
 		MaxDelay:          2 * time.Second,
 		BackoffMultiplier: 2.0,
+		AttemptTimeout:    max(timeout, 0),
 	}
 }
 
@@ -54,7 +73,7 @@ func Retry(ctx context.Context, config RetryConfig, fn RetryableFunc) error {
 			return err
 		}
 
-		lastErr = fn(ctx)
+		lastErr = runAttempt(ctx, config.AttemptTimeout, fn)
 		if lastErr == nil {
 			return nil // Success
 		}
@@ -82,11 +101,21 @@ func Retry(ctx context.Context, config RetryConfig, fn RetryableFunc) error {
 	return fmt.Errorf("operation failed after %d attempts: %w", config.MaxAttempts, lastErr)
 }
 
-// RetryContainerInspect wraps ContainerInspect with retry logic
-func RetryContainerInspect(ctx context.Context, dockerClient *client.Client, containerID string) (types.ContainerJSON, error) {
+// runAttempt runs fn, bounded by timeout when it is positive.
+func runAttempt(ctx context.Context, timeout time.Duration, fn RetryableFunc) error {
+	if timeout <= 0 {
+		return fn(ctx)
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return fn(attemptCtx)
+}
+
+// RetryContainerInspect wraps ContainerInspect with retry logic, each attempt bounded by timeout
+func RetryContainerInspect(ctx context.Context, dockerClient *client.Client, timeout time.Duration, containerID string) (types.ContainerJSON, error) {
 	var result types.ContainerJSON
 
-	err := Retry(ctx, DefaultRetryConfig(), func(ctx context.Context) error {
+	err := Retry(ctx, DefaultRetryConfig(timeout), func(ctx context.Context) error {
 		var err error
 		result, err = dockerClient.ContainerInspect(ctx, containerID)
 		return err
@@ -95,11 +124,11 @@ func RetryContainerInspect(ctx context.Context, dockerClient *client.Client, con
 	return result, err
 }
 
-// RetryContainerList wraps ContainerList with retry logic
-func RetryContainerList(ctx context.Context, dockerClient *client.Client, options container.ListOptions) ([]types.Container, error) {
+// RetryContainerList wraps ContainerList with retry logic, each attempt bounded by timeout
+func RetryContainerList(ctx context.Context, dockerClient *client.Client, timeout time.Duration, options container.ListOptions) ([]types.Container, error) {
 	var result []types.Container
 
-	err := Retry(ctx, DefaultRetryConfig(), func(ctx context.Context) error {
+	err := Retry(ctx, DefaultRetryConfig(timeout), func(ctx context.Context) error {
 		var err error
 		result, err = dockerClient.ContainerList(ctx, options)
 		return err
@@ -108,18 +137,18 @@ func RetryContainerList(ctx context.Context, dockerClient *client.Client, option
 	return result, err
 }
 
-// RetryNetworkConnect wraps NetworkConnect with retry logic
-func RetryNetworkConnect(ctx context.Context, dockerClient *client.Client, networkID, containerName string, config *network.EndpointSettings) error {
-	return Retry(ctx, DefaultRetryConfig(), func(ctx context.Context) error {
+// RetryNetworkConnect wraps NetworkConnect with retry logic, each attempt bounded by timeout
+func RetryNetworkConnect(ctx context.Context, dockerClient *client.Client, timeout time.Duration, networkID, containerName string, config *network.EndpointSettings) error {
+	return Retry(ctx, DefaultRetryConfig(timeout), func(ctx context.Context) error {
 		return dockerClient.NetworkConnect(ctx, networkID, containerName, config)
 	})
 }
 
-// RetryNetworkInspect wraps NetworkInspect with retry logic
-func RetryNetworkInspect(ctx context.Context, dockerClient *client.Client, networkID string, options network.InspectOptions) (network.Inspect, error) {
+// RetryNetworkInspect wraps NetworkInspect with retry logic, each attempt bounded by timeout
+func RetryNetworkInspect(ctx context.Context, dockerClient *client.Client, timeout time.Duration, networkID string, options network.InspectOptions) (network.Inspect, error) {
 	var result network.Inspect
 
-	err := Retry(ctx, DefaultRetryConfig(), func(ctx context.Context) error {
+	err := Retry(ctx, DefaultRetryConfig(timeout), func(ctx context.Context) error {
 		var err error
 		result, err = dockerClient.NetworkInspect(ctx, networkID, options)
 		return err
@@ -128,6 +157,19 @@ func RetryNetworkInspect(ctx context.Context, dockerClient *client.Client, netwo
 	return result, err
 }
 
+// RetryNetworkList wraps NetworkList with retry logic, each attempt bounded by timeout
+func RetryNetworkList(ctx context.Context, dockerClient *client.Client, timeout time.Duration, options network.ListOptions) ([]network.Summary, error) {
+	var result []network.Summary
+
+	err := Retry(ctx, DefaultRetryConfig(timeout), func(ctx context.Context) error {
+		var err error
+		result, err = dockerClient.NetworkList(ctx, options)
+		return err
+	})
+
+	return result, err
+}
+
 // FormatDockerID returns a shortened version of a Docker ID for logging
 // This can be used for container IDs, network IDs, or any Docker resource ID
 func FormatDockerID(id string) string {
@@ -186,12 +228,50 @@ func HasTraefikLabel(labels map[string]string) bool {
 	return false
 }
 
+var (
+	// hostMatcherPattern matches the Host() and HostRegexp() matchers of a
+	// Traefik rule; values are quoted, so they may contain parentheses
+	hostMatcherPattern = regexp.MustCompile("\\b(Host|HostRegexp)\\(((?:\\s*(?:`[^`]*`|\"[^\"]*\")\\s*,?)+)\\)")
+
+	// matcherValuePattern matches the quoted values of a matcher
+	matcherValuePattern = regexp.MustCompile("`([^`]*)`|\"([^\"]*)\"")
+)
+
+// TraefikRouterHosts returns the values of the Host() matchers (hosts) and of
+// the HostRegexp() matchers (regexps) in the traefik.http.routers.*.rule
+// labels, ordered by router name.
+func TraefikRouterHosts(labels map[string]string) (hosts, regexps []string) {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		if strings.HasPrefix(key, "traefik.http.routers.") && strings.HasSuffix(key, ".rule") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, matcher := range hostMatcherPattern.FindAllStringSubmatch(labels[key], -1) {
+			for _, value := range matcherValuePattern.FindAllStringSubmatch(matcher[2], -1) {
+				v := value[1] + value[2]
+				if matcher[1] == "Host" {
+					hosts = append(hosts, v)
+				} else {
+					regexps = append(regexps, v)
+				}
+			}
+		}
+	}
+	return hosts, regexps
+}
+
 // ShouldManageContainer checks if a container should be managed based on dinghy env vars or traefik labels
-// Returns true if the container has VIRTUAL_HOST environment variable or traefik labels
+// Returns true if the container has a VIRTUAL_HOST, VIRTUAL_TCP_PORT or VIRTUAL_UDP_PORT environment variable or traefik labels
 func ShouldManageContainer(env []string, labels map[string]string) bool {
-	// Check for dinghy VIRTUAL_HOST environment variable
-	if GetDockerEnvVar(env, "VIRTUAL_HOST") != "" {
-		return true
+	// Check for dinghy environment variables
+	for _, name := range []string{"VIRTUAL_HOST", "VIRTUAL_TCP_PORT", "VIRTUAL_UDP_PORT"} {
+		if GetDockerEnvVar(env, name) != "" {
+			return true
+		}
 	}
 
 	return HasTraefikLabel(labels)
@@ -199,10 +279,9 @@ func ShouldManageContainer(env []string, labels map[string]string) bool {
 
 // HasManageableContainersInNetwork checks if a network has any manageable containers,
 // optionally excluding a specific container
-func HasManageableContainersInNetwork(ctx context.Context, dockerClient *client.Client, networkID, excludeContainerName string) (bool, error) {
+func HasManageableContainersInNetwork(ctx context.Context, dockerClient *client.Client, timeout time.Duration, networkID, excludeContainerName string) (bool, error) {
 	// Inspect the network to get the container map
-	networkResource, err := dockerClient.NetworkInspect(ctx, networkID,
-		network.InspectOptions{})
+	networkResource, err := RetryNetworkInspect(ctx, dockerClient, timeout, networkID, network.InspectOptions{})
 	if err != nil {
 		return false, fmt.Errorf("failed to inspect network: %w", err)
 	}
@@ -217,7 +296,7 @@ func HasManageableContainersInNetwork(ctx context.Context, dockerClient *client.
 		}
 
 		// Inspect the container to get its details
-		inspect, err := RetryContainerInspect(ctx, dockerClient, containerID)
+		inspect, err := RetryContainerInspect(ctx, dockerClient, timeout, containerID)
 		if err != nil {
 			continue // Skip containers we can't inspect
 		}
@@ -234,3 +313,39 @@ func HasManageableContainersInNetwork(ctx context.Context, dockerClient *client.
 
 	return false, nil
 }
+
+// maxNetworkNamespaceDepth bounds how many network_mode "container:" links
+// are followed, guarding against cycles
+const maxNetworkNamespaceDepth = 8
+
+// NetworkNamespaceParent returns the container whose network namespace a
+// container shares (network_mode "container:<id>", which Compose uses for
+// "service:<name>"), or "" when it has its own.
+func NetworkNamespaceParent(hostConfig *container.HostConfig) string {
+	if hostConfig == nil || !hostConfig.NetworkMode.IsContainer() {
+		return ""
+	}
+	return hostConfig.NetworkMode.ConnectedContainer()
+}
+
+// ResolveNetworkNamespaceOwner returns the container owning a container's
+// network namespace, following network_mode "container:" links. Containers
+// sharing a namespace have no network endpoints of their own, so their
+// networks and addresses are the owner's. A container with its own namespace
+// is returned unchanged.
+func ResolveNetworkNamespaceOwner(ctx context.Context, dockerClient *client.Client, timeout time.Duration, inspect types.ContainerJSON) (types.ContainerJSON, error) {
+	owner := inspect
+	for range maxNetworkNamespaceDepth {
+		parent := NetworkNamespaceParent(owner.HostConfig)
+		if parent == "" {
+			return owner, nil
+		}
+
+		next, err := RetryContainerInspect(ctx, dockerClient, timeout, parent)
+		if err != nil {
+			return inspect, fmt.Errorf("failed to inspect network namespace owner %s: %w", FormatDockerID(parent), err)
+		}
+		owner = next
+	}
+	return inspect, fmt.Errorf("network_mode container chain of %s is longer than %d", FormatDockerID(inspect.ID), maxNetworkNamespaceDepth)
+}
@@ -1,6 +1,14 @@
 package utils
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
 
 func TestGetDockerEnvVar(t *testing.T) {
 	env := []string{"FOO=bar", "VIRTUAL_HOST=app.loc", "EMPTY="}
@@ -40,6 +48,24 @@ func TestHasTraefikLabel(t *testing.T) {
 	}
 }
 
+func TestTraefikRouterHosts(t *testing.T) {
+	labels := map[string]string{
+		"traefik.http.routers.web.rule":     "Host(`web.loc`, `www.web.loc`) && PathPrefix(`/app`)",
+		"traefik.http.routers.api.rule":     "Host(\"api.loc\") || HostRegexp(`^(v1|v2)\\.api\\.loc$`)",
+		"traefik.http.routers.api.service":  "api",
+		"traefik.tcp.routers.db.rule":       "HostSNI(`db.loc`)",
+		"traefik.http.routers.broken.rule":  "Host(web.loc)",
+		"traefik.http.routers.headers.rule": "Header(`X-Host`, `x.loc`)",
+	}
+	hosts, regexps := TraefikRouterHosts(labels)
+	if want := []string{"api.loc", "web.loc", "www.web.loc"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("hosts = %v, want %v", hosts, want)
+	}
+	if want := []string{`^(v1|v2)\.api\.loc$`}; !reflect.DeepEqual(regexps, want) {
+		t.Errorf("regexps = %v, want %v", regexps, want)
+	}
+}
+
 func TestShouldManageContainer(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -49,6 +75,8 @@ func TestShouldManageContainer(t *testing.T) {
 	}{
 		{"neither", []string{"FOO=bar"}, nil, false},
 		{"virtual host", []string{"VIRTUAL_HOST=app.loc"}, nil, true},
+		{"tcp port", []string{"VIRTUAL_TCP_PORT=5432"}, nil, true},
+		{"udp port", []string{"VIRTUAL_UDP_PORT=dns:53"}, nil, true},
 		{"traefik label", nil, map[string]string{"traefik.enable": "true"}, true},
 		{"both", []string{"VIRTUAL_HOST=app.loc"}, map[string]string{"traefik.enable": "true"}, true},
 	}
@@ -90,3 +118,70 @@ func TestValidateLogLevel(t *testing.T) {
 		}
 	}
 }
+
+func TestNetworkNamespaceParent(t *testing.T) {
+	tests := []struct {
+		name       string
+		hostConfig *container.HostConfig
+		want       string
+	}{
+		{"no host config", nil, ""},
+		{"default", &container.HostConfig{NetworkMode: "default"}, ""},
+		{"bridge", &container.HostConfig{NetworkMode: "bridge"}, ""},
+		{"host", &container.HostConfig{NetworkMode: "host"}, ""},
+		{"container", &container.HostConfig{NetworkMode: "container:3f2a9c"}, "3f2a9c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NetworkNamespaceParent(tt.hostConfig); got != tt.want {
+				t.Errorf("NetworkNamespaceParent() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAttemptTimeout(t *testing.T) {
+	config := RetryConfig{MaxAttempts: 2, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond, AttemptTimeout: 10 * time.Millisecond}
+
+	// A hanging attempt is cut at its deadline and retried with a fresh one
+	attempts := 0
+	err := Retry(context.Background(), config, func(ctx context.Context) error {
+		attempts++
+		if attempts == 1 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("Retry() = %v after %d attempts, want success after 2", err, attempts)
+	}
+
+	err = Retry(context.Background(), config, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Retry() = %v, want deadline exceeded", err)
+	}
+}
+
+func TestWithDockerTimeout(t *testing.T) {
+	if got := DefaultRetryConfig(5 * time.Second).AttemptTimeout; got != 5*time.Second {
+		t.Errorf("AttemptTimeout = %v, want 5s", got)
+	}
+	ctx, cancel := WithDockerTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		t.Error("WithDockerTimeout() context has no deadline")
+	}
+
+	if got := DefaultRetryConfig(-time.Second).AttemptTimeout; got != 0 {
+		t.Errorf("AttemptTimeout = %v, want 0 for a negative timeout", got)
+	}
+	ctx, cancel = WithDockerTimeout(context.Background(), 0)
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("WithDockerTimeout() set a deadline with the timeout disabled")
+	}
+}